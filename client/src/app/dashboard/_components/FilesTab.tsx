@@ -268,22 +268,36 @@ export const FilesTab = ({
         return [];
       }
 
-      const response = await fetch(`${API_BASE_URL}/api/v1/pieces`, {
-        headers: {
-          Authorization: `Bearer ${token}`,
-        },
-      });
+      // The list is paged; follow the cursor until every piece is loaded.
+      const data: Piece[] = [];
+      let cursor = "";
+      do {
+        const params = new URLSearchParams({ pageSize: "200" });
+        if (cursor) {
+          params.set("after", cursor);
+        }
+        const response = await fetch(
+          `${API_BASE_URL}/api/v1/pieces?${params.toString()}`,
+          {
+            headers: {
+              Authorization: `Bearer ${token}`,
+            },
+          }
+        );
 
-      if (response.status === 401) {
-        setAuthError("Your session has expired. Please login again.");
-        return [];
-      }
+        if (response.status === 401) {
+          setAuthError("Your session has expired. Please login again.");
+          return [];
+        }
 
-      if (!response.ok) {
-        throw new Error(`Failed to fetch pieces: ${response.statusText}`);
-      }
+        if (!response.ok) {
+          throw new Error(`Failed to fetch pieces: ${response.statusText}`);
+        }
 
-      const data = await response.json();
+        const page = await response.json();
+        data.push(...page.pieces);
+        cursor = page.nextCursor ?? "";
+      } while (cursor);
       setPieces(data);
 
       // Refresh payment status after successfully fetching pieces
@@ -1,17 +1,28 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hotvault/backend/config"
+	"github.com/hotvault/backend/internal/api/handlers"
 	"github.com/hotvault/backend/internal/api/routes"
 	"github.com/hotvault/backend/internal/database"
 	"github.com/hotvault/backend/pkg/logger"
 	"github.com/joho/godotenv"
 )
 
+// shutdownTimeout bounds how long the server waits for in-flight requests and
+// queued uploads to finish after receiving SIGINT or SIGTERM.
+const shutdownTimeout = 5 * time.Minute
+
 func main() {
 
 	log := logger.NewLogger()
@@ -22,6 +33,23 @@ func main() {
 
 	log.Info("Loading configuration...")
 	cfg := config.LoadConfig()
+	for _, warning := range cfg.Warnings {
+		log.Warning("Config: " + warning)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(fmt.Sprintf("Invalid configuration: %v", err))
+	}
+	log.WithField("addRootsMaxRetries", cfg.Retry.AddRootsMaxRetries).
+		WithField("addRootsBackoff", cfg.Retry.AddRootsBackoff).
+		WithField("addRootsMaxBackoff", cfg.Retry.AddRootsMaxBackoff).
+		WithField("addRootsTimeout", cfg.Retry.AddRootsTimeout).
+		WithField("preUploadDelay", cfg.Retry.PreUploadDelay).
+		WithField("preAddRootDelay", cfg.Retry.PreAddRootDelay).
+		WithField("rootIDPollInterval", cfg.Retry.RootIDPollInterval).
+		WithField("rootIDPollMaxAttempts", cfg.Retry.RootIDPollMaxAttempts).
+		WithField("proofSetPollInterval", cfg.Retry.ProofSetPollInterval).
+		WithField("proofSetPollMaxAttempts", cfg.Retry.ProofSetPollMaxAttempts).
+		Info("Loaded retry configuration")
 
 	loggingConfig := logger.GetLoggingConfig()
 
@@ -39,7 +67,7 @@ func main() {
 	log.Info("Successfully connected to database.")
 
 	log.Info("Attempting to run database migrations...")
-	if err := database.MigrateDB(db); err != nil {
+	if err := database.MigrateDB(db, cfg); err != nil {
 		log.Fatal(fmt.Sprintf("Failed to migrate database: %v", err))
 	}
 	log.Info("Database migrations completed successfully.")
@@ -54,8 +82,33 @@ func main() {
 	}
 
 	serverAddr := fmt.Sprintf(":%s", port)
-	log.Info("Server starting on " + serverAddr)
-	if err := router.Run(serverAddr); err != nil {
-		log.Fatal(fmt.Sprintf("Failed to start server: %v", err))
+	srv := &http.Server{
+		Addr:    serverAddr,
+		Handler: router,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Info("Server starting on " + serverAddr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(fmt.Sprintf("Failed to start server: %v", err))
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Info("Shutting down server...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Error(fmt.Sprintf("Server shutdown failed: %v", err))
+	}
+	if err := handlers.Shutdown(shutdownCtx); err != nil {
+		log.Error(fmt.Sprintf("Upload workers did not finish before shutdown: %v", err))
 	}
+	log.Info("Server stopped")
 }
@@ -1,9 +1,16 @@
 package config
 
 import (
+	"fmt"
+	"math/big"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/hotvault/backend/pkg/diskspace"
 )
 
 type Config struct {
@@ -11,10 +18,22 @@ type Config struct {
 	Database     DatabaseConfig
 	JWT          JWTConfig
 	Ethereum     EthereumConfig
+	Upload       UploadConfig
+	Download     DownloadConfig
+	Retry        RetryConfig
+	Scan         ScanConfig
+	Proofs       ProofsConfig
+	Pricing      PricingConfig
 	PdptoolPath  string
 	ServiceName  string
 	ServiceURL   string
 	RecordKeeper string
+	// AdminWallets are the lowercased wallet addresses allowed to manage
+	// storage providers.
+	AdminWallets []string
+	// Warnings lists settings that were invalid and replaced by defaults, so
+	// they can be logged once a logger is available.
+	Warnings []string
 }
 
 type ServerConfig struct {
@@ -29,6 +48,10 @@ type DatabaseConfig struct {
 	Password string
 	DBName   string
 	SSLMode  string
+	// DedupeDryRun makes migration only log the duplicate pieces it would
+	// remove before creating the unique (user_id, c_id) index, and stop
+	// while there are any.
+	DedupeDryRun bool
 }
 
 type JWTConfig struct {
@@ -42,6 +65,202 @@ type EthereumConfig struct {
 	ContractAddress string
 }
 
+type UploadConfig struct {
+	MaxSize        int64
+	Concurrency    int
+	QueueWarnDepth int
+	// AllowedMimeTypes and BlockedMimeTypes filter uploads by their sniffed
+	// content type. Entries may use a "type/*" wildcard.
+	AllowedMimeTypes []string
+	BlockedMimeTypes []string
+	// UserQuota is the total number of bytes a user may store. 0 means
+	// unlimited.
+	UserQuota int64
+	// JobRetention is how long finished upload jobs are kept before the
+	// janitor removes them. The newest JobHistoryPerUser jobs of each user
+	// are always kept.
+	JobRetention      time.Duration
+	JobHistoryPerUser int
+	// PadFiles zero-pads files whose size the PDP service would reject. When
+	// disabled such uploads are refused instead.
+	PadFiles bool
+	// CommandTimeout caps how long prepare-piece and upload-file may run
+	// for one file. Smaller files get a shorter deadline based on their size.
+	CommandTimeout time.Duration
+	// Versioning makes an upload whose filename matches one of the user's
+	// existing files a new version of that file rather than an unrelated
+	// piece.
+	Versioning bool
+	// DiskReserve is the number of bytes of free disk space chunked uploads
+	// must leave untouched on the filesystem holding their chunks.
+	DiskReserve int64
+	// ChunkDir is where chunked uploads keep their chunks until they are
+	// assembled. Defaults to a directory under the system temp directory.
+	ChunkDir string
+	// MinChunkSize and MaxChunkSize bound the chunk size a chunked upload
+	// may declare, and MaxChunks how many chunks it may have. An upload
+	// sent as a single chunk may be smaller than MinChunkSize. A
+	// MaxChunkSize of 0 means no limit.
+	MinChunkSize int64
+	MaxChunkSize int64
+	MaxChunks    int
+	// ChunkedUploadExpiry is how long a chunked upload may sit idle before
+	// it and its chunks are deleted, checked every ChunkedCleanupInterval.
+	ChunkedUploadExpiry    time.Duration
+	ChunkedCleanupInterval time.Duration
+	// PublicDownloads lets users mark pieces public, so any signed-in user
+	// can download them by CID. When disabled only owners can download.
+	PublicDownloads bool
+	// MaxFilenameLength caps, in characters, the name a piece may be
+	// renamed to.
+	MaxFilenameLength int
+	// RemovalGracePeriod is how long a deleted piece whose root has been
+	// removed stays listed as pending removal before its row is deleted.
+	RemovalGracePeriod time.Duration
+	// RemovalInterval is how often pieces past their grace period are
+	// checked against their service and deleted, 0 disabling it. They are
+	// handled RemovalBatch at a time; with RemovalDryRun nothing is
+	// changed, only logged and counted.
+	RemovalInterval time.Duration
+	RemovalBatch    int
+	RemovalDryRun   bool
+	// BulkMaxItems caps how many pieces one bulk request may name. Bulk
+	// removals of more than BulkSyncRemovals pieces with roots run as a
+	// background job instead of while the client waits.
+	BulkMaxItems     int
+	BulkSyncRemovals int
+	// MaxMetadataBytes caps the size of the JSON metadata object a piece
+	// may carry.
+	MaxMetadataBytes int
+}
+
+// DownloadConfig controls downloads: the local cache of files fetched from
+// the PDP service, signed download links and IPFS gateway redirects.
+type DownloadConfig struct {
+	// CacheDir holds downloaded files keyed by CID.
+	CacheDir string
+	// CacheMaxSize is the number of bytes the cache may hold before the
+	// least recently used files are evicted. 0 disables the cache.
+	CacheMaxSize int64
+	// LinkDefaultTTL is how long a signed download link stays valid when
+	// its creator doesn't say. No link may outlive LinkMaxTTL.
+	LinkDefaultTTL time.Duration
+	LinkMaxTTL     time.Duration
+	// Gateways are base URLs of IPFS gateways, in order of preference, that
+	// downloads asking for gateway=true are redirected to. The first one to
+	// answer a HEAD request within GatewayProbeTimeout is used.
+	Gateways            []string
+	GatewayProbeTimeout time.Duration
+	// MaxConcurrentPerUser caps how many downloads one user may run at
+	// once, and UserBytesPerSecond how fast they are sent in total. 0
+	// means no limit.
+	MaxConcurrentPerUser int
+	UserBytesPerSecond   int64
+	// ArchiveSyncMaxSize is the largest proof set, by total file size, that
+	// is archived while the client waits; larger ones are archived by a
+	// background job. No archive may exceed ArchiveMaxSize, 0 meaning no
+	// limit.
+	ArchiveSyncMaxSize int64
+	ArchiveMaxSize     int64
+	// PreviewMaxBytes caps how much of a file a preview may return.
+	PreviewMaxBytes int
+	// Stream sends whole-file downloads while pdptool is still writing
+	// them, rather than once it has finished. It relies on pdptool writing
+	// its output file in place and in order.
+	Stream bool
+}
+
+// RetryConfig controls how long uploads and proof set creation keep retrying
+// pdptool calls against the PDP service.
+type RetryConfig struct {
+	AddRootsMaxRetries      int
+	AddRootsBackoff         time.Duration
+	AddRootsMaxBackoff      time.Duration
+	AddRootsTimeout         time.Duration
+	PreUploadDelay          time.Duration
+	PreAddRootDelay         time.Duration
+	RootIDPollInterval      time.Duration
+	RootIDPollMaxAttempts   int
+	ProofSetPollInterval    time.Duration
+	ProofSetPollMaxAttempts int // 0 polls until the transaction settles
+	// ProofSetPollMaxDuration is how long a creation transaction is polled
+	// for its proof set ID before the creation is marked failed, 0 polling
+	// until it settles.
+	ProofSetPollMaxDuration time.Duration
+	// ProofSetStaleAfter is how long a creation can go without a proof set
+	// ID or a poller before a retry may treat it as failed.
+	ProofSetStaleAfter time.Duration
+	// DownloadMaxRetries is how many times a download-file command that
+	// failed for a transient reason is run again before giving up.
+	DownloadMaxRetries int
+	DownloadBackoff    time.Duration
+	DownloadMaxBackoff time.Duration
+	// WebhookMaxAttempts is how many times a webhook delivery is tried
+	// before it is dead-lettered.
+	WebhookMaxAttempts int
+	WebhookBackoff     time.Duration
+	WebhookMaxBackoff  time.Duration
+}
+
+// ProofsConfig controls the history of each proof set's proving state.
+type ProofsConfig struct {
+	// RecordInterval is how often the state of every ready proof set is
+	// recorded, 0 disabling it. Records older than Retention are deleted;
+	// 0 keeps them.
+	RecordInterval time.Duration
+	Retention      time.Duration
+	// MaxBackoff caps how long a proof set whose service couldn't be
+	// reached is skipped before it is asked again.
+	MaxBackoff time.Duration
+	// ChallengeWindow is how many epochs after its next challenge epoch a
+	// proof set has to submit the proof. A proof set is taken to have missed
+	// it once GraceEpochs more have passed without the proof.
+	ChallengeWindow int64
+	GraceEpochs     int64
+}
+
+// Pricing sources.
+const (
+	PricingSourceConfig = "config"
+	PricingSourceFree   = "free"
+)
+
+// PricingConfig sets what cost estimates are based on. Amounts are in
+// attoFIL and gas in gas units.
+type PricingConfig struct {
+	// Source is PricingSourceConfig, pricing with the settings below, or
+	// PricingSourceFree for networks where storage costs nothing.
+	Source string
+	// StoragePerTiBMonth is what storing one TiB for a month costs.
+	StoragePerTiBMonth *big.Int
+	// GasPrice is used when the gas price can't be read from ETH_RPC_URL.
+	GasPrice          *big.Int
+	CreateProofSetGas int64
+	AddRootsGas       int64
+	// ProveGas is spent on each proof of possession, submitted once every
+	// ProvingPeriod.
+	ProveGas      int64
+	ProvingPeriod time.Duration
+}
+
+// ScanConfig controls malware scanning of uploads before they are sent to the
+// PDP service.
+type ScanConfig struct {
+	Enabled bool
+	// Address is the host:port of the clamd daemon.
+	Address string
+	Timeout time.Duration
+	// FailOpen lets uploads through when the scanner can't be reached or
+	// can't scan a file. When false such uploads fail.
+	FailOpen bool
+}
+
+const (
+	defaultMaxUploadSize     = 10 * 1024 * 1024 * 1024 // 10 GB
+	defaultUploadConcurrency = 3
+	defaultQueueWarnDepth    = 10
+)
+
 func LoadConfig() *Config {
 	expirationStr := os.Getenv("JWT_EXPIRATION")
 	expiration, err := time.ParseDuration(expirationStr)
@@ -54,18 +273,60 @@ func LoadConfig() *Config {
 		chainID = 1
 	}
 
+	maxUploadSize, err := strconv.ParseInt(os.Getenv("MAX_UPLOAD_SIZE"), 10, 64)
+	if err != nil || maxUploadSize <= 0 {
+		maxUploadSize = defaultMaxUploadSize
+	}
+
+	uploadConcurrency, err := strconv.Atoi(os.Getenv("UPLOAD_CONCURRENCY"))
+	if err != nil || uploadConcurrency <= 0 {
+		uploadConcurrency = defaultUploadConcurrency
+	}
+
+	queueWarnDepth, err := strconv.Atoi(os.Getenv("UPLOAD_QUEUE_WARN_DEPTH"))
+	if err != nil || queueWarnDepth <= 0 {
+		queueWarnDepth = defaultQueueWarnDepth
+	}
+
+	env := &envParser{}
+	retry := RetryConfig{
+		AddRootsMaxRetries:      env.positiveInt("ADD_ROOTS_MAX_RETRIES", 100),
+		AddRootsBackoff:         env.duration("ADD_ROOTS_BACKOFF", 10*time.Second),
+		AddRootsMaxBackoff:      env.duration("ADD_ROOTS_MAX_BACKOFF", 10*time.Second),
+		AddRootsTimeout:         env.duration("ADD_ROOTS_TIMEOUT", 60*time.Second),
+		PreUploadDelay:          env.duration("PRE_UPLOAD_DELAY", 10*time.Second),
+		PreAddRootDelay:         env.duration("PRE_ADD_ROOT_DELAY", 1*time.Second),
+		RootIDPollInterval:      env.duration("ROOT_ID_POLL_INTERVAL", 10*time.Second),
+		RootIDPollMaxAttempts:   env.positiveInt("ROOT_ID_POLL_MAX_ATTEMPTS", 100),
+		ProofSetPollInterval:    env.duration("PROOF_SET_POLL_INTERVAL", 10*time.Second),
+		ProofSetPollMaxAttempts: env.nonNegativeInt("PROOF_SET_POLL_MAX_ATTEMPTS", 0),
+		ProofSetPollMaxDuration: env.duration("PROOF_SET_POLL_MAX_DURATION", 2*time.Hour),
+		ProofSetStaleAfter:      env.duration("PROOF_SET_STALE_AFTER", time.Hour),
+		DownloadMaxRetries:      env.nonNegativeInt("DOWNLOAD_MAX_RETRIES", 2),
+		DownloadBackoff:         env.duration("DOWNLOAD_BACKOFF", 2*time.Second),
+		DownloadMaxBackoff:      env.duration("DOWNLOAD_MAX_BACKOFF", 10*time.Second),
+		WebhookMaxAttempts:      env.positiveInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookBackoff:          env.duration("WEBHOOK_BACKOFF", 30*time.Second),
+		WebhookMaxBackoff:       env.duration("WEBHOOK_MAX_BACKOFF", time.Hour),
+	}
+	if retry.AddRootsMaxBackoff < retry.AddRootsBackoff {
+		env.warn("ADD_ROOTS_MAX_BACKOFF is lower than ADD_ROOTS_BACKOFF, using ADD_ROOTS_BACKOFF")
+		retry.AddRootsMaxBackoff = retry.AddRootsBackoff
+	}
+
 	return &Config{
 		Server: ServerConfig{
 			Port: os.Getenv("PORT"),
 			Env:  os.Getenv("ENV"),
 		},
 		Database: DatabaseConfig{
-			Host:     os.Getenv("DB_HOST"),
-			Port:     os.Getenv("DB_PORT"),
-			User:     os.Getenv("DB_USER"),
-			Password: os.Getenv("DB_PASSWORD"),
-			DBName:   os.Getenv("DB_NAME"),
-			SSLMode:  os.Getenv("DB_SSLMODE"),
+			Host:         os.Getenv("DB_HOST"),
+			Port:         os.Getenv("DB_PORT"),
+			User:         os.Getenv("DB_USER"),
+			Password:     os.Getenv("DB_PASSWORD"),
+			DBName:       os.Getenv("DB_NAME"),
+			SSLMode:      os.Getenv("DB_SSLMODE"),
+			DedupeDryRun: env.boolean("DB_DEDUPE_PIECES_DRY_RUN", false),
 		},
 		JWT: JWTConfig{
 			Secret:     os.Getenv("JWT_SECRET"),
@@ -76,9 +337,251 @@ func LoadConfig() *Config {
 			ChainID:         chainID,
 			ContractAddress: os.Getenv("CONTRACT_ADDRESS"),
 		},
+		Upload: UploadConfig{
+			MaxSize:                maxUploadSize,
+			Concurrency:            uploadConcurrency,
+			QueueWarnDepth:         queueWarnDepth,
+			AllowedMimeTypes:       splitList(os.Getenv("ALLOWED_MIME_TYPES")),
+			BlockedMimeTypes:       splitList(os.Getenv("BLOCKED_MIME_TYPES")),
+			UserQuota:              env.nonNegativeInt64("UPLOAD_USER_QUOTA", 0),
+			JobRetention:           env.duration("UPLOAD_JOB_RETENTION", 24*time.Hour),
+			JobHistoryPerUser:      env.nonNegativeInt("UPLOAD_JOB_HISTORY_PER_USER", 20),
+			PadFiles:               env.boolean("UPLOAD_PAD_FILES", true),
+			CommandTimeout:         env.duration("UPLOAD_COMMAND_TIMEOUT", 2*time.Hour),
+			Versioning:             env.boolean("UPLOAD_VERSIONING", false),
+			DiskReserve:            env.nonNegativeInt64("UPLOAD_DISK_RESERVE", 1<<30),
+			MinChunkSize:           env.nonNegativeInt64("UPLOAD_MIN_CHUNK_SIZE", 1<<20),
+			MaxChunkSize:           env.nonNegativeInt64("UPLOAD_MAX_CHUNK_SIZE", 100<<20),
+			MaxChunks:              env.positiveInt("UPLOAD_MAX_CHUNKS", 10000),
+			ChunkedUploadExpiry:    env.duration("CHUNKED_UPLOAD_EXPIRY", 24*time.Hour),
+			ChunkedCleanupInterval: env.duration("CHUNKED_UPLOAD_CLEANUP_INTERVAL", time.Hour),
+			PublicDownloads:        env.boolean("PUBLIC_DOWNLOADS", false),
+			MaxFilenameLength:      env.positiveInt("MAX_FILENAME_LENGTH", 255),
+			RemovalGracePeriod:     env.duration("PIECE_REMOVAL_GRACE_PERIOD", 24*time.Hour),
+			RemovalInterval:        env.duration("PIECE_REMOVAL_FINALIZE_INTERVAL", 10*time.Minute),
+			RemovalBatch:           env.positiveInt("PIECE_REMOVAL_FINALIZE_BATCH", 100),
+			RemovalDryRun:          env.boolean("PIECE_REMOVAL_FINALIZE_DRY_RUN", false),
+			BulkMaxItems:           env.positiveInt("BULK_MAX_ITEMS", 500),
+			BulkSyncRemovals:       env.nonNegativeInt("BULK_SYNC_REMOVALS", 5),
+			MaxMetadataBytes:       env.positiveInt("PIECE_METADATA_MAX_BYTES", 4096),
+			ChunkDir:               envOrDefault("CHUNK_UPLOAD_DIR", filepath.Join(os.TempDir(), "chunked_uploads")),
+		},
+		Download: DownloadConfig{
+			CacheDir:             envOrDefault("DOWNLOAD_CACHE_DIR", filepath.Join(os.TempDir(), "hotvault-download-cache")),
+			CacheMaxSize:         env.nonNegativeInt64("DOWNLOAD_CACHE_MAX_SIZE", 10<<30),
+			LinkDefaultTTL:       env.duration("DOWNLOAD_LINK_DEFAULT_TTL", time.Hour),
+			LinkMaxTTL:           env.duration("DOWNLOAD_LINK_MAX_TTL", 7*24*time.Hour),
+			Gateways:             env.urlList("DOWNLOAD_GATEWAYS"),
+			GatewayProbeTimeout:  env.duration("DOWNLOAD_GATEWAY_PROBE_TIMEOUT", 2*time.Second),
+			Stream:               env.boolean("DOWNLOAD_STREAM", false),
+			MaxConcurrentPerUser: env.nonNegativeInt("DOWNLOAD_MAX_CONCURRENT_PER_USER", 4),
+			UserBytesPerSecond:   env.nonNegativeInt64("DOWNLOAD_USER_BYTES_PER_SECOND", 0),
+			ArchiveSyncMaxSize:   env.nonNegativeInt64("DOWNLOAD_ARCHIVE_SYNC_MAX_SIZE", 1<<30),
+			ArchiveMaxSize:       env.nonNegativeInt64("DOWNLOAD_ARCHIVE_MAX_SIZE", 50<<30),
+			PreviewMaxBytes:      env.positiveInt("DOWNLOAD_PREVIEW_MAX_BYTES", 1<<20),
+		},
+		Retry: retry,
+		Scan: ScanConfig{
+			Enabled:  env.boolean("SCAN_ENABLED", false),
+			Address:  envOrDefault("CLAMD_ADDRESS", "localhost:3310"),
+			Timeout:  env.duration("SCAN_TIMEOUT", 5*time.Minute),
+			FailOpen: env.boolean("SCAN_FAIL_OPEN", false),
+		},
+		Proofs: ProofsConfig{
+			RecordInterval:  env.duration("PROOF_RECORD_INTERVAL", 10*time.Minute),
+			Retention:       env.duration("PROOF_RECORD_RETENTION", 90*24*time.Hour),
+			MaxBackoff:      env.duration("PROOF_RECORD_MAX_BACKOFF", 6*time.Hour),
+			ChallengeWindow: env.nonNegativeInt64("PROOF_CHALLENGE_WINDOW", 60),
+			GraceEpochs:     env.nonNegativeInt64("PROOF_MISSED_GRACE_EPOCHS", 60),
+		},
+		Pricing: PricingConfig{
+			Source:             env.oneOf("PRICING_SOURCE", PricingSourceConfig, PricingSourceConfig, PricingSourceFree),
+			StoragePerTiBMonth: env.amount("PRICING_STORAGE_PER_TIB_MONTH", 0),
+			GasPrice:           env.amount("PRICING_GAS_PRICE", 100),
+			CreateProofSetGas:  env.nonNegativeInt64("PRICING_CREATE_PROOF_SET_GAS", 100_000_000),
+			AddRootsGas:        env.nonNegativeInt64("PRICING_ADD_ROOTS_GAS", 50_000_000),
+			ProveGas:           env.nonNegativeInt64("PRICING_PROVE_GAS", 150_000_000),
+			ProvingPeriod:      env.duration("PRICING_PROVING_PERIOD", 24*time.Hour),
+		},
+		Warnings:     env.warnings,
 		PdptoolPath:  os.Getenv("PDPTOOL_PATH"),
 		ServiceName:  os.Getenv("SERVICE_NAME"),
 		ServiceURL:   os.Getenv("SERVICE_URL"),
 		RecordKeeper: os.Getenv("RECORD_KEEPER"),
+		AdminWallets: splitList(os.Getenv("ADMIN_WALLETS")),
+	}
+}
+
+// Validate checks settings that can only be verified against the running
+// system, so a misconfigured server fails at startup rather than on the first
+// request that needs them. It creates the chunk upload directory if needed.
+func (c *Config) Validate() error {
+	if err := os.MkdirAll(c.Upload.ChunkDir, 0750); err != nil {
+		return fmt.Errorf("CHUNK_UPLOAD_DIR %s can't be created: %w", c.Upload.ChunkDir, err)
+	}
+	info, err := os.Stat(c.Upload.ChunkDir)
+	if err != nil {
+		return fmt.Errorf("CHUNK_UPLOAD_DIR %s can't be read: %w", c.Upload.ChunkDir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("CHUNK_UPLOAD_DIR %s is not a directory", c.Upload.ChunkDir)
+	}
+
+	probe, err := os.CreateTemp(c.Upload.ChunkDir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("CHUNK_UPLOAD_DIR %s is not writable: %w", c.Upload.ChunkDir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	// Platforms where free space can't be read are checked per upload
+	// instead, which also skips the check.
+	if available, err := diskspace.Available(c.Upload.ChunkDir); err == nil && available <= c.Upload.DiskReserve {
+		return fmt.Errorf("CHUNK_UPLOAD_DIR %s has %d bytes free, not more than UPLOAD_DISK_RESERVE (%d)",
+			c.Upload.ChunkDir, available, c.Upload.DiskReserve)
+	}
+	return nil
+}
+
+// envParser reads optional numeric settings, falling back to a default when
+// a value is missing and recording a warning when it is invalid.
+type envParser struct {
+	warnings []string
+}
+
+func (p *envParser) warn(format string, args ...interface{}) {
+	p.warnings = append(p.warnings, fmt.Sprintf(format, args...))
+}
+
+func (p *envParser) duration(key string, def time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil || value < 0 {
+		p.warn("invalid %s %q, using default %v", key, raw, def)
+		return def
+	}
+	return value
+}
+
+func (p *envParser) positiveInt(key string, def int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 {
+		p.warn("invalid %s %q, using default %d", key, raw, def)
+		return def
+	}
+	return value
+}
+
+func (p *envParser) nonNegativeInt(key string, def int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		p.warn("invalid %s %q, using default %d", key, raw, def)
+		return def
+	}
+	return value
+}
+
+func (p *envParser) nonNegativeInt64(key string, def int64) int64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || value < 0 {
+		p.warn("invalid %s %q, using default %d", key, raw, def)
+		return def
+	}
+	return value
+}
+
+func (p *envParser) boolean(key string, def bool) bool {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		p.warn("invalid %s %q, using default %t", key, raw, def)
+		return def
+	}
+	return value
+}
+
+// amount parses a non-negative whole number of attoFIL.
+func (p *envParser) amount(key string, def int64) *big.Int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return big.NewInt(def)
+	}
+	value, ok := new(big.Int).SetString(raw, 10)
+	if !ok || value.Sign() < 0 {
+		p.warn("invalid %s %q, using default %d", key, raw, def)
+		return big.NewInt(def)
+	}
+	return value
+}
+
+// oneOf returns the lower-cased value of key when it is one of allowed.
+func (p *envParser) oneOf(key, def string, allowed ...string) string {
+	raw := strings.ToLower(strings.TrimSpace(os.Getenv(key)))
+	if raw == "" {
+		return def
+	}
+	for _, value := range allowed {
+		if raw == value {
+			return value
+		}
+	}
+	p.warn("invalid %s %q, using default %s", key, raw, def)
+	return def
+}
+
+func envOrDefault(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// urlList parses a comma separated list of http(s) base URLs, dropping
+// trailing slashes and skipping invalid entries with a warning.
+func (p *envParser) urlList(key string) []string {
+	var urls []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		item = strings.TrimRight(strings.TrimSpace(item), "/")
+		if item == "" {
+			continue
+		}
+		parsed, err := url.Parse(item)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			p.warn("invalid URL %q in %s, ignoring it", item, key)
+			continue
+		}
+		urls = append(urls, item)
+	}
+	return urls
+}
+
+// splitList parses a comma separated setting into lower-cased, trimmed
+// entries, skipping empty ones.
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+)
+
+const (
+	// accountStatsTTL is how long a user's statistics are served from memory
+	// before being computed again.
+	accountStatsTTL = time.Minute
+	// accountStatsDays is how many days of daily upload counts to report.
+	accountStatsDays = 30
+)
+
+// ProofSetStats is how much of a user's storage is in one proof set
+// @Description Files and bytes stored in one proof set
+type ProofSetStats struct {
+	ProofSetID  *uint  `json:"proofSetId"`
+	ServiceID   string `json:"serviceProofSetId,omitempty"`
+	ServiceName string `json:"serviceName,omitempty"`
+	Pieces      int64  `json:"pieces"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// DailyUploads is how many files a user uploaded on one day
+// @Description Files uploaded on one UTC day
+type DailyUploads struct {
+	Date    string `json:"date" example:"2024-01-31"`
+	Uploads int64  `json:"uploads"`
+}
+
+// AccountStatsResponse summarises a user's storage
+// @Description Totals exclude files pending removal, whose bytes are reported separately. Uploads covers the last 30 days, oldest first.
+type AccountStatsResponse struct {
+	TotalPieces         int64           `json:"totalPieces"`
+	TotalBytes          int64           `json:"totalBytes"`
+	PendingRemovalBytes int64           `json:"pendingRemovalBytes"`
+	UnconfirmedRoots    int64           `json:"unconfirmedRoots"`
+	ProofSets           []ProofSetStats `json:"proofSets"`
+	Uploads             []DailyUploads  `json:"uploads"`
+	ComputedAt          time.Time       `json:"computedAt"`
+}
+
+type accountStatsEntry struct {
+	stats   AccountStatsResponse
+	expires time.Time
+}
+
+var (
+	accountStatsCache      = make(map[uint]accountStatsEntry)
+	accountStatsCacheMutex sync.Mutex
+)
+
+// @Summary Get storage statistics
+// @Description Get how many files and bytes the authenticated user is storing, broken down by proof set, how many files are still waiting for their root to be confirmed, and how many files were uploaded on each of the last 30 days. Results may be up to a minute old.
+// @Tags account
+// @Produce json
+// @Success 200 {object} AccountStatsResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /api/v1/account/stats [get]
+func GetAccountStats(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	now := time.Now()
+	accountStatsCacheMutex.Lock()
+	entry, ok := accountStatsCache[userID.(uint)]
+	accountStatsCacheMutex.Unlock()
+	if ok && now.Before(entry.expires) {
+		c.JSON(http.StatusOK, entry.stats)
+		return
+	}
+
+	stats, err := accountStats(userID.(uint), now)
+	if err != nil {
+		log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to compute account statistics")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load account statistics",
+		})
+		return
+	}
+
+	accountStatsCacheMutex.Lock()
+	for id, cached := range accountStatsCache {
+		if !now.Before(cached.expires) {
+			delete(accountStatsCache, id)
+		}
+	}
+	accountStatsCache[userID.(uint)] = accountStatsEntry{stats: stats, expires: now.Add(accountStatsTTL)}
+	accountStatsCacheMutex.Unlock()
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// accountStats computes a user's statistics with one aggregate query each
+// for the totals, the proof set breakdown and the daily upload counts.
+func accountStats(userID uint, now time.Time) (AccountStatsResponse, error) {
+	stats := AccountStatsResponse{
+		ProofSets:  []ProofSetStats{},
+		Uploads:    make([]DailyUploads, 0, accountStatsDays),
+		ComputedAt: now.UTC(),
+	}
+
+	var totals struct {
+		TotalPieces         int64
+		TotalBytes          int64
+		PendingRemovalBytes int64
+		UnconfirmedRoots    int64
+	}
+	if err := db.Model(&models.Piece{}).
+		Select(`COUNT(*) FILTER (WHERE NOT pending_removal) AS total_pieces,
+			COALESCE(SUM(size) FILTER (WHERE NOT pending_removal), 0) AS total_bytes,
+			COALESCE(SUM(size) FILTER (WHERE pending_removal), 0) AS pending_removal_bytes,
+			COUNT(*) FILTER (WHERE root_status IN ?) AS unconfirmed_roots`, []string{rootStatusPending, rootStatusUnconfirmed}).
+		Where("user_id = ?", userID).
+		Scan(&totals).Error; err != nil {
+		return stats, err
+	}
+	stats.TotalPieces = totals.TotalPieces
+	stats.TotalBytes = totals.TotalBytes
+	stats.PendingRemovalBytes = totals.PendingRemovalBytes
+	stats.UnconfirmedRoots = totals.UnconfirmedRoots
+
+	if err := db.Model(&models.Piece{}).
+		Select(`pieces.proof_set_id, proof_sets.proof_set_id AS service_id, proof_sets.service_name,
+			COUNT(*) AS pieces, COALESCE(SUM(pieces.size), 0) AS bytes`).
+		Joins("LEFT JOIN proof_sets ON proof_sets.id = pieces.proof_set_id").
+		Where("pieces.user_id = ? AND NOT pieces.pending_removal", userID).
+		Group("pieces.proof_set_id, proof_sets.proof_set_id, proof_sets.service_name").
+		Order("pieces.proof_set_id").
+		Scan(&stats.ProofSets).Error; err != nil {
+		return stats, err
+	}
+
+	// Count every upload, including files deleted since.
+	start := usageHistoryStart("day", accountStatsDays)
+	var rows []struct {
+		Day     time.Time
+		Uploads int64
+	}
+	if err := db.Unscoped().Model(&models.Piece{}).
+		Select("date_trunc('day', created_at AT TIME ZONE 'UTC') AS day, COUNT(*) AS uploads").
+		Where("user_id = ? AND created_at >= ?", userID, start).
+		Group("day").
+		Scan(&rows).Error; err != nil {
+		return stats, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Day.Format("2006-01-02")] = row.Uploads
+	}
+	for i := 0; i < accountStatsDays; i++ {
+		day := start.AddDate(0, 0, i).Format("2006-01-02")
+		stats.Uploads = append(stats.Uploads, DailyUploads{Date: day, Uploads: counts[day]})
+	}
+
+	return stats, nil
+}
@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/exec"
@@ -64,6 +66,12 @@ type StatusResponse struct {
 	Address           string `json:"address,omitempty" example:"0x742d35Cc6634C0532925a3b844Bc454e4438f44e"`
 	ProofSetReady     bool   `json:"proofSetReady" example:"true"`
 	ProofSetInitiated bool   `json:"proofSetInitiated" example:"true"`
+	ProofSetStatus    string `json:"proofSetStatus,omitempty" example:"ready"`
+	ProofSetError     string `json:"proofSetError,omitempty"`
+	// ProvingHealth is healthy, missed or unknown once the proof set is
+	// ready; ProvingHealthy is false when it missed a proof.
+	ProvingHealth  string `json:"provingHealth,omitempty" example:"healthy"`
+	ProvingHealthy *bool  `json:"provingHealthy,omitempty" example:"true"`
 }
 
 // VerifyRequest represents the request for verifying a signature
@@ -74,6 +82,14 @@ type VerifyRequest struct {
 	Message   string `json:"message,omitempty" example:"Sign this message to login to Hot Vault (No funds will be transferred in this step): 7a39f642c2608fd2"`
 }
 
+// CreateProofSetRequest represents the optional request for creating a proof set
+// @Description Storage provider to create the proof set on, the default provider being used when providerId is left out, and an optional name, unique among the caller's proof sets, and description
+type CreateProofSetRequest struct {
+	ProviderID  *uint   `json:"providerId,omitempty" example:"1"`
+	Name        *string `json:"name,omitempty" example:"Team photos"`
+	Description *string `json:"description,omitempty" example:"Originals from the 2024 offsite"`
+}
+
 // VerifyResponse represents the response for a verification request
 // @Description Response containing the JWT token and expiration
 type VerifyResponse struct {
@@ -197,6 +213,7 @@ func (h *AuthHandler) VerifySignature(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update nonce"})
 		return
 	}
+	activatePieceGrants(h.db, user)
 
 	expirationTime := time.Now().Add(h.cfg.JWT.Expiration)
 	claims := &models.JWTClaims{
@@ -231,13 +248,18 @@ func (h *AuthHandler) VerifySignature(c *gin.Context) {
 
 // CreateProofSet godoc
 // @Summary Create Proof Set
-// @Description Manually initiates the creation of a proof set for the authenticated user if one doesn't exist.
+// @Description Manually initiates the creation of a proof set for the authenticated user if one doesn't exist, on the chosen storage provider or the default one. Providers that don't exist or are disabled get 404. A name, 1 to 100 characters and unique among the caller's proof sets, and a description of up to 1000 characters may be given.
 // @Tags Proof Set
 // @Security ApiKeyAuth
+// @Accept json
+// @Param request body CreateProofSetRequest false "Storage provider"
 // @Produce json
 // @Success 200 {object} map[string]interface{} "message:Proof set creation initiated successfully"
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /proof-set/create [post]
 func (h *AuthHandler) CreateProofSet(c *gin.Context) {
@@ -247,26 +269,71 @@ func (h *AuthHandler) CreateProofSet(c *gin.Context) {
 		return
 	}
 
+	var request CreateProofSetRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid request parameters: " + err.Error()})
+		return
+	}
+	details := make(map[string]interface{})
+	if request.Name != nil {
+		name, err := proofSetName(*request.Name)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, ErrorResponse{Error: err.Error()})
+			return
+		}
+		details["name"] = name
+	}
+	if request.Description != nil {
+		description, err := proofSetDescription(*request.Description)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, ErrorResponse{Error: err.Error()})
+			return
+		}
+		details["description"] = description
+	}
+
 	var user models.User
 	if err := h.db.First(&user, userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
 		return
 	}
 
+	provider, err := findStorageProvider(request.ProviderID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if request.ProviderID == nil {
+				authLog.WithField("userID", user.ID).Error("No storage provider is configured")
+				c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "No storage provider is configured"})
+				return
+			}
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Storage provider not found or disabled"})
+			return
+		}
+		authLog.WithField("userID", user.ID).Errorf("Error loading storage provider: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to load storage provider"})
+		return
+	}
+
 	var existingProofSet models.ProofSet
-	err := h.db.Where("user_id = ?", user.ID).First(&existingProofSet).Error
+	err = h.db.Where("user_id = ?", user.ID).First(&existingProofSet).Error
 	if err == nil {
-		if existingProofSet.ProofSetID != "" {
-			authLog.WithField("userID", user.ID).Warn("CreateProofSet called but ProofSetID already exists.")
+		switch existingProofSet.Status {
+		case proofSetDecommissioning:
+			c.JSON(http.StatusConflict, ErrorResponse{Error: "Proof set is being decommissioned. Create a new one once it is gone."})
+			return
+		case proofSetReady:
+			authLog.WithField("userID", user.ID).Warn("CreateProofSet called but the proof set is already ready.")
 			c.JSON(http.StatusConflict, ErrorResponse{Error: "Proof set already exists and is complete for this user"})
 			return
-		}
-		if existingProofSet.TransactionHash != "" {
-			authLog.WithField("userID", user.ID).Warn("CreateProofSet called but TransactionHash exists (creation likely in progress).")
+		case proofSetSubmitting, proofSetConfirming:
+			authLog.WithField("userID", user.ID).Warn("CreateProofSet called but creation is already in progress.")
 			c.JSON(http.StatusConflict, ErrorResponse{Error: "Proof set creation is already in progress for this user. Check status."})
 			return
+		case proofSetFailed:
+			authLog.WithField("userID", user.ID).WithField("reason", existingProofSet.FailureReason).Info("Previous proof set creation failed, submitting it again.")
+		default:
+			authLog.WithField("userID", user.ID).Info("Found pending proof set record, proceeding with creation attempt.")
 		}
-		authLog.WithField("userID", user.ID).Info("Found existing proof set record with empty fields, proceeding with creation attempt.")
 	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
 		authLog.WithField("userID", user.ID).Errorf("Error checking for existing proof set: %v", err)
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to check for existing proof sets"})
@@ -274,32 +341,217 @@ func (h *AuthHandler) CreateProofSet(c *gin.Context) {
 	} else {
 		authLog.WithField("userID", user.ID).Info("No existing proof set record found.")
 	}
+	if name, ok := details["name"]; ok {
+		if err := checkProofSetName(h.db, user.ID, name.(string), existingProofSet.ID); err != nil {
+			if errors.Is(err, errProofSetNameTaken) {
+				c.JSON(http.StatusConflict, ErrorResponse{Error: err.Error()})
+				return
+			}
+			authLog.WithField("userID", user.ID).Errorf("Error checking proof set name: %v", err)
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to check for existing proof sets"})
+			return
+		}
+	}
 
 	go func(u *models.User) {
-		authLog.WithField("userID", u.ID).Info("Starting background proof set creation...")
-		if err := h.createProofSetForUser(u); err != nil {
+		authLog.WithField("userID", u.ID).WithField("providerID", provider.ID).Info("Starting background proof set creation...")
+		if err := h.createProofSetForUser(u, provider, details); err != nil {
 			authLog.WithField("userID", u.ID).Errorf("Background proof set creation failed: %v", err)
 		} else {
-			authLog.WithField("userID", u.ID).Info("Background proof set creation completed successfully.")
+			authLog.WithField("userID", u.ID).Info("Background proof set creation submitted, polling for its ID.")
 		}
 	}(&user)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Proof set creation initiated successfully. Monitor /auth/status for readiness."})
 }
 
-func (h *AuthHandler) createProofSetForUser(user *models.User) error {
+// createProofSetForUser submits the creation of user's proof set on
+// provider and hands its transaction to the poller, first saving details,
+// the proof set's name and description, when given. The proof set moves
+// from pending, or from failed when an earlier creation failed, to
+// submitting while create-proof-set runs, then to confirming, or to failed
+// with the reason.
+func (h *AuthHandler) createProofSetForUser(user *models.User, provider models.StorageProvider, details map[string]interface{}) error {
+	query := h.db.Where(models.ProofSet{UserID: user.ID}).
+		Attrs(models.ProofSet{Status: proofSetPending})
+	if len(details) > 0 {
+		query = query.Assign(details)
+	}
+	var proofSet models.ProofSet
+	if err := query.FirstOrCreate(&proofSet).Error; err != nil {
+		return fmt.Errorf("failed to save proof set for user %d: %w", user.ID, err)
+	}
+	if err := h.startProofSetCreation(&proofSet, provider); err != nil {
+		return err
+	}
+	return h.finishProofSetCreation(user, proofSet, provider)
+}
+
+// startProofSetCreation moves proofSet to submitting on provider, clearing
+// the transaction hash and polling progress of any earlier attempt. Only one
+// caller can start a creation; the others get errProofSetTransition.
+func (h *AuthHandler) startProofSetCreation(proofSet *models.ProofSet, provider models.StorageProvider) error {
+	if err := transitionProofSet(h.db, proofSet, proofSetSubmitting, map[string]interface{}{
+		"service_name":     provider.ServiceName,
+		"service_url":      provider.ServiceURL,
+		"provider_id":      provider.ID,
+		"transaction_hash": "",
+		"poll_started_at":  nil,
+		"poll_attempts":    0,
+		"last_poll_status": "",
+	}); err != nil {
+		return err
+	}
+	publishUserEvent(proofSet.UserID, WSTypeProofSetStatus, proofSetEventFromModel(*proofSet))
+	return nil
+}
+
+// finishProofSetCreation runs create-proof-set for a proof set started by
+// startProofSetCreation and starts polling for its ID.
+func (h *AuthHandler) finishProofSetCreation(user *models.User, proofSet models.ProofSet, provider models.StorageProvider) error {
+	txHash, err := h.submitProofSetCreation(user, provider)
+	if err != nil {
+		if err := transitionProofSet(h.db, &proofSet, proofSetFailed, map[string]interface{}{
+			"failure_reason": err.Error(),
+		}); err != nil {
+			authLog.WithField("userID", user.ID).Errorf("Failed to mark proof set creation as failed: %v", err)
+		}
+		publishUserEvent(user.ID, WSTypeProofSetStatus, proofSetEventFromModel(proofSet))
+		return err
+	}
+
+	if err := transitionProofSet(h.db, &proofSet, proofSetConfirming, map[string]interface{}{
+		"transaction_hash": txHash,
+		"poll_started_at":  time.Now(),
+	}); err != nil {
+		errMsg := fmt.Sprintf("[Goroutine Create] Failed to save proof set with txHash for user %d: %v", user.ID, err)
+		authLog.Error(errMsg)
+		return errors.New(errMsg)
+	}
+	publishUserEvent(user.ID, WSTypeProofSetStatus, proofSetEventFromModel(proofSet))
+	if err := recordTransaction(h.db, user, proofSet.ID, txHash, transactionMethodCreateProofSet); err != nil {
+		authLog.WithField("userID", user.ID).WithField("txHash", txHash).Errorf("Failed to record proof set creation transaction: %v", err)
+	}
+
+	// The poller records the proof set's ID once the transaction settles,
+	// and picks the creation up again if the server restarts first.
+	proofSetPolls.watch(proofSet)
+	return nil
+}
+
+// failStalledProofSet marks proofSet failed when its creation transaction
+// has gone staleAfter without producing a proof set ID while nothing polls
+// it, so it can be retried. It reports whether it did.
+func failStalledProofSet(tx *gorm.DB, proofSet *models.ProofSet, staleAfter time.Duration) bool {
+	if proofSet.Status != proofSetConfirming || proofSet.TransactionHash == "" || proofSet.ProofSetID != "" ||
+		time.Since(proofSet.UpdatedAt) < staleAfter || proofSetPolls.isWatching(proofSet.ID) {
+		return false
+	}
+	reason := fmt.Sprintf("creation transaction %s produced no proof set after %s", proofSet.TransactionHash, staleAfter)
+	if err := transitionProofSet(tx, proofSet, proofSetFailed, map[string]interface{}{
+		"failure_reason": reason,
+	}); err != nil {
+		authLog.WithField("proofSetID", proofSet.ID).Errorf("Failed to mark stalled proof set creation as failed: %v", err)
+		return false
+	}
+	authLog.WithField("proofSetID", proofSet.ID).WithField("userID", proofSet.UserID).Warn("Marked stalled proof set creation as failed")
+	publishUserEvent(proofSet.UserID, WSTypeProofSetStatus, proofSetEventFromModel(*proofSet))
+	return true
+}
+
+// RetryProofSet godoc
+// @Summary Retry Proof Set Creation
+// @Description Submits the creation of one of the caller's proof sets again after it failed, on the storage provider it was first submitted to, or the default provider if that one has since been disabled. The stale transaction hash is cleared, create-proof-set is run again in the background and the new transaction is polled; follow progress on /auth/status or the proof set status events. A creation that has been confirming without a proof set ID or a poller for longer than PROOF_SET_STALE_AFTER is treated as failed. Refused with 409 unless the creation failed, including while another attempt is running.
+// @Tags Proof Set
+// @Security ApiKeyAuth
+// @Param id path int true "Proof set database ID"
+// @Produce json
+// @Success 202 {object} map[string]interface{} "message:Proof set creation retry initiated"
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /proof-set/{id}/retry [post]
+func (h *AuthHandler) RetryProofSet(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Unauthorized: User ID not found in token"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Error: "User not found"})
+		return
+	}
+
+	proofSet, err := findUserProofSet(c.Param("id"), user.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, ErrorResponse{Error: "Proof set not found"})
+			return
+		}
+		authLog.WithField("userID", user.ID).Errorf("Error loading proof set to retry: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to load proof set"})
+		return
+	}
+
+	failStalledProofSet(h.db, &proofSet, h.cfg.Retry.ProofSetStaleAfter)
+	if proofSet.Status != proofSetFailed {
+		c.JSON(http.StatusConflict, ErrorResponse{Error: fmt.Sprintf("Only a failed proof set creation can be retried; this one is %s", proofSet.Status)})
+		return
+	}
+	provider, err := findStorageProvider(proofSet.ProviderID)
+	if errors.Is(err, gorm.ErrRecordNotFound) && proofSet.ProviderID != nil {
+		provider, err = defaultStorageProvider()
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			authLog.WithField("userID", user.ID).Error("No storage provider is configured")
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "No storage provider is configured"})
+			return
+		}
+		authLog.WithField("userID", user.ID).Errorf("Error loading storage provider: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to load storage provider"})
+		return
+	}
+	if err := h.startProofSetCreation(&proofSet, provider); err != nil {
+		if errors.Is(err, errProofSetTransition) {
+			c.JSON(http.StatusConflict, ErrorResponse{Error: "Proof set creation is already being retried"})
+			return
+		}
+		authLog.WithField("userID", user.ID).Errorf("Error starting proof set retry: %v", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to retry proof set creation"})
+		return
+	}
+
+	go func(u models.User, proofSet models.ProofSet) {
+		authLog.WithField("userID", u.ID).Info("Retrying proof set creation...")
+		if err := h.finishProofSetCreation(&u, proofSet, provider); err != nil {
+			authLog.WithField("userID", u.ID).Errorf("Proof set creation retry failed: %v", err)
+		}
+	}(user, proofSet)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Proof set creation retry initiated. Monitor /auth/status for readiness.",
+		"status":  proofSet.Status,
+	})
+}
+
+// submitProofSetCreation runs create-proof-set for user on provider and
+// returns the hash of the creation transaction.
+func (h *AuthHandler) submitProofSetCreation(user *models.User, provider models.StorageProvider) (string, error) {
 	pdptoolPath := h.cfg.PdptoolPath
 	if pdptoolPath == "" {
-		return errors.New("pdptool path not configured")
+		return "", errors.New("pdptool path not configured")
 	}
-	serviceName := h.cfg.ServiceName
-	serviceURL := h.cfg.ServiceURL
-	recordKeeper := h.cfg.RecordKeeper
+	serviceName := provider.ServiceName
+	serviceURL := provider.ServiceURL
+	recordKeeper := provider.RecordKeeper
 
 	if serviceName == "" || serviceURL == "" || recordKeeper == "" {
 		errMsg := "service name, service url, or record keeper not configured"
 		authLog.Error(errMsg)
-		return errors.New(errMsg)
+		return "", errors.New(errMsg)
 	}
 
 	// Change working directory to pdptool directory
@@ -307,10 +559,15 @@ func (h *AuthHandler) createProofSetForUser(user *models.User) error {
 	if err := os.Chdir(pdptoolDir); err != nil {
 		errMsg := fmt.Sprintf("Failed to change working directory to pdptool directory: %v", err)
 		authLog.Error(errMsg)
-		return errors.New(errMsg)
+		return "", errors.New(errMsg)
 	}
 	authLog.WithField("pdptoolDir", pdptoolDir).Info("Changed working directory to pdptool directory")
 
+	if _, err := ensureServiceSecret(context.Background(), pdptoolPath, ""); err != nil {
+		authLog.WithField("error", err.Error()).Error("[Goroutine Create] Failed to create service secret")
+		return "", err
+	}
+
 	authLog.Infof("[Goroutine Create] Creating proof set for user %d (Address: %s)...", user.ID, user.WalletAddress)
 
 	metadata := fmt.Sprintf("hotvault-user-%d", user.ID)
@@ -320,7 +577,7 @@ func (h *AuthHandler) createProofSetForUser(user *models.User) error {
 	if err != nil {
 		errMsg := fmt.Sprintf("[Goroutine Create] Failed to ABI encode extra data for user %d: %v", user.ID, err)
 		authLog.Error(errMsg)
-		return errors.New(errMsg)
+		return "", errors.New(errMsg)
 	}
 	authLog.WithField("extraDataHex", extraDataHex).Info("[Goroutine Create] ABI encoded extra data for user ", user.ID)
 
@@ -350,7 +607,7 @@ func (h *AuthHandler) createProofSetForUser(user *models.User) error {
 			"stdout":  createProofSetOutput.String(),
 			"command": pdptoolPath + " " + strings.Join(createProofSetArgs, " "),
 		}).Error(errMsg)
-		return errors.New(errMsg + ", stderr: " + createProofSetError.String())
+		return "", errors.New(errMsg + ", stderr: " + createProofSetError.String())
 	}
 
 	outputStr := createProofSetOutput.String()
@@ -358,198 +615,20 @@ func (h *AuthHandler) createProofSetForUser(user *models.User) error {
 
 	txHashRegex := regexp.MustCompile(`Location: /pdp/proof-sets/created/(0x[a-fA-F0-9]{64})`)
 	txHashMatches := txHashRegex.FindStringSubmatch(outputStr)
-	var txHash string
-
-	if len(txHashMatches) > 1 {
-		txHash = txHashMatches[1]
-		authLog.WithField("txHash", txHash).Infof("[Goroutine Create] Extracted transaction hash for user %d. Updating database and starting polling...", user.ID)
-
-		proofSetToUpdate := models.ProofSet{
-			UserID:          user.ID,
-			TransactionHash: txHash,
-			ServiceName:     serviceName,
-			ServiceURL:      serviceURL,
-		}
-		result := h.db.Where(models.ProofSet{UserID: user.ID}).Assign(proofSetToUpdate).FirstOrCreate(&models.ProofSet{})
-		if result.Error != nil {
-			errMsg := fmt.Sprintf("[Goroutine Create] Failed to save/update proof set with txHash for user %d: %v", user.ID, result.Error)
-			authLog.Error(errMsg)
-			return errors.New(errMsg)
-		}
-
-	} else {
+	if len(txHashMatches) < 2 {
 		authLog.Warn("[Goroutine Create] Could not extract transaction hash using Location regex for user ", user.ID, ". Check pdptool output format.")
 		errMsg := fmt.Sprintf("[Goroutine Create] Failed to extract transaction hash needed for polling for user %d. Output: %s", user.ID, outputStr)
 		authLog.Error(errMsg)
-		return errors.New(errMsg)
-	}
-
-	extractedID, pollErr := h.pollForProofSetID(pdptoolPath, serviceURL, serviceName, txHash, user)
-	if pollErr != nil {
-		authLog.Errorf("[Goroutine Create] Failed to poll for proof set ID for user %d: %v", user.ID, pollErr)
-		return pollErr
-	}
-
-	finalUpdate := models.ProofSet{
-		ProofSetID: extractedID,
-	}
-	result := h.db.Model(&models.ProofSet{}).Where("user_id = ?", user.ID).Updates(finalUpdate)
-	if result.Error != nil {
-		errMsg := fmt.Sprintf("[Goroutine Create] Failed to update proof set with ProofSetID for user %d: %v", user.ID, result.Error)
-		authLog.Error(errMsg)
-		return errors.New(errMsg)
-	}
-	if result.RowsAffected == 0 {
-		errMsg := fmt.Sprintf("[Goroutine Create] Failed to find proof set record for user %d to update with ProofSetID", user.ID)
-		authLog.Error(errMsg)
-		return errors.New(errMsg)
-	}
-	authLog.WithField("proofSetPdpID", extractedID).Infof("[Goroutine Create] Successfully updated proof set with ID for user %d", user.ID)
-	return nil
-}
-
-func (h *AuthHandler) pollForProofSetID(pdptoolPath, serviceURL, serviceName, txHash string, user *models.User) (string, error) {
-	proofSetIDRegex := regexp.MustCompile(`ProofSet ID:[ \t]*(\d+)`)
-	creationStatusRegex := regexp.MustCompile(`Proofset Created:[ \t]*(true|false)`)
-	txStatusRegex := regexp.MustCompile(`Transaction Status:[ \t]*(confirmed|pending|failed)`)
-	txSuccessRegex := regexp.MustCompile(`Transaction Successful:[ \t]*(true|false|Pending)`)
-
-	// Change working directory to pdptool directory
-	pdptoolDir := getPdptoolParentDir(pdptoolPath)
-	if err := os.Chdir(pdptoolDir); err != nil {
-		errMsg := fmt.Sprintf("Failed to change working directory to pdptool directory: %v", err)
-		authLog.Error(errMsg)
 		return "", errors.New(errMsg)
 	}
-	authLog.WithField("pdptoolDir", pdptoolDir).Info("Changed working directory to pdptool directory")
-
-	sleepDuration := 10 * time.Second
-	attemptCounter := 0
-	const maxLogInterval = 6
-
-	authLog.WithField("txHash", txHash).Info("[Goroutine Polling] Starting polling for ProofSet ID for user ", user.ID)
-
-	for {
-		attemptCounter++
-		getStatusCmd := exec.Command(
-			pdptoolPath,
-			"get-proof-set-create-status",
-			"--service-url", serviceURL,
-			"--service-name", serviceName,
-			"--tx-hash", txHash,
-		)
-
-		var getStatusOutput bytes.Buffer
-		var getStatusError bytes.Buffer
-		getStatusCmd.Stdout = &getStatusOutput
-		getStatusCmd.Stderr = &getStatusError
-
-		cmdString := fmt.Sprintf("%s %s", pdptoolPath, strings.Join(getStatusCmd.Args[1:], " "))
-		authLog.WithField("command", cmdString).
-			WithField("attempt", attemptCounter).
-			WithField("txHash", txHash).
-			WithField("userID", user.ID).
-			Info("[Goroutine Polling] Executing get-proof-set-create-status command")
-
-		err := getStatusCmd.Run()
-		statusOutput := getStatusOutput.String()
-		statusStderr := getStatusError.String()
-
-		if err != nil {
-			authLog.WithField("error", err.Error()).
-				WithField("stderr", statusStderr).
-				WithField("command", cmdString).
-				WithField("attempt", attemptCounter).
-				WithField("userID", user.ID).
-				Warnf("[Goroutine Polling] Failed to run get-proof-set-create-status command, retrying in %v...", sleepDuration)
-			time.Sleep(sleepDuration)
-			continue
-		}
-
-		authLog.WithField("statusOutput", statusOutput).
-			WithField("attempt", attemptCounter).
-			WithField("userID", user.ID).
-			WithField("txHash", txHash).
-			Info("[Goroutine Polling] get-proof-set-create-status command output")
-
-		txStatusMatch := txStatusRegex.FindStringSubmatch(statusOutput)
-		txSuccessMatch := txSuccessRegex.FindStringSubmatch(statusOutput)
-		createdMatch := creationStatusRegex.FindStringSubmatch(statusOutput)
-		idMatch := proofSetIDRegex.FindStringSubmatch(statusOutput)
-
-		var txStatus, txSuccess, createdStatus string
-		if len(txStatusMatch) > 1 {
-			txStatus = txStatusMatch[1]
-		}
-		if len(txSuccessMatch) > 1 {
-			txSuccess = txSuccessMatch[1]
-		}
-		if len(createdMatch) > 1 {
-			createdStatus = createdMatch[1]
-		}
-
-		// Log the status details for each polling attempt
-		var idMatchValue string
-		if len(idMatch) > 1 {
-			idMatchValue = idMatch[1]
-		} else {
-			idMatchValue = "none"
-		}
-
-		authLog.WithFields(logrus.Fields{
-			"userID":        user.ID,
-			"txHash":        txHash,
-			"attempt":       attemptCounter,
-			"txStatus":      txStatus,
-			"txSuccess":     txSuccess,
-			"createdStatus": createdStatus,
-			"idFound":       len(idMatch) > 1,
-			"idMatch":       idMatchValue,
-		}).Info("[Goroutine Polling] Current proof set creation status")
-
-		if txStatus == "confirmed" && txSuccess == "true" && createdStatus == "true" && len(idMatch) > 1 {
-			proofSetIDStr := idMatch[1]
-			authLog.WithField("proofSetID", proofSetIDStr).WithField("attempts", attemptCounter).Infof("[Goroutine Polling] Successfully extracted proof set ID for user %d", user.ID)
-			return proofSetIDStr, nil
-		}
-
-		if txStatus == "confirmed" && txSuccess == "true" && createdStatus == "false" {
-			authLog.Infof("[Goroutine Polling] Attempt %d: Transaction confirmed for user %d, but proofset creation still processing (TxStatus: %s, TxSuccess: %s, CreatedStatus: %s)... Polling again in %v.",
-				attemptCounter, user.ID, txStatus, txSuccess, createdStatus, sleepDuration)
-			time.Sleep(sleepDuration)
-			continue
-		}
-
-		if txStatus == "confirmed" && (txSuccess == "false" || (createdStatus == "true" && len(idMatch) == 0)) {
-			authLog.Errorf("[Goroutine Polling] Proof set creation failed or stalled for user %d (TxStatus: %s, TxSuccess: %s, CreatedStatus: %s, ID Found: %t). Output: %s",
-				user.ID, txStatus, txSuccess, createdStatus, len(idMatch) > 1, statusOutput)
-			return "", fmt.Errorf("proof set creation failed or stalled post-confirmation for tx %s (status: %s, success: %s, created: %s)", txHash, txStatus, txSuccess, createdStatus)
-		}
-
-		if txStatus == "failed" {
-			authLog.Errorf("[Goroutine Polling] Proof set creation transaction failed for user %d (TxStatus: %s). Output: %s",
-				user.ID, txStatus, statusOutput)
-			return "", fmt.Errorf("proof set creation transaction failed for tx %s (status: %s)", txHash, txStatus)
-		}
-
-		if txStatus == "pending" || txStatus == "" {
-			authLog.Infof("[Goroutine Polling] Attempt %d: Proof set creation still pending for user %d (TxStatus: '%s')... Polling again in %v.", attemptCounter, user.ID, txStatus, sleepDuration)
-			if attemptCounter%maxLogInterval == 0 {
-				authLog.WithField("attempt", attemptCounter).Info("[Goroutine Polling] Still waiting for proof set ID for user ", user.ID, " (TxHash: ", txHash, ")")
-			}
-			time.Sleep(sleepDuration)
-			continue
-		}
-
-		authLog.Warnf("[Goroutine Polling] Attempt %d: Encountered unhandled status for user %d (TxStatus: %s, TxSuccess: %s, CreatedStatus: %s). Retrying in %v... Output: %s",
-			attemptCounter, user.ID, txStatus, txSuccess, createdStatus, sleepDuration, statusOutput)
-		time.Sleep(sleepDuration)
-	}
+	txHash := txHashMatches[1]
+	authLog.WithField("txHash", txHash).Infof("[Goroutine Create] Extracted transaction hash for user %d. Updating database and starting polling...", user.ID)
+	return txHash, nil
 }
 
 // CheckAuthStatus godoc
 // @Summary Check Authentication Status
-// @Description Checks if the user is authenticated via cookie and if their proof set is ready
+// @Description Checks if the user is authenticated via cookie and the status of their proof set: pending, submitting, confirming, ready, failed (with proofSetError giving the reason) or decommissioning
 // @Tags Authentication
 // @Produce json
 // @Success 200 {object} StatusResponse
@@ -595,22 +674,25 @@ func (h *AuthHandler) CheckAuthStatus(c *gin.Context) {
 	isReady := false
 	isInitiated := false
 	if err := h.db.Where("user_id = ?", claims.UserID).First(&proofSet).Error; err == nil {
-		if proofSet.ProofSetID != "" {
-			isReady = true
-		}
-		if proofSet.TransactionHash != "" {
-			isInitiated = true
-		}
+		isReady = proofSet.Status == proofSetReady
+		isInitiated = isReady || proofSet.Status == proofSetConfirming || proofSet.Status == proofSetDecommissioning
 	} else if err != gorm.ErrRecordNotFound {
 		authLog.WithField("userID", claims.UserID).Errorf("Error checking proof set readiness in /auth/status: %v", err)
 	}
 
-	c.JSON(http.StatusOK, StatusResponse{
+	response := StatusResponse{
 		Authenticated:     true,
 		Address:           claims.WalletAddress,
 		ProofSetReady:     isReady,
 		ProofSetInitiated: isInitiated,
-	})
+		ProofSetStatus:    proofSet.Status,
+		ProofSetError:     proofSet.FailureReason,
+	}
+	if isReady {
+		response.ProvingHealth = proofSet.ProvingHealth
+		response.ProvingHealthy = provingHealthFlag(proofSet.ProvingHealth)
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // Logout godoc
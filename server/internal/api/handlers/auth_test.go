@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+)
+
+// createTestStorageProvider stores the default storage provider, at the
+// test config's service.
+func createTestStorageProvider(t *testing.T) models.StorageProvider {
+	t.Helper()
+	provider := models.StorageProvider{
+		Name:         defaultStorageProviderName,
+		ServiceURL:   cfg.ServiceURL,
+		ServiceName:  cfg.ServiceName,
+		RecordKeeper: "0x" + strings.Repeat("11", 20),
+		Enabled:      true,
+	}
+	if err := db.Create(&provider).Error; err != nil {
+		t.Fatalf("create storage provider: %v", err)
+	}
+	return provider
+}
+
+// fakePdptoolCreatingProofSets installs a fake pdptool whose
+// create-proof-set sends transaction txHash once release exists, when it is
+// set, and whose get-proof-set-create-status reports proof set 77 created.
+// It returns the path of its calls file.
+func fakePdptoolCreatingProofSets(t *testing.T, txHash, release string) string {
+	t.Helper()
+	wait := ""
+	if release != "" {
+		wait = "while [ ! -f " + release + " ]; do sleep 0.01; done; "
+	}
+	return fakePdptool(t, `case "$1" in
+create-proof-set) `+wait+`printf 'HTTP/1.1 201 Created\nLocation: /pdp/proof-sets/created/`+txHash+`\n';;
+get-proof-set-create-status) printf 'Transaction Status: confirmed\nTransaction Successful: true\nProofset Created: true\nProofSet ID: 77\n';;
+esac`)
+}
+
+func retryProofSet(proofSet models.ProofSet, user models.User) *httptest.ResponseRecorder {
+	id := strconv.FormatUint(uint64(proofSet.ID), 10)
+	c, recorder := newTestContext(http.MethodPost, "/api/v1/proof-set/"+id+"/retry", nil, user)
+	c.Params = gin.Params{{Key: "id", Value: id}}
+	(&AuthHandler{db: db, cfg: cfg}).RetryProofSet(c)
+	return recorder
+}
+
+func TestRetryProofSetAfterTransactionFailure(t *testing.T) {
+	useTestProofSetPolling(t)
+	useTestProofSetPolls(t)
+	createTestStorageProvider(t)
+	user := createTestUser(t, "0x"+strings.Repeat("ab", 20))
+	failedTx := "0x" + strings.Repeat("0f", 32)
+	proofSet := createCreatingProofSet(t, user, proofSetFailed, failedTx)
+	db.Model(&proofSet).Update("failure_reason", "transaction failed for tx "+failedTx)
+	retryTx := "0x" + strings.Repeat("a1", 32)
+	calls := fakePdptoolCreatingProofSets(t, retryTx, "")
+
+	if recorder := retryProofSet(proofSet, createTestUser(t, "0x2")); recorder.Code != http.StatusNotFound {
+		t.Errorf("another user's proof set: status = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+	recorder := retryProofSet(proofSet, user)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusAccepted, recorder.Body)
+	}
+
+	retried := waitForProofSetStatus(t, proofSet.ID, proofSetReady)
+	if retried.ProofSetID != "77" || retried.TransactionHash != retryTx {
+		t.Errorf("proof set ID %q from tx %s, want 77 from %s", retried.ProofSetID, retried.TransactionHash, retryTx)
+	}
+	if n := len(pdptoolCalls(t, calls, "create-proof-set")); n != 1 {
+		t.Errorf("create-proof-set ran %d times, want 1", n)
+	}
+	for _, call := range pdptoolCalls(t, calls, "get-proof-set-create-status") {
+		if !strings.Contains(call, "--tx-hash "+retryTx) {
+			t.Errorf("polled a transaction other than the retry's: %s", call)
+		}
+	}
+}
+
+func TestRetryProofSetWhileAnotherAttemptRuns(t *testing.T) {
+	useTestProofSetPolling(t)
+	useTestProofSetPolls(t)
+	createTestStorageProvider(t)
+	user := createTestUser(t, "0x"+strings.Repeat("ab", 20))
+	proofSet := createCreatingProofSet(t, user, proofSetFailed, "0x"+strings.Repeat("0f", 32))
+	// The first retry's create-proof-set runs until release exists.
+	release := filepath.Join(t.TempDir(), "release")
+	calls := fakePdptoolCreatingProofSets(t, "0x"+strings.Repeat("a1", 32), release)
+
+	if recorder := retryProofSet(proofSet, user); recorder.Code != http.StatusAccepted {
+		t.Fatalf("first retry: status = %d, want %d: %s", recorder.Code, http.StatusAccepted, recorder.Body)
+	}
+	if recorder := retryProofSet(proofSet, user); recorder.Code != http.StatusConflict {
+		t.Errorf("retry while the first runs: status = %d, want %d", recorder.Code, http.StatusConflict)
+	}
+
+	if err := os.WriteFile(release, nil, 0644); err != nil {
+		t.Fatalf("release create-proof-set: %v", err)
+	}
+	waitForProofSetStatus(t, proofSet.ID, proofSetReady)
+	if recorder := retryProofSet(proofSet, user); recorder.Code != http.StatusConflict {
+		t.Errorf("retry of a ready proof set: status = %d, want %d", recorder.Code, http.StatusConflict)
+	}
+	if n := len(pdptoolCalls(t, calls, "create-proof-set")); n != 1 {
+		t.Errorf("create-proof-set ran %d times, want 1", n)
+	}
+}
+
+func TestFailStalledProofSet(t *testing.T) {
+	useTestProofSetPolling(t)
+	previous := proofSetPolls
+	proofSetPolls = &proofSetPoller{watching: make(map[uint]bool)}
+	t.Cleanup(func() { proofSetPolls = previous })
+	txHash := "0x" + strings.Repeat("cd", 32)
+	staleAfter := time.Hour
+
+	tests := []struct {
+		name     string
+		status   string
+		age      time.Duration
+		watched  bool
+		wantFail bool
+	}{
+		{"stale creation", proofSetConfirming, 2 * time.Hour, false, true},
+		{"recent creation", proofSetConfirming, time.Minute, false, false},
+		{"stale creation still polled", proofSetConfirming, 2 * time.Hour, true, false},
+		{"ready proof set", proofSetReady, 2 * time.Hour, false, false},
+	}
+	for i, test := range tests {
+		proofSet := createCreatingProofSet(t, createTestUser(t, "0x"+strconv.Itoa(i+1)), test.status, txHash)
+		db.Model(&proofSet).UpdateColumn("updated_at", time.Now().Add(-test.age))
+		db.First(&proofSet, proofSet.ID)
+		proofSetPolls.watching[proofSet.ID] = test.watched
+
+		failed := failStalledProofSet(db, &proofSet, staleAfter)
+		db.First(&proofSet, proofSet.ID)
+		if failed != test.wantFail || (proofSet.Status == proofSetFailed) != test.wantFail {
+			t.Errorf("%s: failStalledProofSet = %v, status %s", test.name, failed, proofSet.Status)
+		}
+	}
+}
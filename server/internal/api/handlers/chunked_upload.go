@@ -1,19 +1,23 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/hotvault/backend/internal/models"
 )
 
 type ChunkedUploadInfo struct {
@@ -25,11 +29,39 @@ type ChunkedUploadInfo struct {
 	TotalChunks    int          `json:"totalChunks"`
 	UploadedChunks int          `json:"uploadedChunks"`
 	ChunksReceived map[int]bool `json:"-"`
-	TempDir        string       `json:"-"`
-	Status         string       `json:"status"`
-	CreatedAt      time.Time    `json:"createdAt"`
-	UpdatedAt      time.Time    `json:"updatedAt"`
-	FileType       string       `json:"fileType"`
+	// ChunkBytes is the number of bytes written for each received chunk
+	// that hasn't been appended to the assembled file yet.
+	ChunkBytes map[int]int64 `json:"-"`
+	// chunksWriting holds chunks being written, so a retry of the same
+	// chunk can't write over it at the same time.
+	chunksWriting map[int]bool
+	TempDir       string    `json:"-"`
+	Status        string    `json:"status"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	FileType      string    `json:"fileType"`
+	// ContentType is sniffed from the first chunk when the upload completes,
+	// falling back to FileType when sniffing can't tell.
+	ContentType string `json:"contentType,omitempty"`
+	// Compress asks for the assembled file to be gzipped before upload.
+	Compress bool `json:"compress"`
+	// FileHash is the hex SHA-256 the client says the whole file has. The
+	// assembled file is checked against it before anything is stored.
+	FileHash string `json:"fileHash,omitempty"`
+	// JobID is the upload job storing the file once it has been assembled.
+	JobID string `json:"jobId,omitempty"`
+	// Metadata is given to the piece, as sent with the complete request.
+	Metadata models.Metadata `json:"metadata,omitempty"`
+	// RetainUntil is when the piece is to be removed, as sent with the
+	// complete request.
+	RetainUntil *time.Time `json:"retainUntil,omitempty"`
+	// AssembledChunks is how many leading chunks have been appended to the
+	// assembled file and had their chunk files deleted.
+	AssembledChunks int `json:"assembledChunks"`
+	assembly        *chunkAssembly
+	// saves orders the writes of the upload's row, so an older state can't
+	// be saved over a newer one, nor the row saved again once deleted.
+	saves *sync.Mutex
 }
 
 var (
@@ -37,33 +69,6 @@ var (
 	chunkedUploadsMutex sync.RWMutex
 )
 
-func init() {
-	go func() {
-		ticker := time.NewTicker(1 * time.Hour)
-		defer ticker.Stop()
-		for range ticker.C {
-			cleanupOldChunkedUploads()
-		}
-	}()
-}
-
-func cleanupOldChunkedUploads() {
-	threshold := time.Now().Add(-24 * time.Hour)
-
-	chunkedUploadsMutex.Lock()
-	defer chunkedUploadsMutex.Unlock()
-
-	for id, info := range chunkedUploads {
-		if info.UpdatedAt.Before(threshold) {
-			if info.TempDir != "" {
-				os.RemoveAll(info.TempDir)
-			}
-			delete(chunkedUploads, id)
-			log.WithField("uploadId", id).Info("Cleaned up expired chunked upload")
-		}
-	}
-}
-
 func InitChunkedUpload(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -79,6 +84,8 @@ func InitChunkedUpload(c *gin.Context) {
 		ChunkSize   int64  `json:"chunkSize" binding:"required"`
 		TotalChunks int    `json:"totalChunks" binding:"required"`
 		FileType    string `json:"fileType" binding:"required"`
+		Compress    bool   `json:"compress"`
+		FileHash    string `json:"fileHash"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -88,8 +95,46 @@ func InitChunkedUpload(c *gin.Context) {
 		return
 	}
 
+	fileHash, ok := normalizeFileHash(request.FileHash)
+	if !ok {
+		respondInvalidFileHash(c)
+		return
+	}
+
+	if err := validateChunkLayout(request.TotalSize, request.ChunkSize, request.TotalChunks); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":        err.Error(),
+			"minChunkSize": cfg.Upload.MinChunkSize,
+			"maxChunkSize": cfg.Upload.MaxChunkSize,
+			"maxChunks":    cfg.Upload.MaxChunks,
+		})
+		return
+	}
+
+	if report := uploadPreflight(c.Request.Context(), userID.(uint), request.TotalSize); !report.Ready {
+		if check, _ := report.failure(); check.Name == "size" {
+			respondFileTooLarge(c, cfg.Upload.MaxSize)
+			return
+		}
+		respondPreflightFailure(c, report)
+		return
+	}
+
+	if !cfg.Upload.PadFiles && pieceNeedsPadding(request.TotalSize) {
+		respondUnalignedSize(c, request.Filename, request.TotalSize)
+		return
+	}
+
+	// The chunks and the file assembled from them are on disk together, so
+	// the upload needs room for twice its size.
+	headroom, checked := chunkedUploadHeadroom()
+	if checked && headroom < request.TotalSize*2 {
+		respondInsufficientStorage(c, request.TotalSize*2, headroom)
+		return
+	}
+
 	uploadID := uuid.New().String()
-	tempDir := filepath.Join(os.TempDir(), "chunked_uploads", uploadID)
+	tempDir := filepath.Join(chunkedUploadsRoot(), uploadID)
 
 	if err := os.MkdirAll(tempDir, 0755); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -113,11 +158,15 @@ func InitChunkedUpload(c *gin.Context) {
 		CreatedAt:      now,
 		UpdatedAt:      now,
 		FileType:       request.FileType,
+		Compress:       request.Compress,
+		FileHash:       fileHash,
 	}
 
 	chunkedUploadsMutex.Lock()
 	chunkedUploads[uploadID] = uploadInfo
 	chunkedUploadsMutex.Unlock()
+	saveChunkedUpload(uploadInfo)
+	publishChunkedUpload(uploadInfo)
 
 	log.WithField("uploadId", uploadID).
 		WithField("filename", request.Filename).
@@ -125,11 +174,15 @@ func InitChunkedUpload(c *gin.Context) {
 		WithField("totalChunks", request.TotalChunks).
 		Info("Initialized chunked upload")
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"uploadId":    uploadID,
 		"message":     "Chunked upload initialized successfully",
 		"totalChunks": request.TotalChunks,
-	})
+	}
+	if checked {
+		response["headroomBytes"] = headroom
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func UploadChunk(c *gin.Context) {
@@ -190,20 +243,63 @@ func UploadChunk(c *gin.Context) {
 		return
 	}
 
-	chunkedUploadsMutex.RLock()
-	_, chunkExists := uploadInfo.ChunksReceived[chunkIndex]
-	chunkedUploadsMutex.RUnlock()
-
-	if chunkExists {
+	// Check and claim the chunk in one step, so concurrent retries of the
+	// same chunk neither both write it nor both count it.
+	chunkedUploadsMutex.Lock()
+	if chunkedUploadHandedOff(uploadInfo.Status) {
+		status := uploadInfo.Status
+		chunkedUploadsMutex.Unlock()
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  "Upload is already being processed",
+			"status": status,
+		})
+		return
+	}
+	if uploadInfo.ChunksReceived[chunkIndex] {
+		uploadedChunks := uploadInfo.UploadedChunks
+		chunkedUploadsMutex.Unlock()
 		c.JSON(http.StatusOK, gin.H{
-			"message":        fmt.Sprintf("Chunk %d already received", chunkIndex),
-			"uploadId":       uploadID,
-			"chunkIndex":     chunkIndex,
-			"uploadedChunks": uploadInfo.UploadedChunks,
-			"totalChunks":    uploadInfo.TotalChunks,
+			"message":           fmt.Sprintf("Chunk %d already received", chunkIndex),
+			"uploadId":          uploadID,
+			"chunkIndex":        chunkIndex,
+			"uploadedChunks":    uploadedChunks,
+			"totalChunks":       uploadInfo.TotalChunks,
+			"allChunksReceived": uploadedChunks == uploadInfo.TotalChunks,
 		})
 		return
 	}
+	if uploadInfo.chunksWriting[chunkIndex] {
+		chunkedUploadsMutex.Unlock()
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      fmt.Sprintf("Chunk %d is already being received", chunkIndex),
+			"chunkIndex": chunkIndex,
+		})
+		return
+	}
+	if uploadInfo.chunksWriting == nil {
+		uploadInfo.chunksWriting = make(map[int]bool)
+	}
+	uploadInfo.chunksWriting[chunkIndex] = true
+	remaining := uploadInfo.TotalSize - int64(uploadInfo.UploadedChunks)*uploadInfo.ChunkSize
+	chunkedUploadsMutex.Unlock()
+
+	received := false
+	defer func() {
+		if !received {
+			chunkedUploadsMutex.Lock()
+			delete(uploadInfo.chunksWriting, chunkIndex)
+			chunkedUploadsMutex.Unlock()
+		}
+	}()
+
+	// Other uploads may have used the space since this one was initialized.
+	if remaining < 0 {
+		remaining = 0
+	}
+	if headroom, ok := chunkedUploadHeadroom(); ok && headroom < remaining+uploadInfo.TotalSize {
+		respondInsufficientStorage(c, remaining+uploadInfo.TotalSize, headroom)
+		return
+	}
 
 	file, err := c.FormFile("chunk")
 	if err != nil {
@@ -213,6 +309,12 @@ func UploadChunk(c *gin.Context) {
 		return
 	}
 
+	expectedSize := expectedChunkSize(uploadInfo, chunkIndex)
+	if file.Size != expectedSize {
+		respondChunkSizeMismatch(c, chunkIndex, expectedSize, file.Size)
+		return
+	}
+
 	src, err := file.Open()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -222,17 +324,42 @@ func UploadChunk(c *gin.Context) {
 	}
 	defer src.Close()
 
-	chunkPath := filepath.Join(uploadInfo.TempDir, fmt.Sprintf("chunk_%d", chunkIndex))
-	dst, err := os.Create(chunkPath)
+	// Write to a temporary name first so a failed write never leaves a
+	// partial file under the chunk's name.
+	chunkPath := chunkFilePath(uploadInfo.TempDir, chunkIndex)
+	partPath := chunkPath + ".part"
+	dst, err := os.Create(partPath)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to create chunk file: " + err.Error(),
 		})
 		return
 	}
-	defer dst.Close()
 
-	if _, err = io.Copy(dst, src); err != nil {
+	written, err := io.Copy(dst, src)
+	if err != nil {
+		dst.Close()
+		os.Remove(partPath)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to save chunk data: " + err.Error(),
+		})
+		return
+	}
+	if written != expectedSize {
+		dst.Close()
+		os.Remove(partPath)
+		respondChunkSizeMismatch(c, chunkIndex, expectedSize, written)
+		return
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(partPath)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to save chunk data: " + err.Error(),
+		})
+		return
+	}
+	if err := os.Rename(partPath, chunkPath); err != nil {
+		os.Remove(partPath)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to save chunk data: " + err.Error(),
 		})
@@ -240,19 +367,29 @@ func UploadChunk(c *gin.Context) {
 	}
 
 	chunkedUploadsMutex.Lock()
+	delete(uploadInfo.chunksWriting, chunkIndex)
 	uploadInfo.ChunksReceived[chunkIndex] = true
-	uploadInfo.UploadedChunks++
+	if uploadInfo.ChunkBytes == nil {
+		uploadInfo.ChunkBytes = make(map[int]int64)
+	}
+	uploadInfo.ChunkBytes[chunkIndex] = written
+	uploadInfo.UploadedChunks = len(uploadInfo.ChunksReceived)
 	uploadInfo.UpdatedAt = time.Now()
 	if uploadInfo.UploadedChunks == uploadInfo.TotalChunks {
 		uploadInfo.Status = "allChunksReceived"
 	} else {
 		uploadInfo.Status = "inProgress"
 	}
+	uploadedChunks := uploadInfo.UploadedChunks
 	chunkedUploadsMutex.Unlock()
+	received = true
+	saveChunkedUpload(uploadInfo)
+	publishChunkedUpload(uploadInfo)
+	appendChunksInBackground(uploadInfo)
 
 	log.WithField("uploadId", uploadID).
 		WithField("chunkIndex", chunkIndex).
-		WithField("uploadedChunks", uploadInfo.UploadedChunks).
+		WithField("uploadedChunks", uploadedChunks).
 		WithField("totalChunks", uploadInfo.TotalChunks).
 		Info("Received chunk")
 
@@ -260,9 +397,9 @@ func UploadChunk(c *gin.Context) {
 		"message":           fmt.Sprintf("Chunk %d received successfully", chunkIndex),
 		"uploadId":          uploadID,
 		"chunkIndex":        chunkIndex,
-		"uploadedChunks":    uploadInfo.UploadedChunks,
+		"uploadedChunks":    uploadedChunks,
 		"totalChunks":       uploadInfo.TotalChunks,
-		"allChunksReceived": uploadInfo.UploadedChunks == uploadInfo.TotalChunks,
+		"allChunksReceived": uploadedChunks == uploadInfo.TotalChunks,
 	})
 }
 
@@ -276,7 +413,11 @@ func CompleteChunkedUpload(c *gin.Context) {
 	}
 
 	var request struct {
-		UploadID string `json:"uploadId" binding:"required"`
+		UploadID      string          `json:"uploadId" binding:"required"`
+		FileHash      string          `json:"fileHash"`
+		Metadata      json.RawMessage `json:"metadata"`
+		RetainUntil   string          `json:"retainUntil"`
+		RetentionDays int             `json:"retentionDays"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -286,6 +427,30 @@ func CompleteChunkedUpload(c *gin.Context) {
 		return
 	}
 
+	fileHash, ok := normalizeFileHash(request.FileHash)
+	if !ok {
+		respondInvalidFileHash(c)
+		return
+	}
+
+	var metadata models.Metadata
+	if len(request.Metadata) > 0 && string(request.Metadata) != "null" {
+		var err error
+		if metadata, err = parseMetadata(request.Metadata); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": metadataError(err),
+			})
+			return
+		}
+	}
+	retainUntil, err := parseRetention(request.RetainUntil, request.RetentionDays)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": retentionError(err),
+		})
+		return
+	}
+
 	chunkedUploadsMutex.RLock()
 	uploadInfo, exists := chunkedUploads[request.UploadID]
 	chunkedUploadsMutex.RUnlock()
@@ -304,23 +469,61 @@ func CompleteChunkedUpload(c *gin.Context) {
 		return
 	}
 
-	if uploadInfo.UploadedChunks != uploadInfo.TotalChunks {
+	// Claim the upload for assembly in one step, so a repeated complete
+	// request can't start a second assembly.
+	chunkedUploadsMutex.Lock()
+	if chunkedUploadHandedOff(uploadInfo.Status) {
+		status := uploadInfo.Status
+		chunkedUploadsMutex.Unlock()
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  "Upload is already being processed",
+			"status": status,
+		})
+		return
+	}
+	uploadedChunks := len(uploadInfo.ChunksReceived)
+	if uploadedChunks != uploadInfo.TotalChunks {
+		chunkedUploadsMutex.Unlock()
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": fmt.Sprintf("Not all chunks received. Got %d of %d chunks",
-				uploadInfo.UploadedChunks, uploadInfo.TotalChunks),
-			"uploadedChunks": uploadInfo.UploadedChunks,
+				uploadedChunks, uploadInfo.TotalChunks),
+			"uploadedChunks": uploadedChunks,
 			"totalChunks":    uploadInfo.TotalChunks,
 		})
 		return
 	}
-
-	chunkedUploadsMutex.Lock()
+	if badChunks := unusableChunks(uploadInfo); len(badChunks) > 0 {
+		// Forget the bad chunks so the client re-sends just those.
+		for _, index := range badChunks {
+			delete(uploadInfo.ChunksReceived, index)
+			delete(uploadInfo.ChunkBytes, index)
+			os.Remove(chunkFilePath(uploadInfo.TempDir, index))
+		}
+		uploadInfo.UploadedChunks = len(uploadInfo.ChunksReceived)
+		uploadInfo.Status = "inProgress"
+		chunkedUploadsMutex.Unlock()
+		saveChunkedUpload(uploadInfo)
+		publishChunkedUpload(uploadInfo)
+
+		log.WithField("uploadId", uploadInfo.ID).WithField("badChunks", badChunks).Warning("Chunked upload has chunks of the wrong size")
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":     fmt.Sprintf("%d chunks have the wrong size and must be sent again", len(badChunks)),
+			"badChunks": badChunks,
+		})
+		return
+	}
 	uploadInfo.Status = "assembling"
+	if fileHash != "" {
+		uploadInfo.FileHash = fileHash
+	}
+	uploadInfo.Metadata = metadata
+	uploadInfo.RetainUntil = retainUntil
 	chunkedUploadsMutex.Unlock()
 
-	jobID := uuid.New().String()
-
-	go assembleAndProcessFile(uploadInfo, jobID, userID.(uint))
+	jobID, ok := startChunkedUploadProcessing(c, uploadInfo, userID.(uint))
+	if !ok {
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":  "Finalizing chunked upload",
@@ -330,6 +533,62 @@ func CompleteChunkedUpload(c *gin.Context) {
 	})
 }
 
+// startChunkedUploadProcessing checks the content type of an upload that has
+// been claimed for assembly and starts assembling and storing it in the
+// background. On failure it writes the error response and returns false.
+func startChunkedUploadProcessing(c *gin.Context, uploadInfo *ChunkedUploadInfo, userID uint) (string, bool) {
+	// The start of the file is in the assembled file once its first chunk
+	// has been appended, and in the chunk files otherwise.
+	chunkedUploadsMutex.RLock()
+	assembledChunks := uploadInfo.AssembledChunks
+	chunkedUploadsMutex.RUnlock()
+	var samplePaths []string
+	if assembledChunks > 0 {
+		samplePaths = append(samplePaths, assembledFilePath(uploadInfo.TempDir))
+	}
+	for i := assembledChunks; i < uploadInfo.TotalChunks; i++ {
+		samplePaths = append(samplePaths, chunkFilePath(uploadInfo.TempDir, i))
+	}
+	contentType, err := detectFileContentType(samplePaths...)
+	if err != nil {
+		log.WithField("uploadId", uploadInfo.ID).WithField("error", err.Error()).Error("Failed to detect content type of chunked upload")
+		chunkedUploadsMutex.Lock()
+		uploadInfo.Status = "allChunksReceived"
+		chunkedUploadsMutex.Unlock()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to inspect uploaded file",
+		})
+		return "", false
+	}
+	if !mimeTypeAllowed(contentType) {
+		log.WithField("uploadId", uploadInfo.ID).WithField("contentType", contentType).Warning("Rejected chunked upload with disallowed content type")
+
+		chunkedUploadsMutex.Lock()
+		uploadInfo.Status = "rejected"
+		chunkedUploadsMutex.Unlock()
+		forgetChunkedUpload(uploadInfo.ID)
+		publishChunkedUpload(uploadInfo)
+		os.RemoveAll(uploadInfo.TempDir)
+
+		respondUnsupportedMediaType(c, uploadInfo.Filename, contentType)
+		return "", false
+	}
+
+	chunkedUploadsMutex.Lock()
+	uploadInfo.ContentType = contentTypeHint(contentType, uploadInfo.FileType)
+	chunkedUploadsMutex.Unlock()
+	saveChunkedUpload(uploadInfo)
+	publishChunkedUpload(uploadInfo)
+
+	jobID := uuid.New().String()
+	chunkedUploadsMutex.Lock()
+	uploadInfo.JobID = jobID
+	chunkedUploadsMutex.Unlock()
+
+	go assembleAndProcessFile(uploadInfo, jobID, userID)
+	return jobID, true
+}
+
 func GetChunkedUploadStatus(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -377,16 +636,17 @@ func GetChunkedUploadStatus(c *gin.Context) {
 }
 
 func assembleAndProcessFile(uploadInfo *ChunkedUploadInfo, jobID string, userID uint) {
-	uploadJobsLock.Lock()
-	uploadJobs[jobID] = UploadProgress{
+	createUploadJob(jobID, userID, UploadProgress{
 		Status:    "assembling",
 		Progress:  0,
 		Message:   "Assembling file chunks",
 		Filename:  uploadInfo.Filename,
 		TotalSize: uploadInfo.TotalSize,
-		JobID:     jobID,
-	}
-	uploadJobsLock.Unlock()
+	})
+	setJobTempDir(jobID, uploadInfo.TempDir)
+	setJobMetadata(jobID, uploadInfo.Metadata)
+	setJobRetention(jobID, uploadInfo.RetainUntil)
+	ctx := jobContext(jobID)
 
 	chunkedUploadsMutex.Lock()
 	uploadInfo.Status = "assembling"
@@ -399,146 +659,79 @@ func assembleAndProcessFile(uploadInfo *ChunkedUploadInfo, jobID string, userID
 			Error:   "Failed to locate temporary directory",
 			Message: fmt.Sprintf("Directory %s doesn't exist", uploadInfo.TempDir),
 		})
+		dropChunkedUpload(uploadInfo, "failed")
 		return
 	}
 
-	finalFilePath := filepath.Join(uploadInfo.TempDir, uploadInfo.Filename)
-
-	if _, err := os.Stat(finalFilePath); err == nil {
-		log.WithField("finalFilePath", finalFilePath).Info("Final file already exists, removing it")
-		if err := os.Remove(finalFilePath); err != nil {
-			log.WithField("error", err.Error()).Error("Failed to remove existing final file")
-			updateJobStatus(jobID, UploadProgress{
-				Status:  "error",
-				Error:   "Failed to prepare final file",
-				Message: fmt.Sprintf("Failed to remove existing file: %s", err.Error()),
-			})
-			return
-		}
-	}
-
-	finalFile, err := os.Create(finalFilePath)
-	if err != nil {
-		log.WithField("error", err.Error()).
-			WithField("finalFilePath", finalFilePath).
-			Error("Failed to create final file")
-		updateJobStatus(jobID, UploadProgress{
-			Status:  "error",
-			Error:   "Failed to create final file",
-			Message: err.Error(),
-		})
-		return
-	}
-
-	defer func() {
-		if finalFile != nil {
-			finalFile.Close()
-		}
-	}()
-
-	totalBytesWritten := int64(0)
-	missingChunks := false
-
-	for i := 0; i < uploadInfo.TotalChunks; i++ {
+	// Most chunks have normally been appended as they arrived already, so
+	// this only appends whatever is left.
+	err := appendReadyChunks(ctx, uploadInfo, func(index int) {
 		updateJobStatus(jobID, UploadProgress{
 			Status:    "assembling",
-			Progress:  int(float64(i) / float64(uploadInfo.TotalChunks) * 30), // Assembly = 0-30%
-			Message:   fmt.Sprintf("Assembling chunks: %d/%d", i+1, uploadInfo.TotalChunks),
+			Progress:  int(float64(index) / float64(uploadInfo.TotalChunks) * 30), // Assembly = 0-30%
+			Message:   fmt.Sprintf("Assembling chunks: %d/%d", index+1, uploadInfo.TotalChunks),
 			Filename:  uploadInfo.Filename,
 			TotalSize: uploadInfo.TotalSize,
 		})
-
-		chunkPath := filepath.Join(uploadInfo.TempDir, fmt.Sprintf("chunk_%d", i))
-
-		if _, err := os.Stat(chunkPath); os.IsNotExist(err) {
-			log.WithField("chunkPath", chunkPath).Error("Chunk file doesn't exist")
-			missingChunks = true
-			updateJobStatus(jobID, UploadProgress{
-				Status:  "error",
-				Error:   fmt.Sprintf("Missing chunk %d", i),
-				Message: fmt.Sprintf("Chunk file %s doesn't exist", chunkPath),
-			})
-			return
-		}
-
-		chunkData, err := ioutil.ReadFile(chunkPath)
-		if err != nil {
-			log.WithField("error", err.Error()).
-				WithField("chunkPath", chunkPath).
-				Error("Failed to read chunk")
-			updateJobStatus(jobID, UploadProgress{
-				Status:  "error",
-				Error:   fmt.Sprintf("Failed to read chunk %d", i),
-				Message: err.Error(),
-			})
-			return
-		}
-
-		bytesWritten, err := finalFile.Write(chunkData)
-		if err != nil {
-			log.WithField("error", err.Error()).
-				WithField("chunkPath", chunkPath).
-				Error("Failed to write chunk to final file")
-			updateJobStatus(jobID, UploadProgress{
-				Status:  "error",
-				Error:   fmt.Sprintf("Failed to write chunk %d to final file", i),
-				Message: err.Error(),
-			})
-			return
+	})
+	if ctx.Err() != nil {
+		log.WithField("jobID", jobID).Info("Chunked upload cancelled during assembly")
+		// Cancelling the job deleted the chunks along with its temp
+		// directory. Otherwise the server is shutting down, and the upload
+		// is completed again after the restart.
+		if progress, _, _ := getUploadJob(jobID); progress.Status == "cancelled" {
+			dropChunkedUpload(uploadInfo, "cancelled")
+		} else {
+			releaseChunkedUpload(uploadInfo, "allChunksReceived")
 		}
-
-		totalBytesWritten += int64(bytesWritten)
-	}
-
-	if missingChunks {
-		log.Error("Some chunks were missing, cannot assemble file")
-		return
-	}
-
-	if totalBytesWritten != uploadInfo.TotalSize {
-		log.WithField("expectedSize", uploadInfo.TotalSize).
-			WithField("actualSize", totalBytesWritten).
-			Error("Assembled file size mismatch")
-		updateJobStatus(jobID, UploadProgress{
-			Status:  "error",
-			Error:   "Assembled file size mismatch",
-			Message: fmt.Sprintf("Expected %d bytes but wrote %d bytes", uploadInfo.TotalSize, totalBytesWritten),
-		})
 		return
 	}
-
-	if err := finalFile.Sync(); err != nil {
-		log.WithField("error", err.Error()).Error("Failed to sync final file")
+	if err != nil {
+		log.WithField("uploadId", uploadInfo.ID).WithField("error", err.Error()).Error("Failed to assemble chunked upload")
 		updateJobStatus(jobID, UploadProgress{
 			Status:  "error",
-			Error:   "Failed to sync final file",
+			Error:   "Failed to assemble file",
 			Message: err.Error(),
 		})
+		if errors.Is(err, os.ErrNotExist) {
+			// The next chunk's file is gone, so it has to be sent again.
+			chunkedUploadsMutex.Lock()
+			delete(uploadInfo.ChunksReceived, uploadInfo.AssembledChunks)
+			delete(uploadInfo.ChunkBytes, uploadInfo.AssembledChunks)
+			uploadInfo.UploadedChunks = len(uploadInfo.ChunksReceived)
+			chunkedUploadsMutex.Unlock()
+			releaseChunkedUpload(uploadInfo, "inProgress")
+			return
+		}
+		releaseChunkedUpload(uploadInfo, "allChunksReceived")
 		return
 	}
 
-	if err := finalFile.Close(); err != nil {
-		log.WithField("error", err.Error()).Error("Failed to close final file")
+	chunkedUploadsMutex.RLock()
+	assembledChunks := uploadInfo.AssembledChunks
+	chunkedUploadsMutex.RUnlock()
+	if assembledChunks != uploadInfo.TotalChunks {
+		log.WithField("uploadId", uploadInfo.ID).WithField("chunkIndex", assembledChunks).Error("Chunk missing from assembly")
 		updateJobStatus(jobID, UploadProgress{
 			Status:  "error",
-			Error:   "Failed to close final file",
-			Message: err.Error(),
+			Error:   fmt.Sprintf("Missing chunk %d", assembledChunks),
+			Message: fmt.Sprintf("Chunk %d was never received", assembledChunks),
 		})
+		releaseChunkedUpload(uploadInfo, "inProgress")
 		return
 	}
 
-	finalFile = nil
-
-	fileInfo, err := os.Stat(finalFilePath)
+	fileInfo, err := os.Stat(assembledFilePath(uploadInfo.TempDir))
 	if err != nil {
 		log.WithField("error", err.Error()).
-			WithField("finalFilePath", finalFilePath).
+			WithField("uploadId", uploadInfo.ID).
 			Error("Failed to stat assembled file")
 		updateJobStatus(jobID, UploadProgress{
 			Status:  "error",
 			Error:   "Failed to verify assembled file",
 			Message: fmt.Sprintf("Error: %s", err.Error()),
 		})
+		releaseChunkedUpload(uploadInfo, "allChunksReceived")
 		return
 	}
 
@@ -551,6 +744,36 @@ func assembleAndProcessFile(uploadInfo *ChunkedUploadInfo, jobID string, userID
 			Error:   "Final file size mismatch",
 			Message: fmt.Sprintf("Expected %d bytes but got %d bytes", uploadInfo.TotalSize, fileInfo.Size()),
 		})
+		dropChunkedUpload(uploadInfo, "failed")
+		return
+	}
+
+	checksum := hex.EncodeToString(assembledChecksum(uploadInfo))
+	if uploadInfo.FileHash != "" && checksum != uploadInfo.FileHash {
+		log.WithField("uploadId", uploadInfo.ID).
+			WithField("expected", uploadInfo.FileHash).
+			WithField("actual", checksum).
+			Error("Assembled file does not match the expected checksum")
+		updateJobStatus(jobID, UploadProgress{
+			Status:  "checksum_mismatch",
+			Error:   "Assembled file checksum mismatch",
+			Message: fmt.Sprintf("Expected SHA-256 %s but the assembled file has %s", uploadInfo.FileHash, checksum),
+		})
+		dropChunkedUpload(uploadInfo, "checksum_mismatch")
+		return
+	}
+
+	finalFilePath := filepath.Join(uploadInfo.TempDir, sanitizeFilename(uploadInfo.Filename))
+	if err := os.Rename(assembledFilePath(uploadInfo.TempDir), finalFilePath); err != nil {
+		log.WithField("error", err.Error()).
+			WithField("finalFilePath", finalFilePath).
+			Error("Failed to move assembled file into place")
+		updateJobStatus(jobID, UploadProgress{
+			Status:  "error",
+			Error:   "Failed to prepare final file",
+			Message: err.Error(),
+		})
+		releaseChunkedUpload(uploadInfo, "allChunksReceived")
 		return
 	}
 
@@ -565,62 +788,43 @@ func assembleAndProcessFile(uploadInfo *ChunkedUploadInfo, jobID string, userID
 	chunkedUploadsMutex.Lock()
 	uploadInfo.Status = "processing"
 	chunkedUploadsMutex.Unlock()
+	saveChunkedUpload(uploadInfo)
+	publishChunkedUpload(uploadInfo)
 
 	log.WithField("finalFilePath", finalFilePath).
 		WithField("fileSize", fileInfo.Size()).
 		Info("File successfully assembled, proceeding to processing")
 
-	fileHeader := &multipart.FileHeader{
-		Filename: uploadInfo.Filename,
-		Size:     uploadInfo.TotalSize,
-		Header:   make(map[string][]string),
-	}
-
-	uploadPathsLock.Lock()
-	filePaths[jobID] = finalFilePath
-	log.WithField("jobID", jobID).
-		WithField("finalFilePath", finalFilePath).
-		Info("Storing file path for processing")
-	uploadPathsLock.Unlock()
-
-	uploadPathsLock.RLock()
-	storedPath, pathExists := filePaths[jobID]
-	uploadPathsLock.RUnlock()
-
-	if !pathExists || storedPath != finalFilePath {
-		log.WithField("jobID", jobID).
-			WithField("expectedPath", finalFilePath).
-			WithField("storedPath", storedPath).
-			WithField("pathExists", pathExists).
-			Error("File path was not stored correctly")
+	upload := localUpload{
+		Path:        finalFilePath,
+		Filename:    uploadInfo.Filename,
+		Size:        fileInfo.Size(),
+		Checksum:    checksum,
+		ContentType: uploadInfo.ContentType,
+		Compress:    uploadInfo.Compress,
+		Metadata:    uploadInfo.Metadata,
+		RetainUntil: uploadInfo.RetainUntil,
+	}
+
+	if err := enqueueUpload(jobID, userID, func() {
+		processUpload(jobID, upload, userID, cfg.PdptoolPath)
+	}); err != nil {
 		updateJobStatus(jobID, UploadProgress{
 			Status:  "error",
-			Error:   "Internal error: file path not stored correctly",
-			Message: "Please try again or contact support",
+			Error:   "Server is shutting down",
+			Message: "The upload could not be queued. Please try again shortly.",
 		})
-		return
 	}
 
-	processUpload(jobID, fileHeader, userID, cfg.PdptoolPath)
-
 	go func() {
 		time.Sleep(5 * time.Second)
 
-		uploadJobsLock.RLock()
-		progress, exists := uploadJobs[jobID]
-		uploadJobsLock.RUnlock()
+		progress, _, exists := getUploadJob(jobID)
 
-		if exists && (progress.Status == "complete" || progress.Status == "error") {
+		if exists && isTerminalJobStatus(progress.Status) {
 			log.WithField("tempDir", uploadInfo.TempDir).Info("Cleaning up temp directory after completion")
 			os.RemoveAll(uploadInfo.TempDir)
-
-			uploadPathsLock.Lock()
-			delete(filePaths, jobID)
-			uploadPathsLock.Unlock()
-
-			chunkedUploadsMutex.Lock()
-			delete(chunkedUploads, uploadInfo.ID)
-			chunkedUploadsMutex.Unlock()
+			forgetChunkedUpload(uploadInfo.ID)
 
 			log.WithField("uploadId", uploadInfo.ID).
 				WithField("jobId", jobID).
@@ -636,24 +840,15 @@ func assembleAndProcessFile(uploadInfo *ChunkedUploadInfo, jobID string, userID
 				defer cleanupTicker.Stop()
 
 				for range cleanupTicker.C {
-					uploadJobsLock.RLock()
-					progress, exists := uploadJobs[jobID]
-					uploadJobsLock.RUnlock()
+					progress, _, exists := getUploadJob(jobID)
 
-					if !exists || progress.Status == "complete" || progress.Status == "error" {
+					if !exists || isTerminalJobStatus(progress.Status) {
 						log.WithField("uploadId", uploadInfo.ID).
 							WithField("jobId", jobID).
 							Info("Cleaning up chunked upload in delayed cleanup")
 
 						os.RemoveAll(uploadInfo.TempDir)
-
-						uploadPathsLock.Lock()
-						delete(filePaths, jobID)
-						uploadPathsLock.Unlock()
-
-						chunkedUploadsMutex.Lock()
-						delete(chunkedUploads, uploadInfo.ID)
-						chunkedUploadsMutex.Unlock()
+						forgetChunkedUpload(uploadInfo.ID)
 
 						return
 					}
@@ -663,14 +858,311 @@ func assembleAndProcessFile(uploadInfo *ChunkedUploadInfo, jobID string, userID
 	}()
 }
 
-var (
-	filePaths       = make(map[string]string)
-	uploadPathsLock sync.RWMutex
-)
+// ChunkedUploadSummary describes a chunked upload that is still receiving
+// chunks
+// @Description A chunked upload the user can resume or abort
+type ChunkedUploadSummary struct {
+	UploadID       string    `json:"uploadId"`
+	Filename       string    `json:"filename"`
+	TotalSize      int64     `json:"totalSize"`
+	TotalChunks    int       `json:"totalChunks"`
+	UploadedChunks int       `json:"uploadedChunks"`
+	Status         string    `json:"status"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
 
-func updateJobStatus(jobID string, progress UploadProgress) {
-	progress.JobID = jobID
-	uploadJobsLock.Lock()
-	uploadJobs[jobID] = progress
-	uploadJobsLock.Unlock()
+// chunkedUploadHandedOff reports whether a chunked upload has been passed on
+// to assembly and processing, after which it can no longer be resumed or
+// aborted.
+func chunkedUploadHandedOff(status string) bool {
+	return status == "assembling" || status == "processing"
+}
+
+// releaseChunkedUpload hands an upload whose assembly stopped short back to
+// the client as status, so it can be completed again, or resumed first when
+// chunks have to be sent again.
+func releaseChunkedUpload(info *ChunkedUploadInfo, status string) {
+	chunkedUploadsMutex.Lock()
+	info.Status = status
+	chunkedUploadsMutex.Unlock()
+	saveChunkedUpload(info)
+	publishChunkedUpload(info)
+}
+
+// dropChunkedUpload ends an upload whose assembled file can't be stored as
+// status, deleting its chunks.
+func dropChunkedUpload(info *ChunkedUploadInfo, status string) {
+	chunkedUploadsMutex.Lock()
+	info.Status = status
+	chunkedUploadsMutex.Unlock()
+	forgetChunkedUpload(info.ID)
+	publishChunkedUpload(info)
+	os.RemoveAll(info.TempDir)
+}
+
+// @Summary List in-progress chunked uploads
+// @Description List the authenticated user's chunked uploads that are still receiving chunks, newest first, so they can be resumed or aborted
+// @Tags upload
+// @Produce json
+// @Success 200 {array} ChunkedUploadSummary
+// @Router /api/v1/upload/chunked [get]
+func ListChunkedUploads(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var rows []models.ChunkedUpload
+	if err := db.Where("user_id = ? AND status NOT IN ?", userID, []string{"assembling", "processing"}).
+		Order("created_at DESC").
+		Find(&rows).Error; err != nil {
+		log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to list chunked uploads")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list chunked uploads",
+		})
+		return
+	}
+
+	uploads := make([]ChunkedUploadSummary, 0, len(rows))
+	for _, row := range rows {
+		uploads = append(uploads, ChunkedUploadSummary{
+			UploadID:       row.UploadID,
+			Filename:       row.Filename,
+			TotalSize:      row.TotalSize,
+			TotalChunks:    row.TotalChunks,
+			UploadedChunks: row.UploadedChunks,
+			Status:         row.Status,
+			CreatedAt:      row.CreatedAt,
+			UpdatedAt:      row.UpdatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, uploads)
+}
+
+// @Summary Abort a chunked upload
+// @Description Abort a chunked upload that is still receiving chunks and delete the chunks received so far
+// @Tags upload
+// @Produce json
+// @Param uploadId path string true "Upload ID"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/upload/chunked/{uploadId} [delete]
+func AbortChunkedUpload(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	uploadID := c.Param("uploadId")
+
+	chunkedUploadsMutex.Lock()
+	uploadInfo, exists := chunkedUploads[uploadID]
+	if !exists {
+		chunkedUploadsMutex.Unlock()
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Upload ID not found",
+		})
+		return
+	}
+	if uploadInfo.UserID != userID.(uint) {
+		chunkedUploadsMutex.Unlock()
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "You don't have permission to access this upload",
+		})
+		return
+	}
+	if chunkedUploadHandedOff(uploadInfo.Status) {
+		status := uploadInfo.Status
+		chunkedUploadsMutex.Unlock()
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  "Upload is already being processed. Cancel its upload job instead",
+			"status": status,
+		})
+		return
+	}
+	uploadInfo.Status = "aborted"
+	uploadInfo.UpdatedAt = time.Now()
+	chunkedUploadsMutex.Unlock()
+
+	forgetChunkedUpload(uploadID)
+	publishChunkedUpload(uploadInfo)
+	if err := os.RemoveAll(uploadInfo.TempDir); err != nil {
+		log.WithField("uploadId", uploadID).WithField("error", err.Error()).Warning("Failed to remove chunks of aborted upload")
+	}
+
+	log.WithField("uploadId", uploadID).Info("Aborted chunked upload")
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Chunked upload aborted",
+		"uploadId": uploadID,
+	})
+}
+
+// ChunkRange is an inclusive range of chunk indexes
+// @Description Inclusive range of chunk indexes
+type ChunkRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// MissingChunksResponse lists the chunks a chunked upload still needs
+// @Description Chunks still missing from a chunked upload, with the slicing needed to send them
+type MissingChunksResponse struct {
+	UploadID       string       `json:"uploadId"`
+	ChunkSize      int64        `json:"chunkSize"`
+	TotalSize      int64        `json:"totalSize"`
+	TotalChunks    int          `json:"totalChunks"`
+	UploadedChunks int          `json:"uploadedChunks"`
+	MissingChunks  []int        `json:"missingChunks"`
+	MissingRanges  []ChunkRange `json:"missingRanges"`
+}
+
+// @Summary List missing chunks
+// @Description List the chunk indexes of a chunked upload that haven't been received yet, as a sorted list and as ranges, so a client can resume by sending only those
+// @Tags upload
+// @Produce json
+// @Param uploadId path string true "Upload ID"
+// @Success 200 {object} MissingChunksResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/upload/chunked/{uploadId}/chunks [get]
+func GetMissingChunks(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	uploadID := c.Param("uploadId")
+
+	chunkedUploadsMutex.RLock()
+	uploadInfo, exists := chunkedUploads[uploadID]
+	if !exists {
+		chunkedUploadsMutex.RUnlock()
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Upload ID not found",
+		})
+		return
+	}
+	if uploadInfo.UserID != userID.(uint) {
+		chunkedUploadsMutex.RUnlock()
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "You don't have permission to access this upload",
+		})
+		return
+	}
+
+	response := MissingChunksResponse{
+		UploadID:       uploadID,
+		ChunkSize:      uploadInfo.ChunkSize,
+		TotalSize:      uploadInfo.TotalSize,
+		TotalChunks:    uploadInfo.TotalChunks,
+		UploadedChunks: uploadInfo.UploadedChunks,
+		MissingChunks:  []int{},
+		MissingRanges:  []ChunkRange{},
+	}
+	for i := 0; i < uploadInfo.TotalChunks; i++ {
+		if uploadInfo.ChunksReceived[i] {
+			continue
+		}
+		response.MissingChunks = append(response.MissingChunks, i)
+		if last := len(response.MissingRanges) - 1; last >= 0 && response.MissingRanges[last].End == i-1 {
+			response.MissingRanges[last].End = i
+		} else {
+			response.MissingRanges = append(response.MissingRanges, ChunkRange{Start: i, End: i})
+		}
+	}
+	chunkedUploadsMutex.RUnlock()
+
+	c.JSON(http.StatusOK, response)
+}
+
+// normalizeFileHash lower-cases a client supplied SHA-256 and reports whether
+// it is one. An empty hash is accepted and left empty.
+func normalizeFileHash(fileHash string) (string, bool) {
+	fileHash = strings.ToLower(strings.TrimSpace(fileHash))
+	if fileHash == "" {
+		return "", true
+	}
+	if len(fileHash) != sha256.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(fileHash); err != nil {
+		return "", false
+	}
+	return fileHash, true
+}
+
+func respondInvalidFileHash(c *gin.Context) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error": "fileHash must be a hex encoded SHA-256",
+	})
+}
+
+// validateChunkLayout checks a chunked upload's declared sizes against the
+// configured bounds and against each other, so assembly can rely on every
+// chunk but the last being exactly chunkSize bytes.
+func validateChunkLayout(totalSize, chunkSize int64, totalChunks int) error {
+	if totalSize <= 0 || chunkSize <= 0 || totalChunks <= 0 {
+		return fmt.Errorf("totalSize, chunkSize and totalChunks must be positive")
+	}
+	if totalChunks > cfg.Upload.MaxChunks {
+		return fmt.Errorf("an upload may have at most %d chunks, got %d", cfg.Upload.MaxChunks, totalChunks)
+	}
+	if cfg.Upload.MaxChunkSize > 0 && chunkSize > cfg.Upload.MaxChunkSize {
+		return fmt.Errorf("chunkSize may be at most %d bytes, got %d", cfg.Upload.MaxChunkSize, chunkSize)
+	}
+	if totalChunks > 1 && chunkSize < cfg.Upload.MinChunkSize {
+		return fmt.Errorf("chunkSize must be at least %d bytes, got %d", cfg.Upload.MinChunkSize, chunkSize)
+	}
+	if expected := (totalSize + chunkSize - 1) / chunkSize; int64(totalChunks) != expected {
+		return fmt.Errorf("%d bytes in chunks of %d bytes makes %d chunks, not %d", totalSize, chunkSize, expected, totalChunks)
+	}
+	return nil
+}
+
+// unusableChunks returns the indexes of received chunks whose recorded size
+// isn't the size the chunk must have, or whose size is unknown, so a
+// short chunk is caught before assembly rather than by the final size check.
+// chunkedUploadsMutex must be held.
+func unusableChunks(info *ChunkedUploadInfo) []int {
+	var bad []int
+	for index := info.AssembledChunks; index < info.TotalChunks; index++ {
+		if !info.ChunksReceived[index] {
+			continue
+		}
+		if written, ok := info.ChunkBytes[index]; !ok || written != expectedChunkSize(info, index) {
+			bad = append(bad, index)
+		}
+	}
+	return bad
+}
+
+// expectedChunkSize is the number of bytes chunk index must have. Only the
+// last chunk may be shorter than the upload's chunk size.
+func expectedChunkSize(info *ChunkedUploadInfo, index int) int64 {
+	if index == info.TotalChunks-1 {
+		return info.TotalSize - int64(info.TotalChunks-1)*info.ChunkSize
+	}
+	return info.ChunkSize
+}
+
+func respondChunkSizeMismatch(c *gin.Context, chunkIndex int, expected, actual int64) {
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error":        fmt.Sprintf("Chunk %d must be %d bytes, got %d", chunkIndex, expected, actual),
+		"chunkIndex":   chunkIndex,
+		"expectedSize": expected,
+		"actualSize":   actual,
+	})
 }
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// assembledFileName is the file in a chunked upload's directory that the
+// leading run of received chunks is appended to as they arrive, so little is
+// left to do once the last chunk lands.
+const assembledFileName = "assembled.part"
+
+// chunkAssembly serialises appends to an upload's assembled file and holds
+// the running hash of what has been appended so far.
+type chunkAssembly struct {
+	mu   sync.Mutex
+	hash hash.Hash
+}
+
+func chunkFilePath(dir string, index int) string {
+	return filepath.Join(dir, fmt.Sprintf("chunk_%d", index))
+}
+
+func assembledFilePath(dir string) string {
+	return filepath.Join(dir, assembledFileName)
+}
+
+// assembledLength is the size of the assembled file once the first n chunks
+// have been appended. Every chunk but the last is exactly chunkSize bytes.
+func assembledLength(chunkSize, totalSize int64, n int) int64 {
+	length := int64(n) * chunkSize
+	if length > totalSize {
+		return totalSize
+	}
+	return length
+}
+
+// assemblyFor returns the upload's assembly state, creating it on first use.
+func assemblyFor(info *ChunkedUploadInfo) *chunkAssembly {
+	chunkedUploadsMutex.Lock()
+	defer chunkedUploadsMutex.Unlock()
+	if info.assembly == nil {
+		info.assembly = &chunkAssembly{}
+	}
+	return info.assembly
+}
+
+// appendReadyChunks appends received chunks to the upload's assembled file,
+// in order, for as long as the next one has arrived. Each append is synced
+// to disk before it is recorded, and each chunk file deleted only once the
+// record is saved, so after a crash the assembled file holds at least the
+// recorded chunks and the chunks after them still exist. Chunks that arrive
+// ahead of a missing one stay as chunk files until the gap is filled.
+// progress, when set, is called before each chunk is appended.
+func appendReadyChunks(ctx context.Context, info *ChunkedUploadInfo, progress func(index int)) error {
+	assembly := assemblyFor(info)
+	assembly.mu.Lock()
+	defer assembly.mu.Unlock()
+
+	assembledPath := assembledFilePath(info.TempDir)
+
+	// After a restart, or a failed append, the hash of what is already in
+	// the assembled file has to be rebuilt from the file.
+	if assembly.hash == nil {
+		chunkedUploadsMutex.RLock()
+		length := assembledLength(info.ChunkSize, info.TotalSize, info.AssembledChunks)
+		chunkedUploadsMutex.RUnlock()
+
+		hasher := sha256.New()
+		if length > 0 {
+			existing, err := os.Open(assembledPath)
+			if err != nil {
+				return err
+			}
+			_, err = io.CopyN(hasher, existing, length)
+			existing.Close()
+			if err != nil {
+				return fmt.Errorf("failed to read assembled file: %w", err)
+			}
+		}
+		assembly.hash = hasher
+	}
+
+	var assembled *os.File
+	defer func() {
+		if assembled != nil {
+			assembled.Close()
+		}
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		chunkedUploadsMutex.RLock()
+		next := info.AssembledChunks
+		ready := next < info.TotalChunks && info.ChunksReceived[next]
+		chunkedUploadsMutex.RUnlock()
+		if !ready {
+			return nil
+		}
+
+		if progress != nil {
+			progress(next)
+		}
+
+		if assembled == nil {
+			var err error
+			assembled, err = os.OpenFile(assembledPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+		}
+
+		chunkPath := chunkFilePath(info.TempDir, next)
+		chunk, err := os.Open(chunkPath)
+		if err != nil {
+			return fmt.Errorf("failed to read chunk %d: %w", next, err)
+		}
+		_, err = io.Copy(io.MultiWriter(assembled, assembly.hash), chunk)
+		chunk.Close()
+		if err == nil {
+			err = assembled.Sync()
+		}
+		if err != nil {
+			// Drop the partial append so the file stays a whole number of
+			// chunks, and rebuild the hash next time.
+			assembled.Truncate(assembledLength(info.ChunkSize, info.TotalSize, next))
+			assembly.hash = nil
+			return fmt.Errorf("failed to append chunk %d: %w", next, err)
+		}
+
+		chunkedUploadsMutex.Lock()
+		info.AssembledChunks = next + 1
+		delete(info.ChunkBytes, next)
+		chunkedUploadsMutex.Unlock()
+		// Until the new count is saved a restart goes back to the old one,
+		// which needs this chunk file to append it again.
+		if err := saveChunkedUpload(info); err == nil {
+			os.Remove(chunkPath)
+		}
+	}
+}
+
+// appendChunksInBackground appends newly contiguous chunks of an upload
+// without holding up the request that delivered them.
+func appendChunksInBackground(info *ChunkedUploadInfo) {
+	go func() {
+		if err := appendReadyChunks(context.Background(), info, nil); err != nil {
+			log.WithField("uploadId", info.ID).
+				WithField("error", err.Error()).
+				Warning("Failed to append chunks to assembled file, will retry when the upload completes")
+		}
+	}()
+}
+
+// assembledChecksum returns the hash of everything appended to the upload's
+// assembled file.
+func assembledChecksum(info *ChunkedUploadInfo) []byte {
+	assembly := assemblyFor(info)
+	assembly.mu.Lock()
+	defer assembly.mu.Unlock()
+	return assembly.hash.Sum(nil)
+}
+
+// restoreAssembledChunks reconciles an upload's assembled file with the
+// number of chunks recorded as appended, after a restart. A file cut short
+// by the crash rolls the count back to the chunks it holds in full; a file
+// that got ahead of the count is truncated back to it. It returns how many
+// chunks the file holds.
+func restoreAssembledChunks(dir string, chunkSize, totalSize int64, assembled int) int {
+	if assembled == 0 {
+		os.Remove(assembledFilePath(dir))
+		return 0
+	}
+
+	var size int64
+	if stat, err := os.Stat(assembledFilePath(dir)); err == nil {
+		size = stat.Size()
+	}
+	for assembled > 0 && assembledLength(chunkSize, totalSize, assembled) > size {
+		assembled--
+	}
+	if err := os.Truncate(assembledFilePath(dir), assembledLength(chunkSize, totalSize, assembled)); err != nil && !os.IsNotExist(err) {
+		log.WithField("dir", dir).WithField("error", err.Error()).Warning("Failed to truncate assembled file")
+	}
+
+	// Chunks already in the assembled file may not have been deleted yet.
+	for index := 0; index < assembled; index++ {
+		os.Remove(chunkFilePath(dir, index))
+	}
+	return assembled
+}
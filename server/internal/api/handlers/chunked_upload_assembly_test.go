@@ -0,0 +1,521 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hotvault/backend/internal/models"
+)
+
+// newTestChunkedUpload registers a chunked upload of data split into chunks
+// of chunkSize and writes the chunks whose index is in written.
+func newTestChunkedUpload(t *testing.T, userID uint, data []byte, chunkSize int64, written ...int) *ChunkedUploadInfo {
+	t.Helper()
+	totalChunks := int((int64(len(data)) + chunkSize - 1) / chunkSize)
+	info := &ChunkedUploadInfo{
+		ID:             uuid.New().String(),
+		UserID:         userID,
+		Filename:       "data.bin",
+		ChunkSize:      chunkSize,
+		TotalSize:      int64(len(data)),
+		TotalChunks:    totalChunks,
+		ChunksReceived: make(map[int]bool),
+		ChunkBytes:     make(map[int]int64),
+		chunksWriting:  make(map[int]bool),
+		TempDir:        filepath.Join(cfg.Upload.ChunkDir, uuid.New().String()),
+		Status:         "initialized",
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	if err := os.MkdirAll(info.TempDir, 0750); err != nil {
+		t.Fatalf("create chunk directory: %v", err)
+	}
+	for _, index := range written {
+		start := int64(index) * chunkSize
+		end := start + chunkSize
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		if err := os.WriteFile(chunkFilePath(info.TempDir, index), data[start:end], 0644); err != nil {
+			t.Fatalf("write chunk %d: %v", index, err)
+		}
+		info.ChunksReceived[index] = true
+		info.ChunkBytes[index] = end - start
+	}
+	info.UploadedChunks = len(info.ChunksReceived)
+
+	chunkedUploadsMutex.Lock()
+	chunkedUploads[info.ID] = info
+	chunkedUploadsMutex.Unlock()
+	t.Cleanup(func() {
+		chunkedUploadsMutex.Lock()
+		delete(chunkedUploads, info.ID)
+		chunkedUploadsMutex.Unlock()
+	})
+	saveChunkedUpload(info)
+	return info
+}
+
+func storedChunkedUpload(t *testing.T, uploadID string) (models.ChunkedUpload, bool) {
+	t.Helper()
+	var row models.ChunkedUpload
+	err := db.Where("upload_id = ?", uploadID).Limit(1).Find(&row).Error
+	if err != nil {
+		t.Fatalf("load chunked upload: %v", err)
+	}
+	return row, row.UploadID != ""
+}
+
+func TestAppendReadyChunksAppendsLeadingChunks(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+
+	data := bytes.Repeat([]byte("0123456789"), 4)
+	// Chunk 2 arrived ahead of chunk 1.
+	info := newTestChunkedUpload(t, user.ID, data, 10, 0, 2)
+
+	if err := appendReadyChunks(context.Background(), info, nil); err != nil {
+		t.Fatalf("appendReadyChunks: %v", err)
+	}
+	if info.AssembledChunks != 1 {
+		t.Fatalf("AssembledChunks = %d, want 1", info.AssembledChunks)
+	}
+	if _, err := os.Stat(chunkFilePath(info.TempDir, 0)); !os.IsNotExist(err) {
+		t.Errorf("chunk 0 was not removed once appended")
+	}
+	if _, err := os.Stat(chunkFilePath(info.TempDir, 2)); err != nil {
+		t.Errorf("chunk 2 should wait for chunk 1: %v", err)
+	}
+	row, _ := storedChunkedUpload(t, info.ID)
+	if row.AssembledChunks != 1 {
+		t.Errorf("stored AssembledChunks = %d, want 1", row.AssembledChunks)
+	}
+
+	// Filling the gap appends everything that is left.
+	if err := os.WriteFile(chunkFilePath(info.TempDir, 1), data[10:20], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(chunkFilePath(info.TempDir, 3), data[30:], 0644); err != nil {
+		t.Fatal(err)
+	}
+	chunkedUploadsMutex.Lock()
+	info.ChunksReceived[1] = true
+	info.ChunksReceived[3] = true
+	chunkedUploadsMutex.Unlock()
+	if err := appendReadyChunks(context.Background(), info, nil); err != nil {
+		t.Fatalf("appendReadyChunks: %v", err)
+	}
+	assembled, err := os.ReadFile(assembledFilePath(info.TempDir))
+	if err != nil {
+		t.Fatalf("read assembled file: %v", err)
+	}
+	if !bytes.Equal(assembled, data) {
+		t.Errorf("assembled file = %q, want %q", assembled, data)
+	}
+	row, _ = storedChunkedUpload(t, info.ID)
+	if row.AssembledChunks != 4 {
+		t.Errorf("stored AssembledChunks = %d, want 4", row.AssembledChunks)
+	}
+}
+
+func TestRestoreAssembledChunksAfterCrash(t *testing.T) {
+	useTestConfig(t)
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("abcd"), 3)
+
+	// The third chunk was appended but the crash came before its count
+	// was saved, so its chunk file is still there.
+	if err := os.WriteFile(assembledFilePath(dir), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(chunkFilePath(dir, 2), data[8:], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := restoreAssembledChunks(dir, 4, int64(len(data)), 2); got != 2 {
+		t.Fatalf("restoreAssembledChunks = %d, want 2", got)
+	}
+	assembled, _ := os.ReadFile(assembledFilePath(dir))
+	if !bytes.Equal(assembled, data[:8]) {
+		t.Errorf("assembled file = %q, want %q", assembled, data[:8])
+	}
+	if _, err := os.Stat(chunkFilePath(dir, 2)); err != nil {
+		t.Errorf("chunk 2 must survive to be appended again: %v", err)
+	}
+}
+
+func waitForChunkedStatus(t *testing.T, info *ChunkedUploadInfo, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		chunkedUploadsMutex.RLock()
+		status := info.Status
+		chunkedUploadsMutex.RUnlock()
+		if status == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("chunked upload status = %q, want %q", status, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAssemblyWithLostChunkAsksForItAgain(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+
+	data := bytes.Repeat([]byte("x"), 30)
+	info := newTestChunkedUpload(t, user.ID, data, 10, 0, 1, 2)
+	os.Remove(chunkFilePath(info.TempDir, 1))
+	info.Status = "assembling"
+
+	assembleAndProcessFile(info, uuid.New().String(), user.ID)
+
+	waitForChunkedStatus(t, info, "inProgress")
+	if info.ChunksReceived[1] {
+		t.Errorf("lost chunk 1 is still counted as received")
+	}
+	if info.UploadedChunks != 2 {
+		t.Errorf("UploadedChunks = %d, want 2", info.UploadedChunks)
+	}
+	row, ok := storedChunkedUpload(t, info.ID)
+	if !ok || row.Status != "inProgress" {
+		t.Errorf("stored status = %q, want inProgress", row.Status)
+	}
+}
+
+func TestAssemblyWithWrongSizeDropsUpload(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+
+	data := bytes.Repeat([]byte("x"), 30)
+	info := newTestChunkedUpload(t, user.ID, data, 10, 0, 1, 2)
+	// A chunk longer than declared that slipped past the size checks.
+	if err := os.WriteFile(chunkFilePath(info.TempDir, 2), bytes.Repeat([]byte("y"), 12), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info.Status = "assembling"
+
+	assembleAndProcessFile(info, uuid.New().String(), user.ID)
+
+	waitForChunkedStatus(t, info, "failed")
+	if _, ok := storedChunkedUpload(t, info.ID); ok {
+		t.Errorf("failed upload is still stored")
+	}
+	if _, err := os.Stat(info.TempDir); !os.IsNotExist(err) {
+		t.Errorf("failed upload's chunks were not removed")
+	}
+}
+
+func TestCancelledAssemblyDropsUpload(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+
+	data := bytes.Repeat([]byte("x"), 30)
+	info := newTestChunkedUpload(t, user.ID, data, 10, 0, 1, 2)
+	info.Status = "assembling"
+
+	jobID := uuid.New().String()
+	// The job is cancelled as assembly starts on its first chunk.
+	assemblyFor(info).mu.Lock()
+	go func() {
+		for jobContext(jobID) == context.Background() {
+			time.Sleep(time.Millisecond)
+		}
+		cancelUploadJob(jobID)
+		assemblyFor(info).mu.Unlock()
+	}()
+	assembleAndProcessFile(info, jobID, user.ID)
+
+	waitForChunkedStatus(t, info, "cancelled")
+	if _, ok := storedChunkedUpload(t, info.ID); ok {
+		t.Errorf("cancelled upload is still stored")
+	}
+}
+
+// assembleWithFileHash assembles data uploaded in chunks with fileHash as
+// the expected SHA-256 and runs the queued upload against a fake pdptool. It
+// returns the upload, the job's final status and the pdptool calls made.
+func assembleWithFileHash(t *testing.T, user models.User, data []byte, fileHash string) (*ChunkedUploadInfo, UploadProgress, []string) {
+	t.Helper()
+	queue := useTestUploadQueue(t)
+	cfg.Retry.PreUploadDelay = 0
+	calls := fakePdptool(t, `case "$1" in upload-file) echo bagaone:bagasub;; esac`)
+	// processUpload runs pdptool from its own directory.
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	info := newTestChunkedUpload(t, user.ID, data, 10, 0, 1, 2)
+	info.FileHash = fileHash
+	info.Status = "assembling"
+	jobID := uuid.New().String()
+	createUploadJob(jobID, user.ID, UploadProgress{Status: "assembling", Filename: info.Filename, TotalSize: info.TotalSize})
+
+	assembleAndProcessFile(info, jobID, user.ID)
+	if _, queued := queue.position(jobID); queued {
+		task, _ := queue.next()
+		task.run()
+	}
+
+	progress, _, _ := getUploadJob(jobID)
+	return info, progress, pdptoolCalls(t, calls, "")
+}
+
+func TestAssemblyChecksMatchingFileHash(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	createTestProofSet(t, user, "42")
+
+	data := bytes.Repeat([]byte("0123456789"), 3)
+	sum := sha256.Sum256(data)
+	_, progress, _ := assembleWithFileHash(t, user, data, fmt.Sprintf("%x", sum))
+
+	if progress.Status != "uploaded" {
+		t.Fatalf("status = %q (%s %s), want uploaded", progress.Status, progress.Error, progress.Message)
+	}
+	var piece models.Piece
+	if err := db.First(&piece, progress.PieceID).Error; err != nil {
+		t.Fatalf("load piece: %v", err)
+	}
+	if want := fmt.Sprintf("%x", sum); stringValue(piece.Checksum) != want {
+		t.Errorf("piece checksum = %q, want the verified %s", stringValue(piece.Checksum), want)
+	}
+}
+
+func TestAssemblyRejectsMismatchedFileHash(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	createTestProofSet(t, user, "42")
+
+	data := bytes.Repeat([]byte("0123456789"), 3)
+	sum := sha256.Sum256(append(data[:len(data)-1:len(data)-1], 'x'))
+	info, progress, calls := assembleWithFileHash(t, user, data, fmt.Sprintf("%x", sum))
+
+	if progress.Status != "checksum_mismatch" {
+		t.Errorf("status = %q, want checksum_mismatch", progress.Status)
+	}
+	if len(calls) != 0 {
+		t.Errorf("pdptool was run for a corrupt file: %q", calls)
+	}
+	var pieces int64
+	db.Model(&models.Piece{}).Count(&pieces)
+	if pieces != 0 {
+		t.Errorf("%d pieces saved for a corrupt file", pieces)
+	}
+	if _, ok := storedChunkedUpload(t, info.ID); ok {
+		t.Errorf("corrupt upload is still stored")
+	}
+}
+
+func TestAssemblyWithoutFileHashRecordsChecksum(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	createTestProofSet(t, user, "42")
+
+	data := bytes.Repeat([]byte("0123456789"), 3)
+	_, progress, _ := assembleWithFileHash(t, user, data, "")
+
+	if progress.Status != "uploaded" {
+		t.Fatalf("status = %q (%s %s), want uploaded", progress.Status, progress.Error, progress.Message)
+	}
+	var piece models.Piece
+	db.First(&piece, progress.PieceID)
+	sum := sha256.Sum256(data)
+	if want := fmt.Sprintf("%x", sum); stringValue(piece.Checksum) != want {
+		t.Errorf("piece checksum = %q, want %s computed during assembly", stringValue(piece.Checksum), want)
+	}
+}
+
+func TestNormalizeFileHash(t *testing.T) {
+	valid := strings.Repeat("ab", sha256.Size)
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"", "", true},
+		{valid, valid, true},
+		{" " + strings.ToUpper(valid) + " ", valid, true},
+		{valid[:len(valid)-2], "", false},
+		{strings.Repeat("zz", sha256.Size), "", false},
+	}
+	for _, test := range tests {
+		got, ok := normalizeFileHash(test.in)
+		if got != test.want || ok != test.ok {
+			t.Errorf("normalizeFileHash(%q) = %q, %v, want %q, %v", test.in, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+// benchmarkAssemblySize is how much each assembly benchmark appends per
+// operation, whatever its chunk size.
+const benchmarkAssemblySize = 64 << 20
+
+// writeBenchmarkChunks writes the chunks of a benchmarkAssemblySize file to
+// a directory they are linked from for each operation, since assembly
+// deletes them.
+func writeBenchmarkChunks(b *testing.B, chunkSize int64) (string, int) {
+	b.Helper()
+	dir := b.TempDir()
+	chunk := bytes.Repeat([]byte{0xab}, int(chunkSize))
+	totalChunks := int(benchmarkAssemblySize / chunkSize)
+	for index := 0; index < totalChunks; index++ {
+		if err := os.WriteFile(chunkFilePath(dir, index), chunk, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return dir, totalChunks
+}
+
+func linkBenchmarkChunks(b *testing.B, source, dir string, totalChunks int) {
+	b.Helper()
+	os.Remove(assembledFilePath(dir))
+	for index := 0; index < totalChunks; index++ {
+		if err := os.Link(chunkFilePath(source, index), chunkFilePath(dir, index)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// trackPeakHeap samples the heap in use until stop is called, which returns
+// the highest amount seen above what was in use at the start.
+func trackPeakHeap() (stop func() uint64) {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	base := stats.HeapInuse
+
+	var peak uint64
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			if stats.HeapInuse > base && stats.HeapInuse-base > atomic.LoadUint64(&peak) {
+				atomic.StoreUint64(&peak, stats.HeapInuse-base)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() uint64 {
+		close(done)
+		<-finished
+		return atomic.LoadUint64(&peak)
+	}
+}
+
+// BenchmarkAppendReadyChunks measures the memory assembling a chunked upload
+// takes, by streaming as appendReadyChunks does and by reading each chunk
+// whole as it used to. Streaming stays flat as the chunk size grows, while
+// reading whole chunks grows with it. Compare peak-heap-bytes across chunk
+// sizes with:
+//
+//	go test -run '^$' -bench AppendReadyChunks ./internal/api/handlers/
+func BenchmarkAppendReadyChunks(b *testing.B) {
+	for _, chunkSize := range []int64{1 << 20, 8 << 20, 32 << 20} {
+		source, totalChunks := writeBenchmarkChunks(b, chunkSize)
+
+		b.Run(fmt.Sprintf("stream/chunk=%dMiB", chunkSize>>20), func(b *testing.B) {
+			dir := b.TempDir()
+			b.SetBytes(benchmarkAssemblySize)
+			b.ReportAllocs()
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				linkBenchmarkChunks(b, source, dir, totalChunks)
+				received := make(map[int]bool, totalChunks)
+				for index := 0; index < totalChunks; index++ {
+					received[index] = true
+				}
+				info := &ChunkedUploadInfo{
+					ID:             fmt.Sprintf("bench-%d", i),
+					ChunkSize:      chunkSize,
+					TotalSize:      benchmarkAssemblySize,
+					TotalChunks:    totalChunks,
+					ChunksReceived: received,
+					ChunkBytes:     make(map[int]int64),
+					TempDir:        dir,
+				}
+				stop := trackPeakHeap()
+				b.StartTimer()
+
+				if err := appendReadyChunks(context.Background(), info, nil); err != nil {
+					b.Fatal(err)
+				}
+
+				b.StopTimer()
+				if used := stop(); used > peak {
+					peak = used
+				}
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(peak), "peak-heap-bytes")
+		})
+
+		b.Run(fmt.Sprintf("readFile/chunk=%dMiB", chunkSize>>20), func(b *testing.B) {
+			dir := b.TempDir()
+			b.SetBytes(benchmarkAssemblySize)
+			b.ReportAllocs()
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				linkBenchmarkChunks(b, source, dir, totalChunks)
+				stop := trackPeakHeap()
+				b.StartTimer()
+
+				assembled, err := os.Create(assembledFilePath(dir))
+				if err != nil {
+					b.Fatal(err)
+				}
+				hasher := sha256.New()
+				for index := 0; index < totalChunks; index++ {
+					data, err := os.ReadFile(chunkFilePath(dir, index))
+					if err != nil {
+						b.Fatal(err)
+					}
+					if _, err := io.MultiWriter(assembled, hasher).Write(data); err != nil {
+						b.Fatal(err)
+					}
+					if err := assembled.Sync(); err != nil {
+						b.Fatal(err)
+					}
+					os.Remove(chunkFilePath(dir, index))
+				}
+				assembled.Close()
+
+				b.StopTimer()
+				if used := stop(); used > peak {
+					peak = used
+				}
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(peak), "peak-heap-bytes")
+		})
+	}
+}
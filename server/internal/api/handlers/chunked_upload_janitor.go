@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+// chunkedUploadJanitor periodically deletes chunked uploads that have been
+// idle for longer than the expiry, along with their chunks.
+type chunkedUploadJanitor struct {
+	expiry   time.Duration
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+var chunkedJanitor *chunkedUploadJanitor
+
+func startChunkedUploadJanitor(expiry, interval time.Duration) *chunkedUploadJanitor {
+	j := &chunkedUploadJanitor{
+		expiry:  expiry,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	go func() {
+		defer close(j.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-j.done:
+				return
+			case <-ticker.C:
+				j.sweep()
+			}
+		}
+	}()
+
+	log.WithField("expiry", expiry.String()).
+		WithField("interval", interval.String()).
+		Info("Chunked upload janitor started")
+	return j
+}
+
+// stop ends the janitor loop and waits for a running sweep to finish.
+func (j *chunkedUploadJanitor) stop() {
+	j.stopOnce.Do(func() {
+		close(j.done)
+	})
+	<-j.stopped
+}
+
+func (j *chunkedUploadJanitor) sweep() {
+	if db == nil {
+		return
+	}
+
+	var expired []models.ChunkedUpload
+	if err := db.Where("updated_at < ?", time.Now().Add(-j.expiry)).Find(&expired).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to find expired chunked uploads")
+		return
+	}
+
+	removed := 0
+	var reclaimed int64
+	for _, row := range expired {
+		chunkedUploadsMutex.Lock()
+		info, active := chunkedUploads[row.UploadID]
+		if active && chunkedUploadHandedOff(info.Status) {
+			chunkedUploadsMutex.Unlock()
+			continue
+		}
+		if active {
+			info.Status = "expired"
+		}
+		chunkedUploadsMutex.Unlock()
+
+		if row.TempDir != "" {
+			reclaimed += dirSize(row.TempDir)
+			os.RemoveAll(row.TempDir)
+		}
+		forgetChunkedUpload(row.UploadID)
+		// Tell the client the upload is gone so it can show it as failed.
+		if active {
+			publishChunkedUpload(info)
+		}
+		removed++
+		log.WithField("uploadId", row.UploadID).Info("Cleaned up expired chunked upload")
+	}
+
+	if removed > 0 {
+		log.WithField("removed", removed).
+			WithField("reclaimedBytes", reclaimed).
+			WithField("reclaimed", formatFileSize(reclaimed)).
+			Info("Chunked upload janitor sweep finished")
+	}
+}
+
+// dirSize returns the total size of the regular files under dir.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"github.com/hotvault/backend/pkg/diskspace"
+)
+
+// chunkedUploadsRoot is the directory each chunked upload gets a
+// subdirectory of for its chunks.
+func chunkedUploadsRoot() string {
+	return cfg.Upload.ChunkDir
+}
+
+// chunkedUploadHeadroom returns how many bytes chunked uploads may still
+// write before eating into the configured disk reserve. ok is false when the
+// free space can't be determined, in which case callers skip the check.
+func chunkedUploadHeadroom() (headroom int64, ok bool) {
+	root := chunkedUploadsRoot()
+	if err := os.MkdirAll(root, 0750); err != nil {
+		log.WithField("error", err.Error()).Warning("Failed to create chunked upload directory")
+		return 0, false
+	}
+	available, err := diskspace.Available(root)
+	if err != nil {
+		log.WithField("error", err.Error()).Debug("Skipping disk space check")
+		return 0, false
+	}
+	return available - cfg.Upload.DiskReserve, true
+}
+
+// respondInsufficientStorage rejects a chunked upload the disk has no room
+// for.
+func respondInsufficientStorage(c *gin.Context, required, headroom int64) {
+	if headroom < 0 {
+		headroom = 0
+	}
+	c.JSON(http.StatusInsufficientStorage, gin.H{
+		"error":         fmt.Sprintf("Not enough disk space for this upload: needs %s, %s available", formatFileSize(required), formatFileSize(headroom)),
+		"requiredBytes": required,
+		"headroomBytes": headroom,
+	})
+}
+
+// saveLockFor returns the lock the upload's row is written under, creating
+// it on first use.
+func saveLockFor(info *ChunkedUploadInfo) *sync.Mutex {
+	chunkedUploadsMutex.Lock()
+	defer chunkedUploadsMutex.Unlock()
+	if info.saves == nil {
+		info.saves = &sync.Mutex{}
+	}
+	return info.saves
+}
+
+// saveChunkedUpload writes the upload's current state to the database,
+// unless it has been forgotten. The failure is logged as well as returned.
+func saveChunkedUpload(info *ChunkedUploadInfo) error {
+	if db == nil {
+		return nil
+	}
+
+	// The state is read and written under the upload's save lock, so
+	// concurrent saves land in the order their states were read.
+	saves := saveLockFor(info)
+	saves.Lock()
+	defer saves.Unlock()
+
+	chunkedUploadsMutex.RLock()
+	if chunkedUploads[info.ID] != info {
+		chunkedUploadsMutex.RUnlock()
+		return nil
+	}
+	row := models.ChunkedUpload{
+		UploadID:        info.ID,
+		UserID:          info.UserID,
+		Filename:        info.Filename,
+		ChunkSize:       info.ChunkSize,
+		TotalSize:       info.TotalSize,
+		TotalChunks:     info.TotalChunks,
+		UploadedChunks:  info.UploadedChunks,
+		ReceivedChunks:  encodeChunkBitmap(info.ChunksReceived, info.TotalChunks),
+		AssembledChunks: info.AssembledChunks,
+		TempDir:         info.TempDir,
+		Status:          info.Status,
+		FileType:        info.FileType,
+		ContentType:     info.ContentType,
+		Compress:        info.Compress,
+		FileHash:        info.FileHash,
+		CreatedAt:       info.CreatedAt,
+		UpdatedAt:       info.UpdatedAt,
+	}
+	chunkedUploadsMutex.RUnlock()
+
+	if err := db.Save(&row).Error; err != nil {
+		log.WithField("uploadId", info.ID).WithField("error", err.Error()).Error("Failed to persist chunked upload")
+		return err
+	}
+	return nil
+}
+
+// forgetChunkedUpload drops a finished or abandoned chunked upload from
+// memory and the database. Its directory is left to the caller.
+func forgetChunkedUpload(uploadID string) {
+	chunkedUploadsMutex.Lock()
+	info := chunkedUploads[uploadID]
+	delete(chunkedUploads, uploadID)
+	chunkedUploadsMutex.Unlock()
+
+	if db == nil {
+		return
+	}
+	// A save already under way finishes first; later ones find the upload
+	// gone and write nothing.
+	if info != nil {
+		saves := saveLockFor(info)
+		saves.Lock()
+		defer saves.Unlock()
+	}
+	if err := db.Where("upload_id = ?", uploadID).Delete(&models.ChunkedUpload{}).Error; err != nil {
+		log.WithField("uploadId", uploadID).WithField("error", err.Error()).Error("Failed to delete chunked upload")
+	}
+}
+
+func encodeChunkBitmap(received map[int]bool, totalChunks int) []byte {
+	bitmap := make([]byte, (totalChunks+7)/8)
+	for index := range received {
+		if index >= 0 && index < totalChunks {
+			bitmap[index/8] |= 1 << (index % 8)
+		}
+	}
+	return bitmap
+}
+
+func decodeChunkBitmap(bitmap []byte, totalChunks int) map[int]bool {
+	received := make(map[int]bool)
+	for index := 0; index < totalChunks && index/8 < len(bitmap); index++ {
+		if bitmap[index/8]&(1<<(index%8)) != 0 {
+			received[index] = true
+		}
+	}
+	return received
+}
+
+// restoreChunkedUploads rebuilds the in-memory chunked uploads from the
+// database on startup and deletes chunk directories nothing refers to.
+// Chunks whose file is gone are dropped so the client sends them again, and
+// uploads that were being assembled when the server stopped can be
+// completed again.
+func restoreChunkedUploads() {
+	var rows []models.ChunkedUpload
+	if err := db.Find(&rows).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to load chunked uploads")
+		return
+	}
+
+	known := make(map[string]bool, len(rows))
+	restored := make([]*ChunkedUploadInfo, 0, len(rows))
+	for _, row := range rows {
+		if _, err := os.Stat(row.TempDir); err != nil {
+			log.WithField("uploadId", row.UploadID).Warning("Chunk directory of chunked upload is gone, dropping it")
+			forgetChunkedUpload(row.UploadID)
+			continue
+		}
+		known[filepath.Clean(row.TempDir)] = true
+
+		assembled := restoreAssembledChunks(row.TempDir, row.ChunkSize, row.TotalSize, row.AssembledChunks)
+		received := decodeChunkBitmap(row.ReceivedChunks, row.TotalChunks)
+		chunkBytes := make(map[int]int64)
+		for index := range received {
+			if index < assembled {
+				continue
+			}
+			stat, err := os.Stat(chunkFilePath(row.TempDir, index))
+			if err != nil {
+				delete(received, index)
+				continue
+			}
+			chunkBytes[index] = stat.Size()
+		}
+		for index := 0; index < assembled; index++ {
+			received[index] = true
+		}
+
+		var status string
+		switch {
+		case len(received) == row.TotalChunks:
+			status = "allChunksReceived"
+		case len(received) > 0:
+			status = "inProgress"
+		default:
+			status = "initialized"
+		}
+
+		restored = append(restored, &ChunkedUploadInfo{
+			ID:              row.UploadID,
+			UserID:          row.UserID,
+			Filename:        row.Filename,
+			ChunkSize:       row.ChunkSize,
+			TotalSize:       row.TotalSize,
+			TotalChunks:     row.TotalChunks,
+			UploadedChunks:  len(received),
+			ChunksReceived:  received,
+			ChunkBytes:      chunkBytes,
+			AssembledChunks: assembled,
+			TempDir:         row.TempDir,
+			Status:          status,
+			CreatedAt:       row.CreatedAt,
+			UpdatedAt:       row.UpdatedAt,
+			FileType:        row.FileType,
+			ContentType:     row.ContentType,
+			Compress:        row.Compress,
+			FileHash:        row.FileHash,
+		})
+	}
+
+	chunkedUploadsMutex.Lock()
+	for _, info := range restored {
+		chunkedUploads[info.ID] = info
+	}
+	chunkedUploadsMutex.Unlock()
+	for _, info := range restored {
+		saveChunkedUpload(info)
+	}
+
+	removed := 0
+	entries, err := os.ReadDir(chunkedUploadsRoot())
+	if err != nil && !os.IsNotExist(err) {
+		log.WithField("error", err.Error()).Warning("Failed to list chunked upload directories")
+	}
+	for _, entry := range entries {
+		dir := filepath.Join(chunkedUploadsRoot(), entry.Name())
+		if known[filepath.Clean(dir)] {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.WithField("dir", dir).WithField("error", err.Error()).Warning("Failed to remove orphaned chunk directory")
+			continue
+		}
+		removed++
+	}
+
+	if len(restored) > 0 || removed > 0 {
+		log.WithField("restored", len(restored)).
+			WithField("orphanedDirsRemoved", removed).
+			Info("Restored chunked uploads")
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+func TestConcurrentSavesKeepNewestState(t *testing.T) {
+	testDB := useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	info := newTestChunkedUpload(t, user.ID, bytes.Repeat([]byte("x"), 200), 10)
+
+	// The first state saved is the slowest to write, as a save that lost
+	// the race for the database would be.
+	testDB.Callback().Update().Before("gorm:update").Register("test:slow_first_save", func(tx *gorm.DB) {
+		if row, ok := tx.Statement.Dest.(*models.ChunkedUpload); ok && row.UploadedChunks == 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chunkedUploadsMutex.Lock()
+			info.UploadedChunks++
+			chunkedUploadsMutex.Unlock()
+			saveChunkedUpload(info)
+		}()
+	}
+	wg.Wait()
+
+	row, _ := storedChunkedUpload(t, info.ID)
+	if row.UploadedChunks != 20 {
+		t.Errorf("stored UploadedChunks = %d, want 20", row.UploadedChunks)
+	}
+}
+
+func TestSaveAfterForgetWritesNothing(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	info := newTestChunkedUpload(t, user.ID, bytes.Repeat([]byte("x"), 20), 10)
+
+	forgetChunkedUpload(info.ID)
+	if err := saveChunkedUpload(info); err != nil {
+		t.Fatalf("saveChunkedUpload: %v", err)
+	}
+	if _, ok := storedChunkedUpload(t, info.ID); ok {
+		t.Errorf("forgotten upload was saved again")
+	}
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConcurrentUploadsOfSameChunkCountOnce(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	data := bytes.Repeat([]byte("x"), 30)
+	info := newTestChunkedUpload(t, user.ID, data, 10)
+
+	target := fmt.Sprintf("/api/v1/upload/chunk?uploadId=%s&chunkIndex=1", info.ID)
+	contexts := make([]*gin.Context, 20)
+	recorders := make([]*httptest.ResponseRecorder, len(contexts))
+	for i := range contexts {
+		contexts[i], recorders[i] = newMultipartRequest(t, target, nil, []testFile{{field: "chunk", name: "chunk", data: data[10:20]}}, user)
+	}
+	var wg sync.WaitGroup
+	for _, c := range contexts {
+		wg.Add(1)
+		go func(c *gin.Context) {
+			defer wg.Done()
+			UploadChunk(c)
+		}(c)
+	}
+	wg.Wait()
+
+	for i, recorder := range recorders {
+		if recorder.Code != http.StatusOK && recorder.Code != http.StatusConflict {
+			t.Errorf("request %d: status = %d, want %d or %d", i, recorder.Code, http.StatusOK, http.StatusConflict)
+		}
+	}
+	chunkedUploadsMutex.RLock()
+	uploaded, received := info.UploadedChunks, len(info.ChunksReceived)
+	chunkedUploadsMutex.RUnlock()
+	if uploaded != 1 || received != 1 {
+		t.Errorf("UploadedChunks = %d with %d chunks received, want 1", uploaded, received)
+	}
+	row, _ := storedChunkedUpload(t, info.ID)
+	if row.UploadedChunks != 1 {
+		t.Errorf("stored UploadedChunks = %d, want 1", row.UploadedChunks)
+	}
+}
+
+func TestValidateChunkLayout(t *testing.T) {
+	useTestConfig(t)
+	cfg.Upload.MinChunkSize = 10
+	cfg.Upload.MaxChunkSize = 100
+	cfg.Upload.MaxChunks = 5
+
+	tests := []struct {
+		name        string
+		totalSize   int64
+		chunkSize   int64
+		totalChunks int
+		ok          bool
+	}{
+		{"chunks fill the file exactly", 30, 10, 3, true},
+		{"last chunk one byte long", 31, 10, 4, true},
+		{"last chunk one byte short", 29, 10, 3, true},
+		{"one byte past the declared chunks", 31, 10, 3, false},
+		{"one chunk too many", 30, 10, 4, false},
+		{"single chunk below the minimum", 5, 5, 1, true},
+		{"chunks below the minimum", 18, 9, 2, false},
+		{"chunk at the maximum", 100, 100, 1, true},
+		{"chunk over the maximum", 101, 101, 1, false},
+		{"at the chunk count limit", 50, 10, 5, true},
+		{"over the chunk count limit", 60, 10, 6, false},
+		{"zero total size", 0, 10, 1, false},
+		{"zero chunk size", 30, 0, 3, false},
+		{"negative chunk count", 30, 10, -1, false},
+	}
+	for _, test := range tests {
+		err := validateChunkLayout(test.totalSize, test.chunkSize, test.totalChunks)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: validateChunkLayout(%d, %d, %d) = %v, want ok %v", test.name, test.totalSize, test.chunkSize, test.totalChunks, err, test.ok)
+		}
+	}
+}
+
+func TestInitChunkedUploadRejectsInconsistentLayout(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	createTestProofSet(t, user, "42")
+
+	c, recorder := newJSONRequest(t, http.MethodPost, "/api/v1/chunked-upload/init", gin.H{
+		"filename":    "a.bin",
+		"totalSize":   cfg.Upload.MinChunkSize*3 + 1,
+		"chunkSize":   cfg.Upload.MinChunkSize,
+		"totalChunks": 3,
+		"fileType":    "application/octet-stream",
+	}, user)
+	InitChunkedUpload(c)
+
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusUnprocessableEntity, recorder.Body)
+	}
+	var response struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if !strings.Contains(response.Error, "makes 4 chunks, not 3") {
+		t.Errorf("error = %q, want it to explain the chunk count", response.Error)
+	}
+}
+
+func TestExpectedChunkSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		totalSize int64
+		index     int
+		want      int64
+	}{
+		{"first chunk", 31, 0, 10},
+		{"chunk before the last", 31, 2, 10},
+		{"one-byte last chunk", 31, 3, 1},
+		{"full last chunk", 40, 3, 10},
+		{"short last chunk", 39, 3, 9},
+	}
+	for _, test := range tests {
+		info := &ChunkedUploadInfo{ChunkSize: 10, TotalSize: test.totalSize, TotalChunks: int((test.totalSize + 9) / 10)}
+		if got := expectedChunkSize(info, test.index); got != test.want {
+			t.Errorf("%s: expectedChunkSize(%d of %d bytes) = %d, want %d", test.name, test.index, test.totalSize, got, test.want)
+		}
+	}
+}
+
+func TestUploadChunkChecksChunkLength(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	// The last of four chunks holds the one byte past a multiple of the
+	// chunk size.
+	data := bytes.Repeat([]byte("x"), 31)
+
+	tests := []struct {
+		name  string
+		index int
+		size  int
+		want  int
+	}{
+		{"full chunk", 0, 10, http.StatusOK},
+		{"short chunk", 1, 9, http.StatusUnprocessableEntity},
+		{"long chunk", 1, 11, http.StatusUnprocessableEntity},
+		{"one-byte last chunk", 3, 1, http.StatusOK},
+		{"full-size last chunk", 3, 10, http.StatusUnprocessableEntity},
+		{"empty last chunk", 3, 0, http.StatusUnprocessableEntity},
+	}
+	for _, test := range tests {
+		info := newTestChunkedUpload(t, user.ID, data, 10)
+		target := fmt.Sprintf("/api/v1/upload/chunk?uploadId=%s&chunkIndex=%d", info.ID, test.index)
+		c, recorder := newMultipartRequest(t, target, nil, []testFile{{field: "chunk", name: "chunk", data: bytes.Repeat([]byte("x"), test.size)}}, user)
+		UploadChunk(c)
+
+		if recorder.Code != test.want {
+			t.Errorf("%s: status = %d, want %d: %s", test.name, recorder.Code, test.want, recorder.Body)
+			continue
+		}
+		chunkedUploadsMutex.RLock()
+		received := info.ChunksReceived[test.index]
+		chunkedUploadsMutex.RUnlock()
+		if received != (test.want == http.StatusOK) {
+			t.Errorf("%s: chunk counted as received = %v", test.name, received)
+		}
+	}
+}
+
+func TestCompleteChunkedUploadNamesShortChunk(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	useTestUploadQueue(t)
+	fakePdptool(t, "exit 1")
+	user := createTestUser(t, "0x1")
+	createTestProofSet(t, user, "42")
+
+	data := bytes.Repeat([]byte("0123456789"), 3)
+	info := newTestChunkedUpload(t, user.ID, data, 10, 0, 1, 2)
+	// The server stopped while chunk 1 was being written, after only 7 of
+	// its bytes reached the disk.
+	if err := os.WriteFile(chunkFilePath(info.TempDir, 1), data[10:17], 0644); err != nil {
+		t.Fatal(err)
+	}
+	info.ChunkBytes[1] = 7
+
+	c, recorder := newJSONRequest(t, http.MethodPost, "/api/v1/chunked-upload/complete", gin.H{"uploadId": info.ID}, user)
+	CompleteChunkedUpload(c)
+
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusUnprocessableEntity, recorder.Body)
+	}
+	var response struct {
+		BadChunks []int `json:"badChunks"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if len(response.BadChunks) != 1 || response.BadChunks[0] != 1 {
+		t.Errorf("badChunks = %v, want [1]", response.BadChunks)
+	}
+	chunkedUploadsMutex.RLock()
+	received, status := info.ChunksReceived[1], info.Status
+	chunkedUploadsMutex.RUnlock()
+	if received || status != "inProgress" {
+		t.Errorf("chunk 1 received = %v with status %q, want it to be asked for again", received, status)
+	}
+	if row, _ := storedChunkedUpload(t, info.ID); row.UploadedChunks != 2 {
+		t.Errorf("stored UploadedChunks = %d, want 2", row.UploadedChunks)
+	}
+
+	// Only the short chunk has to be sent again.
+	target := fmt.Sprintf("/api/v1/upload/chunk?uploadId=%s&chunkIndex=1", info.ID)
+	c, recorder = newMultipartRequest(t, target, nil, []testFile{{field: "chunk", name: "chunk", data: data[10:20]}}, user)
+	UploadChunk(c)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("re-send chunk 1: status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	c, recorder = newJSONRequest(t, http.MethodPost, "/api/v1/chunked-upload/complete", gin.H{"uploadId": info.ID}, user)
+	CompleteChunkedUpload(c)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("complete after re-send: status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	waitForChunkedStatus(t, info, "processing")
+}
+
+func TestUploadChunkNamesShortChunk(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	info := newTestChunkedUpload(t, user.ID, bytes.Repeat([]byte("x"), 30), 10)
+
+	target := fmt.Sprintf("/api/v1/upload/chunk?uploadId=%s&chunkIndex=2", info.ID)
+	c, recorder := newMultipartRequest(t, target, nil, []testFile{{field: "chunk", name: "chunk", data: []byte("xxxx")}}, user)
+	UploadChunk(c)
+
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusUnprocessableEntity, recorder.Body)
+	}
+	var response struct {
+		Error      string `json:"error"`
+		ChunkIndex int    `json:"chunkIndex"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if response.ChunkIndex != 2 || !strings.Contains(response.Error, "Chunk 2 ") {
+		t.Errorf("response = %s, want it to name chunk 2", recorder.Body)
+	}
+}
@@ -0,0 +1,487 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	errCollectionNotFound = errors.New("collection not found")
+	errCollectionCycle    = errors.New("a collection can't be moved into itself or one of its own collections")
+)
+
+// optionalID is a JSON ID field that tells apart a missing field, which
+// leaves a value unchanged, from null, which clears it.
+type optionalID struct {
+	Set   bool
+	Value *uint
+}
+
+func (o *optionalID) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Value = nil
+		return nil
+	}
+	var id uint
+	if err := json.Unmarshal(data, &id); err != nil {
+		return err
+	}
+	o.Value = &id
+	return nil
+}
+
+// CollectionRequest is the body of a request to create or change a
+// collection. When changing one, missing fields are left as they are and a
+// null parentId moves it to the top level.
+type CollectionRequest struct {
+	Name     *string    `json:"name"`
+	ParentID optionalID `json:"parentId" swaggertype:"integer"`
+}
+
+// CollectionResponse is a collection with how many files are directly in it.
+type CollectionResponse struct {
+	ID         uint      `json:"id"`
+	ParentID   *uint     `json:"parentId"`
+	Name       string    `json:"name"`
+	PieceCount int64     `json:"pieceCount"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// collectionResponses builds responses for collections, counting their
+// files in one query.
+func collectionResponses(collections []models.Collection) ([]CollectionResponse, error) {
+	ids := make([]uint, len(collections))
+	for i, collection := range collections {
+		ids[i] = collection.ID
+	}
+	counts := make(map[uint]int64)
+	if len(ids) > 0 {
+		var rows []struct {
+			CollectionID uint
+			Count        int64
+		}
+		if err := db.Model(&models.Piece{}).
+			Select("collection_id, COUNT(*) AS count").
+			Where("collection_id IN ?", ids).
+			Group("collection_id").
+			Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			counts[row.CollectionID] = row.Count
+		}
+	}
+
+	responses := make([]CollectionResponse, len(collections))
+	for i, collection := range collections {
+		responses[i] = CollectionResponse{
+			ID:         collection.ID,
+			ParentID:   collection.ParentID,
+			Name:       collection.Name,
+			PieceCount: counts[collection.ID],
+			CreatedAt:  collection.CreatedAt,
+			UpdatedAt:  collection.UpdatedAt,
+		}
+	}
+	return responses, nil
+}
+
+// collectionName cleans a collection name as filenames are cleaned. The
+// returned error describes a name that is empty or too long.
+func collectionName(name string) (string, error) {
+	name = cleanFilename(name)
+	if name == "" {
+		return "", errors.New("name must not be empty")
+	}
+	if utf8.RuneCountInString(name) > cfg.Upload.MaxFilenameLength {
+		return "", fmt.Errorf("name must be at most %d characters", cfg.Upload.MaxFilenameLength)
+	}
+	return name, nil
+}
+
+// findCollection loads one of the user's collections.
+func findCollection(tx *gorm.DB, userID interface{}, id interface{}) (models.Collection, error) {
+	var collection models.Collection
+	err := tx.Where("id = ? AND user_id = ?", id, userID).First(&collection).Error
+	if err == gorm.ErrRecordNotFound {
+		return collection, errCollectionNotFound
+	}
+	return collection, err
+}
+
+// collectionTree returns the IDs of a collection and every collection
+// nested in it.
+func collectionTree(tx *gorm.DB, id uint) ([]uint, error) {
+	var ids []uint
+	err := tx.Raw(`
+		WITH RECURSIVE tree AS (
+			SELECT id FROM collections WHERE id = ?
+			UNION ALL
+			SELECT collections.id FROM collections JOIN tree ON collections.parent_id = tree.id
+		)
+		SELECT id FROM tree`, id).Scan(&ids).Error
+	return ids, err
+}
+
+// respondCollectionError reports an error looking up or changing a
+// collection.
+func respondCollectionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errCollectionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Collection not found",
+		})
+	case errors.Is(err, errCollectionCycle):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "A collection can't be moved into itself or one of its own collections",
+		})
+	default:
+		log.WithField("error", err.Error()).Error("Failed to update collection")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update collection",
+		})
+	}
+}
+
+// @Summary Create a collection
+// @Description Create a collection to organise files in, at the top level or inside another of the caller's collections.
+// @Tags collections
+// @Accept json
+// @Param request body CollectionRequest true "Name and optional parent collection"
+// @Produce json
+// @Success 201 {object} CollectionResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /api/v1/collections [post]
+func CreateCollection(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var request CollectionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+	if request.Name == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "name is required",
+		})
+		return
+	}
+	name, err := collectionName(*request.Name)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	collection := models.Collection{UserID: userID.(uint), Name: name, ParentID: request.ParentID.Value}
+	if collection.ParentID != nil {
+		if _, err := findCollection(db, userID, *collection.ParentID); err != nil {
+			respondCollectionError(c, err)
+			return
+		}
+	}
+	if err := db.Create(&collection).Error; err != nil {
+		respondCollectionError(c, err)
+		return
+	}
+
+	responses, err := collectionResponses([]models.Collection{collection})
+	if err != nil {
+		respondCollectionError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, responses[0])
+}
+
+// @Summary List collections
+// @Description List all of the caller's collections, with how many files are directly in each. Nesting is given by parentId.
+// @Tags collections
+// @Produce json
+// @Success 200 {array} CollectionResponse
+// @Router /api/v1/collections [get]
+func ListCollections(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var collections []models.Collection
+	if err := db.Where("user_id = ?", userID).Order("name ASC, id ASC").Find(&collections).Error; err != nil {
+		log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to list collections")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list collections",
+		})
+		return
+	}
+	responses, err := collectionResponses(collections)
+	if err != nil {
+		log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to count collection files")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list collections",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, responses)
+}
+
+// @Summary Get a collection
+// @Tags collections
+// @Param id path int true "Collection ID"
+// @Produce json
+// @Success 200 {object} CollectionResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/collections/{id} [get]
+func GetCollection(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	collection, err := findCollection(db, userID, c.Param("id"))
+	if err != nil {
+		respondCollectionError(c, err)
+		return
+	}
+	responses, err := collectionResponses([]models.Collection{collection})
+	if err != nil {
+		respondCollectionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, responses[0])
+}
+
+// @Summary Rename or move a collection
+// @Description Change a collection's name or parent. A null parentId moves it to the top level; it can't be moved into itself or a collection nested in it.
+// @Tags collections
+// @Accept json
+// @Param id path int true "Collection ID"
+// @Param request body CollectionRequest true "Fields to change"
+// @Produce json
+// @Success 200 {object} CollectionResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /api/v1/collections/{id} [patch]
+func UpdateCollection(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var request CollectionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+
+	updates := make(map[string]interface{})
+	if request.Name != nil {
+		name, err := collectionName(*request.Name)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		updates["name"] = name
+	}
+
+	var collection models.Collection
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		collection, err = findCollection(tx, userID, c.Param("id"))
+		if err != nil {
+			return err
+		}
+		if request.ParentID.Set {
+			if parentID := request.ParentID.Value; parentID != nil {
+				if _, err := findCollection(tx, userID, *parentID); err != nil {
+					return err
+				}
+				tree, err := collectionTree(tx, collection.ID)
+				if err != nil {
+					return err
+				}
+				for _, id := range tree {
+					if id == *parentID {
+						return errCollectionCycle
+					}
+				}
+			}
+			updates["parent_id"] = request.ParentID.Value
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		if err := tx.Model(&collection).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.First(&collection, collection.ID).Error
+	})
+	if err != nil {
+		respondCollectionError(c, err)
+		return
+	}
+
+	responses, err := collectionResponses([]models.Collection{collection})
+	if err != nil {
+		respondCollectionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, responses[0])
+}
+
+// DeleteCollectionResponse reports a deleted collection and, when its files
+// were deleted too, how each deletion went.
+type DeleteCollectionResponse struct {
+	Deleted []uint                `json:"deleted"`
+	Pieces  []DeletePieceResponse `json:"pieces,omitempty"`
+	Error   string                `json:"error,omitempty"`
+}
+
+// @Summary Delete a collection
+// @Description Delete a collection. With mode=orphan, the default, only the collection is deleted: its files and the collections in it move to the top level. With mode=cascade, the collections nested in it and all of their files are deleted too, each file as DELETE /api/v1/pieces/{id} does. If any file can't be deleted the collections are kept and the response lists what happened to each file.
+// @Tags collections
+// @Param id path int true "Collection ID"
+// @Param mode query string false "orphan or cascade" default(orphan)
+// @Produce json
+// @Success 200 {object} DeleteCollectionResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 502 {object} DeleteCollectionResponse
+// @Router /api/v1/collections/{id} [delete]
+func DeleteCollection(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	mode := c.DefaultQuery("mode", "orphan")
+	if mode != "orphan" && mode != "cascade" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "mode must be orphan or cascade",
+		})
+		return
+	}
+
+	collection, err := findCollection(db, userID, c.Param("id"))
+	if err != nil {
+		respondCollectionError(c, err)
+		return
+	}
+
+	deleted := []uint{collection.ID}
+	var response DeleteCollectionResponse
+	if mode == "cascade" {
+		deleted, err = collectionTree(db, collection.ID)
+		if err != nil {
+			respondCollectionError(c, err)
+			return
+		}
+
+		var pieces []models.Piece
+		if err := db.Where("collection_id IN ? AND pending_removal = ?", deleted, false).Find(&pieces).Error; err != nil {
+			respondCollectionError(c, err)
+			return
+		}
+		failed := false
+		for _, piece := range pieces {
+			result, status := deletePiece(c.Request.Context(), piece)
+			if status != http.StatusOK {
+				failed = true
+			}
+			response.Pieces = append(response.Pieces, result)
+		}
+		if failed {
+			response.Error = "Some files could not be deleted, so the collection was kept"
+			c.JSON(http.StatusBadGateway, response)
+			return
+		}
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Files pending removal, and in orphan mode every file, are left
+		// outside any collection.
+		if err := tx.Unscoped().Model(&models.Piece{}).
+			Where("collection_id IN ?", deleted).
+			Update("collection_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Collection{}).
+			Where("parent_id IN ? AND id NOT IN ?", deleted, deleted).
+			Update("parent_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", deleted).Delete(&models.Collection{}).Error
+	})
+	if err != nil {
+		respondCollectionError(c, err)
+		return
+	}
+
+	log.WithField("collectionID", collection.ID).WithField("mode", mode).WithField("deleted", len(deleted)).Info("Deleted collection")
+	response.Deleted = deleted
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary List a collection's files
+// @Description List the files directly in one of the caller's collections. Takes the same paging, sorting and filter parameters as GET /api/v1/pieces, and answers If-None-Match with 304 the same way.
+// @Tags collections
+// @Param id path int true "Collection ID"
+// @Produce json
+// @Success 200 {object} PieceListResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/collections/{id}/pieces [get]
+func ListCollectionPieces(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	collection, err := findCollection(db, userID, c.Param("id"))
+	if err != nil {
+		respondCollectionError(c, err)
+		return
+	}
+	listPieces(c, userID, func(query *gorm.DB) *gorm.DB {
+		return query.Where("collection_id = ?", collection.ID)
+	})
+}
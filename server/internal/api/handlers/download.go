@@ -1,27 +1,47 @@
 package handlers
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
 )
 
 // @Summary Download a file from PDP service
-// @Description Download a file from the PDP service using its CID
+// @Description Download a file from the PDP service using its CID. The file is checked against the SHA-256 recorded at upload time before it is sent, unless verify=false. A file that doesn't match is not sent and 502 integrity_failure is returned.
 // @Tags download
 // @Accept json
-// @Param cid path string true "CID of the file to download"
+// @Param cid path string true "CID of the file to download, either the compound base:subroot CID it is stored under or just its base CID"
+// @Param verify query bool false "Set to false to skip checking the file against its stored SHA-256 checksum"
+// @Param path query string false "For pieces uploaded as a directory, the path of a single file to extract"
+// @Param inline query bool false "Ask for images, video, audio, PDFs and plain text to be shown in the browser rather than saved"
+// @Param gateway query bool false "Redirect to the first configured IPFS gateway that responds, falling back to a direct download"
+// @Param Range header string false "A single byte range, e.g. bytes=0-1023"
+// @Param If-None-Match header string false "ETag of a copy the client already has"
 // @Produce octet-stream
 // @Success 200 {file} binary "File content"
+// @Success 206 {file} binary "Requested range of the file"
+// @Success 302 "Redirect to an IPFS gateway"
+// @Success 304 "The file matches the ETag given in If-None-Match"
+// @Failure 400 {object} ErrorResponse
+// @Failure 416 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 501 {object} ErrorResponse
+// @Failure 502 {object} ErrorResponse
 // @Router /api/v1/download/{cid} [get]
+// @Router /api/v1/download/{cid} [head]
 func DownloadFile(c *gin.Context) {
 	if db == nil {
 		log.Error("Database connection not initialized")
@@ -38,50 +58,135 @@ func DownloadFile(c *gin.Context) {
 		})
 		return
 	}
-
-	var piece models.Piece
-	if err := db.Where("c_id = ?", cid).First(&piece).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "Piece not found",
+	if !pieceCIDFormat.MatchString(cid) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid CID",
 		})
 		return
 	}
 
-	pdptoolPath := cfg.PdptoolPath
-	if pdptoolPath == "" {
-		log.Error("PDPTool path not configured in environment/config")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Server configuration error: PDPTool path missing",
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
 		})
 		return
 	}
 
-	if _, err := os.Stat(pdptoolPath); os.IsNotExist(err) {
-		log.WithField("path", pdptoolPath).Error("pdptool not found at configured path")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "pdptool executable not found at configured path",
+	// The same CID may be stored by several users, each with their own
+	// filename and size, so the caller's piece is used when they have one.
+	// Other users' pieces are only served when shared with the caller or
+	// publicly, and are otherwise reported as not found so their existence
+	// isn't revealed.
+	piece, err := findPieceByCID(cid, userID)
+	if err == gorm.ErrRecordNotFound && cfg.Upload.PublicDownloads {
+		err = whereCID(db, cid).Where("public = ?", true).Order("created_at ASC").First(&piece).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Piece not found",
 		})
 		return
 	}
 
-	// Change working directory to pdptool directory
-	pdptoolDir := getPdptoolParentDir(pdptoolPath)
-	if err := os.Chdir(pdptoolDir); err != nil {
-		log.Error(fmt.Sprintf("Failed to change working directory to pdptool directory: %v", err))
+	var member *models.ArchiveEntry
+	if rawPath := c.Query("path"); rawPath != "" {
+		memberPath, ok := cleanArchivePath(rawPath)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid path",
+			})
+			return
+		}
+		var entry models.ArchiveEntry
+		if err := db.Where("piece_id = ? AND path = ?", piece.ID, memberPath).First(&entry).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "File not found in directory",
+			})
+			return
+		}
+		member = &entry
+	}
+
+	// HEAD and conditional requests for the whole file are answered from
+	// the piece record, without fetching the file.
+	if member == nil {
+		if c.Request.Method == http.MethodHead {
+			setDownloadHeaders(c, piece, nil)
+			c.Header("Content-Length", strconv.FormatInt(piece.Size, 10))
+			c.Header("Accept-Ranges", "bytes")
+			c.Header("Last-Modified", piece.CreatedAt.UTC().Format(http.TimeFormat))
+			c.Status(http.StatusOK)
+			return
+		}
+		if piece.Checksum != nil && *piece.Checksum != "" && etagMatches(c.GetHeader("If-None-Match"), *piece.Checksum) {
+			c.Header("ETag", `"`+*piece.Checksum+`"`)
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+
+	// Gateway redirects skip the checksum check, since the file never
+	// passes through here. When no gateway answers, or the piece can't be
+	// served by one, the file is fetched with pdptool as usual.
+	if c.Query("gateway") == "true" {
+		if len(cfg.Download.Gateways) == 0 {
+			c.JSON(http.StatusNotImplemented, gin.H{
+				"error": "Gateway downloads are not configured on this server",
+			})
+			return
+		}
+		if member == nil && gatewayServable(piece) {
+			if target, gateway, ok := findGateway(c.Request.Context(), baseCID(piece.CID), piece.Filename); ok {
+				log.WithField("cid", piece.CID).WithField("gateway", gateway).Info("Redirecting download to gateway")
+				c.Header("X-Download-Gateway", gateway)
+				c.Redirect(http.StatusFound, target)
+				logPieceDownload(c, piece, nil)
+				return
+			}
+			log.WithField("cid", piece.CID).Warning("No gateway answered, downloading with pdptool")
+		}
+	}
+
+	release, ok := limitDownload(c, userID.(uint))
+	if !ok {
+		return
+	}
+	defer release()
+
+	sendPiece(c, piece, member, userID.(uint))
+}
+
+// sendPiece fetches a piece from the PDP service, or the download cache, and
+// sends it, or just member when the piece is a directory archive. The bytes
+// sent are recorded as download usage of usageUserID.
+func sendPiece(c *gin.Context, piece models.Piece, member *models.ArchiveEntry, usageUserID uint) {
+	cid := piece.CID
+	pdptoolPath, err := preparePdptool(c.Request.Context())
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to set working directory",
+			"error": err.Error(),
 		})
 		return
 	}
-	log.WithField("pdptoolDir", pdptoolDir).Info("Changed working directory to pdptool directory")
-
-	log.WithField("path", pdptoolPath).Info("Using pdptool at path")
 
-	processCid := cid
-	if parts := strings.Split(cid, ":"); len(parts) > 0 {
-		processCid = parts[0]
+	// Downloads are checked against the checksum recorded at upload unless
+	// the caller opts out. Pieces stored before checksums were recorded can
+	// only be served unverified, unless verification was asked for.
+	hasChecksum := piece.Checksum != nil && *piece.Checksum != ""
+	verify := c.Query("verify") != "false"
+	if verify && !hasChecksum {
+		if c.Query("verify") == "true" {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": "No checksum was recorded for this piece, it cannot be verified",
+			})
+			return
+		}
+		verify = false
 	}
 
+	processCid := baseCID(cid)
+
 	tempDir, err := os.MkdirTemp("", "pdp-download-*")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -91,76 +196,237 @@ func DownloadFile(c *gin.Context) {
 	}
 	defer os.RemoveAll(tempDir)
 
-	chunkFile := filepath.Join(tempDir, "chunks.txt")
-	if err := os.WriteFile(chunkFile, []byte(processCid), 0644); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to create chunk file: %v", err),
+	downloadTo := pieceDownloader(c.Request.Context(), pdptoolPath, piece, tempDir)
+	fetch := func(outputFile string) error {
+		return downloadTo(outputFile, true)
+	}
+
+	// When streaming, the first fetch sends the file while pdptool is still
+	// writing it. A second fetch, after the cached copy was evicted before
+	// it could be opened, is an ordinary one.
+	streamed := false
+	if downloadStreamable(c, piece, member) {
+		fetchFile := fetch
+		fetch = func(outputFile string) error {
+			if streamed {
+				return fetchFile(outputFile)
+			}
+			streamed = true
+			return streamWhileFetching(c, piece, verify, usageUserID, outputFile, func(outputFile string) error {
+				return downloadTo(outputFile, false)
+			})
+		}
+	}
+
+	raw, err := openRawPiece(piece, tempDir, fetch)
+	if streamed {
+		// Unless it failed before the first byte, the response has been
+		// sent, or cut short, already.
+		if err != nil {
+			log.WithField("cid", cid).WithField("error", err.Error()).Error("Streamed download failed")
+			if !c.Writer.Written() {
+				respondDownloadFailed(c, err)
+			}
+			return
+		}
+		raw.Close()
+		logPieceDownload(c, piece, member)
+		return
+	}
+	if err != nil {
+		respondDownloadFailed(c, err)
+		return
+	}
+	defer raw.Close()
+
+	file, size, err := openPieceContent(piece, raw, tempDir)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errPieceDecompress) {
+			log.WithField("cid", cid).WithField("error", err.Error()).Error("Failed to decompress downloaded file")
+			status = http.StatusBadGateway
+		}
+		c.JSON(status, gin.H{
+			"error": err.Error(),
 		})
 		return
 	}
+	if file != raw {
+		defer file.Close()
+	}
+	content := io.NewSectionReader(file, 0, size)
 
-	outputFile := filepath.Join(tempDir, piece.Filename)
-	downloadCmd := exec.Command(
-		pdptoolPath,
-		"download-file",
-		"--service-url", piece.ServiceURL,
-		"--chunk-file", chunkFile,
-		"--output-file", outputFile,
-	)
+	if verify {
+		hasher := sha256.New()
+		if _, err := io.Copy(hasher, content); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to read downloaded file: %v", err),
+			})
+			return
+		}
+		actual := hex.EncodeToString(hasher.Sum(nil))
+		if actual != *piece.Checksum {
+			log.WithField("cid", cid).
+				WithField("pieceID", piece.ID).
+				WithField("expected", *piece.Checksum).
+				WithField("actual", actual).
+				Error("Downloaded file does not match stored checksum")
+			// Don't serve the same bad copy from the cache next time.
+			if downloads != nil {
+				downloads.remove(processCid)
+			}
+			c.JSON(http.StatusBadGateway, gin.H{
+				"error":    "integrity_failure",
+				"message":  "Downloaded file does not match the stored checksum",
+				"expected": *piece.Checksum,
+				"actual":   actual,
+			})
+			return
+		}
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": fmt.Sprintf("Failed to rewind downloaded file: %v", err),
+			})
+			return
+		}
 
-	log.WithField("command", "download-file").
-		WithField("serviceURL", piece.ServiceURL).
-		WithField("chunkFile", chunkFile).
-		WithField("outputFile", outputFile).
-		WithField("cid", cid).
-		WithField("processCid", processCid).
-		WithField("filename", piece.Filename).
-		Info("Executing download-file command")
+		recordPieceVerification(piece, verifyResultOK, time.Now())
+		c.Header("X-Verified", "true")
+	}
 
-	var errOutput bytes.Buffer
-	downloadCmd.Stderr = &errOutput
+	if member != nil {
+		logPieceDownload(c, piece, member)
+		streamArchiveMember(c, content, member, usageUserID)
+		return
+	}
 
-	if err := downloadCmd.Run(); err != nil {
-		errorMsg := fmt.Sprintf("Failed to download file: %v", err)
-		log.WithField("error", err.Error()).WithField("stderr", errOutput.String()).Error(errorMsg)
+	sniff := make([]byte, sniffLen)
+	n, _ := content.ReadAt(sniff, 0)
+	setDownloadHeaders(c, piece, sniff[:n])
 
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   errorMsg,
-			"details": err.Error(),
-			"stderr":  errOutput.String(),
-		})
+	written, err := serveDownload(c, usageUserID, piece.ID, piece.CreatedAt, content, size)
+	if err != nil {
+		log.WithField("error", err.Error()).WithField("bytesSent", written).Error("Failed to stream file to response")
 		return
 	}
+	logPieceDownload(c, piece, nil)
+}
 
-	file, err := os.Open(outputFile)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to open downloaded file: %v", err),
+// setDownloadHeaders sets the headers describing a piece's file. head is the
+// start of the file, to tell its type by when none was recorded, or nil when
+// the file hasn't been fetched.
+func setDownloadHeaders(c *gin.Context, piece models.Piece, head []byte) {
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Transfer-Encoding", "binary")
+	// Pieces stored before content types were recorded, or whose type
+	// couldn't be told, are sniffed from their first bytes.
+	contentType := piece.ContentType
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = "application/octet-stream"
+		if head != nil {
+			contentType = sniffContentType(head)
+		}
+	}
+	disposition := "attachment"
+	if c.Query("inline") == "true" && previewableTypes[contentType] {
+		disposition = "inline"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Disposition", contentDisposition(disposition, piece.Filename))
+	if piece.Checksum != nil && *piece.Checksum != "" {
+		c.Header("X-Checksum-SHA256", *piece.Checksum)
+		// The checksum identifies the contents, so If-Range can be
+		// checked against it.
+		c.Header("ETag", `"`+*piece.Checksum+`"`)
+	}
+	c.Header("Cache-Control", "private, no-cache, no-store, must-revalidate")
+	c.Header("Pragma", "no-cache")
+	c.Header("Expires", "0")
+}
+
+// serveDownload sends content to the response, honouring Range and If-Range
+// headers, and records the bytes sent as download usage of userID. Only a
+// single range is served; requests for several are answered with 416 rather
+// than a multipart response.
+func serveDownload(c *gin.Context, userID uint, pieceID uint, modTime time.Time, content io.ReadSeeker, size int64) (int64, error) {
+	if ranges := c.GetHeader("Range"); strings.Contains(ranges, ",") {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+		c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{
+			"error": "Only a single byte range may be requested",
 		})
-		return
+		return 0, nil
 	}
-	defer file.Close()
 
-	fileInfo, err := file.Stat()
-	if err != nil {
+	counter := &countingResponseWriter{ResponseWriter: c.Writer}
+	http.ServeContent(counter, c.Request, "", modTime, content)
+	recordUsage(userID, pieceID, usageDirectionDownload, counter.n)
+	if err := c.Request.Context().Err(); err != nil {
+		return counter.n, err
+	}
+	return counter.n, nil
+}
+
+// streamArchiveMember sends a single file out of a downloaded directory
+// archive, using the offset recorded in the manifest.
+func streamArchiveMember(c *gin.Context, archive io.ReaderAt, member *models.ArchiveEntry, usageUserID uint) {
+	section := io.NewSectionReader(archive, member.Offset, member.Size)
+
+	sniff := make([]byte, sniffLen)
+	n, _ := io.ReadFull(section, sniff)
+	contentType := sniffContentType(sniff[:n])
+	if _, err := section.Seek(0, io.SeekStart); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to get file info: %v", err),
+			"error": fmt.Sprintf("Failed to read file from directory: %v", err),
 		})
 		return
 	}
 
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
-	encodedFilename := strings.ReplaceAll(piece.Filename, `"`, `\"`)
-	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, encodedFilename))
+	disposition := "attachment"
+	if c.Query("inline") == "true" && previewableTypes[contentType] {
+		disposition = "inline"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Disposition", contentDisposition(disposition, path.Base(member.Path)))
 	c.Header("Cache-Control", "private, no-cache, no-store, must-revalidate")
 	c.Header("Pragma", "no-cache")
 	c.Header("Expires", "0")
 
-	if _, err := io.Copy(c.Writer, file); err != nil {
-		log.WithField("error", err.Error()).Error("Failed to stream file to response")
+	if _, err := serveDownload(c, usageUserID, member.PieceID, member.CreatedAt, section, member.Size); err != nil {
+		log.WithField("error", err.Error()).Error("Failed to stream directory member to response")
+	}
+}
+
+// etagMatches reports whether an If-None-Match header lists the ETag of a
+// file with the given checksum. Weak ETags match, as they do for GET.
+func etagMatches(header, checksum string) bool {
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == `"`+checksum+`"` {
+			return true
+		}
+	}
+	return false
+}
+
+// countPieceDownloads adds one to the download count of each piece and
+// sets when it was last downloaded. The counts are incremented in the
+// database so concurrent downloads aren't lost, and updated_at is left
+// alone since the files themselves haven't changed.
+func countPieceDownloads(pieceIDs ...uint) {
+	if db == nil || len(pieceIDs) == 0 {
 		return
 	}
+	if err := db.Model(&models.Piece{}).Where("id IN ?", pieceIDs).UpdateColumns(map[string]interface{}{
+		"download_count":     gorm.Expr("download_count + 1"),
+		"last_downloaded_at": time.Now(),
+	}).Error; err != nil {
+		log.WithField("pieceIDs", pieceIDs).WithField("error", err.Error()).Error("Failed to count piece downloads")
+	}
 }
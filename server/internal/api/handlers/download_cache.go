@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// downloadCache keeps files fetched from the PDP service on disk, keyed by
+// base CID, and evicts the least recently used ones once they take more than
+// maxSize bytes. Files are cached as the service returns them, before any
+// decompression or padding removal, so every piece with the CID can use them.
+type downloadCache struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is most recently used
+	size    int64
+
+	fetches singleflight.Group
+	hits    atomic.Int64
+	misses  atomic.Int64
+}
+
+type downloadCacheEntry struct {
+	cid  string
+	size int64
+}
+
+// DownloadCacheStats describes the download cache's contents and how often
+// downloads were served from it.
+type DownloadCacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+var downloads *downloadCache
+
+// newDownloadCache opens the cache in dir, indexing files left there by a
+// previous run by their modification time. It returns nil when maxSize is 0.
+func newDownloadCache(dir string, maxSize int64) *downloadCache {
+	if maxSize <= 0 {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		log.WithField("dir", dir).WithField("error", err.Error()).Error("Failed to create download cache directory, downloads will not be cached")
+		return nil
+	}
+
+	cache := &downloadCache{
+		dir:     dir,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.WithField("dir", dir).WithField("error", err.Error()).Warning("Failed to read download cache directory")
+	}
+	type existing struct {
+		cid     string
+		size    int64
+		modTime time.Time
+	}
+	var found []existing
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		// Leftovers of downloads interrupted by a restart.
+		if strings.HasPrefix(entry.Name(), ".") {
+			os.Remove(path)
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		found = append(found, existing{cid: entry.Name(), size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].modTime.Before(found[j].modTime) })
+	for _, f := range found {
+		cache.entries[f.cid] = cache.lru.PushFront(&downloadCacheEntry{cid: f.cid, size: f.size})
+		cache.size += f.size
+	}
+	cache.mu.Lock()
+	cache.evictLocked("")
+	cache.mu.Unlock()
+
+	log.WithField("dir", dir).
+		WithField("maxSize", formatFileSize(maxSize)).
+		WithField("entries", len(cache.entries)).
+		WithField("size", formatFileSize(cache.size)).
+		Info("Download cache ready")
+	return cache
+}
+
+func (d *downloadCache) path(cid string) string {
+	return filepath.Join(d.dir, cid)
+}
+
+// open returns the cached file for cid, calling fetch to download it into the
+// given path first when it isn't cached. Concurrent requests for the same
+// missing CID share one fetch.
+func (d *downloadCache) open(cid string, fetch func(dst string) error) (*os.File, error) {
+	if cid == "" || filepath.Base(cid) != cid || strings.HasPrefix(cid, ".") {
+		return nil, fmt.Errorf("invalid CID %q", cid)
+	}
+
+	// The file can be evicted between being fetched and being opened, in
+	// which case it is fetched again.
+	for attempt := 0; attempt < 2; attempt++ {
+		if file, ok := d.lookup(cid); ok {
+			d.hits.Add(1)
+			return file, nil
+		}
+
+		d.misses.Add(1)
+		_, err, _ := d.fetches.Do(cid, func() (interface{}, error) {
+			return nil, d.fill(cid, fetch)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if file, ok := d.lookup(cid); ok {
+			return file, nil
+		}
+	}
+	return nil, fmt.Errorf("cached download of %s was evicted before it could be read", cid)
+}
+
+// lookup opens cid's cached file and marks it most recently used.
+func (d *downloadCache) lookup(cid string) (*os.File, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	element, ok := d.entries[cid]
+	if !ok {
+		return nil, false
+	}
+	file, err := os.Open(d.path(cid))
+	if err != nil {
+		d.removeLocked(element)
+		return nil, false
+	}
+	d.lru.MoveToFront(element)
+	now := time.Now()
+	os.Chtimes(d.path(cid), now, now)
+	return file, true
+}
+
+// fill downloads cid into a temporary file and moves it into the cache.
+func (d *downloadCache) fill(cid string, fetch func(dst string) error) error {
+	tmp, err := os.CreateTemp(d.dir, "."+cid+"-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+
+	if err := fetch(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, d.path(cid)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if element, ok := d.entries[cid]; ok {
+		d.size -= element.Value.(*downloadCacheEntry).size
+		d.lru.Remove(element)
+	}
+	d.entries[cid] = d.lru.PushFront(&downloadCacheEntry{cid: cid, size: info.Size()})
+	d.size += info.Size()
+	d.evictLocked(cid)
+	return nil
+}
+
+// evictLocked removes least recently used files until the cache fits in
+// maxSize. keep is never evicted, so a file larger than the cache can still
+// be served once. d.mu must be held.
+func (d *downloadCache) evictLocked(keep string) {
+	for d.size > d.maxSize {
+		element := d.lru.Back()
+		for element != nil && element.Value.(*downloadCacheEntry).cid == keep {
+			element = element.Prev()
+		}
+		if element == nil {
+			return
+		}
+		entry := element.Value.(*downloadCacheEntry)
+		d.removeLocked(element)
+		log.WithField("cid", entry.cid).WithField("size", entry.size).Debug("Evicted download from cache")
+	}
+}
+
+// remove drops cid's file from the cache, if it is cached.
+func (d *downloadCache) remove(cid string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if element, ok := d.entries[cid]; ok {
+		d.removeLocked(element)
+	}
+}
+
+func (d *downloadCache) removeLocked(element *list.Element) {
+	entry := element.Value.(*downloadCacheEntry)
+	// Readers that have the file open keep reading it after it is removed.
+	os.Remove(d.path(entry.cid))
+	d.lru.Remove(element)
+	delete(d.entries, entry.cid)
+	d.size -= entry.size
+}
+
+func (d *downloadCache) stats() DownloadCacheStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return DownloadCacheStats{
+		Hits:    d.hits.Load(),
+		Misses:  d.misses.Load(),
+		Entries: len(d.entries),
+		Bytes:   d.size,
+	}
+}
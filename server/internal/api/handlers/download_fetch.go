@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+var errPieceDecompress = errors.New("failed to decompress downloaded file")
+
+// preparePdptool checks that pdptool is configured and has a service secret
+// to download with, and returns its path. Errors are fit to show clients.
+func preparePdptool(ctx context.Context) (string, error) {
+	pdptoolPath := cfg.PdptoolPath
+	if pdptoolPath == "" {
+		log.Error("PDPTool path not configured in environment/config")
+		return "", errors.New("Server configuration error: PDPTool path missing")
+	}
+
+	if _, err := os.Stat(pdptoolPath); os.IsNotExist(err) {
+		log.WithField("path", pdptoolPath).Error("pdptool not found at configured path")
+		return "", errors.New("pdptool executable not found at configured path")
+	}
+
+	// Change working directory to pdptool directory
+	pdptoolDir := getPdptoolParentDir(pdptoolPath)
+	if err := os.Chdir(pdptoolDir); err != nil {
+		log.Error(fmt.Sprintf("Failed to change working directory to pdptool directory: %v", err))
+		return "", errors.New("Failed to set working directory")
+	}
+	log.WithField("pdptoolDir", pdptoolDir).Info("Changed working directory to pdptool directory")
+
+	log.WithField("path", pdptoolPath).Info("Using pdptool at path")
+
+	if _, err := ensureServiceSecret(ctx, pdptoolPath, ""); err != nil {
+		log.WithField("error", err.Error()).Error("Failed to create service secret for download")
+		return "", errors.New("Failed to create service secret")
+	}
+	return pdptoolPath, nil
+}
+
+// pieceDownloader returns a function that runs download-file for piece into
+// outputFile, retrying failures that look transient. An attempt that already
+// wrote part of the file is only retried when restartable, as a streamed
+// download can't start over. tempDir holds pdptool's chunk file.
+func pieceDownloader(ctx context.Context, pdptoolPath string, piece models.Piece, tempDir string) func(outputFile string, restartable bool) error {
+	return func(outputFile string, restartable bool) error {
+		maxAttempts := cfg.Retry.DownloadMaxRetries + 1
+		for attempt := 1; ; attempt++ {
+			// The command isn't tied to ctx, so a fetch other requests are
+			// waiting on through the cache isn't killed when its own client
+			// goes away.
+			downloadCmd, err := downloadFileCommand(context.Background(), pdptoolPath, piece, tempDir, outputFile)
+			if err != nil {
+				return err
+			}
+			log.WithField("command", "download-file").
+				WithField("serviceURL", piece.ServiceURL).
+				WithField("outputFile", outputFile).
+				WithField("cid", piece.CID).
+				WithField("filename", piece.Filename).
+				WithField("attempt", attempt).
+				Info("Executing download-file command")
+
+			var errOutput bytes.Buffer
+			downloadCmd.Stderr = &errOutput
+
+			err = downloadCmd.Run()
+			if err == nil {
+				return nil
+			}
+
+			retryable := retryablePdptoolError(errOutput.String())
+			log.WithField("error", err.Error()).
+				WithField("stderr", errOutput.String()).
+				WithField("attempt", attempt).
+				WithField("retryable", retryable).
+				Error("Failed to download file")
+
+			fetchErr := &downloadFetchError{Attempts: attempt, Retryable: retryable, stderr: errOutput.String()}
+			if !retryable || attempt >= maxAttempts {
+				return fetchErr
+			}
+			if info, err := os.Stat(outputFile); err == nil && info.Size() > 0 {
+				if !restartable {
+					return fetchErr
+				}
+				if err := os.Truncate(outputFile, 0); err != nil {
+					return fetchErr
+				}
+			}
+			if !sleepContext(ctx, retryDelay(cfg.Retry.DownloadBackoff, cfg.Retry.DownloadMaxBackoff, attempt)) {
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// downloadFileCommand prepares a download-file command writing piece to
+// outputFile, with its chunk file in tempDir.
+func downloadFileCommand(ctx context.Context, pdptoolPath string, piece models.Piece, tempDir, outputFile string) (*exec.Cmd, error) {
+	processCid := baseCID(piece.CID)
+	chunkFile := filepath.Join(tempDir, "chunks.txt")
+	if err := os.WriteFile(chunkFile, []byte(processCid), 0644); err != nil {
+		return nil, fmt.Errorf("failed to create chunk file: %w", err)
+	}
+
+	return exec.CommandContext(ctx,
+		pdptoolPath,
+		"download-file",
+		"--service-url", piece.ServiceURL,
+		"--chunk-file", chunkFile,
+		"--output-file", outputFile,
+	), nil
+}
+
+// openRawPiece opens the piece as stored on the service, which may be
+// compressed or padded. It comes from the download cache when there is one,
+// and is otherwise fetched into tempDir.
+func openRawPiece(piece models.Piece, tempDir string, fetch func(outputFile string) error) (*os.File, error) {
+	if downloads != nil {
+		return downloads.open(baseCID(piece.CID), fetch)
+	}
+	rawPath := filepath.Join(tempDir, "download")
+	if err := fetch(rawPath); err != nil {
+		return nil, err
+	}
+	return os.Open(rawPath)
+}
+
+// openPieceContent returns the file holding the piece's original bytes and
+// how many of its bytes they are. Compressed pieces are decompressed into
+// tempDir; otherwise raw itself is returned. Zero padding is left out rather
+// than removed, so a cached file stays as the service returned it.
+func openPieceContent(piece models.Piece, raw *os.File, tempDir string) (*os.File, int64, error) {
+	file := raw
+	if piece.Compressed {
+		// Any zero padding follows the gzip stream and is ignored by it.
+		outputFile := filepath.Join(tempDir, sanitizeFilename(piece.Filename))
+		if err := gunzipTo(raw, outputFile); err != nil {
+			return nil, 0, fmt.Errorf("%w: %v", errPieceDecompress, err)
+		}
+		var err error
+		if file, err = os.Open(outputFile); err != nil {
+			return nil, 0, fmt.Errorf("Failed to open downloaded file: %v", err)
+		}
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		if file != raw {
+			file.Close()
+		}
+		return nil, 0, fmt.Errorf("Failed to get file info: %v", err)
+	}
+	size := fileInfo.Size()
+	if !piece.Compressed && piece.PaddedSize > piece.Size && size > piece.Size {
+		size = piece.Size
+	}
+	return file, size, nil
+}
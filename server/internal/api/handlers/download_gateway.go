@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+// gatewayClient probes gateways without following redirects, since a
+// gateway that redirects to its subdomain form is up.
+var gatewayClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// baseCID strips the suffix after a colon that some piece CIDs carry,
+// leaving the CID the PDP service and gateways know the file by.
+func baseCID(cid string) string {
+	return strings.Split(cid, ":")[0]
+}
+
+// subrootCID is the part of a compound CID after the colon, or "" when cid
+// has none.
+func subrootCID(cid string) string {
+	_, subroot, _ := strings.Cut(cid, ":")
+	return subroot
+}
+
+// gatewayServable reports whether a gateway would return the piece's bytes
+// as they were uploaded. Compressed and padded pieces are stored changed, so
+// they have to go through pdptool.
+func gatewayServable(piece models.Piece) bool {
+	return !piece.Compressed && piece.PaddedSize <= piece.Size
+}
+
+// findGateway probes the configured gateways in order and returns the URL of
+// cid on the first that answers, and that gateway's base URL.
+func findGateway(ctx context.Context, cid, filename string) (string, string, bool) {
+	query := url.Values{"filename": {filename}}.Encode()
+	for _, gateway := range cfg.Download.Gateways {
+		target := gateway + "/" + url.PathEscape(cid) + "?" + query
+		if probeGateway(ctx, target) {
+			return target, gateway, true
+		}
+	}
+	return "", "", false
+}
+
+func probeGateway(ctx context.Context, target string) bool {
+	timeout := cfg.Download.GatewayProbeTimeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := gatewayClient.Do(req)
+	if err != nil {
+		log.WithField("url", target).WithField("error", err.Error()).Debug("Gateway probe failed")
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		log.WithField("url", target).WithField("status", resp.StatusCode).Debug("Gateway probe failed")
+		return false
+	}
+	return true
+}
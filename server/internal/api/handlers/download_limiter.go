@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// downloadRetryAfter is the Retry-After sent with 429s for users at their
+// concurrent download limit.
+const downloadRetryAfter = 5 * time.Second
+
+// downloadLimiter caps how many downloads each user may run at once and,
+// optionally, how fast their downloads are sent in total. Users are only
+// tracked while they have downloads running.
+type downloadLimiter struct {
+	maxConcurrent  int
+	bytesPerSecond int64
+
+	mu    sync.Mutex
+	users map[uint]*userDownloads
+}
+
+// userDownloads is one user's running downloads and the bucket their bytes
+// are drawn from.
+type userDownloads struct {
+	active int
+	bucket *tokenBucket
+}
+
+var downloadLimits *downloadLimiter
+
+// newDownloadLimiter returns a limiter allowing maxConcurrent downloads per
+// user, each user's downloads sharing bytesPerSecond. 0 means no limit for
+// either.
+func newDownloadLimiter(maxConcurrent int, bytesPerSecond int64) *downloadLimiter {
+	return &downloadLimiter{
+		maxConcurrent:  maxConcurrent,
+		bytesPerSecond: bytesPerSecond,
+		users:          make(map[uint]*userDownloads),
+	}
+}
+
+// acquire starts a download for userID. It returns false when the user
+// already has as many downloads running as allowed; otherwise release must
+// be called once the download ends.
+func (l *downloadLimiter) acquire(userID uint) (*userDownloads, func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	user, ok := l.users[userID]
+	if !ok {
+		user = &userDownloads{}
+		if l.bytesPerSecond > 0 {
+			user.bucket = newTokenBucket(l.bytesPerSecond)
+		}
+		l.users[userID] = user
+	}
+	if l.maxConcurrent > 0 && user.active >= l.maxConcurrent {
+		return nil, nil, false
+	}
+	user.active++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			user.active--
+			if user.active == 0 {
+				delete(l.users, userID)
+			}
+		})
+	}
+	return user, release, true
+}
+
+// limitDownload starts a download for userID, throttling the response when
+// a rate is configured. When the user is at their limit it responds with
+// 429 and returns false; otherwise the returned release must be called once
+// the download ends.
+func limitDownload(c *gin.Context, userID uint) (func(), bool) {
+	if downloadLimits == nil {
+		return func() {}, true
+	}
+	user, release, ok := downloadLimits.acquire(userID)
+	if !ok {
+		log.WithField("userID", userID).Warning("Rejected download over the concurrent download limit")
+		c.Header("Retry-After", strconv.Itoa(int(downloadRetryAfter/time.Second)))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "Too many downloads in progress, try again shortly",
+		})
+		return nil, false
+	}
+	if user.bucket != nil {
+		c.Writer = &throttledResponseWriter{ResponseWriter: c.Writer, bucket: user.bucket, ctx: c.Request.Context()}
+	}
+	return release, true
+}
+
+// throttledResponseWriter sends the response body no faster than its
+// bucket allows.
+type throttledResponseWriter struct {
+	gin.ResponseWriter
+	bucket *tokenBucket
+	ctx    context.Context
+}
+
+func (w *throttledResponseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := len(p)
+		if int64(chunk) > w.bucket.burst {
+			chunk = int(w.bucket.burst)
+		}
+		if err := w.bucket.wait(w.ctx, int64(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.ResponseWriter.Write(p[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[chunk:]
+	}
+	return written, nil
+}
+
+func (w *throttledResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// tokenBucket hands out up to rate bytes a second, letting up to a second's
+// worth build up while unused.
+type tokenBucket struct {
+	rate  int64
+	burst int64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: rate, tokens: float64(rate), last: time.Now()}
+}
+
+// wait blocks until n bytes, at most burst, may be sent.
+func (b *tokenBucket) wait(ctx context.Context, n int64) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * float64(b.rate)
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+	b.last = now
+	// Taking the tokens now, even into debt, queues concurrent writers
+	// behind each other rather than letting them race for refills.
+	b.tokens -= float64(n)
+	delay := time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	if !sleepContext(ctx, delay) {
+		return ctx.Err()
+	}
+	return nil
+}
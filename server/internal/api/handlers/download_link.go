@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// downloadLinkLeeway is how far past its expiry a link is still accepted,
+// to allow for clocks that differ between the servers issuing and checking
+// links.
+const downloadLinkLeeway = 30 * time.Second
+
+var (
+	errDownloadLinkInvalid = errors.New("invalid download link")
+	errDownloadLinkExpired = errors.New("download link has expired")
+)
+
+// downloadLinkClaims is the signed payload of a download link token.
+type downloadLinkClaims struct {
+	TokenID   string `json:"jti"`
+	PieceID   uint   `json:"pid"`
+	ExpiresAt int64  `json:"exp"`
+	MaxUses   int    `json:"max,omitempty"`
+}
+
+// signDownloadLink encodes claims as a token of the form payload.signature,
+// both base64url encoded, signed with HMAC-SHA256.
+func signDownloadLink(secret []byte, claims downloadLinkClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(downloadLinkSignature(secret, encoded)), nil
+}
+
+// downloadLinkSignature signs an encoded payload. The prefix keeps the
+// signature from being valid for anything else signed with the same secret.
+func downloadLinkSignature(secret []byte, encoded string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("download-link:" + encoded))
+	return mac.Sum(nil)
+}
+
+// parseDownloadLink checks a token's signature and expiry at now and returns
+// its claims.
+func parseDownloadLink(secret []byte, token string, now time.Time) (downloadLinkClaims, error) {
+	var claims downloadLinkClaims
+
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return claims, errDownloadLinkInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, downloadLinkSignature(secret, encoded)) {
+		return claims, errDownloadLinkInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return claims, errDownloadLinkInvalid
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.TokenID == "" {
+		return claims, errDownloadLinkInvalid
+	}
+
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(downloadLinkLeeway)) {
+		return claims, errDownloadLinkExpired
+	}
+	return claims, nil
+}
+
+// CreateDownloadURLRequest is the body of a request for a signed download
+// link. Both fields are optional.
+type CreateDownloadURLRequest struct {
+	// ExpiresIn is the link's lifetime in seconds. The server default is
+	// used when it is 0.
+	ExpiresIn int `json:"expiresIn" binding:"min=0"`
+	// MaxUses limits how many times the link may be used. 0 means no limit.
+	MaxUses int `json:"maxUses" binding:"min=0"`
+}
+
+// DownloadURLResponse describes a signed download link.
+type DownloadURLResponse struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	MaxUses   int       `json:"maxUses"`
+}
+
+// @Summary Create a signed download URL
+// @Description Create a URL anyone can use to download one of the caller's pieces, or one shared with them, without signing in, until it expires, is used maxUses times, is revoked, or the piece is removed. Links to a shared piece also stop working when the share is revoked.
+// @Tags pieces
+// @Accept json
+// @Param id path int true "Piece ID"
+// @Param request body CreateDownloadURLRequest false "Lifetime and use limit of the link"
+// @Produce json
+// @Success 201 {object} DownloadURLResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/download-url [post]
+func CreateDownloadURL(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var request CreateDownloadURLRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request parameters: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	ttl := cfg.Download.LinkDefaultTTL
+	if request.ExpiresIn > 0 {
+		ttl = time.Duration(request.ExpiresIn) * time.Second
+		if ttl > cfg.Download.LinkMaxTTL {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("expiresIn may be at most %d seconds", int64(cfg.Download.LinkMaxTTL/time.Second)),
+			})
+			return
+		}
+	} else if ttl > cfg.Download.LinkMaxTTL {
+		ttl = cfg.Download.LinkMaxTTL
+	}
+
+	piece, ok := findLinkablePiece(c, userID, true)
+	if !ok {
+		return
+	}
+
+	link := models.DownloadLink{
+		TokenID:   uuid.New().String(),
+		PieceID:   piece.ID,
+		UserID:    piece.UserID,
+		IssuedBy:  userID.(uint),
+		ExpiresAt: time.Now().Add(ttl).Truncate(time.Second),
+		MaxUses:   request.MaxUses,
+	}
+	token, err := signDownloadLink([]byte(cfg.JWT.Secret), downloadLinkClaims{
+		TokenID:   link.TokenID,
+		PieceID:   link.PieceID,
+		ExpiresAt: link.ExpiresAt.Unix(),
+		MaxUses:   link.MaxUses,
+	})
+	if err != nil {
+		log.WithField("error", err.Error()).Error("Failed to sign download link")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create download link",
+		})
+		return
+	}
+	if err := db.Create(&link).Error; err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to save download link")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create download link",
+		})
+		return
+	}
+
+	log.WithField("pieceID", piece.ID).
+		WithField("linkID", link.TokenID).
+		WithField("expiresAt", link.ExpiresAt).
+		WithField("maxUses", link.MaxUses).
+		Info("Created signed download link")
+
+	c.JSON(http.StatusCreated, DownloadURLResponse{
+		ID:        link.TokenID,
+		URL:       "/api/v1/dl/" + token,
+		ExpiresAt: link.ExpiresAt,
+		MaxUses:   link.MaxUses,
+	})
+}
+
+// @Summary List signed download URLs
+// @Description List the unexpired download links of one of the caller's pieces, or the caller's own links to a piece shared with them. The URLs themselves are only returned when a link is created.
+// @Tags pieces
+// @Param id path int true "Piece ID"
+// @Produce json
+// @Success 200 {array} models.DownloadLink
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/download-url [get]
+func ListDownloadURLs(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	piece, ok := findLinkablePiece(c, userID, true)
+	if !ok {
+		return
+	}
+
+	links := []models.DownloadLink{}
+	query := db.Where("piece_id = ? AND expires_at > ?", piece.ID, time.Now().Add(-downloadLinkLeeway))
+	if piece.UserID != userID.(uint) {
+		// Users a piece is shared with only see their own links.
+		query = query.Where("issued_by = ?", userID)
+	}
+	if err := query.
+		Order("created_at DESC").
+		Find(&links).Error; err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to fetch download links")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch download links",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, links)
+}
+
+// @Summary Revoke a signed download URL
+// @Description Revoke a download link of one of the caller's pieces, or one the caller made to a piece shared with them, so it can no longer be used.
+// @Tags pieces
+// @Param id path int true "Piece ID"
+// @Param linkId path string true "Download link ID"
+// @Success 204
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/download-url/{linkId} [delete]
+func RevokeDownloadURL(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	result := db.Where("token_id = ? AND piece_id = ? AND (user_id = ? OR issued_by = ?)", c.Param("linkId"), c.Param("id"), userID, userID).
+		Delete(&models.DownloadLink{})
+	if result.Error != nil {
+		log.WithField("linkID", c.Param("linkId")).WithField("error", result.Error.Error()).Error("Failed to revoke download link")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to revoke download link",
+		})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Download link not found",
+		})
+		return
+	}
+
+	log.WithField("linkID", c.Param("linkId")).Info("Revoked signed download link")
+	c.Status(http.StatusNoContent)
+}
+
+// findLinkablePiece loads the caller's piece named in the path, or when
+// shared is set one shared with them, responding with an error and
+// returning false when there is none.
+func findLinkablePiece(c *gin.Context, userID interface{}, shared bool) (models.Piece, bool) {
+	var piece models.Piece
+	query := db.Where("id = ? AND pending_removal = ?", c.Param("id"), false)
+	if shared {
+		query = accessibleTo(query, userID)
+	} else {
+		query = query.Where("user_id = ?", userID)
+	}
+	err := query.First(&piece).Error
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Piece not found",
+		})
+		return piece, false
+	}
+	if err != nil {
+		log.WithField("error", err.Error()).Error("Failed to fetch piece")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch piece",
+		})
+		return piece, false
+	}
+	return piece, true
+}
+
+// @Summary Download a file with a signed URL
+// @Description Download the piece a signed download link was created for. No sign-in is needed. Each request counts as one use of the link.
+// @Tags download
+// @Param token path string true "Signed download token"
+// @Param inline query bool false "Ask for images, video, audio, PDFs and plain text to be shown in the browser rather than saved"
+// @Param Range header string false "A single byte range, e.g. bytes=0-1023"
+// @Produce octet-stream
+// @Success 200 {file} binary "File content"
+// @Success 206 {file} binary "Requested range of the file"
+// @Failure 404 {object} ErrorResponse
+// @Failure 410 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Router /api/v1/dl/{token} [get]
+func DownloadSignedURL(c *gin.Context) {
+	if db == nil {
+		log.Error("Database connection not initialized")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Internal server error: database not initialized",
+		})
+		return
+	}
+
+	claims, err := parseDownloadLink([]byte(cfg.JWT.Secret), c.Param("token"), time.Now())
+	if err == errDownloadLinkExpired {
+		c.JSON(http.StatusGone, gin.H{
+			"error": "Download link has expired",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Download link not found",
+		})
+		return
+	}
+
+	// The token was issued here, so a missing row means it was revoked.
+	var link models.DownloadLink
+	if err := db.Where("token_id = ? AND piece_id = ?", claims.TokenID, claims.PieceID).First(&link).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusGone, gin.H{
+				"error": "Download link has been revoked",
+			})
+			return
+		}
+		log.WithField("linkID", claims.TokenID).WithField("error", err.Error()).Error("Failed to fetch download link")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch download link",
+		})
+		return
+	}
+
+	var piece models.Piece
+	if err := db.Where("id = ? AND user_id = ?", link.PieceID, link.UserID).First(&piece).Error; err != nil || piece.PendingRemoval {
+		c.JSON(http.StatusGone, gin.H{
+			"error": "The file is no longer available",
+		})
+		return
+	}
+	// A link made by someone the piece was shared with stops working as
+	// soon as that share is revoked.
+	if link.IssuedBy != 0 && link.IssuedBy != piece.UserID && !hasPieceGrant(piece.ID, link.IssuedBy) {
+		c.JSON(http.StatusGone, gin.H{
+			"error": "Download link has been revoked",
+		})
+		return
+	}
+
+	// Downloads through links count against the owner's limits, and are
+	// checked before the use is counted so a rejected request doesn't use
+	// the link up.
+	release, ok := limitDownload(c, piece.UserID)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// Counting the use in the update keeps concurrent requests from going
+	// over the limit.
+	result := db.Model(&models.DownloadLink{}).
+		Where("id = ? AND (max_uses = 0 OR uses < max_uses)", link.ID).
+		UpdateColumn("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		log.WithField("linkID", link.TokenID).WithField("error", result.Error.Error()).Error("Failed to record download link use")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to record download link use",
+		})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusGone, gin.H{
+			"error": "Download link has been used up",
+		})
+		return
+	}
+
+	log.WithField("linkID", link.TokenID).WithField("pieceID", piece.ID).Info("Serving signed download link")
+	sendPiece(c, piece, nil, piece.UserID)
+}
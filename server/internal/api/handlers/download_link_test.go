@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hotvault/backend/internal/models"
+)
+
+func TestParseDownloadLink(t *testing.T) {
+	secret := []byte("test-secret")
+	issued := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := issued.Add(time.Hour)
+	claims := downloadLinkClaims{TokenID: "link", PieceID: 7, ExpiresAt: expiresAt.Unix(), MaxUses: 3}
+	token, err := signDownloadLink(secret, claims)
+	if err != nil {
+		t.Fatalf("signDownloadLink: %v", err)
+	}
+	payload, signature, _ := strings.Cut(token, ".")
+	other, _ := signDownloadLink(secret, downloadLinkClaims{TokenID: "link", PieceID: 8, ExpiresAt: expiresAt.Unix()})
+	otherPayload, _, _ := strings.Cut(other, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+		want  error
+	}{
+		{"fresh", token, issued, nil},
+		{"just before expiry", token, expiresAt.Add(-time.Second), nil},
+		{"at expiry", token, expiresAt, nil},
+		{"expired within the clock skew leeway", token, expiresAt.Add(downloadLinkLeeway - time.Second), nil},
+		{"expired at the end of the leeway", token, expiresAt.Add(downloadLinkLeeway), nil},
+		{"expired past the leeway", token, expiresAt.Add(downloadLinkLeeway + time.Second), errDownloadLinkExpired},
+		{"signed with another secret", mustSignDownloadLink(t, []byte("other-secret"), claims), issued, errDownloadLinkInvalid},
+		{"payload swapped", otherPayload + "." + signature, issued, errDownloadLinkInvalid},
+		{"signature missing", payload, issued, errDownloadLinkInvalid},
+		{"signature not base64", payload + ".!!", issued, errDownloadLinkInvalid},
+		{"empty", "", issued, errDownloadLinkInvalid},
+	}
+	for _, test := range tests {
+		got, err := parseDownloadLink(secret, test.token, test.now)
+		if err != test.want {
+			t.Errorf("%s: parseDownloadLink error = %v, want %v", test.name, err, test.want)
+			continue
+		}
+		if err == nil && got != claims {
+			t.Errorf("%s: claims = %+v, want %+v", test.name, got, claims)
+		}
+	}
+}
+
+func mustSignDownloadLink(t *testing.T, secret []byte, claims downloadLinkClaims) string {
+	t.Helper()
+	token, err := signDownloadLink(secret, claims)
+	if err != nil {
+		t.Fatalf("signDownloadLink: %v", err)
+	}
+	return token
+}
+
+// useTestDownloadLinks sets up a user with a piece served by a fake pdptool
+// and a secret to sign links with.
+func useTestDownloadLinks(t *testing.T) (models.User, models.Piece) {
+	t.Helper()
+	useTestDB(t)
+	useTestConfig(t)
+	cfg.JWT.Secret = "test-secret"
+	t.Setenv("TMPDIR", t.TempDir())
+	user := createTestUser(t, "0x1")
+	data := []byte("shared picture")
+	piece := createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaone:bagasub", Filename: "picture.png", Size: int64(len(data))})
+	fakePdptoolServing(t, data)
+	return user, piece
+}
+
+func createDownloadURL(t *testing.T, user models.User, piece models.Piece, request CreateDownloadURLRequest) DownloadURLResponse {
+	t.Helper()
+	id := strconv.FormatUint(uint64(piece.ID), 10)
+	c, recorder := newJSONRequest(t, http.MethodPost, "/api/v1/pieces/"+id+"/download-url", request, user)
+	c.Params = gin.Params{{Key: "id", Value: id}}
+	CreateDownloadURL(c)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("create download URL: status = %d, want %d: %s", recorder.Code, http.StatusCreated, recorder.Body)
+	}
+	var response DownloadURLResponse
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	return response
+}
+
+func useDownloadURL(url string) int {
+	token := strings.TrimPrefix(url, "/api/v1/dl/")
+	c, recorder := newTestContext(http.MethodGet, url, nil, models.User{})
+	c.Params = gin.Params{{Key: "token", Value: token}}
+	DownloadSignedURL(c)
+	return recorder.Code
+}
+
+func TestDownloadSignedURLMaxUses(t *testing.T) {
+	user, piece := useTestDownloadLinks(t)
+	link := createDownloadURL(t, user, piece, CreateDownloadURLRequest{MaxUses: 2})
+
+	for i := 1; i <= 2; i++ {
+		if status := useDownloadURL(link.URL); status != http.StatusOK {
+			t.Errorf("use %d: status = %d, want %d", i, status, http.StatusOK)
+		}
+	}
+	if status := useDownloadURL(link.URL); status != http.StatusGone {
+		t.Errorf("use 3 of 2: status = %d, want %d", status, http.StatusGone)
+	}
+	var stored models.DownloadLink
+	db.Where("token_id = ?", link.ID).First(&stored)
+	if stored.Uses != 2 {
+		t.Errorf("uses = %d, want 2", stored.Uses)
+	}
+
+	unlimited := createDownloadURL(t, user, piece, CreateDownloadURLRequest{})
+	for i := 1; i <= 5; i++ {
+		if status := useDownloadURL(unlimited.URL); status != http.StatusOK {
+			t.Errorf("unlimited link, use %d: status = %d, want %d", i, status, http.StatusOK)
+		}
+	}
+}
+
+func TestDownloadSignedURLExpiry(t *testing.T) {
+	user, piece := useTestDownloadLinks(t)
+
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		want      int
+	}{
+		{"unexpired", time.Now().Add(time.Minute), http.StatusOK},
+		{"expired, but within the clock skew leeway", time.Now().Add(-downloadLinkLeeway / 2), http.StatusOK},
+		{"expired past the leeway", time.Now().Add(-downloadLinkLeeway - time.Minute), http.StatusGone},
+	}
+	for _, test := range tests {
+		link := models.DownloadLink{
+			TokenID:   uuid.New().String(),
+			PieceID:   piece.ID,
+			UserID:    user.ID,
+			IssuedBy:  user.ID,
+			ExpiresAt: test.expiresAt,
+		}
+		if err := db.Create(&link).Error; err != nil {
+			t.Fatalf("save link: %v", err)
+		}
+		token := mustSignDownloadLink(t, []byte(cfg.JWT.Secret), downloadLinkClaims{
+			TokenID:   link.TokenID,
+			PieceID:   piece.ID,
+			ExpiresAt: test.expiresAt.Unix(),
+		})
+		if status := useDownloadURL("/api/v1/dl/" + token); status != test.want {
+			t.Errorf("%s: status = %d, want %d", test.name, status, test.want)
+		}
+	}
+}
+
+func TestDownloadSignedURLRevokedAndRemoved(t *testing.T) {
+	user, piece := useTestDownloadLinks(t)
+	revoked := createDownloadURL(t, user, piece, CreateDownloadURLRequest{})
+	kept := createDownloadURL(t, user, piece, CreateDownloadURLRequest{})
+
+	id := strconv.FormatUint(uint64(piece.ID), 10)
+	c, recorder := newTestContext(http.MethodDelete, "/api/v1/pieces/"+id+"/download-url/"+revoked.ID, nil, user)
+	c.Params = gin.Params{{Key: "id", Value: id}, {Key: "linkId", Value: revoked.ID}}
+	RevokeDownloadURL(c)
+	c.Writer.WriteHeaderNow()
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("revoke: status = %d, want %d", recorder.Code, http.StatusNoContent)
+	}
+	if status := useDownloadURL(revoked.URL); status != http.StatusGone {
+		t.Errorf("revoked link: status = %d, want %d", status, http.StatusGone)
+	}
+	if status := useDownloadURL(kept.URL); status != http.StatusOK {
+		t.Errorf("other link: status = %d, want %d", status, http.StatusOK)
+	}
+
+	db.Model(&piece).Update("pending_removal", true)
+	if status := useDownloadURL(kept.URL); status != http.StatusGone {
+		t.Errorf("link to a removed piece: status = %d, want %d", status, http.StatusGone)
+	}
+}
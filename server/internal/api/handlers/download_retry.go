@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// transientPdptoolErrors match pdptool stderr for failures that may go away
+// on their own: the service couldn't be reached, timed out, or answered with
+// a server error.
+var transientPdptoolErrors = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)connection refused`),
+	regexp.MustCompile(`(?i)connection reset`),
+	regexp.MustCompile(`(?i)broken pipe`),
+	regexp.MustCompile(`(?i)no such host`),
+	regexp.MustCompile(`(?i)timeout|timed out|deadline exceeded`),
+	regexp.MustCompile(`(?i)unexpected EOF`),
+	regexp.MustCompile(`(?i)(status|code|http)\D{0,12}5\d\d\b`),
+	regexp.MustCompile(`\b5\d\d (Internal Server Error|Bad Gateway|Service Unavailable|Gateway Timeout)\b`),
+}
+
+// retryablePdptoolError reports whether a pdptool command that failed with
+// stderr is worth running again.
+func retryablePdptoolError(stderr string) bool {
+	for _, pattern := range transientPdptoolErrors {
+		if pattern.MatchString(stderr) {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadFetchError is returned when download-file keeps failing. The
+// pdptool output is logged rather than sent to the client.
+type downloadFetchError struct {
+	Attempts  int
+	Retryable bool
+	stderr    string
+}
+
+func (e *downloadFetchError) Error() string {
+	return fmt.Sprintf("download-file failed after %d attempts: %s", e.Attempts, strings.TrimSpace(e.stderr))
+}
+
+// respondDownloadFailed reports a file that couldn't be fetched for download.
+func respondDownloadFailed(c *gin.Context, err error) {
+	var fetchErr *downloadFetchError
+	if errors.As(err, &fetchErr) {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error":     "Failed to download file from the storage provider",
+			"attempts":  fetchErr.Attempts,
+			"retryable": fetchErr.Retryable,
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error":   fmt.Sprintf("Failed to download file: %v", err),
+		"details": err.Error(),
+	})
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+// fakePdptoolFailing installs a fake pdptool whose download-file fails with
+// stderr for its first failures calls and then writes data to the file it
+// is asked for. It returns the path of its calls file.
+func fakePdptoolFailing(t *testing.T, failures int, stderr string, data []byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	piece := filepath.Join(dir, "piece")
+	if err := os.WriteFile(piece, data, 0644); err != nil {
+		t.Fatalf("write served piece: %v", err)
+	}
+	attempts := filepath.Join(dir, "attempts")
+	return fakePdptool(t, `case "$1" in download-file)
+	echo x >> `+attempts+`
+	if [ $(wc -l < `+attempts+`) -le `+strconv.Itoa(failures)+` ]; then echo '`+stderr+`' >&2; exit 1; fi
+	while [ $# -gt 0 ]; do [ "$1" = --output-file ] && out="$2"; shift; done
+	cp `+piece+` "$out";;
+esac`)
+}
+
+func useTestDownloadRetries(t *testing.T, maxRetries int) {
+	t.Helper()
+	useTestConfig(t)
+	cfg.Retry.DownloadMaxRetries = maxRetries
+	cfg.Retry.DownloadBackoff = time.Millisecond
+	cfg.Retry.DownloadMaxBackoff = 2 * time.Millisecond
+}
+
+func TestPieceDownloaderRetriesTransientFailures(t *testing.T) {
+	useTestDownloadRetries(t, 3)
+	data := []byte("piece contents")
+	calls := fakePdptoolFailing(t, 2, "dial tcp 10.0.0.1:443: connect: connection refused", data)
+	piece := models.Piece{CID: "bagaone:bagasub", ServiceURL: "https://sp.example.com"}
+	tempDir := t.TempDir()
+	outputFile := filepath.Join(tempDir, "download")
+
+	err := pieceDownloader(context.Background(), cfg.PdptoolPath, piece, tempDir)(outputFile, true)
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if got, _ := os.ReadFile(outputFile); string(got) != string(data) {
+		t.Errorf("downloaded %q, want %q", got, data)
+	}
+	if n := len(pdptoolCalls(t, calls, "download-file")); n != 3 {
+		t.Errorf("download-file ran %d times, want 3", n)
+	}
+}
+
+func TestPieceDownloaderGivesUp(t *testing.T) {
+	tests := []struct {
+		name          string
+		stderr        string
+		wantAttempts  int
+		wantRetryable bool
+	}{
+		{"retry budget used up", "upload failed: status 503 Service Unavailable", 3, true},
+		{"error that won't go away", "piece not found", 1, false},
+	}
+	for _, test := range tests {
+		useTestDownloadRetries(t, 2)
+		calls := fakePdptoolFailing(t, 10, test.stderr, nil)
+		piece := models.Piece{CID: "bagaone:bagasub", ServiceURL: "https://sp.example.com"}
+		tempDir := t.TempDir()
+
+		err := pieceDownloader(context.Background(), cfg.PdptoolPath, piece, tempDir)(filepath.Join(tempDir, "download"), true)
+		var fetchErr *downloadFetchError
+		if !errors.As(err, &fetchErr) {
+			t.Errorf("%s: error = %v, want a downloadFetchError", test.name, err)
+			continue
+		}
+		if fetchErr.Attempts != test.wantAttempts || fetchErr.Retryable != test.wantRetryable {
+			t.Errorf("%s: gave up after %d attempts, retryable %v, want %d, %v", test.name, fetchErr.Attempts, fetchErr.Retryable, test.wantAttempts, test.wantRetryable)
+		}
+		if n := len(pdptoolCalls(t, calls, "download-file")); n != test.wantAttempts {
+			t.Errorf("%s: download-file ran %d times, want %d", test.name, n, test.wantAttempts)
+		}
+	}
+}
+
+func TestDownloadFileRetriesThenServesFile(t *testing.T) {
+	useTestDB(t)
+	useTestDownloadRetries(t, 3)
+	t.Setenv("TMPDIR", t.TempDir())
+	user := createTestUser(t, "0x1")
+	data := []byte("piece contents")
+	createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaone:bagasub", Filename: "a.txt", Size: int64(len(data))})
+	fakePdptoolFailing(t, 2, "context deadline exceeded", data)
+
+	c, recorder := downloadRequest("bagaone:bagasub", "", user)
+	DownloadFile(c)
+
+	if recorder.Code != http.StatusOK || recorder.Body.String() != string(data) {
+		t.Errorf("status = %d, body = %q, want the file", recorder.Code, recorder.Body)
+	}
+}
+
+func TestDownloadFileFailureHidesPdptoolOutput(t *testing.T) {
+	useTestDB(t)
+	useTestDownloadRetries(t, 1)
+	t.Setenv("TMPDIR", t.TempDir())
+	user := createTestUser(t, "0x1")
+	createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaone:bagasub", Filename: "a.txt", Size: 5})
+	fakePdptoolFailing(t, 10, "connection refused by 10.0.0.1 using /secret/path", nil)
+
+	c, recorder := downloadRequest("bagaone:bagasub", "", user)
+	DownloadFile(c)
+
+	if recorder.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusBadGateway, recorder.Body)
+	}
+	var response map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if response["attempts"] != float64(2) || response["retryable"] != true {
+		t.Errorf("response = %s, want 2 retryable attempts", recorder.Body)
+	}
+	if strings.Contains(recorder.Body.String(), "/secret/path") {
+		t.Errorf("response leaks pdptool output: %s", recorder.Body)
+	}
+}
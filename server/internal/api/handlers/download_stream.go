@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+)
+
+// streamHoldback is how many bytes at the end of a streamed download are
+// only sent once the whole file has been read and checked. Leaving them out
+// when something went wrong makes the response shorter than its
+// Content-Length, so the client sees a failed download rather than a
+// complete but wrong file.
+const streamHoldback = 4096
+
+// followPollInterval is how long a streamed download waits before looking
+// for more data once it has caught up with pdptool.
+const followPollInterval = 50 * time.Millisecond
+
+var errStreamIntegrity = errors.New("downloaded file does not match the stored checksum")
+
+// downloadStreamable reports whether a download can be sent while pdptool
+// is still fetching it. Only whole, uncompressed files are streamed; ranges,
+// directory members and compressed pieces need the complete file first.
+func downloadStreamable(c *gin.Context, piece models.Piece, member *models.ArchiveEntry) bool {
+	return cfg.Download.Stream &&
+		member == nil &&
+		!piece.Compressed &&
+		piece.Size > 0 &&
+		c.Request.Method == http.MethodGet &&
+		c.GetHeader("Range") == ""
+}
+
+// streamWhileFetching runs fetch into outputFile and sends the piece to the
+// response as the file grows, so the client doesn't wait for the whole
+// download. Content-Length comes from the piece record. If fetch fails, the
+// file turns out short, or it doesn't match the piece's checksum when verify
+// is set, the response is cut short and an error is returned. It returns
+// once fetch has finished, so a cached file is complete.
+func streamWhileFetching(c *gin.Context, piece models.Piece, verify bool, usageUserID uint, outputFile string, fetch func(string) error) error {
+	file, err := os.OpenFile(outputFile, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	done := make(chan struct{})
+	var fetchErr error
+	go func() {
+		defer close(done)
+		fetchErr = fetch(outputFile)
+	}()
+	finish := func(err error) error {
+		<-done
+		if fetchErr != nil {
+			return fetchErr
+		}
+		return err
+	}
+
+	// Zero padding past the piece's size is never sent.
+	content := io.LimitReader(&followReader{file: file, done: done, ctx: c.Request.Context()}, piece.Size)
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return finish(fmt.Errorf("failed to read downloaded file: %w", err))
+	}
+	head = head[:n]
+
+	setDownloadHeaders(c, piece, head)
+	c.Header("Content-Length", strconv.FormatInt(piece.Size, 10))
+	c.Status(http.StatusOK)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+
+	counter := &countingResponseWriter{ResponseWriter: c.Writer}
+	defer func() {
+		recordUsage(usageUserID, piece.ID, usageDirectionDownload, counter.n)
+	}()
+
+	hasher := sha256.New()
+	body := io.TeeReader(io.MultiReader(bytes.NewReader(head), content), hasher)
+
+	holdback := int64(streamHoldback)
+	if holdback > piece.Size {
+		holdback = piece.Size
+	}
+	if _, err := io.CopyN(counter, body, piece.Size-holdback); err != nil {
+		// A client that went away doesn't make the download itself bad.
+		if c.Request.Context().Err() != nil {
+			return finish(nil)
+		}
+		return finish(fmt.Errorf("streamed download stopped after %d bytes: %w", counter.n, err))
+	}
+	tail := make([]byte, holdback)
+	if _, err := io.ReadFull(body, tail); err != nil {
+		return finish(fmt.Errorf("downloaded file is shorter than its recorded size: %w", err))
+	}
+
+	// The rest of the file is padding, but the cache needs all of it.
+	if err := finish(nil); err != nil {
+		return err
+	}
+
+	if verify {
+		actual := hex.EncodeToString(hasher.Sum(nil))
+		if actual != *piece.Checksum {
+			log.WithField("cid", piece.CID).
+				WithField("pieceID", piece.ID).
+				WithField("expected", *piece.Checksum).
+				WithField("actual", actual).
+				Error("Streamed file does not match stored checksum")
+			return errStreamIntegrity
+		}
+		recordPieceVerification(piece, verifyResultOK, time.Now())
+	}
+
+	if _, err := counter.Write(tail); err != nil {
+		log.WithField("error", err.Error()).WithField("bytesSent", counter.n).Error("Failed to stream file to response")
+	}
+	return nil
+}
+
+// followReader reads a file that another process is still writing. At the
+// end of what has been written so far it waits for more, until done is
+// closed.
+type followReader struct {
+	file *os.File
+	done <-chan struct{}
+	ctx  context.Context
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.file.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		select {
+		case <-r.done:
+			// Nothing more will be written, so one last read gets the rest.
+			return r.file.Read(p)
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		case <-time.After(followPollInterval):
+		}
+	}
+}
@@ -0,0 +1,459 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+)
+
+// useTestGateway configures a gateway that has every CID and returns the
+// number of times it has been probed.
+func useTestGateway(t *testing.T) *int32 {
+	t.Helper()
+	var probes int32
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+	}))
+	t.Cleanup(gateway.Close)
+	cfg.Download.Gateways = []string{gateway.URL}
+	return &probes
+}
+
+func downloadRequest(cid, query string, user models.User) (*gin.Context, *httptest.ResponseRecorder) {
+	target := "/api/v1/download/" + cid
+	if query != "" {
+		target += "?" + query
+	}
+	c, recorder := newTestContext(http.MethodGet, target, nil, user)
+	c.Params = gin.Params{{Key: "cid", Value: cid}}
+	return c, recorder
+}
+
+func TestDownloadFileHidesOtherUsersPieces(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	owner := createTestUser(t, "0x1")
+	other := createTestUser(t, "0x2")
+	createTestPiece(t, models.Piece{UserID: owner.ID, CID: "bagaone:bagasub", Filename: "secret.txt", Size: 6})
+	probes := useTestGateway(t)
+	calls := fakePdptool(t, "exit 1")
+
+	tests := []struct {
+		name            string
+		cid             string
+		query           string
+		publicDownloads bool
+	}{
+		{"compound CID", "bagaone:bagasub", "", false},
+		{"base CID", "bagaone", "", false},
+		{"gateway redirect", "bagaone:bagasub", "gateway=true", false},
+		{"gateway redirect by base CID", "bagaone", "gateway=true", false},
+		{"private piece with public downloads", "bagaone:bagasub", "gateway=true", true},
+	}
+	for _, test := range tests {
+		cfg.Upload.PublicDownloads = test.publicDownloads
+		c, recorder := downloadRequest(test.cid, test.query, other)
+		DownloadFile(c)
+
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want %d: %s", test.name, recorder.Code, http.StatusNotFound, recorder.Body)
+		}
+		if location := recorder.Header().Get("Location"); location != "" {
+			t.Errorf("%s: redirected to %s", test.name, location)
+		}
+	}
+	if n := atomic.LoadInt32(probes); n != 0 {
+		t.Errorf("gateway was probed %d times for another user's piece", n)
+	}
+	if called := pdptoolCalls(t, calls, ""); len(called) != 0 {
+		t.Errorf("pdptool was run for another user's piece: %q", called)
+	}
+
+	// The owner is redirected, so the requests above were refused for
+	// being someone else's piece.
+	c, recorder := downloadRequest("bagaone:bagasub", "gateway=true", owner)
+	DownloadFile(c)
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("owner: status = %d, want %d: %s", recorder.Code, http.StatusFound, recorder.Body)
+	}
+}
+
+func TestDownloadFileServesCallersOwnPieceOfSharedCID(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	owner := createTestUser(t, "0x1")
+	other := createTestUser(t, "0x2")
+	createTestPiece(t, models.Piece{UserID: owner.ID, CID: "bagaone:bagasub", Filename: "secret.txt", Size: 6})
+	createTestPiece(t, models.Piece{UserID: other.ID, CID: "bagaone:bagasub", Filename: "mine.txt", Size: 6})
+	useTestGateway(t)
+
+	c, recorder := downloadRequest("bagaone:bagasub", "gateway=true", other)
+	DownloadFile(c)
+
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusFound, recorder.Body)
+	}
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse redirect: %v", err)
+	}
+	if filename := location.Query().Get("filename"); filename != "mine.txt" {
+		t.Errorf("redirected with filename %q, want the caller's own mine.txt", filename)
+	}
+}
+
+func TestDownloadFileByCompoundOrBaseCID(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	user := createTestUser(t, "0x1")
+	data := []byte("found either way\n")
+	createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaone:bagasub", Filename: "a.txt", Size: int64(len(data))})
+	// Only the stored piece's base CID is served, so a download by base CID
+	// has to fetch that piece.
+	calls := fakePdptoolServingPieces(t, map[string][]byte{"bagaone": data})
+
+	tests := []struct {
+		name string
+		cid  string
+		want int
+	}{
+		{"compound CID", "bagaone:bagasub", http.StatusOK},
+		{"base CID", "bagaone", http.StatusOK},
+		{"unknown base CID", "bagatwo", http.StatusNotFound},
+		{"garbage", "../../etc/passwd", http.StatusBadRequest},
+		{"too many parts", "bagaone:bagasub:bagamore", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		c, recorder := downloadRequest(test.cid, "", user)
+		DownloadFile(c)
+
+		if recorder.Code != test.want {
+			t.Errorf("%s: status = %d, want %d: %s", test.name, recorder.Code, test.want, recorder.Body)
+		} else if test.want == http.StatusOK && !bytes.Equal(recorder.Body.Bytes(), data) {
+			t.Errorf("%s: body = %q, want %q", test.name, recorder.Body, data)
+		}
+	}
+	if n := len(pdptoolCalls(t, calls, "download-file")); n != 2 {
+		t.Errorf("download-file ran %d times, want 2", n)
+	}
+}
+
+func TestDownloadFileRanges(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	user := createTestUser(t, "0x1")
+	data := []byte("0123456789abcdefghij")
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaone:bagasub", Filename: "data.bin", Size: int64(len(data)), Checksum: &checksum})
+	fakePdptoolServing(t, data)
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		ifRange      string
+		want         int
+		body         string
+		contentRange string
+	}{
+		{"no range", "", "", http.StatusOK, string(data), ""},
+		{"closed range", "bytes=2-5", "", http.StatusPartialContent, "2345", "bytes 2-5/20"},
+		{"open-ended range", "bytes=15-", "", http.StatusPartialContent, "fghij", "bytes 15-19/20"},
+		{"suffix range", "bytes=-4", "", http.StatusPartialContent, "ghij", "bytes 16-19/20"},
+		{"suffix longer than the file", "bytes=-50", "", http.StatusPartialContent, string(data), "bytes 0-19/20"},
+		{"range past the end is clipped", "bytes=18-100", "", http.StatusPartialContent, "ij", "bytes 18-19/20"},
+		{"start past the end", "bytes=20-", "", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		// Malformed ranges get no Content-Range from http.ServeContent.
+		{"start after end", "bytes=5-2", "", http.StatusRequestedRangeNotSatisfiable, "", ""},
+		{"not a number", "bytes=a-b", "", http.StatusRequestedRangeNotSatisfiable, "", ""},
+		{"several ranges", "bytes=0-1,4-5", "", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		{"If-Range matching the ETag", "bytes=0-1", `"` + checksum + `"`, http.StatusPartialContent, "01", "bytes 0-1/20"},
+		{"If-Range for other contents", "bytes=0-1", `"other"`, http.StatusOK, string(data), ""},
+	}
+	for _, test := range tests {
+		c, recorder := downloadRequest("bagaone:bagasub", "", user)
+		if test.rangeHeader != "" {
+			c.Request.Header.Set("Range", test.rangeHeader)
+		}
+		if test.ifRange != "" {
+			c.Request.Header.Set("If-Range", test.ifRange)
+		}
+		DownloadFile(c)
+
+		if recorder.Code != test.want {
+			t.Errorf("%s: status = %d, want %d: %s", test.name, recorder.Code, test.want, recorder.Body)
+			continue
+		}
+		if got := recorder.Header().Get("Content-Range"); got != test.contentRange {
+			t.Errorf("%s: Content-Range = %q, want %q", test.name, got, test.contentRange)
+		}
+		if test.want != http.StatusRequestedRangeNotSatisfiable && recorder.Body.String() != test.body {
+			t.Errorf("%s: body = %q, want %q", test.name, recorder.Body, test.body)
+		}
+	}
+}
+
+// corruptedCopy returns data with one byte in the middle flipped, as a
+// storage provider returning damaged bytes would.
+func corruptedCopy(data []byte) []byte {
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)/2] ^= 0xff
+	return corrupted
+}
+
+func TestDownloadFileRejectsCorruptedFile(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	user := createTestUser(t, "0x1")
+	data := []byte("the quarterly numbers, exactly as uploaded\n")
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	piece := createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaone:bagasub", Filename: "numbers.txt", Size: int64(len(data)), Checksum: &checksum})
+	fakePdptoolServing(t, corruptedCopy(data))
+
+	c, recorder := downloadRequest(piece.CID, "", user)
+	DownloadFile(c)
+
+	if recorder.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusBadGateway, recorder.Body)
+	}
+	var response struct {
+		Error    string `json:"error"`
+		Expected string `json:"expected"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if response.Error != "integrity_failure" || response.Expected != checksum {
+		t.Errorf("response = %s, want integrity_failure for %s", recorder.Body, checksum)
+	}
+	var stored models.Piece
+	db.First(&stored, piece.ID)
+	if stored.LastVerifiedAt != nil && stored.VerifyResult == verifyResultOK {
+		t.Errorf("corrupted download was recorded as verified")
+	}
+
+	// With verification skipped the bytes are served as they came back.
+	c, recorder = downloadRequest(piece.CID, "verify=false", user)
+	DownloadFile(c)
+	if recorder.Code != http.StatusOK || !bytes.Equal(recorder.Body.Bytes(), corruptedCopy(data)) {
+		t.Errorf("verify=false: status = %d, body = %q, want the unverified bytes", recorder.Code, recorder.Body)
+	}
+	if recorder.Header().Get("X-Verified") != "" {
+		t.Errorf("verify=false: response claims to be verified")
+	}
+}
+
+func TestDownloadFileVerifiesIntactFile(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	user := createTestUser(t, "0x1")
+	data := []byte("the quarterly numbers, exactly as uploaded\n")
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	piece := createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaone:bagasub", Filename: "numbers.txt", Size: int64(len(data)), Checksum: &checksum})
+	fakePdptoolServing(t, data)
+
+	c, recorder := downloadRequest(piece.CID, "", user)
+	DownloadFile(c)
+
+	if recorder.Code != http.StatusOK || !bytes.Equal(recorder.Body.Bytes(), data) {
+		t.Fatalf("status = %d, body = %q, want the file", recorder.Code, recorder.Body)
+	}
+	if recorder.Header().Get("X-Verified") != "true" {
+		t.Errorf("X-Verified = %q, want true", recorder.Header().Get("X-Verified"))
+	}
+	var stored models.Piece
+	db.First(&stored, piece.ID)
+	if stored.LastVerifiedAt == nil || stored.VerifyResult != verifyResultOK {
+		t.Errorf("verification recorded as %v %q, want a time and %q", stored.LastVerifiedAt, stored.VerifyResult, verifyResultOK)
+	}
+}
+
+func TestStreamedDownloadWithholdsEndOfCorruptedFile(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	cfg.Download.Stream = true
+	t.Setenv("TMPDIR", t.TempDir())
+	user := createTestUser(t, "0x1")
+	data := bytes.Repeat([]byte("the quarterly numbers\n"), 100)
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	piece := createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaone:bagasub", Filename: "numbers.txt", Size: int64(len(data)), Checksum: &checksum})
+	fakePdptoolServing(t, corruptedCopy(data))
+
+	c, recorder := downloadRequest(piece.CID, "", user)
+	DownloadFile(c)
+
+	// The status has gone out with the first bytes, so the corrupted file
+	// is cut short instead.
+	if recorder.Body.Len() >= len(data) {
+		t.Errorf("streamed %d of %d bytes of a corrupted file, want it cut short", recorder.Body.Len(), len(data))
+	}
+}
+
+func TestDownloadFileSniffsUnrecordedContentType(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	user := createTestUser(t, "0x1")
+	png := append([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), make([]byte, 32)...)
+	text := []byte("meeting notes\n- ship the release\n")
+
+	tests := []struct {
+		name        string
+		data        []byte
+		stored      string
+		query       string
+		want        string
+		disposition string
+	}{
+		{"PNG with no recorded type", png, "", "", "image/png", "attachment"},
+		{"PNG recorded as octet-stream", png, "application/octet-stream", "", "image/png", "attachment"},
+		{"PNG shown inline", png, "", "inline=true", "image/png", "inline"},
+		{"text with no recorded type", text, "", "", "text/plain", "attachment"},
+		{"text shown inline", text, "application/octet-stream", "inline=true", "text/plain", "inline"},
+		{"recorded type wins over sniffing", text, "application/pdf", "", "application/pdf", "attachment"},
+		{"HTML is never inline", []byte("<!DOCTYPE html><html><body>hi</body></html>"), "", "inline=true", "text/html", "attachment"},
+	}
+	for i, test := range tests {
+		piece := createTestPiece(t, models.Piece{
+			UserID:      user.ID,
+			CID:         fmt.Sprintf("bagasniff%d:bagasub", i),
+			Filename:    "file",
+			Size:        int64(len(test.data)),
+			ContentType: test.stored,
+		})
+		fakePdptoolServing(t, test.data)
+
+		c, recorder := downloadRequest(piece.CID, test.query, user)
+		DownloadFile(c)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d: %s", test.name, recorder.Code, http.StatusOK, recorder.Body)
+			continue
+		}
+		if got := recorder.Header().Get("Content-Type"); got != test.want {
+			t.Errorf("%s: Content-Type = %q, want %q", test.name, got, test.want)
+		}
+		if got := recorder.Header().Get("Content-Disposition"); !strings.HasPrefix(got, test.disposition+";") {
+			t.Errorf("%s: Content-Disposition = %q, want %s", test.name, got, test.disposition)
+		}
+	}
+}
+
+func TestDownloadFileHead(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	checksum := strings.Repeat("ab", 32)
+	piece := createTestPiece(t, models.Piece{
+		UserID:      user.ID,
+		CID:         "bagaone:bagasub",
+		Filename:    "movie.mp4",
+		Size:        1234,
+		PaddedSize:  1248,
+		Checksum:    &checksum,
+		ContentType: "video/mp4",
+	})
+	calls := fakePdptool(t, "exit 1")
+
+	c, recorder := downloadRequest(piece.CID, "", user)
+	c.Request.Method = http.MethodHead
+	DownloadFile(c)
+	c.Writer.WriteHeaderNow()
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	want := map[string]string{
+		"Content-Length": "1234",
+		"Content-Type":   "video/mp4",
+		"ETag":           `"` + checksum + `"`,
+		"Last-Modified":  piece.CreatedAt.UTC().Format(http.TimeFormat),
+		"Accept-Ranges":  "bytes",
+	}
+	for name, value := range want {
+		if got := recorder.Header().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	if recorder.Body.Len() != 0 {
+		t.Errorf("HEAD response has a body: %q", recorder.Body)
+	}
+	if called := pdptoolCalls(t, calls, ""); len(called) != 0 {
+		t.Errorf("pdptool was run for HEAD: %q", called)
+	}
+
+	// Someone else's piece is as missing for HEAD as for GET.
+	other := createTestUser(t, "0x2")
+	c, recorder = downloadRequest(piece.CID, "", other)
+	c.Request.Method = http.MethodHead
+	DownloadFile(c)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("other user: status = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+}
+
+func TestDownloadFileIfNoneMatch(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	user := createTestUser(t, "0x1")
+	data := []byte("cached on the client")
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	piece := createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaone:bagasub", Filename: "a.txt", Size: int64(len(data)), Checksum: &checksum})
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        int
+	}{
+		{"matching ETag", `"` + checksum + `"`, http.StatusNotModified},
+		{"weak matching ETag", `W/"` + checksum + `"`, http.StatusNotModified},
+		{"one of several ETags", `"stale", "` + checksum + `"`, http.StatusNotModified},
+		{"any ETag", "*", http.StatusNotModified},
+		{"other ETag", `"stale"`, http.StatusOK},
+		{"unquoted checksum", checksum, http.StatusOK},
+		{"no ETag", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		calls := fakePdptoolServing(t, data)
+		c, recorder := downloadRequest(piece.CID, "", user)
+		if test.ifNoneMatch != "" {
+			c.Request.Header.Set("If-None-Match", test.ifNoneMatch)
+		}
+		DownloadFile(c)
+		c.Writer.WriteHeaderNow()
+
+		if recorder.Code != test.want {
+			t.Errorf("%s: status = %d, want %d", test.name, recorder.Code, test.want)
+			continue
+		}
+		fetched := len(pdptoolCalls(t, calls, "download-file")) > 0
+		if test.want == http.StatusNotModified {
+			if fetched || recorder.Body.Len() != 0 {
+				t.Errorf("%s: 304 fetched the file or sent a body", test.name)
+			}
+			if got := recorder.Header().Get("ETag"); got != `"`+checksum+`"` {
+				t.Errorf("%s: ETag = %q, want the checksum", test.name, got)
+			}
+		} else if recorder.Body.String() != string(data) {
+			t.Errorf("%s: body = %q, want the file", test.name, recorder.Body)
+		}
+	}
+}
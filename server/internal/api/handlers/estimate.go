@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/services/pricing"
+)
+
+// pricer supplies the rates cost estimates use. It is replaced by the source
+// PRICING_SOURCE selects once the handlers are initialized.
+var pricer pricing.Source = pricing.FreeSource{}
+
+const (
+	estimateUnit = "attoFIL"
+	// estimateMonth is the month ongoing costs are given for.
+	estimateMonth = 30 * 24 * time.Hour
+	tebibyte      = 1 << 40
+)
+
+// Kinds of CostEstimate.
+const (
+	estimateKindUpload   = "upload"
+	estimateKindProofSet = "proof-set"
+)
+
+// CostEstimate is an estimate of what storing something costs
+// @Description Estimated cost, in attoFIL, of uploading a file or creating a proof set. These are estimates from the inputs listed, not quotes: the gas price moves, and the gas a transaction uses depends on the network. oneTime is paid once, by the add-roots or create-proof-set transaction; monthly is the ongoing cost over 30 days, storage for a file and proving for a proof set.
+type CostEstimate struct {
+	Estimate bool           `json:"estimate"`
+	Kind     string         `json:"kind" example:"upload"`
+	Unit     string         `json:"unit" example:"attoFIL"`
+	OneTime  string         `json:"oneTime" example:"5000000000"`
+	Monthly  string         `json:"monthly" example:"0"`
+	Inputs   EstimateInputs `json:"inputs"`
+}
+
+// EstimateInputs are what a CostEstimate was computed from
+// @Description The values an estimate was computed from. gasPriceFrom is rpc when the gas price was read from the network, config when the configured price was used and none when the pricing source is free.
+type EstimateInputs struct {
+	PricingSource      string `json:"pricingSource" example:"config"`
+	SizeBytes          int64  `json:"sizeBytes,omitempty"`
+	PieceSize          int64  `json:"pieceSize,omitempty"`
+	GasPrice           string `json:"gasPrice"`
+	GasPriceFrom       string `json:"gasPriceFrom" example:"rpc"`
+	Gas                int64  `json:"gas"`
+	StoragePerTiBMonth string `json:"storagePerTibMonth,omitempty"`
+	ProveGas           int64  `json:"proveGas,omitempty"`
+	ProvingPeriod      string `json:"provingPeriod,omitempty"`
+	ProofsPerMonth     int64  `json:"proofsPerMonth,omitempty"`
+}
+
+// gasCost returns gas * price.
+func gasCost(gas int64, price *big.Int) *big.Int {
+	return new(big.Int).Mul(big.NewInt(gas), price)
+}
+
+// uploadEstimate estimates storing a file of size bytes: one add-roots
+// transaction, then storage of its piece.
+func uploadEstimate(rates pricing.Rates, size int64) CostEstimate {
+	pieceSize := alignedPieceSize(size)
+	monthly := new(big.Int).Mul(rates.StoragePerTiBMonth, big.NewInt(pieceSize))
+	// Round up, so a small file on a paid network isn't estimated as free.
+	monthly.Add(monthly, big.NewInt(tebibyte-1))
+	monthly.Quo(monthly, big.NewInt(tebibyte))
+
+	return CostEstimate{
+		Estimate: true,
+		Kind:     estimateKindUpload,
+		Unit:     estimateUnit,
+		OneTime:  gasCost(rates.AddRootsGas, rates.GasPrice).String(),
+		Monthly:  monthly.String(),
+		Inputs: EstimateInputs{
+			PricingSource:      rates.Source,
+			SizeBytes:          size,
+			PieceSize:          pieceSize,
+			GasPrice:           rates.GasPrice.String(),
+			GasPriceFrom:       rates.GasPriceFrom,
+			Gas:                rates.AddRootsGas,
+			StoragePerTiBMonth: rates.StoragePerTiBMonth.String(),
+		},
+	}
+}
+
+// proofSetEstimate estimates a proof set: one create-proof-set transaction,
+// then a proof of possession every proving period.
+func proofSetEstimate(rates pricing.Rates) CostEstimate {
+	var proofsPerMonth int64
+	if rates.ProvingPeriod > 0 {
+		proofsPerMonth = int64(estimateMonth / rates.ProvingPeriod)
+	}
+	estimate := CostEstimate{
+		Estimate: true,
+		Kind:     estimateKindProofSet,
+		Unit:     estimateUnit,
+		OneTime:  gasCost(rates.CreateProofSetGas, rates.GasPrice).String(),
+		Monthly:  gasCost(rates.ProveGas*proofsPerMonth, rates.GasPrice).String(),
+		Inputs: EstimateInputs{
+			PricingSource:  rates.Source,
+			GasPrice:       rates.GasPrice.String(),
+			GasPriceFrom:   rates.GasPriceFrom,
+			Gas:            rates.CreateProofSetGas,
+			ProveGas:       rates.ProveGas,
+			ProofsPerMonth: proofsPerMonth,
+		},
+	}
+	if rates.ProvingPeriod > 0 {
+		estimate.Inputs.ProvingPeriod = rates.ProvingPeriod.String()
+	}
+	return estimate
+}
+
+// @Summary Estimate the cost of storing a file
+// @Description Estimate what uploading a file of sizeBytes costs: the add-roots transaction adding it to a proof set, and storing its piece for a month. Rates come from the configured pricing source; on networks where storage is free every amount is 0.
+// @Tags estimates
+// @Param sizeBytes query int true "Size of the file in bytes"
+// @Produce json
+// @Success 200 {object} CostEstimate
+// @Failure 400 {object} ErrorResponse
+// @Failure 502 {object} ErrorResponse
+// @Router /api/v1/estimate [get]
+func GetUploadEstimate(c *gin.Context) {
+	size, err := strconv.ParseInt(c.Query("sizeBytes"), 10, 64)
+	if err != nil || size <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "sizeBytes must be a positive integer",
+		})
+		return
+	}
+
+	rates, err := pricer.Rates(c.Request.Context())
+	if err != nil {
+		log.WithField("error", err.Error()).Error("Failed to load pricing rates")
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Pricing is unavailable",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, uploadEstimate(rates, size))
+}
+
+// @Summary Estimate the cost of a proof set
+// @Description Estimate what creating a proof set costs: the create-proof-set transaction, and submitting its proofs of possession for a month. Rates come from the configured pricing source; on networks where storage is free every amount is 0.
+// @Tags estimates
+// @Produce json
+// @Success 200 {object} CostEstimate
+// @Failure 502 {object} ErrorResponse
+// @Router /api/v1/estimate/proof-set [get]
+func GetProofSetEstimate(c *gin.Context) {
+	rates, err := pricer.Rates(c.Request.Context())
+	if err != nil {
+		log.WithField("error", err.Error()).Error("Failed to load pricing rates")
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "Pricing is unavailable",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, proofSetEstimate(rates))
+}
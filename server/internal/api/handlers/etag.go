@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// notModified sets etag as the response's ETag, with a Cache-Control that
+// has clients revalidate their copy on every use, and responds 304 when the
+// request's If-None-Match already lists it. It reports whether it responded.
+func notModified(c *gin.Context, etag string) bool {
+	c.Header("Cache-Control", "private, no-cache")
+	c.Header("ETag", `"`+etag+`"`)
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// jsonWithETag responds with body as JSON, tagged with a hash of it so an
+// unchanged body isn't sent again.
+func jsonWithETag(c *gin.Context, body interface{}) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		c.JSON(http.StatusOK, body)
+		return
+	}
+	sum := sha256.Sum256(b)
+	if notModified(c, hex.EncodeToString(sum[:16])) {
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", b)
+}
+
+// pieceListETag fingerprints everything a page of the user's pieces is
+// built from: their pieces, including deleted ones and their downloads, the
+// tags on them, their proof sets and the pieces shared with them. It is
+// computed with counts and latest change times, so it is much cheaper than
+// the page itself. The request's path and query are part of it, as they
+// pick the page.
+func pieceListETag(c *gin.Context, userID interface{}) (string, error) {
+	var fingerprint struct {
+		Pieces           int64
+		PiecesChanged    *time.Time
+		Tags             int64
+		TagsChanged      *time.Time
+		ProofSetsChanged *time.Time
+		Grants           int64
+		GrantsChanged    *time.Time
+	}
+	if err := db.Raw(`
+		SELECT * FROM
+			(SELECT COUNT(*) AS pieces, GREATEST(MAX(updated_at), MAX(deleted_at), MAX(last_downloaded_at)) AS pieces_changed
+				FROM pieces WHERE user_id = @user) p,
+			(SELECT COUNT(*) AS tags, MAX(piece_tags.created_at) AS tags_changed
+				FROM piece_tags JOIN pieces ON pieces.id = piece_tags.piece_id WHERE pieces.user_id = @user) t,
+			(SELECT MAX(updated_at) AS proof_sets_changed
+				FROM proof_sets WHERE user_id = @user) ps,
+			(SELECT COUNT(*) AS grants, GREATEST(MAX(piece_grants.created_at), MAX(pieces.updated_at), MAX(pieces.deleted_at), MAX(pieces.last_downloaded_at)) AS grants_changed
+				FROM piece_grants JOIN pieces ON pieces.id = piece_grants.piece_id WHERE piece_grants.grantee_id = @user) g`,
+		sql.Named("user", userID)).Scan(&fingerprint).Error; err != nil {
+		return "", err
+	}
+
+	unixNano := func(t *time.Time) int64 {
+		if t == nil {
+			return 0
+		}
+		return t.UnixNano()
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s?%s|%d|%d|%d|%d|%d|%d|%d",
+		c.Request.URL.Path, c.Request.URL.RawQuery,
+		fingerprint.Pieces, unixNano(fingerprint.PiecesChanged),
+		fingerprint.Tags, unixNano(fingerprint.TagsChanged),
+		unixNano(fingerprint.ProofSetsChanged),
+		fingerprint.Grants, unixNano(fingerprint.GrantsChanged))))
+	return hex.EncodeToString(sum[:16]), nil
+}
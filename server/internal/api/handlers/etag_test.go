@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+)
+
+// conditionalRequest runs handler for a GET of target as user, sending
+// etag in If-None-Match when it is set.
+func conditionalRequest(handler gin.HandlerFunc, target string, params gin.Params, etag string, user models.User) *httptest.ResponseRecorder {
+	c, recorder := newTestContext(http.MethodGet, target, nil, user)
+	c.Params = params
+	if etag != "" {
+		c.Request.Header.Set("If-None-Match", etag)
+	}
+	handler(c)
+	c.Writer.WriteHeaderNow()
+	return recorder
+}
+
+func TestGetUserPiecesETag(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	other := createTestUser(t, "0x2")
+	proofSet := createTestProofSet(t, user, "42")
+	piece := createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaone:bagasub", Filename: "a.txt", Size: 5, ProofSetID: &proofSet.ID})
+	list := func(query, etag string) *httptest.ResponseRecorder {
+		return conditionalRequest(GetUserPieces, "/api/v1/pieces?"+query, nil, etag, user)
+	}
+
+	recorder := list("", "")
+	etag := recorder.Header().Get("ETag")
+	if recorder.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status = %d, ETag = %q, want 200 with an ETag", recorder.Code, etag)
+	}
+	if got := recorder.Header().Get("Cache-Control"); got != "private, no-cache" {
+		t.Errorf("Cache-Control = %q, want private, no-cache", got)
+	}
+	recorder = list("", etag)
+	if recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+		t.Fatalf("unchanged pieces: status = %d with %d bytes, want an empty 304", recorder.Code, recorder.Body.Len())
+	}
+	if recorder = list("sortBy=size", etag); recorder.Code != http.StatusOK {
+		t.Errorf("another page: status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+
+	// Another user's pieces are no part of the list.
+	createTestPiece(t, models.Piece{UserID: other.ID, CID: "bagaother:bagasub", Filename: "b.txt", Size: 5})
+	if recorder = list("", etag); recorder.Code != http.StatusNotModified {
+		t.Errorf("another user's new piece: status = %d, want %d", recorder.Code, http.StatusNotModified)
+	}
+
+	mutations := []struct {
+		name   string
+		mutate func()
+	}{
+		{"renamed piece", func() { db.Model(&piece).Update("filename", "renamed.txt") }},
+		{"new piece", func() {
+			createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagatwo:bagasub", Filename: "c.txt", Size: 5})
+		}},
+		{"tagged piece", func() {
+			tag := models.Tag{UserID: user.ID, Name: "reports"}
+			db.Create(&tag)
+			db.Create(&models.PieceTag{PieceID: piece.ID, TagID: tag.ID})
+		}},
+		{"downloaded piece", func() { countPieceDownloads(piece.ID) }},
+		{"updated proof set", func() { db.Model(&proofSet).Update("name", "Reports") }},
+		{"deleted piece", func() { db.Delete(&piece) }},
+	}
+	for _, mutation := range mutations {
+		mutation.mutate()
+		recorder := list("", etag)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d", mutation.name, recorder.Code, http.StatusOK)
+			continue
+		}
+		changed := recorder.Header().Get("ETag")
+		if changed == etag {
+			t.Errorf("%s: ETag is unchanged", mutation.name)
+		}
+		if recorder := list("", changed); recorder.Code != http.StatusNotModified {
+			t.Errorf("%s: new ETag: status = %d, want %d", mutation.name, recorder.Code, http.StatusNotModified)
+		}
+		etag = changed
+	}
+}
+
+func TestGetPieceETag(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	piece := createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaone:bagasub", Filename: "a.txt", Size: 5})
+	id := strconv.FormatUint(uint64(piece.ID), 10)
+
+	endpoints := []struct {
+		name    string
+		handler gin.HandlerFunc
+		target  string
+		params  gin.Params
+	}{
+		{"by ID", GetPieceByID, "/api/v1/pieces/" + id, gin.Params{{Key: "id", Value: id}}},
+		{"by CID", GetPieceByCID, "/api/v1/pieces/cid/" + piece.CID, gin.Params{{Key: "cid", Value: piece.CID}}},
+	}
+	etags := make([]string, len(endpoints))
+	for i, endpoint := range endpoints {
+		recorder := conditionalRequest(endpoint.handler, endpoint.target, endpoint.params, "", user)
+		etags[i] = recorder.Header().Get("ETag")
+		if recorder.Code != http.StatusOK || etags[i] == "" {
+			t.Fatalf("%s: status = %d, ETag = %q, want 200 with an ETag", endpoint.name, recorder.Code, etags[i])
+		}
+		recorder = conditionalRequest(endpoint.handler, endpoint.target, endpoint.params, etags[i], user)
+		if recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+			t.Errorf("%s: unchanged piece: status = %d with %d bytes, want an empty 304", endpoint.name, recorder.Code, recorder.Body.Len())
+		}
+	}
+
+	db.Model(&piece).Update("filename", "renamed.txt")
+	for i, endpoint := range endpoints {
+		recorder := conditionalRequest(endpoint.handler, endpoint.target, endpoint.params, etags[i], user)
+		if recorder.Code != http.StatusOK || recorder.Header().Get("ETag") == etags[i] {
+			t.Errorf("%s: renamed piece: status = %d, ETag %q, want 200 with a new ETag", endpoint.name, recorder.Code, recorder.Header().Get("ETag"))
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxFilenameBytes keeps sanitized names well below the 255 byte limit of
+// common filesystems, leaving room for prefixes added to temp files.
+const maxFilenameBytes = 200
+
+// sanitizeFilename turns a client supplied filename into a single path
+// component that is safe to use inside a temp directory. The original name
+// is still what gets stored and shown to the user; this is only for the
+// filesystem and response headers.
+func sanitizeFilename(name string) string {
+	name = cleanFilename(name)
+	name = strings.TrimLeft(name, ".")
+	name = truncateFilename(name, maxFilenameBytes)
+
+	if name == "" {
+		return "file-" + uuid.New().String()
+	}
+	return name
+}
+
+// cleanFilename normalizes name to NFC, replaces path separators and drops
+// control characters and surrounding whitespace.
+func cleanFilename(name string) string {
+	name = norm.NFC.String(strings.ToValidUTF8(name, ""))
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\':
+			return '_'
+		case r == 0 || unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, name)
+
+	return strings.TrimSpace(name)
+}
+
+// truncateFilename shortens name to at most max bytes on a rune boundary,
+// keeping a short extension intact.
+func truncateFilename(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	if len(ext) > 16 {
+		ext = ""
+	}
+	base := strings.TrimSuffix(name, ext)
+	limit := max - len(ext)
+	for limit > 0 && !utf8.RuneStart(base[limit]) {
+		limit--
+	}
+	return base[:limit] + ext
+}
+
+// contentDisposition builds a Content-Disposition header value of the given
+// type ("attachment" or "inline") for name, following RFC 6266. Names that
+// aren't plain ASCII get an ASCII filename= fallback for old clients plus
+// the exact name as an RFC 5987 filename*= parameter.
+func contentDisposition(disposition, name string) string {
+	name = sanitizeFilename(name)
+
+	fallback := strings.Map(func(r rune) rune {
+		// % is replaced too, as some browsers percent-decode filename=.
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' || r == '%' {
+			return '_'
+		}
+		return r
+	}, name)
+
+	value := disposition + "; filename=" + strconv.Quote(fallback)
+	if fallback != name {
+		value += "; filename*=UTF-8''" + encodeRFC5987(name)
+	}
+	return value
+}
+
+// encodeRFC5987 percent-encodes every byte of s that isn't an RFC 5987
+// attr-char.
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+			strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"mime"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "report.pdf", "report.pdf"},
+		{"non-ASCII", "résumé 履歴書.pdf", "résumé 履歴書.pdf"},
+		{"decomposed accents are composed", "re\u0301sume\u0301.pdf", "r\u00e9sum\u00e9.pdf"},
+		{"path separators", "../../etc/passwd", "_.._etc_passwd"},
+		{"backslashes", `..\..\boot.ini`, "_.._boot.ini"},
+		{"control characters", "a\x00b\r\nc\t.txt", "abc.txt"},
+		{"surrounding whitespace", "  notes.txt  ", "notes.txt"},
+		{"leading dots", "..hidden", "hidden"},
+		{"quotes, semicolons and percent", `a";b%20.txt`, `a";b%20.txt`},
+		{"invalid UTF-8", "a\xffb.txt", "ab.txt"},
+	}
+	for _, test := range tests {
+		if got := sanitizeFilename(test.in); got != test.want {
+			t.Errorf("%s: sanitizeFilename(%q) = %q, want %q", test.name, test.in, got, test.want)
+		}
+	}
+}
+
+func TestSanitizeFilenameWithNothingLeft(t *testing.T) {
+	for _, in := range []string{"", ".", "..", "...", " . ", "\x00\r\n"} {
+		got := sanitizeFilename(in)
+		if !strings.HasPrefix(got, "file-") || len(got) <= len("file-") {
+			t.Errorf("sanitizeFilename(%q) = %q, want a generated file- name", in, got)
+		}
+	}
+	if sanitizeFilename("..") == sanitizeFilename("..") {
+		t.Errorf("generated names are not unique")
+	}
+}
+
+func TestTruncateFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		max  int
+		want string
+	}{
+		{"short enough", "a.txt", 10, "a.txt"},
+		{"exactly at the limit", "abcde.txt", 9, "abcde.txt"},
+		{"keeps the extension", "abcdefgh.txt", 9, "abcde.txt"},
+		{"drops a long extension", "ab." + strings.Repeat("x", 20), 5, "ab.xx"},
+		{"two-byte runes", strings.Repeat("é", 10) + ".txt", 11, "ééé.txt"},
+		{"cuts before a split three-byte rune", strings.Repeat("€", 10) + ".txt", 12, "€€.txt"},
+		{"cuts before a split four-byte rune", strings.Repeat("😀", 4) + ".txt", 11, "😀.txt"},
+	}
+	for _, test := range tests {
+		got := truncateFilename(test.in, test.max)
+		if got != test.want {
+			t.Errorf("%s: truncateFilename(%q, %d) = %q, want %q", test.name, test.in, test.max, got, test.want)
+		}
+		if len(got) > test.max || !utf8.ValidString(got) {
+			t.Errorf("%s: truncateFilename(%q, %d) = %q is too long or not valid UTF-8", test.name, test.in, test.max, got)
+		}
+	}
+}
+
+func TestSanitizeFilenameTruncatesLongNames(t *testing.T) {
+	got := sanitizeFilename(strings.Repeat("€", 100) + ".pdf")
+	if len(got) > maxFilenameBytes || !utf8.ValidString(got) || !strings.HasSuffix(got, "€.pdf") {
+		t.Errorf("sanitizeFilename of a long name = %q (%d bytes)", got, len(got))
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          string
+		want        string
+		wantDecoded string
+	}{
+		{"ASCII", "report.pdf", `attachment; filename="report.pdf"`, "report.pdf"},
+		{"non-ASCII", "résumé.pdf", `attachment; filename="r_sum_.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf`, "résumé.pdf"},
+		{"double quote", `say "hi".txt`, `attachment; filename="say _hi_.txt"; filename*=UTF-8''say%20%22hi%22.txt`, `say "hi".txt`},
+		{"backslash", `a\b.txt`, `attachment; filename="a_b.txt"`, "a_b.txt"},
+		{"percent", "100%.txt", `attachment; filename="100_.txt"; filename*=UTF-8''100%25.txt`, "100%.txt"},
+		{"semicolon", "a;b=c.txt", `attachment; filename="a;b=c.txt"`, "a;b=c.txt"},
+		{"only dots", "...", "", ""},
+	}
+	for _, test := range tests {
+		got := contentDisposition("attachment", test.in)
+		if test.want != "" && got != test.want {
+			t.Errorf("%s: contentDisposition(%q) = %s, want %s", test.name, test.in, got, test.want)
+		}
+		disposition, params, err := mime.ParseMediaType(got)
+		if err != nil || disposition != "attachment" {
+			t.Errorf("%s: %s does not parse: %v", test.name, got, err)
+			continue
+		}
+		if test.wantDecoded != "" && params["filename"] != test.wantDecoded {
+			t.Errorf("%s: filename parses as %q, want %q", test.name, params["filename"], test.wantDecoded)
+		}
+	}
+}
+
+func TestContentDispositionHeaderInjection(t *testing.T) {
+	for _, in := range []string{
+		"a.txt\r\nSet-Cookie: session=1",
+		"a.txt\";\r\n\r\n<html>",
+		"a.txt\"; filename=\"evil.exe",
+		"a.txt\nContent-Type: text/html",
+	} {
+		got := contentDisposition("inline", in)
+		if strings.ContainsAny(got, "\r\n") {
+			t.Errorf("contentDisposition(%q) = %q contains a line break", in, got)
+		}
+		disposition, params, err := mime.ParseMediaType(got)
+		if err != nil || disposition != "inline" {
+			t.Errorf("contentDisposition(%q) = %q does not parse: %v", in, got, err)
+			continue
+		}
+		if want := sanitizeFilename(in); params["filename"] != want {
+			t.Errorf("contentDisposition(%q): filename parses as %q, want %q", in, params["filename"], want)
+		}
+		if len(params) != 1 {
+			t.Errorf("contentDisposition(%q) = %q has extra parameters: %v", in, got, params)
+		}
+	}
+}
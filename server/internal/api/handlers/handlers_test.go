@@ -0,0 +1,423 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/config"
+	"github.com/hotvault/backend/internal/models"
+	"github.com/hotvault/backend/pkg/logger"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Setenv("LOG_LEVEL", "error")
+	log = logger.NewLogger()
+	os.Exit(m.Run())
+}
+
+// testDriver is SQLite with the Postgres functions the handlers use.
+const testDriver = "sqlite3_hotvault"
+
+// sqliteTimeFormat is how go-sqlite3 stores times.
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+func init() {
+	sql.Register(testDriver, &sqliteTimeDriver{sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("greatest", sqlGreatest, true); err != nil {
+				return err
+			}
+			return conn.RegisterFunc("now", func() string {
+				return time.Now().UTC().Format(sqliteTimeFormat)
+			}, false)
+		},
+	}})
+}
+
+// sqlGreatest is Postgres' GREATEST, which skips NULLs. SQLite hands NULLs
+// over as nil byte slices. Values of one column compare the same whether
+// SQLite hands them over as text or numbers.
+func sqlGreatest(values ...interface{}) interface{} {
+	var greatest interface{}
+	for _, value := range values {
+		if b, ok := value.([]byte); value == nil || ok && b == nil {
+			continue
+		}
+		if greatest == nil || fmt.Sprint(value) > fmt.Sprint(greatest) {
+			greatest = value
+		}
+	}
+	return greatest
+}
+
+// sqliteTimeDriver returns times computed by queries, such as MAX of a time
+// column, as times the way Postgres does. SQLite only knows a result is a
+// time when it is a column of a table, and otherwise returns it as text.
+type sqliteTimeDriver struct {
+	sqlite3.SQLiteDriver
+}
+
+func (d *sqliteTimeDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteTimeConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+type sqliteTimeConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c sqliteTimeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteTimeRows{rows.(*sqlite3.SQLiteRows)}, nil
+}
+
+type sqliteTimeRows struct {
+	*sqlite3.SQLiteRows
+}
+
+func (r sqliteTimeRows) Next(dest []driver.Value) error {
+	if err := r.SQLiteRows.Next(dest); err != nil {
+		return err
+	}
+	for i, value := range dest {
+		if text, ok := value.(string); ok {
+			if t, err := time.Parse(sqliteTimeFormat, text); err == nil {
+				dest[i] = t
+			}
+		}
+	}
+	return nil
+}
+
+// postgresSQL rewrites Postgres syntax the handlers use that SQLite has an
+// equivalent for. SQLite's LIKE already ignores ASCII case.
+var postgresSQL = strings.NewReplacer(
+	" USING gin", "",
+	" ILIKE ", " LIKE ",
+	" AT TIME ZONE 'UTC'", "",
+)
+
+// sqliteConn passes statements to SQLite after rewriting them.
+type sqliteConn struct {
+	conn interface {
+		PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+		ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+		QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+		QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	}
+}
+
+func (c sqliteConn) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return c.conn.PrepareContext(ctx, postgresSQL.Replace(query))
+}
+
+func (c sqliteConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(ctx, postgresSQL.Replace(query), args...)
+}
+
+func (c sqliteConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(ctx, postgresSQL.Replace(query), args...)
+}
+
+func (c sqliteConn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(ctx, postgresSQL.Replace(query), args...)
+}
+
+// sqliteConnPool is the database the handlers are given.
+type sqliteConnPool struct {
+	sqliteConn
+	db *sql.DB
+}
+
+func (p *sqliteConnPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	tx, err := p.db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteTx{sqliteConn: sqliteConn{conn: tx}, tx: tx}, nil
+}
+
+func (p *sqliteConnPool) GetDBConn() (*sql.DB, error) { return p.db, nil }
+
+// sqliteTx is a transaction of sqliteConnPool. gorm tells one from the pool
+// by it committing and not beginning transactions of its own.
+type sqliteTx struct {
+	sqliteConn
+	tx *sql.Tx
+}
+
+func (t *sqliteTx) Commit() error   { return t.tx.Commit() }
+func (t *sqliteTx) Rollback() error { return t.tx.Rollback() }
+
+var testDBCount atomic.Int64
+
+// useTestDB points the handlers at a new SQLite database with every model
+// migrated. It lives in a file so concurrent requests get their own
+// connections, waiting on each other's writes as they would on Postgres.
+func useTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), fmt.Sprintf("hotvault-%d.db", testDBCount.Add(1)))
+	sqlDB, err := sql.Open(testDriver, "file:"+path+"?_busy_timeout=10000&_journal_mode=WAL&_txlock=immediate")
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	testDB, err := gorm.Open(&sqlite.Dialector{Conn: &sqliteConnPool{sqliteConn: sqliteConn{conn: sqlDB}, db: sqlDB}}, &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	if err := testDB.AutoMigrate(
+		&models.User{},
+		&models.Wallet{},
+		&models.Transaction{},
+		&models.ProofSet{},
+		&models.Piece{},
+		&models.UploadJob{},
+		&models.ArchiveEntry{},
+		&models.JobCommandLog{},
+		&models.RootTask{},
+		&models.UsageRecord{},
+		&models.ChunkedUpload{},
+		&models.DownloadLink{},
+		&models.PieceEvent{},
+		&models.Tag{},
+		&models.PieceTag{},
+		&models.Collection{},
+		&models.ShareLink{},
+		&models.PieceGrant{},
+		&models.ProofSetSync{},
+		&models.Webhook{},
+		&models.WebhookDelivery{},
+		&models.ProofRecord{},
+		&models.StorageProvider{},
+	); err != nil {
+		t.Fatalf("migrate test database: %v", err)
+	}
+	// The CID parts Postgres generates from c_id.
+	for _, stmt := range []string{
+		`ALTER TABLE pieces ADD COLUMN base_c_id text GENERATED ALWAYS AS (CASE WHEN instr(c_id, ':') > 0 THEN substr(c_id, 1, instr(c_id, ':') - 1) ELSE c_id END) VIRTUAL`,
+		`ALTER TABLE pieces ADD COLUMN subroot_c_id text GENERATED ALWAYS AS (CASE WHEN instr(c_id, ':') > 0 THEN substr(c_id, instr(c_id, ':') + 1) ELSE '' END) VIRTUAL`,
+	} {
+		if err := testDB.Exec(stmt).Error; err != nil {
+			t.Fatalf("migrate test database: %v", err)
+		}
+	}
+
+	previous := db
+	db = testDB
+	t.Cleanup(func() { db = previous })
+	return testDB
+}
+
+// useTestConfig gives the handlers the default configuration, with their
+// directories under the test's temp directory. Tests adjust it as needed.
+func useTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+	testCfg := config.LoadConfig()
+	testCfg.Upload.ChunkDir = filepath.Join(t.TempDir(), "chunks")
+	testCfg.Download.CacheDir = filepath.Join(t.TempDir(), "cache")
+	testCfg.Upload.DiskReserve = 0
+	testCfg.ServiceName = "hotvault"
+	testCfg.ServiceURL = "https://sp.example.com"
+
+	previous := cfg
+	cfg = testCfg
+	t.Cleanup(func() { cfg = previous })
+	return testCfg
+}
+
+func createTestUser(t *testing.T, wallet string) models.User {
+	t.Helper()
+	user := models.User{WalletAddress: strings.ToLower(wallet), Nonce: "nonce"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+func createTestProofSet(t *testing.T, user models.User, proofSetID string) models.ProofSet {
+	t.Helper()
+	proofSet := models.ProofSet{
+		UserID:          user.ID,
+		ProofSetID:      proofSetID,
+		TransactionHash: "0x" + strings.Repeat("ab", 32),
+		ServiceName:     cfg.ServiceName,
+		ServiceURL:      cfg.ServiceURL,
+		Status:          proofSetReady,
+	}
+	if err := db.Create(&proofSet).Error; err != nil {
+		t.Fatalf("create proof set: %v", err)
+	}
+	return proofSet
+}
+
+func createTestPiece(t *testing.T, piece models.Piece) models.Piece {
+	t.Helper()
+	if piece.ServiceName == "" {
+		piece.ServiceName = cfg.ServiceName
+		piece.ServiceURL = cfg.ServiceURL
+	}
+	if piece.Filename == "" {
+		piece.Filename = "file.bin"
+	}
+	if err := db.Create(&piece).Error; err != nil {
+		t.Fatalf("create piece: %v", err)
+	}
+	return piece
+}
+
+// newTestContext returns a request context as the JWT middleware leaves it
+// for user, and the recorder its response is written to. A zero user leaves
+// the context unauthenticated.
+func newTestContext(method, target string, body io.Reader, user models.User) (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(method, target, body)
+	if user.ID != 0 {
+		c.Set("userID", user.ID)
+		c.Set("walletAddress", user.WalletAddress)
+	}
+	return c, recorder
+}
+
+// fakePdptool installs a shell script as pdptool that runs script for every
+// command and returns the path of the file each call's arguments are
+// appended to, one call per line. The service secret is created up front,
+// --help, which the upload preflight probes with, always succeeds, and
+// cached get-proof-set answers are dropped.
+func fakePdptool(t *testing.T, script string) string {
+	t.Helper()
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls.log")
+	path := filepath.Join(dir, "pdptool")
+	body := "#!/bin/sh\n[ \"$1\" = --help ] && exit 0\necho \"$*\" >> " + calls + "\n" + script + "\n"
+	if err := os.WriteFile(path, []byte(body), 0755); err != nil {
+		t.Fatalf("write fake pdptool: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pdpservice.json"), []byte(`{"name":"test"}`), 0600); err != nil {
+		t.Fatalf("write service secret: %v", err)
+	}
+	cfg.PdptoolPath = path
+
+	// Nothing the last fake service answered applies to this one.
+	proofSetStatusCacheMutex.Lock()
+	proofSetStatusCache = make(map[string]proofSetStatusEntry)
+	proofSetStatusCacheMutex.Unlock()
+	return calls
+}
+
+// fakePdptoolServing installs a fake pdptool whose download-file writes data
+// to the file it is asked for, and returns the path of its calls file.
+func fakePdptoolServing(t *testing.T, data []byte) string {
+	t.Helper()
+	piece := filepath.Join(t.TempDir(), "piece")
+	if err := os.WriteFile(piece, data, 0644); err != nil {
+		t.Fatalf("write served piece: %v", err)
+	}
+	return fakePdptool(t, `case "$1" in download-file)
+	while [ $# -gt 0 ]; do [ "$1" = --output-file ] && out="$2"; shift; done
+	cp `+piece+` "$out";;
+esac`)
+}
+
+// pdptoolCalls returns the argument lists of the fake pdptool's calls that
+// start with command, or of all its calls when command is empty.
+func pdptoolCalls(t *testing.T, callsPath, command string) []string {
+	t.Helper()
+	data, err := os.ReadFile(callsPath)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("read pdptool calls: %v", err)
+	}
+	var calls []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line != "" && (command == "" || strings.HasPrefix(line, command+" ") || line == command) {
+			calls = append(calls, line)
+		}
+	}
+	return calls
+}
+
+// newJSONRequest returns a context for a request to target as user with body
+// encoded as JSON.
+func newJSONRequest(t *testing.T, method, target string, body interface{}, user models.User) (*gin.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("encode request: %v", err)
+	}
+	c, recorder := newTestContext(method, target, bytes.NewReader(data), user)
+	c.Request.Header.Set("Content-Type", "application/json")
+	return c, recorder
+}
+
+// useTestUploadQueue installs an upload queue without workers, so uploads
+// that are accepted stay queued rather than being processed. Their temp
+// files go to a directory removed with the test.
+func useTestUploadQueue(t *testing.T) *uploadQueue {
+	t.Helper()
+	t.Setenv("TMPDIR", t.TempDir())
+	previous := uploads
+	uploads = newUploadQueue(0, 0)
+	t.Cleanup(func() { uploads = previous })
+	return uploads
+}
+
+// testFile is a file part of a multipart test request.
+type testFile struct {
+	field, name string
+	data        []byte
+}
+
+// newMultipartRequest returns a context for a multipart POST of fields and
+// files to target as user.
+func newMultipartRequest(t *testing.T, target string, fields map[string]string, files []testFile, user models.User) (*gin.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			t.Fatalf("write form field: %v", err)
+		}
+	}
+	for _, file := range files {
+		part, err := writer.CreateFormFile(file.field, file.name)
+		if err != nil {
+			t.Fatalf("create form file: %v", err)
+		}
+		part.Write(file.data)
+	}
+	writer.Close()
+
+	c, recorder := newTestContext(http.MethodPost, target, &body, user)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	return c, recorder
+}
@@ -8,6 +8,10 @@ import (
 
 type HealthResponse struct {
 	Status string `json:"status" example:"ok"`
+	// DownloadCache is reported when downloads are cached.
+	DownloadCache *DownloadCacheStats `json:"downloadCache,omitempty"`
+	// Removals is reported while the removal finalizer runs.
+	Removals *RemovalFinalizerStats `json:"removals,omitempty"`
 }
 
 // HealthCheck godoc
@@ -18,9 +22,18 @@ type HealthResponse struct {
 // @Success 200 {object} HealthResponse
 // @Router /health [get]
 func HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, HealthResponse{
+	response := HealthResponse{
 		Status: "ok",
-	})
+	}
+	if downloads != nil {
+		stats := downloads.stats()
+		response.DownloadCache = &stats
+	}
+	if removals != nil && removals.interval > 0 {
+		stats := removals.snapshot()
+		response.Removals = &stats
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // NotFound godoc
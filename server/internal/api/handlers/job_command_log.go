@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+)
+
+// maxCommandLogOutput caps how much of each output stream is kept per command.
+const maxCommandLogOutput = 16 << 10
+
+// minSecretLength is the shortest pdpservice.json value treated as a secret,
+// so short fields like names are not redacted from every log.
+const minSecretLength = 16
+
+const redactedSecret = "[REDACTED]"
+
+// cappedBuffer keeps the first limit bytes written to it and drops the rest.
+// It never fails a write, so it can sit in a MultiWriter next to the caller's
+// own buffers without cutting the command's output short.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.buf.Len(); remaining > 0 {
+		if len(p) > remaining {
+			b.buf.Write(p[:remaining])
+			b.truncated = true
+		} else {
+			b.buf.Write(p)
+		}
+	} else if len(p) > 0 {
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "\n... [truncated]"
+	}
+	return b.buf.String()
+}
+
+// commandRecorder captures a pdptool command run for upload jobs and stores
+// it in each job's command log once it finishes.
+type commandRecorder struct {
+	jobIDs  []string
+	cmd     *exec.Cmd
+	started time.Time
+	stdout  *cappedBuffer
+	stderr  *cappedBuffer
+}
+
+// recordJobCommand tees cmd's output into a recorder. It must be called
+// before the command is started.
+func recordJobCommand(jobID string, cmd *exec.Cmd) *commandRecorder {
+	return recordJobsCommand([]string{jobID}, cmd)
+}
+
+// recordJobsCommand is recordJobCommand for a command shared by several jobs,
+// such as one get-proof-set call that resolves roots for all of them.
+func recordJobsCommand(jobIDs []string, cmd *exec.Cmd) *commandRecorder {
+	r := &commandRecorder{
+		jobIDs:  jobIDs,
+		cmd:     cmd,
+		started: time.Now(),
+		stdout:  &cappedBuffer{limit: maxCommandLogOutput},
+		stderr:  &cappedBuffer{limit: maxCommandLogOutput},
+	}
+	cmd.Stdout = teeWriter(cmd.Stdout, r.stdout)
+	cmd.Stderr = teeWriter(cmd.Stderr, r.stderr)
+	return r
+}
+
+func teeWriter(existing io.Writer, capture io.Writer) io.Writer {
+	if existing == nil {
+		return capture
+	}
+	return io.MultiWriter(existing, capture)
+}
+
+// finish stores the command's outcome. err is the result of Run or Wait.
+func (r *commandRecorder) finish(err error) {
+	if db == nil {
+		return
+	}
+
+	exitCode := 0
+	errMsg := ""
+	if err != nil {
+		exitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
+		errMsg = err.Error()
+	}
+
+	secrets := loadServiceSecrets()
+	command := redactSecrets(strings.Join(r.cmd.Args, " "), secrets)
+	stdout := redactSecrets(r.stdout.String(), secrets)
+	stderr := redactSecrets(r.stderr.String(), secrets)
+	errMsg = redactSecrets(errMsg, secrets)
+	duration := time.Since(r.started).Milliseconds()
+
+	for _, jobID := range r.jobIDs {
+		if jobID == "" {
+			continue
+		}
+		entry := models.JobCommandLog{
+			JobID:      jobID,
+			Command:    command,
+			ExitCode:   exitCode,
+			DurationMs: duration,
+			Stdout:     stdout,
+			Stderr:     stderr,
+			Error:      errMsg,
+		}
+		if dbErr := db.Create(&entry).Error; dbErr != nil {
+			log.WithField("jobID", jobID).WithField("error", dbErr.Error()).Warning("Failed to save pdptool command log")
+		}
+	}
+}
+
+// runJobCommand runs cmd and records it in the job's command log.
+func runJobCommand(jobID string, cmd *exec.Cmd) error {
+	recorder := recordJobCommand(jobID, cmd)
+	err := cmd.Run()
+	recorder.finish(err)
+	return err
+}
+
+// loadServiceSecrets reads the values stored in pdptool's pdpservice.json so
+// they can be scrubbed from command output. Multi-line values such as PEM
+// keys are also split into lines, since tools often print them that way.
+func loadServiceSecrets() []string {
+	data, err := os.ReadFile(serviceSecretPath(cfg.PdptoolPath))
+	if err != nil {
+		return nil
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil
+	}
+
+	var secrets []string
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if len(s) >= minSecretLength {
+			secrets = append(secrets, s)
+		}
+		for _, line := range strings.Split(s, "\n") {
+			line = strings.TrimSpace(line)
+			if len(line) >= minSecretLength && !strings.HasPrefix(line, "-----") {
+				secrets = append(secrets, line)
+			}
+		}
+	}
+	return secrets
+}
+
+func redactSecrets(s string, secrets []string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redactedSecret)
+	}
+	return s
+}
+
+// @Summary Get upload command logs
+// @Description List every pdptool command run for an upload job, with exit codes, durations and truncated output
+// @Tags upload
+// @Produce json
+// @Param jobId path string true "Job ID"
+// @Success 200 {array} models.JobCommandLog
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/upload/{jobId}/logs [get]
+func GetUploadJobLogs(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	jobID := c.Param("jobId")
+
+	_, ownerID, exists := getUploadJob(jobID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Upload job not found",
+		})
+		return
+	}
+
+	if ownerID != userID.(uint) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "You don't have permission to access this upload",
+		})
+		return
+	}
+
+	var logs []models.JobCommandLog
+	if err := db.Where("job_id = ?", jobID).Order("created_at ASC, id ASC").Find(&logs).Error; err != nil {
+		log.WithField("jobID", jobID).WithField("error", err.Error()).Error("Failed to load upload command logs")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load upload command logs",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, logs)
+}
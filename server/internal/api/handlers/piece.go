@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -10,20 +11,58 @@ import (
 )
 
 type PieceResponse struct {
-	ID                uint       `json:"id"`
-	UserID            uint       `json:"userId"`
-	CID               string     `json:"cid"`
-	Filename          string     `json:"filename"`
-	Size              int64      `json:"size"`
+	ID          uint   `json:"id"`
+	UserID      uint   `json:"userId"`
+	CID         string `json:"cid"`
+	BaseCID     string `json:"baseCid"`
+	SubrootCID  string `json:"subrootCid"`
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+	StoredSize  int64  `json:"storedSize"`
+	Compressed  bool   `json:"compressed,omitempty"`
+	FileGroupID string `json:"fileGroupId,omitempty"`
+	Version     int    `json:"version"`
+	IsCurrent   bool   `json:"isCurrent"`
+	Public      bool   `json:"public"`
+	Pinned      bool   `json:"pinned"`
+	// VersionCount is how many versions the file has, set when listing
+	// only current versions.
+	VersionCount      int        `json:"versionCount,omitempty"`
 	ServiceName       string     `json:"serviceName"`
 	ServiceURL        string     `json:"serviceUrl"`
 	PendingRemoval    *bool      `json:"pendingRemoval,omitempty"`
 	RemovalDate       *time.Time `json:"removalDate,omitempty"`
 	ProofSetDbID      *uint      `json:"proofSetDbId,omitempty"`
 	ServiceProofSetID *string    `json:"serviceProofSetId,omitempty"`
+	ProofSetName      string     `json:"proofSetName,omitempty"`
+	ProvingHealthy    *bool      `json:"provingHealthy,omitempty"`
 	RootID            *string    `json:"rootId,omitempty"`
+	RootStatus        string     `json:"rootStatus"`
+	RootMissing       bool       `json:"missingOnService"`
+	Checksum          *string    `json:"checksum,omitempty"`
+	DownloadCount     int64      `json:"downloadCount"`
+	LastDownloaded    *time.Time `json:"lastDownloadedAt,omitempty"`
+	RetainUntil       *time.Time `json:"retainUntil,omitempty"`
+	ContentType       string     `json:"contentType,omitempty"`
+	Tags              []string   `json:"tags"`
+	CollectionID      *uint      `json:"collectionId,omitempty"`
 	CreatedAt         time.Time  `json:"createdAt"`
 	UpdatedAt         time.Time  `json:"updatedAt"`
+	// Metadata holds the string fields the owner attached to the file.
+	Metadata models.Metadata `json:"metadata,omitempty"`
+}
+
+// pieceStoredSize is the number of bytes a piece takes on the service, which
+// differs from its logical size when it was compressed or padded.
+func pieceStoredSize(piece models.Piece) int64 {
+	switch {
+	case piece.StoredSize > 0:
+		return piece.StoredSize
+	case piece.PaddedSize > 0:
+		return piece.PaddedSize
+	default:
+		return piece.Size
+	}
 }
 
 type ProofSetsResponse struct {
@@ -31,23 +70,108 @@ type ProofSetsResponse struct {
 	Pieces    []PieceResponse      `json:"pieces"`
 }
 
+// ProofSetWithPieces is a proof set with the IDs of its pieces
+// @Description A proof set, the IDs of its pieces and aggregates over them. pieceCount, totalBytes and the piece timestamps leave out files pending removal, whose bytes are given by pendingRemovalBytes; unconfirmedRoots counts files whose root ID isn't confirmed yet. provingHealth is healthy, missed when a challenge passed without a proof, or unknown when that couldn't be told, such as while the service or chain couldn't be reached; provingHealthy is false when missed and null when unknown.
 type ProofSetWithPieces struct {
 	ID              uint      `json:"id"`
 	ProofSetID      string    `json:"proofSetId"`
+	Name            string    `json:"name"`
+	Description     string    `json:"description"`
 	TransactionHash string    `json:"transactionHash"`
 	ServiceName     string    `json:"serviceName"`
 	ServiceURL      string    `json:"serviceUrl"`
+	Status          string    `json:"status"`
+	FailureReason   string    `json:"failureReason,omitempty"`
+	ProvingHealth   string    `json:"provingHealth"`
+	ProvingHealthy  *bool     `json:"provingHealthy"`
 	PieceIDs        []uint    `json:"pieceIds"`
 	CreatedAt       time.Time `json:"createdAt"`
 	UpdatedAt       time.Time `json:"updatedAt"`
+	ProofSetAggregates
+}
+
+// ProofSetAggregates sums up the pieces of a proof set.
+type ProofSetAggregates struct {
+	PieceCount          int64      `json:"pieceCount"`
+	TotalBytes          int64      `json:"totalBytes"`
+	PendingRemovalBytes int64      `json:"pendingRemovalBytes"`
+	UnconfirmedRoots    int64      `json:"unconfirmedRoots"`
+	OldestPieceAt       *time.Time `json:"oldestPieceAt,omitempty"`
+	NewestPieceAt       *time.Time `json:"newestPieceAt,omitempty"`
+}
+
+// proofSetAggregates computes the aggregates of each of proofSetIDs with one
+// grouped query. Proof sets without pieces are left out of the result.
+func proofSetAggregates(proofSetIDs []uint) (map[uint]ProofSetAggregates, error) {
+	aggregates := make(map[uint]ProofSetAggregates, len(proofSetIDs))
+	if len(proofSetIDs) == 0 {
+		return aggregates, nil
+	}
+	var rows []struct {
+		ProofSetID          uint
+		PieceCount          int64
+		TotalBytes          int64
+		PendingRemovalBytes int64
+		UnconfirmedRoots    int64
+		OldestPieceAt       *time.Time
+		NewestPieceAt       *time.Time
+	}
+	if err := db.Model(&models.Piece{}).
+		Select(`proof_set_id,
+			COUNT(*) FILTER (WHERE NOT pending_removal) AS piece_count,
+			COALESCE(SUM(size) FILTER (WHERE NOT pending_removal), 0) AS total_bytes,
+			COALESCE(SUM(size) FILTER (WHERE pending_removal), 0) AS pending_removal_bytes,
+			COUNT(*) FILTER (WHERE root_status IN ?) AS unconfirmed_roots,
+			MIN(created_at) FILTER (WHERE NOT pending_removal) AS oldest_piece_at,
+			MAX(created_at) FILTER (WHERE NOT pending_removal) AS newest_piece_at`, []string{rootStatusPending, rootStatusUnconfirmed}).
+		Where("proof_set_id IN ?", proofSetIDs).
+		Group("proof_set_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		aggregates[row.ProofSetID] = ProofSetAggregates{
+			PieceCount:          row.PieceCount,
+			TotalBytes:          row.TotalBytes,
+			PendingRemovalBytes: row.PendingRemovalBytes,
+			UnconfirmedRoots:    row.UnconfirmedRoots,
+			OldestPieceAt:       row.OldestPieceAt,
+			NewestPieceAt:       row.NewestPieceAt,
+		}
+	}
+	return aggregates, nil
 }
 
-// GetUserPieces returns all pieces for the authenticated user
+// GetUserPieces returns a page of the authenticated user's pieces
 // @Summary Get user's pieces
-// @Description Get all pieces uploaded by the authenticated user, including service proof set ID
+// @Description Get a page of the pieces uploaded by the authenticated user, including service proof set ID. Versioned files are listed by their current version only unless versions=all. Filters combine, and total counts the files matching all of them. Pages are chosen either by page number or by passing the previous page's nextCursor as after; cursors stay stable as files are added.
 // @Tags pieces
+// @Param versions query string false "Set to all to list every version of versioned files"
+// @Param page query int false "Page number, starting at 1; ignored when after is set" default(1)
+// @Param pageSize query int false "Number of pieces per page (max 200)" default(50)
+// @Param after query string false "nextCursor from the previous page"
+// @Param sortBy query string false "createdAt, size, filename or lastDownloaded" default(createdAt)
+// @Param order query string false "asc or desc" default(desc)
+// @Param filename query string false "Only files whose name contains this, ignoring case"
+// @Param pendingRemoval query bool false "Only files that are, or aren't, pending removal"
+// @Param pinned query bool false "Only files that are, or aren't, pinned"
+// @Param createdAfter query string false "Only files uploaded at or after this RFC 3339 time or YYYY-MM-DD date"
+// @Param createdBefore query string false "Only files uploaded before this RFC 3339 time or YYYY-MM-DD date"
+// @Param expiresBefore query string false "Only files with a retention ending before this RFC 3339 time or YYYY-MM-DD date, to find those expiring soon"
+// @Param minSize query int false "Only files of at least this many bytes"
+// @Param maxSize query int false "Only files of at most this many bytes"
+// @Param proofSetId query int false "Only files in the proof set with this proofSetDbId"
+// @Param tags query string false "Comma-separated tags; only files with any of them, or all of them when tagMode=all"
+// @Param tagMode query string false "any or all" default(any)
+// @Param collectionId query string false "Only files directly in this collection, or none for files outside any collection"
+// @Param metadata.{key} query string false "Only files whose metadata field key has this value, e.g. metadata.project=apollo; several such filters must all match"
+// @Param shared query bool false "Also list, under shared, the most recent files other wallets have shared with the caller"
+// @Param If-None-Match header string false "ETag of a page the client already has"
 // @Produce json
-// @Success 200 {array} PieceResponse
+// @Success 200 {object} PieceListResponse
+// @Success 304 "Nothing on the page has changed since the ETag given in If-None-Match"
+// @Failure 400 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
 // @Router /api/v1/pieces [get]
 func GetUserPieces(c *gin.Context) {
 	userID, exists := c.Get("userID")
@@ -58,8 +182,107 @@ func GetUserPieces(c *gin.Context) {
 		return
 	}
 
+	listPieces(c, userID, nil)
+}
+
+// listPieces responds with a page of the user's pieces as GetUserPieces
+// describes, narrowed by scope when it is set.
+func listPieces(c *gin.Context, userID interface{}, scope func(*gorm.DB) *gorm.DB) {
+	allVersions := c.Query("versions") == "all"
+
+	sortBy := c.DefaultQuery("sortBy", "createdAt")
+	column, ok := pieceSortColumns[sortBy]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "sortBy must be 'createdAt', 'size', 'filename' or 'lastDownloaded'",
+		})
+		return
+	}
+	order := c.DefaultQuery("order", "desc")
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "order must be 'asc' or 'desc'",
+		})
+		return
+	}
+
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", strconv.Itoa(defaultPiecesPageSize)))
+	if err != nil || pageSize <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "pageSize must be a positive integer",
+		})
+		return
+	}
+	if pageSize > maxPiecesPageSize {
+		pageSize = maxPiecesPageSize
+	}
+
+	after := c.Query("after")
+	page := 0
+	if after == "" {
+		page, err = strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "page must be a positive integer",
+			})
+			return
+		}
+	}
+
+	query := db.Model(&models.Piece{}).Where("user_id = ?", userID)
+	if !allVersions {
+		query = query.Where("is_current = ?", true)
+	}
+	if scope != nil {
+		query = scope(query)
+	}
+	query, err = filterPieces(c, query)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// The page is only built when something it depends on has changed
+	// since the client's copy.
+	etag, err := pieceListETag(c, userID)
+	if err != nil {
+		log.WithField("error", err.Error()).Error("Failed to compute pieces ETag")
+	} else if notModified(c, etag) {
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to count user pieces")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to fetch pieces",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	pageQuery := query.Session(&gorm.Session{})
+	if after != "" {
+		pageQuery, err = afterPieceCursor(pageQuery, after, sortBy, order)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "after is not a cursor for this sort order",
+			})
+			return
+		}
+	} else {
+		pageQuery = pageQuery.Offset((page - 1) * pageSize)
+	}
+
+	// One extra row tells whether another page follows.
 	var pieces []models.Piece
-	if err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&pieces).Error; err != nil {
+	if err := pageQuery.
+		Order(column + " " + order).
+		Order("id " + order).
+		Limit(pageSize + 1).
+		Find(&pieces).Error; err != nil {
 		log.WithField("error", err.Error()).Error("Failed to fetch user pieces")
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to fetch pieces",
@@ -68,6 +291,39 @@ func GetUserPieces(c *gin.Context) {
 		return
 	}
 
+	var nextCursor string
+	if len(pieces) > pageSize {
+		pieces = pieces[:pageSize]
+		nextCursor = encodePieceCursor(pieces[len(pieces)-1], sortBy, order)
+	}
+
+	response := PieceListResponse{
+		Pieces:     pieceResponses(pieces, userID, !allVersions),
+		Total:      total,
+		Page:       page,
+		PageSize:   pageSize,
+		SortBy:     sortBy,
+		Order:      order,
+		NextCursor: nextCursor,
+	}
+	if scope == nil && c.Query("shared") == "true" {
+		response.Shared, err = sharedPieces(userID)
+		if err != nil {
+			log.WithField("error", err.Error()).Error("Failed to fetch shared pieces")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Failed to fetch pieces",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// pieceResponses builds the list entries for pieces, looking up their proof
+// sets and tags with one query each and, when withVersionCounts is set, how
+// many versions each versioned file has.
+func pieceResponses(pieces []models.Piece, userID interface{}, withVersionCounts bool) []PieceResponse {
 	proofSetIDs := make([]uint, 0, len(pieces))
 	for _, piece := range pieces {
 		if piece.ProofSetID != nil {
@@ -87,7 +343,40 @@ func GetUserPieces(c *gin.Context) {
 		}
 	}
 
-	responsePieces := make([]PieceResponse, 0, len(pieces))
+	pieceIDs := make([]uint, len(pieces))
+	for i, piece := range pieces {
+		pieceIDs[i] = piece.ID
+	}
+	tagNames, err := pieceTagNames(pieceIDs)
+	if err != nil {
+		log.WithField("error", err.Error()).Error("Failed to fetch piece tags")
+	}
+
+	versionCounts := make(map[string]int)
+	fileGroupIDs := make([]string, 0, len(pieces))
+	for _, piece := range pieces {
+		if piece.FileGroupID != "" {
+			fileGroupIDs = append(fileGroupIDs, piece.FileGroupID)
+		}
+	}
+	if withVersionCounts && len(fileGroupIDs) > 0 {
+		var counts []struct {
+			FileGroupID string
+			Count       int
+		}
+		if err := db.Model(&models.Piece{}).
+			Select("file_group_id, COUNT(*) AS count").
+			Where("user_id = ? AND file_group_id IN ?", userID, fileGroupIDs).
+			Group("file_group_id").
+			Scan(&counts).Error; err != nil {
+			log.WithField("error", err.Error()).Error("Failed to count file versions")
+		}
+		for _, count := range counts {
+			versionCounts[count.FileGroupID] = count.Count
+		}
+	}
+
+	responses := make([]PieceResponse, 0, len(pieces))
 	for _, piece := range pieces {
 		var pendingRemovalPtr *bool
 		if piece.PendingRemoval {
@@ -99,16 +388,34 @@ func GetUserPieces(c *gin.Context) {
 			ID:             piece.ID,
 			UserID:         piece.UserID,
 			CID:            piece.CID,
+			BaseCID:        baseCID(piece.CID),
+			SubrootCID:     subrootCID(piece.CID),
 			Filename:       piece.Filename,
 			Size:           piece.Size,
+			StoredSize:     pieceStoredSize(piece),
+			Compressed:     piece.Compressed,
+			FileGroupID:    piece.FileGroupID,
+			Version:        piece.Version,
+			IsCurrent:      piece.IsCurrent,
+			Public:         piece.Public,
+			Pinned:         piece.Pinned,
 			ServiceName:    piece.ServiceName,
 			ServiceURL:     piece.ServiceURL,
 			PendingRemoval: pendingRemovalPtr,
 			RemovalDate:    piece.RemovalDate,
 			ProofSetDbID:   piece.ProofSetID,
 			RootID:         piece.RootID,
+			RootStatus:     piece.RootStatus,
+			RootMissing:    piece.RootMissing,
+			Checksum:       piece.Checksum,
+			DownloadCount:  piece.DownloadCount,
+			LastDownloaded: piece.LastDownloaded,
+			RetainUntil:    piece.RetainUntil,
+			ContentType:    piece.ContentType,
+			CollectionID:   piece.CollectionID,
 			CreatedAt:      piece.CreatedAt,
 			UpdatedAt:      piece.UpdatedAt,
+			Metadata:       piece.Metadata,
 		}
 		if piece.ProofSetID != nil {
 			if proofSet, ok := proofSetMap[*piece.ProofSetID]; ok {
@@ -116,21 +423,31 @@ func GetUserPieces(c *gin.Context) {
 					serviceID := proofSet.ProofSetID
 					respPiece.ServiceProofSetID = &serviceID
 				}
+				respPiece.ProofSetName = proofSet.Name
+				respPiece.ProvingHealthy = provingHealthFlag(proofSet.ProvingHealth)
 			}
 		}
-		responsePieces = append(responsePieces, respPiece)
+		if piece.FileGroupID != "" {
+			respPiece.VersionCount = versionCounts[piece.FileGroupID]
+		}
+		respPiece.Tags = tagNames[piece.ID]
+		if respPiece.Tags == nil {
+			respPiece.Tags = []string{}
+		}
+		responses = append(responses, respPiece)
 	}
-
-	c.JSON(http.StatusOK, responsePieces)
+	return responses
 }
 
 // GetPieceByID returns a specific piece by ID
 // @Summary Get piece by ID
-// @Description Get a specific piece by its ID
+// @Description Get a specific piece by its ID, including pieces shared with the caller
 // @Tags pieces
 // @Param id path string true "Piece ID"
+// @Param If-None-Match header string false "ETag of a copy the client already has"
 // @Produce json
 // @Success 200 {object} models.Piece
+// @Success 304 "The piece matches the ETag given in If-None-Match"
 // @Router /api/v1/pieces/{id} [get]
 func GetPieceByID(c *gin.Context) {
 	userID, exists := c.Get("userID")
@@ -144,7 +461,7 @@ func GetPieceByID(c *gin.Context) {
 	pieceID := c.Param("id")
 	var piece models.Piece
 
-	if err := db.Where("id = ? AND user_id = ?", pieceID, userID).First(&piece).Error; err != nil {
+	if err := accessibleTo(db.Where("id = ?", pieceID), userID).First(&piece).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{
 				"error": "Piece not found",
@@ -159,16 +476,20 @@ func GetPieceByID(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, piece)
+	jsonWithETag(c, piece)
 }
 
 // GetPieceByCID returns a specific piece by CID
 // @Summary Get piece by CID
-// @Description Get a specific piece by its CID
+// @Description Get a specific piece by its CID. The CID may be the compound base:subroot CID the piece is stored under, or just its base CID. The caller's own piece is returned when they have one, otherwise one shared with them.
 // @Tags pieces
-// @Param cid path string true "Piece CID"
+// @Param cid path string true "Piece CID, compound or base"
+// @Param If-None-Match header string false "ETag of a copy the client already has"
 // @Produce json
 // @Success 200 {object} models.Piece
+// @Success 304 "The piece matches the ETag given in If-None-Match"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
 // @Router /api/v1/pieces/cid/{cid} [get]
 func GetPieceByCID(c *gin.Context) {
 	userID, exists := c.Get("userID")
@@ -180,9 +501,15 @@ func GetPieceByCID(c *gin.Context) {
 	}
 
 	cid := c.Param("cid")
-	var piece models.Piece
+	if !pieceCIDFormat.MatchString(cid) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid CID",
+		})
+		return
+	}
 
-	if err := db.Where("cid = ? AND user_id = ?", cid, userID).First(&piece).Error; err != nil {
+	piece, err := findPieceByCID(cid, userID)
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{
 				"error": "Piece not found",
@@ -197,12 +524,12 @@ func GetPieceByCID(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, piece)
+	jsonWithETag(c, piece)
 }
 
 // GetProofSets returns all proof sets and associated pieces for the authenticated user
 // @Summary Get user's proof sets
-// @Description Get all proof sets and their pieces for the authenticated user
+// @Description Get all proof sets and their pieces for the authenticated user, including a proof set that is still being created or whose creation failed. Each proof set's status is pending, submitting, confirming, ready, failed (with a failureReason) or decommissioning. Each proof set also carries aggregates over its pieces: pieceCount, totalBytes, pendingRemovalBytes, unconfirmedRoots and the oldest and newest piece's upload time, and its provingHealth and provingHealthy. Files carry their proof set's provingHealthy, left out when it is unknown.
 // @Tags pieces
 // @Produce json
 // @Success 200 {object} ProofSetsResponse
@@ -233,16 +560,8 @@ func GetProofSets(c *gin.Context) {
 		}
 	}
 
-	if len(proofSetIDs) == 0 {
-		c.JSON(http.StatusOK, ProofSetsResponse{
-			ProofSets: []ProofSetWithPieces{},
-			Pieces:    []PieceResponse{},
-		})
-		return
-	}
-
 	var proofSets []models.ProofSet
-	if err := db.Where("id IN ?", proofSetIDs).Find(&proofSets).Error; err != nil {
+	if err := db.Where("user_id = ? OR id IN ?", userID, proofSetIDs).Find(&proofSets).Error; err != nil {
 		log.WithField("error", err.Error()).Error("Failed to fetch proof sets")
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to fetch proof sets",
@@ -252,23 +571,47 @@ func GetProofSets(c *gin.Context) {
 	}
 
 	piecesByProofSetID := make(map[uint][]uint)
+	for _, ps := range proofSets {
+		piecesByProofSetID[ps.ID] = []uint{}
+	}
 	for _, piece := range pieces {
 		if piece.ProofSetID != nil {
 			piecesByProofSetID[*piece.ProofSetID] = append(piecesByProofSetID[*piece.ProofSetID], piece.ID)
 		}
 	}
 
+	ids := make([]uint, len(proofSets))
+	for i, ps := range proofSets {
+		ids[i] = ps.ID
+	}
+	aggregates, err := proofSetAggregates(ids)
+	if err != nil {
+		log.WithField("error", err.Error()).Error("Failed to aggregate proof set pieces")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to fetch proof sets",
+			"details": err.Error(),
+		})
+		return
+	}
+
 	proofSetResponses := make([]ProofSetWithPieces, 0, len(proofSets))
 	for _, ps := range proofSets {
 		proofSetResponse := ProofSetWithPieces{
-			ID:              ps.ID,
-			ProofSetID:      ps.ProofSetID,
-			TransactionHash: ps.TransactionHash,
-			ServiceName:     ps.ServiceName,
-			ServiceURL:      ps.ServiceURL,
-			PieceIDs:        piecesByProofSetID[ps.ID],
-			CreatedAt:       ps.CreatedAt,
-			UpdatedAt:       ps.UpdatedAt,
+			ID:                 ps.ID,
+			ProofSetID:         ps.ProofSetID,
+			Name:               ps.Name,
+			Description:        ps.Description,
+			TransactionHash:    ps.TransactionHash,
+			ServiceName:        ps.ServiceName,
+			ServiceURL:         ps.ServiceURL,
+			Status:             ps.Status,
+			FailureReason:      ps.FailureReason,
+			ProvingHealth:      ps.ProvingHealth,
+			ProvingHealthy:     provingHealthFlag(ps.ProvingHealth),
+			PieceIDs:           piecesByProofSetID[ps.ID],
+			CreatedAt:          ps.CreatedAt,
+			UpdatedAt:          ps.UpdatedAt,
+			ProofSetAggregates: aggregates[ps.ID],
 		}
 		proofSetResponses = append(proofSetResponses, proofSetResponse)
 	}
@@ -285,14 +628,30 @@ func GetProofSets(c *gin.Context) {
 			ID:             piece.ID,
 			UserID:         piece.UserID,
 			CID:            piece.CID,
+			BaseCID:        baseCID(piece.CID),
+			SubrootCID:     subrootCID(piece.CID),
 			Filename:       piece.Filename,
 			Size:           piece.Size,
+			StoredSize:     pieceStoredSize(piece),
+			Compressed:     piece.Compressed,
+			FileGroupID:    piece.FileGroupID,
+			Version:        piece.Version,
+			IsCurrent:      piece.IsCurrent,
+			Public:         piece.Public,
+			Pinned:         piece.Pinned,
 			ServiceName:    piece.ServiceName,
 			ServiceURL:     piece.ServiceURL,
 			PendingRemoval: pendingRemovalPtr,
 			RemovalDate:    piece.RemovalDate,
 			ProofSetDbID:   piece.ProofSetID,
 			RootID:         piece.RootID,
+			RootStatus:     piece.RootStatus,
+			RootMissing:    piece.RootMissing,
+			Checksum:       piece.Checksum,
+			DownloadCount:  piece.DownloadCount,
+			LastDownloaded: piece.LastDownloaded,
+			RetainUntil:    piece.RetainUntil,
+			ContentType:    piece.ContentType,
 			CreatedAt:      piece.CreatedAt,
 			UpdatedAt:      piece.UpdatedAt,
 		}
@@ -301,6 +660,7 @@ func GetProofSets(c *gin.Context) {
 			if piece.ProofSetID != nil && *piece.ProofSetID == ps.ID {
 				serviceID := ps.ProofSetID
 				respPiece.ServiceProofSetID = &serviceID
+				respPiece.ProofSetName = ps.Name
 				break
 			}
 		}
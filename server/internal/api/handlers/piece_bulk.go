@@ -0,0 +1,489 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Actions a bulk request may take.
+const (
+	bulkActionRemove = "remove"
+	bulkActionTag    = "tag"
+	bulkActionMove   = "move"
+)
+
+// bulkJobPrefix starts the ID of every bulk removal job, setting them apart
+// from upload and archive jobs.
+const bulkJobPrefix = "bulk-"
+
+// BulkPieceParams holds the parameters of a bulk action: tags for "tag" and
+// collectionId, which may be null, for "move".
+type BulkPieceParams struct {
+	Tags         []string   `json:"tags"`
+	CollectionID optionalID `json:"collectionId" swaggertype:"integer"`
+}
+
+// BulkPieceRequest is the body of a request to act on several pieces.
+type BulkPieceRequest struct {
+	Action   string          `json:"action" binding:"required" example:"remove"`
+	PieceIDs []uint          `json:"pieceIds" binding:"required"`
+	Params   BulkPieceParams `json:"params"`
+}
+
+// BulkItemResult is the outcome of a bulk action on one piece.
+type BulkItemResult struct {
+	PieceID     uint       `json:"pieceId"`
+	Status      string     `json:"status" example:"succeeded"`
+	Error       string     `json:"error,omitempty"`
+	RemovalDate *time.Time `json:"removalDate,omitempty"`
+}
+
+// BulkPieceResponse reports the outcome of a bulk action on each piece.
+// @Description Per-piece results of a bulk action. Some pieces may fail while others succeed. A removal run as a background job has a jobId and status, and its results fill in as it goes.
+type BulkPieceResponse struct {
+	Action    string           `json:"action"`
+	JobID     string           `json:"jobId,omitempty"`
+	StatusURL string           `json:"statusUrl,omitempty"`
+	Status    string           `json:"status,omitempty"`
+	Results   []BulkItemResult `json:"results"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+}
+
+func (r *BulkPieceResponse) add(result BulkItemResult) {
+	r.Results = append(r.Results, result)
+	switch result.Status {
+	case stageSucceeded, stageSkipped:
+		r.Succeeded++
+	case stageFailed:
+		r.Failed++
+	}
+}
+
+// @Summary Act on several pieces at once
+// @Description Remove, tag or move several of the caller's files in one request. Every ID must belong to the caller or nothing is done, and a removal including pinned files is refused with 409. Each file is then handled on its own, so the response may mix successes and failures. Removals of more files with roots than the configured limit run as a background job and 202 is returned; poll its statusUrl for results.
+// @Tags pieces
+// @Accept json
+// @Param request body BulkPieceRequest true "Action, piece IDs and parameters"
+// @Produce json
+// @Success 200 {object} BulkPieceResponse
+// @Success 202 {object} BulkPieceResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /api/v1/pieces/bulk [post]
+func BulkUpdatePieces(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var request BulkPieceRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+	switch request.Action {
+	case bulkActionRemove, bulkActionTag, bulkActionMove:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "action must be 'remove', 'tag' or 'move'",
+		})
+		return
+	}
+
+	ids := make([]uint, 0, len(request.PieceIDs))
+	seen := make(map[uint]bool, len(request.PieceIDs))
+	for _, id := range request.PieceIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "pieceIds must not be empty",
+		})
+		return
+	}
+	if len(ids) > cfg.Upload.BulkMaxItems {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":    fmt.Sprintf("A bulk request may act on at most %d files", cfg.Upload.BulkMaxItems),
+			"maxItems": cfg.Upload.BulkMaxItems,
+		})
+		return
+	}
+
+	var tags []string
+	switch request.Action {
+	case bulkActionTag:
+		var err error
+		tags, err = normalizeTags(request.Params.Tags)
+		if err == nil && len(tags) == 0 {
+			err = errors.New("params.tags must not be empty")
+		}
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	case bulkActionMove:
+		if !request.Params.CollectionID.Set {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": "params.collectionId is required, null moves files out of their collection",
+			})
+			return
+		}
+		if request.Params.CollectionID.Value != nil {
+			if _, err := findCollection(db, userID, *request.Params.CollectionID.Value); err != nil {
+				respondCollectionError(c, err)
+				return
+			}
+		}
+	}
+
+	var pieces []models.Piece
+	if err := db.Where("id IN ? AND user_id = ?", ids, userID).Find(&pieces).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to fetch pieces")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch pieces",
+		})
+		return
+	}
+	if len(pieces) != len(ids) {
+		found := make(map[uint]bool, len(pieces))
+		for _, piece := range pieces {
+			found[piece.ID] = true
+		}
+		missing := []uint{}
+		for _, id := range ids {
+			if !found[id] {
+				missing = append(missing, id)
+			}
+		}
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":      "Some pieces were not found",
+			"missingIds": missing,
+		})
+		return
+	}
+	// Act in the order the IDs were given.
+	byID := make(map[uint]models.Piece, len(pieces))
+	for _, piece := range pieces {
+		byID[piece.ID] = piece
+	}
+	for i, id := range ids {
+		pieces[i] = byID[id]
+	}
+
+	response := BulkPieceResponse{Action: request.Action, Results: []BulkItemResult{}}
+	switch request.Action {
+	case bulkActionTag:
+		for _, piece := range pieces {
+			response.add(bulkTagPiece(piece, tags))
+		}
+	case bulkActionMove:
+		moved := make(map[string]bool)
+		for _, piece := range pieces {
+			response.add(bulkMovePiece(piece, request.Params.CollectionID.Value, moved))
+		}
+	case bulkActionRemove:
+		pinned := []uint{}
+		for _, piece := range pieces {
+			if piece.Pinned {
+				pinned = append(pinned, piece.ID)
+			}
+		}
+		if len(pinned) > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":     "Some files are pinned. Unpin them before removing them.",
+				"pinnedIds": pinned,
+			})
+			return
+		}
+
+		withRoots := 0
+		for _, piece := range pieces {
+			if hasRemovableRoot(piece) {
+				withRoots++
+			}
+		}
+		if withRoots > cfg.Upload.BulkSyncRemovals {
+			jobID := startBulkRemoval(userID.(uint), pieces)
+			c.JSON(http.StatusAccepted, BulkPieceResponse{
+				Action:    bulkActionRemove,
+				JobID:     jobID,
+				StatusURL: "/api/v1/pieces/bulk/" + jobID,
+				Status:    "removing",
+				Results:   []BulkItemResult{},
+			})
+			return
+		}
+		for _, result := range removePieces(c.Request.Context(), pieces, nil) {
+			response.add(result)
+		}
+	}
+
+	log.WithField("userID", userID).
+		WithField("action", request.Action).
+		WithField("succeeded", response.Succeeded).
+		WithField("failed", response.Failed).
+		Info("Ran bulk piece action")
+	c.JSON(http.StatusOK, response)
+}
+
+// bulkTagPiece adds tags to one piece of a bulk request.
+func bulkTagPiece(piece models.Piece, tags []string) BulkItemResult {
+	result := BulkItemResult{PieceID: piece.ID, Status: stageSucceeded}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return addPieceTagsTx(tx, piece, tags)
+	})
+	if errors.Is(err, errTooManyTags) {
+		result.Status = stageFailed
+		result.Error = fmt.Sprintf("A file may have at most %d tags", maxTagsPerPiece)
+	} else if err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to update piece tags")
+		result.Status = stageFailed
+		result.Error = "Failed to update tags"
+	}
+	return result
+}
+
+// bulkMovePiece moves one piece of a bulk request. Files whose versions
+// have already been moved by an earlier piece, recorded in moved, are
+// skipped.
+func bulkMovePiece(piece models.Piece, collectionID *uint, moved map[string]bool) BulkItemResult {
+	result := BulkItemResult{PieceID: piece.ID, Status: stageSucceeded}
+	if sameID(collectionID, piece.CollectionID) || (piece.FileGroupID != "" && moved[piece.FileGroupID]) {
+		result.Status = stageSkipped
+		return result
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return movePieceTx(tx, piece, collectionID)
+	})
+	if err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to move piece")
+		result.Status = stageFailed
+		result.Error = "Failed to move file"
+		return result
+	}
+	if piece.FileGroupID != "" {
+		moved[piece.FileGroupID] = true
+	}
+	return result
+}
+
+// removePieces deletes pieces as DeletePiece does, calling onResult, when
+// set, as each is done. Pieces with roots are grouped by proof set, so
+// pdptool is prepared and each proof set looked up once however many roots
+// are removed from it.
+func removePieces(ctx context.Context, pieces []models.Piece, onResult func(BulkItemResult)) []BulkItemResult {
+	results := make([]BulkItemResult, 0, len(pieces))
+	report := func(result BulkItemResult) {
+		results = append(results, result)
+		if onResult != nil {
+			onResult(result)
+		}
+	}
+	failAll := func(group []models.Piece, err error) {
+		for _, piece := range group {
+			report(BulkItemResult{PieceID: piece.ID, Status: stageFailed, Error: err.Error()})
+		}
+	}
+
+	var proofSetIDs []uint
+	byProofSet := make(map[uint][]models.Piece)
+	var noProofSet []models.Piece
+	for _, piece := range pieces {
+		switch {
+		case !hasRemovableRoot(piece):
+			// Nothing to run pdptool for.
+			deleted, status := deletePiece(ctx, piece)
+			result := BulkItemResult{PieceID: piece.ID, Status: stageSucceeded, RemovalDate: deleted.RemovalDate}
+			if status != http.StatusOK {
+				result.Status = stageFailed
+				result.Error = deleted.Error
+			}
+			report(result)
+		case piece.ProofSetID == nil:
+			noProofSet = append(noProofSet, piece)
+		default:
+			if _, ok := byProofSet[*piece.ProofSetID]; !ok {
+				proofSetIDs = append(proofSetIDs, *piece.ProofSetID)
+			}
+			byProofSet[*piece.ProofSetID] = append(byProofSet[*piece.ProofSetID], piece)
+		}
+	}
+	failAll(noProofSet, errors.New("The file has no proof set"))
+	if len(proofSetIDs) == 0 {
+		return results
+	}
+
+	pdptoolPath, err := preparePdptool(ctx)
+	if err != nil {
+		for _, id := range proofSetIDs {
+			failAll(byProofSet[id], err)
+		}
+		return results
+	}
+
+	for _, id := range proofSetIDs {
+		group := byProofSet[id]
+		proofSet, err := pieceProofSet(group[0])
+		if err != nil {
+			failAll(group, err)
+			continue
+		}
+		removeProofSetRoots(ctx, pdptoolPath, proofSet, group, report)
+	}
+	return results
+}
+
+// removeProofSetRoots removes the roots of group, pieces of proofSet, and
+// then marks the pieces whose root was removed pending removal in one
+// transaction. pdptool removes one root per call, so it is run for each.
+func removeProofSetRoots(ctx context.Context, pdptoolPath string, proofSet models.ProofSet, group []models.Piece, report func(BulkItemResult)) {
+	var removed []models.Piece
+	for i, piece := range group {
+		if ctx.Err() != nil {
+			for _, piece := range group[i:] {
+				report(BulkItemResult{PieceID: piece.ID, Status: stageFailed, Error: "Removal was cancelled"})
+			}
+			break
+		}
+		logRemovalRequested(piece)
+		if _, removeErr := runRemoveRoots(pdptoolPath, piece.ServiceURL, piece.ServiceName, proofSet.ProofSetID, *piece.RootID); removeErr != nil {
+			report(BulkItemResult{PieceID: piece.ID, Status: stageFailed, Error: "Failed to remove root: " + removeErr.stderr})
+			continue
+		}
+		removed = append(removed, piece)
+	}
+	if len(removed) == 0 {
+		return
+	}
+
+	removalDate, err := markPendingRemovals(removed)
+	for _, piece := range removed {
+		if err != nil {
+			report(BulkItemResult{PieceID: piece.ID, Status: stageFailed, Error: "Root removed but the file could not be marked pending removal"})
+			continue
+		}
+		report(BulkItemResult{PieceID: piece.ID, Status: stageSucceeded, RemovalDate: &removalDate})
+	}
+}
+
+// startBulkRemoval removes pieces in a background job, tracked like an
+// upload job with one file entry per piece, and returns the job's ID.
+func startBulkRemoval(userID uint, pieces []models.Piece) string {
+	jobID := bulkJobPrefix + uuid.New().String()
+	files := make([]FileProgress, len(pieces))
+	index := make(map[uint]int, len(pieces))
+	for i, piece := range pieces {
+		files[i] = FileProgress{Filename: piece.Filename, Size: piece.Size, Status: "pending", PieceID: piece.ID}
+		index[piece.ID] = i
+	}
+	createUploadJob(jobID, userID, UploadProgress{
+		Status:  "removing",
+		Message: fmt.Sprintf("Removing %d files...", len(pieces)),
+		Files:   files,
+	})
+
+	go func() {
+		ctx := jobContext(jobID)
+		done, failed := 0, 0
+		removePieces(ctx, pieces, func(result BulkItemResult) {
+			done++
+			if result.Status == stageFailed {
+				failed++
+			}
+			file := &files[index[result.PieceID]]
+			file.Status = result.Status
+			file.Error = result.Error
+			file.Progress = 100
+			updateJobStatus(jobID, UploadProgress{
+				Status:   "removing",
+				Progress: done * 100 / len(pieces),
+				Message:  fmt.Sprintf("Removed %d of %d files", done, len(pieces)),
+				Files:    append([]FileProgress(nil), files...),
+			})
+		})
+
+		progress := UploadProgress{
+			Status:   "complete",
+			Progress: 100,
+			Message:  fmt.Sprintf("Removed %d files", len(pieces)),
+		}
+		switch {
+		case failed == len(pieces):
+			progress.Status = "error"
+			progress.Message = fmt.Sprintf("None of the %d files could be removed", len(pieces))
+			progress.Error = "all removals failed"
+		case failed > 0:
+			progress.Status = "partial"
+			progress.Message = fmt.Sprintf("Removed %d files, %d could not be removed", len(pieces)-failed, failed)
+		}
+		updateJobStatus(jobID, progress)
+		log.WithField("jobID", jobID).
+			WithField("pieces", len(pieces)).
+			WithField("failed", failed).
+			Info("Finished bulk removal")
+	}()
+
+	return jobID
+}
+
+// @Summary Get a bulk removal job
+// @Description Get the status of a bulk removal or batch root removal running in the background and the result for each file so far. Files not yet handled have status pending.
+// @Tags pieces
+// @Param jobId path string true "Bulk job ID"
+// @Produce json
+// @Success 200 {object} BulkPieceResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/pieces/bulk/{jobId} [get]
+// @Router /api/v1/roots/remove/batch/{jobId} [get]
+func GetBulkJob(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	jobID := c.Param("jobId")
+	progress, ownerID, ok := getUploadJob(jobID)
+	if !strings.HasPrefix(jobID, bulkJobPrefix) || !ok || ownerID != userID.(uint) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Bulk job not found",
+		})
+		return
+	}
+
+	response := BulkPieceResponse{
+		Action:  bulkActionRemove,
+		JobID:   jobID,
+		Status:  progress.Status,
+		Results: make([]BulkItemResult, 0, len(progress.Files)),
+	}
+	for _, file := range progress.Files {
+		response.add(BulkItemResult{PieceID: file.PieceID, Status: file.Status, Error: file.Error})
+	}
+	c.JSON(http.StatusOK, response)
+}
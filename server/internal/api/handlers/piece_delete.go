@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Stages of deleting a piece, as reported by DeletePiece.
+const (
+	deleteStageCancelRootTask = "cancelRootTask"
+	deleteStageRemoveRoot     = "removeRoot"
+	deleteStageMarkRemoval    = "markPendingRemoval"
+	deleteStageDeletePiece    = "deletePiece"
+)
+
+// Outcomes of a deletion stage.
+const (
+	stageSucceeded = "succeeded"
+	stageFailed    = "failed"
+	stageSkipped   = "skipped"
+)
+
+// DeletionStage is the outcome of one step of deleting a piece.
+type DeletionStage struct {
+	Stage  string `json:"stage"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// DeletePieceResponse reports each step of deleting a piece, so a failure
+// part way through shows what was already done.
+// @Description Steps taken to delete a piece. RemovalDate is set once its root has been removed and it is pending removal.
+type DeletePieceResponse struct {
+	PieceID     uint            `json:"pieceId"`
+	Stages      []DeletionStage `json:"stages"`
+	RemovalDate *time.Time      `json:"removalDate,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+func (r *DeletePieceResponse) stage(stage, status string, err error) {
+	entry := DeletionStage{Stage: stage, Status: status}
+	if err != nil {
+		entry.Error = err.Error()
+		r.Error = err.Error()
+	}
+	r.Stages = append(r.Stages, entry)
+}
+
+// @Summary Delete a piece
+// @Description Delete one of the caller's files. A pinned file is refused with 409 until it is unpinned. A file whose root is in its proof set has the root removed from the service and is then listed as pending removal until the grace period ends, when it is deleted for good. A file still waiting to be added to its proof set has that work cancelled and is deleted at once, as is one whose root could not be added. The response lists each stage and whether it succeeded, including on failure.
+// @Tags pieces
+// @Param id path int true "Piece ID"
+// @Produce json
+// @Success 200 {object} DeletePieceResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} DeletePieceResponse
+// @Failure 500 {object} DeletePieceResponse
+// @Failure 502 {object} DeletePieceResponse
+// @Router /api/v1/pieces/{id} [delete]
+func DeletePiece(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var piece models.Piece
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&piece).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Piece not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch piece")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch piece",
+		})
+		return
+	}
+
+	response, status := deletePiece(c.Request.Context(), piece)
+	c.JSON(status, response)
+}
+
+// deletePiece deletes piece as DeletePiece describes and returns the
+// response and status code to report.
+func deletePiece(ctx context.Context, piece models.Piece) (DeletePieceResponse, int) {
+	response := DeletePieceResponse{PieceID: piece.ID}
+
+	if piece.Pinned {
+		response.Error = errPiecePinned.Error()
+		return response, http.StatusConflict
+	}
+
+	if piece.PendingRemoval {
+		response.stage(deleteStageRemoveRoot, stageSkipped, nil)
+		response.RemovalDate = piece.RemovalDate
+		return response, http.StatusOK
+	}
+
+	switch {
+	case piece.RootStatus == rootStatusPending:
+		return response, deletePendingPiece(piece, &response)
+	case piece.RootStatus == rootStatusUnconfirmed:
+		// The root was added, but removing it needs its ID.
+		response.Error = "The piece's root ID has not been confirmed by the storage provider yet. Please try again once its proof set has been synced."
+		return response, http.StatusConflict
+	case piece.RootID == nil || *piece.RootID == "":
+		// add-roots failed, so there is no root to remove.
+		response.stage(deleteStageRemoveRoot, stageSkipped, nil)
+		if err := deletePieceNow(db, piece); err != nil {
+			log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to delete piece")
+			response.stage(deleteStageDeletePiece, stageFailed, err)
+			return response, http.StatusInternalServerError
+		}
+		response.stage(deleteStageDeletePiece, stageSucceeded, nil)
+		return response, http.StatusOK
+	default:
+		return response, removePieceRoot(ctx, piece, &response)
+	}
+}
+
+// deletePendingPiece deletes a piece whose root hasn't been added yet by
+// cancelling the root task that would add it, returning the status code to
+// report.
+func deletePendingPiece(piece models.Piece, response *DeletePieceResponse) int {
+	deleteTx := func(tx *gorm.DB) error {
+		return deletePieceNow(tx, piece)
+	}
+	var err error
+	if roots != nil {
+		err = roots.cancelPiece(piece.ID, deleteTx)
+	} else {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.RootTask{}).
+				Where("piece_id = ? AND status = ?", piece.ID, rootTaskAdding).
+				Update("status", rootTaskCancelled).Error; err != nil {
+				return err
+			}
+			return deleteTx(tx)
+		})
+	}
+
+	if errors.Is(err, errRootTaskBusy) {
+		response.stage(deleteStageCancelRootTask, stageFailed, errors.New("The file is already being added to its proof set. Please try again once its root is confirmed."))
+		return http.StatusConflict
+	}
+	if err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to cancel root task and delete piece")
+		response.stage(deleteStageCancelRootTask, stageFailed, err)
+		return http.StatusInternalServerError
+	}
+
+	log.WithField("pieceID", piece.ID).Info("Cancelled root task and deleted piece")
+	response.stage(deleteStageCancelRootTask, stageSucceeded, nil)
+	response.stage(deleteStageDeletePiece, stageSucceeded, nil)
+	return http.StatusOK
+}
+
+// deletePieceNow soft-deletes a piece that has no root on the service.
+func deletePieceNow(tx *gorm.DB, piece models.Piece) error {
+	if err := tx.Delete(&piece).Error; err != nil {
+		return err
+	}
+	if err := tx.Create(&models.PieceEvent{
+		PieceID: piece.ID,
+		UserID:  piece.UserID,
+		ActorID: &piece.UserID,
+		Action:  pieceEventDeleted,
+	}).Error; err != nil {
+		return err
+	}
+	if err := handOffCurrentVersion(piece); err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to make another version of the file current")
+	}
+	return nil
+}
+
+// removePieceRoot removes a piece's root from its proof set and marks the
+// piece pending removal until the grace period ends, returning the status
+// code to report.
+func removePieceRoot(ctx context.Context, piece models.Piece, response *DeletePieceResponse) int {
+	proofSet, err := pieceProofSet(piece)
+	if err != nil {
+		response.stage(deleteStageRemoveRoot, stageFailed, err)
+		return http.StatusInternalServerError
+	}
+
+	pdptoolPath, err := preparePdptool(ctx)
+	if err != nil {
+		response.stage(deleteStageRemoveRoot, stageFailed, err)
+		return http.StatusInternalServerError
+	}
+	logRemovalRequested(piece)
+	if _, removeErr := runRemoveRoots(pdptoolPath, piece.ServiceURL, piece.ServiceName, proofSet.ProofSetID, *piece.RootID); removeErr != nil {
+		response.stage(deleteStageRemoveRoot, stageFailed, errors.New("Failed to remove root: "+removeErr.stderr))
+		return http.StatusBadGateway
+	}
+	response.stage(deleteStageRemoveRoot, stageSucceeded, nil)
+
+	removalDate, err := markPendingRemoval(piece)
+	if err != nil {
+		response.stage(deleteStageMarkRemoval, stageFailed, err)
+		return http.StatusInternalServerError
+	}
+	response.stage(deleteStageMarkRemoval, stageSucceeded, nil)
+	response.RemovalDate = &removalDate
+	return http.StatusOK
+}
+
+// hasRemovableRoot reports whether deleting piece means removing its root
+// from its proof set with pdptool first.
+func hasRemovableRoot(piece models.Piece) bool {
+	return !piece.PendingRemoval && piece.RootStatus == rootStatusConfirmed &&
+		piece.RootID != nil && *piece.RootID != ""
+}
+
+// logRemovalRequested records that the owner asked for piece's root to be
+// removed, before the service is asked to remove it.
+func logRemovalRequested(piece models.Piece) {
+	logPieceEvent(models.PieceEvent{
+		PieceID: piece.ID,
+		UserID:  piece.UserID,
+		ActorID: &piece.UserID,
+		Action:  pieceEventRemovalRequested,
+		From:    stringValue(piece.RootID),
+	})
+}
+
+// pieceProofSet returns the proof set piece's root was added to.
+func pieceProofSet(piece models.Piece) (models.ProofSet, error) {
+	var proofSet models.ProofSet
+	if piece.ProofSetID == nil {
+		return proofSet, errors.New("The file has no proof set")
+	}
+	if err := db.Where("id = ? AND user_id = ?", *piece.ProofSetID, piece.UserID).First(&proofSet).Error; err != nil || proofSet.ProofSetID == "" {
+		log.WithField("pieceID", piece.ID).WithField("proofSetDbId", *piece.ProofSetID).Error("Proof set of piece not found")
+		return proofSet, errors.New("The file's proof set could not be found")
+	}
+	return proofSet, nil
+}
+
+// markPendingRemoval marks a piece whose root has been removed as pending
+// removal until the grace period ends, returning when it will be deleted.
+func markPendingRemoval(piece models.Piece) (time.Time, error) {
+	return markPendingRemovals([]models.Piece{piece})
+}
+
+// markPendingRemovals marks pieces whose roots have been removed as pending
+// removal until the grace period ends, all of them or none, returning when
+// they will be deleted.
+func markPendingRemovals(pieces []models.Piece) (time.Time, error) {
+	removalDate := time.Now().Add(cfg.Upload.RemovalGracePeriod)
+	ids := make([]uint, len(pieces))
+	events := make([]models.PieceEvent, len(pieces))
+	for i, piece := range pieces {
+		ids[i] = piece.ID
+		events[i] = models.PieceEvent{
+			PieceID: piece.ID,
+			UserID:  piece.UserID,
+			ActorID: &pieces[i].UserID,
+			Action:  pieceEventRootRemoved,
+			From:    stringValue(piece.RootID),
+			To:      removalDate.UTC().Format(time.RFC3339),
+		}
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Piece{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"pending_removal": true,
+			"removal_date":    removalDate,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&events).Error
+	})
+	if err != nil {
+		log.WithField("pieceIDs", ids).WithField("error", err.Error()).Error("Failed to mark pieces pending removal after removing their roots")
+		return removalDate, err
+	}
+	for _, piece := range pieces {
+		if err := handOffCurrentVersion(piece); err != nil {
+			log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to make another version of the file current")
+		}
+	}
+
+	log.WithField("pieceIDs", ids).WithField("removalDate", removalDate).Info("Removed roots and marked pieces pending removal")
+	return removalDate, nil
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// pieceContentKey is the SQL for what identifies a piece's contents: its
+// SHA-256 or, for pieces stored before checksums were recorded, its base
+// CID.
+const pieceContentKey = "COALESCE(checksum, base_c_id)"
+
+// DuplicateGroup is a set of the caller's files with identical contents
+// @Description Files with identical contents, oldest first. The oldest is suggested for keeping and the rest for removal.
+type DuplicateGroup struct {
+	Checksum    string          `json:"checksum,omitempty"`
+	BaseCID     string          `json:"baseCid"`
+	Pieces      []PieceResponse `json:"pieces"`
+	WastedBytes int64           `json:"wastedBytes"`
+	Keep        uint            `json:"keep"`
+	Remove      []uint          `json:"remove"`
+}
+
+// DuplicateReportResponse lists the caller's duplicated files
+// @Description Groups of files stored more than once. cleanup, when present, is a bulk request removing every file suggested for removal, ready to send to POST /api/v1/pieces/bulk.
+type DuplicateReportResponse struct {
+	Groups      []DuplicateGroup  `json:"groups"`
+	WastedBytes int64             `json:"wastedBytes"`
+	Cleanup     *BulkPieceRequest `json:"cleanup,omitempty"`
+}
+
+// contentKey is the Go side of pieceContentKey.
+func contentKey(piece models.Piece) string {
+	if piece.Checksum != nil {
+		return *piece.Checksum
+	}
+	return piece.BaseCID
+}
+
+// @Summary Find duplicated files
+// @Description Group the caller's files that have identical contents, matched by SHA-256 or, for files uploaded before checksums were recorded, by CID. Files pending removal are left out, as are contents stored only once. wastedBytes is what the copies beyond the oldest take on the service.
+// @Tags pieces
+// @Produce json
+// @Success 200 {object} DuplicateReportResponse
+// @Router /api/v1/pieces/duplicates [get]
+func GetDuplicatePieces(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	live := db.Model(&models.Piece{}).Where("user_id = ? AND pending_removal = ?", userID, false)
+	duplicatedKeys := live.Session(&gorm.Session{}).
+		Select(pieceContentKey).
+		Group(pieceContentKey).
+		Having("COUNT(*) > 1")
+
+	var pieces []models.Piece
+	if err := live.Session(&gorm.Session{}).
+		Where(pieceContentKey+" IN (?)", duplicatedKeys).
+		Order(pieceContentKey + ", created_at ASC, id ASC").
+		Find(&pieces).Error; err != nil {
+		log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to find duplicate pieces")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to find duplicate pieces",
+		})
+		return
+	}
+
+	// Pieces come ordered by contents, so each group is a run of them.
+	response := DuplicateReportResponse{Groups: []DuplicateGroup{}}
+	var removeIDs []uint
+	for i, entry := range pieceResponses(pieces, userID, false) {
+		piece := pieces[i]
+		if i == 0 || contentKey(piece) != contentKey(pieces[i-1]) {
+			response.Groups = append(response.Groups, DuplicateGroup{
+				Checksum: stringValue(piece.Checksum),
+				BaseCID:  entry.BaseCID,
+				Keep:     piece.ID,
+				Remove:   []uint{},
+			})
+		} else {
+			group := &response.Groups[len(response.Groups)-1]
+			group.Remove = append(group.Remove, piece.ID)
+			group.WastedBytes += entry.StoredSize
+			response.WastedBytes += entry.StoredSize
+			removeIDs = append(removeIDs, piece.ID)
+		}
+		group := &response.Groups[len(response.Groups)-1]
+		group.Pieces = append(group.Pieces, entry)
+	}
+	if len(removeIDs) > 0 {
+		response.Cleanup = &BulkPieceRequest{Action: bulkActionRemove, PieceIDs: removeIDs}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Actions recorded in a piece's history.
+const (
+	pieceEventUploaded         = "uploaded"
+	pieceEventRootAdded        = "root_added"
+	pieceEventRootConfirmed    = "root_confirmed"
+	pieceEventRootFailed       = "root_failed"
+	pieceEventRemovalRequested = "removal_requested"
+	pieceEventRootRemoved      = "root_removed"
+	pieceEventDeleted          = "deleted"
+	pieceEventRestored         = "restored"
+	pieceEventRenamed          = "renamed"
+	pieceEventMoved            = "moved"
+	pieceEventDownloaded       = "downloaded"
+	pieceEventRetentionExpired = "retention_expired"
+	pieceEventPinned           = "pinned"
+	pieceEventUnpinned         = "unpinned"
+	pieceEventVerified         = "verified"
+	pieceEventRootMissing      = "root_missing"
+	pieceEventTransferred      = "transferred"
+	pieceEventRemovalRetried   = "removal_retried"
+)
+
+const (
+	defaultPieceEventsLimit = 50
+	maxPieceEventsLimit     = 200
+)
+
+// PieceEventListResponse is a page of a piece's history
+// @Description Page of a piece's events, newest first
+type PieceEventListResponse struct {
+	Events []models.PieceEvent `json:"events"`
+	Total  int64               `json:"total"`
+	Limit  int                 `json:"limit"`
+	Offset int                 `json:"offset"`
+}
+
+// logPieceEvent records an event that isn't part of a change to the
+// database, so there is no transaction to write it in. Failures are logged
+// rather than failing whatever caused the event.
+func logPieceEvent(event models.PieceEvent) {
+	if db == nil {
+		return
+	}
+	if err := db.Create(&event).Error; err != nil {
+		log.WithField("pieceID", event.PieceID).
+			WithField("action", event.Action).
+			WithField("error", err.Error()).
+			Error("Failed to record piece event")
+	}
+}
+
+// uploadEvents are the events of piece being stored by upload job jobID:
+// its upload, or its restore when it was pending removal, followed by its
+// root being added and confirmed when rootID is already known.
+func uploadEvents(piece *models.Piece, jobID, rootID string) []models.PieceEvent {
+	action := pieceEventUploaded
+	if piece.PendingRemoval {
+		action = pieceEventRestored
+	}
+	events := []models.PieceEvent{{
+		PieceID: piece.ID,
+		UserID:  piece.UserID,
+		ActorID: &piece.UserID,
+		Action:  action,
+		To:      piece.CID,
+		Detail:  "upload job " + jobID,
+	}}
+	if rootID != "" {
+		for _, action := range []string{pieceEventRootAdded, pieceEventRootConfirmed} {
+			events = append(events, models.PieceEvent{
+				PieceID: piece.ID,
+				UserID:  piece.UserID,
+				Action:  action,
+				To:      rootID,
+			})
+		}
+	}
+	return events
+}
+
+// logPieceDownload records that piece was sent to a client, in its history
+// and its download count. Requests for a later part of the file, as players
+// make when seeking, aren't recorded so one viewing counts once. It is
+// called once the file has been sent, so recording it doesn't hold up the
+// download.
+func logPieceDownload(c *gin.Context, piece models.Piece, member *models.ArchiveEntry) {
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	if ranges := c.GetHeader("Range"); ranges != "" && ranges != "bytes=0-" {
+		return
+	}
+	countPieceDownloads(piece.ID)
+
+	event := models.PieceEvent{
+		PieceID: piece.ID,
+		UserID:  piece.UserID,
+		Action:  pieceEventDownloaded,
+	}
+	if userID, ok := c.Get("userID"); ok {
+		actorID := userID.(uint)
+		event.ActorID = &actorID
+	}
+	switch {
+	case c.Param("slug") != "":
+		event.Detail = "share link"
+	case c.Param("token") != "":
+		event.Detail = "download link"
+	}
+	if member != nil {
+		event.To = member.Path
+	}
+	logPieceEvent(event)
+}
+
+// @Summary Get a piece's history
+// @Description List what has happened to one of the caller's files, newest first: its upload, its root being added, confirmed or removed, removal requests, renames, moves, downloads and transfers to another user. The history of a deleted file stays available.
+// @Tags pieces
+// @Param id path int true "Piece ID"
+// @Param limit query int false "Maximum number of events to return (max 200)" default(50)
+// @Param offset query int false "Number of events to skip" default(0)
+// @Produce json
+// @Success 200 {object} PieceEventListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/events [get]
+func ListPieceEvents(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPieceEventsLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "limit must be a positive integer",
+		})
+		return
+	}
+	if limit > maxPieceEventsLimit {
+		limit = maxPieceEventsLimit
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "offset must be a non-negative integer",
+		})
+		return
+	}
+
+	var piece models.Piece
+	if err := db.Unscoped().Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&piece).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Piece not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch piece")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch piece",
+		})
+		return
+	}
+
+	query := db.Model(&models.PieceEvent{}).Where("piece_id = ?", piece.ID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to count piece events")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch piece history",
+		})
+		return
+	}
+
+	events := []models.PieceEvent{}
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&events).Error; err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to fetch piece events")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch piece history",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, PieceEventListResponse{
+		Events: events,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// grantPermissionRead lets the grantee see, preview and download a piece.
+// It is the only permission so far.
+const grantPermissionRead = "read"
+
+// grantedPieceIDs selects the IDs of the pieces shared with a user, for use
+// as a subquery.
+const grantedPieceIDs = "SELECT piece_id FROM piece_grants WHERE grantee_id = ?"
+
+// PieceGrantRequest is the body of a request to share a piece with a wallet.
+type PieceGrantRequest struct {
+	WalletAddress string `json:"walletAddress" binding:"required" example:"0x742d35Cc6634C0532925a3b844Bc454e4438f44e"`
+	// Permission defaults to read, the only one supported.
+	Permission string `json:"permission" example:"read"`
+}
+
+// PieceGrantResponse is a wallet a piece is shared with. Active is false
+// until that wallet signs in for the first time.
+type PieceGrantResponse struct {
+	ID            uint      `json:"id"`
+	PieceID       uint      `json:"pieceId"`
+	WalletAddress string    `json:"walletAddress"`
+	Permission    string    `json:"permission"`
+	Active        bool      `json:"active"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+func pieceGrantResponse(grant models.PieceGrant) PieceGrantResponse {
+	return PieceGrantResponse{
+		ID:            grant.ID,
+		PieceID:       grant.PieceID,
+		WalletAddress: grant.GranteeWallet,
+		Permission:    grant.Permission,
+		Active:        grant.GranteeID != nil,
+		CreatedAt:     grant.CreatedAt,
+	}
+}
+
+// SharedPieceResponse is a piece another user has shared with the caller.
+// The owner's tags, collections and metadata are not included.
+type SharedPieceResponse struct {
+	PieceResponse
+	OwnerWallet string    `json:"ownerWallet"`
+	Permission  string    `json:"permission"`
+	SharedAt    time.Time `json:"sharedAt"`
+}
+
+// accessibleTo narrows query to pieces userID owns or has been granted.
+func accessibleTo(query *gorm.DB, userID interface{}) *gorm.DB {
+	return query.Where("(pieces.user_id = ? OR pieces.id IN ("+grantedPieceIDs+"))", userID, userID)
+}
+
+// findPieceByCID returns the user's piece with cid or, when they have none,
+// one shared with them. cid may be the compound CID the piece is stored
+// under or just its base CID.
+func findPieceByCID(cid string, userID interface{}) (models.Piece, error) {
+	var piece models.Piece
+	err := whereCID(db, cid).Where("user_id = ?", userID).First(&piece).Error
+	if err == gorm.ErrRecordNotFound {
+		err = whereCID(db, cid).Where("id IN ("+grantedPieceIDs+")", userID).Order("created_at ASC").First(&piece).Error
+	}
+	return piece, err
+}
+
+// whereCID narrows query to pieces stored under cid. A compound base:subroot
+// CID has to match exactly, while a base CID matches whatever subroot the
+// piece has.
+func whereCID(query *gorm.DB, cid string) *gorm.DB {
+	if strings.Contains(cid, ":") {
+		return query.Where("pieces.c_id = ?", cid)
+	}
+	return query.Where("pieces.base_c_id = ?", cid)
+}
+
+// hasPieceGrant reports whether pieceID is currently shared with userID.
+func hasPieceGrant(pieceID, userID uint) bool {
+	var count int64
+	if err := db.Model(&models.PieceGrant{}).Where("piece_id = ? AND grantee_id = ?", pieceID, userID).Count(&count).Error; err != nil {
+		log.WithField("pieceID", pieceID).WithField("error", err.Error()).Error("Failed to check piece grant")
+		return false
+	}
+	return count > 0
+}
+
+// activatePieceGrants links grants made to user's wallet before they had an
+// account to that account. It is called whenever the user signs in.
+func activatePieceGrants(tx *gorm.DB, user models.User) {
+	result := tx.Model(&models.PieceGrant{}).
+		Where("grantee_wallet = ? AND grantee_id IS NULL", strings.ToLower(user.WalletAddress)).
+		Update("grantee_id", user.ID)
+	if result.Error != nil {
+		log.WithField("userID", user.ID).WithField("error", result.Error.Error()).Error("Failed to activate piece grants")
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.WithField("userID", user.ID).WithField("grants", result.RowsAffected).Info("Activated piece grants")
+	}
+}
+
+// sharedPieces returns the most recently shared pieces shared with userID.
+func sharedPieces(userID interface{}) ([]SharedPieceResponse, error) {
+	var grants []struct {
+		PieceID     uint
+		Permission  string
+		CreatedAt   time.Time
+		OwnerWallet string
+	}
+	if err := db.Table("piece_grants").
+		Select("piece_grants.piece_id, piece_grants.permission, piece_grants.created_at, users.wallet_address AS owner_wallet").
+		Joins("JOIN users ON users.id = piece_grants.owner_id").
+		Where("piece_grants.grantee_id = ?", userID).
+		Order("piece_grants.created_at DESC").
+		Limit(maxPiecesPageSize).
+		Scan(&grants).Error; err != nil {
+		return nil, err
+	}
+	shared := []SharedPieceResponse{}
+	if len(grants) == 0 {
+		return shared, nil
+	}
+
+	pieceIDs := make([]uint, len(grants))
+	for i, grant := range grants {
+		pieceIDs[i] = grant.PieceID
+	}
+	var pieces []models.Piece
+	if err := db.Where("id IN ?", pieceIDs).Find(&pieces).Error; err != nil {
+		return nil, err
+	}
+	responses := make(map[uint]PieceResponse, len(pieces))
+	for _, response := range pieceResponses(pieces, userID, false) {
+		response.Tags = []string{}
+		response.CollectionID = nil
+		response.Metadata = nil
+		responses[response.ID] = response
+	}
+
+	for _, grant := range grants {
+		response, ok := responses[grant.PieceID]
+		if !ok {
+			// Deleted since it was shared.
+			continue
+		}
+		shared = append(shared, SharedPieceResponse{
+			PieceResponse: response,
+			OwnerWallet:   grant.OwnerWallet,
+			Permission:    grant.Permission,
+			SharedAt:      grant.CreatedAt,
+		})
+	}
+	return shared, nil
+}
+
+// @Summary Share a piece with a wallet
+// @Description Give another wallet read access to one of the caller's files, so it is listed under shared and can be viewed and downloaded by that wallet. A wallet that hasn't signed in yet gets access when it first does. Sharing again with the same wallet changes nothing.
+// @Tags pieces
+// @Accept json
+// @Param id path int true "Piece ID"
+// @Param request body PieceGrantRequest true "Wallet to share with"
+// @Produce json
+// @Success 201 {object} PieceGrantResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/grants [post]
+func CreatePieceGrant(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var request PieceGrantRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+	if !common.IsHexAddress(request.WalletAddress) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "walletAddress must be a 0x-prefixed Ethereum address",
+		})
+		return
+	}
+	if request.Permission == "" {
+		request.Permission = grantPermissionRead
+	}
+	if request.Permission != grantPermissionRead {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "permission must be 'read'",
+		})
+		return
+	}
+	wallet := strings.ToLower(request.WalletAddress)
+
+	piece, ok := findLinkablePiece(c, userID, false)
+	if !ok {
+		return
+	}
+
+	var grantee models.User
+	err := db.Where("LOWER(wallet_address) = ?", wallet).First(&grantee).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		log.WithField("error", err.Error()).Error("Failed to look up grantee")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to share piece",
+		})
+		return
+	}
+	if err == nil && grantee.ID == piece.UserID {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "You can't share a file with yourself",
+		})
+		return
+	}
+
+	grant := models.PieceGrant{
+		PieceID:       piece.ID,
+		OwnerID:       piece.UserID,
+		GranteeWallet: wallet,
+		Permission:    request.Permission,
+	}
+	if err == nil {
+		grant.GranteeID = &grantee.ID
+	}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&grant).Error; err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to save piece grant")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to share piece",
+		})
+		return
+	}
+	if err := db.Where("piece_id = ? AND grantee_wallet = ?", piece.ID, wallet).First(&grant).Error; err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to load piece grant")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to share piece",
+		})
+		return
+	}
+
+	log.WithField("pieceID", piece.ID).
+		WithField("grantID", grant.ID).
+		WithField("active", grant.GranteeID != nil).
+		Info("Shared piece with wallet")
+	c.JSON(http.StatusCreated, pieceGrantResponse(grant))
+}
+
+// @Summary List who a piece is shared with
+// @Description List the wallets one of the caller's files is shared with.
+// @Tags pieces
+// @Param id path int true "Piece ID"
+// @Produce json
+// @Success 200 {array} PieceGrantResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/grants [get]
+func ListPieceGrants(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	piece, ok := findLinkablePiece(c, userID, false)
+	if !ok {
+		return
+	}
+
+	var grants []models.PieceGrant
+	if err := db.Where("piece_id = ?", piece.ID).Order("created_at DESC").Find(&grants).Error; err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to fetch piece grants")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch grants",
+		})
+		return
+	}
+	response := make([]PieceGrantResponse, len(grants))
+	for i, grant := range grants {
+		response[i] = pieceGrantResponse(grant)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Stop sharing a piece with a wallet
+// @Description Revoke a wallet's access to one of the caller's files. It takes effect at once, including for download links that wallet created.
+// @Tags pieces
+// @Param id path int true "Piece ID"
+// @Param grantId path int true "Grant ID"
+// @Success 204
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/grants/{grantId} [delete]
+func RevokePieceGrant(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var grant models.PieceGrant
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND piece_id = ? AND owner_id = ?", c.Param("grantId"), c.Param("id"), userID).
+			First(&grant).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&grant).Error; err != nil {
+			return err
+		}
+		if grant.GranteeID == nil {
+			return nil
+		}
+		// Downloads through these are refused anyway once the grant is
+		// gone; deleting them keeps them out of the owner's list.
+		return tx.Where("piece_id = ? AND issued_by = ?", grant.PieceID, *grant.GranteeID).
+			Delete(&models.DownloadLink{}).Error
+	})
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Grant not found",
+		})
+		return
+	}
+	if err != nil {
+		log.WithField("grantID", c.Param("grantId")).WithField("error", err.Error()).Error("Failed to revoke piece grant")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to revoke grant",
+		})
+		return
+	}
+
+	log.WithField("grantID", grant.ID).WithField("pieceID", grant.PieceID).Info("Revoked piece grant")
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPiecesPageSize = 50
+	maxPiecesPageSize     = 200
+)
+
+// pieceSortColumns maps the sortBy values the piece list accepts to the
+// columns they sort on. Files never downloaded sort as if last downloaded at
+// the Unix epoch, so they come last when sorting by newest download.
+var pieceSortColumns = map[string]string{
+	"createdAt":      "created_at",
+	"size":           "size",
+	"filename":       "filename",
+	"lastDownloaded": "COALESCE(last_downloaded_at, 'epoch')",
+}
+
+var errPieceCursorInvalid = errors.New("invalid cursor")
+
+// PieceListResponse is a page of a user's pieces
+// @Description Page of pieces. NextCursor is set when more pieces follow; pass it as after to fetch them. Shared is only set when asked for, and isn't paged or filtered.
+type PieceListResponse struct {
+	Pieces     []PieceResponse       `json:"pieces"`
+	Total      int64                 `json:"total"`
+	Page       int                   `json:"page,omitempty"`
+	PageSize   int                   `json:"pageSize"`
+	SortBy     string                `json:"sortBy"`
+	Order      string                `json:"order"`
+	NextCursor string                `json:"nextCursor,omitempty"`
+	Shared     []SharedPieceResponse `json:"shared,omitempty"`
+}
+
+// pieceCursor marks the last piece of a page. It records the sort it was
+// made for so it can't be replayed against a different one.
+type pieceCursor struct {
+	SortBy string `json:"s"`
+	Order  string `json:"o"`
+	Value  string `json:"v"`
+	ID     uint   `json:"i"`
+}
+
+// pieceSortValue is piece's value for sortBy, as stored in a cursor.
+func pieceSortValue(piece models.Piece, sortBy string) string {
+	switch sortBy {
+	case "size":
+		return strconv.FormatInt(piece.Size, 10)
+	case "filename":
+		return piece.Filename
+	case "lastDownloaded":
+		lastDownloaded := time.Unix(0, 0)
+		if piece.LastDownloaded != nil {
+			lastDownloaded = *piece.LastDownloaded
+		}
+		return lastDownloaded.UTC().Format(time.RFC3339Nano)
+	default:
+		return piece.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+func encodePieceCursor(piece models.Piece, sortBy, order string) string {
+	payload, _ := json.Marshal(pieceCursor{
+		SortBy: sortBy,
+		Order:  order,
+		Value:  pieceSortValue(piece, sortBy),
+		ID:     piece.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// afterPieceCursor narrows query to the pieces sorted after the cursor. Ties
+// on the sort column are broken by id, matching the list's order.
+func afterPieceCursor(query *gorm.DB, encoded, sortBy, order string) (*gorm.DB, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errPieceCursorInvalid
+	}
+	var cursor pieceCursor
+	if err := json.Unmarshal(payload, &cursor); err != nil || cursor.SortBy != sortBy || cursor.Order != order {
+		return nil, errPieceCursorInvalid
+	}
+
+	var value interface{}
+	switch sortBy {
+	case "size":
+		size, err := strconv.ParseInt(cursor.Value, 10, 64)
+		if err != nil {
+			return nil, errPieceCursorInvalid
+		}
+		value = size
+	case "filename":
+		value = cursor.Value
+	default:
+		createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return nil, errPieceCursorInvalid
+		}
+		value = createdAt
+	}
+
+	comparison := ">"
+	if order == "desc" {
+		comparison = "<"
+	}
+	column := pieceSortColumns[sortBy]
+	return query.Where("("+column+", id) "+comparison+" (?, ?)", value, cursor.ID), nil
+}
+
+// filterPieces narrows query by the piece list's filter parameters. The
+// returned error describes the first malformed one.
+func filterPieces(c *gin.Context, query *gorm.DB) (*gorm.DB, error) {
+	if filename := strings.TrimSpace(c.Query("filename")); filename != "" {
+		query = query.Where("filename ILIKE ? ESCAPE '\\'", "%"+likeEscaper.Replace(filename)+"%")
+	}
+
+	if raw := c.Query("pendingRemoval"); raw != "" {
+		pending, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, errors.New("pendingRemoval must be true or false")
+		}
+		query = query.Where("pending_removal = ?", pending)
+	}
+
+	if raw := c.Query("pinned"); raw != "" {
+		pinned, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, errors.New("pinned must be true or false")
+		}
+		query = query.Where("pinned = ?", pinned)
+	}
+
+	for _, bound := range []struct {
+		param      string
+		comparison string
+	}{
+		{"createdAfter", ">="},
+		{"createdBefore", "<"},
+	} {
+		raw := c.Query(bound.param)
+		if raw == "" {
+			continue
+		}
+		at, err := parseFilterTime(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 time or a YYYY-MM-DD date", bound.param)
+		}
+		query = query.Where("created_at "+bound.comparison+" ?", at)
+	}
+
+	if raw := c.Query("expiresBefore"); raw != "" {
+		at, err := parseFilterTime(raw)
+		if err != nil {
+			return nil, errors.New("expiresBefore must be an RFC 3339 time or a YYYY-MM-DD date")
+		}
+		query = query.Where("retain_until < ?", at)
+	}
+
+	for _, bound := range []struct {
+		param      string
+		comparison string
+	}{
+		{"minSize", ">="},
+		{"maxSize", "<="},
+	} {
+		raw := c.Query(bound.param)
+		if raw == "" {
+			continue
+		}
+		size, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative number of bytes", bound.param)
+		}
+		query = query.Where("size "+bound.comparison+" ?", size)
+	}
+
+	if raw := c.Query("proofSetId"); raw != "" {
+		proofSetID, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return nil, errors.New("proofSetId must be a proof set ID")
+		}
+		query = query.Where("proof_set_id = ?", proofSetID)
+	}
+
+	if raw := c.Query("collectionId"); raw == "none" {
+		query = query.Where("collection_id IS NULL")
+	} else if raw != "" {
+		collectionID, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return nil, errors.New("collectionId must be a collection ID or none")
+		}
+		query = query.Where("collection_id = ?", collectionID)
+	}
+
+	if raw := c.Query("tags"); raw != "" {
+		tags, err := normalizeTags(strings.Split(raw, ","))
+		if err != nil {
+			return nil, err
+		}
+		tagged := "SELECT piece_tags.piece_id FROM piece_tags JOIN tags ON tags.id = piece_tags.tag_id " +
+			"WHERE tags.user_id = pieces.user_id AND tags.name IN ?"
+		switch c.DefaultQuery("tagMode", "any") {
+		case "any":
+			query = query.Where("pieces.id IN ("+tagged+")", tags)
+		case "all":
+			query = query.Where("pieces.id IN ("+tagged+" GROUP BY piece_tags.piece_id HAVING COUNT(*) = ?)", tags, len(tags))
+		default:
+			return nil, errors.New("tagMode must be any or all")
+		}
+	}
+
+	return filterByMetadata(c, query)
+}
+
+// likeEscaper escapes the LIKE wildcards in a filename filter so they match
+// literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// parseFilterTime accepts an RFC 3339 time or a date, the latter meaning
+// midnight UTC at its start.
+func parseFilterTime(raw string) (time.Time, error) {
+	if at, err := time.Parse(time.RFC3339, raw); err == nil {
+		return at, nil
+	}
+	return time.Parse("2006-01-02", raw)
+}
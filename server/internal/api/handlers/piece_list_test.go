@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+// listPiecesRequest runs GetUserPieces for user with query.
+func listPiecesRequest(query string, user models.User) *httptest.ResponseRecorder {
+	target := "/api/v1/pieces"
+	if query != "" {
+		target += "?" + query
+	}
+	c, recorder := newTestContext(http.MethodGet, target, nil, user)
+	GetUserPieces(c)
+	return recorder
+}
+
+// fetchPiecesPage runs GetUserPieces and returns the page it responds with.
+func fetchPiecesPage(t *testing.T, query string, user models.User) PieceListResponse {
+	t.Helper()
+	recorder := listPiecesRequest(query, user)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET ?%s: status = %d, want %d: %s", query, recorder.Code, http.StatusOK, recorder.Body)
+	}
+	var response PieceListResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("GET ?%s: parse response: %v", query, err)
+	}
+	return response
+}
+
+func pieceFilenames(pieces []PieceResponse) []string {
+	filenames := make([]string, len(pieces))
+	for i, piece := range pieces {
+		filenames[i] = piece.Filename
+	}
+	return filenames
+}
+
+// seedSizedPieces stores one piece per filename for user, each a byte larger
+// than the one before.
+func seedSizedPieces(t *testing.T, user models.User, filenames ...string) {
+	t.Helper()
+	for i, filename := range filenames {
+		createTestPiece(t, models.Piece{UserID: user.ID, CID: "baga" + filename + ":bagasub", Filename: filename, Size: int64(i + 1)})
+	}
+}
+
+func TestGetUserPiecesPages(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	other := createTestUser(t, "0x2")
+	seedSizedPieces(t, user, "a", "b", "c", "d", "e")
+	seedSizedPieces(t, other, "x")
+
+	tests := []struct {
+		query string
+		want  []string
+		more  bool
+	}{
+		{"sortBy=size&order=asc&pageSize=2", []string{"a", "b"}, true},
+		{"sortBy=size&order=asc&pageSize=2&page=2", []string{"c", "d"}, true},
+		{"sortBy=size&order=asc&pageSize=2&page=3", []string{"e"}, false},
+		{"sortBy=size&order=asc&pageSize=2&page=4", []string{}, false},
+		{"sortBy=size&order=asc&pageSize=5", []string{"a", "b", "c", "d", "e"}, false},
+		{"sortBy=size&order=desc&pageSize=2", []string{"e", "d"}, true},
+		{"sortBy=filename&order=desc&pageSize=1&page=5", []string{"a"}, false},
+	}
+	for _, test := range tests {
+		response := fetchPiecesPage(t, test.query, user)
+		if got := pieceFilenames(response.Pieces); !slices.Equal(got, test.want) {
+			t.Errorf("?%s: pieces = %q, want %q", test.query, got, test.want)
+		}
+		if response.Total != 5 {
+			t.Errorf("?%s: total = %d, want 5", test.query, response.Total)
+		}
+		if more := response.NextCursor != ""; more != test.more {
+			t.Errorf("?%s: next cursor set = %v, want %v", test.query, more, test.more)
+		}
+	}
+}
+
+func TestGetUserPiecesCursor(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	seedSizedPieces(t, user, "a", "b", "c", "d", "e")
+	// Pieces of the same size are ordered by ID, so a page boundary in the
+	// middle of a tie neither repeats nor skips one.
+	createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagatie:bagasub", Filename: "tie", Size: 2})
+
+	var seen []string
+	query := "sortBy=size&order=asc&pageSize=2"
+	for pages := 0; ; pages++ {
+		if pages == 5 {
+			t.Fatalf("cursor still set after %d pages: %q", pages, seen)
+		}
+		response := fetchPiecesPage(t, query, user)
+		if pages > 0 && response.Page != 0 {
+			t.Errorf("page = %d for a cursor page, want it unset", response.Page)
+		}
+		seen = append(seen, pieceFilenames(response.Pieces)...)
+		if response.NextCursor == "" {
+			break
+		}
+		query = "sortBy=size&order=asc&pageSize=2&after=" + response.NextCursor
+	}
+	if want := []string{"a", "b", "tie", "c", "d", "e"}; !slices.Equal(seen, want) {
+		t.Errorf("pieces = %q, want %q", seen, want)
+	}
+}
+
+func TestGetUserPiecesPageSizeLimits(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+
+	if response := fetchPiecesPage(t, "", user); response.PageSize != defaultPiecesPageSize || response.Page != 1 {
+		t.Errorf("default page = %d of size %d, want page 1 of size %d", response.Page, response.PageSize, defaultPiecesPageSize)
+	}
+	if response := fetchPiecesPage(t, "pageSize=100000", user); response.PageSize != maxPiecesPageSize {
+		t.Errorf("page size = %d, want it capped at %d", response.PageSize, maxPiecesPageSize)
+	}
+}
+
+func TestGetUserPiecesRejectsInvalidParameters(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	seedSizedPieces(t, user, "a", "b", "c")
+	sizeCursor := fetchPiecesPage(t, "sortBy=size&order=asc&pageSize=1", user).NextCursor
+
+	for _, query := range []string{
+		"pageSize=0",
+		"pageSize=-1",
+		"pageSize=ten",
+		"page=0",
+		"page=-2",
+		"page=two",
+		"sortBy=owner",
+		"order=up",
+		"after=not-a-cursor",
+		// A cursor only works with the sort it was made for.
+		"sortBy=size&order=desc&after=" + sizeCursor,
+		"sortBy=filename&order=asc&after=" + sizeCursor,
+	} {
+		if recorder := listPiecesRequest(query, user); recorder.Code != http.StatusBadRequest {
+			t.Errorf("?%s: status = %d, want %d", query, recorder.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGetUserPiecesNeedsUser(t *testing.T) {
+	useTestDB(t)
+	if recorder := listPiecesRequest("", models.User{}); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestGetUserPiecesFilters(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, user, "42")
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 12, 0, 0, 0, time.UTC) }
+	for _, piece := range []models.Piece{
+		{Filename: "Report-Q1.pdf", Size: 100, CreatedAt: day(1), ProofSetID: &proofSet.ID},
+		{Filename: "report-q2.pdf", Size: 2000, CreatedAt: day(8), ProofSetID: &proofSet.ID, PendingRemoval: true},
+		{Filename: "holiday.jpg", Size: 30000, CreatedAt: day(15)},
+		{Filename: "100%_done.txt", Size: 5, CreatedAt: day(22), PendingRemoval: true},
+	} {
+		piece.UserID = user.ID
+		piece.CID = "baga" + piece.Filename + ":bagasub"
+		createTestPiece(t, piece)
+	}
+	other := createTestUser(t, "0x2")
+	createTestPiece(t, models.Piece{UserID: other.ID, CID: "bagaother:bagasub", Filename: "report-other.pdf", Size: 100, CreatedAt: day(8)})
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"filename=REPORT", []string{"Report-Q1.pdf", "report-q2.pdf"}},
+		{"filename=%25_", []string{"100%_done.txt"}},
+		{"filename=nothing", []string{}},
+		{"pendingRemoval=true", []string{"report-q2.pdf", "100%_done.txt"}},
+		{"pendingRemoval=false", []string{"Report-Q1.pdf", "holiday.jpg"}},
+		{"createdAfter=2026-01-08", []string{"report-q2.pdf", "holiday.jpg", "100%_done.txt"}},
+		{"createdBefore=2026-01-08T12:00:00Z", []string{"Report-Q1.pdf"}},
+		{"minSize=2000", []string{"report-q2.pdf", "holiday.jpg"}},
+		{"maxSize=100", []string{"Report-Q1.pdf", "100%_done.txt"}},
+		{"proofSetId=" + strconv.FormatUint(uint64(proofSet.ID), 10), []string{"Report-Q1.pdf", "report-q2.pdf"}},
+		{"filename=report&pendingRemoval=false&createdAfter=2026-01-01&createdBefore=2026-01-31&minSize=50&maxSize=5000&proofSetId=" + strconv.FormatUint(uint64(proofSet.ID), 10), []string{"Report-Q1.pdf"}},
+		{"minSize=10&maxSize=100000&createdAfter=2026-01-02", []string{"report-q2.pdf", "holiday.jpg"}},
+	}
+	for _, test := range tests {
+		response := fetchPiecesPage(t, test.query+"&sortBy=createdAt&order=asc", user)
+		if got := pieceFilenames(response.Pieces); !slices.Equal(got, test.want) {
+			t.Errorf("?%s: pieces = %q, want %q", test.query, got, test.want)
+		}
+		if response.Total != int64(len(test.want)) {
+			t.Errorf("?%s: total = %d, want %d", test.query, response.Total, len(test.want))
+		}
+	}
+}
+
+func TestGetUserPiecesRejectsMalformedFilters(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+
+	for _, query := range []string{
+		"pendingRemoval=maybe",
+		"createdAfter=last-week",
+		"createdAfter=2026-13-01",
+		"createdBefore=01/08/2026",
+		"minSize=-1",
+		"maxSize=-100",
+		"minSize=1kb",
+		"proofSetId=-3",
+		"proofSetId=abc",
+	} {
+		recorder := listPiecesRequest(query, user)
+		if recorder.Code != http.StatusUnprocessableEntity {
+			t.Errorf("?%s: status = %d, want %d", query, recorder.Code, http.StatusUnprocessableEntity)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// metadataQueryPrefix marks the piece list parameters that filter on a
+// metadata field, as in metadata.project=apollo.
+const metadataQueryPrefix = "metadata."
+
+var errInvalidMetadata = errors.New("invalid metadata")
+
+// parseMetadata decodes the metadata a client attached to a piece. It must
+// be a JSON object of string values no larger than the configured limit.
+// An empty object gives nil, so the piece has no metadata. Errors wrap
+// errInvalidMetadata.
+func parseMetadata(raw []byte) (models.Metadata, error) {
+	if len(raw) > cfg.Upload.MaxMetadataBytes {
+		return nil, fmt.Errorf("%w: metadata must be at most %d bytes", errInvalidMetadata, cfg.Upload.MaxMetadataBytes)
+	}
+
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if err := decoder.Decode(&fields); err != nil || fields == nil || decoder.More() {
+		return nil, fmt.Errorf("%w: metadata must be a JSON object", errInvalidMetadata)
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	metadata := make(models.Metadata, len(fields))
+	for key, value := range fields {
+		if strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("%w: metadata keys must not be empty", errInvalidMetadata)
+		}
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: metadata field %q must be a string", errInvalidMetadata, key)
+		}
+		metadata[key] = s
+	}
+	return metadata, nil
+}
+
+// metadataError is the message for a parseMetadata error, without the
+// errInvalidMetadata prefix.
+func metadataError(err error) string {
+	return strings.TrimPrefix(err.Error(), errInvalidMetadata.Error()+": ")
+}
+
+// filterByMetadata narrows query to pieces whose metadata holds every
+// metadata.<key>=<value> pair in the request's query string.
+func filterByMetadata(c *gin.Context, query *gorm.DB) (*gorm.DB, error) {
+	wanted := make(models.Metadata)
+	for param, values := range c.Request.URL.Query() {
+		key, ok := strings.CutPrefix(param, metadataQueryPrefix)
+		if !ok {
+			continue
+		}
+		if key == "" {
+			return nil, errors.New("metadata filters must name a field, as in metadata.project=apollo")
+		}
+		if len(values) > 1 {
+			return nil, fmt.Errorf("%s may only be given once", param)
+		}
+		wanted[key] = values[0]
+	}
+	if len(wanted) == 0 {
+		return query, nil
+	}
+
+	containment, err := json.Marshal(wanted)
+	if err != nil {
+		return nil, err
+	}
+	return query.Where("pieces.metadata @> ?::jsonb", string(containment)), nil
+}
+
+// setJobMetadata records the metadata an upload job's piece is to get, so
+// it isn't lost when the job is resumed after a restart.
+func setJobMetadata(jobID string, metadata models.Metadata) {
+	if db == nil || metadata == nil {
+		return
+	}
+	if err := db.Model(&models.UploadJob{}).Where("job_id = ?", jobID).Update("metadata", metadata).Error; err != nil {
+		log.WithField("jobID", jobID).WithField("error", err.Error()).Error("Failed to persist upload metadata")
+	}
+}
+
+// applyUploadMetadata gives an existing piece the metadata sent with a
+// duplicate upload of it. The piece keeps its metadata when none was sent.
+func applyUploadMetadata(piece *models.Piece, metadata models.Metadata) {
+	if metadata == nil {
+		return
+	}
+	if err := db.Model(piece).Update("metadata", metadata).Error; err != nil {
+		log.WithField("pieceId", piece.ID).WithField("error", err.Error()).Error("Failed to update piece metadata")
+		return
+	}
+	piece.Metadata = metadata
+}
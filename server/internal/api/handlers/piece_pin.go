@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// errPiecePinned is reported when removing a pinned piece.
+var errPiecePinned = errors.New("The file is pinned. Unpin it before removing it.")
+
+// @Summary Pin a piece
+// @Description Protect one of the caller's files from removal. Deleting it, removing its root, removing it in bulk and its retention ending are all refused with 409 until it is unpinned.
+// @Tags pieces
+// @Param id path int true "Piece ID"
+// @Produce json
+// @Success 200 {object} PieceResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/pin [post]
+func PinPiece(c *gin.Context) {
+	setPiecePinned(c, true)
+}
+
+// @Summary Unpin a piece
+// @Description Allow one of the caller's files to be removed again
+// @Tags pieces
+// @Param id path int true "Piece ID"
+// @Produce json
+// @Success 200 {object} PieceResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/pin [delete]
+func UnpinPiece(c *gin.Context) {
+	setPiecePinned(c, false)
+}
+
+func setPiecePinned(c *gin.Context, pinned bool) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var piece models.Piece
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&piece).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Piece not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch piece")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch piece",
+		})
+		return
+	}
+
+	if piece.Pinned != pinned {
+		action := pieceEventPinned
+		if !pinned {
+			action = pieceEventUnpinned
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&piece).Update("pinned", pinned).Error; err != nil {
+				return err
+			}
+			return tx.Create(&models.PieceEvent{
+				PieceID: piece.ID,
+				UserID:  piece.UserID,
+				ActorID: &piece.UserID,
+				Action:  action,
+			}).Error
+		})
+		if err != nil {
+			log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to update piece pin")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to update piece",
+			})
+			return
+		}
+		piece.Pinned = pinned
+		log.WithField("pieceID", piece.ID).WithField("pinned", pinned).Info("Updated piece pin")
+	}
+
+	c.JSON(http.StatusOK, pieceResponses([]models.Piece{piece}, userID, piece.IsCurrent)[0])
+}
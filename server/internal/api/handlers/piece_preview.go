@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+)
+
+// defaultPreviewBytes is how much of a file a preview returns when the
+// request doesn't say.
+const defaultPreviewBytes = 64 << 10
+
+// gatewayPreviewTimeout bounds a ranged request for a preview to a gateway.
+const gatewayPreviewTimeout = 15 * time.Second
+
+// previewableTextTypes are the non-text/* types shown as text previews.
+var previewableTextTypes = map[string]bool{
+	"application/json":     true,
+	"application/x-ndjson": true,
+	"application/xml":      true,
+	"application/csv":      true,
+	"application/yaml":     true,
+	"application/x-yaml":   true,
+	"application/toml":     true,
+	"application/sql":      true,
+	"image/svg+xml":        true,
+}
+
+// @Summary Preview the start of a file
+// @Description Return the first bytes of one of the caller's text files, or one shared with them, such as CSV or JSON, without downloading the whole file. The preview is always sent as text/plain; the file's own type is in X-Preview-Content-Type. Files that aren't text get 415 with error not_previewable.
+// @Tags pieces
+// @Param id path int true "Piece ID"
+// @Param bytes query int false "How many bytes to return, 65536 by default, capped by the server"
+// @Produce plain
+// @Success 200 {string} string "Start of the file"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/preview [get]
+func PreviewPiece(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	limit := int64(defaultPreviewBytes)
+	if raw := c.Query("bytes"); raw != "" {
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || value <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "bytes must be a positive number",
+			})
+			return
+		}
+		limit = value
+	}
+	if limit > int64(cfg.Download.PreviewMaxBytes) {
+		limit = int64(cfg.Download.PreviewMaxBytes)
+	}
+
+	var piece models.Piece
+	if err := accessibleTo(db.Where("id = ? AND pending_removal = ?", c.Param("id"), false), userID).First(&piece).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Piece not found",
+		})
+		return
+	}
+
+	// Types recorded as binary can be turned down without fetching
+	// anything.
+	if piece.ContentType != "" && piece.ContentType != "application/octet-stream" && !previewableType(piece.ContentType) {
+		respondNotPreviewable(c, piece.ContentType)
+		return
+	}
+
+	if limit > piece.Size {
+		limit = piece.Size
+	}
+	head, err := previewBytes(c.Request.Context(), piece, limit)
+	if err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to fetch preview")
+		respondDownloadFailed(c, err)
+		return
+	}
+
+	// A file recorded as text that turns out not to be is still turned
+	// down, as is one whose type wasn't known and doesn't sniff as text.
+	sniffed := sniffContentType(head)
+	contentType := piece.ContentType
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = sniffed
+	}
+	if !previewableType(contentType) || !previewableType(sniffed) {
+		respondNotPreviewable(c, contentType)
+		return
+	}
+
+	// Don't end the preview part way through a character.
+	for len(head) > 0 && !utf8.Valid(head) {
+		_, size := utf8.DecodeLastRune(head)
+		head = head[:len(head)-size]
+	}
+
+	c.Header("X-Preview-Content-Type", contentType)
+	c.Header("X-Preview-Truncated", strconv.FormatBool(int64(len(head)) < piece.Size))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, no-cache, no-store, must-revalidate")
+	// Previews are sent as plain text so markup in them is never rendered.
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", head)
+	countPieceDownloads(piece.ID)
+}
+
+func previewableType(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") || previewableTextTypes[contentType]
+}
+
+func respondNotPreviewable(c *gin.Context, contentType string) {
+	c.JSON(http.StatusUnsupportedMediaType, gin.H{
+		"error":       "not_previewable",
+		"message":     "Only text files can be previewed",
+		"contentType": contentType,
+	})
+}
+
+// previewBytes returns up to n bytes from the start of a piece's original
+// contents. It reads them from the download cache when the piece is there,
+// then tries a ranged request to a gateway, and otherwise fetches the piece
+// with pdptool into a temporary directory that is removed before returning.
+func previewBytes(ctx context.Context, piece models.Piece, n int64) ([]byte, error) {
+	if downloads != nil {
+		if file, ok := downloads.lookup(baseCID(piece.CID)); ok {
+			defer file.Close()
+			return readPreview(file, piece, n)
+		}
+	}
+
+	if len(cfg.Download.Gateways) > 0 && gatewayServable(piece) {
+		if head, err := gatewayPreview(ctx, baseCID(piece.CID), n); err == nil {
+			return head, nil
+		} else {
+			log.WithField("cid", piece.CID).WithField("error", err.Error()).Debug("No gateway could serve the preview")
+		}
+	}
+
+	pdptoolPath, err := preparePdptool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tempDir, err := os.MkdirTemp("", "pdp-preview-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	// pdptool can only be trusted to have written the start of the file
+	// before it finishes when streaming is enabled; otherwise the whole
+	// file is fetched, into the cache when there is one.
+	if !cfg.Download.Stream {
+		downloadTo := pieceDownloader(ctx, pdptoolPath, piece, tempDir)
+		raw, err := openRawPiece(piece, tempDir, func(outputFile string) error {
+			return downloadTo(outputFile, true)
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer raw.Close()
+		return readPreview(raw, piece, n)
+	}
+
+	// Stop pdptool as soon as enough of the file has been written.
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outputFile := filepath.Join(tempDir, "preview")
+	file, err := os.OpenFile(outputFile, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cmd, err := downloadFileCommand(fetchCtx, pdptoolPath, piece, tempDir, outputFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	return readPreview(&followReader{file: file, done: done, ctx: fetchCtx}, piece, n)
+}
+
+// readPreview reads up to n bytes of a piece's original contents from raw,
+// the piece as stored on the service.
+func readPreview(raw io.Reader, piece models.Piece, n int64) ([]byte, error) {
+	var content io.Reader = raw
+	if piece.Compressed {
+		gz, err := gzip.NewReader(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errPieceDecompress, err)
+		}
+		defer gz.Close()
+		content = gz
+	}
+
+	head := make([]byte, n)
+	read, err := io.ReadFull(content, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return head[:read], nil
+}
+
+// gatewayPreview asks each configured gateway in turn for the first n bytes
+// of cid.
+func gatewayPreview(ctx context.Context, cid string, n int64) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, gatewayPreviewTimeout)
+	defer cancel()
+
+	var lastErr error
+	for _, gateway := range cfg.Download.Gateways {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, gateway+"/"+cid, nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", n-1))
+		resp, err := gatewayClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("%s answered %s", gateway, resp.Status)
+			continue
+		}
+		// A gateway that ignores Range sends the whole file; only the
+		// start of it is read.
+		head, err := io.ReadAll(io.LimitReader(resp.Body, n))
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		log.WithField("cid", cid).WithField("gateway", gateway).Info("Fetched preview from gateway")
+		return head, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no gateways configured")
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+// maxRetentionDays bounds retentionDays, so the removal time it gives stays
+// representable.
+const maxRetentionDays = 36500
+
+// retentionBatchSize is how many expired pieces one janitor sweep removes,
+// since each may need a call to the PDP service.
+const retentionBatchSize = 50
+
+var errInvalidRetention = errors.New("invalid retention")
+
+// parseRetention turns the retainUntil or retentionDays a client sent into
+// when the piece is to be removed, or nil when neither was sent.
+// retainUntil is an RFC 3339 time or a date and retentionDays is counted
+// from now, with 0 meaning unset. Errors wrap errInvalidRetention.
+func parseRetention(retainUntil string, retentionDays int) (*time.Time, error) {
+	retainUntil = strings.TrimSpace(retainUntil)
+	switch {
+	case retainUntil != "" && retentionDays != 0:
+		return nil, fmt.Errorf("%w: give retainUntil or retentionDays, not both", errInvalidRetention)
+	case retentionDays != 0:
+		if retentionDays < 0 || retentionDays > maxRetentionDays {
+			return nil, fmt.Errorf("%w: retentionDays must be between 1 and %d", errInvalidRetention, maxRetentionDays)
+		}
+		at := time.Now().AddDate(0, 0, retentionDays)
+		return &at, nil
+	case retainUntil != "":
+		at, err := parseFilterTime(retainUntil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: retainUntil must be an RFC 3339 time or a YYYY-MM-DD date", errInvalidRetention)
+		}
+		if !at.After(time.Now()) {
+			return nil, fmt.Errorf("%w: retainUntil must be in the future", errInvalidRetention)
+		}
+		return &at, nil
+	}
+	return nil, nil
+}
+
+// parseRetentionForm is parseRetention for form fields, where
+// retentionDays is text.
+func parseRetentionForm(retainUntil, retentionDays string) (*time.Time, error) {
+	days := 0
+	if retentionDays = strings.TrimSpace(retentionDays); retentionDays != "" {
+		var err error
+		if days, err = strconv.Atoi(retentionDays); err != nil || days == 0 {
+			return nil, fmt.Errorf("%w: retentionDays must be a whole number of days", errInvalidRetention)
+		}
+	}
+	return parseRetention(retainUntil, days)
+}
+
+// retentionError is the message for a parseRetention error, without the
+// errInvalidRetention prefix.
+func retentionError(err error) string {
+	return strings.TrimPrefix(err.Error(), errInvalidRetention.Error()+": ")
+}
+
+// setJobRetention records when an upload job's piece is to be removed, so
+// it isn't lost when the job is resumed after a restart.
+func setJobRetention(jobID string, retainUntil *time.Time) {
+	if db == nil || retainUntil == nil {
+		return
+	}
+	if err := db.Model(&models.UploadJob{}).Where("job_id = ?", jobID).Update("retain_until", retainUntil).Error; err != nil {
+		log.WithField("jobID", jobID).WithField("error", err.Error()).Error("Failed to persist upload retention")
+	}
+}
+
+// applyUploadRetention gives an existing piece the retention sent with a
+// duplicate upload of it. The piece keeps its retention when none was sent.
+func applyUploadRetention(piece *models.Piece, retainUntil *time.Time) {
+	if retainUntil == nil || piece.PendingRemoval {
+		return
+	}
+	if err := db.Model(piece).Update("retain_until", retainUntil).Error; err != nil {
+		log.WithField("pieceId", piece.ID).WithField("error", err.Error()).Error("Failed to update piece retention")
+		return
+	}
+	piece.RetainUntil = retainUntil
+}
+
+// expireRetainedPieces starts the removal of pieces whose retention has
+// ended, as if their owners had deleted them, and returns how many it
+// removed. Pinned pieces are kept until they are unpinned. A piece that
+// can't be removed yet, such as one whose root is still being added, is
+// tried again on a later sweep.
+func expireRetainedPieces(ctx context.Context, now time.Time) int {
+	var due []models.Piece
+	if err := db.Where("retain_until <= ? AND pending_removal = ? AND pinned = ?", now, false, false).
+		Order("retain_until ASC").
+		Limit(retentionBatchSize).
+		Find(&due).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to load pieces due for retention removal")
+		return 0
+	}
+
+	expired := 0
+	for _, piece := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		response, status := deletePiece(ctx, piece)
+		if status != http.StatusOK {
+			log.WithField("pieceID", piece.ID).WithField("error", response.Error).Warning("Failed to remove piece at the end of its retention")
+			continue
+		}
+		logPieceEvent(models.PieceEvent{
+			PieceID: piece.ID,
+			UserID:  piece.UserID,
+			Action:  pieceEventRetentionExpired,
+			From:    piece.RetainUntil.UTC().Format(time.RFC3339),
+			Detail:  "retention period ended",
+		})
+		expired++
+	}
+	return expired
+}
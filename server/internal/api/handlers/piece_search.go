@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPieceSearchLimit = 20
+	maxPieceSearchLimit     = 100
+	// minSearchQueryLength is the fewest letters and digits a search may
+	// have, so a stray character doesn't match most of a user's files.
+	minSearchQueryLength = 2
+)
+
+// searchWord matches the words filenames are split into for searching,
+// mirroring the split the search_vector column makes.
+var searchWord = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// searchQuery is a parsed search: words matched as prefixes and quoted
+// phrases matched exactly, all of which must match.
+type searchQuery struct {
+	prefixes []string
+	phrases  [][]string
+}
+
+// parseSearchQuery splits q into quoted phrases and the words around them.
+// An unmatched quote runs to the end of q.
+func parseSearchQuery(q string) searchQuery {
+	var query searchQuery
+	for i, part := range strings.Split(q, `"`) {
+		words := searchWord.FindAllString(strings.ToLower(part), -1)
+		if i%2 == 0 {
+			query.prefixes = append(query.prefixes, words...)
+		} else if len(words) > 0 {
+			query.phrases = append(query.phrases, words)
+		}
+	}
+	return query
+}
+
+// length is how many letters and digits the query has.
+func (q searchQuery) length() int {
+	n := 0
+	for _, word := range q.prefixes {
+		n += len([]rune(word))
+	}
+	for _, phrase := range q.phrases {
+		for _, word := range phrase {
+			n += len([]rune(word))
+		}
+	}
+	return n
+}
+
+// tsquery renders the query for Postgres's to_tsquery. Words only hold
+// letters and digits, so they need no escaping.
+func (q searchQuery) tsquery() string {
+	terms := make([]string, 0, len(q.prefixes)+len(q.phrases))
+	for _, word := range q.prefixes {
+		terms = append(terms, "'"+word+"':*")
+	}
+	for _, phrase := range q.phrases {
+		quoted := make([]string, len(phrase))
+		for i, word := range phrase {
+			quoted[i] = "'" + word + "'"
+		}
+		terms = append(terms, "("+strings.Join(quoted, " <-> ")+")")
+	}
+	return strings.Join(terms, " & ")
+}
+
+// highlight returns filename, HTML-escaped, with the words the query matched
+// wrapped in <mark>.
+func (q searchQuery) highlight(filename string) string {
+	exact := make(map[string]bool)
+	for _, phrase := range q.phrases {
+		for _, word := range phrase {
+			exact[word] = true
+		}
+	}
+	matches := func(word string) bool {
+		word = strings.ToLower(word)
+		if exact[word] {
+			return true
+		}
+		for _, prefix := range q.prefixes {
+			if strings.HasPrefix(word, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range searchWord.FindAllStringIndex(filename, -1) {
+		word := filename[loc[0]:loc[1]]
+		if !matches(word) {
+			continue
+		}
+		b.WriteString(html.EscapeString(filename[last:loc[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(word))
+		b.WriteString("</mark>")
+		last = loc[1]
+	}
+	b.WriteString(html.EscapeString(filename[last:]))
+	return b.String()
+}
+
+// PieceSearchResult is one match of a piece search
+// @Description Piece matching a search, with its relevance and its filename with the matched words in <mark>
+type PieceSearchResult struct {
+	PieceResponse
+	Rank      float64 `json:"rank"`
+	Highlight string  `json:"highlight"`
+}
+
+// PieceSearchResponse is a page of search results
+// @Description Page of pieces matching a search, most relevant first
+type PieceSearchResponse struct {
+	Results []PieceSearchResult `json:"results"`
+	Total   int64               `json:"total"`
+	Limit   int                 `json:"limit"`
+	Offset  int                 `json:"offset"`
+}
+
+// @Summary Search pieces
+// @Description Search the current versions of the authenticated user's files by filename and content type. Words match the start of words in the filename, so "rep" finds "Q3-report.pdf"; "quoted phrases" must appear in order. Every word and phrase must match. The highlight field is the filename, HTML-escaped, with the matched words in <mark>.
+// @Tags pieces
+// @Produce json
+// @Param q query string true "Search terms, at least 2 letters or digits"
+// @Param limit query int false "Maximum number of results to return (max 100)" default(20)
+// @Param offset query int false "Number of results to skip" default(0)
+// @Success 200 {object} PieceSearchResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /api/v1/pieces/search [get]
+func SearchPieces(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	search := parseSearchQuery(c.Query("q"))
+	if search.length() < minSearchQueryLength {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "q must contain at least " + strconv.Itoa(minSearchQueryLength) + " letters or digits",
+		})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPieceSearchLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "limit must be a positive integer",
+		})
+		return
+	}
+	if limit > maxPieceSearchLimit {
+		limit = maxPieceSearchLimit
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "offset must be a non-negative integer",
+		})
+		return
+	}
+
+	tsquery := search.tsquery()
+	query := db.Model(&models.Piece{}).
+		Where("user_id = ? AND is_current = ?", userID, true).
+		Where("search_vector @@ to_tsquery('simple', ?)", tsquery).
+		Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to count piece search results")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to search pieces",
+		})
+		return
+	}
+
+	var ranked []struct {
+		models.Piece
+		Rank float64
+	}
+	if err := query.
+		Select("pieces.*, ts_rank(search_vector, to_tsquery('simple', ?)) AS rank", tsquery).
+		Order("rank DESC").
+		Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Scan(&ranked).Error; err != nil {
+		log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to search pieces")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to search pieces",
+		})
+		return
+	}
+
+	pieces := make([]models.Piece, len(ranked))
+	for i, row := range ranked {
+		pieces[i] = row.Piece
+	}
+	results := make([]PieceSearchResult, 0, len(ranked))
+	for i, response := range pieceResponses(pieces, userID, true) {
+		results = append(results, PieceSearchResult{
+			PieceResponse: response,
+			Rank:          ranked[i].Rank,
+			Highlight:     search.highlight(response.Filename),
+		})
+	}
+
+	c.JSON(http.StatusOK, PieceSearchResponse{
+		Results: results,
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+	})
+}
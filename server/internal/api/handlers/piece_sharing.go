@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// SetPiecePublicRequest is the body of a request to share or unshare a piece.
+type SetPiecePublicRequest struct {
+	Public bool `json:"public"`
+}
+
+// @Summary Share or unshare a piece
+// @Description Mark one of the caller's pieces public, so other signed-in users can download it by CID, or private again. Only available when public downloads are enabled.
+// @Tags pieces
+// @Accept json
+// @Param id path int true "Piece ID"
+// @Param request body SetPiecePublicRequest true "Whether the piece is public"
+// @Produce json
+// @Success 200 {object} models.Piece
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/public [put]
+func SetPiecePublic(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	if !cfg.Upload.PublicDownloads {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Public downloads are disabled on this server",
+		})
+		return
+	}
+
+	var request SetPiecePublicRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+
+	var piece models.Piece
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&piece).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Piece not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch piece")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch piece",
+		})
+		return
+	}
+
+	if err := db.Model(&piece).Update("public", request.Public).Error; err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to update piece sharing")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update piece",
+		})
+		return
+	}
+
+	log.WithField("pieceID", piece.ID).WithField("public", request.Public).Info("Updated piece sharing")
+	c.JSON(http.StatusOK, piece)
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// PieceStatusResponse is a piece's proof status as reported by its service.
+// @Description Whether the piece's root is in its proof set on the service right now. RootPresent is null when that couldn't be checked, with serviceUnreachable set if the service didn't answer. Results may be up to 30 seconds old.
+type PieceStatusResponse struct {
+	PieceID            uint      `json:"pieceId"`
+	CID                string    `json:"cid"`
+	RootID             *string   `json:"rootId"`
+	RootStatus         string    `json:"rootStatus"`
+	ProofSetID         string    `json:"proofSetId,omitempty"`
+	RootPresent        *bool     `json:"rootPresent"`
+	NextChallengeEpoch *int64    `json:"nextChallengeEpoch,omitempty"`
+	LastProvenEpoch    *int64    `json:"lastProvenEpoch,omitempty"`
+	ServiceUnreachable bool      `json:"serviceUnreachable"`
+	Error              string    `json:"error,omitempty"`
+	CheckedAt          time.Time `json:"checkedAt"`
+}
+
+// @Summary Get a piece's live proof status
+// @Description Ask the piece's storage provider whether its root is still in the proof set, along with the proof set's next challenge epoch and, when the service reports it, the last proven epoch. When the service can't be reached, serviceUnreachable is set and the stored root status is returned alone.
+// @Tags pieces
+// @Param id path int true "Piece ID"
+// @Produce json
+// @Success 200 {object} PieceStatusResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/status [get]
+func GetPieceStatus(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var piece models.Piece
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&piece).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Piece not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch piece")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch piece",
+		})
+		return
+	}
+
+	response := PieceStatusResponse{
+		PieceID:    piece.ID,
+		CID:        piece.CID,
+		RootID:     piece.RootID,
+		RootStatus: piece.RootStatus,
+		CheckedAt:  time.Now().UTC(),
+	}
+
+	proofSet, err := pieceProofSet(piece)
+	if err != nil {
+		response.Error = err.Error()
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	response.ProofSetID = proofSet.ProofSetID
+
+	status, checkedAt, err := fetchProofSetStatus(c.Request.Context(), piece.ServiceURL, piece.ServiceName, proofSet.ProofSetID)
+	response.CheckedAt = checkedAt.UTC()
+	if err != nil {
+		response.ServiceUnreachable = errors.Is(err, errServiceUnreachable)
+		response.Error = err.Error()
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	rootID := ""
+	if piece.RootID != nil {
+		rootID = *piece.RootID
+	}
+	root, present := status.root(rootID, baseCID(piece.CID))
+	response.RootPresent = &present
+	response.NextChallengeEpoch = status.NextChallengeEpoch
+	response.LastProvenEpoch = status.LastProvenEpoch
+	if present && rootID == "" {
+		// Not confirmed here yet, but the service already knows its ID.
+		response.RootID = &root.RootID
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// maxTagLength caps, in characters, the name of a tag.
+	maxTagLength = 64
+	// maxTagsPerPiece caps how many tags one piece may have.
+	maxTagsPerPiece = 50
+)
+
+// PieceTagsRequest is the body of a request to add or remove tags.
+type PieceTagsRequest struct {
+	Tags []string `json:"tags" binding:"required"`
+}
+
+// PieceTagsResponse is the tags a piece has after a change.
+type PieceTagsResponse struct {
+	PieceID uint     `json:"pieceId"`
+	Tags    []string `json:"tags"`
+}
+
+// normalizeTag trims and lowercases a tag name, dropping control characters
+// and collapsing runs of whitespace to a single space.
+func normalizeTag(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, strings.ToValidUTF8(name, ""))
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// normalizeTags normalizes names and drops duplicates. The returned error
+// describes the first name that is empty, too long or has a comma.
+func normalizeTags(names []string) ([]string, error) {
+	seen := make(map[string]bool, len(names))
+	tags := make([]string, 0, len(names))
+	for _, name := range names {
+		tag := normalizeTag(name)
+		if tag == "" {
+			return nil, errors.New("tags must not be empty")
+		}
+		// Commas separate tags in the listing's tags filter.
+		if strings.Contains(tag, ",") {
+			return nil, errors.New("tags must not contain commas")
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags must be at most %d characters", maxTagLength)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// pieceTagNames returns the tag names of each of pieceIDs, sorted, in one
+// query.
+func pieceTagNames(pieceIDs []uint) (map[uint][]string, error) {
+	names := make(map[uint][]string)
+	if len(pieceIDs) == 0 {
+		return names, nil
+	}
+	var rows []struct {
+		PieceID uint
+		Name    string
+	}
+	if err := db.Table("piece_tags").
+		Select("piece_tags.piece_id, tags.name").
+		Joins("JOIN tags ON tags.id = piece_tags.tag_id").
+		Where("piece_tags.piece_id IN ?", pieceIDs).
+		Order("tags.name").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		names[row.PieceID] = append(names[row.PieceID], row.Name)
+	}
+	return names, nil
+}
+
+// @Summary Tag a piece
+// @Description Add tags to one of the caller's files. Tags are trimmed and lowercased, may be up to 64 characters, and are only visible to their owner. Tags the file already has are ignored.
+// @Tags pieces
+// @Accept json
+// @Param id path int true "Piece ID"
+// @Param request body PieceTagsRequest true "Tags to add"
+// @Produce json
+// @Success 200 {object} PieceTagsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/tags [post]
+func AddPieceTags(c *gin.Context) {
+	updatePieceTags(c, true)
+}
+
+// @Summary Untag a piece
+// @Description Remove tags from one of the caller's files. Tags the file doesn't have are ignored.
+// @Tags pieces
+// @Accept json
+// @Param id path int true "Piece ID"
+// @Param request body PieceTagsRequest true "Tags to remove"
+// @Produce json
+// @Success 200 {object} PieceTagsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/tags [delete]
+func RemovePieceTags(c *gin.Context) {
+	updatePieceTags(c, false)
+}
+
+func updatePieceTags(c *gin.Context, add bool) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var request PieceTagsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+	tags, err := normalizeTags(request.Tags)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	var piece models.Piece
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&piece).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Piece not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch piece")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch piece",
+		})
+		return
+	}
+
+	if len(tags) > 0 {
+		if add {
+			err = db.Transaction(func(tx *gorm.DB) error {
+				return addPieceTagsTx(tx, piece, tags)
+			})
+		} else {
+			err = db.Transaction(func(tx *gorm.DB) error {
+				return removePieceTagsTx(tx, piece, tags)
+			})
+		}
+	}
+	if errors.Is(err, errTooManyTags) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": fmt.Sprintf("A file may have at most %d tags", maxTagsPerPiece),
+		})
+		return
+	}
+	if err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to update piece tags")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update tags",
+		})
+		return
+	}
+
+	names, err := pieceTagNames([]uint{piece.ID})
+	if err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to load piece tags")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load tags",
+		})
+		return
+	}
+	response := PieceTagsResponse{PieceID: piece.ID, Tags: names[piece.ID]}
+	if response.Tags == nil {
+		response.Tags = []string{}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+var errTooManyTags = errors.New("too many tags")
+
+// addPieceTagsTx attaches tags to piece, creating any the user doesn't have
+// yet.
+func addPieceTagsTx(tx *gorm.DB, piece models.Piece, names []string) error {
+	records := make([]models.Tag, len(names))
+	for i, name := range names {
+		records[i] = models.Tag{UserID: piece.UserID, Name: name}
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&records).Error; err != nil {
+		return err
+	}
+
+	var tagIDs []uint
+	if err := tx.Model(&models.Tag{}).
+		Where("user_id = ? AND name IN ?", piece.UserID, names).
+		Pluck("id", &tagIDs).Error; err != nil {
+		return err
+	}
+	links := make([]models.PieceTag, len(tagIDs))
+	for i, tagID := range tagIDs {
+		links[i] = models.PieceTag{PieceID: piece.ID, TagID: tagID}
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&links).Error; err != nil {
+		return err
+	}
+
+	var count int64
+	if err := tx.Model(&models.PieceTag{}).Where("piece_id = ?", piece.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > maxTagsPerPiece {
+		return errTooManyTags
+	}
+	return nil
+}
+
+// removePieceTagsTx detaches tags from piece, deleting any the user no
+// longer has on any piece.
+func removePieceTagsTx(tx *gorm.DB, piece models.Piece, names []string) error {
+	var tagIDs []uint
+	if err := tx.Model(&models.Tag{}).
+		Where("user_id = ? AND name IN ?", piece.UserID, names).
+		Pluck("id", &tagIDs).Error; err != nil {
+		return err
+	}
+	if len(tagIDs) == 0 {
+		return nil
+	}
+	if err := tx.Where("piece_id = ? AND tag_id IN ?", piece.ID, tagIDs).Delete(&models.PieceTag{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("id IN ? AND NOT EXISTS (SELECT 1 FROM piece_tags WHERE piece_tags.tag_id = tags.id)", tagIDs).
+		Delete(&models.Tag{}).Error
+}
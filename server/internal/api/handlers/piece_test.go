@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+)
+
+func pieceByCIDRequest(cid string, user models.User) *httptest.ResponseRecorder {
+	c, recorder := newTestContext(http.MethodGet, "/api/v1/pieces/cid/"+url.PathEscape(cid), nil, user)
+	c.Params = gin.Params{{Key: "cid", Value: cid}}
+	GetPieceByCID(c)
+	return recorder
+}
+
+func TestGetPieceByCID(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	other := createTestUser(t, "0x2")
+	stored := createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagabase:bagasub", Filename: "a.txt", Size: 5})
+	createTestPiece(t, models.Piece{UserID: other.ID, CID: "bagaother:bagasub", Filename: "b.txt", Size: 5})
+
+	tests := []struct {
+		name string
+		cid  string
+		want int
+	}{
+		{"compound CID", "bagabase:bagasub", http.StatusOK},
+		{"base CID", "bagabase", http.StatusOK},
+		{"base CID with another subroot", "bagabase:bagaelse", http.StatusNotFound},
+		{"subroot CID alone", "bagasub", http.StatusNotFound},
+		{"another user's base CID", "bagaother", http.StatusNotFound},
+		{"unknown CID", "bagaunknown", http.StatusNotFound},
+		{"garbage", "not a cid!", http.StatusBadRequest},
+		{"too many parts", "bagabase:bagasub:bagamore", http.StatusBadRequest},
+		{"empty subroot", "bagabase:", http.StatusBadRequest},
+		{"empty base", ":bagasub", http.StatusBadRequest},
+		{"wildcard", "baga%", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		recorder := pieceByCIDRequest(test.cid, user)
+		if recorder.Code != test.want {
+			t.Errorf("%s: status = %d, want %d: %s", test.name, recorder.Code, test.want, recorder.Body)
+			continue
+		}
+		if test.want != http.StatusOK {
+			continue
+		}
+		var piece models.Piece
+		json.Unmarshal(recorder.Body.Bytes(), &piece)
+		if piece.ID != stored.ID || piece.CID != "bagabase:bagasub" || piece.BaseCID != "bagabase" || piece.SubrootCID != "bagasub" {
+			t.Errorf("%s: piece %d = %q (base %q, subroot %q), want piece %d split into bagabase and bagasub",
+				test.name, piece.ID, piece.CID, piece.BaseCID, piece.SubrootCID, stored.ID)
+		}
+	}
+}
+
+func TestGetPieceByCIDWithoutSubroot(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaplain", Filename: "a.txt", Size: 5})
+
+	recorder := pieceByCIDRequest("bagaplain", user)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	var piece models.Piece
+	json.Unmarshal(recorder.Body.Bytes(), &piece)
+	if piece.BaseCID != "bagaplain" || piece.SubrootCID != "" {
+		t.Errorf("base %q, subroot %q, want bagaplain and none", piece.BaseCID, piece.SubrootCID)
+	}
+}
+
+func TestProofSetAggregates(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	other := createTestUser(t, "0x2")
+	filled := createTestProofSet(t, user, "42")
+	removing := createTestProofSet(t, user, "43")
+	empty := createCreatingProofSet(t, user, proofSetConfirming, "0x"+strings.Repeat("cd", 32))
+	othersProofSet := createTestProofSet(t, other, "44")
+	uploaded := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	at := func(hours int) time.Time { return uploaded.Add(time.Duration(hours) * time.Hour) }
+
+	fixture := []models.Piece{
+		{UserID: user.ID, ProofSetID: &filled.ID, CID: "bagaone:bagasub", Size: 100, RootStatus: rootStatusConfirmed, CreatedAt: at(0)},
+		{UserID: user.ID, ProofSetID: &filled.ID, CID: "bagatwo:bagasub", Size: 250, RootStatus: rootStatusPending, CreatedAt: at(1)},
+		{UserID: user.ID, ProofSetID: &filled.ID, CID: "bagathree:bagasub", Size: 40, RootStatus: rootStatusUnconfirmed, CreatedAt: at(2)},
+		{UserID: user.ID, ProofSetID: &filled.ID, CID: "bagafour:bagasub", Size: 1000, RootStatus: rootStatusConfirmed, PendingRemoval: true, CreatedAt: at(-5)},
+		{UserID: user.ID, ProofSetID: &filled.ID, CID: "bagafive:bagasub", Size: 30, RootStatus: rootStatusFailed, CreatedAt: at(3)},
+		{UserID: user.ID, ProofSetID: &removing.ID, CID: "bagasix:bagasub", Size: 5, RootStatus: rootStatusConfirmed, PendingRemoval: true, CreatedAt: at(4)},
+		{UserID: other.ID, ProofSetID: &othersProofSet.ID, CID: "bagaone:bagasub", Size: 9, RootStatus: rootStatusPending, CreatedAt: at(9)},
+	}
+	for _, piece := range fixture {
+		createTestPiece(t, piece)
+	}
+	// Deleted pieces count for nothing.
+	deleted := createTestPiece(t, models.Piece{UserID: user.ID, ProofSetID: &filled.ID, CID: "bagaseven:bagasub", Size: 7, RootStatus: rootStatusPending, CreatedAt: at(8)})
+	db.Delete(&deleted)
+
+	timePointer := func(t time.Time) *time.Time { return &t }
+	want := map[uint]ProofSetAggregates{
+		filled.ID: {
+			PieceCount:          4,
+			TotalBytes:          420,
+			PendingRemovalBytes: 1000,
+			UnconfirmedRoots:    2,
+			OldestPieceAt:       timePointer(at(0)),
+			NewestPieceAt:       timePointer(at(3)),
+		},
+		removing.ID: {PendingRemovalBytes: 5},
+		empty.ID:    {},
+	}
+	check := func(name string, got ProofSetAggregates, want ProofSetAggregates) {
+		t.Helper()
+		sameTime := func(a, b *time.Time) bool { return (a == nil) == (b == nil) && (a == nil || a.Equal(*b)) }
+		if got.PieceCount != want.PieceCount || got.TotalBytes != want.TotalBytes || got.PendingRemovalBytes != want.PendingRemovalBytes ||
+			got.UnconfirmedRoots != want.UnconfirmedRoots || !sameTime(got.OldestPieceAt, want.OldestPieceAt) || !sameTime(got.NewestPieceAt, want.NewestPieceAt) {
+			t.Errorf("%s: aggregates = %+v, want %+v", name, got, want)
+		}
+	}
+
+	aggregates, err := proofSetAggregates([]uint{filled.ID, removing.ID, empty.ID})
+	if err != nil {
+		t.Fatalf("proofSetAggregates: %v", err)
+	}
+	for id, want := range want {
+		check(fmt.Sprintf("proof set %d", id), aggregates[id], want)
+	}
+
+	c, recorder := newTestContext(http.MethodGet, "/api/v1/pieces/proof-sets", nil, user)
+	GetProofSets(c)
+	var response ProofSetsResponse
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if recorder.Code != http.StatusOK || len(response.ProofSets) != len(want) {
+		t.Fatalf("GetProofSets: status = %d with %d proof sets, want %d with %d", recorder.Code, len(response.ProofSets), http.StatusOK, len(want))
+	}
+	for _, proofSet := range response.ProofSets {
+		check("GetProofSets proof set "+proofSet.ProofSetID, proofSet.ProofSetAggregates, want[proofSet.ID])
+	}
+
+	single, err := proofSetResponse(filled)
+	if err != nil {
+		t.Fatalf("proofSetResponse: %v", err)
+	}
+	check("proofSetResponse", single.ProofSetAggregates, want[filled.ID])
+}
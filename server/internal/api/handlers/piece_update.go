@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+var errFilenameTaken = errors.New("filename taken")
+
+// UpdatePieceRequest is the body of a request to change a piece. Missing
+// fields are left as they are; a null collectionId moves the piece out of
+// its collection. Metadata replaces the piece's metadata as a whole, and
+// null or an empty object removes it.
+type UpdatePieceRequest struct {
+	Filename     *string         `json:"filename"`
+	CollectionID optionalID      `json:"collectionId" swaggertype:"integer"`
+	Metadata     json.RawMessage `json:"metadata" swaggertype:"object"`
+	// RetainUntil, a time, or RetentionDays schedules the piece's removal;
+	// a null retainUntil cancels it.
+	RetainUntil   json.RawMessage `json:"retainUntil" swaggertype:"string"`
+	RetentionDays int             `json:"retentionDays"`
+}
+
+// @Summary Rename or move a piece, or change its metadata or retention
+// @Description Change the name one of the caller's files is listed and downloaded under, the collection it is in, its metadata, or when it is removed automatically. retainUntil, an RFC 3339 time or YYYY-MM-DD date in the future, or retentionDays schedules the removal and a null retainUntil cancels it; a file already pending removal can't be given a retention and is refused with 409. The stored content, CID and root are unchanged. Metadata must be a JSON object of string values within the configured size limit, or null to remove it, and is refused with 422 otherwise. Path separators and control characters in names are replaced or dropped. Changing a versioned file changes all of its versions; with versioning enabled, a name already used by another of the caller's files is refused with 409.
+// @Tags pieces
+// @Accept json
+// @Param id path int true "Piece ID"
+// @Param request body UpdatePieceRequest true "Fields to change"
+// @Produce json
+// @Success 200 {object} PieceResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /api/v1/pieces/{id} [patch]
+func UpdatePiece(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var request UpdatePieceRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+	setMetadata := len(request.Metadata) > 0
+	setRetention := len(request.RetainUntil) > 0 || request.RetentionDays != 0
+	if request.Filename == nil && !request.CollectionID.Set && !setMetadata && !setRetention {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Nothing to change: give a filename, collectionId, metadata or a retention",
+		})
+		return
+	}
+
+	var metadata models.Metadata
+	if setMetadata && string(request.Metadata) != "null" {
+		var err error
+		if metadata, err = parseMetadata(request.Metadata); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": metadataError(err),
+			})
+			return
+		}
+	}
+
+	var retainUntil *time.Time
+	if setRetention && string(request.RetainUntil) != "null" {
+		var until string
+		if len(request.RetainUntil) > 0 {
+			if err := json.Unmarshal(request.RetainUntil, &until); err != nil {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{
+					"error": "retainUntil must be a time, or null to cancel the removal",
+				})
+				return
+			}
+		}
+		var err error
+		if retainUntil, err = parseRetention(until, request.RetentionDays); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": retentionError(err),
+			})
+			return
+		}
+	}
+
+	var filename string
+	if request.Filename != nil {
+		filename = cleanFilename(*request.Filename)
+		if filename == "" {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": "filename must not be empty",
+			})
+			return
+		}
+		if utf8.RuneCountInString(filename) > cfg.Upload.MaxFilenameLength {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": fmt.Sprintf("filename must be at most %d characters", cfg.Upload.MaxFilenameLength),
+			})
+			return
+		}
+	}
+
+	var piece models.Piece
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&piece).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Piece not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch piece")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch piece",
+		})
+		return
+	}
+
+	if retainUntil != nil && piece.PendingRemoval {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "The file is already pending removal and can't be given a retention",
+		})
+		return
+	}
+
+	if request.CollectionID.Value != nil {
+		if _, err := findCollection(db, userID, *request.CollectionID.Value); err != nil {
+			respondCollectionError(c, err)
+			return
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if filename != "" && filename != piece.Filename {
+			if err := renamePieceTx(tx, piece, filename); err != nil {
+				return err
+			}
+		}
+		if setMetadata {
+			if err := tx.Model(&models.Piece{}).Where("id = ?", piece.ID).Update("metadata", metadata).Error; err != nil {
+				return err
+			}
+		}
+		if setRetention {
+			if err := tx.Model(&models.Piece{}).Where("id = ?", piece.ID).Update("retain_until", retainUntil).Error; err != nil {
+				return err
+			}
+		}
+		if request.CollectionID.Set && !sameID(request.CollectionID.Value, piece.CollectionID) {
+			return movePieceTx(tx, piece, request.CollectionID.Value)
+		}
+		return nil
+	})
+	if errors.Is(err, errFilenameTaken) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Another of your files is already named " + filename,
+		})
+		return
+	}
+	if err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to update piece")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update piece",
+		})
+		return
+	}
+
+	if filename != "" && filename != piece.Filename {
+		log.WithField("pieceID", piece.ID).WithField("filename", filename).Info("Renamed piece")
+		piece.Filename = filename
+	}
+	if request.CollectionID.Set {
+		piece.CollectionID = request.CollectionID.Value
+	}
+	if setMetadata {
+		piece.Metadata = metadata
+	}
+	if setRetention {
+		piece.RetainUntil = retainUntil
+	}
+
+	c.JSON(http.StatusOK, pieceResponses([]models.Piece{piece}, userID, piece.IsCurrent)[0])
+}
+
+func sameID(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// pieceVersions returns piece and every other version of its file.
+func pieceVersions(tx *gorm.DB, piece models.Piece) ([]models.Piece, error) {
+	if piece.FileGroupID == "" {
+		return []models.Piece{piece}, nil
+	}
+	var versions []models.Piece
+	if err := tx.Where("file_group_id = ? AND user_id = ? AND id <> ?", piece.FileGroupID, piece.UserID, piece.ID).
+		Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	return append(versions, piece), nil
+}
+
+// movePieceTx moves piece, and every other version of it, into a
+// collection, or out of any when collectionID is nil.
+func movePieceTx(tx *gorm.DB, piece models.Piece, collectionID *uint) error {
+	versions, err := pieceVersions(tx, piece)
+	if err != nil {
+		return err
+	}
+	for _, p := range versions {
+		if err := tx.Model(&p).Update("collection_id", collectionID).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.PieceEvent{
+			PieceID: p.ID,
+			UserID:  p.UserID,
+			ActorID: &p.UserID,
+			Action:  pieceEventMoved,
+			From:    collectionIDString(p.CollectionID),
+			To:      collectionIDString(collectionID),
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func collectionIDString(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
+
+// renamePieceTx renames piece, and every other version of it, to filename,
+// recording the change against each.
+func renamePieceTx(tx *gorm.DB, piece models.Piece, filename string) error {
+	renamed, err := pieceVersions(tx, piece)
+	if err != nil {
+		return err
+	}
+
+	// Versioning groups uploads by name, so two files may not share one.
+	if cfg.Upload.Versioning {
+		taken := tx.Model(&models.Piece{}).Where("user_id = ? AND filename = ?", piece.UserID, filename)
+		if piece.FileGroupID != "" {
+			taken = taken.Where("file_group_id <> ?", piece.FileGroupID)
+		} else {
+			taken = taken.Where("id <> ?", piece.ID)
+		}
+		var count int64
+		if err := taken.Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errFilenameTaken
+		}
+	}
+
+	for _, p := range renamed {
+		if err := tx.Model(&p).Update("filename", filename).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.PieceEvent{
+			PieceID: p.ID,
+			UserID:  p.UserID,
+			ActorID: &p.UserID,
+			Action:  pieceEventRenamed,
+			From:    p.Filename,
+			To:      filename,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// verifyJobPrefix starts the ID of every piece verification job, setting
+// them apart from upload, bulk and archive jobs.
+const verifyJobPrefix = "verify-"
+
+// Outcomes of checking a piece, recorded as its lastVerifyResult.
+const (
+	verifyResultOK          = "ok"
+	verifyResultUnreachable = "sp_unreachable"
+	verifyResultMismatch    = "content_mismatch"
+	verifyResultFailed      = "verify_failed"
+)
+
+// runningVerifications maps a piece to the job verifying it, so a second
+// request while one runs is given the same job.
+var (
+	runningVerifications     = make(map[uint]string)
+	runningVerificationsLock sync.Mutex
+)
+
+// PieceVerifyResponse describes a piece verification job
+// @Description Verification of a stored file. result is set once status is complete or error: ok, sp_unreachable when the storage provider couldn't be reached, content_mismatch when what it returned differs from what was uploaded, or verify_failed when the check itself couldn't be run.
+type PieceVerifyResponse struct {
+	JobID     string `json:"jobId"`
+	PieceID   uint   `json:"pieceId,omitempty"`
+	Status    string `json:"status"`
+	Result    string `json:"result,omitempty"`
+	Message   string `json:"message,omitempty"`
+	StatusURL string `json:"statusUrl"`
+}
+
+func pieceVerifyResponse(jobID string, progress UploadProgress) PieceVerifyResponse {
+	response := PieceVerifyResponse{
+		JobID:     jobID,
+		PieceID:   progress.PieceID,
+		Status:    progress.Status,
+		Message:   progress.Message,
+		StatusURL: "/api/v1/pieces/verify/" + jobID,
+	}
+	switch progress.Status {
+	case "complete":
+		response.Result = verifyResultOK
+	case "error", "interrupted":
+		response.Result = progress.Error
+	}
+	return response
+}
+
+// @Summary Verify a piece
+// @Description Check that one of the caller's files can still be retrieved from its storage provider and matches what was uploaded. The file is downloaded in the background, bypassing the download cache, and its SHA-256 and piece CID are recomputed and compared with the stored ones. The outcome is recorded on the piece as lastVerifiedAt and lastVerifyResult and in its history. Verifying a file that is already being verified returns the running job.
+// @Tags pieces
+// @Param id path int true "Piece ID"
+// @Produce json
+// @Success 202 {object} PieceVerifyResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/verify [post]
+func VerifyPiece(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var piece models.Piece
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&piece).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Piece not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch piece")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch piece",
+		})
+		return
+	}
+
+	if piece.PendingRemoval {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "The file is pending removal and can no longer be verified",
+		})
+		return
+	}
+
+	jobID := startPieceVerification(userID.(uint), piece)
+	progress, _, _ := getUploadJob(jobID)
+	c.JSON(http.StatusAccepted, pieceVerifyResponse(jobID, progress))
+}
+
+// @Summary Get a piece verification job
+// @Description Get the status of a piece verification and, once it has finished, its result
+// @Tags pieces
+// @Param jobId path string true "Verification job ID"
+// @Produce json
+// @Success 200 {object} PieceVerifyResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/pieces/verify/{jobId} [get]
+func GetPieceVerifyJob(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	jobID := c.Param("jobId")
+	progress, ownerID, ok := getUploadJob(jobID)
+	if !strings.HasPrefix(jobID, verifyJobPrefix) || !ok || ownerID != userID.(uint) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Verification job not found",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, pieceVerifyResponse(jobID, progress))
+}
+
+// startPieceVerification verifies piece in a background job and returns the
+// job's ID, or the ID of the job already verifying it.
+func startPieceVerification(userID uint, piece models.Piece) string {
+	runningVerificationsLock.Lock()
+	defer runningVerificationsLock.Unlock()
+
+	if jobID, ok := runningVerifications[piece.ID]; ok {
+		return jobID
+	}
+
+	jobID := verifyJobPrefix + uuid.New().String()
+	runningVerifications[piece.ID] = jobID
+	createUploadJob(jobID, userID, UploadProgress{
+		Status:    "verifying",
+		Message:   "Downloading file from storage provider",
+		Filename:  piece.Filename,
+		TotalSize: piece.Size,
+		PieceID:   piece.ID,
+	})
+
+	go func() {
+		defer func() {
+			runningVerificationsLock.Lock()
+			delete(runningVerifications, piece.ID)
+			runningVerificationsLock.Unlock()
+		}()
+
+		result, detail := verifyPiece(jobContext(jobID), jobID, piece)
+		if result == "" {
+			// The job was cancelled, so nothing was learned about the piece.
+			return
+		}
+		if result == verifyResultMismatch && downloads != nil {
+			// A cached copy is likely just as bad.
+			downloads.remove(baseCID(piece.CID))
+		}
+		recordPieceVerification(piece, result, time.Now())
+		logPieceEvent(models.PieceEvent{
+			PieceID: piece.ID,
+			UserID:  piece.UserID,
+			ActorID: &userID,
+			Action:  pieceEventVerified,
+			To:      result,
+			Detail:  detail,
+		})
+
+		progress := UploadProgress{
+			Status:   "complete",
+			Progress: 100,
+			Message:  "The file was retrieved and matches what was uploaded",
+			PieceID:  piece.ID,
+		}
+		if result != verifyResultOK {
+			progress.Status = "error"
+			progress.Error = result
+			progress.Message = detail
+		}
+		updateJobStatus(jobID, progress)
+		log.WithField("jobID", jobID).
+			WithField("pieceID", piece.ID).
+			WithField("result", result).
+			Info("Finished piece verification")
+	}()
+
+	return jobID
+}
+
+// verifyPiece downloads piece into a temporary directory and compares it
+// with what was uploaded. It returns the outcome and a description of it,
+// or an empty outcome when ctx was cancelled first.
+func verifyPiece(ctx context.Context, jobID string, piece models.Piece) (result, detail string) {
+	pdptoolPath, err := preparePdptool(ctx)
+	if err != nil {
+		return verifyResultFailed, err.Error()
+	}
+
+	tempDir, err := os.MkdirTemp("", "pdp-verify-*")
+	if err != nil {
+		return verifyResultFailed, fmt.Sprintf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	setJobTempDir(jobID, tempDir)
+
+	// The cache is bypassed, as its copy says nothing about whether the
+	// storage provider still has the file.
+	rawPath := filepath.Join(tempDir, "download")
+	if err := pieceDownloader(ctx, pdptoolPath, piece, tempDir)(rawPath, true); err != nil {
+		if ctx.Err() != nil {
+			return "", ""
+		}
+		var fetchErr *downloadFetchError
+		if errors.As(err, &fetchErr) {
+			return verifyResultUnreachable, fmt.Sprintf("The storage provider could not be reached after %d attempts: %s", fetchErr.Attempts, strings.TrimSpace(fetchErr.stderr))
+		}
+		return verifyResultFailed, err.Error()
+	}
+	raw, err := os.Open(rawPath)
+	if err != nil {
+		return verifyResultFailed, fmt.Sprintf("Failed to open downloaded file: %v", err)
+	}
+	defer raw.Close()
+
+	updateJobStatus(jobID, UploadProgress{
+		Status:   "verifying",
+		Progress: 50,
+		Message:  "Checking the downloaded file",
+		PieceID:  piece.ID,
+	})
+
+	if expected := baseCID(piece.CID); pieceCIDRegex.FindString(expected) == expected {
+		actual, err := preparedPieceCID(ctx, jobID, pdptoolPath, rawPath, piece.Size)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ""
+			}
+			return verifyResultFailed, err.Error()
+		}
+		if actual != expected {
+			return verifyResultMismatch, fmt.Sprintf("Expected piece CID %s, got %s", expected, actual)
+		}
+	}
+
+	if piece.Checksum == nil || *piece.Checksum == "" {
+		return verifyResultOK, "The file was retrieved and its piece CID matches; it has no stored checksum"
+	}
+	file, size, err := openPieceContent(piece, raw, tempDir)
+	if err != nil {
+		if errors.Is(err, errPieceDecompress) {
+			return verifyResultMismatch, err.Error()
+		}
+		return verifyResultFailed, err.Error()
+	}
+	if file != raw {
+		defer file.Close()
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(file, 0, size)); err != nil {
+		return verifyResultFailed, fmt.Sprintf("Failed to read downloaded file: %v", err)
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != *piece.Checksum {
+		return verifyResultMismatch, fmt.Sprintf("Expected checksum %s, got %s", *piece.Checksum, actual)
+	}
+	return verifyResultOK, "The file was retrieved and matches its checksum and piece CID"
+}
+
+// preparedPieceCID runs prepare-piece on the file at path and returns the
+// piece CID it computes.
+func preparedPieceCID(ctx context.Context, jobID, pdptoolPath, path string, size int64) (string, error) {
+	prepareCtx, cancel := context.WithTimeout(ctx, prepareCommandTimeout(size))
+	defer cancel()
+
+	var output, errOutput bytes.Buffer
+	cmd := exec.CommandContext(prepareCtx, pdptoolPath, "prepare-piece", path)
+	cmd.Stdout = &output
+	cmd.Stderr = &errOutput
+	if err := runJobCommand(jobID, cmd); err != nil {
+		return "", fmt.Errorf("Failed to compute piece CID: %s", strings.TrimSpace(errOutput.String()))
+	}
+	cid := pieceCIDRegex.FindString(output.String())
+	if cid == "" {
+		return "", errors.New("Failed to compute piece CID: prepare-piece printed none")
+	}
+	return cid, nil
+}
+
+// recordPieceVerification stores the outcome of checking piece against what
+// was uploaded.
+func recordPieceVerification(piece models.Piece, result string, at time.Time) {
+	if err := db.Model(&piece).UpdateColumns(map[string]interface{}{
+		"last_verified_at": at,
+		"verify_result":    result,
+	}).Error; err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Warning("Failed to record piece verification")
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// assignFileVersionTx makes a new piece the next version of the user's file
+// with the same name, when versioning is enabled. Pieces with that name
+// stored before versioning was turned on are first numbered by age into a
+// group of their own. It must be called before the piece is created.
+func assignFileVersionTx(tx *gorm.DB, piece *models.Piece) error {
+	if !cfg.Upload.Versioning {
+		return nil
+	}
+
+	var latest models.Piece
+	err := tx.Where("user_id = ? AND filename = ?", piece.UserID, piece.Filename).
+		Order("version DESC, created_at DESC").
+		First(&latest).Error
+	if err == gorm.ErrRecordNotFound {
+		piece.FileGroupID = uuid.New().String()
+		piece.Version = 1
+		piece.IsCurrent = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	groupID := latest.FileGroupID
+	if groupID == "" {
+		groupID = uuid.New().String()
+	}
+
+	var ungrouped []models.Piece
+	if err := tx.Where("user_id = ? AND filename = ? AND file_group_id = ?", piece.UserID, piece.Filename, "").
+		Order("created_at ASC").
+		Find(&ungrouped).Error; err != nil {
+		return err
+	}
+	maxVersion := 0
+	if latest.FileGroupID != "" {
+		maxVersion = latest.Version
+	}
+	for _, p := range ungrouped {
+		maxVersion++
+		if err := tx.Model(&p).Updates(map[string]interface{}{
+			"file_group_id": groupID,
+			"version":       maxVersion,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Model(&models.Piece{}).
+		Where("file_group_id = ?", groupID).
+		Update("is_current", false).Error; err != nil {
+		return err
+	}
+
+	piece.FileGroupID = groupID
+	piece.Version = maxVersion + 1
+	piece.IsCurrent = true
+	return nil
+}
+
+// handOffCurrentVersion makes the newest remaining version of a removed
+// piece's file current, so deleting the current version doesn't leave the
+// group with nothing to list.
+func handOffCurrentVersion(piece models.Piece) error {
+	if piece.FileGroupID == "" || !piece.IsCurrent {
+		return nil
+	}
+
+	var next models.Piece
+	err := db.Where("file_group_id = ? AND id <> ? AND pending_removal = ?", piece.FileGroupID, piece.ID, false).
+		Order("version DESC").
+		First(&next).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return db.Model(&next).Update("is_current", true).Error
+}
+
+// @Summary Promote a file version
+// @Description Make an older version of a versioned file the current one, so it is listed in place of the newer versions
+// @Tags pieces
+// @Param id path int true "Piece ID of the version to promote"
+// @Produce json
+// @Success 200 {object} models.Piece
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/promote [post]
+func PromotePieceVersion(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var piece models.Piece
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&piece).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Piece not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch piece")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch piece",
+		})
+		return
+	}
+
+	if piece.FileGroupID == "" {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Piece is not a version of a file",
+		})
+		return
+	}
+	if piece.IsCurrent {
+		c.JSON(http.StatusOK, piece)
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Piece{}).
+			Where("file_group_id = ?", piece.FileGroupID).
+			Update("is_current", false).Error; err != nil {
+			return err
+		}
+		return tx.Model(&piece).Update("is_current", true).Error
+	})
+	if err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to promote piece version")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to promote version",
+		})
+		return
+	}
+
+	log.WithField("pieceID", piece.ID).
+		WithField("fileGroupID", piece.FileGroupID).
+		WithField("version", piece.Version).
+		Info("Promoted file version to current")
+	c.JSON(http.StatusOK, piece)
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultProofHistory is how far back the proof history goes when the
+	// request doesn't say.
+	defaultProofHistory = 30 * 24 * time.Hour
+	// maxProofRecords caps how many records one request returns; the
+	// newest are kept.
+	maxProofRecords = 10000
+)
+
+// proofRecorder periodically records the proving state of every ready proof
+// set, so users can see proofs being submitted over time. A proof set whose
+// service can't be reached is skipped for longer after each failure, up to
+// the configured maximum.
+type proofRecorder struct {
+	interval   time.Duration
+	retention  time.Duration
+	maxBackoff time.Duration
+	// window and grace are the epochs after a challenge before it counts
+	// as missed.
+	window   int64
+	grace    int64
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	// backoff holds the proof sets whose service couldn't be reached. Only
+	// the recorder's goroutine uses it.
+	backoff map[uint]proofRecordBackoff
+}
+
+type proofRecordBackoff struct {
+	failures int
+	until    time.Time
+}
+
+var proofs *proofRecorder
+
+func startProofRecorder(interval, retention, maxBackoff time.Duration, window, grace int64) *proofRecorder {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &proofRecorder{
+		interval:   interval,
+		retention:  retention,
+		maxBackoff: maxBackoff,
+		window:     window,
+		grace:      grace,
+		ctx:        ctx,
+		cancel:     cancel,
+		stopped:    make(chan struct{}),
+		backoff:    make(map[uint]proofRecordBackoff),
+	}
+
+	if interval <= 0 {
+		close(r.stopped)
+		log.Info("Proof recording disabled")
+		return r
+	}
+	go r.run()
+
+	log.WithField("interval", interval.String()).
+		WithField("retention", retention.String()).
+		Info("Proof recorder started")
+	return r
+}
+
+// stop cancels any running get-proof-set call and waits for the recorder
+// to exit.
+func (r *proofRecorder) stop() {
+	r.stopOnce.Do(r.cancel)
+	<-r.stopped
+}
+
+func (r *proofRecorder) run() {
+	defer close(r.stopped)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			r.recordAll()
+			r.prune()
+		}
+	}
+}
+
+// recordAll records the state of every ready proof set that isn't backing
+// off. The chain's epoch is read once for all of them.
+func (r *proofRecorder) recordAll() {
+	var proofSets []models.ProofSet
+	if err := db.Where("status = ?", proofSetReady).Find(&proofSets).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to load proof sets to record")
+		return
+	}
+	if len(proofSets) == 0 {
+		return
+	}
+
+	chainEpoch, err := fetchChainEpoch(r.ctx)
+	if err != nil {
+		log.WithField("error", err.Error()).Warning("Could not read the chain's epoch, proving health will be unknown")
+	}
+
+	for _, proofSet := range proofSets {
+		if r.ctx.Err() != nil {
+			return
+		}
+		if backoff, ok := r.backoff[proofSet.ID]; ok && backoff.until.After(time.Now()) {
+			continue
+		}
+		r.record(proofSet, chainEpoch)
+	}
+}
+
+// record asks the service for proofSet's state, stores it and updates the
+// proof set's proving health.
+func (r *proofRecorder) record(proofSet models.ProofSet, chainEpoch *int64) {
+	status, checkedAt, err := fetchProofSetStatus(r.ctx, proofSet.ServiceURL, proofSet.ServiceName, proofSet.ProofSetID)
+	if r.ctx.Err() != nil {
+		return
+	}
+	record := models.ProofRecord{
+		ProofSetID: proofSet.ID,
+		UserID:     proofSet.UserID,
+		CheckedAt:  checkedAt.UTC(),
+	}
+
+	if err != nil {
+		record.Error = err.Error()
+		backoff := r.backoff[proofSet.ID]
+		backoff.failures++
+		backoff.until = time.Now().Add(retryDelay(r.interval, r.maxBackoff, backoff.failures))
+		r.backoff[proofSet.ID] = backoff
+		log.WithField("proofSetID", proofSet.ID).
+			WithField("failures", backoff.failures).
+			WithField("retryAt", backoff.until).
+			Warning("Could not record proof set state, backing off")
+	} else {
+		delete(r.backoff, proofSet.ID)
+		record.Reachable = true
+		record.NextChallengeEpoch = status.NextChallengeEpoch
+		record.LastProvenEpoch = status.LastProvenEpoch
+		record.LastProofTx = status.LastProofTx
+		record.RootCount = len(status.Roots)
+		record.ChainEpoch = chainEpoch
+
+		var previous models.ProofRecord
+		err := db.Where("proof_set_id = ? AND reachable = ?", proofSet.ID, true).
+			Order("checked_at DESC, id DESC").
+			First(&previous).Error
+		if err == nil {
+			record.Missed = missedProof(previous, record)
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Warning("Failed to load previous proof record")
+		}
+	}
+
+	if err := db.Create(&record).Error; err != nil {
+		log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to save proof record")
+		return
+	}
+	if record.Missed {
+		log.WithField("proofSetID", proofSet.ID).
+			WithField("lastProvenEpoch", record.LastProvenEpoch).
+			Warning("Proof set missed a proof")
+	}
+	setProvingHealth(proofSet, nextProvingHealth(proofSet.ProvingHealth, record, r.window, r.grace), record)
+}
+
+// missedProof reports whether the challenge that was next at previous came
+// due without being proven by current: a later challenge is now next, but
+// the last proven epoch is still before it. Without both epochs nothing
+// can be told.
+func missedProof(previous, current models.ProofRecord) bool {
+	if previous.NextChallengeEpoch == nil || current.NextChallengeEpoch == nil || current.LastProvenEpoch == nil {
+		return false
+	}
+	due := *previous.NextChallengeEpoch
+	return *current.NextChallengeEpoch > due && *current.LastProvenEpoch < due
+}
+
+// prune deletes records older than the retention period.
+func (r *proofRecorder) prune() {
+	if r.retention <= 0 {
+		return
+	}
+	result := db.Where("checked_at < ?", time.Now().Add(-r.retention)).Delete(&models.ProofRecord{})
+	if result.Error != nil {
+		log.WithField("error", result.Error.Error()).Error("Failed to prune proof records")
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.WithField("count", result.RowsAffected).Info("Pruned old proof records")
+	}
+}
+
+// ProofHistoryResponse is a proof set's proving history
+// @Description Records of a proof set's proving state since a time, oldest first. missed records are those where a challenge came due without being proven; unreachable ones where the service couldn't be asked. uptime is the share of records that were reachable and didn't miss a proof.
+type ProofHistoryResponse struct {
+	ProofSetDbID uint                 `json:"proofSetDbId"`
+	ProofSetID   string               `json:"proofSetId"`
+	Since        time.Time            `json:"since"`
+	Records      []models.ProofRecord `json:"records"`
+	Checks       int                  `json:"checks"`
+	Unreachable  int                  `json:"unreachable"`
+	MissedProofs int                  `json:"missedProofs"`
+	Uptime       float64              `json:"uptime"`
+	Truncated    bool                 `json:"truncated"`
+}
+
+// @Summary Get a proof set's proving history
+// @Description Get the proving state recorded for one of the caller's proof sets over time, for charting: next challenge epoch, last proven epoch, last proof transaction when the service reports it, and whether a proof was missed. States are recorded every PROOF_RECORD_INTERVAL while the proof set is ready. At most 10000 records are returned, the newest, with truncated set when there were more.
+// @Tags proofset
+// @Param id path int true "Proof set database ID"
+// @Param since query string false "Only records from this time, RFC 3339 or a date (default 30 days ago)"
+// @Produce json
+// @Success 200 {object} ProofHistoryResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/proof-sets/{id}/proofs [get]
+func GetProofHistory(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	since := time.Now().Add(-defaultProofHistory).UTC()
+	if raw := c.Query("since"); raw != "" {
+		at, err := parseFilterTime(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "since must be an RFC 3339 time or a date",
+			})
+			return
+		}
+		since = at.UTC()
+	}
+
+	proofSet, err := findUserProofSet(c.Param("id"), userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Proof set not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch proof set")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch proof set",
+		})
+		return
+	}
+
+	records := []models.ProofRecord{}
+	if err := db.Where("proof_set_id = ? AND checked_at >= ?", proofSet.ID, since).
+		Order("checked_at DESC, id DESC").
+		Limit(maxProofRecords + 1).
+		Find(&records).Error; err != nil {
+		log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to fetch proof records")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch proof history",
+		})
+		return
+	}
+
+	response := ProofHistoryResponse{
+		ProofSetDbID: proofSet.ID,
+		ProofSetID:   proofSet.ProofSetID,
+		Since:        since,
+		Truncated:    len(records) > maxProofRecords,
+	}
+	if response.Truncated {
+		records = records[:maxProofRecords]
+	}
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	response.Records = records
+
+	healthy := 0
+	for _, record := range records {
+		switch {
+		case !record.Reachable:
+			response.Unreachable++
+		case record.Missed:
+			response.MissedProofs++
+		default:
+			healthy++
+		}
+	}
+	response.Checks = len(records)
+	if response.Checks > 0 {
+		response.Uptime = float64(healthy) / float64(response.Checks)
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,562 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Statuses of a proof set. A creation goes from pending through submitting,
+// while create-proof-set runs, and confirming, while its transaction
+// settles, to ready, and can fail on the way; a failed creation can be
+// submitted again. A ready proof set whose decommission was requested is
+// decommissioning until the service drops its last root, then
+// decommissioned and deleted.
+const (
+	proofSetPending         = "pending"
+	proofSetSubmitting      = "submitting"
+	proofSetConfirming      = "confirming"
+	proofSetReady           = "ready"
+	proofSetFailed          = "failed"
+	proofSetDecommissioning = "decommissioning"
+	proofSetDecommissioned  = "decommissioned"
+)
+
+// proofSetTransitions lists the statuses a proof set can move to from each
+// status.
+var proofSetTransitions = map[string][]string{
+	proofSetPending:         {proofSetSubmitting, proofSetFailed},
+	proofSetSubmitting:      {proofSetConfirming, proofSetFailed},
+	proofSetConfirming:      {proofSetReady, proofSetFailed},
+	proofSetFailed:          {proofSetSubmitting},
+	proofSetReady:           {proofSetDecommissioning},
+	proofSetDecommissioning: {proofSetDecommissioned},
+}
+
+var (
+	// errProofSetTransition is returned by transitionProofSet when the
+	// proof set's status doesn't allow the move.
+	errProofSetTransition = errors.New("proof set status does not allow the change")
+
+	// errProofSetNotReady is reported for uploads to a proof set that is
+	// still being created.
+	errProofSetNotReady = errors.New("Proof set creation is still pending. Please wait.")
+
+	// errProofSetDecommissioning is reported for uploads to a proof set
+	// whose decommission was requested.
+	errProofSetDecommissioning = errors.New("Proof set is being decommissioned and no longer accepts uploads")
+
+	errProofSetNameTaken = errors.New("You already have a proof set with this name")
+)
+
+const (
+	maxProofSetNameLength        = 100
+	maxProofSetDescriptionLength = 1000
+)
+
+// UpdateProofSetRequest renames or describes a proof set
+// @Description Fields to change. Fields left out keep their value; an empty description clears it.
+type UpdateProofSetRequest struct {
+	Name        *string `json:"name" example:"Team photos"`
+	Description *string `json:"description" example:"Originals from the 2024 offsite"`
+}
+
+// proofSetName trims name and checks its length.
+func proofSetName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("name must not be empty")
+	}
+	if utf8.RuneCountInString(name) > maxProofSetNameLength {
+		return "", fmt.Errorf("name must be at most %d characters", maxProofSetNameLength)
+	}
+	return name, nil
+}
+
+// proofSetDescription trims description and checks its length.
+func proofSetDescription(description string) (string, error) {
+	description = strings.TrimSpace(description)
+	if utf8.RuneCountInString(description) > maxProofSetDescriptionLength {
+		return "", fmt.Errorf("description must be at most %d characters", maxProofSetDescriptionLength)
+	}
+	return description, nil
+}
+
+// checkProofSetName returns errProofSetNameTaken when another of the user's
+// proof sets than exceptID is called name.
+func checkProofSetName(tx *gorm.DB, userID interface{}, name string, exceptID uint) error {
+	var count int64
+	if err := tx.Model(&models.ProofSet{}).
+		Where("user_id = ? AND name = ? AND id <> ?", userID, name, exceptID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errProofSetNameTaken
+	}
+	return nil
+}
+
+// transitionProofSet moves proofSet to status to, applying updates with it.
+// The change only applies while the stored status is one to can be reached
+// from, so of two racing transitions one gets errProofSetTransition. The
+// failure reason is cleared unless to is failed. On success proofSet is
+// reloaded.
+func transitionProofSet(tx *gorm.DB, proofSet *models.ProofSet, to string, updates map[string]interface{}) error {
+	var from []string
+	for status, next := range proofSetTransitions {
+		for _, candidate := range next {
+			if candidate == to {
+				from = append(from, status)
+			}
+		}
+	}
+
+	columns := map[string]interface{}{"status": to}
+	if to != proofSetFailed {
+		columns["failure_reason"] = ""
+	}
+	for column, value := range updates {
+		columns[column] = value
+	}
+	result := tx.Model(&models.ProofSet{}).Where("id = ? AND status IN ?", proofSet.ID, from).Updates(columns)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %s to %s", errProofSetTransition, proofSet.Status, to)
+	}
+	return tx.Unscoped().First(proofSet, proofSet.ID).Error
+}
+
+// proofSetUploadError explains why files can't be uploaded to proofSet, or
+// returns nil when they can. Only errProofSetNotReady clears up by waiting.
+func proofSetUploadError(proofSet models.ProofSet) error {
+	switch proofSet.Status {
+	case proofSetReady:
+		return nil
+	case proofSetFailed:
+		return fmt.Errorf("Proof set creation failed: %s. Create the proof set again to upload.", proofSet.FailureReason)
+	case proofSetDecommissioning, proofSetDecommissioned:
+		return errProofSetDecommissioning
+	default:
+		return errProofSetNotReady
+	}
+}
+
+// findUserProofSet loads one of the user's proof sets, including
+// decommissioned ones.
+func findUserProofSet(id string, userID interface{}) (models.ProofSet, error) {
+	var proofSet models.ProofSet
+	err := db.Unscoped().Where("id = ? AND user_id = ?", id, userID).First(&proofSet).Error
+	return proofSet, err
+}
+
+func proofSetResponse(proofSet models.ProofSet) (ProofSetWithPieces, error) {
+	pieceIDs := []uint{}
+	if err := db.Model(&models.Piece{}).Where("proof_set_id = ?", proofSet.ID).Order("id ASC").Pluck("id", &pieceIDs).Error; err != nil {
+		return ProofSetWithPieces{}, err
+	}
+	aggregates, err := proofSetAggregates([]uint{proofSet.ID})
+	if err != nil {
+		return ProofSetWithPieces{}, err
+	}
+	return ProofSetWithPieces{
+		ID:                 proofSet.ID,
+		ProofSetID:         proofSet.ProofSetID,
+		Name:               proofSet.Name,
+		Description:        proofSet.Description,
+		TransactionHash:    proofSet.TransactionHash,
+		ServiceName:        proofSet.ServiceName,
+		ServiceURL:         proofSet.ServiceURL,
+		Status:             proofSet.Status,
+		FailureReason:      proofSet.FailureReason,
+		ProvingHealth:      proofSet.ProvingHealth,
+		ProvingHealthy:     provingHealthFlag(proofSet.ProvingHealth),
+		PieceIDs:           pieceIDs,
+		CreatedAt:          proofSet.CreatedAt,
+		UpdatedAt:          proofSet.UpdatedAt,
+		ProofSetAggregates: aggregates[proofSet.ID],
+	}, nil
+}
+
+// @Summary Get a proof set
+// @Description Get one of the caller's proof sets by its database ID, with the pieces still in it. status is pending, submitting or confirming while it is being created, ready, failed with a failureReason when its creation failed, decommissioning while it is being torn down, or decommissioned once it is gone.
+// @Tags proofset
+// @Param id path int true "Proof set database ID"
+// @Produce json
+// @Success 200 {object} ProofSetWithPieces
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/proof-sets/{id} [get]
+func GetProofSet(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	proofSet, err := findUserProofSet(c.Param("id"), userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Proof set not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch proof set")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch proof set",
+		})
+		return
+	}
+
+	response, err := proofSetResponse(proofSet)
+	if err != nil {
+		log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to fetch proof set pieces")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch proof set",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Rename or describe a proof set
+// @Description Change the name or description of one of the caller's proof sets. Names are trimmed, must be 1 to 100 characters and unique among the caller's proof sets; descriptions may be up to 1000 characters.
+// @Tags proofset
+// @Accept json
+// @Param id path int true "Proof set database ID"
+// @Param request body UpdateProofSetRequest true "Fields to change"
+// @Produce json
+// @Success 200 {object} ProofSetWithPieces
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /api/v1/proof-sets/{id} [patch]
+func UpdateProofSet(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var request UpdateProofSetRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+
+	updates := make(map[string]interface{})
+	if request.Name != nil {
+		name, err := proofSetName(*request.Name)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		updates["name"] = name
+	}
+	if request.Description != nil {
+		description, err := proofSetDescription(*request.Description)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		updates["description"] = description
+	}
+
+	var proofSet models.ProofSet
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&proofSet).Error; err != nil {
+			return err
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		if name, ok := updates["name"]; ok {
+			if err := checkProofSetName(tx, userID, name.(string), proofSet.ID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&proofSet).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.First(&proofSet, proofSet.ID).Error
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Proof set not found",
+			})
+		case errors.Is(err, errProofSetNameTaken), isDuplicateKey(err):
+			c.JSON(http.StatusConflict, gin.H{
+				"error": errProofSetNameTaken.Error(),
+			})
+		default:
+			log.WithField("error", err.Error()).Error("Failed to update proof set")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to update proof set",
+			})
+		}
+		return
+	}
+
+	response, err := proofSetResponse(proofSet)
+	if err != nil {
+		log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to fetch proof set pieces")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch proof set",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Decommission a proof set
+// @Description Tear down one of the caller's proof sets once every file in it has been removed. The proof set stops taking uploads and becomes decommissioning; once the storage provider lists no roots in it, it becomes decommissioned and is deleted. Refused with 409 while files that aren't pending removal are still in it, or unless it is ready.
+// @Tags proofset
+// @Param id path int true "Proof set database ID"
+// @Produce json
+// @Success 202 {object} ProofSetWithPieces
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/proof-sets/{id} [delete]
+func DecommissionProofSet(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	proofSet, err := findUserProofSet(c.Param("id"), userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Proof set not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch proof set")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch proof set",
+		})
+		return
+	}
+
+	switch {
+	case proofSet.Status == proofSetDecommissioned:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Proof set has already been decommissioned",
+		})
+		return
+	case proofSet.Status == proofSetFailed:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Proof set creation failed, so there is nothing to decommission",
+		})
+		return
+	case proofSet.Status != proofSetReady && proofSet.Status != proofSetDecommissioning:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Proof set is still being created",
+		})
+		return
+	}
+
+	if proofSet.Status == proofSetReady {
+		var remaining int64
+		if err := db.Model(&models.Piece{}).
+			Where("proof_set_id = ? AND pending_removal = ?", proofSet.ID, false).
+			Count(&remaining).Error; err != nil {
+			log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to count proof set pieces")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to decommission proof set",
+			})
+			return
+		}
+		if remaining > 0 {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":     "Remove every file in the proof set before decommissioning it",
+				"remaining": remaining,
+			})
+			return
+		}
+		// Uploads check the status before adding a piece, so none can land
+		// in the proof set from here on.
+		if err := transitionProofSet(db, &proofSet, proofSetDecommissioning, nil); err != nil {
+			if errors.Is(err, errProofSetTransition) {
+				c.JSON(http.StatusConflict, gin.H{
+					"error": "Proof set changed while it was being decommissioned. Please try again",
+				})
+				return
+			}
+			log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to request proof set decommission")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to decommission proof set",
+			})
+			return
+		}
+		log.WithField("proofSetID", proofSet.ID).WithField("userID", proofSet.UserID).Info("Requested proof set decommission")
+		publishUserEvent(proofSet.UserID, WSTypeProofSetStatus, proofSetEventFromModel(proofSet))
+	}
+
+	proofSetPolls.watchDecommission(proofSet)
+
+	response, err := proofSetResponse(proofSet)
+	if err != nil {
+		log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to fetch proof set pieces")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch proof set",
+		})
+		return
+	}
+	c.JSON(http.StatusAccepted, response)
+}
+
+// ProofSetRootResponse is one root of a proof set.
+type ProofSetRootResponse struct {
+	RootID      string   `json:"rootId"`
+	CID         string   `json:"cid"`
+	SubrootCIDs []string `json:"subrootCids,omitempty"`
+}
+
+// ProofSetStatusResponse is a proof set as reported by its service.
+// @Description A proof set's state on its storage provider. live is false when the service couldn't be asked, or the proof set isn't on it yet or anymore; roots then lists what is recorded locally, and the epochs and fields are left out. Live results may be up to 30 seconds old. provingHealth is the proof set's proving health as last recorded: healthy, missed or unknown.
+type ProofSetStatusResponse struct {
+	ID                 uint                   `json:"id"`
+	ProofSetID         string                 `json:"proofSetId"`
+	Status             string                 `json:"status"`
+	ProvingHealth      string                 `json:"provingHealth"`
+	ProvingHealthy     *bool                  `json:"provingHealthy"`
+	Live               bool                   `json:"live"`
+	NextChallengeEpoch *int64                 `json:"nextChallengeEpoch,omitempty"`
+	LastProvenEpoch    *int64                 `json:"lastProvenEpoch,omitempty"`
+	RootCount          int                    `json:"rootCount"`
+	Roots              []ProofSetRootResponse `json:"roots"`
+	Fields             map[string]string      `json:"fields,omitempty"`
+	ServiceUnreachable bool                   `json:"serviceUnreachable"`
+	Error              string                 `json:"error,omitempty"`
+	CheckedAt          time.Time              `json:"checkedAt"`
+}
+
+// storedProofSetRoots lists the roots of proofSet known from its pieces.
+func storedProofSetRoots(proofSet models.ProofSet) ([]ProofSetRootResponse, error) {
+	var pieces []models.Piece
+	if err := db.Select("id", "c_id", "root_id").
+		Where("proof_set_id = ? AND root_id IS NOT NULL", proofSet.ID).
+		Order("id ASC").
+		Find(&pieces).Error; err != nil {
+		return nil, err
+	}
+	roots := make([]ProofSetRootResponse, 0, len(pieces))
+	for _, piece := range pieces {
+		root := ProofSetRootResponse{RootID: *piece.RootID, CID: baseCID(piece.CID)}
+		if subroot := subrootCID(piece.CID); subroot != "" {
+			root.SubrootCIDs = []string{subroot}
+		}
+		roots = append(roots, root)
+	}
+	return roots, nil
+}
+
+// @Summary Get a proof set's live status
+// @Description Ask the storage provider for one of the caller's proof sets: its roots, next challenge epoch, last proven epoch when reported, and every field get-proof-set printed. When the service can't be reached, serviceUnreachable is set and the roots recorded locally are returned with live false.
+// @Tags proofset
+// @Param id path int true "Proof set database ID"
+// @Produce json
+// @Success 200 {object} ProofSetStatusResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/proof-sets/{id}/status [get]
+func GetProofSetStatus(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	proofSet, err := findUserProofSet(c.Param("id"), userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Proof set not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch proof set")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch proof set",
+		})
+		return
+	}
+
+	response := ProofSetStatusResponse{
+		ID:             proofSet.ID,
+		ProofSetID:     proofSet.ProofSetID,
+		Status:         proofSet.Status,
+		ProvingHealth:  proofSet.ProvingHealth,
+		ProvingHealthy: provingHealthFlag(proofSet.ProvingHealth),
+		CheckedAt:      time.Now().UTC(),
+	}
+
+	switch {
+	case proofSet.Status == proofSetFailed:
+		response.Error = "Proof set creation failed: " + proofSet.FailureReason
+	case proofSet.ProofSetID == "":
+		response.Error = "Proof set is still being created"
+	case proofSet.Status == proofSetDecommissioned:
+		response.Error = "Proof set has been decommissioned"
+	default:
+		status, checkedAt, err := fetchProofSetStatus(c.Request.Context(), proofSet.ServiceURL, proofSet.ServiceName, proofSet.ProofSetID)
+		response.CheckedAt = checkedAt.UTC()
+		if err != nil {
+			response.ServiceUnreachable = errors.Is(err, errServiceUnreachable)
+			response.Error = err.Error()
+			break
+		}
+		response.Live = true
+		response.NextChallengeEpoch = status.NextChallengeEpoch
+		response.LastProvenEpoch = status.LastProvenEpoch
+		response.Fields = status.Fields
+		response.Roots = make([]ProofSetRootResponse, 0, len(status.Roots))
+		for _, root := range status.Roots {
+			response.Roots = append(response.Roots, ProofSetRootResponse{
+				RootID:      root.RootID,
+				CID:         root.CID,
+				SubrootCIDs: root.SubrootCIDs,
+			})
+		}
+	}
+
+	if !response.Live {
+		if response.Roots, err = storedProofSetRoots(proofSet); err != nil {
+			log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to fetch proof set roots")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to fetch proof set",
+			})
+			return
+		}
+	}
+	response.RootCount = len(response.Roots)
+
+	c.JSON(http.StatusOK, response)
+}
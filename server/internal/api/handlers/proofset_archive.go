@@ -0,0 +1,493 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hotvault/backend/internal/models"
+)
+
+// archiveManifestName is the file in every proof set archive describing the
+// pieces it holds and those that couldn't be fetched.
+const archiveManifestName = "manifest.json"
+
+// archiveManifest is written to manifest.json in a proof set archive.
+type archiveManifest struct {
+	ProofSetID string                 `json:"proofSetId"`
+	CreatedAt  time.Time              `json:"createdAt"`
+	Files      []archiveManifestEntry `json:"files"`
+	Failed     []archiveManifestEntry `json:"failed,omitempty"`
+}
+
+type archiveManifestEntry struct {
+	Path     string  `json:"path,omitempty"`
+	PieceID  uint    `json:"pieceId"`
+	CID      string  `json:"cid"`
+	RootID   *string `json:"rootId"`
+	Filename string  `json:"filename"`
+	Size     int64   `json:"size"`
+	Checksum *string `json:"checksum"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// ProofSetArchiveJobResponse is returned when a proof set is too large to
+// archive while the client waits.
+type ProofSetArchiveJobResponse struct {
+	JobID       string `json:"jobId"`
+	StatusURL   string `json:"statusUrl"`
+	DownloadURL string `json:"downloadUrl"`
+	TotalSize   int64  `json:"totalSize"`
+}
+
+// archivesDir holds archives built by archive jobs until the job is removed.
+func archivesDir() string {
+	return filepath.Join(os.TempDir(), "hotvault-archives")
+}
+
+func archiveJobPath(jobID string) string {
+	return filepath.Join(archivesDir(), jobID+".zip")
+}
+
+// @Summary Download a proof set as a zip archive
+// @Description Download every file in one of the caller's proof sets as a zip, with a manifest.json listing each file's CID, root ID, size and checksum. Files that can't be fetched, or don't match their checksum, are left out and listed under "failed" in the manifest; their count is sent in the X-Archive-Failed trailer. Proof sets larger than the synchronous limit, or any with async=true, are archived by a background job instead and 202 is returned.
+// @Tags pieces
+// @Param id path int true "Proof set ID"
+// @Param async query bool false "Build the archive in the background even if it is small enough to stream"
+// @Produce application/zip
+// @Success 200 {file} binary "Zip archive"
+// @Success 202 {object} ProofSetArchiveJobResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Router /api/v1/pieces/proof-sets/{id}/archive [get]
+func DownloadProofSetArchive(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	// Other users' proof sets are reported as not found, so their existence
+	// isn't revealed.
+	var proofSet models.ProofSet
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&proofSet).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Proof set not found",
+		})
+		return
+	}
+
+	var pieces []models.Piece
+	if err := db.Where("proof_set_id = ? AND user_id = ? AND pending_removal = ?", proofSet.ID, userID, false).
+		Order("id ASC").
+		Find(&pieces).Error; err != nil {
+		log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to fetch proof set pieces")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch pieces",
+		})
+		return
+	}
+	if len(pieces) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Proof set has no files to archive",
+		})
+		return
+	}
+
+	var totalSize int64
+	for _, piece := range pieces {
+		totalSize += piece.Size
+	}
+	if cfg.Download.ArchiveMaxSize > 0 && totalSize > cfg.Download.ArchiveMaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":     fmt.Sprintf("Proof set holds %s, archives are limited to %s", formatFileSize(totalSize), formatFileSize(cfg.Download.ArchiveMaxSize)),
+			"totalSize": totalSize,
+			"maxSize":   cfg.Download.ArchiveMaxSize,
+		})
+		return
+	}
+
+	filename := fmt.Sprintf("proof-set-%s.zip", proofSet.ProofSetID)
+
+	if c.Query("async") == "true" || totalSize > cfg.Download.ArchiveSyncMaxSize {
+		jobID := uuid.New().String()
+		createUploadJob(jobID, userID.(uint), UploadProgress{
+			Status:     "archiving",
+			Message:    "Preparing archive...",
+			Filename:   filename,
+			TotalSize:  totalSize,
+			ProofSetID: proofSet.ProofSetID,
+		})
+		go buildProofSetArchive(jobID, proofSet, pieces)
+
+		c.JSON(http.StatusAccepted, ProofSetArchiveJobResponse{
+			JobID:       jobID,
+			StatusURL:   "/api/v1/upload/status/" + jobID,
+			DownloadURL: "/api/v1/pieces/proof-sets/archives/" + jobID,
+			TotalSize:   totalSize,
+		})
+		return
+	}
+
+	release, ok := limitDownload(c, userID.(uint))
+	if !ok {
+		return
+	}
+	defer release()
+
+	pdptoolPath, err := preparePdptool(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", contentDisposition("attachment", filename))
+	c.Header("Cache-Control", "private, no-cache, no-store, must-revalidate")
+	// The archive is streamed, so how many files were left out is only
+	// known at the end.
+	c.Header("Trailer", "X-Archive-Failed")
+	c.Status(http.StatusOK)
+
+	counter := &countingResponseWriter{ResponseWriter: c.Writer}
+	manifest, err := writeProofSetArchive(c.Request.Context(), counter, pdptoolPath, proofSet, pieces, nil)
+	recordUsage(userID.(uint), 0, usageDirectionDownload, counter.n)
+	if err != nil {
+		log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to stream proof set archive")
+		return
+	}
+	c.Writer.Header().Set("X-Archive-Failed", strconv.Itoa(len(manifest.Failed)))
+
+	log.WithField("proofSetID", proofSet.ID).
+		WithField("files", len(manifest.Files)).
+		WithField("failed", len(manifest.Failed)).
+		WithField("bytesSent", counter.n).
+		Info("Sent proof set archive")
+}
+
+// @Summary Download an archive built by a job
+// @Description Download the proof set archive built by a background archive job once its status is complete or partial.
+// @Tags pieces
+// @Param jobId path string true "Archive job ID"
+// @Produce application/zip
+// @Success 200 {file} binary "Zip archive"
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Router /api/v1/pieces/proof-sets/archives/{jobId} [get]
+func DownloadProofSetArchiveJob(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	jobID := c.Param("jobId")
+	progress, ownerID, ok := getUploadJob(jobID)
+	if !ok || ownerID != userID.(uint) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Archive job not found",
+		})
+		return
+	}
+	if progress.Status != "complete" && progress.Status != "partial" {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  "Archive is not ready",
+			"status": progress.Status,
+		})
+		return
+	}
+
+	file, err := os.Open(archiveJobPath(jobID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Archive has expired",
+		})
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("Failed to get file info: %v", err),
+		})
+		return
+	}
+
+	release, ok := limitDownload(c, ownerID)
+	if !ok {
+		return
+	}
+	defer release()
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", contentDisposition("attachment", progress.Filename))
+	if _, err := serveDownload(c, ownerID, 0, info.ModTime(), file, info.Size()); err != nil {
+		log.WithField("jobID", jobID).WithField("error", err.Error()).Error("Failed to send proof set archive")
+	}
+}
+
+// buildProofSetArchive writes a proof set's archive to disk for an archive
+// job, reporting progress through the job's status.
+func buildProofSetArchive(jobID string, proofSet models.ProofSet, pieces []models.Piece) {
+	ctx := jobContext(jobID)
+
+	fail := func(message string, err error) {
+		log.WithField("jobID", jobID).WithField("error", err.Error()).Error(message)
+		updateJobStatus(jobID, UploadProgress{
+			Status:  "error",
+			Message: message,
+			Error:   err.Error(),
+		})
+	}
+
+	pdptoolPath, err := preparePdptool(ctx)
+	if err != nil {
+		fail("Failed to prepare pdptool", err)
+		return
+	}
+	if err := os.MkdirAll(archivesDir(), 0750); err != nil {
+		fail("Failed to create archive directory", err)
+		return
+	}
+	tmp, err := os.CreateTemp(archivesDir(), "."+jobID+"-*")
+	if err != nil {
+		fail("Failed to create archive", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	var totalSize int64
+	for _, piece := range pieces {
+		totalSize += piece.Size
+	}
+	onProgress := func(done int, bytes int64) {
+		updateJobStatus(jobID, UploadProgress{
+			Status:   "archiving",
+			Progress: uploadPercent(bytes, totalSize),
+			Message:  fmt.Sprintf("Fetched %d of %d files", done, len(pieces)),
+		})
+	}
+
+	manifest, err := writeProofSetArchive(ctx, tmp, pdptoolPath, proofSet, pieces, onProgress)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// Cancelled through the API, which has set the status already.
+			return
+		}
+		fail("Failed to build archive", err)
+		return
+	}
+	if len(manifest.Files) == 0 {
+		fail("No files could be fetched", fmt.Errorf("all %d files failed", len(manifest.Failed)))
+		return
+	}
+	if err := os.Rename(tmp.Name(), archiveJobPath(jobID)); err != nil {
+		fail("Failed to save archive", err)
+		return
+	}
+
+	progress := UploadProgress{
+		Status:   "complete",
+		Progress: 100,
+		Message:  fmt.Sprintf("Archive of %d files ready", len(manifest.Files)),
+	}
+	if len(manifest.Failed) > 0 {
+		progress.Status = "partial"
+		progress.Message = fmt.Sprintf("Archive of %d files ready, %d could not be fetched", len(manifest.Files), len(manifest.Failed))
+	}
+	updateJobStatus(jobID, progress)
+	log.WithField("jobID", jobID).
+		WithField("proofSetID", proofSet.ID).
+		WithField("files", len(manifest.Files)).
+		WithField("failed", len(manifest.Failed)).
+		Info("Built proof set archive")
+}
+
+// writeProofSetArchive fetches each piece and writes it to a zip on w,
+// followed by the manifest. Pieces that can't be fetched, or don't match
+// their checksum, are left out and listed as failed in the manifest. The
+// error is only set when the archive itself couldn't be written. onProgress,
+// when set, is called after each piece with how many pieces and bytes have
+// been handled.
+func writeProofSetArchive(ctx context.Context, w io.Writer, pdptoolPath string, proofSet models.ProofSet, pieces []models.Piece, onProgress func(done int, bytes int64)) (archiveManifest, error) {
+	manifest := archiveManifest{
+		ProofSetID: proofSet.ProofSetID,
+		CreatedAt:  time.Now(),
+		Files:      []archiveManifestEntry{},
+	}
+	zw := zip.NewWriter(w)
+	names := map[string]bool{archiveManifestName: true}
+
+	var handled int64
+	for i, piece := range pieces {
+		if err := ctx.Err(); err != nil {
+			return manifest, err
+		}
+
+		entry := archiveManifestEntry{
+			PieceID:  piece.ID,
+			CID:      piece.CID,
+			RootID:   piece.RootID,
+			Filename: piece.Filename,
+			Size:     piece.Size,
+			Checksum: piece.Checksum,
+		}
+		entry.Path = uniqueArchiveName(names, piece)
+
+		written, err := addPieceToArchive(ctx, zw, pdptoolPath, piece, entry.Path)
+		if err != nil {
+			if written {
+				// The zip itself is broken past this point.
+				return manifest, err
+			}
+			log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Warning("Left piece out of proof set archive")
+			delete(names, entry.Path)
+			entry.Path = ""
+			entry.Error = err.Error()
+			manifest.Failed = append(manifest.Failed, entry)
+		} else {
+			manifest.Files = append(manifest.Files, entry)
+		}
+
+		handled += piece.Size
+		if onProgress != nil {
+			onProgress(i+1, handled)
+		}
+	}
+
+	manifestWriter, err := zw.Create(archiveManifestName)
+	if err != nil {
+		return manifest, err
+	}
+	encoder := json.NewEncoder(manifestWriter)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return manifest, err
+	}
+	if err := zw.Close(); err != nil {
+		return manifest, err
+	}
+
+	archived := make([]uint, len(manifest.Files))
+	for i, entry := range manifest.Files {
+		archived[i] = entry.PieceID
+	}
+	countPieceDownloads(archived...)
+	return manifest, nil
+}
+
+// addPieceToArchive fetches a piece, checks it against its checksum and adds
+// it to zw as name. written reports whether anything went into the zip, in
+// which case a failure leaves it unusable.
+func addPieceToArchive(ctx context.Context, zw *zip.Writer, pdptoolPath string, piece models.Piece, name string) (written bool, err error) {
+	tempDir, err := os.MkdirTemp("", "pdp-archive-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	downloadTo := pieceDownloader(ctx, pdptoolPath, piece, tempDir)
+	raw, err := openRawPiece(piece, tempDir, func(outputFile string) error {
+		return downloadTo(outputFile, true)
+	})
+	if err != nil {
+		return false, err
+	}
+	defer raw.Close()
+
+	file, size, err := openPieceContent(piece, raw, tempDir)
+	if err != nil {
+		return false, err
+	}
+	if file != raw {
+		defer file.Close()
+	}
+	content := io.NewSectionReader(file, 0, size)
+
+	if piece.Checksum != nil && *piece.Checksum != "" {
+		hasher := sha256.New()
+		if _, err := io.Copy(hasher, content); err != nil {
+			return false, err
+		}
+		if actual := hex.EncodeToString(hasher.Sum(nil)); actual != *piece.Checksum {
+			if downloads != nil {
+				downloads.remove(baseCID(piece.CID))
+			}
+			return false, fmt.Errorf("integrity_failure: expected checksum %s, got %s", *piece.Checksum, actual)
+		}
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+	}
+
+	// Stored pieces are mostly media or already compressed, so they are
+	// stored rather than deflated again.
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: piece.CreatedAt,
+	}
+	entryWriter, err := zw.CreateHeader(header)
+	if err != nil {
+		return true, err
+	}
+	if _, err := io.Copy(entryWriter, content); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// uniqueArchiveName picks the path of a piece in an archive, adding the
+// piece ID to names already taken, and records it in names.
+func uniqueArchiveName(names map[string]bool, piece models.Piece) string {
+	name := sanitizeFilename(piece.Filename)
+	if names[name] {
+		ext := filepath.Ext(name)
+		name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), piece.ID, ext)
+	}
+	names[name] = true
+	return name
+}
+
+// removeExpiredArchives deletes archives built by jobs that finished before
+// cutoff, along with leftovers of archive jobs interrupted by a restart.
+func removeExpiredArchives(cutoff time.Time) int {
+	entries, err := os.ReadDir(archivesDir())
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if os.Remove(filepath.Join(archivesDir(), entry.Name())) == nil {
+			removed++
+		}
+	}
+	return removed
+}
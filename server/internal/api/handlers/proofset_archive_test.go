@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+)
+
+// fakePdptoolServingPieces installs a fake pdptool whose download-file
+// writes the data stored under the base CID it is asked for, and fails as a
+// missing piece for any other CID. It returns the path of its calls file.
+func fakePdptoolServingPieces(t *testing.T, pieces map[string][]byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	for cid, data := range pieces {
+		if err := os.WriteFile(filepath.Join(dir, cid), data, 0644); err != nil {
+			t.Fatalf("write served piece: %v", err)
+		}
+	}
+	return fakePdptool(t, `case "$1" in download-file)
+	while [ $# -gt 0 ]; do
+		case "$1" in --output-file) out="$2";; --chunk-file) chunk="$2";; esac
+		shift
+	done
+	cid=$(cat "$chunk")
+	if [ ! -f "`+dir+`/$cid" ]; then echo "piece not found" >&2; exit 1; fi
+	cp "`+dir+`/$cid" "$out";;
+esac`)
+}
+
+func proofSetArchiveRequest(proofSet models.ProofSet, query string, user models.User) (*gin.Context, *httptest.ResponseRecorder) {
+	id := strconv.FormatUint(uint64(proofSet.ID), 10)
+	target := "/api/v1/pieces/proof-sets/" + id + "/archive"
+	if query != "" {
+		target += "?" + query
+	}
+	c, recorder := newTestContext(http.MethodGet, target, nil, user)
+	c.Params = gin.Params{{Key: "id", Value: id}}
+	return c, recorder
+}
+
+// readProofSetArchive returns the files in a zip archive and its manifest.
+func readProofSetArchive(t *testing.T, data []byte) (map[string][]byte, archiveManifest) {
+	t.Helper()
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	files := make(map[string][]byte)
+	var manifest archiveManifest
+	for _, file := range reader.File {
+		r, err := file.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", file.Name, err)
+		}
+		contents, _ := io.ReadAll(r)
+		r.Close()
+		if file.Name == archiveManifestName {
+			if err := json.Unmarshal(contents, &manifest); err != nil {
+				t.Fatalf("parse manifest: %v", err)
+			}
+			continue
+		}
+		files[file.Name] = contents
+	}
+	return files, manifest
+}
+
+// seedArchivePieces stores three pieces in proofSet: one that can be
+// fetched, one the service no longer has and one that comes back corrupted.
+// It returns the fetchable piece's contents.
+func seedArchivePieces(t *testing.T, user models.User, proofSet models.ProofSet) []byte {
+	t.Helper()
+	good := []byte("first quarter report\n")
+	corrupted := []byte("second quarter report\n")
+	checksumOf := func(data []byte) *string {
+		sum := sha256.Sum256(data)
+		checksum := hex.EncodeToString(sum[:])
+		return &checksum
+	}
+	rootID := "1"
+	createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagagood:bagasub", Filename: "q1.txt", Size: int64(len(good)), Checksum: checksumOf(good), ProofSetID: &proofSet.ID, RootID: &rootID})
+	createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagamissing:bagasub", Filename: "q2.txt", Size: 10, ProofSetID: &proofSet.ID})
+	createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagacorrupt:bagasub", Filename: "q3.txt", Size: int64(len(corrupted)), Checksum: checksumOf(corrupted), ProofSetID: &proofSet.ID})
+	// Pieces being removed are left out altogether.
+	createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaremoved:bagasub", Filename: "old.txt", Size: 3, ProofSetID: &proofSet.ID, PendingRemoval: true})
+
+	fakePdptoolServingPieces(t, map[string][]byte{
+		"bagagood":    good,
+		"bagacorrupt": corruptedCopy(corrupted),
+		"bagaremoved": []byte("old"),
+	})
+	return good
+}
+
+func useTestArchives(t *testing.T) {
+	t.Helper()
+	useTestDB(t)
+	useTestConfig(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	cfg.Retry.DownloadMaxRetries = 0
+}
+
+func TestDownloadProofSetArchiveHidesOtherUsersProofSets(t *testing.T) {
+	useTestArchives(t)
+	owner := createTestUser(t, "0x1")
+	other := createTestUser(t, "0x2")
+	proofSet := createTestProofSet(t, owner, "42")
+	seedArchivePieces(t, owner, proofSet)
+	calls := fakePdptool(t, "exit 1")
+
+	c, recorder := proofSetArchiveRequest(proofSet, "", other)
+	DownloadProofSetArchive(c)
+
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+	if called := pdptoolCalls(t, calls, ""); len(called) != 0 {
+		t.Errorf("pdptool was run for another user's proof set: %q", called)
+	}
+}
+
+func TestDownloadProofSetArchiveOfEmptyProofSet(t *testing.T) {
+	useTestArchives(t)
+	user := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, user, "42")
+	createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaremoved:bagasub", Filename: "old.txt", Size: 3, ProofSetID: &proofSet.ID, PendingRemoval: true})
+	fakePdptool(t, "exit 1")
+
+	c, recorder := proofSetArchiveRequest(proofSet, "", user)
+	DownloadProofSetArchive(c)
+
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d: %s", recorder.Code, http.StatusNotFound, recorder.Body)
+	}
+}
+
+func TestDownloadProofSetArchiveWithFailedFetches(t *testing.T) {
+	useTestArchives(t)
+	user := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, user, "42")
+	good := seedArchivePieces(t, user, proofSet)
+
+	c, recorder := proofSetArchiveRequest(proofSet, "", user)
+	DownloadProofSetArchive(c)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	if failed := recorder.Result().Trailer.Get("X-Archive-Failed"); failed != "2" {
+		t.Errorf("X-Archive-Failed = %q, want 2", failed)
+	}
+	files, manifest := readProofSetArchive(t, recorder.Body.Bytes())
+	if len(files) != 1 || !bytes.Equal(files["q1.txt"], good) {
+		t.Errorf("archive holds %d files, want only q1.txt = %q", len(files), good)
+	}
+	if len(manifest.Files) != 1 || manifest.Files[0].CID != "bagagood:bagasub" || stringValue(manifest.Files[0].RootID) != "1" {
+		t.Errorf("manifest files = %+v, want the fetched piece with its root ID", manifest.Files)
+	}
+	failed := map[string]bool{}
+	for _, entry := range manifest.Failed {
+		failed[entry.CID] = entry.Error != "" && entry.Path == ""
+	}
+	if len(failed) != 2 || !failed["bagamissing:bagasub"] || !failed["bagacorrupt:bagasub"] {
+		t.Errorf("manifest failed = %+v, want the missing and corrupted pieces with errors", manifest.Failed)
+	}
+}
+
+func waitForUploadJob(t *testing.T, jobID string) UploadProgress {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		progress, _, _ := getUploadJob(jobID)
+		if isTerminalJobStatus(progress.Status) {
+			return progress
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job status = %q, want it to finish", progress.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProofSetArchiveJob(t *testing.T) {
+	useTestArchives(t)
+	user := createTestUser(t, "0x1")
+	other := createTestUser(t, "0x2")
+	proofSet := createTestProofSet(t, user, "42")
+	good := seedArchivePieces(t, user, proofSet)
+
+	c, recorder := proofSetArchiveRequest(proofSet, "async=true", user)
+	DownloadProofSetArchive(c)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusAccepted, recorder.Body)
+	}
+	var job ProofSetArchiveJobResponse
+	json.Unmarshal(recorder.Body.Bytes(), &job)
+	if progress := waitForUploadJob(t, job.JobID); progress.Status != "partial" {
+		t.Fatalf("job status = %q (%s), want partial", progress.Status, progress.Message)
+	}
+
+	jobRequest := func(user models.User) *httptest.ResponseRecorder {
+		c, recorder := newTestContext(http.MethodGet, job.DownloadURL, nil, user)
+		c.Params = gin.Params{{Key: "jobId", Value: job.JobID}}
+		DownloadProofSetArchiveJob(c)
+		return recorder
+	}
+	if recorder := jobRequest(other); recorder.Code != http.StatusNotFound {
+		t.Errorf("other user: status = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+	recorder = jobRequest(user)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("download archive: status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	files, manifest := readProofSetArchive(t, recorder.Body.Bytes())
+	if len(files) != 1 || !bytes.Equal(files["q1.txt"], good) || len(manifest.Failed) != 2 {
+		t.Errorf("archive holds %d files with %d failed, want q1.txt and 2 failed", len(files), len(manifest.Failed))
+	}
+}
+
+func TestProofSetArchiveJobWithEveryFetchFailing(t *testing.T) {
+	useTestArchives(t)
+	user := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, user, "42")
+	createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagamissing:bagasub", Filename: "q2.txt", Size: 10, ProofSetID: &proofSet.ID})
+	fakePdptoolServingPieces(t, nil)
+
+	c, recorder := proofSetArchiveRequest(proofSet, "async=true", user)
+	DownloadProofSetArchive(c)
+	var job ProofSetArchiveJobResponse
+	json.Unmarshal(recorder.Body.Bytes(), &job)
+
+	if progress := waitForUploadJob(t, job.JobID); progress.Status != "error" {
+		t.Errorf("job status = %q, want error", progress.Status)
+	}
+	if _, err := os.Stat(archiveJobPath(job.JobID)); !os.IsNotExist(err) {
+		t.Errorf("an archive was kept for a job that fetched nothing")
+	}
+}
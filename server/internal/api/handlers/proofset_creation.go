@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	proofSetIDRegex     = regexp.MustCompile(`ProofSet ID:[ \t]*(\d+)`)
+	creationStatusRegex = regexp.MustCompile(`Proofset Created:[ \t]*(true|false)`)
+	txStatusRegex       = regexp.MustCompile(`Transaction Status:[ \t]*(confirmed|pending|failed)`)
+	txSuccessRegex      = regexp.MustCompile(`Transaction Successful:[ \t]*(true|false|Pending)`)
+
+	// txHashFormat matches an Ethereum transaction hash.
+	txHashFormat = regexp.MustCompile(`^0x[0-9a-fA-F]{64}$`)
+)
+
+// proofSetCreateStatus is what pdptool get-proof-set-create-status reports
+// about a creation transaction. Fields the output doesn't include are
+// empty: TxStatus is confirmed, pending or failed, TxSuccess true, false or
+// Pending, and Created true or false.
+type proofSetCreateStatus struct {
+	TxStatus   string
+	TxSuccess  string
+	Created    string
+	ProofSetID string
+}
+
+// parseProofSetCreateStatus parses the output of pdptool
+// get-proof-set-create-status.
+func parseProofSetCreateStatus(output string) proofSetCreateStatus {
+	match := func(re *regexp.Regexp) string {
+		if m := re.FindStringSubmatch(output); len(m) > 1 {
+			return m[1]
+		}
+		return ""
+	}
+	return proofSetCreateStatus{
+		TxStatus:   match(txStatusRegex),
+		TxSuccess:  match(txSuccessRegex),
+		Created:    match(creationStatusRegex),
+		ProofSetID: match(proofSetIDRegex),
+	}
+}
+
+// describe summarises s, for a proof set's LastPollStatus.
+func (s proofSetCreateStatus) describe() string {
+	unknown := func(value string) string {
+		if value == "" {
+			return "unknown"
+		}
+		return value
+	}
+	return fmt.Sprintf("transaction %s, successful %s, proof set created %s", unknown(s.TxStatus), unknown(s.TxSuccess), unknown(s.Created))
+}
+
+// fetchProofSetCreateStatus runs pdptool get-proof-set-create-status for a
+// creation transaction sent to a service.
+func fetchProofSetCreateStatus(ctx context.Context, serviceURL, serviceName, txHash string) (proofSetCreateStatus, error) {
+	pdptoolPath, err := preparePdptool(ctx)
+	if err != nil {
+		return proofSetCreateStatus{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, proofSetStatusTimeout)
+	defer cancel()
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, pdptoolPath,
+		"get-proof-set-create-status",
+		"--service-url", serviceURL,
+		"--service-name", serviceName,
+		"--tx-hash", txHash,
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		log.WithField("txHash", txHash).
+			WithField("error", err.Error()).
+			WithField("stderr", stderr.String()).
+			Warning("pdptool get-proof-set-create-status failed while checking creation status")
+		return proofSetCreateStatus{}, errServiceUnreachable
+	}
+	return parseProofSetCreateStatus(stdout.String()), nil
+}
+
+// ProofSetCreationStatusResponse is a proof set creation transaction as
+// reported by the service, with the proof set stored locally.
+// @Description The state of one of the caller's proof set creation transactions. txStatus, txSuccess, proofSetCreated and serviceProofSetId are what get-proof-set-create-status reported, left out when it didn't say; live is false when the service couldn't be asked, with the reason in error. The other fields are the proof set as stored locally, including the progress of the background poll for its ID.
+type ProofSetCreationStatusResponse struct {
+	ID                 uint       `json:"id"`
+	TransactionHash    string     `json:"transactionHash"`
+	Status             string     `json:"status"`
+	ProofSetID         string     `json:"proofSetId"`
+	FailureReason      string     `json:"failureReason,omitempty"`
+	PollStartedAt      *time.Time `json:"pollStartedAt,omitempty"`
+	PollAttempts       int        `json:"pollAttempts"`
+	LastPollStatus     string     `json:"lastPollStatus,omitempty"`
+	Live               bool       `json:"live"`
+	TxStatus           string     `json:"txStatus,omitempty"`
+	TxSuccess          string     `json:"txSuccess,omitempty"`
+	ProofSetCreated    *bool      `json:"proofSetCreated,omitempty"`
+	ServiceProofSetID  string     `json:"serviceProofSetId,omitempty"`
+	ServiceUnreachable bool       `json:"serviceUnreachable"`
+	Error              string     `json:"error,omitempty"`
+	CheckedAt          time.Time  `json:"checkedAt"`
+}
+
+// @Summary Get a proof set creation's status
+// @Description Ask the storage provider about one of the caller's proof set creation transactions with get-proof-set-create-status, and return what it reports along with the proof set stored locally. Hashes that are malformed or aren't the caller's current creation transaction get 404.
+// @Tags proofset
+// @Param txHash query string true "Creation transaction hash"
+// @Produce json
+// @Success 200 {object} ProofSetCreationStatusResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/proof-set/status [get]
+func GetProofSetCreationStatus(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	txHash := c.Query("txHash")
+	if !txHashFormat.MatchString(txHash) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Proof set creation not found",
+		})
+		return
+	}
+
+	var proofSet models.ProofSet
+	if err := db.Unscoped().Where("user_id = ? AND LOWER(transaction_hash) = LOWER(?)", userID, txHash).First(&proofSet).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Proof set creation not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch proof set")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch proof set",
+		})
+		return
+	}
+
+	response := ProofSetCreationStatusResponse{
+		ID:              proofSet.ID,
+		TransactionHash: proofSet.TransactionHash,
+		Status:          proofSet.Status,
+		ProofSetID:      proofSet.ProofSetID,
+		FailureReason:   proofSet.FailureReason,
+		PollStartedAt:   proofSet.PollStartedAt,
+		PollAttempts:    proofSet.PollAttempts,
+		LastPollStatus:  proofSet.LastPollStatus,
+		CheckedAt:       time.Now().UTC(),
+	}
+
+	status, err := fetchProofSetCreateStatus(c.Request.Context(), proofSet.ServiceURL, proofSet.ServiceName, proofSet.TransactionHash)
+	if err != nil {
+		response.ServiceUnreachable = errors.Is(err, errServiceUnreachable)
+		response.Error = err.Error()
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	response.Live = true
+	response.TxStatus = status.TxStatus
+	response.TxSuccess = status.TxSuccess
+	if status.Created != "" {
+		created := status.Created == "true"
+		response.ProofSetCreated = &created
+	}
+	response.ServiceProofSetID = status.ProofSetID
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+func TestParseProofSetCreateStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   proofSetCreateStatus
+	}{
+		{
+			name: "created",
+			output: `Proof Set Creation Status:
+Transaction Hash: 0xabc
+Transaction Status: confirmed
+Transaction Successful: true
+Proofset Created: true
+ProofSet ID: 77
+`,
+			want: proofSetCreateStatus{TxStatus: "confirmed", TxSuccess: "true", Created: "true", ProofSetID: "77"},
+		},
+		{
+			name:   "pending",
+			output: "Transaction Hash: 0xabc\nTransaction Status: pending\nTransaction Successful: Pending\nProofset Created: false\n",
+			want:   proofSetCreateStatus{TxStatus: "pending", TxSuccess: "Pending", Created: "false"},
+		},
+		{
+			name:   "confirmed, proof set not created yet",
+			output: "Transaction Status: confirmed\nTransaction Successful: true\nProofset Created: false\n",
+			want:   proofSetCreateStatus{TxStatus: "confirmed", TxSuccess: "true", Created: "false"},
+		},
+		{
+			name:   "reverted",
+			output: "Transaction Status: confirmed\nTransaction Successful: false\nProofset Created: false\n",
+			want:   proofSetCreateStatus{TxStatus: "confirmed", TxSuccess: "false", Created: "false"},
+		},
+		{
+			name:   "failed",
+			output: "Transaction Status: failed\n",
+			want:   proofSetCreateStatus{TxStatus: "failed"},
+		},
+		{
+			name:   "unknown values",
+			output: "Transaction Status: dropped\nTransaction Successful: maybe\nProofset Created: soon\nProofSet ID: none\n",
+			want:   proofSetCreateStatus{},
+		},
+		{
+			name:   "not get-proof-set-create-status output",
+			output: "Error: transaction not found\n",
+			want:   proofSetCreateStatus{},
+		},
+	}
+	for _, test := range tests {
+		if got := parseProofSetCreateStatus(test.output); got != test.want {
+			t.Errorf("%s: parseProofSetCreateStatus = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestProofSetCreateStatusDescribe(t *testing.T) {
+	tests := []struct {
+		status proofSetCreateStatus
+		want   string
+	}{
+		{proofSetCreateStatus{TxStatus: "confirmed", TxSuccess: "true", Created: "true", ProofSetID: "77"}, "transaction confirmed, successful true, proof set created true"},
+		{proofSetCreateStatus{TxStatus: "pending"}, "transaction pending, successful unknown, proof set created unknown"},
+	}
+	for _, test := range tests {
+		if got := test.status.describe(); got != test.want {
+			t.Errorf("describe(%+v) = %q, want %q", test.status, got, test.want)
+		}
+	}
+}
+
+func TestGetProofSetCreationStatus(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	other := createTestUser(t, "0x2")
+	txHash := "0x" + strings.Repeat("cd", 32)
+	unreachableTx := "0x" + strings.Repeat("ef", 32)
+	proofSet := createCreatingProofSet(t, user, proofSetConfirming, txHash)
+	db.Model(&proofSet).Updates(map[string]interface{}{"poll_attempts": 3, "last_poll_status": "transaction pending"})
+	createCreatingProofSet(t, other, proofSetConfirming, unreachableTx)
+	fakePdptool(t, `case "$1" in get-proof-set-create-status)
+	case "$*" in *`+txHash+`*) printf 'Transaction Status: confirmed\nTransaction Successful: true\nProofset Created: false\n';; *) exit 1;; esac;;
+esac`)
+	request := func(txHash string, user models.User) (int, ProofSetCreationStatusResponse) {
+		c, recorder := newTestContext(http.MethodGet, "/api/v1/proof-set/status?txHash="+url.QueryEscape(txHash), nil, user)
+		GetProofSetCreationStatus(c)
+		var response ProofSetCreationStatusResponse
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder.Code, response
+	}
+
+	for _, hash := range []string{txHash, strings.Replace(txHash, "cd", "CD", -1)} {
+		code, status := request(hash, user)
+		if code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d", hash, code, http.StatusOK)
+		}
+		if status.ID != proofSet.ID || status.Status != proofSetConfirming || status.PollAttempts != 3 || status.LastPollStatus != "transaction pending" {
+			t.Errorf("%s: stored state = %+v", hash, status)
+		}
+		if !status.Live || status.TxStatus != "confirmed" || status.TxSuccess != "true" ||
+			status.ProofSetCreated == nil || *status.ProofSetCreated || status.ServiceProofSetID != "" {
+			t.Errorf("%s: live state = %+v, want confirmed, successful, not created yet", hash, status)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		txHash string
+	}{
+		{"missing", ""},
+		{"malformed", "0x1234"},
+		{"not hex", "0x" + strings.Repeat("zz", 32)},
+		{"another user's", unreachableTx},
+		{"unknown", "0x" + strings.Repeat("01", 32)},
+	}
+	for _, test := range tests {
+		if code, _ := request(test.txHash, user); code != http.StatusNotFound {
+			t.Errorf("%s transaction: status = %d, want %d", test.name, code, http.StatusNotFound)
+		}
+	}
+
+	code, status := request(unreachableTx, other)
+	if code != http.StatusOK || status.Live || !status.ServiceUnreachable || status.Error == "" || status.Status != proofSetConfirming {
+		t.Errorf("service unreachable: status = %d, %+v, want the stored state only", code, status)
+	}
+}
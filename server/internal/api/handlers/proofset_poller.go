@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// proofSetRecoveryInterval is how often the proof set poller looks for
+// creations that nothing is watching.
+const proofSetRecoveryInterval = 5 * time.Minute
+
+// errProofSetCreationFailed is wrapped by polling errors meaning the
+// creation transaction will never produce a proof set.
+var errProofSetCreationFailed = errors.New("proof set creation failed")
+
+// proofSetPoller waits for proof set creation transactions to produce their
+// proof set's ID and records it, and for decommissioned proof sets to lose
+// their last root before deleting them. It also syncs every proof set with
+// its service each proofSetSyncInterval. Creations and decommissions left
+// unfinished by a restart are picked up again from the proof_sets table,
+// except creations stopped before their transaction was sent, which are
+// marked failed. Each proof set is polled by at most one goroutine.
+type proofSetPoller struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopped  chan struct{}
+	stopOnce sync.Once
+	polls    sync.WaitGroup
+
+	// mu guards watching, the IDs of the proof set rows being polled.
+	mu       sync.Mutex
+	watching map[uint]bool
+}
+
+var proofSetPolls *proofSetPoller
+
+func startProofSetPoller() *proofSetPoller {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &proofSetPoller{
+		ctx:      ctx,
+		cancel:   cancel,
+		stopped:  make(chan struct{}),
+		watching: make(map[uint]bool),
+	}
+
+	go p.run()
+
+	log.Info("Proof set poller started")
+	return p
+}
+
+// stop cancels every poll and waits for them to exit. Creations still
+// pending are resumed on the next start.
+func (p *proofSetPoller) stop() {
+	p.stopOnce.Do(p.cancel)
+	<-p.stopped
+	p.polls.Wait()
+}
+
+func (p *proofSetPoller) run() {
+	defer close(p.stopped)
+
+	ticker := time.NewTicker(proofSetRecoveryInterval)
+	defer ticker.Stop()
+	syncTicker := time.NewTicker(proofSetSyncInterval)
+	defer syncTicker.Stop()
+
+	p.failInterrupted()
+	p.resumePending()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			p.resumePending()
+		case <-syncTicker.C:
+			if synced := syncProofSets(p.ctx); synced > 0 {
+				log.WithField("proofSets", synced).Info("Synced proof sets with their services")
+			}
+		}
+	}
+}
+
+// failInterrupted marks creations that were still submitting when the
+// server stopped as failed. Whether create-proof-set went through is
+// unknown and without its transaction hash there is nothing to poll, so
+// they are left for the user to submit again.
+func (p *proofSetPoller) failInterrupted() {
+	var interrupted []models.ProofSet
+	if err := db.Where("status = ?", proofSetSubmitting).Find(&interrupted).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to load interrupted proof set creations")
+		return
+	}
+	for _, proofSet := range interrupted {
+		if err := transitionProofSet(db, &proofSet, proofSetFailed, map[string]interface{}{
+			"failure_reason": "creation was interrupted by a server restart",
+		}); err != nil {
+			log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to mark interrupted proof set creation as failed")
+			continue
+		}
+		log.WithField("proofSetID", proofSet.ID).WithField("userID", proofSet.UserID).Warning("Proof set creation was interrupted by a restart")
+		publishUserEvent(proofSet.UserID, WSTypeProofSetStatus, proofSetEventFromModel(proofSet))
+	}
+}
+
+// resumePending starts polling every proof set whose creation transaction
+// is confirming, or which is decommissioning, unless it is already being
+// polled. It returns how many polls it started.
+func (p *proofSetPoller) resumePending() int {
+	var pending []models.ProofSet
+	if err := db.Where("status IN ?", []string{proofSetConfirming, proofSetDecommissioning}).
+		Find(&pending).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to load pending proof sets")
+		return 0
+	}
+
+	started := 0
+	for _, proofSet := range pending {
+		watch := p.watch
+		if proofSet.Status == proofSetDecommissioning {
+			watch = p.watchDecommission
+		}
+		if watch(proofSet) {
+			started++
+		}
+	}
+	if started > 0 {
+		log.WithField("count", started).Info("Resumed polling for pending proof sets")
+	}
+	return started
+}
+
+// watch polls proofSet's creation in the background, reporting whether it
+// started doing so; it doesn't when the proof set is already being polled.
+func (p *proofSetPoller) watch(proofSet models.ProofSet) bool {
+	return p.start(proofSet, p.poll)
+}
+
+// watchDecommission waits in the background for proofSet to have no roots
+// left, then deletes it. Like watch, it reports whether it started.
+func (p *proofSetPoller) watchDecommission(proofSet models.ProofSet) bool {
+	return p.start(proofSet, p.decommission)
+}
+
+// isWatching reports whether proof set id is being polled.
+func (p *proofSetPoller) isWatching(id uint) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.watching[id]
+}
+
+func (p *proofSetPoller) start(proofSet models.ProofSet, poll func(models.ProofSet)) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.watching[proofSet.ID] || p.ctx.Err() != nil {
+		return false
+	}
+	p.watching[proofSet.ID] = true
+	p.polls.Add(1)
+
+	go func() {
+		defer p.polls.Done()
+		defer func() {
+			p.mu.Lock()
+			delete(p.watching, proofSet.ID)
+			p.mu.Unlock()
+		}()
+		poll(proofSet)
+	}()
+	return true
+}
+
+// decommission polls get-proof-set until the service lists no roots in
+// proofSet, as they are only dropped at the end of a proving period, then
+// marks it decommissioned and deletes it.
+func (p *proofSetPoller) decommission(proofSet models.ProofSet) {
+	for attempt := 1; ; attempt++ {
+		status, _, err := fetchProofSetStatus(p.ctx, proofSet.ServiceURL, proofSet.ServiceName, proofSet.ProofSetID)
+		if err == nil && len(status.Roots) == 0 {
+			break
+		}
+		if err == nil && attempt%6 == 1 {
+			log.WithField("proofSetID", proofSet.ID).
+				WithField("roots", len(status.Roots)).
+				Info("Waiting for decommissioned proof set to lose its roots")
+		}
+		if !sleepContext(p.ctx, cfg.Retry.ProofSetPollInterval) {
+			return
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := transitionProofSet(tx, &proofSet, proofSetDecommissioned, nil); err != nil {
+			return err
+		}
+		return tx.Delete(&models.ProofSet{}, proofSet.ID).Error
+	})
+	if err != nil {
+		log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to delete decommissioned proof set")
+		return
+	}
+	log.WithField("proofSetID", proofSet.ID).WithField("userID", proofSet.UserID).Info("Decommissioned proof set")
+	publishUserEvent(proofSet.UserID, WSTypeProofSetStatus, proofSetEventFromModel(proofSet))
+}
+
+// poll waits for proofSet's ID and records it, making the proof set ready.
+// A creation that will never succeed is marked failed, so the user can
+// submit it again; other errors leave it confirming for resumePending.
+func (p *proofSetPoller) poll(proofSet models.ProofSet) {
+	extractedID, err := pollForProofSetID(p.ctx, cfg.PdptoolPath, proofSet)
+	if err != nil {
+		if p.ctx.Err() != nil {
+			return
+		}
+		authLog.Errorf("[Goroutine Create] Failed to poll for proof set ID for user %d: %v", proofSet.UserID, err)
+		if errors.Is(err, errProofSetCreationFailed) {
+			if err := transitionProofSet(db, &proofSet, proofSetFailed, map[string]interface{}{
+				"failure_reason": err.Error(),
+			}); err != nil {
+				log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to mark proof set creation as failed")
+			}
+		}
+		event := proofSetEventFromModel(proofSet)
+		event.Error = err.Error()
+		publishUserEvent(proofSet.UserID, WSTypeProofSetStatus, event)
+		return
+	}
+
+	if err := transitionProofSet(db, &proofSet, proofSetReady, map[string]interface{}{
+		"proof_set_id": extractedID,
+	}); err != nil {
+		authLog.Errorf("[Goroutine Create] Failed to update proof set with ProofSetID for user %d: %v", proofSet.UserID, err)
+		return
+	}
+	authLog.WithField("proofSetPdpID", extractedID).Infof("[Goroutine Create] Successfully updated proof set with ID for user %d", proofSet.UserID)
+	publishUserEvent(proofSet.UserID, WSTypeProofSetStatus, proofSetEventFromModel(proofSet))
+	publishWebhookEvent(proofSet.UserID, webhookEventProofSetReady, ProofSetReadyData{
+		ID:              proofSet.ID,
+		ProofSetID:      proofSet.ProofSetID,
+		TransactionHash: proofSet.TransactionHash,
+	})
+}
+
+// saveProofSetPoll records the attempts made polling for proofSet's ID and
+// the status last reported, so polling resumed later carries on from them.
+func saveProofSetPoll(proofSet models.ProofSet, attempts int, status string) {
+	if err := db.Model(&models.ProofSet{}).Where("id = ?", proofSet.ID).Updates(map[string]interface{}{
+		"poll_attempts":    attempts,
+		"last_poll_status": status,
+	}).Error; err != nil {
+		log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Warning("Failed to save proof set polling progress")
+	}
+}
+
+// pollForProofSetID polls get-proof-set-create-status for proofSet's
+// creation transaction until the proof set's ID is known, saving its
+// progress on the proof set, and the transaction's outcome once known, as
+// it goes. Errors wrap
+// errProofSetCreationFailed when the creation will never succeed, or when
+// its ID still isn't known ProofSetPollMaxDuration after polling began or
+// after ProofSetPollMaxAttempts attempts, counting those made before a
+// restart.
+func pollForProofSetID(ctx context.Context, pdptoolPath string, proofSet models.ProofSet) (string, error) {
+	serviceURL, serviceName, txHash := proofSet.ServiceURL, proofSet.ServiceName, proofSet.TransactionHash
+
+	// Change working directory to pdptool directory
+	pdptoolDir := getPdptoolParentDir(pdptoolPath)
+	if err := os.Chdir(pdptoolDir); err != nil {
+		errMsg := fmt.Sprintf("Failed to change working directory to pdptool directory: %v", err)
+		authLog.Error(errMsg)
+		return "", errors.New(errMsg)
+	}
+	authLog.WithField("pdptoolDir", pdptoolDir).Info("Changed working directory to pdptool directory")
+
+	sleepDuration := cfg.Retry.ProofSetPollInterval
+	maxAttempts := cfg.Retry.ProofSetPollMaxAttempts
+	maxDuration := cfg.Retry.ProofSetPollMaxDuration
+	attemptCounter := proofSet.PollAttempts
+	lastStatus := proofSet.LastPollStatus
+	settled := false // whether the transaction's outcome was recorded
+	const maxLogInterval = 6
+
+	startedAt := time.Now()
+	if proofSet.PollStartedAt != nil {
+		startedAt = *proofSet.PollStartedAt
+	} else if err := db.Model(&models.ProofSet{}).Where("id = ?", proofSet.ID).Update("poll_started_at", startedAt).Error; err != nil {
+		log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Warning("Failed to save when proof set polling began")
+	}
+	parent := ctx
+	if maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, startedAt.Add(maxDuration))
+		defer cancel()
+	}
+	// stopped explains why ctx is done: the creation failed when the
+	// deadline passed, otherwise the poller is stopping.
+	stopped := func() error {
+		if parent.Err() != nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ctx.Err()
+		}
+		authLog.Errorf("[Goroutine Polling] Gave up polling for proof set ID for user %d after %v (%d attempts)", proofSet.UserID, maxDuration, attemptCounter)
+		return fmt.Errorf("%w: proof set ID for tx %s not available %v after polling began (%d attempts, last status: %s)", errProofSetCreationFailed, txHash, maxDuration, attemptCounter, lastStatus)
+	}
+
+	authLog.WithField("txHash", txHash).Info("[Goroutine Polling] Starting polling for ProofSet ID for user ", proofSet.UserID)
+
+	for {
+		if ctx.Err() != nil {
+			return "", stopped()
+		}
+		if maxAttempts > 0 && attemptCounter >= maxAttempts {
+			authLog.Errorf("[Goroutine Polling] Gave up polling for proof set ID for user %d after %d attempts", proofSet.UserID, attemptCounter)
+			return "", fmt.Errorf("%w: proof set ID for tx %s not available after %d attempts (last status: %s)", errProofSetCreationFailed, txHash, attemptCounter, lastStatus)
+		}
+
+		attemptCounter++
+		getStatusCmd := exec.CommandContext(ctx,
+			pdptoolPath,
+			"get-proof-set-create-status",
+			"--service-url", serviceURL,
+			"--service-name", serviceName,
+			"--tx-hash", txHash,
+		)
+
+		var getStatusOutput bytes.Buffer
+		var getStatusError bytes.Buffer
+		getStatusCmd.Stdout = &getStatusOutput
+		getStatusCmd.Stderr = &getStatusError
+
+		cmdString := fmt.Sprintf("%s %s", pdptoolPath, strings.Join(getStatusCmd.Args[1:], " "))
+		authLog.WithField("command", cmdString).
+			WithField("attempt", attemptCounter).
+			WithField("txHash", txHash).
+			WithField("userID", proofSet.UserID).
+			Info("[Goroutine Polling] Executing get-proof-set-create-status command")
+
+		err := getStatusCmd.Run()
+		statusOutput := getStatusOutput.String()
+		statusStderr := getStatusError.String()
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", stopped()
+			}
+			lastStatus = "get-proof-set-create-status failed: " + err.Error()
+			saveProofSetPoll(proofSet, attemptCounter, lastStatus)
+			authLog.WithField("error", err.Error()).
+				WithField("stderr", statusStderr).
+				WithField("command", cmdString).
+				WithField("attempt", attemptCounter).
+				WithField("userID", proofSet.UserID).
+				Warnf("[Goroutine Polling] Failed to run get-proof-set-create-status command, retrying in %v...", sleepDuration)
+			if !sleepContext(ctx, sleepDuration) {
+				return "", stopped()
+			}
+			continue
+		}
+
+		authLog.WithField("statusOutput", statusOutput).
+			WithField("attempt", attemptCounter).
+			WithField("userID", proofSet.UserID).
+			WithField("txHash", txHash).
+			Info("[Goroutine Polling] get-proof-set-create-status command output")
+
+		status := parseProofSetCreateStatus(statusOutput)
+		txStatus, txSuccess, createdStatus := status.TxStatus, status.TxSuccess, status.Created
+		lastStatus = status.describe()
+		saveProofSetPoll(proofSet, attemptCounter, lastStatus)
+		if !settled {
+			settled = settleCreationTransaction(ctx, txHash, status)
+		}
+
+		// Log the status details for each polling attempt
+		idMatchValue := status.ProofSetID
+		if idMatchValue == "" {
+			idMatchValue = "none"
+		}
+
+		authLog.WithFields(logrus.Fields{
+			"userID":        proofSet.UserID,
+			"txHash":        txHash,
+			"attempt":       attemptCounter,
+			"txStatus":      txStatus,
+			"txSuccess":     txSuccess,
+			"createdStatus": createdStatus,
+			"idFound":       status.ProofSetID != "",
+			"idMatch":       idMatchValue,
+		}).Info("[Goroutine Polling] Current proof set creation status")
+
+		if txStatus == "confirmed" && txSuccess == "true" && createdStatus == "true" && status.ProofSetID != "" {
+			proofSetIDStr := status.ProofSetID
+			authLog.WithField("proofSetID", proofSetIDStr).WithField("attempts", attemptCounter).Infof("[Goroutine Polling] Successfully extracted proof set ID for user %d", proofSet.UserID)
+			return proofSetIDStr, nil
+		}
+
+		if txStatus == "confirmed" && txSuccess == "true" && createdStatus == "false" {
+			authLog.Infof("[Goroutine Polling] Attempt %d: Transaction confirmed for user %d, but proofset creation still processing (TxStatus: %s, TxSuccess: %s, CreatedStatus: %s)... Polling again in %v.",
+				attemptCounter, proofSet.UserID, txStatus, txSuccess, createdStatus, sleepDuration)
+			if !sleepContext(ctx, sleepDuration) {
+				return "", stopped()
+			}
+			continue
+		}
+
+		if txStatus == "confirmed" && (txSuccess == "false" || (createdStatus == "true" && status.ProofSetID == "")) {
+			authLog.Errorf("[Goroutine Polling] Proof set creation failed or stalled for user %d (TxStatus: %s, TxSuccess: %s, CreatedStatus: %s, ID Found: %t). Output: %s",
+				proofSet.UserID, txStatus, txSuccess, createdStatus, status.ProofSetID != "", statusOutput)
+			return "", fmt.Errorf("%w: failed or stalled post-confirmation for tx %s (status: %s, success: %s, created: %s)", errProofSetCreationFailed, txHash, txStatus, txSuccess, createdStatus)
+		}
+
+		if txStatus == "failed" {
+			authLog.Errorf("[Goroutine Polling] Proof set creation transaction failed for user %d (TxStatus: %s). Output: %s",
+				proofSet.UserID, txStatus, statusOutput)
+			return "", fmt.Errorf("%w: transaction failed for tx %s (status: %s)", errProofSetCreationFailed, txHash, txStatus)
+		}
+
+		if txStatus == "pending" || txStatus == "" {
+			authLog.Infof("[Goroutine Polling] Attempt %d: Proof set creation still pending for user %d (TxStatus: '%s')... Polling again in %v.", attemptCounter, proofSet.UserID, txStatus, sleepDuration)
+			if attemptCounter%maxLogInterval == 0 {
+				authLog.WithField("attempt", attemptCounter).Info("[Goroutine Polling] Still waiting for proof set ID for user ", proofSet.UserID, " (TxHash: ", txHash, ")")
+			}
+			if !sleepContext(ctx, sleepDuration) {
+				return "", stopped()
+			}
+			continue
+		}
+
+		authLog.Warnf("[Goroutine Polling] Attempt %d: Encountered unhandled status for user %d (TxStatus: %s, TxSuccess: %s, CreatedStatus: %s). Retrying in %v... Output: %s",
+			attemptCounter, proofSet.UserID, txStatus, txSuccess, createdStatus, sleepDuration, statusOutput)
+		if !sleepContext(ctx, sleepDuration) {
+			return "", stopped()
+		}
+	}
+}
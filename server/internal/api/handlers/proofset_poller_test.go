@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+// useTestProofSetPolling sets up polling for proof set IDs every few
+// milliseconds, with no chain to read receipts from.
+func useTestProofSetPolling(t *testing.T) {
+	t.Helper()
+	useTestDB(t)
+	useTestConfig(t)
+	cfg.Ethereum.RPCURL = ""
+	cfg.Retry.ProofSetPollInterval = 5 * time.Millisecond
+	cfg.Retry.ProofSetPollMaxAttempts = 0
+	cfg.Retry.ProofSetPollMaxDuration = time.Minute
+	// Polling runs pdptool from its own directory.
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// useTestProofSetPolls sets up a proof set poller for handlers to hand
+// creations to, stopped when the test ends. It skips the startup pass, which
+// would fail rows the test moves to submitting.
+func useTestProofSetPolls(t *testing.T) *proofSetPoller {
+	t.Helper()
+	previous := proofSetPolls
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &proofSetPoller{ctx: ctx, cancel: cancel, watching: make(map[uint]bool)}
+	proofSetPolls = p
+	t.Cleanup(func() {
+		cancel()
+		p.polls.Wait()
+		proofSetPolls = previous
+	})
+	return p
+}
+
+// createCreatingProofSet stores a proof set for user whose creation is at
+// status, sent in transaction txHash when that is set.
+func createCreatingProofSet(t *testing.T, user models.User, status, txHash string) models.ProofSet {
+	t.Helper()
+	proofSet := models.ProofSet{
+		UserID:          user.ID,
+		TransactionHash: txHash,
+		ServiceName:     cfg.ServiceName,
+		ServiceURL:      cfg.ServiceURL,
+		Status:          status,
+	}
+	if err := db.Create(&proofSet).Error; err != nil {
+		t.Fatalf("create proof set: %v", err)
+	}
+	return proofSet
+}
+
+func waitForProofSetStatus(t *testing.T, id uint, status string) models.ProofSet {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var proofSet models.ProofSet
+		db.First(&proofSet, id)
+		if proofSet.Status == status {
+			return proofSet
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("proof set %d status = %q (%s %s), want %q", id, proofSet.Status, proofSet.LastPollStatus, proofSet.FailureReason, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func waitForWatching(t *testing.T, p *proofSetPoller, id uint, watching bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for p.isWatching(id) != watching {
+		if time.Now().After(deadline) {
+			t.Fatalf("proof set %d watched = %v, want %v", id, !watching, watching)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestProofSetPollerResumesCreationAfterRestart(t *testing.T) {
+	useTestProofSetPolling(t)
+	txHash := "0x" + strings.Repeat("cd", 32)
+	// The row a restart left behind: its transaction was sent, but nothing
+	// is polling for the proof set's ID.
+	stuck := createCreatingProofSet(t, createTestUser(t, "0x1"), proofSetConfirming, txHash)
+	interrupted := createCreatingProofSet(t, createTestUser(t, "0x2"), proofSetSubmitting, "")
+	ready := createTestProofSet(t, createTestUser(t, "0x3"), "42")
+	// The transaction stays pending until release exists.
+	release := filepath.Join(t.TempDir(), "release")
+	calls := fakePdptool(t, `case "$1" in get-proof-set-create-status)
+	if [ -f `+release+` ]; then
+		printf 'Transaction Status: confirmed\nTransaction Successful: true\nProofset Created: true\nProofSet ID: 77\n'
+	else
+		printf 'Transaction Status: pending\n'
+	fi;;
+esac`)
+
+	p := startProofSetPoller()
+	t.Cleanup(p.stop)
+	waitForWatching(t, p, stuck.ID, true)
+
+	// Only one poll runs for the row, however often it is picked up.
+	if started := p.resumePending(); started != 0 {
+		t.Errorf("resumePending started %d polls for a proof set already polled", started)
+	}
+	if p.watch(stuck) {
+		t.Errorf("watch started a second poll for the proof set")
+	}
+
+	if err := os.WriteFile(release, nil, 0644); err != nil {
+		t.Fatalf("release transaction: %v", err)
+	}
+	recovered := waitForProofSetStatus(t, stuck.ID, proofSetReady)
+	if recovered.ProofSetID != "77" {
+		t.Errorf("proof set ID = %q, want 77", recovered.ProofSetID)
+	}
+	waitForWatching(t, p, stuck.ID, false)
+
+	failed := waitForProofSetStatus(t, interrupted.ID, proofSetFailed)
+	if failed.FailureReason == "" {
+		t.Errorf("interrupted creation failed without a reason")
+	}
+	if p.isWatching(interrupted.ID) || p.isWatching(ready.ID) {
+		t.Errorf("poller watches proof sets with no creation transaction to poll")
+	}
+	for _, call := range pdptoolCalls(t, calls, "") {
+		if !strings.Contains(call, "--tx-hash "+txHash) {
+			t.Errorf("pdptool ran for another proof set: %s", call)
+		}
+	}
+}
+
+// fakePdptoolPendingForever installs a fake pdptool whose creation
+// transaction never leaves pending, returning the path of its calls file.
+func fakePdptoolPendingForever(t *testing.T) string {
+	t.Helper()
+	return fakePdptool(t, `case "$1" in get-proof-set-create-status) printf 'Transaction Status: pending\n';; esac`)
+}
+
+func TestPollForProofSetIDGivesUpAtDeadline(t *testing.T) {
+	useTestProofSetPolling(t)
+	cfg.Retry.ProofSetPollMaxDuration = 100 * time.Millisecond
+	calls := fakePdptoolPendingForever(t)
+	proofSet := createCreatingProofSet(t, createTestUser(t, "0x1"), proofSetConfirming, "0x"+strings.Repeat("cd", 32))
+
+	began := time.Now()
+	_, err := pollForProofSetID(context.Background(), cfg.PdptoolPath, proofSet)
+	if !errors.Is(err, errProofSetCreationFailed) {
+		t.Fatalf("pollForProofSetID error = %v, want a failed creation", err)
+	}
+	if elapsed := time.Since(began); elapsed > 2*time.Second {
+		t.Errorf("gave up after %v, want about 100ms", elapsed)
+	}
+
+	db.First(&proofSet, proofSet.ID)
+	// The deadline may cut short the last attempt before pdptool records it
+	// or before its result is saved.
+	attempts := len(pdptoolCalls(t, calls, "get-proof-set-create-status"))
+	saved := proofSet.PollAttempts == attempts || proofSet.PollAttempts == attempts-1
+	if attempts < 2 || !saved || proofSet.PollStartedAt == nil || !strings.Contains(proofSet.LastPollStatus, "pending") {
+		t.Errorf("saved %d attempts from %v, last status %q, want %d attempts reporting pending",
+			proofSet.PollAttempts, proofSet.PollStartedAt, proofSet.LastPollStatus, attempts)
+	}
+	counted := strings.Contains(err.Error(), "("+strconv.Itoa(attempts)+" attempts") ||
+		strings.Contains(err.Error(), "("+strconv.Itoa(attempts+1)+" attempts")
+	if !counted || !strings.Contains(err.Error(), "pending") {
+		t.Errorf("error %q doesn't give the %d attempts made or the last status", err, attempts)
+	}
+}
+
+func TestProofSetPollerFailsCreationAtDeadline(t *testing.T) {
+	useTestProofSetPolling(t)
+	cfg.Retry.ProofSetPollMaxDuration = 100 * time.Millisecond
+	fakePdptoolPendingForever(t)
+	p := useTestProofSetPolls(t)
+	proofSet := createCreatingProofSet(t, createTestUser(t, "0x1"), proofSetConfirming, "0x"+strings.Repeat("cd", 32))
+
+	if !p.watch(proofSet) {
+		t.Fatalf("watch didn't start polling")
+	}
+	failed := waitForProofSetStatus(t, proofSet.ID, proofSetFailed)
+	if !strings.Contains(failed.FailureReason, "after polling began") {
+		t.Errorf("failure reason = %q, want the deadline", failed.FailureReason)
+	}
+	waitForWatching(t, p, proofSet.ID, false)
+}
+
+func TestPollForProofSetIDDeadlineCountsFromFirstPoll(t *testing.T) {
+	useTestProofSetPolling(t)
+	cfg.Retry.ProofSetPollMaxDuration = time.Hour
+	calls := fakePdptoolPendingForever(t)
+	proofSet := createCreatingProofSet(t, createTestUser(t, "0x1"), proofSetConfirming, "0x"+strings.Repeat("cd", 32))
+	// Polling began before a restart, longer ago than the deadline allows.
+	startedAt := time.Now().Add(-2 * time.Hour)
+	db.Model(&proofSet).Updates(map[string]interface{}{"poll_started_at": startedAt, "poll_attempts": 700, "last_poll_status": "pending"})
+	db.First(&proofSet, proofSet.ID)
+
+	_, err := pollForProofSetID(context.Background(), cfg.PdptoolPath, proofSet)
+	if !errors.Is(err, errProofSetCreationFailed) || !strings.Contains(err.Error(), "700 attempts") {
+		t.Errorf("pollForProofSetID error = %v, want a failed creation after 700 attempts", err)
+	}
+	if n := len(pdptoolCalls(t, calls, "")); n != 0 {
+		t.Errorf("pdptool ran %d times after the deadline", n)
+	}
+}
+
+func TestPollForProofSetIDStopsWithPoller(t *testing.T) {
+	useTestProofSetPolling(t)
+	fakePdptoolPendingForever(t)
+	proofSet := createCreatingProofSet(t, createTestUser(t, "0x1"), proofSetConfirming, "0x"+strings.Repeat("cd", 32))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := pollForProofSetID(ctx, cfg.PdptoolPath, proofSet)
+	if err == nil || errors.Is(err, errProofSetCreationFailed) {
+		t.Errorf("pollForProofSetID error = %v, want polling stopped without failing the creation", err)
+	}
+	db.First(&proofSet, proofSet.ID)
+	if proofSet.Status != proofSetConfirming || proofSet.PollAttempts == 0 {
+		t.Errorf("status %s after %d attempts, want confirming with progress saved", proofSet.Status, proofSet.PollAttempts)
+	}
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// ProofSetRootEntry is one root of a proof set joined with the file it holds.
+// @Description A root listed by the storage provider, or one a file expects but the service doesn't list. tracked is false for roots no file matches; missing is true for files whose confirmed root isn't listed, in which case rootId is the one recorded locally, if any.
+type ProofSetRootEntry struct {
+	RootID         string   `json:"rootId,omitempty"`
+	CID            string   `json:"cid"`
+	SubrootCIDs    []string `json:"subrootCids,omitempty"`
+	Tracked        bool     `json:"tracked"`
+	Missing        bool     `json:"missing"`
+	PieceID        *uint    `json:"pieceId,omitempty"`
+	Filename       string   `json:"filename,omitempty"`
+	RootStatus     string   `json:"rootStatus,omitempty"`
+	PendingRemoval bool     `json:"pendingRemoval,omitempty"`
+}
+
+// ProofSetRootsResponse lists a proof set's live roots.
+// @Description The roots a storage provider lists for a proof set, in its order, followed by the roots of files it doesn't list. Results may be up to 30 seconds old.
+type ProofSetRootsResponse struct {
+	ID         uint                `json:"id"`
+	ProofSetID string              `json:"proofSetId"`
+	Roots      []ProofSetRootEntry `json:"roots"`
+	Listed     int                 `json:"listed"`
+	Untracked  int                 `json:"untracked"`
+	Missing    int                 `json:"missing"`
+	CheckedAt  time.Time           `json:"checkedAt"`
+}
+
+// joinRoots lists roots with the pieces matchRoots gives them, then the
+// pieces whose root the service should list but doesn't.
+func joinRoots(pieces []models.Piece, roots []proofSetRoot) []ProofSetRootEntry {
+	owners := matchRoots(pieces, roots)
+	owned := make([]bool, len(pieces))
+	entries := make([]ProofSetRootEntry, 0, len(roots))
+	for i, root := range roots {
+		entry := ProofSetRootEntry{
+			RootID:      root.RootID,
+			CID:         root.CID,
+			SubrootCIDs: root.SubrootCIDs,
+		}
+		if owner := owners[i]; owner >= 0 {
+			owned[owner] = true
+			setRootEntryPiece(&entry, pieces[owner])
+		}
+		entries = append(entries, entry)
+	}
+	for p, piece := range pieces {
+		if owned[p] || !rootExpected(piece) {
+			continue
+		}
+		entry := ProofSetRootEntry{
+			RootID:  stringValue(piece.RootID),
+			CID:     baseCID(piece.CID),
+			Missing: true,
+		}
+		if subroot := subrootCID(piece.CID); subroot != "" {
+			entry.SubrootCIDs = []string{subroot}
+		}
+		setRootEntryPiece(&entry, piece)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func setRootEntryPiece(entry *ProofSetRootEntry, piece models.Piece) {
+	id := piece.ID
+	entry.Tracked = true
+	entry.PieceID = &id
+	entry.Filename = piece.Filename
+	entry.RootStatus = piece.RootStatus
+	entry.PendingRemoval = piece.PendingRemoval
+}
+
+// @Summary List a proof set's live roots
+// @Description Ask the storage provider for the roots of one of the caller's proof sets and join them with the caller's files by CID. Each root says whether a file tracks it and which; roots no file matches have tracked false, and files whose confirmed root the service doesn't list are appended with missing true.
+// @Tags proofset
+// @Param id path int true "Proof set database ID"
+// @Produce json
+// @Success 200 {object} ProofSetRootsResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 502 {object} ErrorResponse
+// @Router /api/v1/proof-sets/{id}/roots [get]
+func GetProofSetRoots(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	proofSet, err := findUserProofSet(c.Param("id"), userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Proof set not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch proof set")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch proof set",
+		})
+		return
+	}
+
+	switch {
+	case proofSet.ProofSetID == "":
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Proof set is still being created",
+		})
+		return
+	case proofSet.Status == proofSetDecommissioned:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Proof set has been decommissioned",
+		})
+		return
+	}
+
+	status, checkedAt, err := fetchProofSetStatus(c.Request.Context(), proofSet.ServiceURL, proofSet.ServiceName, proofSet.ProofSetID)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, errServiceUnreachable) {
+			code = http.StatusBadGateway
+		}
+		c.JSON(code, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	var pieces []models.Piece
+	if err := db.Where("proof_set_id = ? AND user_id = ?", proofSet.ID, userID).Order("id ASC").Find(&pieces).Error; err != nil {
+		log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to fetch proof set pieces")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch proof set roots",
+		})
+		return
+	}
+
+	response := ProofSetRootsResponse{
+		ID:         proofSet.ID,
+		ProofSetID: proofSet.ProofSetID,
+		Roots:      joinRoots(pieces, status.Roots),
+		Listed:     len(status.Roots),
+		CheckedAt:  checkedAt.UTC(),
+	}
+	for _, entry := range response.Roots {
+		switch {
+		case entry.Missing:
+			response.Missing++
+		case !entry.Tracked:
+			response.Untracked++
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+)
+
+func TestJoinRoots(t *testing.T) {
+	status := parseProofSetStatus(syncedProofSetOutput)
+	got := joinRoots(syncedPieces(), status.Roots)
+
+	want := []struct {
+		rootID   string
+		cid      string
+		subroots []string
+		pieceID  uint
+		missing  bool
+	}{
+		{"0", "bagaone", []string{"bagasub1"}, 1, false},
+		{"1", "bagatwo", []string{"bagasub2"}, 2, false},
+		{"2", "bagafour", []string{"bagasub4"}, 4, false},
+		{"3", "bagafive", nil, 5, false},
+		{"4", "bagasix", nil, 6, false},
+		{"6", "bagadup", []string{"bagadupa"}, 7, false},
+		{"7", "bagadup", []string{"bagadupb"}, 8, false},
+		{"9", "bagaorphan", nil, 0, false},
+		// The confirmed piece the service no longer lists, at its recorded
+		// root.
+		{"5", "bagathree", []string{"bagasub3"}, 3, true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("joinRoots returned %d entries, want %d: %+v", len(got), len(want), got)
+	}
+	for i, want := range want {
+		entry := got[i]
+		var pieceID uint
+		if entry.PieceID != nil {
+			pieceID = *entry.PieceID
+		}
+		if entry.RootID != want.rootID || entry.CID != want.cid || !slices.Equal(entry.SubrootCIDs, want.subroots) ||
+			pieceID != want.pieceID || entry.Tracked != (want.pieceID != 0) || entry.Missing != want.missing {
+			t.Errorf("entry %d = %+v, want root %s %s %v for piece %d, missing %v",
+				i, entry, want.rootID, want.cid, want.subroots, want.pieceID, want.missing)
+		}
+	}
+	if !got[3].PendingRemoval || got[4].RootStatus != rootStatusPending {
+		t.Errorf("piece details not carried over: %+v, %+v", got[3], got[4])
+	}
+
+	// The listing and the sync agree on which roots are untracked and which
+	// pieces are missing.
+	reconciled := reconcileRoots(syncedPieces(), status.Roots)
+	var untracked []string
+	var missing []uint
+	for _, entry := range got {
+		switch {
+		case entry.Missing:
+			missing = append(missing, *entry.PieceID)
+		case !entry.Tracked:
+			untracked = append(untracked, entry.RootID)
+		}
+	}
+	var orphans []string
+	for _, root := range reconciled.Orphans {
+		orphans = append(orphans, root.RootID)
+	}
+	if !slices.Equal(missing, reconciled.Missing) || !slices.Equal(untracked, orphans) {
+		t.Errorf("untracked %v and missing %v, sync found orphans %v and missing %v", untracked, missing, orphans, reconciled.Missing)
+	}
+}
+
+func TestJoinRootsWithNoRootsListed(t *testing.T) {
+	got := joinRoots(syncedPieces(), nil)
+
+	var missing []uint
+	for _, entry := range got {
+		if !entry.Missing || !entry.Tracked {
+			t.Errorf("entry %+v, want only missing pieces", entry)
+			continue
+		}
+		missing = append(missing, *entry.PieceID)
+	}
+	if want := []uint{1, 3, 4, 7}; !slices.Equal(missing, want) {
+		t.Errorf("missing = %v, want %v", missing, want)
+	}
+}
+
+func TestGetProofSetRoots(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	other := createTestUser(t, "0x2")
+	proofSet := createTestProofSet(t, user, "42")
+	unreachable := createTestProofSet(t, user, "43")
+	creating := createCreatingProofSet(t, user, proofSetConfirming, "0x"+strings.Repeat("cd", 32))
+	for _, piece := range syncedPieces() {
+		piece.UserID = user.ID
+		piece.ProofSetID = &proofSet.ID
+		piece.Filename = piece.CID
+		createTestPiece(t, piece)
+	}
+	fakePdptool(t, `case "$1" in get-proof-set)
+	case "$*" in *" 43") exit 1;; esac
+	cat <<'EOF'
+`+syncedProofSetOutput+`EOF
+;; esac`)
+	for _, id := range []string{"42", "43"} {
+		forgetProofSetStatus(cfg.ServiceURL, id)
+		t.Cleanup(func() { forgetProofSetStatus(cfg.ServiceURL, id) })
+	}
+	request := func(proofSet models.ProofSet, user models.User) (int, ProofSetRootsResponse) {
+		id := strconv.FormatUint(uint64(proofSet.ID), 10)
+		c, recorder := newTestContext(http.MethodGet, "/api/v1/proof-sets/"+id+"/roots", nil, user)
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		GetProofSetRoots(c)
+		var response ProofSetRootsResponse
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder.Code, response
+	}
+
+	code, listing := request(proofSet, user)
+	if code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", code, http.StatusOK)
+	}
+	if listing.ProofSetID != "42" || listing.Listed != 8 || listing.Untracked != 1 || listing.Missing != 1 || len(listing.Roots) != 9 {
+		t.Fatalf("listing = %+v, want 8 roots listed, 1 untracked and 1 missing", listing)
+	}
+	if entry := listing.Roots[1]; entry.PieceID == nil || *entry.PieceID != 2 || entry.Filename != "bagatwo:bagasub2" {
+		t.Errorf("root 1 = %+v, want piece 2", entry)
+	}
+
+	tests := []struct {
+		name     string
+		proofSet models.ProofSet
+		user     models.User
+		want     int
+	}{
+		{"another user's proof set", proofSet, other, http.StatusNotFound},
+		{"proof set still being created", creating, user, http.StatusConflict},
+		{"service unreachable", unreachable, user, http.StatusBadGateway},
+	}
+	for _, test := range tests {
+		if code, _ := request(test.proofSet, test.user); code != test.want {
+			t.Errorf("%s: status = %d, want %d", test.name, code, test.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// proofSetStatusTTL is how long a proof set fetched with get-proof-set,
+	// or the failure to fetch it, is reused before the service is asked
+	// again.
+	proofSetStatusTTL = 30 * time.Second
+	// proofSetStatusTimeout caps how long one get-proof-set call may take.
+	proofSetStatusTimeout = 20 * time.Second
+)
+
+// proofSetRoot is one root listed by pdptool get-proof-set.
+type proofSetRoot struct {
+	RootID      string
+	CID         string
+	SubrootCIDs []string
+}
+
+// proofSetStatus is what pdptool get-proof-set reports about a proof set.
+// NextChallengeEpoch and LastProvenEpoch are nil, and LastProofTx empty,
+// when the output doesn't include them. Fields holds every "Key: value" line about the proof set
+// itself, as printed, including those not parsed into the other fields.
+type proofSetStatus struct {
+	ProofSetID         string
+	NextChallengeEpoch *int64
+	LastProvenEpoch    *int64
+	LastProofTx        string
+	Fields             map[string]string
+	Roots              []proofSetRoot
+}
+
+// root returns the root with rootID or, when rootID is empty, the root
+// whose CID is cid.
+func (s proofSetStatus) root(rootID, cid string) (proofSetRoot, bool) {
+	for _, root := range s.Roots {
+		if rootID != "" && root.RootID == rootID {
+			return root, true
+		}
+		if rootID == "" && cid != "" && root.CID == cid {
+			return root, true
+		}
+	}
+	return proofSetRoot{}, false
+}
+
+// parseProofSetStatus parses the output of pdptool get-proof-set, which
+// lists "Key: value" lines for the proof set followed by a block of lines
+// for each root starting with its "Root ID". The proof set's lines with a
+// value are all kept in Fields; root lines it doesn't recognise are
+// ignored, as are roots whose ID isn't an integer.
+func parseProofSetStatus(output string) proofSetStatus {
+	status := proofSetStatus{Fields: make(map[string]string)}
+	var current *proofSetRoot
+	inRoots := false
+
+	for _, line := range strings.Split(output, "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(strings.TrimLeft(name, "-* "))
+		key := strings.ToLower(name)
+		value = strings.TrimSpace(value)
+		if key == "root id" {
+			inRoots = true
+		}
+		if !inRoots && name != "" && value != "" {
+			status.Fields[name] = value
+		}
+
+		switch key {
+		case "proof set id":
+			status.ProofSetID = value
+		case "next challenge epoch":
+			status.NextChallengeEpoch = parseEpoch(value)
+		case "last proven epoch":
+			status.LastProvenEpoch = parseEpoch(value)
+		case "last proof tx", "last proof transaction":
+			status.LastProofTx = value
+		case "root id":
+			current = nil
+			if _, err := strconv.ParseUint(value, 10, 64); err == nil {
+				status.Roots = append(status.Roots, proofSetRoot{RootID: value})
+				current = &status.Roots[len(status.Roots)-1]
+			}
+		case "root cid":
+			if current != nil {
+				current.CID = value
+			}
+		case "subroot cid":
+			if current != nil && value != "" {
+				current.SubrootCIDs = append(current.SubrootCIDs, value)
+			}
+		}
+	}
+	return status
+}
+
+func parseEpoch(value string) *int64 {
+	epoch, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &epoch
+}
+
+type proofSetStatusEntry struct {
+	status  proofSetStatus
+	err     error
+	expires time.Time
+}
+
+var (
+	proofSetStatusCache      = make(map[string]proofSetStatusEntry)
+	proofSetStatusCacheMutex sync.Mutex
+)
+
+// forgetProofSetStatus drops the cached result for a proof set, so the next
+// fetchProofSetStatus asks the service.
+func forgetProofSetStatus(serviceURL, proofSetID string) {
+	proofSetStatusCacheMutex.Lock()
+	delete(proofSetStatusCache, serviceURL+"\x00"+proofSetID)
+	proofSetStatusCacheMutex.Unlock()
+}
+
+// errServiceUnreachable is returned by fetchProofSetStatus when pdptool
+// couldn't get the proof set from the service.
+var errServiceUnreachable = errors.New("the storage provider could not be reached")
+
+// fetchProofSetStatus runs pdptool get-proof-set for a proof set on a
+// service, reusing the result of a call made within proofSetStatusTTL.
+func fetchProofSetStatus(ctx context.Context, serviceURL, serviceName, proofSetID string) (proofSetStatus, time.Time, error) {
+	key := serviceURL + "\x00" + proofSetID
+	now := time.Now()
+
+	proofSetStatusCacheMutex.Lock()
+	entry, ok := proofSetStatusCache[key]
+	proofSetStatusCacheMutex.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.status, entry.expires.Add(-proofSetStatusTTL), entry.err
+	}
+
+	pdptoolPath, err := preparePdptool(ctx)
+	if err != nil {
+		// A local problem, so not worth caching.
+		return proofSetStatus{}, now, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, proofSetStatusTimeout)
+	defer cancel()
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, pdptoolPath,
+		"get-proof-set",
+		"--service-url", serviceURL,
+		"--service-name", serviceName,
+		proofSetID,
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	entry = proofSetStatusEntry{expires: now.Add(proofSetStatusTTL)}
+	if err := cmd.Run(); err != nil {
+		log.WithField("proofSetID", proofSetID).
+			WithField("error", err.Error()).
+			WithField("stderr", stderr.String()).
+			Warning("pdptool get-proof-set failed while checking proof status")
+		entry.err = errServiceUnreachable
+	} else {
+		entry.status = parseProofSetStatus(stdout.String())
+	}
+
+	proofSetStatusCacheMutex.Lock()
+	for cached, e := range proofSetStatusCache {
+		if !now.Before(e.expires) {
+			delete(proofSetStatusCache, cached)
+		}
+	}
+	proofSetStatusCache[key] = entry
+	proofSetStatusCacheMutex.Unlock()
+
+	return entry.status, now, entry.err
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func int64Pointer(value int64) *int64 {
+	return &value
+}
+
+func TestParseProofSetStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   proofSetStatus
+	}{
+		{
+			name: "proof set with roots",
+			output: `Proof Set ID: 42
+Owner: 0xabc
+Next Challenge Epoch: 2581934
+Last Proven Epoch: 2581874
+Last Proof Tx: 0xfeed
+Roots:
+  - Root ID: 0
+    Root CID: bagaone
+    Subroots:
+      - Subroot CID: bagasub1
+        Subroot Offset: 0
+      - Subroot CID: bagasub2
+        Subroot Offset: 1024
+  - Root ID: 3
+    Root CID: bagatwo
+    Subroots:
+      - Subroot CID: bagasub3
+`,
+			want: proofSetStatus{
+				ProofSetID:         "42",
+				NextChallengeEpoch: int64Pointer(2581934),
+				LastProvenEpoch:    int64Pointer(2581874),
+				LastProofTx:        "0xfeed",
+				Fields: map[string]string{
+					"Proof Set ID":         "42",
+					"Owner":                "0xabc",
+					"Next Challenge Epoch": "2581934",
+					"Last Proven Epoch":    "2581874",
+					"Last Proof Tx":        "0xfeed",
+				},
+				Roots: []proofSetRoot{
+					{RootID: "0", CID: "bagaone", SubrootCIDs: []string{"bagasub1", "bagasub2"}},
+					{RootID: "3", CID: "bagatwo", SubrootCIDs: []string{"bagasub3"}},
+				},
+			},
+		},
+		{
+			name: "never proven, no roots",
+			output: `Proof Set ID: 7
+Next Challenge Epoch: not scheduled
+Last Proven Epoch:
+Last Proof Transaction: 0xbeef
+`,
+			want: proofSetStatus{
+				ProofSetID:  "7",
+				LastProofTx: "0xbeef",
+				Fields: map[string]string{
+					"Proof Set ID":           "7",
+					"Next Challenge Epoch":   "not scheduled",
+					"Last Proof Transaction": "0xbeef",
+				},
+			},
+		},
+		{
+			name: "root lines outside a valid root",
+			output: `Proof Set ID: 42
+Root CID: bagastray
+Root ID: pending
+Root CID: bagapending
+Subroot CID: bagasubpending
+Root ID: 5
+Root CID: bagafive
+Root Size: 2048
+`,
+			want: proofSetStatus{
+				ProofSetID: "42",
+				Fields:     map[string]string{"Proof Set ID": "42", "Root CID": "bagastray"},
+				Roots:      []proofSetRoot{{RootID: "5", CID: "bagafive"}},
+			},
+		},
+		{
+			name:   "not get-proof-set output",
+			output: "Error: proof set not found\nusage: pdptool get-proof-set [options] <id>",
+			want:   proofSetStatus{Fields: map[string]string{"Error": "proof set not found", "usage": "pdptool get-proof-set [options] <id>"}},
+		},
+		{
+			name:   "empty",
+			output: "",
+			want:   proofSetStatus{Fields: map[string]string{}},
+		},
+	}
+	for _, test := range tests {
+		if got := parseProofSetStatus(test.output); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parseProofSetStatus = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestProofSetStatusRoot(t *testing.T) {
+	status := parseProofSetStatus("Root ID: 1\nRoot CID: bagaone\nRoot ID: 2\nRoot CID: bagatwo\n")
+
+	tests := []struct {
+		rootID, cid string
+		want        string
+		found       bool
+	}{
+		{"2", "", "2", true},
+		{"2", "bagaone", "2", true},
+		{"", "bagaone", "1", true},
+		{"9", "bagaone", "", false},
+		{"", "bagathree", "", false},
+		{"", "", "", false},
+	}
+	for _, test := range tests {
+		root, found := status.root(test.rootID, test.cid)
+		if found != test.found || root.RootID != test.want {
+			t.Errorf("root(%q, %q) = %q, %v, want %q, %v", test.rootID, test.cid, root.RootID, found, test.want, test.found)
+		}
+	}
+}
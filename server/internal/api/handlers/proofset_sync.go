@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// proofSetSyncInterval is how often every proof set is reconciled with the
+// roots its service lists.
+const proofSetSyncInterval = time.Hour
+
+// proofSetSyncLock keeps syncs from running at the same time, so a manual
+// sync and the periodic one never update the same pieces together.
+var proofSetSyncLock sync.Mutex
+
+// ProofSetSyncResponse is the result of reconciling a proof set with its service
+// @Description Result of comparing a proof set's roots on the storage provider with its files. filledPieceIds got the root ID the service assigned them, missingPieceIds are files whose root the service no longer lists (flagged missingOnService), restoredPieceIds lost that flag, and orphans are roots no file matches. error is set instead when the service couldn't be asked.
+type ProofSetSyncResponse struct {
+	ProofSetDbID     uint                   `json:"proofSetDbId"`
+	ProofSetID       string                 `json:"proofSetId"`
+	Roots            int                    `json:"roots"`
+	Matched          int                    `json:"matched"`
+	FilledPieceIDs   []uint                 `json:"filledPieceIds"`
+	MissingPieceIDs  []uint                 `json:"missingPieceIds"`
+	RestoredPieceIDs []uint                 `json:"restoredPieceIds"`
+	Orphans          []ProofSetRootResponse `json:"orphans"`
+	Error            string                 `json:"error,omitempty"`
+	SyncedAt         time.Time              `json:"syncedAt"`
+}
+
+// rootReconciliation is how a proof set's pieces compare with the roots its
+// service lists.
+type rootReconciliation struct {
+	Matched int
+	// Fill maps pieces lacking the ID of their listed root to that ID.
+	Fill     map[uint]string
+	Missing  []uint // confirmed pieces whose root isn't listed
+	Restored []uint // pieces flagged missing whose root is listed again
+	Orphans  []proofSetRoot
+}
+
+// Passes of matchRoots, in the order they are made.
+const (
+	matchByRootID = iota
+	matchBySubroot
+	matchByBaseCID
+)
+
+// matchRoots matches pieces to roots by base CID and returns, for each
+// root, the index in pieces of the piece it matched, or -1. Roots are first
+// given to the pieces holding their root ID, then to the other pieces whose
+// subroot CID they list, then to those left with their base CID, so a root
+// added twice matches two pieces and no root ever goes to two. Pieces
+// without a matching root ID take the most recently added root they match.
+func matchRoots(pieces []models.Piece, roots []proofSetRoot) []int {
+	owners := make([]int, len(roots))
+	for i := range owners {
+		owners[i] = -1
+	}
+	matched := make([]bool, len(pieces))
+	for _, pass := range []int{matchByRootID, matchBySubroot, matchByBaseCID} {
+		for p, piece := range pieces {
+			if matched[p] || piece.CID == "" || (pass == matchByRootID && piece.RootID == nil) {
+				continue
+			}
+			subroot := subrootCID(piece.CID)
+			if pass == matchBySubroot && subroot == "" {
+				continue
+			}
+			for j := range roots {
+				i := j
+				if pass != matchByRootID {
+					i = len(roots) - 1 - j
+				}
+				root := roots[i]
+				if owners[i] >= 0 || root.CID != baseCID(piece.CID) {
+					continue
+				}
+				if pass == matchByRootID && root.RootID != *piece.RootID {
+					continue
+				}
+				if pass == matchBySubroot && !slices.Contains(root.SubrootCIDs, subroot) {
+					continue
+				}
+				owners[i] = p
+				matched[p] = true
+				break
+			}
+		}
+	}
+	return owners
+}
+
+// resolveRootIDs finds the root ID of each of cids among roots, which must
+// not be held by one of pieces, the proof set's other pieces. A CID whose
+// root isn't listed, or only under a root ID another piece holds, gets "".
+func resolveRootIDs(pieces []models.Piece, cids []string, roots []proofSetRoot) []string {
+	candidates := make([]models.Piece, 0, len(pieces)+len(cids))
+	candidates = append(candidates, pieces...)
+	for _, cid := range cids {
+		candidates = append(candidates, models.Piece{CID: cid})
+	}
+	rootIDs := make([]string, len(cids))
+	for i, owner := range matchRoots(candidates, roots) {
+		if owner >= len(pieces) {
+			rootIDs[owner-len(pieces)] = roots[i].RootID
+		}
+	}
+	return rootIDs
+}
+
+// rootExpected reports whether the service should list piece's root: it
+// was confirmed and the piece isn't being removed.
+func rootExpected(piece models.Piece) bool {
+	return piece.RootStatus == rootStatusConfirmed && !piece.PendingRemoval
+}
+
+// reconcileRoots compares pieces with the roots the service lists, matched
+// by matchRoots. Pieces whose root is still being added are left to the
+// root worker and those pending removal are expected to lose their root,
+// but both claim a listed root so it isn't reported as an orphan.
+func reconcileRoots(pieces []models.Piece, roots []proofSetRoot) rootReconciliation {
+	owners := matchRoots(pieces, roots)
+	matched := make(map[uint]proofSetRoot, len(pieces))
+	for i, owner := range owners {
+		if owner >= 0 {
+			matched[pieces[owner].ID] = roots[i]
+		}
+	}
+
+	result := rootReconciliation{Fill: make(map[uint]string)}
+	for _, piece := range pieces {
+		root, found := matched[piece.ID]
+		switch {
+		case piece.RootStatus == rootStatusPending || piece.PendingRemoval:
+		case found:
+			result.Matched++
+			if piece.RootID == nil || *piece.RootID != root.RootID || piece.RootStatus != rootStatusConfirmed {
+				result.Fill[piece.ID] = root.RootID
+			}
+			if piece.RootMissing {
+				result.Restored = append(result.Restored, piece.ID)
+			}
+		case rootExpected(piece):
+			result.Missing = append(result.Missing, piece.ID)
+		}
+	}
+	for i, root := range roots {
+		if owners[i] < 0 {
+			result.Orphans = append(result.Orphans, root)
+		}
+	}
+	return result
+}
+
+// applyReconciliation records what reconcileRoots found on the pieces, with
+// an event for each root found or newly missing.
+func applyReconciliation(tx *gorm.DB, pieces []models.Piece, result rootReconciliation) error {
+	byID := make(map[uint]models.Piece, len(pieces))
+	for _, piece := range pieces {
+		byID[piece.ID] = piece
+	}
+
+	for pieceID, rootID := range result.Fill {
+		piece := byID[pieceID]
+		if err := tx.Model(&piece).Updates(map[string]interface{}{
+			"root_id":      rootID,
+			"root_status":  rootStatusConfirmed,
+			"root_missing": false,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.PieceEvent{
+			PieceID: piece.ID,
+			UserID:  piece.UserID,
+			Action:  pieceEventRootConfirmed,
+			To:      rootID,
+			Detail:  "found on the service by a proof set sync",
+		}).Error; err != nil {
+			return err
+		}
+	}
+	if len(result.Restored) > 0 {
+		if err := tx.Model(&models.Piece{}).Where("id IN ?", result.Restored).Update("root_missing", false).Error; err != nil {
+			return err
+		}
+	}
+	for _, pieceID := range result.Missing {
+		piece := byID[pieceID]
+		if piece.RootMissing {
+			continue
+		}
+		if err := tx.Model(&piece).Update("root_missing", true).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.PieceEvent{
+			PieceID: piece.ID,
+			UserID:  piece.UserID,
+			Action:  pieceEventRootMissing,
+			From:    stringValue(piece.RootID),
+			Detail:  "the service no longer lists the root",
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncProofSet reconciles proofSet's pieces with the roots its service lists
+// right now and saves the result as the proof set's last sync. A service
+// that can't be asked is reported in the result; the error is for failures
+// to read or save the pieces.
+func syncProofSet(ctx context.Context, proofSet models.ProofSet) (ProofSetSyncResponse, error) {
+	proofSetSyncLock.Lock()
+	defer proofSetSyncLock.Unlock()
+
+	response := ProofSetSyncResponse{
+		ProofSetDbID:     proofSet.ID,
+		ProofSetID:       proofSet.ProofSetID,
+		FilledPieceIDs:   []uint{},
+		MissingPieceIDs:  []uint{},
+		RestoredPieceIDs: []uint{},
+		Orphans:          []ProofSetRootResponse{},
+		SyncedAt:         time.Now().UTC(),
+	}
+
+	forgetProofSetStatus(proofSet.ServiceURL, proofSet.ProofSetID)
+	status, _, err := fetchProofSetStatus(ctx, proofSet.ServiceURL, proofSet.ServiceName, proofSet.ProofSetID)
+	if err != nil {
+		response.Error = err.Error()
+		return response, saveProofSetSync(proofSet, response)
+	}
+
+	var pieces []models.Piece
+	if err := db.Where("proof_set_id = ?", proofSet.ID).Find(&pieces).Error; err != nil {
+		return response, err
+	}
+	result := reconcileRoots(pieces, status.Roots)
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return applyReconciliation(tx, pieces, result)
+	}); err != nil {
+		return response, err
+	}
+
+	response.Roots = len(status.Roots)
+	response.Matched = result.Matched
+	for pieceID := range result.Fill {
+		response.FilledPieceIDs = append(response.FilledPieceIDs, pieceID)
+	}
+	response.MissingPieceIDs = append(response.MissingPieceIDs, result.Missing...)
+	response.RestoredPieceIDs = append(response.RestoredPieceIDs, result.Restored...)
+	for _, root := range result.Orphans {
+		response.Orphans = append(response.Orphans, ProofSetRootResponse{
+			RootID:      root.RootID,
+			CID:         root.CID,
+			SubrootCIDs: root.SubrootCIDs,
+		})
+	}
+
+	log.WithField("proofSetID", proofSet.ID).
+		WithField("roots", response.Roots).
+		WithField("matched", response.Matched).
+		WithField("filled", len(response.FilledPieceIDs)).
+		WithField("missing", len(response.MissingPieceIDs)).
+		WithField("orphans", len(response.Orphans)).
+		Info("Synced proof set with service")
+	return response, saveProofSetSync(proofSet, response)
+}
+
+// saveProofSetSync replaces the proof set's last sync with response.
+func saveProofSetSync(proofSet models.ProofSet, response ProofSetSyncResponse) error {
+	report, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return db.Where(models.ProofSetSync{ProofSetID: proofSet.ID}).
+		Assign(map[string]interface{}{
+			"user_id": proofSet.UserID,
+			"error":   response.Error,
+			"report":  string(report),
+		}).
+		FirstOrCreate(&models.ProofSetSync{}).Error
+}
+
+// syncProofSets syncs every ready proof set, returning how many were
+// synced.
+func syncProofSets(ctx context.Context) int {
+	var proofSets []models.ProofSet
+	if err := db.Where("status = ?", proofSetReady).Find(&proofSets).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to load proof sets to sync")
+		return 0
+	}
+
+	synced := 0
+	for _, proofSet := range proofSets {
+		if ctx.Err() != nil {
+			break
+		}
+		if _, err := syncProofSet(ctx, proofSet); err != nil {
+			log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to sync proof set")
+			continue
+		}
+		synced++
+	}
+	return synced
+}
+
+// @Summary Sync a proof set with its service
+// @Description Compare one of the caller's proof sets with the roots its storage provider lists right now. Files are matched to roots by CID: files lacking the root ID the service assigned get it, files whose root is no longer listed are flagged missingOnService, and roots matching no file are reported as orphans. The result is kept as the proof set's last sync. Proof sets are also synced hourly.
+// @Tags proofset
+// @Param id path int true "Proof set database ID"
+// @Produce json
+// @Success 200 {object} ProofSetSyncResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/proof-sets/{id}/sync [post]
+func SyncProofSet(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	proofSet, err := findUserProofSet(c.Param("id"), userID)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Proof set not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch proof set")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch proof set",
+		})
+		return
+	}
+
+	switch {
+	case proofSet.ProofSetID == "":
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Proof set is still being created",
+		})
+		return
+	case proofSet.Status == proofSetDecommissioned:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Proof set has been decommissioned",
+		})
+		return
+	}
+
+	response, err := syncProofSet(c.Request.Context(), proofSet)
+	if err != nil {
+		log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to sync proof set")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to sync proof set",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Get a proof set's last sync
+// @Description Get the result of the last time one of the caller's proof sets was compared with the roots on its storage provider, whether it was synced on request or periodically
+// @Tags proofset
+// @Param id path int true "Proof set database ID"
+// @Produce json
+// @Success 200 {object} ProofSetSyncResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/proof-sets/{id}/sync [get]
+func GetProofSetSync(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var last models.ProofSetSync
+	if err := db.Where("proof_set_id = ? AND user_id = ?", c.Param("id"), userID).First(&last).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Proof set has not been synced yet",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch proof set sync")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch proof set sync",
+		})
+		return
+	}
+
+	var response ProofSetSyncResponse
+	if err := json.Unmarshal([]byte(last.Report), &response); err != nil {
+		log.WithField("proofSetID", last.ProofSetID).WithField("error", err.Error()).Error("Failed to decode proof set sync")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch proof set sync",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+)
+
+// syncedProofSetOutput is get-proof-set output for the pieces of
+// syncedPieces, with a root none of them match.
+const syncedProofSetOutput = `Proof Set ID: 42
+Next Challenge Epoch: 2581934
+Roots:
+  - Root ID: 0
+    Root CID: bagaone
+    Subroots:
+      - Subroot CID: bagasub1
+  - Root ID: 1
+    Root CID: bagatwo
+    Subroots:
+      - Subroot CID: bagasub2
+  - Root ID: 2
+    Root CID: bagafour
+    Subroots:
+      - Subroot CID: bagasub4
+  - Root ID: 3
+    Root CID: bagafive
+  - Root ID: 4
+    Root CID: bagasix
+  - Root ID: 6
+    Root CID: bagadup
+    Subroots:
+      - Subroot CID: bagadupa
+  - Root ID: 7
+    Root CID: bagadup
+    Subroots:
+      - Subroot CID: bagadupb
+  - Root ID: 9
+    Root CID: bagaorphan
+`
+
+// syncedPieces are the pieces of a proof set in every state a sync tells
+// apart.
+func syncedPieces() []models.Piece {
+	rootID := func(id string) *string { return &id }
+	return []models.Piece{
+		// Matched, with the root ID already recorded.
+		{ID: 1, CID: "bagaone:bagasub1", RootID: rootID("0"), RootStatus: rootStatusConfirmed},
+		// Added, but its root ID was never found.
+		{ID: 2, CID: "bagatwo:bagasub2", RootStatus: rootStatusUnconfirmed},
+		// Confirmed, but the service no longer lists it.
+		{ID: 3, CID: "bagathree:bagasub3", RootID: rootID("5"), RootStatus: rootStatusConfirmed},
+		// Flagged missing before, listed again now.
+		{ID: 4, CID: "bagafour:bagasub4", RootID: rootID("2"), RootStatus: rootStatusConfirmed, RootMissing: true},
+		// Being removed, and still listed until the removal goes through.
+		{ID: 5, CID: "bagafive:bagasub5", RootID: rootID("3"), RootStatus: rootStatusConfirmed, PendingRemoval: true},
+		// Still being added by the root worker.
+		{ID: 6, CID: "bagasix:bagasub6", RootStatus: rootStatusPending},
+		// The same file added twice, told apart by subroot.
+		{ID: 7, CID: "bagadup:bagadupa", RootID: rootID("6"), RootStatus: rootStatusConfirmed},
+		{ID: 8, CID: "bagadup:bagadupb", RootStatus: rootStatusUnconfirmed},
+	}
+}
+
+func TestReconcileRoots(t *testing.T) {
+	status := parseProofSetStatus(syncedProofSetOutput)
+	got := reconcileRoots(syncedPieces(), status.Roots)
+
+	want := rootReconciliation{
+		Matched:  5,
+		Fill:     map[uint]string{2: "1", 8: "7"},
+		Missing:  []uint{3},
+		Restored: []uint{4},
+		Orphans:  []proofSetRoot{{RootID: "9", CID: "bagaorphan"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("reconcileRoots = %+v, want %+v", got, want)
+	}
+}
+
+func TestReconcileRootsWithNoRootsListed(t *testing.T) {
+	got := reconcileRoots(syncedPieces(), nil)
+
+	if got.Matched != 0 || len(got.Fill) != 0 || len(got.Orphans) != 0 || len(got.Restored) != 0 {
+		t.Errorf("reconcileRoots = %+v, want only missing pieces", got)
+	}
+	// Only confirmed pieces that aren't being removed are expected on the
+	// service.
+	if want := []uint{1, 3, 4, 7}; !slices.Equal(got.Missing, want) {
+		t.Errorf("missing = %v, want %v", got.Missing, want)
+	}
+}
+
+func TestMatchRoots(t *testing.T) {
+	rootID := func(id string) *string { return &id }
+	roots := parseProofSetStatus(`Root ID: 1
+Root CID: bagaone
+Subroot CID: bagasuba
+Root ID: 2
+Root CID: bagaone
+Subroot CID: bagasubb
+Root ID: 3
+Root CID: bagaone
+Subroot CID: bagasubc
+`).Roots
+
+	tests := []struct {
+		name   string
+		pieces []models.Piece
+		want   []int
+	}{
+		{"by subroot", []models.Piece{{CID: "bagaone:bagasubb"}}, []int{-1, 0, -1}},
+		{"by base CID, taking the newest root", []models.Piece{{CID: "bagaone:bagaother"}}, []int{-1, -1, 0}},
+		{"by base CID alone", []models.Piece{{CID: "bagaone"}}, []int{-1, -1, 0}},
+		{"recorded root ID before subroot", []models.Piece{{CID: "bagaone:bagasubc"}, {CID: "bagaone:bagaother", RootID: rootID("3")}}, []int{-1, 0, 1}},
+		{"a root to one piece only", []models.Piece{{CID: "bagaone:bagasuba"}, {CID: "bagaone:bagasuba"}, {CID: "bagaone:bagasuba"}, {CID: "bagaone:bagasuba"}}, []int{0, 2, 1}},
+		{"no piece for the base CID", []models.Piece{{CID: "bagatwo:bagasuba"}, {CID: ""}}, []int{-1, -1, -1}},
+		{"recorded root ID of another CID", []models.Piece{{CID: "bagatwo:bagasubz", RootID: rootID("1")}}, []int{-1, -1, -1}},
+	}
+	for _, test := range tests {
+		if got := matchRoots(test.pieces, roots); !slices.Equal(got, test.want) {
+			t.Errorf("%s: matchRoots = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestResolveRootIDs(t *testing.T) {
+	rootID := func(id string) *string { return &id }
+	roots := parseProofSetStatus("Root ID: 6\nRoot CID: bagaone\nRoot ID: 8\nRoot CID: bagaone\nRoot ID: 9\nRoot CID: bagatwo\n").Roots
+	others := []models.Piece{{CID: "bagaone:bagaold", RootID: rootID("8")}}
+
+	got := resolveRootIDs(others, []string{"bagaone:bagasub", "bagatwo:bagasub", "bagathree:bagasub"}, roots)
+	if want := []string{"6", "9", ""}; !slices.Equal(got, want) {
+		t.Errorf("resolveRootIDs = %q, want %q", got, want)
+	}
+}
+
+func TestSyncProofSet(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	other := createTestUser(t, "0x2")
+	proofSet := createTestProofSet(t, user, "42")
+	for _, piece := range syncedPieces() {
+		piece.UserID = user.ID
+		piece.ProofSetID = &proofSet.ID
+		piece.Filename = piece.CID
+		createTestPiece(t, piece)
+	}
+	fakePdptool(t, `case "$1" in get-proof-set) cat <<'EOF'
+`+syncedProofSetOutput+`EOF
+;; esac`)
+	id := strconv.FormatUint(uint64(proofSet.ID), 10)
+	request := func(method string, handler gin.HandlerFunc, user models.User) (int, ProofSetSyncResponse) {
+		c, recorder := newTestContext(method, "/api/v1/proof-sets/"+id+"/sync", nil, user)
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		handler(c)
+		var response ProofSetSyncResponse
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder.Code, response
+	}
+
+	if code, _ := request(http.MethodGet, GetProofSetSync, user); code != http.StatusNotFound {
+		t.Errorf("before a sync: status = %d, want %d", code, http.StatusNotFound)
+	}
+	if code, _ := request(http.MethodPost, SyncProofSet, other); code != http.StatusNotFound {
+		t.Errorf("another user's proof set: status = %d, want %d", code, http.StatusNotFound)
+	}
+	code, synced := request(http.MethodPost, SyncProofSet, user)
+	if code != http.StatusOK {
+		t.Fatalf("sync: status = %d, want %d", code, http.StatusOK)
+	}
+	slices.Sort(synced.FilledPieceIDs)
+	if synced.Roots != 8 || synced.Matched != 5 || !slices.Equal(synced.FilledPieceIDs, []uint{2, 8}) ||
+		!slices.Equal(synced.MissingPieceIDs, []uint{3}) || !slices.Equal(synced.RestoredPieceIDs, []uint{4}) ||
+		len(synced.Orphans) != 1 || synced.Orphans[0].RootID != "9" {
+		t.Errorf("sync = %+v", synced)
+	}
+
+	var pieces []models.Piece
+	db.Order("id").Find(&pieces)
+	for _, want := range []struct {
+		id      uint
+		rootID  string
+		status  string
+		missing bool
+	}{
+		{2, "1", rootStatusConfirmed, false},
+		{3, "5", rootStatusConfirmed, true},
+		{4, "2", rootStatusConfirmed, false},
+		{6, "", rootStatusPending, false},
+		{8, "7", rootStatusConfirmed, false},
+	} {
+		piece := pieces[want.id-1]
+		if stringValue(piece.RootID) != want.rootID || piece.RootStatus != want.status || piece.RootMissing != want.missing {
+			t.Errorf("piece %d: root %q %s, missing %v, want root %q %s, missing %v",
+				want.id, stringValue(piece.RootID), piece.RootStatus, piece.RootMissing, want.rootID, want.status, want.missing)
+		}
+	}
+
+	code, last := request(http.MethodGet, GetProofSetSync, user)
+	slices.Sort(last.FilledPieceIDs)
+	if code != http.StatusOK || !reflect.DeepEqual(last.FilledPieceIDs, synced.FilledPieceIDs) || last.Matched != synced.Matched {
+		t.Errorf("last sync: status = %d, report %+v, want %+v", code, last, synced)
+	}
+	if code, _ := request(http.MethodGet, GetProofSetSync, other); code != http.StatusNotFound {
+		t.Errorf("another user's last sync: status = %d, want %d", code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	errTransferTargetNotFound    = errors.New("No user has signed in with the target wallet")
+	errTransferToOwner           = errors.New("The proof set already belongs to the target wallet")
+	errTransferTargetHasProofSet = errors.New("The target wallet already has a proof set")
+	errTransferNotReady          = errors.New("Only a ready proof set can be transferred")
+	errTransferUploadsActive     = errors.New("Uploads to the proof set are still in progress. Wait for them to finish or cancel them first")
+	errTransferRootsPending      = errors.New("Files are still being added to the proof set. Wait for their roots to be confirmed first")
+	errTransferRemovalsPending   = errors.New("Files in the proof set are pending removal. Wait for their removal to finish first")
+	errTransferCIDTaken          = errors.New("The target wallet already has some of the files in this proof set")
+)
+
+// TransferProofSetRequest names who a proof set is transferred to
+// @Description Wallet address of the user to transfer the proof set to. They must have signed in at least once.
+type TransferProofSetRequest struct {
+	WalletAddress string `json:"walletAddress" binding:"required" example:"0x742d35Cc6634C0532925a3b844Bc454e4438f44e"`
+}
+
+// TransferProofSetResponse describes a finished transfer
+// @Description The transferred proof set and who it moved between. Only ownership in this app moves: the proof set stays on chain as it was created, so payerWallet, the wallet it was created for, keeps paying for it. billing spells this out.
+type TransferProofSetResponse struct {
+	ProofSet          ProofSetWithPieces `json:"proofSet"`
+	FromWallet        string             `json:"fromWallet"`
+	ToWallet          string             `json:"toWallet"`
+	PiecesTransferred int64              `json:"piecesTransferred"`
+	PayerWallet       string             `json:"payerWallet"`
+	Billing           string             `json:"billing"`
+}
+
+// isAdmin reports whether the caller's wallet is one of ADMIN_WALLETS.
+func isAdmin(c *gin.Context) bool {
+	wallet := strings.ToLower(c.GetString("walletAddress"))
+	for _, admin := range cfg.AdminWallets {
+		if admin == wallet {
+			return true
+		}
+	}
+	return false
+}
+
+// proofSetPayer returns the wallet paying for proofSet on chain: the one
+// named as payer when it was created, which is the wallet of whoever owned
+// it then. owner's wallet is assumed when the creation wasn't recorded.
+func proofSetPayer(tx *gorm.DB, proofSet models.ProofSet, owner models.User) (string, error) {
+	var creation models.Transaction
+	err := tx.Where("proof_set_id = ? AND method = ?", proofSet.ID, transactionMethodCreateProofSet).
+		Order("created_at ASC, id ASC").
+		First(&creation).Error
+	switch {
+	case err == nil && creation.WalletAddress != "":
+		return creation.WalletAddress, nil
+	case err == nil, errors.Is(err, gorm.ErrRecordNotFound):
+		return owner.WalletAddress, nil
+	default:
+		return "", err
+	}
+}
+
+// checkProofSetTransferable returns why proofSet, owned by owner, can't be
+// moved yet, or nil when it can.
+func checkProofSetTransferable(tx *gorm.DB, proofSet models.ProofSet, owner models.User) error {
+	if proofSet.Status != proofSetReady {
+		return errTransferNotReady
+	}
+
+	// Jobs left pending stopped because the proof set wasn't ready yet.
+	// Nothing resumes them, so they don't hold up the transfer, which ends
+	// them.
+	var count int64
+	if err := tx.Model(&models.UploadJob{}).
+		Where("user_id = ? AND status NOT IN ? AND status <> ?", owner.ID, terminalJobStatuses, "pending").
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errTransferUploadsActive
+	}
+	if err := tx.Model(&models.ChunkedUpload{}).Where("user_id = ?", owner.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errTransferUploadsActive
+	}
+
+	pieceIDs := tx.Model(&models.Piece{}).Unscoped().Select("id").Where("proof_set_id = ?", proofSet.ID)
+	if err := tx.Model(&models.RootTask{}).
+		Where("piece_id IN (?) AND status IN ?", pieceIDs, []string{rootTaskAdding, rootTaskConfirming}).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errTransferRootsPending
+	}
+
+	if err := tx.Model(&models.Piece{}).
+		Where("proof_set_id = ? AND pending_removal = ?", proofSet.ID, true).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errTransferRemovalsPending
+	}
+	return nil
+}
+
+// transferProofSet moves proofSet and every piece in it from owner to
+// target, recording a transferred event for each piece. What the old owner
+// set up around the pieces is dropped rather than handed over: their tags
+// and collections, and the share links, download links and grants they
+// made. It returns the number of pieces moved.
+func transferProofSet(tx *gorm.DB, proofSet *models.ProofSet, owner, target models.User, actorID uint) (int64, error) {
+	if err := checkProofSetTransferable(tx, *proofSet, owner); err != nil {
+		return 0, err
+	}
+	var existing int64
+	if err := tx.Model(&models.ProofSet{}).Where("user_id = ?", target.ID).Count(&existing).Error; err != nil {
+		return 0, err
+	}
+	if existing > 0 {
+		return 0, errTransferTargetHasProofSet
+	}
+
+	var pieceIDs []uint
+	if err := tx.Model(&models.Piece{}).Unscoped().Where("proof_set_id = ?", proofSet.ID).Pluck("id", &pieceIDs).Error; err != nil {
+		return 0, err
+	}
+
+	if err := tx.Model(&models.ProofSet{}).Where("id = ?", proofSet.ID).Update("user_id", target.ID).Error; err != nil {
+		return 0, err
+	}
+	for _, model := range []interface{}{&models.ProofRecord{}, &models.ProofSetSync{}} {
+		if err := tx.Model(model).Where("proof_set_id = ?", proofSet.ID).Update("user_id", target.ID).Error; err != nil {
+			return 0, err
+		}
+	}
+	if len(pieceIDs) == 0 {
+		return 0, tx.Unscoped().First(proofSet, proofSet.ID).Error
+	}
+
+	if err := tx.Model(&models.Piece{}).Unscoped().Where("id IN ?", pieceIDs).Updates(map[string]interface{}{
+		"user_id":       target.ID,
+		"collection_id": nil,
+	}).Error; err != nil {
+		if isDuplicateKey(err) {
+			return 0, errTransferCIDTaken
+		}
+		return 0, err
+	}
+	for _, model := range []interface{}{&models.PieceTag{}, &models.ShareLink{}, &models.DownloadLink{}, &models.PieceGrant{}} {
+		if err := tx.Where("piece_id IN ?", pieceIDs).Delete(model).Error; err != nil {
+			return 0, err
+		}
+	}
+	for _, model := range []interface{}{&models.PieceEvent{}, &models.RootTask{}} {
+		if err := tx.Model(model).Where("piece_id IN ?", pieceIDs).Update("user_id", target.ID).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	events := make([]models.PieceEvent, len(pieceIDs))
+	for i, pieceID := range pieceIDs {
+		events[i] = models.PieceEvent{
+			PieceID: pieceID,
+			UserID:  target.ID,
+			ActorID: &actorID,
+			Action:  pieceEventTransferred,
+			From:    owner.WalletAddress,
+			To:      target.WalletAddress,
+			Detail:  fmt.Sprintf("proof set %d", proofSet.ID),
+		}
+	}
+	if err := tx.CreateInBatches(&events, 500).Error; err != nil {
+		return 0, err
+	}
+	return int64(len(pieceIDs)), tx.Unscoped().First(proofSet, proofSet.ID).Error
+}
+
+// interruptPendingUploadJobs ends the jobs userID left pending because
+// their proof set wasn't ready. They stopped for good; otherwise they would
+// only be marked interrupted at the next start.
+func interruptPendingUploadJobs(userID uint) {
+	var jobIDs []string
+	if err := db.Model(&models.UploadJob{}).Where("user_id = ? AND status = ?", userID, "pending").Pluck("job_id", &jobIDs).Error; err != nil {
+		log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to load pending upload jobs")
+		return
+	}
+	for _, jobID := range jobIDs {
+		progress, _, exists := getUploadJob(jobID)
+		if !exists {
+			continue
+		}
+		progress.Status = "interrupted"
+		progress.Error = "The proof set was transferred to another user"
+		progress.Message = "This upload was waiting for a proof set that now belongs to someone else. Please upload the file again."
+		updateJobStatus(jobID, progress)
+	}
+}
+
+// @Summary Transfer a proof set to another user
+// @Description Give one of the caller's proof sets, and every file in it, to the user who signs in with walletAddress, for instance when a teammate leaves. Admins may transfer any proof set. The proof set and its files move in one step, and each file's history records the transfer. The old owner's tags, collections, share links, download links and grants on the files are dropped. Nothing changes on chain: the wallet the proof set was created for keeps paying for it unless the new owner creates a proof set of their own. Refused with 409 while uploads or root additions are in progress, files are pending removal, the proof set isn't ready, or the target already has a proof set.
+// @Tags proofset
+// @Accept json
+// @Param id path int true "Proof set database ID"
+// @Param request body TransferProofSetRequest true "Target wallet"
+// @Produce json
+// @Success 200 {object} TransferProofSetResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /api/v1/proof-sets/{id}/transfer [post]
+func TransferProofSet(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var request TransferProofSetRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+	if !common.IsHexAddress(request.WalletAddress) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "walletAddress must be a wallet address",
+		})
+		return
+	}
+
+	var proofSet models.ProofSet
+	var owner, target models.User
+	var transferred int64
+	var payer string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("id = ?", c.Param("id"))
+		if !isAdmin(c) {
+			query = query.Where("user_id = ?", userID)
+		}
+		if err := query.First(&proofSet).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&owner, proofSet.UserID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("LOWER(wallet_address) = LOWER(?)", request.WalletAddress).First(&target).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errTransferTargetNotFound
+			}
+			return err
+		}
+		if target.ID == owner.ID {
+			return errTransferToOwner
+		}
+
+		var err error
+		if payer, err = proofSetPayer(tx, proofSet, owner); err != nil {
+			return err
+		}
+		transferred, err = transferProofSet(tx, &proofSet, owner, target, userID.(uint))
+		return err
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Proof set not found",
+		})
+		return
+	case errors.Is(err, errTransferTargetNotFound), errors.Is(err, errTransferToOwner):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": err.Error(),
+		})
+		return
+	case errors.Is(err, errTransferTargetHasProofSet), errors.Is(err, errTransferNotReady),
+		errors.Is(err, errTransferUploadsActive), errors.Is(err, errTransferRootsPending),
+		errors.Is(err, errTransferRemovalsPending), errors.Is(err, errTransferCIDTaken):
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+		return
+	default:
+		log.WithField("proofSetID", c.Param("id")).WithField("error", err.Error()).Error("Failed to transfer proof set")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to transfer proof set",
+		})
+		return
+	}
+
+	log.WithField("proofSetID", proofSet.ID).
+		WithField("fromUserID", owner.ID).
+		WithField("toUserID", target.ID).
+		WithField("actorID", userID).
+		WithField("pieces", transferred).
+		Info("Transferred proof set")
+	publishUserEvent(target.ID, WSTypeProofSetStatus, proofSetEventFromModel(proofSet))
+	interruptPendingUploadJobs(owner.ID)
+
+	response, err := proofSetResponse(proofSet)
+	if err != nil {
+		log.WithField("proofSetID", proofSet.ID).WithField("error", err.Error()).Error("Failed to fetch proof set pieces")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch proof set",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, TransferProofSetResponse{
+		ProofSet:          response,
+		FromWallet:        owner.WalletAddress,
+		ToWallet:          target.WalletAddress,
+		PiecesTransferred: transferred,
+		PayerWallet:       payer,
+		Billing: "Billing stays with " + payer + ": the proof set was created on chain with that wallet as payer, " +
+			"which keeps paying for it. The new owner must create a proof set of their own to be billed instead.",
+	})
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hotvault/backend/internal/models"
+)
+
+func TestCheckProofSetTransferableIgnoresJobsWaitingForProofSet(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	owner := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, owner, "42")
+
+	createJob := func(status string) models.UploadJob {
+		job := models.UploadJob{JobID: uuid.New().String(), UserID: owner.ID, Status: status}
+		if err := db.Create(&job).Error; err != nil {
+			t.Fatalf("create upload job: %v", err)
+		}
+		return job
+	}
+
+	// Left behind by an upload made while the proof set was being created.
+	createJob("pending")
+	createJob("complete")
+	if err := checkProofSetTransferable(db, proofSet, owner); err != nil {
+		t.Fatalf("checkProofSetTransferable = %v, want nil", err)
+	}
+
+	running := createJob("uploading")
+	if err := checkProofSetTransferable(db, proofSet, owner); !errors.Is(err, errTransferUploadsActive) {
+		t.Errorf("with a running upload: err = %v, want %v", err, errTransferUploadsActive)
+	}
+	db.Model(&running).Update("status", "cancelled")
+	if err := checkProofSetTransferable(db, proofSet, owner); err != nil {
+		t.Errorf("after cancelling: err = %v, want nil", err)
+	}
+}
+
+func TestTransferProofSetInterruptsJobsWaitingForProofSet(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	owner := createTestUser(t, "0x"+strings.Repeat("11", 20))
+	target := createTestUser(t, "0x"+strings.Repeat("22", 20))
+	proofSet := createTestProofSet(t, owner, "42")
+	// Left behind by an upload made while the proof set was being created.
+	jobID := uuid.New().String()
+	createUploadJob(jobID, owner.ID, UploadProgress{Status: "uploading", Filename: "a.txt"})
+	updateJobStatus(jobID, UploadProgress{Status: "pending", Error: errProofSetNotReady.Error(), CID: "bagaone:bagasub"})
+
+	id := strconv.FormatUint(uint64(proofSet.ID), 10)
+	c, recorder := newJSONRequest(t, http.MethodPost, "/api/v1/proof-sets/"+id+"/transfer", TransferProofSetRequest{WalletAddress: target.WalletAddress}, owner)
+	c.Params = gin.Params{{Key: "id", Value: id}}
+	TransferProofSet(c)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+
+	progress, _, _ := getUploadJob(jobID)
+	var job models.UploadJob
+	db.Where("job_id = ?", jobID).First(&job)
+	if progress.Status != "interrupted" || job.Status != "interrupted" || job.CID != "bagaone:bagasub" {
+		t.Errorf("job = %q, stored %q with CID %q, want interrupted with its CID kept", progress.Status, job.Status, job.CID)
+	}
+
+	c, recorder = newTestContext(http.MethodGet, "/api/v1/upload/jobs", nil, owner)
+	ListUploadJobs(c)
+	var active UploadJobListResponse
+	json.Unmarshal(recorder.Body.Bytes(), &active)
+	if active.Total != 0 {
+		t.Errorf("active jobs = %+v, want none", active.Jobs)
+	}
+}
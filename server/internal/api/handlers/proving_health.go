@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/hotvault/backend/internal/models"
+)
+
+// Proving health of a proof set. unknown means it couldn't be judged, such
+// as while its service or ETH_RPC_URL can't be reached, and is never
+// alerted on.
+const (
+	provingHealthy = "healthy"
+	provingMissed  = "missed"
+	provingUnknown = "unknown"
+)
+
+// ProvingAlertData is the data of proofset.proving_missed and
+// proofset.proving_recovered events.
+type ProvingAlertData struct {
+	ID                 uint   `json:"id"`
+	ProofSetID         string `json:"proofSetId"`
+	ProvingHealth      string `json:"provingHealth"`
+	NextChallengeEpoch *int64 `json:"nextChallengeEpoch,omitempty"`
+	LastProvenEpoch    *int64 `json:"lastProvenEpoch,omitempty"`
+	ChainEpoch         *int64 `json:"chainEpoch,omitempty"`
+}
+
+// provingHealthFlag returns whether health is healthy, or nil when it's
+// unknown.
+func provingHealthFlag(health string) *bool {
+	var healthy bool
+	switch health {
+	case provingHealthy:
+		healthy = true
+	case provingMissed:
+		healthy = false
+	default:
+		return nil
+	}
+	return &healthy
+}
+
+// fetchChainEpoch returns the chain's current epoch, or nil when
+// ETH_RPC_URL isn't set.
+func fetchChainEpoch(ctx context.Context) (*int64, error) {
+	if cfg.Ethereum.RPCURL == "" {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, proofSetStatusTimeout)
+	defer cancel()
+	client, err := ethclient.DialContext(ctx, cfg.Ethereum.RPCURL)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	number, err := client.BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	epoch := int64(number)
+	return &epoch, nil
+}
+
+// provingVerdict judges a record taken from a reachable service. It is
+// missed when the service shows a challenge came due without a proof, or
+// when the chain is more than window plus grace epochs past the next
+// challenge and the last proof is from before it. Without the chain's
+// epoch an overdue challenge can't be told from one that isn't due yet, so
+// the verdict is unknown; a proof set without roots has nothing to prove.
+func provingVerdict(record models.ProofRecord, window, grace int64) string {
+	if record.Missed {
+		return provingMissed
+	}
+	if record.NextChallengeEpoch == nil {
+		if record.RootCount == 0 {
+			return provingHealthy
+		}
+		return provingUnknown
+	}
+	if record.ChainEpoch == nil {
+		return provingUnknown
+	}
+	due := *record.NextChallengeEpoch
+	proven := record.LastProvenEpoch != nil && *record.LastProvenEpoch >= due
+	if !proven && *record.ChainEpoch > due+window+grace {
+		return provingMissed
+	}
+	return provingHealthy
+}
+
+// nextProvingHealth returns the health a proof set whose health is current
+// has after record. Only a healthy verdict clears a miss: not being able to
+// tell doesn't mean the proof set has started proving again.
+func nextProvingHealth(current string, record models.ProofRecord, window, grace int64) string {
+	health := provingUnknown
+	if record.Reachable {
+		health = provingVerdict(record, window, grace)
+	}
+	if health == provingUnknown && current == provingMissed {
+		return provingMissed
+	}
+	return health
+}
+
+// setProvingHealth saves health as proofSet's proving health when it
+// changed, telling the user's websocket clients. Starting to miss proofs
+// sends a proofset.proving_missed event to the user's webhooks, and
+// proving again after that a proofset.proving_recovered one.
+func setProvingHealth(proofSet models.ProofSet, health string, record models.ProofRecord) {
+	previous := proofSet.ProvingHealth
+	if previous == health {
+		return
+	}
+	now := time.Now().UTC()
+	result := db.Model(&models.ProofSet{}).
+		Where("id = ? AND proving_health = ?", proofSet.ID, previous).
+		Updates(map[string]interface{}{
+			"proving_health":    health,
+			"proving_health_at": now,
+		})
+	if result.Error != nil {
+		log.WithField("proofSetID", proofSet.ID).WithField("error", result.Error.Error()).Error("Failed to save proving health")
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+	proofSet.ProvingHealth = health
+	proofSet.ProvingHealthAt = &now
+
+	entry := log.WithField("proofSetID", proofSet.ID).
+		WithField("from", previous).
+		WithField("to", health)
+	if health == provingMissed {
+		entry.Warning("Proof set stopped proving")
+	} else {
+		entry.Info("Proof set proving health changed")
+	}
+	publishUserEvent(proofSet.UserID, WSTypeProofSetStatus, proofSetEventFromModel(proofSet))
+
+	var event string
+	switch {
+	case health == provingMissed:
+		event = webhookEventProofSetProvingMissed
+	case previous == provingMissed && health == provingHealthy:
+		event = webhookEventProofSetProvingRecovered
+	default:
+		return
+	}
+	publishWebhookEvent(proofSet.UserID, event, ProvingAlertData{
+		ID:                 proofSet.ID,
+		ProofSetID:         proofSet.ProofSetID,
+		ProvingHealth:      health,
+		NextChallengeEpoch: record.NextChallengeEpoch,
+		LastProvenEpoch:    record.LastProvenEpoch,
+		ChainEpoch:         record.ChainEpoch,
+	})
+}
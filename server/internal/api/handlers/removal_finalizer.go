@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// removalFinalizer periodically deletes pieces whose removal grace period
+// has ended, once their service confirms the root is gone. A piece whose
+// root is still there has its removal sent again and its grace period
+// restarted. Pieces whose proof set can't be checked are left for the next
+// run. Deleting a piece stops it counting towards its owner's quota.
+type removalFinalizer struct {
+	interval time.Duration
+	batch    int
+	dryRun   bool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	mu    sync.Mutex
+	stats RemovalFinalizerStats
+}
+
+// RemovalFinalizerStats counts what the removal finalizer did since the
+// server started
+// @Description Pieces the removal finalizer handled since the server started. checked counts pieces past their grace period that were looked at; deleted those whose root was confirmed gone; rootsPresent those whose root was still on the service and whose removal was sent again; unverified those whose proof set couldn't be checked, left for the next run; failed those that couldn't be updated. In a dry run nothing is changed and the counts are of what would have been done.
+type RemovalFinalizerStats struct {
+	DryRun       bool       `json:"dryRun"`
+	Runs         int64      `json:"runs"`
+	Checked      int64      `json:"checked"`
+	Deleted      int64      `json:"deleted"`
+	RootsPresent int64      `json:"rootsPresent"`
+	Unverified   int64      `json:"unverified"`
+	Failed       int64      `json:"failed"`
+	LastRunAt    *time.Time `json:"lastRunAt,omitempty"`
+}
+
+var removals *removalFinalizer
+
+func startRemovalFinalizer(interval time.Duration, batch int, dryRun bool) *removalFinalizer {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &removalFinalizer{
+		interval: interval,
+		batch:    batch,
+		dryRun:   dryRun,
+		ctx:      ctx,
+		cancel:   cancel,
+		stopped:  make(chan struct{}),
+		stats:    RemovalFinalizerStats{DryRun: dryRun},
+	}
+
+	if interval <= 0 {
+		close(f.stopped)
+		log.Info("Removal finalizer disabled")
+		return f
+	}
+	go f.run()
+
+	log.WithField("interval", interval.String()).
+		WithField("batch", batch).
+		WithField("dryRun", dryRun).
+		Info("Removal finalizer started")
+	return f
+}
+
+// stop cancels the running pass, which ends after the piece it is on, and
+// waits for the finalizer to exit.
+func (f *removalFinalizer) stop() {
+	f.stopOnce.Do(f.cancel)
+	<-f.stopped
+}
+
+func (f *removalFinalizer) run() {
+	defer close(f.stopped)
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-ticker.C:
+			f.finalizeDue(time.Now())
+		}
+	}
+}
+
+// snapshot returns the counts so far.
+func (f *removalFinalizer) snapshot() RemovalFinalizerStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stats
+}
+
+// removalOutcome is what happened to one piece in a pass.
+type removalOutcome int
+
+const (
+	removalDeleted removalOutcome = iota
+	removalRootPresent
+	removalUnverified
+	removalFailed
+)
+
+// finalizeDue handles every piece whose removal date is before now, a
+// batch at a time, stopping between pieces when the finalizer is stopped.
+func (f *removalFinalizer) finalizeDue(now time.Time) {
+	var run RemovalFinalizerStats
+	var lastID uint
+	pdptoolPath := ""
+
+	for f.ctx.Err() == nil {
+		var due []models.Piece
+		if err := db.Where("pending_removal = ? AND removal_date <= ? AND id > ?", true, now, lastID).
+			Order("id ASC").
+			Limit(f.batch).
+			Find(&due).Error; err != nil {
+			log.WithField("error", err.Error()).Error("Failed to load pieces due for removal")
+			break
+		}
+		if len(due) == 0 {
+			break
+		}
+		lastID = due[len(due)-1].ID
+
+		proofSets := make(map[uint]*proofSetRoots)
+		for _, piece := range due {
+			if f.ctx.Err() != nil {
+				break
+			}
+			run.Checked++
+			switch f.finalize(piece, proofSets, &pdptoolPath) {
+			case removalDeleted:
+				run.Deleted++
+			case removalRootPresent:
+				run.RootsPresent++
+			case removalUnverified:
+				run.Unverified++
+			case removalFailed:
+				run.Failed++
+			}
+		}
+		if len(due) < f.batch {
+			break
+		}
+	}
+
+	f.mu.Lock()
+	f.stats.Runs++
+	f.stats.Checked += run.Checked
+	f.stats.Deleted += run.Deleted
+	f.stats.RootsPresent += run.RootsPresent
+	f.stats.Unverified += run.Unverified
+	f.stats.Failed += run.Failed
+	finishedAt := time.Now().UTC()
+	f.stats.LastRunAt = &finishedAt
+	f.mu.Unlock()
+
+	if run.Checked > 0 {
+		log.WithField("checked", run.Checked).
+			WithField("deleted", run.Deleted).
+			WithField("rootsPresent", run.RootsPresent).
+			WithField("unverified", run.Unverified).
+			WithField("failed", run.Failed).
+			WithField("dryRun", f.dryRun).
+			Info("Finalized pending removals")
+	}
+}
+
+// proofSetRoots is a proof set and its roots as get-proof-set listed them,
+// looked up once per batch. gone is set when the proof set has been
+// decommissioned, so none of its roots remain; err when the service
+// couldn't be asked.
+type proofSetRoots struct {
+	proofSet models.ProofSet
+	status   proofSetStatus
+	gone     bool
+	err      error
+}
+
+// lookupProofSetRoots returns the roots of the proof set with id, from
+// proofSets when it was already looked up.
+func (f *removalFinalizer) lookupProofSetRoots(id uint, proofSets map[uint]*proofSetRoots) *proofSetRoots {
+	if roots, ok := proofSets[id]; ok {
+		return roots
+	}
+	roots := &proofSetRoots{}
+	proofSets[id] = roots
+	if err := db.Unscoped().First(&roots.proofSet, id).Error; err != nil {
+		roots.err = err
+		return roots
+	}
+	switch {
+	case roots.proofSet.Status == proofSetDecommissioned:
+		roots.gone = true
+	case roots.proofSet.ProofSetID == "":
+		roots.err = fmt.Errorf("proof set %d has no service proof set ID", id)
+	default:
+		roots.status, _, roots.err = fetchProofSetStatus(f.ctx, roots.proofSet.ServiceURL, roots.proofSet.ServiceName, roots.proofSet.ProofSetID)
+	}
+	return roots
+}
+
+// finalize deletes piece when its root is confirmed gone, or sends its
+// removal again when the root is still there.
+func (f *removalFinalizer) finalize(piece models.Piece, proofSets map[uint]*proofSetRoots, pdptoolPath *string) removalOutcome {
+	entry := log.WithField("pieceID", piece.ID).WithField("dryRun", f.dryRun)
+
+	var roots *proofSetRoots
+	present := false
+	if piece.ProofSetID != nil && piece.RootID != nil && *piece.RootID != "" {
+		roots = f.lookupProofSetRoots(*piece.ProofSetID, proofSets)
+		if roots.err != nil {
+			entry.WithField("proofSetDbId", *piece.ProofSetID).
+				WithField("error", roots.err.Error()).
+				Warning("Could not confirm removed root is gone, leaving piece for the next run")
+			return removalUnverified
+		}
+		if !roots.gone {
+			_, present = roots.status.root(*piece.RootID, "")
+		}
+	}
+
+	if !present {
+		if f.dryRun {
+			entry.Info("Would delete piece whose removed root is gone")
+			return removalDeleted
+		}
+		if err := deleteRemovedPiece(piece); err != nil {
+			entry.WithField("error", err.Error()).Error("Failed to delete piece after its removal grace period")
+			return removalFailed
+		}
+		return removalDeleted
+	}
+
+	entry = entry.WithField("rootID", *piece.RootID)
+	if f.dryRun {
+		entry.Warning("Root of piece past its removal grace period is still present, would send its removal again")
+		return removalRootPresent
+	}
+	entry.Warning("Root of piece past its removal grace period is still present, sending its removal again")
+	if *pdptoolPath == "" {
+		path, err := preparePdptool(f.ctx)
+		if err != nil {
+			entry.WithField("error", err.Error()).Error("Failed to prepare pdptool to retry root removal")
+		}
+		*pdptoolPath = path
+	}
+	detail := "root still present after the grace period"
+	if *pdptoolPath != "" {
+		if _, removeErr := runRemoveRoots(*pdptoolPath, piece.ServiceURL, piece.ServiceName, roots.proofSet.ProofSetID, *piece.RootID); removeErr != nil {
+			detail += ", removal failed: " + removeErr.stderr
+		}
+	} else {
+		detail += ", pdptool unavailable"
+	}
+	if err := retryPieceRemoval(piece, detail); err != nil {
+		entry.WithField("error", err.Error()).Error("Failed to flag piece for removal retry")
+		return removalFailed
+	}
+	return removalRootPresent
+}
+
+// deleteRemovedPiece deletes a piece whose root is gone and records it.
+func deleteRemovedPiece(piece models.Piece) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&piece).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PieceEvent{
+			PieceID: piece.ID,
+			UserID:  piece.UserID,
+			Action:  pieceEventDeleted,
+			Detail:  "removal grace period ended",
+		}).Error
+	})
+}
+
+// retryPieceRemoval restarts the grace period of a piece whose root was
+// still present after it, counting the retry and recording why.
+func retryPieceRemoval(piece models.Piece, detail string) error {
+	removalDate := time.Now().Add(cfg.Upload.RemovalGracePeriod)
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&piece).Updates(map[string]interface{}{
+			"removal_date":    removalDate,
+			"removal_retries": gorm.Expr("removal_retries + 1"),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PieceEvent{
+			PieceID: piece.ID,
+			UserID:  piece.UserID,
+			Action:  pieceEventRemovalRetried,
+			From:    stringValue(piece.RootID),
+			To:      removalDate.UTC().Format(time.RFC3339),
+			Detail:  detail,
+		}).Error
+	})
+}
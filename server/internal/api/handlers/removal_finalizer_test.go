@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+func removeRootForTest(t *testing.T, user models.User, piece models.Piece) {
+	t.Helper()
+	body := strings.NewReader(fmt.Sprintf(`{"pieceId":%d}`, piece.ID))
+	c, recorder := newTestContext(http.MethodPost, "/api/v1/roots/remove", body, user)
+	c.Request.Header.Set("Content-Type", "application/json")
+	RemoveRoot(c)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("remove root: status = %d: %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestRemovedRootIsFinalizedAfterGracePeriod(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, user, "42")
+	piece := createTestRootedPiece(t, user, proofSet, "bagaone:bagasub", "7")
+	// The service no longer lists the root once it is removed.
+	fakePdptool(t, `case "$1" in get-proof-set) echo "Proof Set ID: 42";; esac`)
+
+	removeRootForTest(t, user, piece)
+
+	f := &removalFinalizer{batch: 10, ctx: context.Background()}
+	f.finalizeDue(time.Now())
+	var stored models.Piece
+	if err := db.First(&stored, piece.ID).Error; err != nil {
+		t.Fatalf("piece was deleted before its grace period ended: %v", err)
+	}
+
+	f.finalizeDue(time.Now().Add(cfg.Upload.RemovalGracePeriod + time.Minute))
+	if err := db.Unscoped().First(&stored, piece.ID).Error; err != nil || !stored.DeletedAt.Valid {
+		t.Fatalf("piece deletedAt = %v, %v; want it deleted", stored.DeletedAt, err)
+	}
+	if stats := f.snapshot(); stats.Deleted != 1 || stats.Checked != 1 {
+		t.Errorf("stats = %+v, want one piece checked and deleted", stats)
+	}
+}
+
+func TestRemovedRootStillPresentIsSentAgain(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, user, "42")
+	piece := createTestRootedPiece(t, user, proofSet, "bagaone:bagasub", "7")
+	calls := fakePdptool(t, `case "$1" in get-proof-set) printf 'Proof Set ID: 42\nRoot ID: 7\nRoot CID: bagaone\n';; esac`)
+
+	removeRootForTest(t, user, piece)
+	f := &removalFinalizer{batch: 10, ctx: context.Background()}
+	f.finalizeDue(time.Now().Add(cfg.Upload.RemovalGracePeriod + time.Minute))
+
+	var stored models.Piece
+	if err := db.First(&stored, piece.ID).Error; err != nil {
+		t.Fatalf("piece whose root is still present was deleted: %v", err)
+	}
+	if stored.RemovalRetries != 1 || stored.RemovalDate == nil || !stored.RemovalDate.After(time.Now()) {
+		t.Errorf("removalRetries = %d, removalDate = %v; want the grace period restarted", stored.RemovalRetries, stored.RemovalDate)
+	}
+	if removed := pdptoolCalls(t, calls, "remove-roots"); len(removed) != 2 {
+		t.Errorf("remove-roots ran %d times, want twice", len(removed))
+	}
+}
@@ -2,9 +2,7 @@ package handlers
 
 import (
 	"bytes"
-	"fmt"
 	"net/http"
-	"os"
 	"os/exec"
 	"strconv"
 
@@ -35,7 +33,7 @@ type Root struct {
 }
 
 // @Summary Remove roots using pdptool
-// @Description Remove a specific root from the PDP service
+// @Description Remove a specific root from the PDP service. A pinned piece is refused with 409 until it is unpinned. As when the piece is deleted, it is then listed as pending removal until the grace period ends, when it is deleted for good; removalDate says when.
 // @Tags roots
 // @Accept json
 // @Produce json
@@ -90,6 +88,37 @@ func RemoveRoot(c *gin.Context) {
 		return
 	}
 
+	if piece.Pinned {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": errPiecePinned.Error(),
+		})
+		return
+	}
+
+	if piece.PendingRemoval {
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "Root already removed; the piece is pending removal until the grace period ends",
+			"removalDate": piece.RemovalDate,
+		})
+		return
+	}
+
+	switch piece.RootStatus {
+	case rootStatusConfirmed:
+	case rootStatusPending:
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "The piece is still being added to the proof set. Please try again once its root is confirmed.",
+		})
+		return
+	default:
+		// Only a root ID read back from the service is safe to remove; any
+		// other could be another file's root.
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "The piece's root ID has not been confirmed by the storage provider, so its root can't be removed",
+		})
+		return
+	}
+
 	if piece.RootID == nil || *piece.RootID == "" {
 		log.WithField("pieceID", piece.ID).Error("Piece is missing the stored Root ID")
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -122,22 +151,23 @@ func RemoveRoot(c *gin.Context) {
 		return
 	}
 
-	serviceURL := piece.ServiceURL
-	serviceName := piece.ServiceName
-	serviceProofSetIDStr := proofSet.ProofSetID
-	storedIntegerRootIDStr := *piece.RootID
-
 	if request.ServiceURL != "" {
-		serviceURL = request.ServiceURL
+		piece.ServiceURL = request.ServiceURL
 		log.WithField("pieceID", piece.ID).Info("Overriding Service URL from request")
 	}
 	if request.ServiceName != "" {
-		serviceName = request.ServiceName
+		piece.ServiceName = request.ServiceName
 		log.WithField("pieceID", piece.ID).Info("Overriding Service Name from request")
 	}
+	if piece.ServiceURL == "" || piece.ServiceName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Service URL and Service Name are required but missing from piece/proofset data",
+		})
+		return
+	}
 
-	if _, err := strconv.Atoi(storedIntegerRootIDStr); err != nil {
-		log.WithField("pieceID", piece.ID).WithField("storedRootID", storedIntegerRootIDStr).Error("Stored Root ID in piece record is not a valid integer string")
+	if _, err := strconv.Atoi(*piece.RootID); err != nil {
+		log.WithField("pieceID", piece.ID).WithField("storedRootID", *piece.RootID).Error("Stored Root ID in piece record is not a valid integer string")
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Internal error: Invalid Root ID format stored for piece",
 		})
@@ -145,52 +175,49 @@ func RemoveRoot(c *gin.Context) {
 	}
 
 	log.WithField("pieceID", piece.ID).
-		WithField("serviceProofSetID", serviceProofSetIDStr).
-		WithField("integerRootID", storedIntegerRootIDStr).
+		WithField("serviceProofSetID", proofSet.ProofSetID).
+		WithField("integerRootID", *piece.RootID).
 		Info("Proceeding with root removal using stored data")
 
-	pdptoolPath := cfg.PdptoolPath
-	if pdptoolPath == "" {
-		log.Error("PDPTool path not configured in environment/config")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Server configuration error: PDPTool path missing",
+	// The piece is removed the way DeletePiece removes it: pending removal
+	// until the grace period ends, then deleted by the removal finalizer.
+	var response DeletePieceResponse
+	status := removePieceRoot(c.Request.Context(), piece, &response)
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"error":  response.Error,
+			"stages": response.Stages,
 		})
 		return
 	}
 
-	if _, err := os.Stat(pdptoolPath); os.IsNotExist(err) {
-		log.WithField("path", pdptoolPath).Error("pdptool not found at configured path")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "pdptool executable not found at configured path",
-			"path":  pdptoolPath,
-		})
-		return
-	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Root removed successfully; the piece is pending removal until the grace period ends",
+		"removalDate": response.RemovalDate,
+		"stages":      response.Stages,
+	})
+}
 
-	// Change working directory to pdptool directory
-	pdptoolDir := getPdptoolParentDir(pdptoolPath)
-	if err := os.Chdir(pdptoolDir); err != nil {
-		log.Error(fmt.Sprintf("Failed to change working directory to pdptool directory: %v", err))
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to set working directory",
-		})
-		return
-	}
-	log.WithField("pdptoolDir", pdptoolDir).Info("Changed working directory to pdptool directory")
+// rootRemovalError is a failed remove-roots command.
+type rootRemovalError struct {
+	command string
+	stderr  string
+	err     error
+}
 
-	if serviceURL == "" || serviceName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Service URL and Service Name are required but missing from piece/proofset data",
-		})
-		return
-	}
+func (e *rootRemovalError) Error() string {
+	return "remove-roots failed: " + e.stderr
+}
 
+// runRemoveRoots removes rootID from a proof set on the service and returns
+// pdptool's output. It must run in pdptool's directory.
+func runRemoveRoots(pdptoolPath, serviceURL, serviceName, proofSetID, rootID string) (string, *rootRemovalError) {
 	removeArgs := []string{
 		"remove-roots",
 		"--service-url", serviceURL,
 		"--service-name", serviceName,
-		"--proof-set-id", serviceProofSetIDStr,
-		"--root-id", storedIntegerRootIDStr,
+		"--proof-set-id", proofSetID,
+		"--root-id", rootID,
 	}
 	removeCmd := exec.Command(pdptoolPath, removeArgs...)
 
@@ -212,31 +239,9 @@ func RemoveRoot(c *gin.Context) {
 			WithField("stderr", errMsg).
 			WithField("command", cmdStr).
 			Error("Failed to execute pdptool remove-roots command")
-
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Failed to remove root: " + errMsg,
-			"details": err.Error(),
-			"command": cmdStr,
-		})
-		return
+		return "", &rootRemovalError{command: cmdStr, stderr: errMsg, err: err}
 	}
 
 	log.WithField("output", stdout.String()).Info("pdptool remove-roots executed successfully")
-
-	if err := db.Delete(&piece).Error; err != nil {
-		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to delete piece from database after successful root removal")
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Root removal command succeeded, but failed to delete piece record from DB",
-			"output":  stdout.String(),
-			"dbError": err.Error(),
-		})
-		return
-	}
-
-	log.WithField("pieceID", piece.ID).Info("Piece successfully deleted from database")
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Root removed successfully and piece deleted",
-		"output":  stdout.String(),
-	})
+	return stdout.String(), nil
 }
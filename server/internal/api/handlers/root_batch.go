@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+)
+
+// RemoveRootsBatchRequest lists the pieces whose roots to remove
+type RemoveRootsBatchRequest struct {
+	PieceIDs []uint `json:"pieceIds" binding:"required"`
+}
+
+// @Summary Remove the roots of several pieces
+// @Description Remove the roots of several of the caller's files from their proof sets and mark the files pending removal. Every file is checked first: all must belong to the caller (404 otherwise), none may be pinned, and each must have a root ID confirmed by its storage provider and not already be pending removal (409 otherwise); if any check fails nothing is removed. Files are then grouped by proof set and each root removed, and the files whose root was removed are marked pending removal together once their proof set is done. More roots than the configured limit run as a background job and 202 is returned; poll its statusUrl for results.
+// @Tags roots
+// @Accept json
+// @Param request body RemoveRootsBatchRequest true "IDs of the pieces to remove"
+// @Produce json
+// @Success 200 {object} BulkPieceResponse
+// @Success 202 {object} BulkPieceResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Router /api/v1/roots/remove/batch [post]
+func RemoveRootsBatch(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var request RemoveRootsBatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+
+	ids := make([]uint, 0, len(request.PieceIDs))
+	seen := make(map[uint]bool, len(request.PieceIDs))
+	for _, id := range request.PieceIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "pieceIds must not be empty",
+		})
+		return
+	}
+	if len(ids) > cfg.Upload.BulkMaxItems {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":    fmt.Sprintf("A batch may remove at most %d roots", cfg.Upload.BulkMaxItems),
+			"maxItems": cfg.Upload.BulkMaxItems,
+		})
+		return
+	}
+
+	var pieces []models.Piece
+	if err := db.Where("id IN ? AND user_id = ?", ids, userID).Find(&pieces).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to fetch pieces")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch pieces",
+		})
+		return
+	}
+	byID := make(map[uint]models.Piece, len(pieces))
+	for _, piece := range pieces {
+		byID[piece.ID] = piece
+	}
+	missing, pinned, unremovable := []uint{}, []uint{}, []uint{}
+	for _, id := range ids {
+		piece, ok := byID[id]
+		switch {
+		case !ok:
+			missing = append(missing, id)
+		case piece.Pinned:
+			pinned = append(pinned, id)
+		case !hasRemovableRoot(piece) || piece.ProofSetID == nil:
+			unremovable = append(unremovable, id)
+		}
+	}
+	switch {
+	case len(missing) > 0:
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":      "Some pieces were not found",
+			"missingIds": missing,
+		})
+		return
+	case len(pinned) > 0:
+		c.JSON(http.StatusConflict, gin.H{
+			"error":     "Some files are pinned. Unpin them before removing them.",
+			"pinnedIds": pinned,
+		})
+		return
+	case len(unremovable) > 0:
+		c.JSON(http.StatusConflict, gin.H{
+			"error":          "Some files have no root ID confirmed by the storage provider, or are already pending removal",
+			"unremovableIds": unremovable,
+		})
+		return
+	}
+	// Remove in the order the IDs were given.
+	for i, id := range ids {
+		pieces[i] = byID[id]
+	}
+
+	if len(pieces) > cfg.Upload.BulkSyncRemovals {
+		jobID := startBulkRemoval(userID.(uint), pieces)
+		c.JSON(http.StatusAccepted, BulkPieceResponse{
+			Action:    bulkActionRemove,
+			JobID:     jobID,
+			StatusURL: "/api/v1/roots/remove/batch/" + jobID,
+			Status:    "removing",
+			Results:   []BulkItemResult{},
+		})
+		return
+	}
+
+	response := BulkPieceResponse{Action: bulkActionRemove, Results: []BulkItemResult{}}
+	for _, result := range removePieces(c.Request.Context(), pieces, nil) {
+		response.add(result)
+	}
+	log.WithField("userID", userID).
+		WithField("succeeded", response.Succeeded).
+		WithField("failed", response.Failed).
+		Info("Removed a batch of roots")
+	c.JSON(http.StatusOK, response)
+}
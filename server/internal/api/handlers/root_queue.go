@@ -0,0 +1,679 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Root status of a piece, as shown by the pieces endpoints. Only a
+// confirmed piece's root ID was read from the service; an unconfirmed
+// piece's root was added but its ID couldn't be found, and the proof set
+// sync keeps looking for it.
+const (
+	rootStatusPending     = "pending"
+	rootStatusConfirmed   = "confirmed"
+	rootStatusUnconfirmed = "unconfirmed"
+	rootStatusFailed      = "failed"
+)
+
+// Status of a RootTask.
+const (
+	rootTaskAdding     = "adding"     // add-roots has not succeeded yet
+	rootTaskConfirming = "confirming" // added, waiting for the root ID
+	rootTaskDone       = "done"
+	rootTaskFailed     = "failed"
+	rootTaskCancelled  = "cancelled" // the piece was deleted before add-roots ran
+)
+
+// errRootTaskBusy is returned when cancelling a root task that has already
+// started adding the root.
+var errRootTaskBusy = errors.New("root is already being added")
+
+// rootQueueInterval is how often the root worker looks for due tasks when
+// nothing new has been queued.
+const rootQueueInterval = 5 * time.Second
+
+// rootQueue adds uploaded pieces to their proof set and resolves their root
+// IDs in the background, so uploads finish as soon as the file is stored.
+// All state lives in the root_tasks table; the queue only wakes up to work
+// through whatever is due.
+type rootQueue struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wake     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	// mu guards adding, the pieces whose add-roots is running, so a task
+	// can't be cancelled out from under it.
+	mu     sync.Mutex
+	adding []uint
+}
+
+var roots *rootQueue
+
+func startRootQueue() *rootQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &rootQueue{
+		ctx:     ctx,
+		cancel:  cancel,
+		wake:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+	}
+
+	go q.run()
+
+	var pending int64
+	db.Model(&models.RootTask{}).Where("status IN ?", []string{rootTaskAdding, rootTaskConfirming}).Count(&pending)
+	log.WithField("pendingTasks", pending).Info("Root worker started")
+	return q
+}
+
+// notify wakes the worker so a newly queued task doesn't wait for the next
+// tick.
+func (q *rootQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop cancels any running pdptool command and waits for the worker to exit.
+// Unfinished tasks stay in the database and are picked up on the next start.
+func (q *rootQueue) stop() {
+	q.stopOnce.Do(q.cancel)
+	<-q.stopped
+}
+
+func (q *rootQueue) run() {
+	defer close(q.stopped)
+
+	ticker := time.NewTicker(rootQueueInterval)
+	defer ticker.Stop()
+
+	for {
+		q.processDue()
+
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// processDue works through every task whose next attempt is due. The roots
+// a job uploaded together are added with a single add-roots call, and tasks
+// are confirmed per proof set so a single get-proof-set call covers all of
+// them.
+func (q *rootQueue) processDue() {
+	var tasks []models.RootTask
+	if err := db.Where("status IN ? AND next_attempt_at <= ?", []string{rootTaskAdding, rootTaskConfirming}, time.Now()).
+		Order("id ASC").
+		Find(&tasks).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to load root tasks")
+		return
+	}
+
+	// Proof set IDs are only unique within a service.
+	adding := make(map[string][]*models.RootTask)
+	var addOrder []string
+	for i := range tasks {
+		task := &tasks[i]
+		if task.Status != rootTaskAdding {
+			continue
+		}
+		key := task.ServiceURL + "\x00" + task.ProofSetID + "\x00" + task.JobID
+		if task.JobID == "" {
+			key += "\x00" + strconv.FormatUint(uint64(task.ID), 10)
+		}
+		if _, ok := adding[key]; !ok {
+			addOrder = append(addOrder, key)
+		}
+		adding[key] = append(adding[key], task)
+	}
+	for _, key := range addOrder {
+		if q.ctx.Err() != nil {
+			return
+		}
+		claimed := q.claim(adding[key])
+		if len(claimed) == 0 {
+			continue
+		}
+		q.addRoots(claimed)
+		q.release()
+	}
+
+	confirming := make(map[string][]*models.RootTask)
+	var proofSetOrder []string
+	for i := range tasks {
+		task := &tasks[i]
+		if task.Status == rootTaskConfirming && !task.NextAttemptAt.After(time.Now()) {
+			key := task.ServiceURL + "\x00" + task.ProofSetID
+			if _, ok := confirming[key]; !ok {
+				proofSetOrder = append(proofSetOrder, key)
+			}
+			confirming[key] = append(confirming[key], task)
+		}
+	}
+
+	for _, key := range proofSetOrder {
+		if q.ctx.Err() != nil {
+			return
+		}
+		tasks := confirming[key]
+		q.confirmRoots(tasks[0].ProofSetID, tasks)
+	}
+}
+
+// claim marks the add-roots of tasks as running and returns those that
+// weren't cancelled since the worker loaded them.
+func (q *rootQueue) claim(tasks []*models.RootTask) []*models.RootTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var claimed []*models.RootTask
+	for _, task := range tasks {
+		var current models.RootTask
+		if err := db.Select("status").Where("id = ?", task.ID).First(&current).Error; err != nil || current.Status != rootTaskAdding {
+			continue
+		}
+		claimed = append(claimed, task)
+		q.adding = append(q.adding, task.PieceID)
+	}
+	return claimed
+}
+
+func (q *rootQueue) release() {
+	q.mu.Lock()
+	q.adding = nil
+	q.mu.Unlock()
+}
+
+// cancelPiece cancels the root task of a piece whose root hasn't been added
+// yet and, inside the same transaction, runs then so the caller can delete
+// the piece. It returns errRootTaskBusy once add-roots has started.
+func (q *rootQueue) cancelPiece(pieceID uint, then func(tx *gorm.DB) error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if slices.Contains(q.adding, pieceID) {
+		return errRootTaskBusy
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.RootTask{}).
+			Where("piece_id = ? AND status = ?", pieceID, rootTaskAdding).
+			Update("status", rootTaskCancelled)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRootTaskBusy
+		}
+		return then(tx)
+	})
+}
+
+// addRoots runs one add-roots attempt for tasks, which share a job and
+// proof set, adding all their roots in a single call.
+func (q *rootQueue) addRoots(tasks []*models.RootTask) {
+	task := tasks[0]
+	pdptoolPath := cfg.PdptoolPath
+	serviceURL, serviceName := rootTaskService(task)
+	args := []string{
+		"add-roots",
+		"--service-url", serviceURL,
+		"--service-name", serviceName,
+		"--proof-set-id", task.ProofSetID,
+	}
+	pieceIDs := make([]uint, len(tasks))
+	for i, task := range tasks {
+		args = append(args, "--root", task.CID)
+		pieceIDs[i] = task.PieceID
+		task.Attempts++
+	}
+
+	maxRetries := cfg.Retry.AddRootsMaxRetries
+	log.WithField("pieceIds", pieceIDs).
+		WithField("args", strings.Join(args, " ")).
+		WithField("attempt", task.Attempts).
+		WithField("maxRetries", maxRetries).
+		Info("Executing add-roots command")
+
+	q.reportJob(task, "Adding root to proof set...")
+
+	var addRootError bytes.Buffer
+	attemptCtx, cancel := context.WithTimeout(q.ctx, cfg.Retry.AddRootsTimeout)
+	cmd := exec.CommandContext(attemptCtx, pdptoolPath, args...)
+	cmd.Dir = filepath.Dir(pdptoolPath)
+	cmd.Stderr = &addRootError
+	err := runJobCommand(task.JobID, cmd)
+	timedOut := attemptCtx.Err() == context.DeadlineExceeded
+	cancel()
+
+	if q.ctx.Err() != nil {
+		// Shutting down; leave the attempt uncounted so it runs again on
+		// the next start.
+		return
+	}
+
+	if err == nil {
+		log.WithField("pieceIds", pieceIDs).
+			WithField("proofSetID", task.ProofSetID).
+			WithField("attempt", task.Attempts).
+			Info("add-roots command completed successfully")
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			for _, task := range tasks {
+				task.Status = rootTaskConfirming
+				task.LastError = ""
+				task.NextAttemptAt = time.Now()
+				if err := tx.Save(task).Error; err != nil {
+					return err
+				}
+				if err := tx.Create(&models.PieceEvent{
+					PieceID: task.PieceID,
+					UserID:  task.UserID,
+					Action:  pieceEventRootAdded,
+					Detail:  "proof set " + task.ProofSetID,
+				}).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			log.WithField("pieceIds", pieceIDs).WithField("error", err.Error()).Error("Failed to save root tasks")
+		}
+		q.reportJob(task, "Confirming Root ID assignment...")
+		return
+	}
+
+	stderrStr := strings.TrimSpace(addRootError.String())
+	lastError := stderrStr
+	if timedOut {
+		lastError = fmt.Sprintf("add-roots timed out after %v", cfg.Retry.AddRootsTimeout)
+	} else if lastError == "" {
+		lastError = err.Error()
+	}
+	log.WithField("pieceIds", pieceIDs).
+		WithField("error", err.Error()).
+		WithField("stderr", stderrStr).
+		WithField("attempt", task.Attempts).
+		WithField("maxRetries", maxRetries).
+		Error("pdptool add-roots command failed")
+
+	if strings.Contains(stderrStr, "Size must be a multiple of 32") {
+		for _, task := range tasks {
+			task.LastError = lastError
+			q.failTask(task, "The service rejected the piece size")
+		}
+		return
+	}
+
+	nextAttempt := time.Now().Add(retryDelay(cfg.Retry.AddRootsBackoff, cfg.Retry.AddRootsMaxBackoff, task.Attempts))
+	var retrying *models.RootTask
+	for _, task := range tasks {
+		task.LastError = lastError
+		if task.Attempts >= maxRetries {
+			q.failTask(task, "Failed to add root to proof set after multiple attempts")
+			continue
+		}
+		task.NextAttemptAt = nextAttempt
+		q.saveTask(task)
+		retrying = task
+	}
+	if retrying != nil {
+		q.reportJob(retrying, fmt.Sprintf("Adding root failed, retrying %d/%d...", retrying.Attempts+1, maxRetries))
+	}
+}
+
+// rootTaskService returns the service task's proof set is on. Tasks queued
+// before storage providers existed don't record one and use the configured
+// service.
+func rootTaskService(task *models.RootTask) (serviceURL, serviceName string) {
+	if task.ServiceURL == "" || task.ServiceName == "" {
+		return cfg.ServiceURL, cfg.ServiceName
+	}
+	return task.ServiceURL, task.ServiceName
+}
+
+// retryDelay doubles base for each failed attempt, up to max, with some
+// jitter.
+func retryDelay(base, max time.Duration, attempts int) time.Duration {
+	backoff := base
+	for i := 1; i < attempts && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	if backoff >= 2 {
+		backoff += time.Duration(rand.Int63n(int64(backoff / 2)))
+	}
+	return backoff
+}
+
+// confirmRoots runs get-proof-set once and confirms every task whose root
+// now appears in the proof set under a root ID no other piece holds. Tasks
+// whose root still can't be found after RootIDPollMaxAttempts leave their
+// piece unconfirmed, for the proof set sync to resolve.
+func (q *rootQueue) confirmRoots(proofSetID string, tasks []*models.RootTask) {
+	jobIDs := make([]string, 0, len(tasks))
+	pieceIDs := make([]uint, 0, len(tasks))
+	cids := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		task.PollAttempts++
+		jobIDs = append(jobIDs, task.JobID)
+		pieceIDs = append(pieceIDs, task.PieceID)
+		cids = append(cids, task.CID)
+	}
+	serviceURL, serviceName := rootTaskService(tasks[0])
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(q.ctx, cfg.PdptoolPath,
+		"get-proof-set",
+		"--service-url", serviceURL,
+		"--service-name", serviceName,
+		proofSetID,
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	recorder := recordJobsCommand(jobIDs, cmd)
+	err := cmd.Run()
+	recorder.finish(err)
+
+	if q.ctx.Err() != nil {
+		return
+	}
+
+	rootIDs := make([]string, len(tasks))
+	if err != nil {
+		log.WithField("proofSetID", proofSetID).
+			WithField("error", err.Error()).
+			WithField("stderr", stderr.String()).
+			Warning("pdptool get-proof-set failed while confirming root IDs")
+	} else {
+		var others []models.Piece
+		if err := db.Select("id", "c_id", "root_id").
+			Where("proof_set_id IN (?) AND id NOT IN ?", db.Model(&models.Piece{}).Select("proof_set_id").Where("id IN ?", pieceIDs), pieceIDs).
+			Find(&others).Error; err != nil {
+			log.WithField("proofSetID", proofSetID).WithField("error", err.Error()).Error("Failed to load proof set pieces while confirming root IDs")
+		} else {
+			rootIDs = resolveRootIDs(others, cids, parseProofSetStatus(stdout.String()).Roots)
+		}
+	}
+
+	for i, task := range tasks {
+		if rootIDs[i] != "" {
+			q.confirmTask(task, rootIDs[i])
+			continue
+		}
+
+		if task.PollAttempts >= cfg.Retry.RootIDPollMaxAttempts {
+			task.LastError = fmt.Sprintf("Polling for Root ID timed out after %d attempts", task.PollAttempts)
+			q.failTask(task, "Could not confirm Root ID assignment")
+			continue
+		}
+
+		task.NextAttemptAt = time.Now().Add(cfg.Retry.RootIDPollInterval)
+		q.saveTask(task)
+		if task.PollAttempts%5 == 0 {
+			q.reportJob(task, "Waiting for blockchain confirmation...")
+		}
+	}
+}
+
+func (q *rootQueue) confirmTask(task *models.RootTask, rootID string) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Piece{}).Where("id = ?", task.PieceID).Updates(map[string]interface{}{
+			"root_id":     rootID,
+			"root_status": rootStatusConfirmed,
+		}).Error; err != nil {
+			return err
+		}
+		task.Status = rootTaskDone
+		task.LastError = ""
+		if err := tx.Save(task).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PieceEvent{
+			PieceID: task.PieceID,
+			UserID:  task.UserID,
+			Action:  pieceEventRootConfirmed,
+			To:      rootID,
+		}).Error
+	})
+	if err != nil {
+		log.WithField("pieceId", task.PieceID).WithField("error", err.Error()).Error("Failed to save confirmed root ID")
+		return
+	}
+
+	log.WithField("pieceId", task.PieceID).
+		WithField("rootID", rootID).
+		WithField("baseCID", task.BaseCID).
+		Info("Root ID confirmed for piece")
+
+	if task.JobID == "" {
+		return
+	}
+	var piece models.Piece
+	db.Select("checksum").Where("id = ?", task.PieceID).First(&piece)
+	q.finishJob(task, UploadProgress{
+		Status:     "complete",
+		Progress:   100,
+		Message:    "Upload completed successfully",
+		CID:        task.CID,
+		ProofSetID: task.ProofSetID,
+		PieceID:    task.PieceID,
+		Checksum:   stringValue(piece.Checksum),
+	})
+}
+
+// failTask gives up on task. A piece whose root was added but whose root ID
+// was never found is left unconfirmed rather than failed, as the service
+// may still list it.
+func (q *rootQueue) failTask(task *models.RootTask, reason string) {
+	rootStatus := rootStatusFailed
+	if task.Status == rootTaskConfirming {
+		rootStatus = rootStatusUnconfirmed
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Piece{}).Where("id = ?", task.PieceID).
+			Update("root_status", rootStatus).Error; err != nil {
+			return err
+		}
+		task.Status = rootTaskFailed
+		if err := tx.Save(task).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PieceEvent{
+			PieceID: task.PieceID,
+			UserID:  task.UserID,
+			Action:  pieceEventRootFailed,
+			Detail:  reason,
+		}).Error
+	})
+	if err != nil {
+		log.WithField("pieceId", task.PieceID).WithField("error", err.Error()).Error("Failed to record failed root task")
+		return
+	}
+
+	log.WithField("pieceId", task.PieceID).
+		WithField("proofSetID", task.ProofSetID).
+		WithField("error", task.LastError).
+		Error(reason)
+
+	q.finishJob(task, UploadProgress{
+		Status:     "error",
+		Error:      reason,
+		Message:    task.LastError,
+		CID:        task.CID,
+		ProofSetID: task.ProofSetID,
+		PieceID:    task.PieceID,
+	})
+}
+
+func (q *rootQueue) saveTask(task *models.RootTask) {
+	if err := db.Save(task).Error; err != nil {
+		log.WithField("pieceId", task.PieceID).WithField("error", err.Error()).Error("Failed to save root task")
+	}
+}
+
+// reportJob updates the message of the upload job that queued task, which
+// stays "uploaded" until its root is confirmed.
+func (q *rootQueue) reportJob(task *models.RootTask, message string) {
+	q.finishJob(task, UploadProgress{
+		Status:     "uploaded",
+		Progress:   100,
+		Message:    message,
+		CID:        task.CID,
+		ProofSetID: task.ProofSetID,
+		PieceID:    task.PieceID,
+	})
+}
+
+func (q *rootQueue) finishJob(task *models.RootTask, progress UploadProgress) {
+	if task.JobID == "" {
+		return
+	}
+	// Load the job into the cache so updates reach its owner even after a
+	// restart.
+	job, _, exists := getUploadJob(task.JobID)
+	if !exists {
+		return
+	}
+	if len(job.Files) > 0 {
+		updateBatchRoot(task.JobID, task.PieceID, progress)
+		return
+	}
+	updateJobStatus(task.JobID, progress)
+}
+
+// enqueueRootTask creates the root task of a pending piece inside tx, or
+// resets one that failed, was cancelled or finished. A task still adding or
+// confirming the piece's root is left as it is and loaded into task, so
+// add-roots isn't submitted for it twice. Callers wake the worker with
+// roots.notify once tx has committed.
+func enqueueRootTask(tx *gorm.DB, task *models.RootTask) error {
+	var existing models.RootTask
+	if err := tx.Where("piece_id = ? AND status IN ?", task.PieceID, []string{rootTaskAdding, rootTaskConfirming}).
+		Limit(1).
+		Find(&existing).Error; err != nil {
+		return err
+	}
+	if existing.ID != 0 {
+		*task = existing
+		return nil
+	}
+
+	task.Status = rootTaskAdding
+	task.NextAttemptAt = time.Now().Add(cfg.Retry.PreAddRootDelay)
+	return tx.Where(models.RootTask{PieceID: task.PieceID}).
+		Assign(map[string]interface{}{
+			"job_id":          task.JobID,
+			"user_id":         task.UserID,
+			"proof_set_id":    task.ProofSetID,
+			"service_url":     task.ServiceURL,
+			"service_name":    task.ServiceName,
+			"c_id":            task.CID,
+			"base_c_id":       task.BaseCID,
+			"status":          task.Status,
+			"attempts":        0,
+			"poll_attempts":   0,
+			"last_error":      "",
+			"next_attempt_at": task.NextAttemptAt,
+		}).
+		FirstOrCreate(task).Error
+}
+
+// queueUploadedPiece saves the piece for a file that pdptool has stored,
+// with its root pending, and queues the work to add it to proof set. A piece
+// the user already has for the same CID is reused rather than duplicated.
+func queueUploadedPiece(jobID string, userID uint, upload localUpload, proofSet *models.ProofSet, compoundCID, baseCID string) (*models.Piece, error) {
+	piece, err := saveUploadedPiece(jobID, userID, upload, proofSet, compoundCID, baseCID)
+	if isDuplicateKey(err) {
+		// Another upload of the same file by this user saved its piece
+		// first. Retrying finds that piece and reuses it.
+		log.WithField("jobID", jobID).WithField("cid", compoundCID).Info("Piece was saved concurrently, reusing it")
+		piece, err = saveUploadedPiece(jobID, userID, upload, proofSet, compoundCID, baseCID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	recordUsage(userID, piece.ID, usageDirectionUpload, upload.Size)
+	return piece, nil
+}
+
+func saveUploadedPiece(jobID string, userID uint, upload localUpload, proofSet *models.ProofSet, compoundCID, baseCID string) (*models.Piece, error) {
+	piece := &models.Piece{
+		UserID:      userID,
+		CID:         compoundCID,
+		Filename:    upload.Filename,
+		Size:        upload.Size,
+		PaddedSize:  upload.PaddedSize,
+		Compressed:  upload.Compressed,
+		StoredSize:  upload.storedSize(),
+		ServiceName: proofSet.ServiceName,
+		ServiceURL:  proofSet.ServiceURL,
+		ProofSetID:  &proofSet.ID,
+		RootStatus:  rootStatusPending,
+		Checksum:    &upload.Checksum,
+		ContentType: upload.ContentType,
+		Metadata:    upload.Metadata,
+		RetainUntil: upload.RetainUntil,
+	}
+
+	existingPiece, isDuplicate := findDuplicatePiece(userID, compoundCID)
+	if isDuplicate {
+		log.WithField("pieceId", existingPiece.ID).
+			WithField("cid", compoundCID).
+			Info("Piece already exists outside the current proof set, re-adding it as a root")
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if isDuplicate {
+			piece = existingPiece
+			if err := reattachPieceTx(tx, jobID, piece, upload, proofSet, ""); err != nil {
+				return err
+			}
+		} else {
+			if err := assignFileVersionTx(tx, piece); err != nil {
+				return err
+			}
+			if err := tx.Create(piece).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(uploadEvents(piece, jobID, "")).Error; err != nil {
+				return err
+			}
+		}
+		return enqueueRootTask(tx, &models.RootTask{
+			PieceID:     piece.ID,
+			JobID:       jobID,
+			UserID:      userID,
+			ProofSetID:  proofSet.ProofSetID,
+			ServiceURL:  proofSet.ServiceURL,
+			ServiceName: proofSet.ServiceName,
+			CID:         compoundCID,
+			BaseCID:     baseCID,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return piece, nil
+}
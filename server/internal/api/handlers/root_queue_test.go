@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+func rootTaskOf(t *testing.T, pieceID uint) models.RootTask {
+	t.Helper()
+	var task models.RootTask
+	if err := db.Where("piece_id = ?", pieceID).First(&task).Error; err != nil {
+		t.Fatalf("load root task: %v", err)
+	}
+	return task
+}
+
+func TestEnqueueRootTaskKeepsTasksInFlight(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, user, "42")
+	piece := createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaone:bagasub", ProofSetID: &proofSet.ID, RootStatus: rootStatusPending})
+
+	newTask := func() *models.RootTask {
+		return &models.RootTask{PieceID: piece.ID, UserID: user.ID, ProofSetID: "42", CID: piece.CID, BaseCID: "bagaone"}
+	}
+	if err := enqueueRootTask(db, newTask()); err != nil {
+		t.Fatalf("enqueueRootTask: %v", err)
+	}
+	if task := rootTaskOf(t, piece.ID); task.Status != rootTaskAdding {
+		t.Fatalf("status = %q, want %q", task.Status, rootTaskAdding)
+	}
+
+	for _, status := range []string{rootTaskAdding, rootTaskConfirming} {
+		db.Model(&models.RootTask{}).Where("piece_id = ?", piece.ID).
+			Updates(map[string]interface{}{"status": status, "attempts": 2, "last_error": "timeout"})
+		task := newTask()
+		if err := enqueueRootTask(db, task); err != nil {
+			t.Fatalf("enqueueRootTask: %v", err)
+		}
+		stored := rootTaskOf(t, piece.ID)
+		if stored.Status != status || stored.Attempts != 2 {
+			t.Errorf("%s task was reset: status = %q, attempts = %d", status, stored.Status, stored.Attempts)
+		}
+		if task.ID != stored.ID || task.Status != status {
+			t.Errorf("%s task: returned %+v, want the stored task", status, task)
+		}
+	}
+
+	for _, status := range []string{rootTaskFailed, rootTaskCancelled, rootTaskDone} {
+		db.Model(&models.RootTask{}).Where("piece_id = ?", piece.ID).
+			Updates(map[string]interface{}{"status": status, "attempts": 2})
+		if err := enqueueRootTask(db, newTask()); err != nil {
+			t.Fatalf("enqueueRootTask: %v", err)
+		}
+		stored := rootTaskOf(t, piece.ID)
+		if stored.Status != rootTaskAdding || stored.Attempts != 0 {
+			t.Errorf("%s task: status = %q, attempts = %d, want it reset", status, stored.Status, stored.Attempts)
+		}
+	}
+}
+
+func TestReuploadWhileRootConfirmingKeepsTask(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, user, "42")
+	upload := localUpload{Filename: "a.txt", Size: 3, Checksum: "abc"}
+
+	piece, err := saveUploadedPiece("job-1", user.ID, upload, &proofSet, "bagaone:bagasub", "bagaone")
+	if err != nil {
+		t.Fatalf("saveUploadedPiece: %v", err)
+	}
+	db.Model(&models.RootTask{}).Where("piece_id = ?", piece.ID).Update("status", rootTaskConfirming)
+
+	// The same file uploaded again before its root is confirmed.
+	again, err := saveUploadedPiece("job-2", user.ID, upload, &proofSet, "bagaone:bagasub", "bagaone")
+	if err != nil {
+		t.Fatalf("saveUploadedPiece: %v", err)
+	}
+	if again.ID != piece.ID {
+		t.Errorf("re-upload saved piece %d, want %d", again.ID, piece.ID)
+	}
+	if task := rootTaskOf(t, piece.ID); task.Status != rootTaskConfirming || task.JobID != "job-1" {
+		t.Errorf("root task = %q of %q, want it still confirming for job-1", task.Status, task.JobID)
+	}
+}
+
+func TestAddRootsStopsAfterConfiguredRetries(t *testing.T) {
+	for _, maxRetries := range []int{1, 3} {
+		t.Run(fmt.Sprintf("%d retries", maxRetries), func(t *testing.T) {
+			useTestDB(t)
+			useTestConfig(t)
+			cfg.Retry.AddRootsMaxRetries = maxRetries
+			cfg.Retry.PreAddRootDelay = 0
+			cfg.Retry.AddRootsBackoff = 0
+			cfg.Retry.AddRootsMaxBackoff = 0
+			user := createTestUser(t, "0x1")
+			proofSet := createTestProofSet(t, user, "42")
+			calls := fakePdptool(t, `echo "service unavailable" >&2; exit 1`)
+
+			piece, err := saveUploadedPiece("", user.ID, localUpload{Filename: "a.txt", Size: 3}, &proofSet, "bagaone:bagasub", "bagaone")
+			if err != nil {
+				t.Fatalf("saveUploadedPiece: %v", err)
+			}
+			q := &rootQueue{ctx: context.Background()}
+			for i := 0; i < maxRetries+2; i++ {
+				time.Sleep(time.Millisecond)
+				q.processDue()
+			}
+
+			if called := pdptoolCalls(t, calls, "add-roots"); len(called) != maxRetries {
+				t.Errorf("add-roots ran %d times, want %d", len(called), maxRetries)
+			}
+			task := rootTaskOf(t, piece.ID)
+			if task.Status != rootTaskFailed || task.Attempts != maxRetries {
+				t.Errorf("task status = %q after %d attempts, want %q after %d", task.Status, task.Attempts, rootTaskFailed, maxRetries)
+			}
+			var stored models.Piece
+			db.First(&stored, piece.ID)
+			if stored.RootStatus != rootStatusFailed {
+				t.Errorf("root status = %q, want %q", stored.RootStatus, rootStatusFailed)
+			}
+		})
+	}
+}
+
+func TestConfirmRootsNeverFabricatesRootID(t *testing.T) {
+	tests := []struct {
+		name        string
+		getProofSet string
+		cid         string
+		wantRootID  string
+	}{
+		{"root not listed", `printf 'Root ID: 1\nRoot CID: bagaheld\n'`, "bagaone:bagasub", ""},
+		{"get-proof-set fails", `echo "service unavailable" >&2; exit 1`, "bagaone:bagasub", ""},
+		{"output without roots", `printf 'Error: proof set not found\n'`, "bagaone:bagasub", ""},
+		{"only root of the CID held by another piece", `printf 'Root ID: 1\nRoot CID: bagaheld\n'`, "bagaheld:bagasub", ""},
+		{"listed under a root of its own", `printf 'Root ID: 1\nRoot CID: bagaheld\nRoot ID: 2\nRoot CID: bagaheld\n'`, "bagaheld:bagasub", "2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestDB(t)
+			useTestConfig(t)
+			cfg.Retry.PreAddRootDelay = 0
+			cfg.Retry.RootIDPollInterval = 0
+			cfg.Retry.RootIDPollMaxAttempts = 2
+			user := createTestUser(t, "0x1")
+			proofSet := createTestProofSet(t, user, "42")
+			// Another user's file in the same proof set holds root 1.
+			createTestRootedPiece(t, createTestUser(t, "0x2"), proofSet, "bagaheld:bagaold", "1")
+			calls := fakePdptool(t, `case "$1" in get-proof-set) `+test.getProofSet+`;; esac`)
+
+			piece, err := saveUploadedPiece("", user.ID, localUpload{Filename: "a.txt", Size: 3}, &proofSet, test.cid, baseCID(test.cid))
+			if err != nil {
+				t.Fatalf("saveUploadedPiece: %v", err)
+			}
+			q := &rootQueue{ctx: context.Background()}
+			for i := 0; i < 4; i++ {
+				q.processDue()
+			}
+
+			if n := len(pdptoolCalls(t, calls, "get-proof-set")); test.wantRootID == "" && n != cfg.Retry.RootIDPollMaxAttempts {
+				t.Errorf("get-proof-set ran %d times, want %d", n, cfg.Retry.RootIDPollMaxAttempts)
+			}
+			var stored models.Piece
+			db.First(&stored, piece.ID)
+			wantStatus := rootStatusConfirmed
+			if test.wantRootID == "" {
+				wantStatus = rootStatusUnconfirmed
+			}
+			if stringValue(stored.RootID) != test.wantRootID || stored.RootStatus != wantStatus {
+				t.Errorf("piece root %q %s, want root %q %s", stringValue(stored.RootID), stored.RootStatus, test.wantRootID, wantStatus)
+			}
+		})
+	}
+}
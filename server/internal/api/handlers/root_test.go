@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+func createTestRootedPiece(t *testing.T, user models.User, proofSet models.ProofSet, cid, rootID string) models.Piece {
+	t.Helper()
+	return createTestPiece(t, models.Piece{
+		UserID:     user.ID,
+		CID:        cid,
+		ProofSetID: &proofSet.ID,
+		RootID:     &rootID,
+		RootStatus: rootStatusConfirmed,
+	})
+}
+
+func TestRemoveRootMarksPiecePendingRemoval(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, user, "42")
+	piece := createTestRootedPiece(t, user, proofSet, "bagaone:bagasub", "7")
+	calls := fakePdptool(t, "exit 0")
+
+	body := strings.NewReader(`{"pieceId":` + fmt.Sprint(piece.ID) + `}`)
+	c, recorder := newTestContext(http.MethodPost, "/api/v1/roots/remove", body, user)
+	c.Request.Header.Set("Content-Type", "application/json")
+	RemoveRoot(c)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	if removed := pdptoolCalls(t, calls, "remove-roots"); len(removed) != 1 || !strings.Contains(removed[0], "--proof-set-id 42 --root-id 7") {
+		t.Errorf("remove-roots calls = %q", removed)
+	}
+
+	var stored models.Piece
+	if err := db.First(&stored, piece.ID).Error; err != nil {
+		t.Fatalf("piece was deleted at once: %v", err)
+	}
+	if !stored.PendingRemoval || stored.RemovalDate == nil {
+		t.Errorf("piece pendingRemoval = %v, removalDate = %v; want it pending removal", stored.PendingRemoval, stored.RemovalDate)
+	}
+	var events int64
+	db.Model(&models.PieceEvent{}).Where("piece_id = ? AND action = ?", piece.ID, pieceEventRootRemoved).Count(&events)
+	if events != 1 {
+		t.Errorf("recorded %d root removed events, want 1", events)
+	}
+
+	// Asking again doesn't remove the root a second time.
+	body = strings.NewReader(`{"pieceId":` + fmt.Sprint(piece.ID) + `}`)
+	c, recorder = newTestContext(http.MethodPost, "/api/v1/roots/remove", body, user)
+	c.Request.Header.Set("Content-Type", "application/json")
+	RemoveRoot(c)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("second removal: status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	if removed := pdptoolCalls(t, calls, "remove-roots"); len(removed) != 1 {
+		t.Errorf("remove-roots ran %d times, want once", len(removed))
+	}
+}
+
+func TestRemoveRootKeepsPieceWhenServiceFails(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, user, "42")
+	piece := createTestRootedPiece(t, user, proofSet, "bagaone:bagasub", "7")
+	fakePdptool(t, "echo 'root not found' >&2; exit 1")
+
+	body := strings.NewReader(`{"pieceId":` + fmt.Sprint(piece.ID) + `}`)
+	c, recorder := newTestContext(http.MethodPost, "/api/v1/roots/remove", body, user)
+	c.Request.Header.Set("Content-Type", "application/json")
+	RemoveRoot(c)
+
+	if recorder.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusBadGateway)
+	}
+	var stored models.Piece
+	db.First(&stored, piece.ID)
+	if stored.PendingRemoval {
+		t.Errorf("piece is pending removal though its root wasn't removed")
+	}
+}
+
+func TestRemoveRootRefusesUnconfirmedRootID(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, user, "42")
+	calls := fakePdptool(t, "exit 0")
+	// A root ID saved without being read back from the service, as the
+	// fallback to root 1 used to.
+	fabricated := "1"
+	pieces := []models.Piece{
+		{UserID: user.ID, CID: "bagaone:bagasub", ProofSetID: &proofSet.ID, RootStatus: rootStatusUnconfirmed},
+		{UserID: user.ID, CID: "bagatwo:bagasub", ProofSetID: &proofSet.ID, RootStatus: rootStatusUnconfirmed, RootID: &fabricated},
+		{UserID: user.ID, CID: "bagathree:bagasub", ProofSetID: &proofSet.ID, RootStatus: rootStatusFailed, RootID: &fabricated},
+	}
+
+	for _, piece := range pieces {
+		piece = createTestPiece(t, piece)
+		body := strings.NewReader(`{"pieceId":` + fmt.Sprint(piece.ID) + `}`)
+		c, recorder := newTestContext(http.MethodPost, "/api/v1/roots/remove", body, user)
+		c.Request.Header.Set("Content-Type", "application/json")
+		RemoveRoot(c)
+		if recorder.Code != http.StatusConflict {
+			t.Errorf("%s root of %s: status = %d, want %d", piece.RootStatus, piece.CID, recorder.Code, http.StatusConflict)
+		}
+		if piece.RootStatus == rootStatusUnconfirmed {
+			if _, status := deletePiece(context.Background(), piece); status != http.StatusConflict {
+				t.Errorf("deleting the %s piece %s: status = %d, want %d", piece.RootStatus, piece.CID, status, http.StatusConflict)
+			}
+		}
+	}
+	if removed := pdptoolCalls(t, calls, "remove-roots"); len(removed) != 0 {
+		t.Errorf("remove-roots ran for a root ID that wasn't confirmed: %q", removed)
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// serviceSecretLock serialises create-service-secret. Two runs at once would
+// each write pdpservice.json, leaving a secret the service doesn't know.
+var serviceSecretLock sync.Mutex
+
+func serviceSecretPath(pdptoolPath string) string {
+	return filepath.Join(getPdptoolParentDir(pdptoolPath), "pdpservice.json")
+}
+
+// serviceSecretExists reports whether pdptool's pdpservice.json is there.
+func serviceSecretExists(pdptoolPath string) bool {
+	_, err := os.Stat(serviceSecretPath(pdptoolPath))
+	return err == nil
+}
+
+// ensureServiceSecret creates pdptool's service secret if it doesn't exist
+// yet and reports whether it had to. Concurrent callers wait for the first
+// one rather than each running create-service-secret. jobID, when set, is
+// the upload job the command is logged against.
+func ensureServiceSecret(ctx context.Context, pdptoolPath string, jobID string) (bool, error) {
+	if serviceSecretExists(pdptoolPath) {
+		return false, nil
+	}
+
+	serviceSecretLock.Lock()
+	defer serviceSecretLock.Unlock()
+
+	// Another caller may have created it while we waited for the lock.
+	if serviceSecretExists(pdptoolPath) {
+		return false, nil
+	}
+
+	log.WithField("path", serviceSecretPath(pdptoolPath)).Info("Creating PDP service secret")
+
+	var createSecretError bytes.Buffer
+	createSecretCmd := exec.CommandContext(ctx, pdptoolPath, "create-service-secret")
+	createSecretCmd.Dir = getPdptoolParentDir(pdptoolPath)
+	createSecretCmd.Stderr = &createSecretError
+	if err := runJobCommand(jobID, createSecretCmd); err != nil {
+		return false, fmt.Errorf("create-service-secret failed: %s", strings.TrimSpace(createSecretError.String()))
+	}
+
+	if err := validateServiceSecret(pdptoolPath); err != nil {
+		os.Remove(serviceSecretPath(pdptoolPath))
+		return false, err
+	}
+	return true, nil
+}
+
+// validateServiceSecret checks the generated pdpservice.json is readable
+// JSON, so a broken file is caught here rather than as auth failures later.
+func validateServiceSecret(pdptoolPath string) error {
+	data, err := os.ReadFile(serviceSecretPath(pdptoolPath))
+	if err != nil {
+		return fmt.Errorf("service secret was not created: %w", err)
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("generated service secret is not valid JSON: %w", err)
+	}
+	return nil
+}
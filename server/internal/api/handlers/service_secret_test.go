@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+)
+
+// fakePdptoolWithoutSecret installs a fake pdptool whose create-service-secret
+// writes secret to pdpservice.json, with no secret there to begin with.
+func fakePdptoolWithoutSecret(t *testing.T, secret string) string {
+	t.Helper()
+	calls := fakePdptool(t, `[ "$1" = create-service-secret ] && sleep 0.1 && printf '%s' '`+secret+`' > pdpservice.json`)
+	if err := os.Remove(serviceSecretPath(cfg.PdptoolPath)); err != nil {
+		t.Fatalf("remove service secret: %v", err)
+	}
+	return calls
+}
+
+func TestEnsureServiceSecretCreatesSecretOnce(t *testing.T) {
+	useTestConfig(t)
+	calls := fakePdptoolWithoutSecret(t, `{"name":"test"}`)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			didCreate, err := ensureServiceSecret(context.Background(), cfg.PdptoolPath, "")
+			if err != nil {
+				t.Errorf("ensureServiceSecret: %v", err)
+			}
+			mu.Lock()
+			if didCreate {
+				created++
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if n := len(pdptoolCalls(t, calls, "create-service-secret")); n != 1 {
+		t.Errorf("create-service-secret ran %d times, want 1", n)
+	}
+	if created != 1 {
+		t.Errorf("%d callers reported creating the secret, want 1", created)
+	}
+	if !serviceSecretExists(cfg.PdptoolPath) {
+		t.Errorf("service secret was not created")
+	}
+}
+
+func TestEnsureServiceSecretRemovesInvalidSecret(t *testing.T) {
+	useTestConfig(t)
+	fakePdptoolWithoutSecret(t, "not json")
+
+	if _, err := ensureServiceSecret(context.Background(), cfg.PdptoolPath, ""); err == nil {
+		t.Errorf("ensureServiceSecret accepted a secret that is not JSON")
+	}
+	if serviceSecretExists(cfg.PdptoolPath) {
+		t.Errorf("invalid service secret was left in place")
+	}
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// shareLinkPasswordHeader carries the password of a protected share link.
+// It may also be sent as the password query parameter.
+const shareLinkPasswordHeader = "X-Share-Password"
+
+// CreateShareLinkRequest is the body of a request for a share link. All
+// fields are optional.
+type CreateShareLinkRequest struct {
+	// ExpiresIn is the link's lifetime in seconds. 0 means it never expires.
+	ExpiresIn int `json:"expiresIn" binding:"min=0"`
+	// Password, when set, must be given to open the link.
+	Password string `json:"password" binding:"max=72"`
+}
+
+// ShareLinkResponse describes a share link and how often it has been used.
+type ShareLinkResponse struct {
+	ID             uint       `json:"id"`
+	PieceID        uint       `json:"pieceId"`
+	URL            string     `json:"url"`
+	ExpiresAt      *time.Time `json:"expiresAt"`
+	Enabled        bool       `json:"enabled"`
+	HasPassword    bool       `json:"hasPassword"`
+	AccessCount    int64      `json:"accessCount"`
+	LastAccessedAt *time.Time `json:"lastAccessedAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+func shareLinkResponse(link models.ShareLink) ShareLinkResponse {
+	return ShareLinkResponse{
+		ID:             link.ID,
+		PieceID:        link.PieceID,
+		URL:            "/s/" + link.Slug,
+		ExpiresAt:      link.ExpiresAt,
+		Enabled:        link.Enabled,
+		HasPassword:    link.PasswordHash != "",
+		AccessCount:    link.AccessCount,
+		LastAccessedAt: link.LastAccessedAt,
+		CreatedAt:      link.CreatedAt,
+	}
+}
+
+// newShareLinkSlug returns a random, URL-safe slug of 22 characters.
+func newShareLinkSlug() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// @Summary Create a share link
+// @Description Create a public link to one of the caller's files that anyone can open without a wallet, optionally expiring or protected by a password. The link stops working when it is revoked, expires, or the file is removed.
+// @Tags pieces
+// @Accept json
+// @Param id path int true "Piece ID"
+// @Param request body CreateShareLinkRequest false "Expiry and password of the link"
+// @Produce json
+// @Success 201 {object} ShareLinkResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/share [post]
+func CreateShareLink(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var request CreateShareLinkRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid request parameters: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	piece, ok := findLinkablePiece(c, userID, false)
+	if !ok {
+		return
+	}
+
+	slug, err := newShareLinkSlug()
+	if err != nil {
+		log.WithField("error", err.Error()).Error("Failed to generate share link slug")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create share link",
+		})
+		return
+	}
+	link := models.ShareLink{
+		Slug:    slug,
+		PieceID: piece.ID,
+		UserID:  piece.UserID,
+		Enabled: true,
+	}
+	if request.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(request.ExpiresIn) * time.Second).Truncate(time.Second)
+		link.ExpiresAt = &expiresAt
+	}
+	if request.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+		if err != nil {
+			log.WithField("error", err.Error()).Error("Failed to hash share link password")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to create share link",
+			})
+			return
+		}
+		link.PasswordHash = string(hash)
+	}
+	if err := db.Create(&link).Error; err != nil {
+		log.WithField("pieceID", piece.ID).WithField("error", err.Error()).Error("Failed to save share link")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create share link",
+		})
+		return
+	}
+
+	log.WithField("pieceID", piece.ID).
+		WithField("shareLinkID", link.ID).
+		WithField("expiresAt", link.ExpiresAt).
+		WithField("password", link.PasswordHash != "").
+		Info("Created share link")
+
+	c.JSON(http.StatusCreated, shareLinkResponse(link))
+}
+
+// @Summary List share links
+// @Description List the share links of one of the caller's files, including revoked and expired ones, with how often and when each was last opened.
+// @Tags pieces
+// @Param id path int true "Piece ID"
+// @Produce json
+// @Success 200 {array} ShareLinkResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/share [get]
+func ListShareLinks(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var links []models.ShareLink
+	if err := db.Where("piece_id = ? AND user_id = ?", c.Param("id"), userID).
+		Order("created_at DESC").
+		Find(&links).Error; err != nil {
+		log.WithField("pieceID", c.Param("id")).WithField("error", err.Error()).Error("Failed to fetch share links")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch share links",
+		})
+		return
+	}
+	if len(links) == 0 {
+		// Tell an unknown piece apart from one that has never been shared.
+		if _, ok := findLinkablePiece(c, userID, false); !ok {
+			return
+		}
+	}
+
+	response := make([]ShareLinkResponse, len(links))
+	for i, link := range links {
+		response[i] = shareLinkResponse(link)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Revoke a share link
+// @Description Disable a share link of one of the caller's files, so opening it returns 410. Its access counts are kept.
+// @Tags pieces
+// @Param id path int true "Piece ID"
+// @Param linkId path int true "Share link ID"
+// @Success 204
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/pieces/{id}/share/{linkId} [delete]
+func RevokeShareLink(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	result := db.Model(&models.ShareLink{}).
+		Where("id = ? AND piece_id = ? AND user_id = ?", c.Param("linkId"), c.Param("id"), userID).
+		Update("enabled", false)
+	if result.Error != nil {
+		log.WithField("shareLinkID", c.Param("linkId")).WithField("error", result.Error.Error()).Error("Failed to revoke share link")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to revoke share link",
+		})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Share link not found",
+		})
+		return
+	}
+
+	log.WithField("shareLinkID", c.Param("linkId")).Info("Revoked share link")
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Open a share link
+// @Description Download the file a share link was created for. No sign-in is needed. A password protected link needs its password in the X-Share-Password header or the password query parameter. Links that were revoked or have expired, or whose file was removed, return 410.
+// @Tags download
+// @Param slug path string true "Share link slug"
+// @Param X-Share-Password header string false "Password of a protected link"
+// @Param password query string false "Password of a protected link"
+// @Param inline query bool false "Ask for images, video, audio, PDFs and plain text to be shown in the browser rather than saved"
+// @Param Range header string false "A single byte range, e.g. bytes=0-1023"
+// @Produce octet-stream
+// @Success 200 {file} binary "File content"
+// @Success 206 {file} binary "Requested range of the file"
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 410 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse
+// @Router /s/{slug} [get]
+func OpenShareLink(c *gin.Context) {
+	if db == nil {
+		log.Error("Database connection not initialized")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Internal server error: database not initialized",
+		})
+		return
+	}
+
+	var link models.ShareLink
+	if err := db.Where("slug = ?", c.Param("slug")).First(&link).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Share link not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch share link")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch share link",
+		})
+		return
+	}
+	if !link.Enabled {
+		c.JSON(http.StatusGone, gin.H{
+			"error": "Share link has been revoked",
+		})
+		return
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		c.JSON(http.StatusGone, gin.H{
+			"error": "Share link has expired",
+		})
+		return
+	}
+
+	var piece models.Piece
+	if err := db.Where("id = ? AND user_id = ?", link.PieceID, link.UserID).First(&piece).Error; err != nil || piece.PendingRemoval {
+		c.JSON(http.StatusGone, gin.H{
+			"error": "The file is no longer available",
+		})
+		return
+	}
+
+	if link.PasswordHash != "" {
+		password := c.GetHeader(shareLinkPasswordHeader)
+		if password == "" {
+			password = c.Query("password")
+		}
+		if password == "" || bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "This link needs a valid password",
+			})
+			return
+		}
+	}
+
+	// Downloads through links count against the owner's limits.
+	release, ok := limitDownload(c, piece.UserID)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if err := db.Model(&models.ShareLink{}).Where("id = ?", link.ID).UpdateColumns(map[string]interface{}{
+		"access_count":     gorm.Expr("access_count + 1"),
+		"last_accessed_at": time.Now(),
+	}).Error; err != nil {
+		log.WithField("shareLinkID", link.ID).WithField("error", err.Error()).Error("Failed to record share link access")
+	}
+
+	log.WithField("shareLinkID", link.ID).WithField("pieceID", piece.ID).Info("Serving share link")
+	sendPiece(c, piece, nil, piece.UserID)
+}
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// defaultStorageProviderName is the provider seeded from SERVICE_URL,
+// SERVICE_NAME and RECORD_KEEPER.
+const defaultStorageProviderName = "default"
+
+var errStorageProviderInUse = errors.New("storage provider has proof sets")
+
+// StorageProviderRequest adds or changes a storage provider
+// @Description Fields of a storage provider. When changing one, fields left out keep their value.
+type StorageProviderRequest struct {
+	Name         *string `json:"name" example:"calibration-1"`
+	ServiceURL   *string `json:"serviceUrl" example:"https://curio.example.com"`
+	ServiceName  *string `json:"serviceName" example:"hotvault"`
+	RecordKeeper *string `json:"recordKeeper" example:"0x6170dE2b09b404776197485F3dc6c968Ef948505"`
+	Enabled      *bool   `json:"enabled"`
+}
+
+// StorageProviderSummary is a storage provider users can choose
+// @Description A storage provider new proof sets can be created on
+type StorageProviderSummary struct {
+	ID      uint   `json:"id"`
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+}
+
+// defaultStorageProvider returns the provider proof sets are created on
+// when none is chosen: the enabled one named "default", else the oldest
+// enabled one.
+func defaultStorageProvider() (models.StorageProvider, error) {
+	var provider models.StorageProvider
+	err := db.Where("enabled = ?", true).
+		Order("name = '" + defaultStorageProviderName + "' DESC, id ASC").
+		First(&provider).Error
+	return provider, err
+}
+
+// findStorageProvider returns the enabled provider with id, or the default
+// provider when id is nil. It returns gorm.ErrRecordNotFound when there is
+// no such provider or it's disabled.
+func findStorageProvider(id *uint) (models.StorageProvider, error) {
+	if id == nil {
+		return defaultStorageProvider()
+	}
+	var provider models.StorageProvider
+	err := db.Where("id = ? AND enabled = ?", *id, true).First(&provider).Error
+	return provider, err
+}
+
+// uploadService returns the service URL and name userID's files are
+// uploaded to: their proof set's, or the default provider's while their
+// proof set hasn't been submitted to one.
+func uploadService(userID uint) (serviceURL, serviceName string) {
+	var proofSet models.ProofSet
+	if err := db.Select("service_url", "service_name").Where("user_id = ?", userID).First(&proofSet).Error; err == nil &&
+		proofSet.ServiceURL != "" && proofSet.ServiceName != "" {
+		return proofSet.ServiceURL, proofSet.ServiceName
+	}
+	if provider, err := defaultStorageProvider(); err == nil {
+		return provider.ServiceURL, provider.ServiceName
+	}
+	return cfg.ServiceURL, cfg.ServiceName
+}
+
+// applyStorageProviderRequest copies the fields set in request to provider,
+// checking each.
+func applyStorageProviderRequest(provider *models.StorageProvider, request StorageProviderRequest) error {
+	if request.Name != nil {
+		name := strings.TrimSpace(*request.Name)
+		if name == "" || len(name) > 64 {
+			return errors.New("name must be between 1 and 64 characters")
+		}
+		provider.Name = name
+	}
+	if request.ServiceURL != nil {
+		target, err := url.Parse(strings.TrimSpace(*request.ServiceURL))
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return errors.New("serviceUrl must be an absolute http or https URL")
+		}
+		provider.ServiceURL = strings.TrimRight(target.String(), "/")
+	}
+	if request.ServiceName != nil {
+		serviceName := strings.TrimSpace(*request.ServiceName)
+		if serviceName == "" {
+			return errors.New("serviceName is required")
+		}
+		provider.ServiceName = serviceName
+	}
+	if request.RecordKeeper != nil {
+		if !common.IsHexAddress(*request.RecordKeeper) {
+			return errors.New("recordKeeper must be a contract address")
+		}
+		provider.RecordKeeper = *request.RecordKeeper
+	}
+	if request.Enabled != nil {
+		provider.Enabled = *request.Enabled
+	}
+	return nil
+}
+
+// @Summary List storage providers
+// @Description List the storage providers new proof sets can be created on. default marks the one used when none is chosen.
+// @Tags storage-providers
+// @Produce json
+// @Success 200 {array} StorageProviderSummary
+// @Router /api/v1/storage-providers [get]
+func ListEnabledStorageProviders(c *gin.Context) {
+	var providers []models.StorageProvider
+	if err := db.Where("enabled = ?", true).Order("id ASC").Find(&providers).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to fetch storage providers")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch storage providers",
+		})
+		return
+	}
+
+	var defaultID uint
+	if provider, err := defaultStorageProvider(); err == nil {
+		defaultID = provider.ID
+	}
+	summaries := make([]StorageProviderSummary, len(providers))
+	for i, provider := range providers {
+		summaries[i] = StorageProviderSummary{
+			ID:      provider.ID,
+			Name:    provider.Name,
+			Default: provider.ID == defaultID,
+		}
+	}
+	c.JSON(http.StatusOK, summaries)
+}
+
+// @Summary List all storage providers
+// @Description List every storage provider, including disabled ones. Admin only.
+// @Tags admin
+// @Produce json
+// @Success 200 {array} models.StorageProvider
+// @Failure 403 {object} ErrorResponse
+// @Router /api/v1/admin/storage-providers [get]
+func ListStorageProviders(c *gin.Context) {
+	providers := []models.StorageProvider{}
+	if err := db.Order("id ASC").Find(&providers).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to fetch storage providers")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch storage providers",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, providers)
+}
+
+// @Summary Add a storage provider
+// @Description Add a PDP service proof sets can be created on. name, serviceUrl, serviceName and recordKeeper are required; enabled defaults to true. Admin only.
+// @Tags admin
+// @Accept json
+// @Param request body StorageProviderRequest true "Storage provider"
+// @Produce json
+// @Success 201 {object} models.StorageProvider
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/admin/storage-providers [post]
+func CreateStorageProvider(c *gin.Context) {
+	var request StorageProviderRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+	if request.Name == nil || request.ServiceURL == nil || request.ServiceName == nil || request.RecordKeeper == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "name, serviceUrl, serviceName and recordKeeper are required",
+		})
+		return
+	}
+
+	provider := models.StorageProvider{Enabled: true}
+	if err := applyStorageProviderRequest(&provider, request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := db.Create(&provider).Error; err != nil {
+		if isDuplicateKey(err) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "A storage provider with this name already exists",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to save storage provider")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create storage provider",
+		})
+		return
+	}
+
+	log.WithField("providerID", provider.ID).WithField("serviceURL", provider.ServiceURL).Info("Added storage provider")
+	c.JSON(http.StatusCreated, provider)
+}
+
+// @Summary Change a storage provider
+// @Description Change a storage provider's fields. Proof sets already created on it keep the service they were created on; disabling it only stops new proof sets being created on it. Admin only.
+// @Tags admin
+// @Accept json
+// @Param id path int true "Storage provider ID"
+// @Param request body StorageProviderRequest true "Fields to change"
+// @Produce json
+// @Success 200 {object} models.StorageProvider
+// @Failure 400 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/admin/storage-providers/{id} [patch]
+func UpdateStorageProvider(c *gin.Context) {
+	var request StorageProviderRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+
+	var provider models.StorageProvider
+	if err := db.First(&provider, "id = ?", c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Storage provider not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch storage provider")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update storage provider",
+		})
+		return
+	}
+
+	if err := applyStorageProviderRequest(&provider, request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := db.Save(&provider).Error; err != nil {
+		if isDuplicateKey(err) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "A storage provider with this name already exists",
+			})
+			return
+		}
+		log.WithField("providerID", provider.ID).WithField("error", err.Error()).Error("Failed to save storage provider")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to update storage provider",
+		})
+		return
+	}
+
+	log.WithField("providerID", provider.ID).Info("Updated storage provider")
+	c.JSON(http.StatusOK, provider)
+}
+
+// @Summary Delete a storage provider
+// @Description Delete a storage provider no proof set was created on. Providers with proof sets can only be disabled. Admin only.
+// @Tags admin
+// @Param id path int true "Storage provider ID"
+// @Produce json
+// @Success 200 {object} map[string]interface{} "message:Storage provider deleted"
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/admin/storage-providers/{id} [delete]
+func DeleteStorageProvider(c *gin.Context) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var provider models.StorageProvider
+		if err := tx.First(&provider, "id = ?", c.Param("id")).Error; err != nil {
+			return err
+		}
+		var proofSets int64
+		if err := tx.Model(&models.ProofSet{}).Unscoped().Where("provider_id = ?", provider.ID).Count(&proofSets).Error; err != nil {
+			return err
+		}
+		if proofSets > 0 {
+			return errStorageProviderInUse
+		}
+		return tx.Delete(&provider).Error
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Storage provider not found",
+		})
+		return
+	case errors.Is(err, errStorageProviderInUse):
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Storage provider has proof sets. Disable it instead",
+		})
+		return
+	default:
+		log.WithField("error", err.Error()).Error("Failed to delete storage provider")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to delete storage provider",
+		})
+		return
+	}
+
+	log.WithField("providerID", c.Param("id")).Info("Deleted storage provider")
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Storage provider deleted",
+	})
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+// pdptoolFlag returns the value given for flag in a pdptool call, as
+// recorded by fakePdptool.
+func pdptoolFlag(call, flag string) string {
+	fields := strings.Fields(call)
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == flag {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+func TestProofSetsOnDifferentProvidersUseTheirService(t *testing.T) {
+	useTestProofSetPolling(t)
+	useTestProofSetPolls(t)
+	providers := []models.StorageProvider{
+		{Name: defaultStorageProviderName, ServiceURL: "https://provider-a.test", ServiceName: "service-a", RecordKeeper: "0x" + strings.Repeat("aa", 20), Enabled: true},
+		{Name: "east", ServiceURL: "https://provider-b.test", ServiceName: "service-b", RecordKeeper: "0x" + strings.Repeat("bb", 20), Enabled: true},
+	}
+	var users []models.User
+	var pieces []*models.Piece
+	// Each service lists only the root of its own user's file.
+	calls := fakePdptool(t, `case "$1" in
+get-proof-set) case "$*" in
+	*provider-a.test*) printf 'Root ID: 0\nRoot CID: bagaa\n';;
+	*provider-b.test*) printf 'Root ID: 0\nRoot CID: bagab\n';;
+	esac;;
+create-proof-set) printf 'Location: /pdp/proof-sets/created/0x`+strings.Repeat("b1", 32)+`\n';;
+get-proof-set-create-status) printf 'Transaction Status: confirmed\nTransaction Successful: true\nProofset Created: true\nProofSet ID: 77\n';;
+esac`)
+	cfg.Retry.PreAddRootDelay = 0
+	cfg.Retry.RootIDPollInterval = 0
+	for i := range providers {
+		provider := &providers[i]
+		if err := db.Create(provider).Error; err != nil {
+			t.Fatalf("create storage provider: %v", err)
+		}
+		user := createTestUser(t, "0x"+strings.Repeat(fmt.Sprintf("%02d", i+1), 20))
+		users = append(users, user)
+		// Both proof sets have the same ID, each on its own service.
+		proofSet := createTestProofSet(t, user, "42")
+		db.Model(&proofSet).Updates(map[string]interface{}{"provider_id": provider.ID, "service_url": provider.ServiceURL, "service_name": provider.ServiceName})
+		db.First(&proofSet, proofSet.ID)
+
+		cid := "baga" + string(rune('a'+i)) + ":bagasub"
+		piece, err := saveUploadedPiece("", user.ID, localUpload{Filename: "a.txt", Size: 3}, &proofSet, cid, baseCID(cid))
+		if err != nil {
+			t.Fatalf("saveUploadedPiece: %v", err)
+		}
+		pieces = append(pieces, piece)
+	}
+	for i := range providers {
+		if url, name := uploadService(users[i].ID); url != providers[i].ServiceURL || name != providers[i].ServiceName {
+			t.Errorf("user %d uploads to %s %s, want %s %s", i, url, name, providers[i].ServiceURL, providers[i].ServiceName)
+		}
+	}
+
+	// Add and confirm both roots.
+	q := &rootQueue{ctx: context.Background()}
+	q.processDue()
+	q.processDue()
+	for i, piece := range pieces {
+		db.First(piece, piece.ID)
+		if stringValue(piece.RootID) != "0" || piece.RootStatus != rootStatusConfirmed || piece.ServiceURL != providers[i].ServiceURL {
+			t.Fatalf("piece %s: root %q %s on %s, want root 0 confirmed on %s",
+				piece.CID, stringValue(piece.RootID), piece.RootStatus, piece.ServiceURL, providers[i].ServiceURL)
+		}
+	}
+
+	for i, piece := range pieces {
+		cmd, err := downloadFileCommand(context.Background(), cfg.PdptoolPath, *piece, t.TempDir(), "out")
+		if err != nil {
+			t.Fatalf("downloadFileCommand: %v", err)
+		}
+		if got := pdptoolFlag(strings.Join(cmd.Args, " "), "--service-url"); got != providers[i].ServiceURL {
+			t.Errorf("download of %s from %s, want %s", piece.CID, got, providers[i].ServiceURL)
+		}
+	}
+
+	for i, piece := range pieces {
+		body := strings.NewReader(`{"pieceId":` + fmt.Sprint(piece.ID) + `}`)
+		c, recorder := newTestContext(http.MethodPost, "/api/v1/roots/remove", body, users[i])
+		c.Request.Header.Set("Content-Type", "application/json")
+		RemoveRoot(c)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("remove root of %s: status = %d: %s", piece.CID, recorder.Code, recorder.Body)
+		}
+	}
+
+	// A creation retried on the second provider is submitted and polled
+	// there, though the first is the default.
+	creator := createTestUser(t, "0x"+strings.Repeat("cc", 20))
+	creating := createCreatingProofSet(t, creator, proofSetFailed, "")
+	db.Model(&creating).Update("provider_id", providers[1].ID)
+	if recorder := retryProofSet(creating, creator); recorder.Code != http.StatusAccepted {
+		t.Fatalf("retry: status = %d, want %d: %s", recorder.Code, http.StatusAccepted, recorder.Body)
+	}
+	created := waitForProofSetStatus(t, creating.ID, proofSetReady)
+	if created.ServiceURL != providers[1].ServiceURL || created.ServiceName != providers[1].ServiceName {
+		t.Errorf("created proof set on %s %s, want %s %s", created.ServiceURL, created.ServiceName, providers[1].ServiceURL, providers[1].ServiceName)
+	}
+
+	expected := map[string][]string{
+		"add-roots":                   {providers[0].ServiceURL, providers[1].ServiceURL},
+		"get-proof-set":               {providers[0].ServiceURL, providers[1].ServiceURL},
+		"remove-roots":                {providers[0].ServiceURL, providers[1].ServiceURL},
+		"create-proof-set":            {providers[1].ServiceURL},
+		"get-proof-set-create-status": {providers[1].ServiceURL},
+	}
+	names := map[string]string{providers[0].ServiceURL: providers[0].ServiceName, providers[1].ServiceURL: providers[1].ServiceName}
+	for command, want := range expected {
+		called := pdptoolCalls(t, calls, command)
+		if len(called) != len(want) {
+			t.Errorf("%s ran %d times, want %d: %q", command, len(called), len(want), called)
+			continue
+		}
+		for i, call := range called {
+			url, name := pdptoolFlag(call, "--service-url"), pdptoolFlag(call, "--service-name")
+			if url != want[i] || name != names[want[i]] {
+				t.Errorf("%s call %d went to %s %s, want %s %s", command, i, url, name, want[i], names[want[i]])
+			}
+		}
+	}
+	for i, call := range pdptoolCalls(t, calls, "add-roots") {
+		if root := pdptoolFlag(call, "--root"); root != pieces[i].CID {
+			t.Errorf("add-roots call %d added %s, want %s", i, root, pieces[i].CID)
+		}
+	}
+	if call := pdptoolCalls(t, calls, "create-proof-set"); len(call) == 1 && pdptoolFlag(call[0], "--recordkeeper") != providers[1].RecordKeeper {
+		t.Errorf("create-proof-set record keeper = %s, want %s", pdptoolFlag(call[0], "--recordkeeper"), providers[1].RecordKeeper)
+	}
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Statuses of a Transaction.
+const (
+	transactionPending   = "pending"
+	transactionConfirmed = "confirmed"
+	transactionFailed    = "failed"
+)
+
+// Methods of the transactions the server sends for users.
+const transactionMethodCreateProofSet = "create-proof-set"
+
+const (
+	defaultTransactionsLimit = 50
+	maxTransactionsLimit     = 200
+)
+
+// TransactionListResponse is a page of the caller's transactions
+// @Description Page of the on-chain transactions sent on the caller's behalf, newest first
+type TransactionListResponse struct {
+	Transactions []models.Transaction `json:"transactions"`
+	Total        int64                `json:"total"`
+	Limit        int                  `json:"limit"`
+	Offset       int                  `json:"offset"`
+}
+
+// recordTransaction saves txHash, sent by method for user, as pending. A
+// hash already recorded is left as it is.
+func recordTransaction(tx *gorm.DB, user *models.User, proofSetID uint, txHash, method string) error {
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Transaction{
+		UserID:        user.ID,
+		TxHash:        txHash,
+		Method:        method,
+		Status:        transactionPending,
+		WalletAddress: user.WalletAddress,
+		ProofSetID:    &proofSetID,
+	}).Error
+}
+
+// settleCreationTransaction records the outcome of a proof set creation
+// transaction once get-proof-set-create-status reports one, with its block
+// when the receipt can be read from ETH_RPC_URL. It reports whether status
+// was an outcome.
+func settleCreationTransaction(ctx context.Context, txHash string, status proofSetCreateStatus) bool {
+	var outcome string
+	switch {
+	case status.TxStatus == "failed" || status.TxSuccess == "false":
+		outcome = transactionFailed
+	case status.TxStatus == "confirmed" && status.TxSuccess == "true":
+		outcome = transactionConfirmed
+	default:
+		return false
+	}
+
+	updates := map[string]interface{}{"status": outcome}
+	receipt, err := fetchTransactionReceipt(ctx, txHash)
+	if err != nil {
+		log.WithField("txHash", txHash).WithField("error", err.Error()).Warning("Failed to fetch transaction receipt")
+	}
+	if receipt != nil {
+		updates["block_hash"] = receipt.BlockHash.Hex()
+		if receipt.BlockNumber != nil {
+			updates["block_number"] = receipt.BlockNumber.Uint64()
+		}
+		if receipt.Status == types.ReceiptStatusFailed {
+			updates["status"] = transactionFailed
+		}
+	}
+	if err := db.Model(&models.Transaction{}).Where("tx_hash = ?", txHash).Updates(updates).Error; err != nil {
+		log.WithField("txHash", txHash).WithField("error", err.Error()).Error("Failed to save transaction outcome")
+	}
+	return true
+}
+
+// fetchTransactionReceipt returns txHash's receipt, or nil when ETH_RPC_URL
+// isn't set or the transaction isn't mined yet.
+func fetchTransactionReceipt(ctx context.Context, txHash string) (*types.Receipt, error) {
+	if cfg.Ethereum.RPCURL == "" {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, proofSetStatusTimeout)
+	defer cancel()
+	client, err := ethclient.DialContext(ctx, cfg.Ethereum.RPCURL)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	receipt, err := client.TransactionReceipt(ctx, common.HexToHash(txHash))
+	if errors.Is(err, ethereum.NotFound) {
+		return nil, nil
+	}
+	return receipt, err
+}
+
+// @Summary List the caller's transactions
+// @Description List the on-chain transactions the server sent on the caller's behalf, such as proof set creations, newest first. status is pending until the transaction settles, then confirmed or failed; blockNumber and blockHash are set once its receipt was read.
+// @Tags transactions
+// @Param status query string false "Only transactions with this status: pending, confirmed or failed"
+// @Param limit query int false "Maximum number of transactions to return (max 200)" default(50)
+// @Param offset query int false "Number of transactions to skip" default(0)
+// @Produce json
+// @Success 200 {object} TransactionListResponse
+// @Failure 400 {object} ErrorResponse
+// @Router /api/v1/transactions [get]
+func ListTransactions(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultTransactionsLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "limit must be a positive integer",
+		})
+		return
+	}
+	if limit > maxTransactionsLimit {
+		limit = maxTransactionsLimit
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "offset must be a non-negative integer",
+		})
+		return
+	}
+
+	query := db.Model(&models.Transaction{}).Where("user_id = ?", userID)
+	switch status := c.Query("status"); status {
+	case "":
+	case transactionPending, transactionConfirmed, transactionFailed:
+		query = query.Where("status = ?", status)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "status must be pending, confirmed or failed",
+		})
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to count transactions")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch transactions",
+		})
+		return
+	}
+
+	transactions := []models.Transaction{}
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&transactions).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to fetch transactions")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch transactions",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, TransactionListResponse{
+		Transactions: transactions,
+		Total:        total,
+		Limit:        limit,
+		Offset:       offset,
+	})
+}
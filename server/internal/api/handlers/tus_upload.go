@@ -0,0 +1,455 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// tusVersion is the version of the tus resumable upload protocol served
+// under /tus.
+const tusVersion = "1.0.0"
+
+// tusHeaders sets the headers every tus response carries.
+func tusHeaders(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Cache-Control", "no-store")
+}
+
+// tusVersionSupported rejects requests for a protocol version other than the
+// one served. It writes the response and returns false when the version is
+// missing or different.
+func tusVersionSupported(c *gin.Context) bool {
+	if c.GetHeader("Tus-Resumable") == tusVersion {
+		return true
+	}
+	c.Header("Tus-Version", tusVersion)
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"error": fmt.Sprintf("Tus-Resumable must be %s", tusVersion),
+	})
+	return false
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated keys,
+// each followed by a space and its base64 encoded value.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("metadata %q is not valid base64", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func encodeTusMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// tusUploadPartPath is the file a tus upload's bytes are appended to. A tus
+// upload is a chunked upload with a single chunk, written to its .part file
+// until the last byte arrives, so the upload's offset is the size of that
+// file. It is then assembled and stored like any other chunked upload.
+func tusUploadPartPath(info *ChunkedUploadInfo) string {
+	return filepath.Join(info.TempDir, "chunk_0.part")
+}
+
+// tusOffset returns how many bytes of a tus upload have been received.
+func tusOffset(info *ChunkedUploadInfo) (int64, error) {
+	chunkedUploadsMutex.RLock()
+	received := info.ChunksReceived[0]
+	chunkedUploadsMutex.RUnlock()
+	if received {
+		return info.TotalSize, nil
+	}
+
+	stat, err := os.Stat(tusUploadPartPath(info))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// tusUpload looks up the caller's tus upload named in the :id path
+// parameter. It writes the response and returns false when there is none.
+func tusUpload(c *gin.Context) (*ChunkedUploadInfo, bool) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return nil, false
+	}
+
+	chunkedUploadsMutex.RLock()
+	uploadInfo, exists := chunkedUploads[c.Param("id")]
+	chunkedUploadsMutex.RUnlock()
+
+	// Uploads started through the chunk endpoints can't be continued with
+	// tus, since their chunks aren't one stream of bytes.
+	if !exists || uploadInfo.UserID != userID.(uint) || uploadInfo.TotalChunks != 1 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Upload not found",
+		})
+		return nil, false
+	}
+	return uploadInfo, true
+}
+
+// @Summary Describe tus support
+// @Description Report the tus protocol version, extensions and maximum upload size supported
+// @Tags upload
+// @Success 204
+// @Router /api/v1/tus [options]
+func TusOptions(c *gin.Context) {
+	tusHeaders(c)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", "creation")
+	c.Header("Tus-Max-Size", strconv.FormatInt(cfg.Upload.MaxSize, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Create a tus upload
+// @Description Start a resumable upload using the tus 1.0.0 creation extension. The file name and type are read from the filename (or name) and filetype (or type) metadata; compress and fileHash are honoured as for chunked uploads.
+// @Tags upload
+// @Param Tus-Resumable header string true "Protocol version, 1.0.0"
+// @Param Upload-Length header int true "Size of the file in bytes"
+// @Param Upload-Metadata header string false "tus metadata"
+// @Success 201
+// @Failure 400 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 507 {object} ErrorResponse
+// @Router /api/v1/tus [post]
+func CreateTusUpload(c *gin.Context) {
+	tusHeaders(c)
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+	if !tusVersionSupported(c) {
+		return
+	}
+
+	totalSize, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || totalSize <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Upload-Length must be a positive number of bytes",
+		})
+		return
+	}
+
+	metadata, err := parseTusMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid Upload-Metadata: " + err.Error(),
+		})
+		return
+	}
+	filename := metadata["filename"]
+	if filename == "" {
+		filename = metadata["name"]
+	}
+	if filename == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Upload-Metadata must include a filename",
+		})
+		return
+	}
+	fileType := metadata["filetype"]
+	if fileType == "" {
+		fileType = metadata["type"]
+	}
+	fileHash, ok := normalizeFileHash(metadata["fileHash"])
+	if !ok {
+		respondInvalidFileHash(c)
+		return
+	}
+
+	if report := uploadPreflight(c.Request.Context(), userID.(uint), totalSize); !report.Ready {
+		if check, _ := report.failure(); check.Name == "size" {
+			respondFileTooLarge(c, cfg.Upload.MaxSize)
+			return
+		}
+		respondPreflightFailure(c, report)
+		return
+	}
+
+	if !cfg.Upload.PadFiles && pieceNeedsPadding(totalSize) {
+		respondUnalignedSize(c, filename, totalSize)
+		return
+	}
+
+	if headroom, checked := chunkedUploadHeadroom(); checked && headroom < totalSize*2 {
+		respondInsufficientStorage(c, totalSize*2, headroom)
+		return
+	}
+
+	uploadID := uuid.New().String()
+	tempDir := filepath.Join(chunkedUploadsRoot(), uploadID)
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create temp directory: " + err.Error(),
+		})
+		return
+	}
+
+	now := time.Now()
+	uploadInfo := &ChunkedUploadInfo{
+		ID:             uploadID,
+		UserID:         userID.(uint),
+		Filename:       filename,
+		ChunkSize:      totalSize,
+		TotalSize:      totalSize,
+		TotalChunks:    1,
+		ChunksReceived: make(map[int]bool),
+		TempDir:        tempDir,
+		Status:         "initialized",
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		FileType:       fileType,
+		Compress:       metadata["compress"] == "true",
+		FileHash:       fileHash,
+	}
+
+	chunkedUploadsMutex.Lock()
+	chunkedUploads[uploadID] = uploadInfo
+	chunkedUploadsMutex.Unlock()
+	saveChunkedUpload(uploadInfo)
+	publishChunkedUpload(uploadInfo)
+
+	log.WithField("uploadId", uploadID).
+		WithField("filename", filename).
+		WithField("totalSize", formatFileSize(totalSize)).
+		Info("Created tus upload")
+
+	c.Header("Location", "/api/v1/tus/"+uploadID)
+	c.Status(http.StatusCreated)
+}
+
+// @Summary Get a tus upload's offset
+// @Description Report how many bytes of a tus upload have been received, so an interrupted upload can resume from there
+// @Tags upload
+// @Param id path string true "Upload ID"
+// @Param Tus-Resumable header string true "Protocol version, 1.0.0"
+// @Success 200
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/tus/{id} [head]
+func GetTusUploadOffset(c *gin.Context) {
+	tusHeaders(c)
+	if !tusVersionSupported(c) {
+		return
+	}
+	uploadInfo, ok := tusUpload(c)
+	if !ok {
+		return
+	}
+
+	offset, err := tusOffset(uploadInfo)
+	if err != nil {
+		log.WithField("uploadId", uploadInfo.ID).WithField("error", err.Error()).Error("Failed to read tus upload offset")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	metadata := map[string]string{"filename": uploadInfo.Filename}
+	chunkedUploadsMutex.RLock()
+	if uploadInfo.JobID != "" {
+		metadata["jobId"] = uploadInfo.JobID
+	}
+	chunkedUploadsMutex.RUnlock()
+
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(uploadInfo.TotalSize, 10))
+	c.Header("Upload-Metadata", encodeTusMetadata(metadata))
+	c.Status(http.StatusOK)
+}
+
+// @Summary Append to a tus upload
+// @Description Append bytes to a tus upload at the given offset. The request that completes the upload starts storing the file and returns its job ID in the Upload-Job-Id header.
+// @Tags upload
+// @Accept application/offset+octet-stream
+// @Param id path string true "Upload ID"
+// @Param Tus-Resumable header string true "Protocol version, 1.0.0"
+// @Param Upload-Offset header int true "Offset the bytes are written at"
+// @Success 204
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 423 {object} ErrorResponse
+// @Router /api/v1/tus/{id} [patch]
+func PatchTusUpload(c *gin.Context) {
+	tusHeaders(c)
+	if !tusVersionSupported(c) {
+		return
+	}
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error": "Content-Type must be application/offset+octet-stream",
+		})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Upload-Offset must be a non-negative number of bytes",
+		})
+		return
+	}
+
+	uploadInfo, ok := tusUpload(c)
+	if !ok {
+		return
+	}
+
+	// Claim the upload so two PATCH requests can't append at once.
+	chunkedUploadsMutex.Lock()
+	if chunkedUploadHandedOff(uploadInfo.Status) || uploadInfo.ChunksReceived[0] {
+		chunkedUploadsMutex.Unlock()
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Upload is already complete",
+		})
+		return
+	}
+	if uploadInfo.chunksWriting[0] {
+		chunkedUploadsMutex.Unlock()
+		c.JSON(http.StatusLocked, gin.H{
+			"error": "Upload is already being written to",
+		})
+		return
+	}
+	if uploadInfo.chunksWriting == nil {
+		uploadInfo.chunksWriting = make(map[int]bool)
+	}
+	uploadInfo.chunksWriting[0] = true
+	chunkedUploadsMutex.Unlock()
+	defer func() {
+		chunkedUploadsMutex.Lock()
+		delete(uploadInfo.chunksWriting, 0)
+		chunkedUploadsMutex.Unlock()
+	}()
+
+	current, err := tusOffset(uploadInfo)
+	if err != nil {
+		log.WithField("uploadId", uploadInfo.ID).WithField("error", err.Error()).Error("Failed to read tus upload offset")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if offset != current {
+		c.Header("Upload-Offset", strconv.FormatInt(current, 10))
+		c.JSON(http.StatusConflict, gin.H{
+			"error":        fmt.Sprintf("Upload-Offset is %d but the upload is at %d", offset, current),
+			"uploadOffset": current,
+		})
+		return
+	}
+
+	remaining := uploadInfo.TotalSize - current
+	if headroom, ok := chunkedUploadHeadroom(); ok && headroom < remaining+uploadInfo.TotalSize {
+		respondInsufficientStorage(c, remaining+uploadInfo.TotalSize, headroom)
+		return
+	}
+
+	partPath := tusUploadPartPath(uploadInfo)
+	dst, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to open upload file: " + err.Error(),
+		})
+		return
+	}
+
+	// Bytes written before the client goes away are kept, so it can resume
+	// from wherever HEAD reports the upload got to.
+	written, copyErr := io.Copy(dst, io.LimitReader(c.Request.Body, remaining+1))
+	if written > remaining {
+		dst.Truncate(current)
+		dst.Close()
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("Request body is larger than the %d bytes left in the upload", remaining),
+		})
+		return
+	}
+	if err := dst.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	chunkedUploadsMutex.Lock()
+	uploadInfo.UpdatedAt = time.Now()
+	if uploadInfo.Status == "initialized" && written > 0 {
+		uploadInfo.Status = "inProgress"
+	}
+	chunkedUploadsMutex.Unlock()
+
+	if copyErr != nil {
+		log.WithField("uploadId", uploadInfo.ID).
+			WithField("written", written).
+			WithField("error", copyErr.Error()).
+			Warning("tus PATCH ended early")
+		saveChunkedUpload(uploadInfo)
+		c.Header("Upload-Offset", strconv.FormatInt(current+written, 10))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to save upload data: " + copyErr.Error(),
+		})
+		return
+	}
+
+	newOffset := current + written
+	c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	if newOffset < uploadInfo.TotalSize {
+		saveChunkedUpload(uploadInfo)
+		publishChunkedUpload(uploadInfo)
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if err := os.Rename(partPath, filepath.Join(uploadInfo.TempDir, "chunk_0")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to save upload data: " + err.Error(),
+		})
+		return
+	}
+
+	chunkedUploadsMutex.Lock()
+	uploadInfo.ChunksReceived[0] = true
+	uploadInfo.ChunkBytes = map[int]int64{0: newOffset}
+	uploadInfo.UploadedChunks = 1
+	uploadInfo.Status = "assembling"
+	chunkedUploadsMutex.Unlock()
+
+	log.WithField("uploadId", uploadInfo.ID).Info("tus upload received, starting processing")
+
+	jobID, ok := startChunkedUploadProcessing(c, uploadInfo, uploadInfo.UserID)
+	if !ok {
+		return
+	}
+	c.Header("Upload-Job-Id", jobID)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+)
+
+// tusRequest returns a tus request for upload id, or for creating an upload
+// when id is empty, with the protocol version set. Handlers given it should be
+// followed by c.Writer.WriteHeaderNow(), as gin does for responses with only
+// a status.
+func tusRequest(method, id string, headers map[string]string, body io.Reader, user models.User) (*gin.Context, *httptest.ResponseRecorder) {
+	target := "/api/v1/tus"
+	if id != "" {
+		target += "/" + id
+	}
+	c, recorder := newTestContext(method, target, body, user)
+	c.Params = gin.Params{{Key: "id", Value: id}}
+	c.Request.Header.Set("Tus-Resumable", tusVersion)
+	for name, value := range headers {
+		c.Request.Header.Set(name, value)
+	}
+	return c, recorder
+}
+
+// createTusUpload starts a tus upload of size bytes and returns its ID.
+func createTusUpload(t *testing.T, user models.User, size int) string {
+	t.Helper()
+	c, recorder := tusRequest(http.MethodPost, "", map[string]string{
+		"Upload-Length":   strconv.Itoa(size),
+		"Upload-Metadata": encodeTusMetadata(map[string]string{"filename": "notes.txt", "filetype": "text/plain"}),
+	}, nil, user)
+	CreateTusUpload(c)
+	c.Writer.WriteHeaderNow()
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("create tus upload: status = %d, want %d: %s", recorder.Code, http.StatusCreated, recorder.Body)
+	}
+	id := strings.TrimPrefix(recorder.Header().Get("Location"), "/api/v1/tus/")
+	t.Cleanup(func() { forgetChunkedUpload(id) })
+	return id
+}
+
+func patchTusUpload(id string, offset int, body io.Reader, user models.User) *httptest.ResponseRecorder {
+	c, recorder := tusRequest(http.MethodPatch, id, map[string]string{
+		"Upload-Offset": strconv.Itoa(offset),
+		"Content-Type":  "application/offset+octet-stream",
+	}, body, user)
+	PatchTusUpload(c)
+	c.Writer.WriteHeaderNow()
+	return recorder
+}
+
+func tusUploadOffset(t *testing.T, id string, user models.User) int {
+	t.Helper()
+	c, recorder := tusRequest(http.MethodHead, id, nil, nil, user)
+	GetTusUploadOffset(c)
+	c.Writer.WriteHeaderNow()
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("HEAD: status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	offset, _ := strconv.Atoi(recorder.Header().Get("Upload-Offset"))
+	return offset
+}
+
+// brokenReader fails once its data has been read, as a request body does
+// when the client goes away mid-PATCH.
+type brokenReader struct {
+	data io.Reader
+}
+
+func (r brokenReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset by peer")
+	}
+	return n, err
+}
+
+func useTestTus(t *testing.T) models.User {
+	t.Helper()
+	useTestDB(t)
+	useTestConfig(t)
+	useTestUploadQueue(t)
+	fakePdptool(t, "exit 1")
+	user := createTestUser(t, "0x1")
+	createTestProofSet(t, user, "42")
+	return user
+}
+
+func TestPatchTusUploadOffsetMismatch(t *testing.T) {
+	user := useTestTus(t)
+	data := []byte("hello, resumable world\n")
+	id := createTusUpload(t, user, len(data))
+
+	if recorder := patchTusUpload(id, 0, bytes.NewReader(data[:5]), user); recorder.Code != http.StatusNoContent {
+		t.Fatalf("first PATCH: status = %d, want %d: %s", recorder.Code, http.StatusNoContent, recorder.Body)
+	}
+
+	for _, offset := range []int{0, 3, 6, len(data)} {
+		recorder := patchTusUpload(id, offset, bytes.NewReader(data[offset:]), user)
+		if recorder.Code != http.StatusConflict {
+			t.Errorf("PATCH at %d: status = %d, want %d", offset, recorder.Code, http.StatusConflict)
+		}
+		if got := recorder.Header().Get("Upload-Offset"); got != "5" {
+			t.Errorf("PATCH at %d: Upload-Offset = %q, want the upload's offset 5", offset, got)
+		}
+	}
+	if offset := tusUploadOffset(t, id, user); offset != 5 {
+		t.Errorf("offset after rejected PATCHes = %d, want 5", offset)
+	}
+}
+
+func TestPatchTusUploadResumesAfterPartialPatch(t *testing.T) {
+	user := useTestTus(t)
+	data := []byte("hello, resumable world\n")
+	id := createTusUpload(t, user, len(data))
+
+	// The client goes away after sending the first 9 bytes.
+	recorder := patchTusUpload(id, 0, brokenReader{bytes.NewReader(data[:9])}, user)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("interrupted PATCH: status = %d, want %d", recorder.Code, http.StatusInternalServerError)
+	}
+	if got := recorder.Header().Get("Upload-Offset"); got != "9" {
+		t.Errorf("interrupted PATCH: Upload-Offset = %q, want 9", got)
+	}
+
+	// As tus-js-client does, it asks where to resume and sends the rest.
+	offset := tusUploadOffset(t, id, user)
+	if offset != 9 {
+		t.Fatalf("HEAD after interrupted PATCH: offset = %d, want 9", offset)
+	}
+	recorder = patchTusUpload(id, offset, bytes.NewReader(data[offset:]), user)
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("resumed PATCH: status = %d, want %d: %s", recorder.Code, http.StatusNoContent, recorder.Body)
+	}
+	if got := recorder.Header().Get("Upload-Offset"); got != strconv.Itoa(len(data)) {
+		t.Errorf("resumed PATCH: Upload-Offset = %q, want %d", got, len(data))
+	}
+	if recorder.Header().Get("Upload-Job-Id") == "" {
+		t.Errorf("completed upload returned no job ID")
+	}
+
+	chunkedUploadsMutex.RLock()
+	info := chunkedUploads[id]
+	chunkedUploadsMutex.RUnlock()
+	waitForChunkedStatus(t, info, "processing")
+	assembled, err := os.ReadFile(filepath.Join(info.TempDir, sanitizeFilename(info.Filename)))
+	if err != nil {
+		t.Fatalf("read assembled file: %v", err)
+	}
+	if !bytes.Equal(assembled, data) {
+		t.Errorf("assembled file = %q, want %q", assembled, data)
+	}
+}
+
+func TestTusRequestsNeedSupportedVersion(t *testing.T) {
+	user := useTestTus(t)
+	c, recorder := tusRequest(http.MethodPost, "", map[string]string{
+		"Tus-Resumable": "0.2.2",
+		"Upload-Length": "10",
+	}, nil, user)
+	CreateTusUpload(c)
+
+	if recorder.Code != http.StatusPreconditionFailed {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusPreconditionFailed)
+	}
+	if got := recorder.Header().Get("Tus-Version"); got != tusVersion {
+		t.Errorf("Tus-Version = %q, want %q", got, tusVersion)
+	}
+}
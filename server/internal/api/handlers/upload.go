@@ -6,14 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
-	"mime/multipart"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -22,6 +19,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/hotvault/backend/config"
 	"github.com/hotvault/backend/internal/models"
+	"github.com/hotvault/backend/internal/services/pricing"
+	"github.com/hotvault/backend/internal/services/scan"
 	"github.com/hotvault/backend/pkg/logger"
 	"gorm.io/gorm"
 )
@@ -33,8 +32,12 @@ var (
 )
 
 var (
-	uploadJobs     = make(map[string]UploadProgress)
-	uploadJobsLock sync.RWMutex
+	uploadJobs      = make(map[string]UploadProgress)
+	uploadJobOwners = make(map[string]uint)
+	// uploadJobFinishedAt records when a cached job reached a terminal
+	// status, so the janitor knows when to evict it.
+	uploadJobFinishedAt = make(map[string]time.Time)
+	uploadJobsLock      sync.RWMutex
 )
 
 func init() {
@@ -71,6 +74,28 @@ func Initialize(database *gorm.DB, appConfig *config.Config) {
 	db = database
 	cfg = appConfig
 
+	resumeUploadedJobs()
+	markInterruptedUploadJobs()
+	restoreChunkedUploads()
+	uploads = newUploadQueue(cfg.Upload.Concurrency, cfg.Upload.QueueWarnDepth)
+	roots = startRootQueue()
+	proofSetPolls = startProofSetPoller()
+	webhooks = startWebhookDispatcher()
+	proofs = startProofRecorder(cfg.Proofs.RecordInterval, cfg.Proofs.Retention, cfg.Proofs.MaxBackoff, cfg.Proofs.ChallengeWindow, cfg.Proofs.GraceEpochs)
+	usage = startUsageRecorder()
+	scanner = scan.New(cfg.Scan)
+	pricer = pricing.New(cfg.Pricing, cfg.Ethereum.RPCURL)
+	if cfg.Scan.Enabled {
+		log.WithField("clamdAddress", cfg.Scan.Address).
+			WithField("failOpen", cfg.Scan.FailOpen).
+			Info("Malware scanning enabled for uploads")
+	}
+	janitor = startUploadJanitor(cfg.Upload.JobRetention, cfg.Upload.JobHistoryPerUser)
+	removals = startRemovalFinalizer(cfg.Upload.RemovalInterval, cfg.Upload.RemovalBatch, cfg.Upload.RemovalDryRun)
+	downloads = newDownloadCache(cfg.Download.CacheDir, cfg.Download.CacheMaxSize)
+	downloadLimits = newDownloadLimiter(cfg.Download.MaxConcurrentPerUser, cfg.Download.UserBytesPerSecond)
+	chunkedJanitor = startChunkedUploadJanitor(cfg.Upload.ChunkedUploadExpiry, cfg.Upload.ChunkedCleanupInterval)
+
 	// Change working directory to pdptool directory
 	if cfg.PdptoolPath != "" {
 		pdptoolDir := getPdptoolParentDir(cfg.PdptoolPath)
@@ -85,24 +110,45 @@ func Initialize(database *gorm.DB, appConfig *config.Config) {
 }
 
 type UploadProgress struct {
-	Status     string `json:"status"`
-	Progress   int    `json:"progress,omitempty"`
-	Message    string `json:"message,omitempty"`
-	CID        string `json:"cid,omitempty"`
-	Error      string `json:"error,omitempty"`
-	Filename   string `json:"filename,omitempty"`
-	TotalSize  int64  `json:"totalSize,omitempty"`
-	JobID      string `json:"jobId,omitempty"`
-	ProofSetID string `json:"proofSetId,omitempty"`
+	Status        string `json:"status"`
+	Progress      int    `json:"progress,omitempty"`
+	Message       string `json:"message,omitempty"`
+	CID           string `json:"cid,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Filename      string `json:"filename,omitempty"`
+	TotalSize     int64  `json:"totalSize,omitempty"`
+	JobID         string `json:"jobId,omitempty"`
+	ProofSetID    string `json:"proofSetId,omitempty"`
+	BytesUploaded int64  `json:"bytesUploaded,omitempty"`
+	QueuePosition int    `json:"queuePosition,omitempty"`
+	Duplicate     bool   `json:"duplicate,omitempty"`
+	PieceID       uint   `json:"pieceId,omitempty"`
+	Checksum      string `json:"checksum,omitempty"`
+	BaseCID       string `json:"baseCid,omitempty"`
+	ContentType   string `json:"contentType,omitempty"`
+	Compressed    bool   `json:"compressed,omitempty"`
+	StoredSize    int64  `json:"storedSize,omitempty"`
+	// Files holds the per-file status of a multi-file upload.
+	Files []FileProgress `json:"files,omitempty"`
 }
 
 // @Summary Upload a file to PDP service
-// @Description Upload a file to the PDP service with piece preparation and returns a job ID for status polling
+// @Description Upload a file to the PDP service with piece preparation and returns a job ID for status polling. Several files can be sent at once as files[] fields; they are tracked by one job with per-file statuses and added to the proof set together. A metadata field may carry a JSON object of string values, which every file gets and which can be filtered on when listing pieces; other values, or one larger than the configured limit, are refused with 422. retainUntil or retentionDays schedules the files' removal; a time not in the future is refused with 422. A file with the same contents as one already in the caller's proof set isn't stored again: the job completes as a duplicate of that piece, whose metadata records the name the file was sent under as duplicateFilename when it differs.
 // @Tags upload
 // @Accept multipart/form-data
-// @Param file formData file true "File to upload"
+// @Param file formData file false "File to upload"
+// @Param files[] formData file false "Files to upload in a single job"
+// @Param metadata formData string false "JSON object of string fields to attach to the stored files"
+// @Param retainUntil formData string false "RFC 3339 time or YYYY-MM-DD date after which the stored files are removed automatically"
+// @Param retentionDays formData int false "Number of days after which the stored files are removed automatically, instead of retainUntil"
+// @Param compress query bool false "Gzip files before storing them; files that are already compressed are stored as is"
 // @Produce json
 // @Success 200 {object} UploadProgress
+// @Failure 409 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 507 {object} ErrorResponse
 // @Router /api/v1/upload [post]
 func UploadFile(c *gin.Context) {
 	userID, exists := c.Get("userID")
@@ -112,16 +158,31 @@ func UploadFile(c *gin.Context) {
 		})
 		return
 	}
-	const MAX_UPLOAD_SIZE = 10 * 1024 * 1024 * 1024
-	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MAX_UPLOAD_SIZE)
+	if respondPreflightFailure(c, uploadPreflight(c.Request.Context(), userID.(uint), 0)) {
+		return
+	}
 
-	file, err := c.FormFile("file")
+	compress := c.Query("compress") == "true"
+
+	maxUploadSize := cfg.Upload.MaxSize
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize+multipartOverhead)
+
+	files, tempDir, batch, err := receiveMultipartFiles(c.Request, maxUploadSize)
 	if err != nil {
 		var maxBytesError *http.MaxBytesError
-		if errors.As(err, &maxBytesError) {
-			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
-				"error":   "File too large",
-				"message": fmt.Sprintf("Maximum file size is %s", formatFileSize(MAX_UPLOAD_SIZE)),
+		if errors.As(err, &maxBytesError) || errors.Is(err, errUploadTooLarge) {
+			respondFileTooLarge(c, maxUploadSize)
+			return
+		}
+		if errors.Is(err, errInvalidMetadata) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": metadataError(err),
+			})
+			return
+		}
+		if errors.Is(err, errInvalidRetention) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error": retentionError(err),
 			})
 			return
 		}
@@ -133,80 +194,231 @@ func UploadFile(c *gin.Context) {
 		return
 	}
 
+	var totalSize int64
+	for i := range files {
+		totalSize += files[i].Size
+		contentType, err := detectFileContentType(files[i].Path)
+		if err != nil {
+			os.RemoveAll(tempDir)
+			log.WithField("error", err.Error()).Error("Failed to detect content type of upload")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to inspect uploaded file",
+			})
+			return
+		}
+		if !mimeTypeAllowed(contentType) {
+			os.RemoveAll(tempDir)
+			log.WithField("filename", files[i].Filename).WithField("contentType", contentType).Warning("Rejected upload with disallowed content type")
+			respondUnsupportedMediaType(c, files[i].Filename, contentType)
+			return
+		}
+		files[i].ContentType = contentType
+		files[i].Compress = compress
+
+		if !cfg.Upload.PadFiles && pieceNeedsPadding(files[i].Size) {
+			os.RemoveAll(tempDir)
+			respondUnalignedSize(c, files[i].Filename, files[i].Size)
+			return
+		}
+	}
+
+	if respondPreflightFailure(c, uploadPreflight(c.Request.Context(), userID.(uint), totalSize)) {
+		os.RemoveAll(tempDir)
+		return
+	}
+	pdptoolPath := cfg.PdptoolPath
+
+	if batch {
+		uploadBatch(c, userID.(uint), files, tempDir)
+		return
+	}
+
+	upload := files[0]
+	upload.TempDir = tempDir
 	jobID := uuid.New().String()
 
-	uploadJobsLock.Lock()
-	uploadJobs[jobID] = UploadProgress{
+	if existing, proofSet, ok := findDuplicateByChecksum(userID.(uint), upload.Checksum); ok {
+		os.RemoveAll(tempDir)
+		createUploadJob(jobID, userID.(uint), UploadProgress{
+			Status:    "queued",
+			Filename:  upload.Filename,
+			TotalSize: upload.Size,
+		})
+		completeDuplicateUpload(jobID, existing, proofSet, upload)
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "File already stored",
+			"jobId":     jobID,
+			"status":    "complete",
+			"duplicate": true,
+			"pieceId":   existing.ID,
+			"cid":       existing.CID,
+		})
+		return
+	}
+
+	createUploadJob(jobID, userID.(uint), UploadProgress{
 		Status:    "uploading",
 		Progress:  0,
 		Message:   "Starting upload",
-		Filename:  file.Filename,
-		TotalSize: file.Size,
-		JobID:     jobID,
-	}
-	uploadJobsLock.Unlock()
-
-	pdptoolPath := cfg.PdptoolPath
-	if _, err := os.Stat(pdptoolPath); os.IsNotExist(err) {
-		log.WithField("pdptoolPath", pdptoolPath).Error("PDPTool executable not found")
-		uploadJobsLock.Lock()
-		uploadJobs[jobID] = UploadProgress{
+		Filename:  upload.Filename,
+		TotalSize: upload.Size,
+	})
+	setJobTempDir(jobID, tempDir)
+	setJobMetadata(jobID, upload.Metadata)
+	setJobRetention(jobID, upload.RetainUntil)
+
+	if err := enqueueUpload(jobID, userID.(uint), func() {
+		processUpload(jobID, upload, userID.(uint), pdptoolPath)
+	}); err != nil {
+		os.RemoveAll(tempDir)
+		updateJobStatus(jobID, UploadProgress{
 			Status:  "error",
-			Error:   "PDPTool executable not found",
-			Message: fmt.Sprintf("File not found at %s", pdptoolPath),
-		}
-		uploadJobsLock.Unlock()
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "PDPTool executable not found",
-			"message": fmt.Sprintf("File not found at %s", pdptoolPath),
+			Error:   "Server is shutting down",
+			Message: "The upload could not be queued. Please try again shortly.",
+		})
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Server is shutting down, please retry shortly",
 		})
 		return
 	}
 
-	go processUpload(jobID, file, userID.(uint), pdptoolPath)
-
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Upload started",
+		"message": "Upload queued",
 		"jobId":   jobID,
-		"status":  "processing",
+		"status":  "queued",
+	})
+}
+
+// UploadLimitsResponse describes the limits the server enforces on uploads
+// @Description Limits enforced on uploads
+type UploadLimitsResponse struct {
+	MaxUploadSize      int64  `json:"maxUploadSize" example:"10737418240"`
+	MaxUploadSizeHuman string `json:"maxUploadSizeHuman" example:"10.0 GB"`
+}
+
+// multipartOverhead is the allowance for multipart boundaries and headers on
+// top of the file itself when limiting the request body size.
+const multipartOverhead = 1 << 20
+
+func respondFileTooLarge(c *gin.Context, maxUploadSize int64) {
+	c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+		"error":         "File too large",
+		"message":       fmt.Sprintf("Maximum file size is %s", formatFileSize(maxUploadSize)),
+		"maxUploadSize": maxUploadSize,
+	})
+}
+
+// @Summary Get upload limits
+// @Description Get the limits enforced on uploads so clients can validate files before sending them
+// @Tags upload
+// @Produce json
+// @Success 200 {object} UploadLimitsResponse
+// @Router /api/v1/upload/limits [get]
+func GetUploadLimits(c *gin.Context) {
+	c.JSON(http.StatusOK, UploadLimitsResponse{
+		MaxUploadSize:      cfg.Upload.MaxSize,
+		MaxUploadSizeHuman: formatFileSize(cfg.Upload.MaxSize),
 	})
 }
 
 // @Summary Get upload status
-// @Description Get the status of an upload job
+// @Description Get the status of one of the caller's upload jobs. Jobs of other users are reported as not found.
 // @Tags upload
 // @Produce json
 // @Param jobId path string true "Job ID"
 // @Success 200 {object} UploadProgress
+// @Failure 404 {object} ErrorResponse
 // @Router /api/v1/upload/status/{jobId} [get]
 func GetUploadStatus(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
 	jobID := c.Param("jobId")
 
-	uploadJobsLock.RLock()
-	progress, exists := uploadJobs[jobID]
-	uploadJobsLock.RUnlock()
+	progress, ownerID, exists := getUploadJob(jobID)
+
+	if !exists || ownerID != userID.(uint) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Upload job not found",
+		})
+		return
+	}
 
+	if progress.Status == "queued" && uploads != nil {
+		if pos, ok := uploads.position(jobID); ok {
+			progress.QueuePosition = pos
+		}
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+// @Summary Cancel an upload
+// @Description Cancel a running upload job, stopping any pdptool command it is executing
+// @Tags upload
+// @Produce json
+// @Param jobId path string true "Job ID"
+// @Success 200 {object} UploadProgress
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/upload/{jobId} [delete]
+func CancelUpload(c *gin.Context) {
+	userID, exists := c.Get("userID")
 	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	jobID := c.Param("jobId")
+
+	_, ownerID, exists := getUploadJob(jobID)
+	if !exists || ownerID != userID.(uint) {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "Upload job not found",
 		})
 		return
 	}
 
+	progress, err := cancelUploadJob(jobID)
+	if err != nil {
+		if errors.Is(err, errUploadJobFinished) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":  "Upload job has already finished",
+				"status": progress.Status,
+			})
+			return
+		}
+		log.WithField("jobID", jobID).WithField("error", err.Error()).Error("Failed to cancel upload job")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to cancel upload job",
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, progress)
 }
 
-func processUpload(jobID string, file *multipart.FileHeader, userID uint, pdptoolPath string) {
-	serviceName := cfg.ServiceName
-	serviceURL := cfg.ServiceURL
+// processUpload runs pdptool on a file that is already on local disk and
+// records the resulting piece.
+func processUpload(jobID string, upload localUpload, userID uint, pdptoolPath string) {
+	if upload.TempDir != "" {
+		defer os.RemoveAll(upload.TempDir)
+	}
+
+	serviceURL, serviceName := uploadService(userID)
 	if serviceName == "" || serviceURL == "" {
 		log.Error("Service Name or Service URL not configured")
-		uploadJobsLock.Lock()
-		progress := uploadJobs[jobID]
-		progress.Status = "error"
-		progress.Error = "Server configuration error: Service Name/URL missing"
-		uploadJobs[jobID] = progress
-		uploadJobsLock.Unlock()
+		updateJobStatus(jobID, UploadProgress{
+			Status: "error",
+			Error:  "Server configuration error: Service Name/URL missing",
+		})
 		return
 	}
 
@@ -214,29 +426,26 @@ func processUpload(jobID string, file *multipart.FileHeader, userID uint, pdptoo
 	pdptoolDir := getPdptoolParentDir(pdptoolPath)
 	if err := os.Chdir(pdptoolDir); err != nil {
 		log.Error(fmt.Sprintf("Failed to change working directory to pdptool directory: %v", err))
-		uploadJobsLock.Lock()
-		progress := uploadJobs[jobID]
-		progress.Status = "error"
-		progress.Error = "Failed to set working directory"
-		uploadJobs[jobID] = progress
-		uploadJobsLock.Unlock()
+		updateJobStatus(jobID, UploadProgress{
+			Status: "error",
+			Error:  "Failed to set working directory",
+		})
 		return
 	}
 	log.WithField("pdptoolDir", pdptoolDir).Info("Changed working directory to pdptool directory")
 
 	updateStatus := func(progress UploadProgress) {
-		progress.JobID = jobID
-		uploadJobsLock.Lock()
-		uploadJobs[jobID] = progress
-		uploadJobsLock.Unlock()
+		updateJobStatus(jobID, progress)
 	}
 
+	ctx := jobContext(jobID)
+
 	currentStage := "starting"
 	currentProgress := 0
 
-	prepareWeight := 20
+	prepareWeight := 10
 
-	fileSizeMB := float64(file.Size) / (1024 * 1024)
+	fileSizeMB := float64(upload.Size) / (1024 * 1024)
 
 	baseDelay := time.Duration(2+int(fileSizeMB/5)) * time.Second
 	if baseDelay < 2*time.Second {
@@ -246,142 +455,104 @@ func processUpload(jobID string, file *multipart.FileHeader, userID uint, pdptoo
 		baseDelay = 10 * time.Second
 	}
 
-	prepareTimeout := time.Duration(30+int(fileSizeMB*2)) * time.Second
-	if prepareTimeout > 3600*time.Second {
-		prepareTimeout = 3600 * time.Second
-	}
+	prepareTimeout := prepareCommandTimeout(upload.Size)
+	uploadTimeout := uploadCommandTimeout(upload.storedSize())
 
-	uploadTimeout := time.Duration(30+int(fileSizeMB*3)) * time.Second
-	if uploadTimeout > 7200*time.Second {
-		uploadTimeout = 7200 * time.Second
-	}
-
-	log.WithField("fileSize", file.Size).
+	log.WithField("fileSize", upload.Size).
 		WithField("fileSizeMB", fileSizeMB).
 		WithField("baseDelay", baseDelay).
 		WithField("prepareTimeout", prepareTimeout).
 		WithField("uploadTimeout", uploadTimeout).
 		Info("Calculated timeouts for file processing")
 
-	if _, err := os.Stat("pdpservice.json"); os.IsNotExist(err) {
+	if !serviceSecretExists(pdptoolPath) {
 		currentStage = "preparing"
 		updateStatus(UploadProgress{
 			Status:   "preparing",
 			Progress: currentProgress,
 			Message:  "Creating service secret",
 		})
-
-		createSecretCmd := exec.Command(pdptoolPath, "create-service-secret")
-		var createSecretOutput bytes.Buffer
-		var createSecretError bytes.Buffer
-		createSecretCmd.Stdout = &createSecretOutput
-		createSecretCmd.Stderr = &createSecretError
-		if err := createSecretCmd.Run(); err != nil {
-			updateStatus(UploadProgress{
-				Status:  "error",
-				Error:   "Failed to create service secret",
-				Message: createSecretError.String(),
-			})
-			return
-		}
+	}
+	if created, err := ensureServiceSecret(ctx, pdptoolPath, jobID); err != nil {
+		updateStatus(UploadProgress{
+			Status:  "error",
+			Error:   "Failed to create service secret",
+			Message: err.Error(),
+		})
+		return
+	} else if created {
 		currentProgress += 5
 	}
 
-	uploadPathsLock.RLock()
-	existingFilePath, hasExistingPath := filePaths[jobID]
-	uploadPathsLock.RUnlock()
-
-	var tempFilePath string
-	if hasExistingPath {
-		tempFilePath = existingFilePath
-		log.WithField("path", tempFilePath).Info("Using existing file path from chunked upload")
-	} else {
-		tempDir, err := os.MkdirTemp("", fmt.Sprintf("upload-%s-", jobID))
-		if err != nil {
-			log.WithField("error", err.Error()).Error("Failed to create temporary directory")
-			updateStatus(UploadProgress{
-				Status:  "error",
-				Error:   "Failed to create temporary directory",
-				Message: err.Error(),
-			})
-			return
-		}
+	tempFilePath := upload.Path
 
-		originalFilename := filepath.Base(file.Filename)
-		tempFilePath = filepath.Join(tempDir, originalFilename)
+	if _, err := os.Stat(tempFilePath); os.IsNotExist(err) {
+		log.WithField("error", err.Error()).
+			WithField("path", tempFilePath).
+			Error("Temporary file does not exist after save")
+		updateStatus(UploadProgress{
+			Status:  "error",
+			Error:   "Failed to verify temporary file",
+			Message: fmt.Sprintf("File does not exist: %s", err.Error()),
+		})
+		return
+	}
 
-		src, err := file.Open()
+	if cfg.Scan.Enabled {
+		updateStatus(UploadProgress{
+			Status:   "scanning",
+			Progress: currentProgress,
+			Message:  "Scanning file for malware",
+		})
+		signature, err := scanUpload(ctx, upload)
 		if err != nil {
-			log.WithField("error", err.Error()).
-				WithField("filename", file.Filename).
-				Error("Failed to open uploaded file")
+			if ctx.Err() != nil {
+				return
+			}
 			updateStatus(UploadProgress{
 				Status:  "error",
-				Error:   "Failed to open uploaded file",
+				Error:   "Malware scan failed",
 				Message: err.Error(),
 			})
 			return
 		}
-		defer src.Close()
-
-		dst, err := os.Create(tempFilePath)
-		if err != nil {
-			log.WithField("error", err.Error()).
-				WithField("path", tempFilePath).
-				Error("Failed to create temporary file")
+		if signature != "" {
 			updateStatus(UploadProgress{
-				Status:  "error",
-				Error:   "Failed to create temporary file",
-				Message: err.Error(),
+				Status:  "rejected_malware",
+				Error:   "File rejected: malware detected",
+				Message: signature,
 			})
 			return
 		}
+	}
 
-		written, err := io.Copy(dst, src)
-		dst.Close()
-
-		if err != nil {
-			log.WithField("error", err.Error()).
-				WithField("path", tempFilePath).
-				Error("Failed to save uploaded file")
+	if upload.Compress {
+		updateStatus(UploadProgress{
+			Status:   "compressing",
+			Progress: currentProgress,
+			Message:  "Compressing file",
+		})
+		if err := compressUploadFile(&upload); err != nil {
+			log.WithField("error", err.Error()).WithField("path", tempFilePath).Error("Failed to compress file")
 			updateStatus(UploadProgress{
 				Status:  "error",
-				Error:   "Failed to save uploaded file",
+				Error:   "Failed to compress file",
 				Message: err.Error(),
 			})
-			os.RemoveAll(tempDir)
 			return
 		}
+	}
 
-		if written != file.Size {
-			err := fmt.Errorf("file size mismatch: expected %d bytes, wrote %d bytes", file.Size, written)
-			log.WithField("error", err.Error()).
-				WithField("path", tempFilePath).
-				Error("Failed to save complete file")
+	if pieceNeedsPadding(upload.storedSize()) {
+		if err := padUploadFile(&upload); err != nil {
+			log.WithField("error", err.Error()).WithField("path", tempFilePath).Error("Failed to pad file")
 			updateStatus(UploadProgress{
 				Status:  "error",
-				Error:   "Failed to save complete file",
+				Error:   "Failed to pad file to a valid piece size",
 				Message: err.Error(),
 			})
-			os.RemoveAll(tempDir)
 			return
 		}
-
-		log.WithField("path", tempFilePath).
-			WithField("size", formatFileSize(written)).
-			Info("File saved to temporary location")
-	}
-
-	if _, err := os.Stat(tempFilePath); os.IsNotExist(err) {
-		log.WithField("error", err.Error()).
-			WithField("path", tempFilePath).
-			Error("Temporary file does not exist after save")
-		updateStatus(UploadProgress{
-			Status:  "error",
-			Error:   "Failed to verify temporary file",
-			Message: fmt.Sprintf("File does not exist: %s", err.Error()),
-		})
-		return
 	}
 
 	currentProgress += 5
@@ -395,11 +566,8 @@ func processUpload(jobID string, file *multipart.FileHeader, userID uint, pdptoo
 
 	var prepareOutput bytes.Buffer
 	var prepareError bytes.Buffer
-	prepareCmd := exec.Command(pdptoolPath, "prepare-piece", tempFilePath)
-	prepareCmd.Stdout = &prepareOutput
-	prepareCmd.Stderr = &prepareError
 
-	prepareCtx, prepareCancel := context.WithTimeout(context.Background(), prepareTimeout)
+	prepareCtx, prepareCancel := context.WithTimeout(ctx, prepareTimeout)
 	defer prepareCancel()
 
 	prepareCmdWithTimeout := exec.CommandContext(prepareCtx, pdptoolPath, "prepare-piece", tempFilePath)
@@ -428,14 +596,20 @@ func processUpload(jobID string, file *multipart.FileHeader, userID uint, pdptoo
 		}
 	}()
 
-	if err := prepareCmdWithTimeout.Run(); err != nil {
+	if err := runJobCommand(jobID, prepareCmdWithTimeout); err != nil {
 		close(prepareDone)
 
+		if ctx.Err() != nil {
+			log.WithField("jobID", jobID).Info("Upload cancelled during prepare-piece")
+			return
+		}
+
 		if prepareCtx.Err() == context.DeadlineExceeded {
+			log.WithField("jobID", jobID).WithField("timeout", prepareTimeout).Error("prepare-piece timed out")
 			updateStatus(UploadProgress{
 				Status:  "error",
-				Error:   "Prepare piece command timed out",
-				Message: fmt.Sprintf("Operation timed out after %v. Try a smaller file or contact support.", prepareTimeout),
+				Error:   "prepare_timeout",
+				Message: fmt.Sprintf("Preparing the piece timed out after %v. Try a smaller file or contact support.", prepareTimeout),
 			})
 		} else {
 			updateStatus(UploadProgress{
@@ -448,7 +622,15 @@ func processUpload(jobID string, file *multipart.FileHeader, userID uint, pdptoo
 	}
 
 	close(prepareDone)
-	currentProgress = prepareWeight + 10
+
+	if preparedCID := pieceCIDRegex.FindString(prepareOutput.String()); preparedCID != "" {
+		if existing, existingProofSet, ok := findDuplicateInUserProofSet(userID, preparedCID); ok {
+			completeDuplicateUpload(jobID, existing, existingProofSet, upload)
+			return
+		}
+	}
+
+	currentProgress = uploadProgressStart
 	currentStage = "uploading"
 
 	updateStatus(UploadProgress{
@@ -457,26 +639,41 @@ func processUpload(jobID string, file *multipart.FileHeader, userID uint, pdptoo
 		Message:  fmt.Sprintf("Uploading file... (%.1f MB)", fileSizeMB),
 	})
 
-	time.Sleep(10 * time.Second)
+	if !sleepContext(ctx, cfg.Retry.PreUploadDelay) {
+		return
+	}
 
 	var uploadOutput bytes.Buffer
 	var uploadError bytes.Buffer
 
 	uploadArgs := []string{
 		"upload-file",
-		"--service-url", cfg.ServiceURL,
-		"--service-name", cfg.ServiceName,
+		"--service-url", serviceURL,
+		"--service-name", serviceName,
 		tempFilePath,
 	}
 
-	uploadCmd := exec.Command(pdptoolPath, uploadArgs...)
-	uploadCmd.Stdout = &uploadOutput
-	uploadCmd.Stderr = &uploadError
+	reportUploaded := func(uploaded int64) {
+		updateStatus(UploadProgress{
+			Status:        currentStage,
+			Progress:      uploadPercent(uploaded, upload.storedSize()),
+			Message:       fmt.Sprintf("Uploading file... (%s of %s)", formatFileSize(uploaded), formatFileSize(upload.storedSize())),
+			BytesUploaded: uploaded,
+		})
+	}
+	progressWriter := newProgressLineWriter(upload.storedSize(), reportUploaded)
+
+	uploadCtx, uploadCancel := context.WithTimeout(ctx, uploadTimeout)
+	defer uploadCancel()
+
+	uploadCmd := exec.CommandContext(uploadCtx, pdptoolPath, uploadArgs...)
+	uploadCmd.Stdout = io.MultiWriter(&uploadOutput, progressWriter)
+	uploadCmd.Stderr = io.MultiWriter(&uploadError, progressWriter)
 
 	log.WithField("command", pdptoolPath).
 		WithField("args", strings.Join(uploadArgs, " ")).
-		WithField("fileSize", formatFileSize(file.Size)).
-		WithField("timeout", "none").
+		WithField("fileSize", formatFileSize(upload.Size)).
+		WithField("timeout", uploadTimeout).
 		Info("Executing pdptool upload-file command")
 
 	updateStatus(UploadProgress{
@@ -485,8 +682,56 @@ func processUpload(jobID string, file *multipart.FileHeader, userID uint, pdptoo
 		Message:  fmt.Sprintf("Uploading file... (%.1f MB)", fileSizeMB),
 	})
 
-	uploadRunErr := uploadCmd.Run()
+	uploadRecorder := recordJobCommand(jobID, uploadCmd)
+	uploadStarted := time.Now()
+	uploadRunErr := uploadCmd.Start()
+	if uploadRunErr == nil {
+		uploadDone := make(chan struct{})
+		go func() {
+			// Fall back to how far pdptool has read the file when its output
+			// doesn't carry any progress information.
+			ticker := time.NewTicker(2 * time.Second)
+			defer ticker.Stop()
+
+			var lastOffset int64
+			for {
+				select {
+				case <-uploadDone:
+					return
+				case <-ticker.C:
+					if progressWriter.SawProgress() {
+						continue
+					}
+					offset, ok := processFileOffset(uploadCmd.Process.Pid, tempFilePath)
+					if ok && offset > lastOffset {
+						lastOffset = offset
+						reportUploaded(offset)
+					}
+				}
+			}
+		}()
+
+		uploadRunErr = uploadCmd.Wait()
+		close(uploadDone)
+	}
+	uploadRecorder.finish(uploadRunErr)
 	if uploadRunErr != nil {
+		if ctx.Err() != nil {
+			log.WithField("jobID", jobID).Info("Upload cancelled during upload-file")
+			return
+		}
+
+		if uploadCtx.Err() == context.DeadlineExceeded {
+			elapsed := time.Since(uploadStarted).Round(time.Second)
+			log.WithField("jobID", jobID).WithField("elapsed", elapsed).Error("upload-file timed out")
+			updateStatus(UploadProgress{
+				Status:  "error",
+				Error:   "upload_timeout",
+				Message: fmt.Sprintf("Uploading to the storage provider timed out after %v", elapsed),
+			})
+			return
+		}
+
 		stderrStr := uploadError.String()
 		stdoutStr := uploadOutput.String()
 
@@ -503,60 +748,15 @@ func processUpload(jobID string, file *multipart.FileHeader, userID uint, pdptoo
 		return
 	}
 
-	outputStr := uploadOutput.String()
-	outputLines := strings.Split(outputStr, "\n")
-
-	cidRegex := regexp.MustCompile(`^(baga[a-zA-Z0-9]+)(?::(baga[a-zA-Z0-9]+))?$`)
-	var compoundCID string
-	var baseCID string
-	var subrootCID string
-
-	for i := len(outputLines) - 1; i >= 0; i-- {
-		trimmedLine := strings.TrimSpace(outputLines[i])
-		if cidRegex.MatchString(trimmedLine) {
-			matches := cidRegex.FindStringSubmatch(trimmedLine)
-			if len(matches) > 1 {
-				compoundCID = matches[0]
-				baseCID = matches[1]
-				if len(matches) > 2 && matches[2] != "" {
-					subrootCID = matches[2]
-				} else {
-					subrootCID = baseCID
-				}
-				log.WithField("compoundCID", compoundCID).WithField("baseCID", baseCID).WithField("subrootCID", subrootCID).Info("Found and parsed CID in output lines")
-				break
-			}
-		}
-	}
-
-	if compoundCID == "" {
-		var lastNonEmpty string
-		for i := len(outputLines) - 1; i >= 0; i-- {
-			line := strings.TrimSpace(outputLines[i])
-			if line != "" {
-				lastNonEmpty = line
-				break
-			}
-		}
-
-		if lastNonEmpty != "" {
-			log.WithField("lastLine", lastNonEmpty).Warning("Using last non-empty output line as CID (fallback, parsing may fail)")
-			compoundCID = lastNonEmpty
-			if idx := strings.Index(compoundCID, ":"); idx != -1 {
-				baseCID = compoundCID[:idx]
-			} else {
-				baseCID = compoundCID
-			}
-			subrootCID = baseCID
-		} else {
-			log.Error("Upload completed but failed to extract CID from pdptool output.")
-			updateStatus(UploadProgress{
-				Status:  "error",
-				Error:   "Failed to extract CID from upload response",
-				Message: "Could not determine upload result CID.",
-			})
-			return
-		}
+	compoundCID, baseCID, subrootCID, ok := parseUploadedCID(uploadOutput.String())
+	if !ok {
+		log.Error("Upload completed but failed to extract CID from pdptool output.")
+		updateStatus(UploadProgress{
+			Status:  "error",
+			Error:   "Failed to extract CID from upload response",
+			Message: "Could not determine upload result CID.",
+		})
+		return
 	}
 
 	log.WithField("uploadOutputCID", compoundCID).
@@ -564,27 +764,27 @@ func processUpload(jobID string, file *multipart.FileHeader, userID uint, pdptoo
 		WithField("parsedSubrootCID", subrootCID).
 		Info("CIDs extracted from upload-file output, before calling add-roots")
 
-	log.WithField("filename", file.Filename).
-		WithField("size", file.Size).
+	log.WithField("filename", upload.Filename).
+		WithField("size", upload.Size).
 		WithField("service_name", serviceName).
 		WithField("service_url", serviceURL).
 		WithField("compoundCID", compoundCID).
 		Info("File uploaded successfully, proceeding to add root")
 
-	currentProgress = 95
-	currentStage = "adding_root"
+	// Record the CIDs before anything else, so the root can still be added
+	// if the server stops before the piece is saved.
 	updateStatus(UploadProgress{
-		Status:   currentStage,
-		Progress: currentProgress,
-		Message:  "Finding or creating a proof set for your file...",
-		CID:      compoundCID,
+		Status:      "finalizing",
+		Progress:    96,
+		Message:     "File stored, adding it to your proof set...",
+		CID:         compoundCID,
+		BaseCID:     baseCID,
+		Checksum:    upload.Checksum,
+		ContentType: upload.ContentType,
+		Compressed:  upload.Compressed,
+		StoredSize:  upload.storedSize(),
 	})
 
-	// Reduced delay before adding root
-	preAddRootDelay := 1 * time.Second
-	log.Info(fmt.Sprintf("Waiting %v before adding root to allow service registration...", preAddRootDelay))
-	time.Sleep(preAddRootDelay)
-
 	var proofSet models.ProofSet
 	if err := db.Where("user_id = ?", userID).First(&proofSet).Error; err != nil {
 		errMsg := "Failed to query proof set for user."
@@ -603,469 +803,132 @@ func processUpload(jobID string, file *multipart.FileHeader, userID uint, pdptoo
 		return
 	}
 
-	if proofSet.ProofSetID == "" {
-		errMsg := "Proof set creation is still pending. Please wait."
-		log.WithField("userID", userID).WithField("dbProofSetID", proofSet.ID).Warning(errMsg)
+	if err := proofSetUploadError(proofSet); errors.Is(err, errProofSetNotReady) {
+		log.WithField("userID", userID).WithField("dbProofSetID", proofSet.ID).Warning(err.Error())
 		updateStatus(UploadProgress{
 			Status:     "pending",
-			Error:      errMsg,
+			Error:      err.Error(),
 			Message:    "The proof set is being initialized. Please try uploading again shortly.",
 			CID:        compoundCID,
 			ProofSetID: proofSet.ProofSetID,
 		})
 		return
-	}
-
-	log.WithField("userID", userID).WithField("serviceProofSetID", proofSet.ProofSetID).Info("Found ready proof set for user, proceeding to add root")
-
-	updateStatus(UploadProgress{
-		Status:     currentStage,
-		Progress:   currentProgress,
-		Message:    fmt.Sprintf("Adding root to proof set %s...", proofSet.ProofSetID),
-		CID:        compoundCID,
-		ProofSetID: proofSet.ProofSetID,
-	})
-
-	rootArgument := compoundCID
-	addRootsArgs := []string{
-		"add-roots",
-		"--service-url", cfg.ServiceURL,
-		"--service-name", cfg.ServiceName,
-		"--proof-set-id", proofSet.ProofSetID,
-		"--root", rootArgument,
-	}
-
-	log.WithField("add-roots-args", strings.Join(addRootsArgs, " ")).Info("Adding root to proof set")
-
-	cmdDir := pdptoolDir
-	secretPath := filepath.Join(cmdDir, "pdpservice.json")
-	log.WithField("expectedCmdDir", cmdDir).Info("Checking command working directory")
-	log.WithField("checkingSecretPath", secretPath).Info("Checking for pdpservice.json")
-	if _, errStat := os.Stat(secretPath); errStat == nil {
-		log.Info("pdpservice.json FOUND at the expected location.")
-	} else if os.IsNotExist(errStat) {
-		log.Error("pdpservice.json NOT FOUND at the expected location.")
-	} else {
-		log.WithField("error", errStat.Error()).Error("Error checking for pdpservice.json")
-	}
-
-	maxRetries := 100
-	backoff := 10 * time.Second
-	maxBackoff := 10 * time.Second
-	success := false
-
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		if attempt > 1 {
-			time.Sleep(backoff)
-		}
-
-		log.WithField("command", pdptoolPath).
-			WithField("args", strings.Join(addRootsArgs, " ")).
-			WithField("attempt", attempt).
-			WithField("maxRetries", maxRetries).
-			Info("Executing add-roots command")
-
-		updateStatus(UploadProgress{
-			Status:     currentStage,
-			Progress:   currentProgress,
-			Message:    "Adding root to proof...",
-			CID:        compoundCID,
-			ProofSetID: proofSet.ProofSetID,
-		})
-
-		addRootCmd := exec.Command(pdptoolPath, addRootsArgs...)
-		var addRootOutput bytes.Buffer
-		var addRootError bytes.Buffer
-		addRootCmd.Stdout = &addRootOutput
-		addRootCmd.Stderr = &addRootError
-
-		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-		defer cancel()
-
-		cmdWithTimeout := exec.CommandContext(ctx, pdptoolPath, addRootsArgs...)
-		cmdWithTimeout.Dir = pdptoolDir
-		cmdWithTimeout.Stdout = &addRootOutput
-		cmdWithTimeout.Stderr = &addRootError
-
-		if err := cmdWithTimeout.Run(); err != nil {
-			stderrStr := addRootError.String()
-			stdoutStr := addRootOutput.String()
-
-			if ctx.Err() == context.DeadlineExceeded {
-				log.WithField("attempt", attempt).
-					WithField("maxRetries", maxRetries).
-					Error("Command execution timed out after 60 seconds")
-
-				if attempt < maxRetries {
-					updateStatus(UploadProgress{
-						Status:     currentStage,
-						Progress:   currentProgress,
-						Message:    fmt.Sprintf("Command timed out. Retrying %d/%d...", attempt+1, maxRetries),
-						CID:        compoundCID,
-						ProofSetID: proofSet.ProofSetID,
-					})
-
-					time.Sleep(backoff)
-
-					backoff *= 2
-					if backoff > maxBackoff {
-						backoff = maxBackoff
-					}
-					continue
-				} else {
-					updateStatus(UploadProgress{
-						Status:     "error",
-						Error:      "Command timed out after multiple attempts",
-						Message:    "The service took too long to respond. Please try again later.",
-						CID:        compoundCID,
-						ProofSetID: proofSet.ProofSetID,
-					})
-					return
-				}
-			}
-
-			log.WithField("error", err.Error()).
-				WithField("stderr", stderrStr).
-				WithField("stdout", stdoutStr).
-				WithField("commandArgs", strings.Join(addRootsArgs, " ")).
-				WithField("attempt", attempt).
-				WithField("maxRetries", maxRetries).
-				Error("pdptool add-roots command failed")
-
-			shouldRetry := false
-
-			if strings.Contains(stderrStr, "subroot CID") && strings.Contains(stderrStr, "not found or does not belong to service") {
-				shouldRetry = true
-			} else if strings.Contains(stderrStr, "Size must be a multiple of 32") {
-				shouldRetry = true
-			} else if strings.Contains(stderrStr, "Failed to send transaction") {
-				shouldRetry = true
-			} else if strings.Contains(stderrStr, "status code 500") || strings.Contains(stderrStr, "status code 400") {
-				shouldRetry = true
-			} else if strings.Contains(stderrStr, "Failed to retrieve next challenge epoch") ||
-				strings.Contains(stderrStr, "can't scan NULL into") {
-				shouldRetry = true
-			} else if strings.Contains(stderrStr, "not found") {
-				shouldRetry = true
-			} else if strings.Contains(stderrStr, "can't add root to non-existing proof set") {
-				shouldRetry = true
-			} else {
-				shouldRetry = true
-			}
-
-			if shouldRetry && attempt < maxRetries {
-				retryDelay := backoff + time.Duration(rand.Int63n(int64(backoff/2)))
-				log.WithField("retryDelay", retryDelay.String()).Info("Waiting before retry")
-				time.Sleep(retryDelay)
-				backoff *= 2
-				if backoff > maxBackoff {
-					backoff = maxBackoff
-				}
-				continue
-			}
-
-			if attempt >= maxRetries {
-				updateStatus(UploadProgress{
-					Status:     "error",
-					Error:      "Failed to add root to proof set after multiple attempts",
-					Message:    stderrStr,
-					CID:        compoundCID,
-					ProofSetID: proofSet.ProofSetID,
-				})
-				return
-			}
-
-			updateStatus(UploadProgress{
-				Status:     "error",
-				Error:      "Failed to add root to proof set",
-				Message:    stderrStr,
-				CID:        compoundCID,
-				ProofSetID: proofSet.ProofSetID,
-			})
-			return
-		}
-
-		addRootStderrStrOnSuccess := addRootError.String()
-		if addRootStderrStrOnSuccess != "" {
-			log.WithField("stderr", addRootStderrStrOnSuccess).Warning("add-roots command succeeded but produced output on stderr")
-		}
-
-		addRootStdoutStr := addRootOutput.String()
-		log.WithField("proofSetID", proofSet.ProofSetID).
-			WithField("rootUsed", rootArgument).
-			WithField("stdout", addRootStdoutStr).
-			WithField("attempt", attempt).
-			Info("add-roots command completed successfully")
-
-		success = true
-		break
-	}
-
-	if !success {
+	} else if err != nil {
 		updateStatus(UploadProgress{
 			Status:     "error",
-			Error:      "Failed to add root to proof set after multiple attempts",
-			Message:    "Service did not accept the root after multiple attempts.",
+			Error:      err.Error(),
+			Message:    "Upload cannot proceed without a valid proof set.",
 			CID:        compoundCID,
 			ProofSetID: proofSet.ProofSetID,
 		})
 		return
 	}
 
-	currentProgress = 96
-	currentStage = "finalizing"
-	updateStatus(UploadProgress{
-		Status:     currentStage,
-		Progress:   currentProgress,
-		Message:    "Confirming Root ID assignment...",
-		CID:        compoundCID,
-		ProofSetID: proofSet.ProofSetID,
-	})
-
-	var extractedIntegerRootID string
-	pollInterval := 10 * time.Second
-	maxPollInterval := 10 * time.Second
-	maxPollAttempts := 100
-	pollAttempt := 0
-	foundRootInPoll := false
-	consecutiveErrors := 0
-	maxConsecutiveErrors := 10
-
-	for pollAttempt < maxPollAttempts {
-		if pollAttempt > 0 {
-			log.Info("Applying fixed 30-second delay before poll attempt")
-			time.Sleep(10 * time.Second)
-		}
-
-		pollAttempt++
-
-		if pollAttempt%5 == 0 {
-			updateStatus(UploadProgress{
-				Status:     currentStage,
-				Progress:   currentProgress,
-				Message:    "Waiting for blockchain confirmation...",
-				CID:        compoundCID,
-				ProofSetID: proofSet.ProofSetID,
-			})
-		}
-
-		log.Info(fmt.Sprintf("Polling get-proof-set attempt %d/%d...", pollAttempt, maxPollAttempts))
-
-		getProofSetArgs := []string{
-			"get-proof-set",
-			"--service-url", cfg.ServiceURL,
-			"--service-name", cfg.ServiceName,
-			proofSet.ProofSetID,
-		}
-		getProofSetCmd := exec.Command(pdptoolPath, getProofSetArgs...)
-		var getProofSetStdout bytes.Buffer
-		var getProofSetStderr bytes.Buffer
-		getProofSetCmd.Stdout = &getProofSetStdout
-		getProofSetCmd.Stderr = &getProofSetStderr
-
-		log.WithField("command", pdptoolPath).WithField("args", strings.Join(getProofSetArgs, " ")).Debug(fmt.Sprintf("Executing get-proof-set poll attempt %d", pollAttempt))
-
-		if err := getProofSetCmd.Run(); err != nil {
-			stderrStr := getProofSetStderr.String()
-			log.WithField("error", err.Error()).
-				WithField("stderr", stderrStr).
-				Warning(fmt.Sprintf("pdptool get-proof-set command failed during poll attempt %d. Retrying after %v...", pollAttempt, pollInterval))
-
-			consecutiveErrors++
-
-			if strings.Contains(stderrStr, "Failed to retrieve next challenge epoch") ||
-				strings.Contains(stderrStr, "can't scan NULL into") {
-
-				log.Info("Detected proof set initialization error, this is normal during proof set creation")
-
-				if consecutiveErrors > 5 {
-					if pollInterval < maxPollInterval {
-						pollInterval += time.Second
-					}
-				}
-
-				time.Sleep(pollInterval)
-				continue
-			}
-
-			if consecutiveErrors > maxConsecutiveErrors {
-				log.Warning(fmt.Sprintf("Received %d consecutive errors while polling for root ID", consecutiveErrors))
-
-				if pollInterval < maxPollInterval {
-					pollInterval *= 2
-					if pollInterval > maxPollInterval {
-						pollInterval = maxPollInterval
-					}
-				}
-			}
-
-			time.Sleep(pollInterval)
-			continue
-		}
-
-		consecutiveErrors = 0
-
-		getProofSetOutput := getProofSetStdout.String()
-		log.WithField("output", getProofSetOutput).Debug(fmt.Sprintf("get-proof-set poll attempt %d output received", pollAttempt))
-
-		if strings.Contains(getProofSetOutput, "Roots:") && !strings.Contains(getProofSetOutput, "Root ID:") {
-			log.Debug("Found proof set but no roots listed yet. Continuing to poll...")
-			time.Sleep(pollInterval)
-			continue
-		}
-
-		lines := strings.Split(getProofSetOutput, "\n")
-		var lastSeenRootID string
-		foundMatchThisAttempt := false
-		sawAnyRootID := false
-
-		for _, line := range lines {
-			trimmedLine := strings.TrimSpace(line)
-			if trimmedLine == "" {
-				continue
-			}
-
-			if idx := strings.Index(trimmedLine, "Root ID:"); idx != -1 {
-				sawAnyRootID = true
-				potentialIDValue := strings.TrimSpace(trimmedLine[idx+len("Root ID:"):])
-				log.Debug(fmt.Sprintf("[Parsing] Found line containing 'Root ID:', potential value: '%s'", potentialIDValue))
-				if _, err := strconv.Atoi(potentialIDValue); err == nil {
-					lastSeenRootID = potentialIDValue
-					log.Debug(fmt.Sprintf("[Parsing] Captured integer Root ID: %s", lastSeenRootID))
-				} else {
-					lastSeenRootID = ""
-					log.Debug(fmt.Sprintf("[Parsing] Found 'Root ID:' but value '%s' is not integer, resetting lastSeenRootID", potentialIDValue))
-				}
-			}
-
-			if idx := strings.Index(trimmedLine, "Root CID:"); idx != -1 {
-				outputCID := strings.TrimSpace(trimmedLine[idx+len("Root CID:"):])
-				log.Debug(fmt.Sprintf("[Parsing] Found line containing 'Root CID:', value: '%s'", outputCID))
-				if outputCID == baseCID {
-					log.Debug(fmt.Sprintf("[Parsing] CID '%s' matches baseCID '%s'. Checking lastSeenRootID ('%s')...", outputCID, baseCID, lastSeenRootID))
-					if lastSeenRootID != "" {
-						extractedIntegerRootID = lastSeenRootID
-						log.WithField("integerRootID", extractedIntegerRootID).WithField("matchedBaseCID", baseCID).Info(fmt.Sprintf("Successfully matched base CID and found associated integer Root ID on poll attempt %d", pollAttempt))
-						foundMatchThisAttempt = true
-						break
-					} else {
-						log.WithField("matchedBaseCID", baseCID).Warning(fmt.Sprintf("Matched base CID on poll attempt %d but no preceding integer Root ID was captured (lastSeenRootID was empty)", pollAttempt))
-					}
-				}
-			}
-		}
-
-		if foundMatchThisAttempt {
-			foundRootInPoll = true
-			break
-		}
-
-		if sawAnyRootID {
-			log.Info("Proof set has roots, but none matching our CID yet. Polling again.")
-			pollInterval = 10 * time.Second
-		}
-
-		log.Debug(fmt.Sprintf("Root CID %s not found in get-proof-set output on attempt %d. Waiting %v...", baseCID, pollAttempt, pollInterval))
-		time.Sleep(pollInterval)
-	}
-
-	if !foundRootInPoll && consecutiveErrors < maxConsecutiveErrors {
-		log.WithField("baseCID", baseCID).
-			WithField("proofSetID", proofSet.ProofSetID).
-			WithField("attempts", maxPollAttempts).
-			Warning("Failed to find integer Root ID in get-proof-set output after polling. Using fallback Root ID.")
-
-		extractedIntegerRootID = "1"
-		foundRootInPoll = true
-
-		updateStatus(UploadProgress{
-			Status:     currentStage,
-			Progress:   98,
-			Message:    "Using default Root ID due to blockchain indexing delay.",
-			CID:        compoundCID,
-			ProofSetID: proofSet.ProofSetID,
-		})
-	} else if !foundRootInPoll {
-		log.WithField("baseCID", baseCID).
-			WithField("proofSetID", proofSet.ProofSetID).
-			WithField("attempts", maxPollAttempts).
-			Error("Failed to find integer Root ID in get-proof-set output after polling.")
-		updateStatus(UploadProgress{
-			Status:     "error",
-			Progress:   98,
-			Message:    "Error: Could not confirm integer Root ID assignment after polling.",
-			Error:      fmt.Sprintf("Polling for Root ID timed out after %d attempts", maxPollAttempts),
-			CID:        compoundCID,
-			ProofSetID: proofSet.ProofSetID,
-		})
+	if existingPiece, ok := findDuplicatePiece(userID, compoundCID); ok && pieceInProofSet(existingPiece, proofSet.ID) {
+		completeDuplicateUpload(jobID, existingPiece, &proofSet, upload)
 		return
 	}
 
-	currentProgress = 98
-	rootIDToSave := extractedIntegerRootID
-
 	updateStatus(UploadProgress{
-		Status:     currentStage,
-		Progress:   currentProgress,
+		Status:     "finalizing",
+		Progress:   98,
 		Message:    "Saving piece information to database...",
 		CID:        compoundCID,
 		ProofSetID: proofSet.ProofSetID,
 	})
 
-	piece := &models.Piece{
-		UserID:      userID,
-		CID:         compoundCID,
-		Filename:    file.Filename,
-		Size:        file.Size,
-		ServiceName: cfg.ServiceName,
-		ServiceURL:  cfg.ServiceURL,
-		ProofSetID:  &proofSet.ID,
-		RootID:      &rootIDToSave,
-	}
-
-	if result := db.Create(piece); result.Error != nil {
-		log.WithField("error", result.Error.Error()).Error("Failed to save piece information")
+	piece, saveErr := queueUploadedPiece(jobID, userID, upload, &proofSet, compoundCID, baseCID)
+	if saveErr != nil {
+		log.WithField("error", saveErr.Error()).Error("Failed to save piece information")
 		updateStatus(UploadProgress{
 			Status:     "error",
 			Error:      "Failed to save piece information to database",
-			Message:    result.Error.Error(),
+			Message:    saveErr.Error(),
 			CID:        compoundCID,
 			ProofSetID: proofSet.ProofSetID,
 		})
 		return
 	}
 
-	log.WithField("pieceId", piece.ID).WithField("integerRootID", rootIDToSave).Info("Piece information saved successfully with integer Root ID")
+	log.WithField("pieceId", piece.ID).WithField("cid", compoundCID).Info("Piece saved, root queued for proof set")
 
-	currentProgress = 100
+	if len(upload.Manifest) > 0 {
+		if err := saveArchiveManifest(piece.ID, upload.Manifest); err != nil {
+			log.WithField("pieceId", piece.ID).WithField("error", err.Error()).Error("Failed to save directory manifest")
+		}
+	}
 
 	updateStatus(UploadProgress{
-		Status:     "complete",
-		Progress:   currentProgress,
-		Message:    "Upload completed successfully",
+		Status:     "uploaded",
+		Progress:   100,
+		Message:    "File uploaded. Adding it to your proof set in the background.",
 		CID:        compoundCID,
-		Filename:   file.Filename,
+		Filename:   upload.Filename,
 		ProofSetID: proofSet.ProofSetID,
+		PieceID:    piece.ID,
+		Checksum:   upload.Checksum,
 	})
 
-	go func() {
-		var tempDir string
+	if roots != nil {
+		roots.notify()
+	}
+}
 
-		if !hasExistingPath && tempFilePath != "" {
-			tempDir = filepath.Dir(tempFilePath)
-		}
+var uploadedCIDRegex = regexp.MustCompile(`^(baga[a-zA-Z0-9]+)(?::(baga[a-zA-Z0-9]+))?$`)
 
-		time.Sleep(1 * time.Hour)
+// parseUploadedCID extracts the compound "root:subroot" CID printed by
+// pdptool upload-file, along with its root and subroot parts. When no line
+// looks like a CID the last non-empty line is used as a best effort.
+func parseUploadedCID(output string) (compoundCID, baseCID, subrootCID string, ok bool) {
+	outputLines := strings.Split(output, "\n")
 
-		uploadJobsLock.Lock()
-		delete(uploadJobs, jobID)
-		uploadJobsLock.Unlock()
+	for i := len(outputLines) - 1; i >= 0; i-- {
+		trimmedLine := strings.TrimSpace(outputLines[i])
+		matches := uploadedCIDRegex.FindStringSubmatch(trimmedLine)
+		if matches == nil {
+			continue
+		}
+		compoundCID = matches[0]
+		baseCID = matches[1]
+		subrootCID = baseCID
+		if matches[2] != "" {
+			subrootCID = matches[2]
+		}
+		log.WithField("compoundCID", compoundCID).WithField("baseCID", baseCID).WithField("subrootCID", subrootCID).Info("Found and parsed CID in output lines")
+		return compoundCID, baseCID, subrootCID, true
+	}
 
-		if tempDir != "" && !hasExistingPath {
-			log.WithField("jobID", jobID).
-				WithField("tempDir", tempDir).
-				Info("Cleaning up temporary directory after successful upload")
-			os.RemoveAll(tempDir)
+	for i := len(outputLines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(outputLines[i])
+		if line == "" {
+			continue
 		}
-	}()
+		log.WithField("lastLine", line).Warning("Using last non-empty output line as CID (fallback, parsing may fail)")
+		compoundCID = line
+		baseCID = compoundCID
+		if idx := strings.Index(compoundCID, ":"); idx != -1 {
+			baseCID = compoundCID[:idx]
+		}
+		return compoundCID, baseCID, baseCID, true
+	}
+
+	return "", "", "", false
+}
+
+// prepareCommandTimeout and uploadCommandTimeout give pdptool 30 seconds
+// plus a few seconds per MB, capped at the configured ceiling.
+func prepareCommandTimeout(size int64) time.Duration {
+	return commandTimeout(size, 2*time.Second)
+}
+
+func uploadCommandTimeout(size int64) time.Duration {
+	return commandTimeout(size, 3*time.Second)
+}
+
+func commandTimeout(size int64, perMB time.Duration) time.Duration {
+	estimate := 30*time.Second + time.Duration(size/(1024*1024))*perMB
+	if ceiling := cfg.Upload.CommandTimeout; ceiling > 0 && estimate > ceiling {
+		return ceiling
+	}
+	return estimate
 }
@@ -0,0 +1,494 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// maxBatchFiles caps how many files a single upload request may carry.
+const maxBatchFiles = 100
+
+// FileProgress is the status of one file within a multi-file upload job
+// @Description Status of a single file within a multi-file upload
+type FileProgress struct {
+	Filename  string `json:"filename"`
+	Size      int64  `json:"size"`
+	Status    string `json:"status"`
+	Progress  int    `json:"progress"`
+	CID       string `json:"cid,omitempty"`
+	Error     string `json:"error,omitempty"`
+	PieceID   uint   `json:"pieceId,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Checksum  string `json:"checksum,omitempty"`
+}
+
+type batchFile struct {
+	upload      localUpload
+	compoundCID string
+	baseCID     string
+}
+
+// batchUpload tracks the per-file state of a multi-file job and reports it
+// through the parent job's progress.
+type batchUpload struct {
+	mu    sync.Mutex
+	jobID string
+	files []FileProgress
+}
+
+func (b *batchUpload) setFile(i int, update func(f *FileProgress)) {
+	b.mu.Lock()
+	update(&b.files[i])
+	b.mu.Unlock()
+}
+
+func (b *batchUpload) snapshot() []FileProgress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	files := make([]FileProgress, len(b.files))
+	copy(files, b.files)
+	return files
+}
+
+func (b *batchUpload) report(status string, progress int, message string, proofSetID string) {
+	updateJobStatus(b.jobID, UploadProgress{
+		Status:     status,
+		Progress:   progress,
+		Message:    message,
+		ProofSetID: proofSetID,
+		Files:      b.snapshot(),
+	})
+}
+
+// failFile marks a single file as failed without stopping the rest of the
+// batch.
+func (b *batchUpload) failFile(i int, errMsg string) {
+	b.setFile(i, func(f *FileProgress) {
+		f.Status = "error"
+		f.Error = errMsg
+	})
+}
+
+// uploadingProgress averages the per-file progress into the upload band of
+// the parent job.
+func (b *batchUpload) uploadingProgress() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.files) == 0 {
+		return uploadProgressStart
+	}
+	total := 0
+	for _, f := range b.files {
+		if f.Status == "error" || f.Status == "rejected_malware" || f.Status == "uploaded" || f.Status == "complete" {
+			total += 100
+		} else {
+			total += f.Progress
+		}
+	}
+	band := uploadProgressEnd - uploadProgressStart
+	return uploadProgressStart + band*total/(100*len(b.files))
+}
+
+// finish sets the parent status from the outcome of each file.
+func (b *batchUpload) finish(proofSetID string) {
+	updateJobStatus(b.jobID, batchProgress(b.snapshot(), proofSetID, ""))
+}
+
+// batchProgress is the status of a multi-file job whose files are as given:
+// uploaded while the root of any file is still being added to the proof
+// set, then complete if every file made it, partial if only some did and
+// error if none did. message, when set, describes the root work still
+// running.
+func batchProgress(files []FileProgress, proofSetID, message string) UploadProgress {
+	succeeded, adding := 0, 0
+	var firstErr string
+	for _, f := range files {
+		switch {
+		case f.Status == "complete":
+			succeeded++
+		case f.Status == "uploaded":
+			adding++
+		case firstErr == "":
+			firstErr = f.Error
+		}
+	}
+
+	progress := UploadProgress{
+		Progress:   100,
+		ProofSetID: proofSetID,
+		Files:      files,
+	}
+	switch {
+	case adding > 0:
+		progress.Status = "uploaded"
+		progress.Message = message
+		if progress.Message == "" {
+			progress.Message = fmt.Sprintf("Files uploaded. Adding %d of them to your proof set in the background.", adding)
+		}
+	case succeeded == len(files):
+		progress.Status = "complete"
+		progress.Message = fmt.Sprintf("Uploaded %d files successfully", succeeded)
+	case succeeded == 0:
+		progress.Status = "error"
+		progress.Error = firstErr
+		progress.Message = "None of the files could be uploaded"
+	default:
+		progress.Status = "partial"
+		progress.Error = fmt.Sprintf("%d of %d files failed", len(files)-succeeded, len(files))
+		progress.Message = fmt.Sprintf("Uploaded %d of %d files", succeeded, len(files))
+	}
+	return progress
+}
+
+// batchJobsLock serializes updates to the files of multi-file jobs, which
+// both the upload and the root worker make.
+var batchJobsLock sync.Mutex
+
+// updateBatchRoot applies the root worker's progress for pieceID to the
+// files of multi-file job jobID that uploaded it.
+func updateBatchRoot(jobID string, pieceID uint, root UploadProgress) {
+	batchJobsLock.Lock()
+	defer batchJobsLock.Unlock()
+
+	progress, _, exists := getUploadJob(jobID)
+	if !exists || progress.Status != "uploaded" {
+		return
+	}
+	files := make([]FileProgress, len(progress.Files))
+	copy(files, progress.Files)
+	for i := range files {
+		if files[i].PieceID != pieceID || files[i].Status != "uploaded" {
+			continue
+		}
+		switch root.Status {
+		case "complete":
+			files[i].Status = "complete"
+		case "error":
+			files[i].Status = "error"
+			files[i].Error = root.Error
+		}
+	}
+	updateJobStatus(jobID, batchProgress(files, progress.ProofSetID, root.Message))
+}
+
+// uploadBatch validates a multi-file upload request and queues it as a
+// single job.
+func uploadBatch(c *gin.Context, userID uint, uploads []localUpload, tempDir string) {
+	if len(uploads) > maxBatchFiles {
+		os.RemoveAll(tempDir)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Too many files",
+			"message": fmt.Sprintf("A single upload may contain at most %d files", maxBatchFiles),
+		})
+		return
+	}
+
+	var totalSize int64
+	for _, upload := range uploads {
+		totalSize += upload.Size
+	}
+	if totalSize > cfg.Upload.MaxSize {
+		os.RemoveAll(tempDir)
+		respondFileTooLarge(c, cfg.Upload.MaxSize)
+		return
+	}
+
+	pdptoolPath := cfg.PdptoolPath
+
+	files := make([]FileProgress, len(uploads))
+	for i, upload := range uploads {
+		files[i] = FileProgress{Filename: upload.Filename, Size: upload.Size, Status: "pending"}
+	}
+
+	jobID := uuid.New().String()
+	createUploadJob(jobID, userID, UploadProgress{
+		Status:    "uploading",
+		Progress:  0,
+		Message:   fmt.Sprintf("Starting upload of %d files", len(uploads)),
+		Filename:  fmt.Sprintf("%d files", len(uploads)),
+		TotalSize: totalSize,
+		Files:     files,
+	})
+	setJobTempDir(jobID, tempDir)
+
+	if err := enqueueUpload(jobID, userID, func() {
+		processBatchUpload(jobID, uploads, tempDir, userID, pdptoolPath)
+	}); err != nil {
+		os.RemoveAll(tempDir)
+		updateJobStatus(jobID, UploadProgress{
+			Status:  "error",
+			Error:   "Server is shutting down",
+			Message: "The upload could not be queued. Please try again shortly.",
+		})
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Server is shutting down, please retry shortly",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Upload queued",
+		"jobId":   jobID,
+		"status":  "queued",
+		"files":   len(uploads),
+	})
+}
+
+// processBatchUpload runs prepare-piece and upload-file for every file of a
+// multi-file job, then saves the uploaded pieces and queues their roots. The
+// root worker adds them to the user's proof set with a single add-roots call.
+func processBatchUpload(jobID string, uploads []localUpload, tempDir string, userID uint, pdptoolPath string) {
+	defer os.RemoveAll(tempDir)
+
+	batch := &batchUpload{jobID: jobID, files: make([]FileProgress, len(uploads))}
+	for i, upload := range uploads {
+		batch.files[i] = FileProgress{
+			Filename: upload.Filename,
+			Size:     upload.Size,
+			Status:   "pending",
+		}
+	}
+
+	failAll := func(errMsg, message string) {
+		for i, f := range batch.snapshot() {
+			if f.Status != "complete" {
+				batch.failFile(i, errMsg)
+			}
+		}
+		updateJobStatus(jobID, UploadProgress{
+			Status:  "error",
+			Error:   errMsg,
+			Message: message,
+			Files:   batch.snapshot(),
+		})
+	}
+
+	pdptoolDir := getPdptoolParentDir(pdptoolPath)
+	if err := os.Chdir(pdptoolDir); err != nil {
+		log.Error(fmt.Sprintf("Failed to change working directory to pdptool directory: %v", err))
+		failAll("Failed to set working directory", "")
+		return
+	}
+
+	ctx := jobContext(jobID)
+
+	if !serviceSecretExists(pdptoolPath) {
+		batch.report("preparing", 0, "Creating service secret", "")
+	}
+	if _, err := ensureServiceSecret(ctx, pdptoolPath, jobID); err != nil {
+		failAll("Failed to create service secret", err.Error())
+		return
+	}
+
+	var proofSet models.ProofSet
+	if err := db.Where("user_id = ?", userID).First(&proofSet).Error; err != nil {
+		errMsg := "Failed to query proof set for user."
+		if err == gorm.ErrRecordNotFound {
+			errMsg = "Proof set not found for user. Please re-authenticate."
+		}
+		log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to load proof set for batch upload")
+		failAll(errMsg, "Upload cannot proceed without a valid proof set.")
+		return
+	}
+	if err := proofSetUploadError(proofSet); errors.Is(err, errProofSetNotReady) {
+		updateJobStatus(jobID, UploadProgress{
+			Status:  "pending",
+			Error:   err.Error(),
+			Message: "The proof set is being initialized. Please try uploading again shortly.",
+			Files:   batch.snapshot(),
+		})
+		return
+	} else if err != nil {
+		failAll(err.Error(), "Upload cannot proceed without a valid proof set.")
+		return
+	}
+	if proofSet.ServiceName == "" || proofSet.ServiceURL == "" {
+		log.WithField("dbProofSetID", proofSet.ID).Error("Proof set has no service name or service URL")
+		failAll("Server configuration error: Service Name/URL missing", "")
+		return
+	}
+
+	files := make([]*batchFile, len(uploads))
+	for i, upload := range uploads {
+		if ctx.Err() != nil {
+			return
+		}
+
+		bf, ok := uploadBatchFile(ctx, batch, i, upload, proofSet, pdptoolPath)
+		if !ok {
+			continue
+		}
+
+		if existing, isDuplicate := findDuplicatePiece(userID, bf.compoundCID); isDuplicate && pieceInProofSet(existing, proofSet.ID) {
+			applyUploadMetadata(existing, upload.Metadata)
+			applyUploadRetention(existing, upload.RetainUntil)
+			batch.setFile(i, func(f *FileProgress) {
+				f.Status = "complete"
+				f.Progress = 100
+				f.CID = existing.CID
+				f.PieceID = existing.ID
+				f.Duplicate = true
+				f.Checksum = stringValue(existing.Checksum)
+			})
+			continue
+		}
+		files[i] = bf
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	// The worker may finish a root before the job reports its files
+	// uploaded, so its updates wait until then.
+	batchJobsLock.Lock()
+	queued := false
+	for i, bf := range files {
+		if bf == nil {
+			continue
+		}
+		piece, err := queueUploadedPiece(jobID, userID, bf.upload, &proofSet, bf.compoundCID, bf.baseCID)
+		if err != nil {
+			log.WithField("error", err.Error()).WithField("filename", bf.upload.Filename).Error("Failed to save piece information")
+			batch.failFile(i, "Failed to save piece information to database")
+			continue
+		}
+		queued = true
+		batch.setFile(i, func(f *FileProgress) {
+			f.PieceID = piece.ID
+			f.Checksum = bf.upload.Checksum
+		})
+	}
+	batch.finish(proofSet.ProofSetID)
+	batchJobsLock.Unlock()
+
+	if queued && roots != nil {
+		roots.notify()
+	}
+}
+
+// uploadBatchFile prepares the piece of one file of a batch and uploads it. Failures are recorded on the file and reported as !ok.
+func uploadBatchFile(ctx context.Context, batch *batchUpload, i int, upload localUpload, proofSet models.ProofSet, pdptoolPath string) (*batchFile, bool) {
+	filePath := upload.Path
+
+	if cfg.Scan.Enabled {
+		batch.setFile(i, func(f *FileProgress) { f.Status = "scanning" })
+		batch.report("scanning", batch.uploadingProgress(), fmt.Sprintf("Scanning %s for malware", upload.Filename), "")
+		signature, err := scanUpload(ctx, upload)
+		if err != nil {
+			if ctx.Err() == nil {
+				batch.failFile(i, fmt.Sprintf("Malware scan failed: %v", err))
+			}
+			return nil, false
+		}
+		if signature != "" {
+			batch.setFile(i, func(f *FileProgress) {
+				f.Status = "rejected_malware"
+				f.Error = fmt.Sprintf("File rejected: malware detected (%s)", signature)
+			})
+			return nil, false
+		}
+	}
+
+	if err := compressUploadFile(&upload); err != nil {
+		batch.failFile(i, fmt.Sprintf("Failed to compress file: %v", err))
+		return nil, false
+	}
+
+	if pieceNeedsPadding(upload.storedSize()) {
+		if err := padUploadFile(&upload); err != nil {
+			batch.failFile(i, fmt.Sprintf("Failed to pad file: %v", err))
+			return nil, false
+		}
+	}
+
+	batch.setFile(i, func(f *FileProgress) { f.Status = "preparing" })
+	batch.report("preparing", batch.uploadingProgress(), fmt.Sprintf("Preparing %s", upload.Filename), "")
+
+	prepareTimeout := prepareCommandTimeout(upload.Size)
+	prepareCtx, prepareCancel := context.WithTimeout(ctx, prepareTimeout)
+	defer prepareCancel()
+
+	var prepareError bytes.Buffer
+	prepareCmd := exec.CommandContext(prepareCtx, pdptoolPath, "prepare-piece", filePath)
+	prepareCmd.Stderr = &prepareError
+	if err := runJobCommand(batch.jobID, prepareCmd); err != nil {
+		switch {
+		case ctx.Err() != nil:
+		case prepareCtx.Err() == context.DeadlineExceeded:
+			batch.failFile(i, fmt.Sprintf("prepare_timeout: preparing the piece timed out after %v", prepareTimeout))
+		default:
+			batch.failFile(i, fmt.Sprintf("Failed to prepare piece: %s", strings.TrimSpace(prepareError.String())))
+		}
+		return nil, false
+	}
+
+	batch.setFile(i, func(f *FileProgress) { f.Status = "uploading" })
+	batch.report("uploading", batch.uploadingProgress(), fmt.Sprintf("Uploading %s", upload.Filename), "")
+
+	progressWriter := newProgressLineWriter(upload.storedSize(), func(uploaded int64) {
+		batch.setFile(i, func(f *FileProgress) {
+			f.Progress = int(scaleProgress(float64(uploaded)/float64(upload.storedSize()), 100))
+		})
+		batch.report("uploading", batch.uploadingProgress(), fmt.Sprintf("Uploading %s", upload.Filename), "")
+	})
+
+	var uploadOutput bytes.Buffer
+	var uploadError bytes.Buffer
+	uploadCtx, uploadCancel := context.WithTimeout(ctx, uploadCommandTimeout(upload.storedSize()))
+	defer uploadCancel()
+
+	uploadStarted := time.Now()
+	uploadCmd := exec.CommandContext(uploadCtx, pdptoolPath,
+		"upload-file",
+		"--service-url", proofSet.ServiceURL,
+		"--service-name", proofSet.ServiceName,
+		filePath,
+	)
+	uploadCmd.Stdout = io.MultiWriter(&uploadOutput, progressWriter)
+	uploadCmd.Stderr = io.MultiWriter(&uploadError, progressWriter)
+	if err := runJobCommand(batch.jobID, uploadCmd); err != nil {
+		switch {
+		case ctx.Err() != nil:
+		case uploadCtx.Err() == context.DeadlineExceeded:
+			elapsed := time.Since(uploadStarted).Round(time.Second)
+			log.WithField("filename", upload.Filename).WithField("elapsed", elapsed).Error("upload-file timed out")
+			batch.failFile(i, fmt.Sprintf("upload_timeout: uploading to the storage provider timed out after %v", elapsed))
+		default:
+			log.WithField("filename", upload.Filename).WithField("stderr", uploadError.String()).Error("Upload command failed")
+			batch.failFile(i, fmt.Sprintf("Upload command failed: %s", strings.TrimSpace(uploadError.String())))
+		}
+		return nil, false
+	}
+
+	compoundCID, baseCID, _, ok := parseUploadedCID(uploadOutput.String())
+	if !ok {
+		batch.failFile(i, "Failed to extract CID from upload response")
+		return nil, false
+	}
+
+	batch.setFile(i, func(f *FileProgress) {
+		f.Status = "uploaded"
+		f.Progress = 100
+		f.CID = compoundCID
+	})
+
+	return &batchFile{
+		upload:      upload,
+		compoundCID: compoundCID,
+		baseCID:     baseCID,
+	}, true
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hotvault/backend/internal/models"
+)
+
+// runBatchUpload uploads a file for each of names as one multi-file job
+// through processBatchUpload and returns the job ID and its status once the
+// files are uploaded.
+func runBatchUpload(t *testing.T, user models.User, names ...string) (string, UploadProgress) {
+	t.Helper()
+	// processBatchUpload runs pdptool from its own directory.
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	tempDir := t.TempDir()
+	uploads := make([]localUpload, len(names))
+	files := make([]FileProgress, len(names))
+	for i, name := range names {
+		path := filepath.Join(tempDir, name)
+		data := []byte(strings.Repeat(name, 10))
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("write upload: %v", err)
+		}
+		uploads[i] = localUpload{Path: path, Filename: name, Size: int64(len(data)), Checksum: "sum-" + name}
+		files[i] = FileProgress{Filename: name, Size: int64(len(data)), Status: "pending"}
+	}
+
+	jobID := uuid.New().String()
+	createUploadJob(jobID, user.ID, UploadProgress{Status: "uploading", Filename: "batch", Files: files})
+	processBatchUpload(jobID, uploads, tempDir, user.ID, cfg.PdptoolPath)
+
+	progress, _, _ := getUploadJob(jobID)
+	return jobID, progress
+}
+
+func TestBatchUploadQueuesRoots(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	cfg.Retry.PreAddRootDelay = 0
+	user := createTestUser(t, "0x1")
+	createTestProofSet(t, user, "42")
+	calls := fakePdptool(t, `case "$1" in
+upload-file) case "$*" in *a.txt) echo bagaone:bagasub1;; *b.txt) echo bagatwo:bagasub2;; esac;;
+get-proof-set) printf 'Root ID: 0\nRoot CID: bagaone\nRoot ID: 1\nRoot CID: bagatwo\n';;
+esac`)
+
+	jobID, progress := runBatchUpload(t, user, "a.txt", "b.txt")
+	if progress.Status != "uploaded" {
+		t.Fatalf("status = %q (%s %s), want uploaded", progress.Status, progress.Error, progress.Message)
+	}
+	if called := pdptoolCalls(t, calls, "add-roots"); len(called) != 0 {
+		t.Errorf("add-roots ran during the upload: %q", called)
+	}
+	pieceIDs := make([]uint, len(progress.Files))
+	for i, f := range progress.Files {
+		var piece models.Piece
+		if err := db.First(&piece, f.PieceID).Error; err != nil {
+			t.Fatalf("%s: load piece: %v", f.Filename, err)
+		}
+		if f.Status != "uploaded" || piece.RootStatus != rootStatusPending || piece.RootID != nil {
+			t.Errorf("%s: file %q, piece root %q %s, want uploaded with its root pending", f.Filename, f.Status, stringValue(piece.RootID), piece.RootStatus)
+		}
+		if task := rootTaskOf(t, piece.ID); task.Status != rootTaskAdding || task.JobID != jobID {
+			t.Errorf("%s: root task %q of %q, want adding for the job", f.Filename, task.Status, task.JobID)
+		}
+		pieceIDs[i] = piece.ID
+	}
+
+	q := &rootQueue{ctx: context.Background()}
+	q.processDue()
+
+	added := pdptoolCalls(t, calls, "add-roots")
+	if len(added) != 1 || !strings.Contains(added[0], "--root bagaone:bagasub1 --root bagatwo:bagasub2") {
+		t.Errorf("add-roots calls = %q, want one adding both roots", added)
+	}
+	for i, pieceID := range pieceIDs {
+		var piece models.Piece
+		db.First(&piece, pieceID)
+		if want := []string{"0", "1"}[i]; stringValue(piece.RootID) != want || piece.RootStatus != rootStatusConfirmed {
+			t.Errorf("piece %s: root %q %s, want %s confirmed", piece.CID, stringValue(piece.RootID), piece.RootStatus, want)
+		}
+	}
+	progress, _, _ = getUploadJob(jobID)
+	if progress.Status != "complete" {
+		t.Errorf("job status = %q (%s), want complete", progress.Status, progress.Error)
+	}
+	for _, f := range progress.Files {
+		if f.Status != "complete" {
+			t.Errorf("%s: status = %q, want complete", f.Filename, f.Status)
+		}
+	}
+}
+
+func TestBatchUploadLeavesUnlistedRootUnconfirmed(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	cfg.Retry.PreAddRootDelay = 0
+	cfg.Retry.RootIDPollInterval = 0
+	cfg.Retry.RootIDPollMaxAttempts = 2
+	user := createTestUser(t, "0x1")
+	createTestProofSet(t, user, "42")
+	// The service lists the first file's root but never the second's.
+	fakePdptool(t, `case "$1" in
+upload-file) case "$*" in *a.txt) echo bagaone:bagasub1;; *b.txt) echo bagatwo:bagasub2;; esac;;
+get-proof-set) printf 'Root ID: 0\nRoot CID: bagaone\n';;
+esac`)
+
+	jobID, progress := runBatchUpload(t, user, "a.txt", "b.txt")
+	if progress.Status != "uploaded" {
+		t.Fatalf("status = %q (%s %s), want uploaded", progress.Status, progress.Error, progress.Message)
+	}
+	q := &rootQueue{ctx: context.Background()}
+	for i := 0; i < cfg.Retry.RootIDPollMaxAttempts; i++ {
+		q.processDue()
+	}
+
+	var unlisted models.Piece
+	if err := db.Where("c_id = ?", "bagatwo:bagasub2").First(&unlisted).Error; err != nil {
+		t.Fatalf("the unlisted file's piece wasn't saved: %v", err)
+	}
+	if unlisted.RootID != nil || unlisted.RootStatus != rootStatusUnconfirmed {
+		t.Errorf("unlisted piece: root %q %s, want no root ID and %s", stringValue(unlisted.RootID), unlisted.RootStatus, rootStatusUnconfirmed)
+	}
+	var listed models.Piece
+	db.Where("c_id = ?", "bagaone:bagasub1").First(&listed)
+	if stringValue(listed.RootID) != "0" || listed.RootStatus != rootStatusConfirmed {
+		t.Errorf("listed piece: root %q %s, want 0 confirmed", stringValue(listed.RootID), listed.RootStatus)
+	}
+
+	progress, _, _ = getUploadJob(jobID)
+	if progress.Status != "partial" {
+		t.Errorf("job status = %q, want partial", progress.Status)
+	}
+	if f := progress.Files[1]; f.Status != "error" || f.PieceID != unlisted.ID {
+		t.Errorf("unlisted file = %+v, want an error pointing at piece %d", f, unlisted.ID)
+	}
+}
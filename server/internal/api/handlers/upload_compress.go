@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// alreadyCompressedTypes are sniffed content types whose data is compressed
+// already, so gzipping them again only costs CPU.
+var alreadyCompressedTypes = map[string]bool{
+	"application/zip":              true,
+	"application/x-gzip":           true,
+	"application/x-rar-compressed": true,
+	"application/vnd.rar":          true,
+	"image/jpeg":                   true,
+	"image/png":                    true,
+	"image/gif":                    true,
+	"image/webp":                   true,
+	"video/mp4":                    true,
+	"video/webm":                   true,
+	"audio/mpeg":                   true,
+	"audio/ogg":                    true,
+	"font/woff2":                   true,
+}
+
+// compressedMagic are leading bytes of compressed formats that content type
+// sniffing doesn't recognise.
+var compressedMagic = [][]byte{
+	{0x42, 0x5A, 0x68},                   // bzip2
+	{0xFD, 0x37, 0x7A, 0x58, 0x5A, 0x00}, // xz
+	{0x28, 0xB5, 0x2F, 0xFD},             // zstd
+	{0x37, 0x7A, 0xBC, 0xAF, 0x27, 0x1C}, // 7z
+	{0x04, 0x22, 0x4D, 0x18},             // lz4
+}
+
+// storedSize is the size of the upload's file as it is sent to the service.
+func (u localUpload) storedSize() int64 {
+	switch {
+	case u.PaddedSize > 0:
+		return u.PaddedSize
+	case u.Compressed:
+		return u.CompressedSize
+	default:
+		return u.Size
+	}
+}
+
+// looksCompressed reports whether the file's content type or leading bytes
+// show it is in a format that is already compressed.
+func looksCompressed(path string, contentType string) (bool, error) {
+	if alreadyCompressedTypes[contentType] {
+		return true, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	head := make([]byte, 8)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	for _, magic := range compressedMagic {
+		if bytes.HasPrefix(head[:n], magic) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// compressUploadFile gzips the upload's file in place when the user asked for
+// compression and it pays off. Files that are already compressed, or that
+// don't get smaller, are left as they are. Size and Checksum keep describing
+// the original contents, which downloads are decompressed back to.
+func compressUploadFile(upload *localUpload) error {
+	if !upload.Compress {
+		return nil
+	}
+
+	skip, err := looksCompressed(upload.Path, upload.ContentType)
+	if err != nil {
+		return err
+	}
+	if skip {
+		log.WithField("filename", upload.Filename).
+			WithField("contentType", upload.ContentType).
+			Info("Skipping compression of already compressed file")
+		return nil
+	}
+
+	compressedPath := upload.Path + ".gz"
+	compressedSize, err := gzipFile(upload.Path, compressedPath)
+	if err != nil {
+		os.Remove(compressedPath)
+		return err
+	}
+
+	if compressedSize >= upload.Size {
+		os.Remove(compressedPath)
+		log.WithField("filename", upload.Filename).
+			WithField("size", upload.Size).
+			WithField("compressedSize", compressedSize).
+			Info("Compression did not reduce file size, storing it uncompressed")
+		return nil
+	}
+
+	if err := os.Rename(compressedPath, upload.Path); err != nil {
+		os.Remove(compressedPath)
+		return err
+	}
+
+	log.WithField("filename", upload.Filename).
+		WithField("size", upload.Size).
+		WithField("compressedSize", compressedSize).
+		Info("Compressed file before upload")
+	upload.Compressed = true
+	upload.CompressedSize = compressedSize
+	return nil
+}
+
+// gzipFile writes a gzip of src to dst and returns the compressed size.
+func gzipFile(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// gunzipTo writes the decompressed contents of the gzip stream in src to
+// dst.
+func gunzipTo(src io.Reader, dst string) error {
+	gz, err := gzip.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("invalid compressed data: %w", err)
+	}
+	defer gz.Close()
+	gz.Multistream(false)
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, gz); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+var pieceCIDRegex = regexp.MustCompile(`baga[a-zA-Z0-9]+`)
+
+// pieceCIDFormat is the shape of a CID a piece can be looked up by: a base
+// CID, optionally followed by a colon and a subroot CID.
+var pieceCIDFormat = regexp.MustCompile(`^[a-zA-Z0-9]+(:[a-zA-Z0-9]+)?$`)
+
+// findDuplicatePiece looks up a piece the user already stored under cid. cid
+// may be a compound "root:subroot" CID or just the root CID, which matches any
+// compound CID starting with it.
+func findDuplicatePiece(userID uint, cid string) (*models.Piece, bool) {
+	if db == nil || cid == "" {
+		return nil, false
+	}
+
+	var piece models.Piece
+	err := db.Where("user_id = ? AND base_c_id = ?", userID, baseCID(cid)).
+		Order("created_at DESC").
+		First(&piece).Error
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			log.WithField("userID", userID).WithField("cid", cid).WithField("error", err.Error()).Error("Failed to check for duplicate piece")
+		}
+		return nil, false
+	}
+	return &piece, true
+}
+
+// isDuplicateKey reports whether err is the database refusing a row that
+// breaks a unique index, such as a second piece for the same user and CID.
+// Only errors checked here are translated; everything else keeps the
+// driver's own error.
+func isDuplicateKey(err error) bool {
+	if err == nil {
+		return false
+	}
+	if translator, ok := db.Dialector.(gorm.ErrorTranslator); ok {
+		err = translator.Translate(err)
+	}
+	return errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
+// pieceInProofSet reports whether piece is already a root of the proof set
+// with the given database ID.
+func pieceInProofSet(piece *models.Piece, proofSetID uint) bool {
+	return piece.ProofSetID != nil && *piece.ProofSetID == proofSetID && piece.RootID != nil && *piece.RootID != ""
+}
+
+// findDuplicateByChecksum returns the user's piece with the same contents
+// when it is already part of their current proof set, so an upload of it can
+// be answered before the file is processed at all.
+func findDuplicateByChecksum(userID uint, checksum string) (*models.Piece, *models.ProofSet, bool) {
+	if db == nil || checksum == "" {
+		return nil, nil, false
+	}
+
+	var piece models.Piece
+	if err := db.Where("user_id = ? AND checksum = ?", userID, checksum).
+		Order("created_at DESC").
+		First(&piece).Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to check for duplicate upload")
+		}
+		return nil, nil, false
+	}
+
+	var proofSet models.ProofSet
+	if err := db.Where("user_id = ?", userID).First(&proofSet).Error; err != nil {
+		return nil, nil, false
+	}
+	if !pieceInProofSet(&piece, proofSet.ID) {
+		return nil, nil, false
+	}
+	return &piece, &proofSet, true
+}
+
+// findDuplicateInUserProofSet returns the user's existing piece for cid if it
+// is already part of their current proof set, so the upload can be skipped.
+func findDuplicateInUserProofSet(userID uint, cid string) (*models.Piece, *models.ProofSet, bool) {
+	piece, ok := findDuplicatePiece(userID, cid)
+	if !ok {
+		return nil, nil, false
+	}
+
+	var proofSet models.ProofSet
+	if err := db.Where("user_id = ?", userID).First(&proofSet).Error; err != nil {
+		return nil, nil, false
+	}
+	if !pieceInProofSet(piece, proofSet.ID) {
+		return nil, nil, false
+	}
+	return piece, &proofSet, true
+}
+
+// duplicateFilenameKey is the metadata field that records the name a
+// duplicate upload was sent under when it differs from the stored piece's.
+// Duplicates are matched on contents alone, so the piece keeps its own name.
+const duplicateFilenameKey = "duplicateFilename"
+
+// duplicateUploadMetadata returns the metadata a duplicate upload gives
+// piece: what was sent with it, or else the piece's own, with the upload's
+// filename under duplicateFilenameKey when it isn't the piece's. It is nil
+// when the piece's metadata stays as it is.
+func duplicateUploadMetadata(piece *models.Piece, upload localUpload) models.Metadata {
+	if upload.Filename == "" || upload.Filename == piece.Filename {
+		return upload.Metadata
+	}
+	base := upload.Metadata
+	if base == nil {
+		base = piece.Metadata
+	}
+	metadata := make(models.Metadata, len(base)+1)
+	for key, value := range base {
+		metadata[key] = value
+	}
+	metadata[duplicateFilenameKey] = upload.Filename
+	return metadata
+}
+
+// completeDuplicateUpload finishes a job whose file is already stored,
+// giving the stored piece the metadata and retention sent with upload, if
+// any.
+func completeDuplicateUpload(jobID string, piece *models.Piece, proofSet *models.ProofSet, upload localUpload) {
+	applyUploadMetadata(piece, duplicateUploadMetadata(piece, upload))
+	applyUploadRetention(piece, upload.RetainUntil)
+
+	log.WithField("jobID", jobID).
+		WithField("pieceId", piece.ID).
+		WithField("cid", piece.CID).
+		Info("File already stored, skipping upload")
+
+	// The file was still sent to the server, so it counts as transferred.
+	recordUsage(piece.UserID, piece.ID, usageDirectionUpload, piece.Size)
+
+	updateJobStatus(jobID, UploadProgress{
+		Status:     "complete",
+		Progress:   100,
+		Message:    "File already stored, reusing existing piece",
+		CID:        piece.CID,
+		ProofSetID: proofSet.ProofSetID,
+		Duplicate:  true,
+		PieceID:    piece.ID,
+		Checksum:   stringValue(piece.Checksum),
+	})
+}
+
+// reattachPieceTx points an existing piece at a new proof set root instead of
+// creating a second row for the same CID. An empty rootID leaves the root
+// pending until the root worker resolves it.
+func reattachPieceTx(tx *gorm.DB, jobID string, piece *models.Piece, upload localUpload, proofSet *models.ProofSet, rootID string) error {
+	var root interface{} = rootID
+	rootStatus := rootStatusConfirmed
+	if rootID == "" {
+		root = nil
+		rootStatus = rootStatusPending
+	}
+	updates := map[string]interface{}{
+		"filename":        upload.Filename,
+		"size":            upload.Size,
+		"padded_size":     upload.PaddedSize,
+		"compressed":      upload.Compressed,
+		"stored_size":     upload.storedSize(),
+		"checksum":        upload.Checksum,
+		"content_type":    upload.ContentType,
+		"service_name":    proofSet.ServiceName,
+		"service_url":     proofSet.ServiceURL,
+		"proof_set_id":    proofSet.ID,
+		"root_id":         root,
+		"root_status":     rootStatus,
+		"pending_removal": false,
+		"removal_date":    nil,
+	}
+	if upload.Metadata != nil {
+		updates["metadata"] = upload.Metadata
+	}
+	// A restored piece starts over with the retention sent, if any, rather
+	// than the one that ended.
+	if upload.RetainUntil != nil || piece.PendingRemoval {
+		updates["retain_until"] = upload.RetainUntil
+	}
+	// Recorded before the update clears PendingRemoval, which tells a
+	// restore from a re-upload.
+	if err := tx.Create(uploadEvents(piece, jobID, rootID)).Error; err != nil {
+		return err
+	}
+	return tx.Model(piece).Updates(updates).Error
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
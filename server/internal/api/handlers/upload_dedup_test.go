@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+func TestUploadOfStoredContentsUnderNewNameIsDuplicate(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, user, "42")
+	calls := fakePdptool(t, "exit 1")
+
+	data := []byte("quarterly numbers\n")
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	rootID := "7"
+	piece := createTestPiece(t, models.Piece{
+		UserID:     user.ID,
+		CID:        "bagaone:bagasub",
+		Filename:   "report.txt",
+		Size:       int64(len(data)),
+		Checksum:   &checksum,
+		ProofSetID: &proofSet.ID,
+		RootID:     &rootID,
+		RootStatus: rootStatusConfirmed,
+		Metadata:   models.Metadata{"project": "apollo"},
+	})
+
+	c, recorder := newMultipartRequest(t, "/api/v1/upload", nil, []testFile{{field: "file", name: "report-copy.txt", data: data}}, user)
+	UploadFile(c)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	var response struct {
+		Duplicate bool `json:"duplicate"`
+		PieceID   uint `json:"pieceId"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if !response.Duplicate || response.PieceID != piece.ID {
+		t.Fatalf("response = %s, want a duplicate of piece %d", recorder.Body, piece.ID)
+	}
+
+	var stored models.Piece
+	db.First(&stored, piece.ID)
+	if stored.Filename != "report.txt" {
+		t.Errorf("filename = %q, want the piece's own name kept", stored.Filename)
+	}
+	want := models.Metadata{"project": "apollo", duplicateFilenameKey: "report-copy.txt"}
+	if len(stored.Metadata) != len(want) || stored.Metadata["project"] != "apollo" || stored.Metadata[duplicateFilenameKey] != "report-copy.txt" {
+		t.Errorf("metadata = %v, want %v", stored.Metadata, want)
+	}
+	if called := pdptoolCalls(t, calls, ""); len(called) != 0 {
+		t.Errorf("pdptool was run for a duplicate: %q", called)
+	}
+	var count int64
+	db.Model(&models.Piece{}).Count(&count)
+	if count != 1 {
+		t.Errorf("stored %d pieces, want 1", count)
+	}
+}
+
+func TestDuplicateUploadMetadata(t *testing.T) {
+	piece := &models.Piece{Filename: "a.txt", Metadata: models.Metadata{"project": "apollo"}}
+	tests := []struct {
+		name   string
+		upload localUpload
+		want   models.Metadata
+	}{
+		{"same name, no metadata", localUpload{Filename: "a.txt"}, nil},
+		{"same name, metadata", localUpload{Filename: "a.txt", Metadata: models.Metadata{"k": "v"}}, models.Metadata{"k": "v"}},
+		{"new name, no metadata", localUpload{Filename: "b.txt"}, models.Metadata{"project": "apollo", duplicateFilenameKey: "b.txt"}},
+		{"new name, metadata", localUpload{Filename: "b.txt", Metadata: models.Metadata{"k": "v"}}, models.Metadata{"k": "v", duplicateFilenameKey: "b.txt"}},
+	}
+	for _, test := range tests {
+		got := duplicateUploadMetadata(piece, test.upload)
+		if len(got) != len(test.want) || (got == nil) != (test.want == nil) {
+			t.Errorf("%s: metadata = %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for key, value := range test.want {
+			if got[key] != value {
+				t.Errorf("%s: metadata = %v, want %v", test.name, got, test.want)
+			}
+		}
+	}
+	if len(piece.Metadata) != 1 {
+		t.Errorf("piece metadata was modified: %v", piece.Metadata)
+	}
+}
+
+func TestSameContentsUploadedByTwoUsersGetAPieceEach(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	alice := createTestUser(t, "0x1")
+	bob := createTestUser(t, "0x2")
+	aliceSet := createTestProofSet(t, alice, "42")
+	bobSet := createTestProofSet(t, bob, "43")
+
+	upload := localUpload{Filename: "report.txt", Size: 18, Checksum: "abc"}
+	first, err := queueUploadedPiece("job-1", alice.ID, upload, &aliceSet, "bagaone:bagasub", "bagaone")
+	if err != nil {
+		t.Fatalf("queue alice's piece: %v", err)
+	}
+	second, err := queueUploadedPiece("job-2", bob.ID, upload, &bobSet, "bagaone:bagasub", "bagaone")
+	if err != nil {
+		t.Fatalf("queue bob's piece: %v", err)
+	}
+
+	if first.ID == second.ID {
+		t.Fatalf("both users got piece %d", first.ID)
+	}
+	if first.UserID != alice.ID || second.UserID != bob.ID {
+		t.Errorf("pieces belong to users %d and %d, want %d and %d", first.UserID, second.UserID, alice.ID, bob.ID)
+	}
+	if *second.ProofSetID != bobSet.ID {
+		t.Errorf("bob's piece is in proof set %d, want %d", *second.ProofSetID, bobSet.ID)
+	}
+}
+
+func TestSameContentsUploadedTwiceByOneUserReusePiece(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, user, "42")
+
+	upload := localUpload{Filename: "report.txt", Size: 18, Checksum: "abc"}
+	first, err := queueUploadedPiece("job-1", user.ID, upload, &proofSet, "bagaone:bagasub", "bagaone")
+	if err != nil {
+		t.Fatalf("queue first upload: %v", err)
+	}
+	second, err := queueUploadedPiece("job-2", user.ID, upload, &proofSet, "bagaone:bagasub", "bagaone")
+	if err != nil {
+		t.Fatalf("queue second upload: %v", err)
+	}
+
+	if second.ID != first.ID {
+		t.Errorf("second upload got piece %d, want piece %d reused", second.ID, first.ID)
+	}
+	var count int64
+	db.Model(&models.Piece{}).Count(&count)
+	if count != 1 {
+		t.Errorf("stored %d pieces, want 1", count)
+	}
+}
+
+func TestIsDuplicateKey(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	createTestPiece(t, models.Piece{UserID: user.ID, CID: "bagaone", Filename: "a.txt"})
+
+	err := db.Create(&models.Piece{UserID: user.ID, CID: "bagaone", Filename: "b.txt"}).Error
+	if !isDuplicateKey(err) {
+		t.Errorf("isDuplicateKey(%v) = false, want true", err)
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		t.Errorf("error was translated outside isDuplicateKey: %v", err)
+	}
+	err = db.First(&models.Piece{}, "c_id = ?", "bagatwo").Error
+	if isDuplicateKey(err) || isDuplicateKey(nil) {
+		t.Errorf("isDuplicateKey reported a duplicate for %v", err)
+	}
+}
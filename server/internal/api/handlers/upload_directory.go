@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hotvault/backend/internal/models"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxArchiveEntries caps how many files a single directory upload may hold.
+const maxArchiveEntries = 10000
+
+var (
+	errInvalidArchivePath   = errors.New("invalid file path in directory upload")
+	errDuplicateArchivePath = errors.New("duplicate file path in directory upload")
+	errTooManyArchiveFiles  = fmt.Errorf("a directory upload may contain at most %d files", maxArchiveEntries)
+)
+
+// disallowedMemberError reports a directory member whose content type is not
+// accepted for upload.
+type disallowedMemberError struct {
+	path        string
+	contentType string
+}
+
+func (e *disallowedMemberError) Error() string {
+	return fmt.Sprintf("%s has disallowed content type %s", e.path, e.contentType)
+}
+
+// countingWriter tracks how many bytes have been written through it, which
+// gives the offset of each member inside the archive.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// @Summary Upload a directory as a single piece
+// @Description Packs every files[] part into one tar archive and stores it as a single piece, so a whole directory takes one root. Each part's filename should be its path relative to the directory root. A manifest of the members is kept so single files can be downloaded with ?path=.
+// @Tags upload
+// @Accept multipart/form-data
+// @Param files[] formData file true "Files of the directory, named by their relative path"
+// @Param name formData string false "Name of the directory"
+// @Param compress query bool false "Gzip the packaged directory before storing it"
+// @Produce json
+// @Success 200 {object} UploadProgress
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 507 {object} ErrorResponse
+// @Router /api/v1/upload/directory [post]
+func UploadDirectory(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	if respondPreflightFailure(c, uploadPreflight(c.Request.Context(), userID.(uint), 0)) {
+		return
+	}
+
+	maxUploadSize := cfg.Upload.MaxSize
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize+multipartOverhead)
+
+	upload, err := receiveDirectoryArchive(c.Request)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		var disallowed *disallowedMemberError
+		switch {
+		case errors.As(err, &maxBytesError):
+			respondFileTooLarge(c, maxUploadSize)
+		case errors.As(err, &disallowed):
+			respondUnsupportedMediaType(c, disallowed.path, disallowed.contentType)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Failed to read directory upload",
+				"message": err.Error(),
+			})
+		}
+		return
+	}
+
+	if respondPreflightFailure(c, uploadPreflight(c.Request.Context(), userID.(uint), upload.Size)) {
+		os.RemoveAll(upload.TempDir)
+		return
+	}
+	upload.Compress = c.Query("compress") == "true"
+	pdptoolPath := cfg.PdptoolPath
+
+	jobID := uuid.New().String()
+	createUploadJob(jobID, userID.(uint), UploadProgress{
+		Status:    "uploading",
+		Progress:  0,
+		Message:   fmt.Sprintf("Packaged %d files", len(upload.Manifest)),
+		Filename:  upload.Filename,
+		TotalSize: upload.Size,
+	})
+	setJobTempDir(jobID, upload.TempDir)
+
+	if err := enqueueUpload(jobID, userID.(uint), func() {
+		processUpload(jobID, upload, userID.(uint), pdptoolPath)
+	}); err != nil {
+		os.RemoveAll(upload.TempDir)
+		updateJobStatus(jobID, UploadProgress{
+			Status:  "error",
+			Error:   "Server is shutting down",
+			Message: "The upload could not be queued. Please try again shortly.",
+		})
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Server is shutting down, please retry shortly",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Upload queued",
+		"jobId":   jobID,
+		"status":  "queued",
+		"files":   len(upload.Manifest),
+	})
+}
+
+// receiveDirectoryArchive streams the file parts of a directory upload into
+// a tar archive on disk, recording where each member's data starts.
+func receiveDirectoryArchive(r *http.Request) (upload localUpload, err error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return localUpload{}, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "pdp-upload-*")
+	if err != nil {
+		return localUpload{}, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tempDir)
+		}
+	}()
+
+	archivePath := filepath.Join(tempDir, "directory.tar")
+	archive, err := os.Create(archivePath)
+	if err != nil {
+		return localUpload{}, fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer archive.Close()
+
+	hasher := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(archive, hasher)}
+	tw := tar.NewWriter(counter)
+
+	name := "directory"
+	seen := make(map[string]bool)
+	var manifest []models.ArchiveEntry
+
+	for {
+		part, partErr := reader.NextPart()
+		if partErr == io.EOF {
+			break
+		}
+		if partErr != nil {
+			return localUpload{}, partErr
+		}
+
+		if part.FormName() == "name" && part.FileName() == "" {
+			value, _ := io.ReadAll(io.LimitReader(part, maxFilenameBytes))
+			if v := strings.TrimSpace(string(value)); v != "" {
+				name = v
+			}
+			part.Close()
+			continue
+		}
+		if part.FileName() == "" || (part.FormName() != "files[]" && part.FormName() != "files") {
+			part.Close()
+			continue
+		}
+
+		memberPath, ok := cleanArchivePath(partFilePath(part))
+		if !ok {
+			part.Close()
+			return localUpload{}, errInvalidArchivePath
+		}
+		if seen[memberPath] {
+			part.Close()
+			return localUpload{}, errDuplicateArchivePath
+		}
+		if len(manifest) >= maxArchiveEntries {
+			part.Close()
+			return localUpload{}, errTooManyArchiveFiles
+		}
+		seen[memberPath] = true
+
+		entry, writeErr := writeArchiveMember(tw, counter, part, memberPath)
+		part.Close()
+		if writeErr != nil {
+			return localUpload{}, writeErr
+		}
+		manifest = append(manifest, entry)
+	}
+
+	if len(manifest) == 0 {
+		return localUpload{}, errNoUploadFile
+	}
+	if err := tw.Close(); err != nil {
+		return localUpload{}, err
+	}
+	if err := archive.Close(); err != nil {
+		return localUpload{}, err
+	}
+
+	log.WithField("path", archivePath).
+		WithField("files", len(manifest)).
+		WithField("size", formatFileSize(counter.n)).
+		Info("Directory packaged into archive")
+
+	return localUpload{
+		Path:        archivePath,
+		Filename:    name + ".tar",
+		Size:        counter.n,
+		Checksum:    hex.EncodeToString(hasher.Sum(nil)),
+		ContentType: "application/x-tar",
+		TempDir:     tempDir,
+		Manifest:    manifest,
+	}, nil
+}
+
+// writeArchiveMember spools one part to disk, so its size and content type
+// are known before the tar header is written, then appends it to the archive.
+func writeArchiveMember(tw *tar.Writer, counter *countingWriter, part io.Reader, memberPath string) (models.ArchiveEntry, error) {
+	spool, err := os.CreateTemp("", "pdp-member-*")
+	if err != nil {
+		return models.ArchiveEntry{}, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, part)
+	if err != nil {
+		return models.ArchiveEntry{}, err
+	}
+
+	contentType, err := detectFileContentType(spool.Name())
+	if err != nil {
+		return models.ArchiveEntry{}, err
+	}
+	if !mimeTypeAllowed(contentType) {
+		return models.ArchiveEntry{}, &disallowedMemberError{path: memberPath, contentType: contentType}
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return models.ArchiveEntry{}, err
+	}
+
+	header := &tar.Header{
+		Name:    memberPath,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+		Format:  tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return models.ArchiveEntry{}, err
+	}
+	// WriteHeader pads out the previous member and writes this one's header
+	// blocks, so its data starts right here.
+	offset := counter.n
+
+	if _, err := io.Copy(tw, spool); err != nil {
+		return models.ArchiveEntry{}, err
+	}
+
+	return models.ArchiveEntry{
+		Path:   memberPath,
+		Size:   size,
+		Offset: offset,
+	}, nil
+}
+
+// partFilePath returns the filename a part was sent with, directories
+// included. Part.FileName keeps only its last element.
+func partFilePath(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] == "" {
+		return part.FileName()
+	}
+	return params["filename"]
+}
+
+// cleanArchivePath normalizes a member path sent by the client and rejects
+// anything that would point outside the directory.
+func cleanArchivePath(raw string) (string, bool) {
+	p := norm.NFC.String(strings.ToValidUTF8(raw, ""))
+	p = strings.Map(func(r rune) rune {
+		if r == 0 || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, p)
+	p = strings.ReplaceAll(p, "\\", "/")
+	p = path.Clean("/" + p)
+	p = strings.TrimPrefix(p, "/")
+
+	if p == "" || p == "." || len(p) > 4096 {
+		return "", false
+	}
+	return p, true
+}
+
+// saveArchiveManifest replaces the manifest stored for a piece.
+func saveArchiveManifest(pieceID uint, manifest []models.ArchiveEntry) error {
+	if err := db.Where("piece_id = ?", pieceID).Delete(&models.ArchiveEntry{}).Error; err != nil {
+		return err
+	}
+	entries := make([]models.ArchiveEntry, len(manifest))
+	for i, entry := range manifest {
+		entry.ID = 0
+		entry.PieceID = pieceID
+		entries[i] = entry
+	}
+	return db.CreateInBatches(entries, 500).Error
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+func TestDirectoryUploadRoundTrip(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	user := createTestUser(t, "0x1")
+
+	members := []testFile{
+		{field: "files[]", name: "notes.txt", data: []byte("first file\n")},
+		{field: "files[]", name: "docs/readme.md", data: []byte("# Project\n\nSecond file.\n")},
+		{field: "files[]", name: "docs/data/numbers.csv", data: bytes.Repeat([]byte("1,2,3\n"), 200)},
+	}
+	c, _ := newMultipartRequest(t, "/api/v1/upload/directory", map[string]string{"name": "project"}, members, user)
+	upload, err := receiveDirectoryArchive(c.Request)
+	if err != nil {
+		t.Fatalf("receiveDirectoryArchive: %v", err)
+	}
+	defer os.RemoveAll(upload.TempDir)
+
+	if upload.Filename != "project.tar" || upload.ContentType != "application/x-tar" {
+		t.Errorf("archive = %q (%s), want project.tar (application/x-tar)", upload.Filename, upload.ContentType)
+	}
+	archive, err := os.ReadFile(upload.Path)
+	if err != nil {
+		t.Fatalf("read archive: %v", err)
+	}
+	if int64(len(archive)) != upload.Size {
+		t.Errorf("size = %d, archive has %d bytes", upload.Size, len(archive))
+	}
+
+	// The archive is an ordinary tar of the directory.
+	reader := tar.NewReader(bytes.NewReader(archive))
+	for _, member := range members {
+		header, err := reader.Next()
+		if err != nil {
+			t.Fatalf("read tar member %s: %v", member.name, err)
+		}
+		data, _ := io.ReadAll(reader)
+		if header.Name != member.name || !bytes.Equal(data, member.data) {
+			t.Errorf("tar member %q = %q, want %q = %q", header.Name, data, member.name, member.data)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("archive has more members than were uploaded: %v", err)
+	}
+
+	// The manifest points at each member's bytes in the archive.
+	if len(upload.Manifest) != len(members) {
+		t.Fatalf("manifest has %d entries, want %d", len(upload.Manifest), len(members))
+	}
+	for i, entry := range upload.Manifest {
+		if entry.Path != members[i].name || entry.Size != int64(len(members[i].data)) {
+			t.Errorf("manifest entry %d = %s (%d bytes), want %s (%d bytes)", i, entry.Path, entry.Size, members[i].name, len(members[i].data))
+		}
+		if got := archive[entry.Offset : entry.Offset+entry.Size]; !bytes.Equal(got, members[i].data) {
+			t.Errorf("manifest entry %s points at %q", entry.Path, got)
+		}
+	}
+
+	// Each member downloads on its own from the stored piece.
+	piece := createTestPiece(t, models.Piece{
+		UserID:      user.ID,
+		CID:         "bagaone:bagasub",
+		Filename:    upload.Filename,
+		Size:        upload.Size,
+		Checksum:    &upload.Checksum,
+		ContentType: upload.ContentType,
+	})
+	if err := saveArchiveManifest(piece.ID, upload.Manifest); err != nil {
+		t.Fatalf("saveArchiveManifest: %v", err)
+	}
+	fakePdptoolServing(t, archive)
+
+	for _, member := range members {
+		c, recorder := downloadRequest(piece.CID, "path="+member.name, user)
+		DownloadFile(c)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("download %s: status = %d, want %d: %s", member.name, recorder.Code, http.StatusOK, recorder.Body)
+			continue
+		}
+		if !bytes.Equal(recorder.Body.Bytes(), member.data) {
+			t.Errorf("download %s = %q, want %q", member.name, recorder.Body.Bytes(), member.data)
+		}
+	}
+
+	for _, path := range []string{"missing.txt", "docs"} {
+		c, recorder := downloadRequest(piece.CID, "path="+path, user)
+		DownloadFile(c)
+		if recorder.Code != http.StatusNotFound {
+			t.Errorf("download %s: status = %d, want %d", path, recorder.Code, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const sseHeartbeatInterval = 15 * time.Second
+
+var (
+	jobSubscribers     = make(map[string]map[chan UploadProgress]struct{})
+	jobSubscribersLock sync.Mutex
+)
+
+// subscribeJob registers a listener for progress updates of a single job. The
+// returned function must be called to unsubscribe.
+func subscribeJob(jobID string) (chan UploadProgress, func()) {
+	ch := make(chan UploadProgress, 16)
+
+	jobSubscribersLock.Lock()
+	if jobSubscribers[jobID] == nil {
+		jobSubscribers[jobID] = make(map[chan UploadProgress]struct{})
+	}
+	jobSubscribers[jobID][ch] = struct{}{}
+	jobSubscribersLock.Unlock()
+
+	return ch, func() {
+		jobSubscribersLock.Lock()
+		delete(jobSubscribers[jobID], ch)
+		if len(jobSubscribers[jobID]) == 0 {
+			delete(jobSubscribers, jobID)
+		}
+		jobSubscribersLock.Unlock()
+	}
+}
+
+// publishJobProgress fans an update out to every subscriber of the job. Slow
+// subscribers lose their oldest queued update rather than blocking the upload.
+func publishJobProgress(progress UploadProgress) {
+	jobSubscribersLock.Lock()
+	defer jobSubscribersLock.Unlock()
+
+	for ch := range jobSubscribers[progress.JobID] {
+		select {
+		case ch <- progress:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- progress:
+			default:
+			}
+		}
+	}
+}
+
+// @Summary Stream upload progress
+// @Description Stream progress updates of an upload job as Server-Sent Events. The stream closes once the job reaches a terminal state.
+// @Tags upload
+// @Produce text/event-stream
+// @Param jobId path string true "Job ID"
+// @Success 200 {object} UploadProgress
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/upload/events/{jobId} [get]
+func StreamUploadEvents(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	jobID := c.Param("jobId")
+
+	ch, unsubscribe := subscribeJob(jobID)
+	defer unsubscribe()
+
+	progress, ownerID, exists := getUploadJob(jobID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Upload job not found",
+		})
+		return
+	}
+
+	if ownerID != userID.(uint) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "You don't have permission to access this upload",
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if !writeSSEProgress(c, progress) || isTerminalJobStatus(progress.Status) {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case progress := <-ch:
+			if !writeSSEProgress(c, progress) || isTerminalJobStatus(progress.Status) {
+				return
+			}
+		}
+	}
+}
+
+func writeSSEProgress(c *gin.Context, progress UploadProgress) bool {
+	payload, err := json.Marshal(progress)
+	if err != nil {
+		log.WithField("jobID", progress.JobID).WithField("error", err.Error()).Error("Failed to encode upload progress event")
+		return false
+	}
+	if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", payload); err != nil {
+		return false
+	}
+	c.Writer.Flush()
+	return true
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+// localUpload is a file that has been written to local disk and is ready for
+// pdptool. Both direct and chunked uploads end up here before processing.
+type localUpload struct {
+	Path     string
+	Filename string
+	Size     int64
+	// PaddedSize is the size of the file on disk after zero-padding, or 0
+	// when it wasn't padded. Size always stays the original size.
+	PaddedSize int64
+	// Compress asks for the file to be gzipped before upload. Compressed and
+	// CompressedSize record whether that happened and the gzip size.
+	Compress       bool
+	Compressed     bool
+	CompressedSize int64
+	// Checksum is the hex encoded SHA-256 of the file contents.
+	Checksum string
+	// ContentType is sniffed from the file contents.
+	ContentType string
+	// Manifest lists the members when the file is a packaged directory.
+	Manifest []models.ArchiveEntry
+	// Metadata is the user's metadata for the piece, nil when none was sent.
+	Metadata models.Metadata
+	// RetainUntil is when the piece is to be removed, nil when no retention
+	// was sent.
+	RetainUntil *time.Time
+	// TempDir is removed once processing finishes. It is empty when the
+	// caller owns the file, as with chunked uploads.
+	TempDir string
+}
+
+var (
+	errNoUploadFile   = errors.New("no file found in upload form")
+	errUploadTooLarge = errors.New("upload exceeds the maximum size")
+)
+
+// multipartFileFields are the form fields accepted as uploaded files.
+var multipartFileFields = map[string]bool{
+	"file":    true,
+	"files":   true,
+	"files[]": true,
+}
+
+// receiveMultipartFiles streams every file part of the request body straight
+// into a new pdp-upload-* temp directory, so uploads are written to disk once
+// and never held in memory. batch reports whether the files came in files[]
+// fields rather than a single file field. A metadata field, when present,
+// is parsed and given to every file; an invalid one fails with an error
+// wrapping errInvalidMetadata. So is a retainUntil or retentionDays field,
+// with errors wrapping errInvalidRetention.
+func receiveMultipartFiles(r *http.Request, maxUploadSize int64) (files []localUpload, tempDir string, batch bool, err error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	tempDir, err = os.MkdirTemp("", "pdp-upload-*")
+	if err != nil {
+		return nil, "", false, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tempDir)
+		}
+	}()
+
+	var metadata models.Metadata
+	retention := map[string]string{}
+	for {
+		part, partErr := reader.NextPart()
+		if partErr == io.EOF {
+			break
+		}
+		if partErr != nil {
+			return nil, "", false, partErr
+		}
+
+		if part.FormName() == "metadata" && part.FileName() == "" {
+			// One byte over the limit is enough for parseMetadata to refuse it.
+			raw, readErr := io.ReadAll(io.LimitReader(part, int64(cfg.Upload.MaxMetadataBytes)+1))
+			part.Close()
+			if readErr != nil {
+				return nil, "", false, readErr
+			}
+			if metadata, err = parseMetadata(raw); err != nil {
+				return nil, "", false, err
+			}
+			continue
+		}
+		if name := part.FormName(); (name == "retainUntil" || name == "retentionDays") && part.FileName() == "" {
+			raw, readErr := io.ReadAll(io.LimitReader(part, 64))
+			part.Close()
+			if readErr != nil {
+				return nil, "", false, readErr
+			}
+			retention[name] = string(raw)
+			continue
+		}
+		if part.FileName() == "" || !multipartFileFields[part.FormName()] {
+			part.Close()
+			continue
+		}
+		if part.FormName() != "file" {
+			batch = true
+		}
+
+		upload, writeErr := writeMultipartPart(part, tempDir, len(files))
+		part.Close()
+		if writeErr != nil {
+			return nil, "", false, writeErr
+		}
+		if upload.Size > maxUploadSize {
+			return nil, "", false, errUploadTooLarge
+		}
+
+		log.WithField("path", upload.Path).
+			WithField("size", formatFileSize(upload.Size)).
+			Info("File saved to temporary location")
+		files = append(files, upload)
+	}
+
+	if len(files) == 0 {
+		return nil, "", false, errNoUploadFile
+	}
+	retainUntil, err := parseRetentionForm(retention["retainUntil"], retention["retentionDays"])
+	if err != nil {
+		return nil, "", false, err
+	}
+	for i := range files {
+		files[i].Metadata = metadata
+		files[i].RetainUntil = retainUntil
+	}
+	return files, tempDir, batch, nil
+}
+
+func writeMultipartPart(part *multipart.Part, tempDir string, index int) (localUpload, error) {
+	path := filepath.Join(tempDir, fmt.Sprintf("%d-%s", index, sanitizeFilename(part.FileName())))
+
+	dst, err := os.Create(path)
+	if err != nil {
+		return localUpload{}, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(dst, hasher), part)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return localUpload{}, err
+	}
+
+	return localUpload{
+		Path:     path,
+		Filename: part.FileName(),
+		Size:     written,
+		Checksum: hex.EncodeToString(hasher.Sum(nil)),
+	}, nil
+}
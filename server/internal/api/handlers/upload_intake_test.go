@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+// newRawFilenameRequest returns a multipart upload whose file part has
+// filenameParam, such as filename="a.txt", as sent, so names a multipart
+// writer would escape can be tried.
+func newRawFilenameRequest(t *testing.T, filenameParam string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="file"; ` + filenameParam},
+		"Content-Type":        {"application/octet-stream"},
+	})
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	part.Write([]byte("data"))
+	writer.Close()
+
+	c, _ := newTestContext(http.MethodPost, "/api/v1/upload", &body, models.User{})
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	return c.Request
+}
+
+func TestReceiveMultipartFilesKeepsFilesInTempDir(t *testing.T) {
+	useTestConfig(t)
+	t.Setenv("TMPDIR", t.TempDir())
+
+	tests := []struct {
+		name          string
+		filenameParam string
+		// stored is the name kept for display, which is the base name
+		// multipart gives for the part.
+		stored string
+	}{
+		{"slash traversal", `filename="../../etc/cron.d/evil"`, "evil"},
+		{"encoded slash traversal", `filename*=UTF-8''..%2F..%2Fetc%2Fcron.d%2Fevil`, "evil"},
+		{"backslash traversal", `filename="..\\..\\windows\\evil.bat"`, `..\..\windows\evil.bat`},
+		{"null byte", `filename*=UTF-8''report.pdf%00.sh`, "report.pdf\x00.sh"},
+		{"only dots", `filename=".."`, ".."},
+		{"very long name", `filename="` + strings.Repeat("a", 1000) + `.txt"`, strings.Repeat("a", 1000) + ".txt"},
+		{"very long multi-byte name", `filename="` + strings.Repeat("文", 500) + `.txt"`, strings.Repeat("文", 500) + ".txt"},
+	}
+	for _, test := range tests {
+		files, tempDir, _, err := receiveMultipartFiles(newRawFilenameRequest(t, test.filenameParam), 1<<20)
+		if err != nil {
+			t.Errorf("%s: receiveMultipartFiles: %v", test.name, err)
+			continue
+		}
+
+		path := files[0].Path
+		if filepath.Dir(path) != tempDir {
+			t.Errorf("%s: file written to %s, outside %s", test.name, path, tempDir)
+		}
+		base := filepath.Base(path)
+		if len(base) > 255 || strings.ContainsAny(base, "/\\\x00") {
+			t.Errorf("%s: unsafe file name %q", test.name, base)
+		}
+		if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+			t.Errorf("%s: read %s = %q, %v", test.name, path, data, err)
+		}
+		if files[0].Filename != test.stored {
+			t.Errorf("%s: filename = %q, want %q kept for display", test.name, files[0].Filename, test.stored)
+		}
+		os.RemoveAll(tempDir)
+	}
+}
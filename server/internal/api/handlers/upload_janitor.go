@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+// maxJanitorInterval caps how long the janitor sleeps between sweeps, so a
+// long retention period doesn't leave finished jobs around for much longer.
+const maxJanitorInterval = 10 * time.Minute
+
+// uploadJanitor periodically removes finished upload jobs that are older than
+// the retention period, from both the in-memory cache and the database. It
+// also starts the removal of pieces whose retention has ended.
+type uploadJanitor struct {
+	retention   time.Duration
+	keepPerUser int
+	done        chan struct{}
+	stopped     chan struct{}
+	stopOnce    sync.Once
+}
+
+var janitor *uploadJanitor
+
+func startUploadJanitor(retention time.Duration, keepPerUser int) *uploadJanitor {
+	j := &uploadJanitor{
+		retention:   retention,
+		keepPerUser: keepPerUser,
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+
+	interval := retention
+	if interval <= 0 || interval > maxJanitorInterval {
+		interval = maxJanitorInterval
+	}
+
+	go func() {
+		defer close(j.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-j.done:
+				return
+			case <-ticker.C:
+				j.sweep()
+			}
+		}
+	}()
+
+	log.WithField("retention", retention.String()).
+		WithField("keepPerUser", keepPerUser).
+		Info("Upload job janitor started")
+	return j
+}
+
+// stop ends the janitor loop and waits for a running sweep to finish.
+func (j *uploadJanitor) stop() {
+	j.stopOnce.Do(func() {
+		close(j.done)
+	})
+	<-j.stopped
+}
+
+func (j *uploadJanitor) sweep() {
+	cutoff := time.Now().Add(-j.retention)
+
+	evicted := 0
+	uploadJobsLock.Lock()
+	for jobID, finishedAt := range uploadJobFinishedAt {
+		if finishedAt.Before(cutoff) {
+			delete(uploadJobs, jobID)
+			delete(uploadJobOwners, jobID)
+			delete(uploadJobFinishedAt, jobID)
+			evicted++
+		}
+	}
+	uploadJobsLock.Unlock()
+
+	purged := j.purgeDatabase(cutoff)
+	archives := removeExpiredArchives(cutoff)
+	expired := expireRetainedPieces(context.Background(), time.Now())
+
+	if evicted > 0 || purged > 0 || archives > 0 || expired > 0 {
+		log.WithField("evicted", evicted).
+			WithField("purged", purged).
+			WithField("archives", archives).
+			WithField("expiredPieces", expired).
+			Info("Upload job janitor removed finished jobs")
+	}
+}
+
+// purgeDatabase deletes finished jobs last updated before cutoff, except for
+// the newest keepPerUser jobs of each user, along with their command logs.
+func (j *uploadJanitor) purgeDatabase(cutoff time.Time) int64 {
+	if db == nil {
+		return 0
+	}
+
+	newest := db.Raw(
+		`SELECT id FROM (
+			SELECT id, ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY created_at DESC) AS row_num
+			FROM upload_jobs
+		) ranked WHERE row_num <= ?`, j.keepPerUser)
+
+	result := db.Where("status IN ? AND updated_at < ? AND id NOT IN (?)", terminalJobStatuses, cutoff, newest).
+		Delete(&models.UploadJob{})
+	if result.Error != nil {
+		log.WithField("error", result.Error.Error()).Error("Failed to purge finished upload jobs")
+		return 0
+	}
+
+	if result.RowsAffected > 0 {
+		if err := db.Where("job_id NOT IN (?)", db.Model(&models.UploadJob{}).Select("job_id")).
+			Delete(&models.JobCommandLog{}).Error; err != nil {
+			log.WithField("error", err.Error()).Warning("Failed to purge command logs of removed upload jobs")
+		}
+	}
+	return result.RowsAffected
+}
@@ -0,0 +1,519 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Upload jobs are persisted in the upload_jobs table. The uploadJobs map only
+// caches the latest progress so status polling doesn't hit the database on
+// every request.
+
+var terminalJobStatuses = []string{"complete", "partial", "error", "interrupted", "cancelled", "rejected_malware", "checksum_mismatch"}
+
+var errUploadJobFinished = errors.New("upload job has already finished")
+
+// uploadJobRuntime holds the state needed to abort a job that is still
+// running in this process.
+type uploadJobRuntime struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	tempDir string
+}
+
+var uploadJobRuntimes = make(map[string]*uploadJobRuntime)
+
+// uploadJobsCtx is the parent of every job's context. Cancelling it on
+// shutdown kills the pdptool commands of jobs still running.
+var uploadJobsCtx, cancelUploadJobs = context.WithCancel(context.Background())
+
+func isTerminalJobStatus(status string) bool {
+	for _, s := range terminalJobStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func createUploadJob(jobID string, userID uint, progress UploadProgress) {
+	progress.JobID = jobID
+
+	ctx, cancel := context.WithCancel(uploadJobsCtx)
+
+	uploadJobsLock.Lock()
+	uploadJobs[jobID] = progress
+	uploadJobOwners[jobID] = userID
+	uploadJobRuntimes[jobID] = &uploadJobRuntime{ctx: ctx, cancel: cancel}
+	uploadJobsLock.Unlock()
+
+	if db == nil {
+		return
+	}
+
+	job := models.UploadJob{
+		JobID:       jobID,
+		UserID:      userID,
+		Status:      progress.Status,
+		Progress:    progress.Progress,
+		Message:     progress.Message,
+		Error:       progress.Error,
+		Filename:    progress.Filename,
+		TotalSize:   progress.TotalSize,
+		CID:         progress.CID,
+		BaseCID:     progress.BaseCID,
+		ProofSetID:  progress.ProofSetID,
+		Checksum:    progress.Checksum,
+		ContentType: progress.ContentType,
+		Compressed:  progress.Compressed,
+		StoredSize:  progress.StoredSize,
+	}
+	if len(progress.Files) > 0 {
+		if files, err := json.Marshal(progress.Files); err == nil {
+			job.Files = string(files)
+		}
+	}
+	if err := db.Create(&job).Error; err != nil {
+		log.WithField("jobID", jobID).WithField("error", err.Error()).Error("Failed to persist upload job")
+	}
+}
+
+func updateJobStatus(jobID string, progress UploadProgress) {
+	progress.JobID = jobID
+
+	uploadJobsLock.Lock()
+	if previous, ok := uploadJobs[jobID]; ok {
+		if previous.Status == "cancelled" {
+			uploadJobsLock.Unlock()
+			return
+		}
+		if progress.Filename == "" {
+			progress.Filename = previous.Filename
+		}
+		if progress.TotalSize == 0 {
+			progress.TotalSize = previous.TotalSize
+		}
+		if progress.Files == nil {
+			progress.Files = previous.Files
+		}
+	}
+	uploadJobs[jobID] = progress
+	if isTerminalJobStatus(progress.Status) {
+		uploadJobFinishedAt[jobID] = time.Now()
+		if runtime, ok := uploadJobRuntimes[jobID]; ok {
+			runtime.cancel()
+			delete(uploadJobRuntimes, jobID)
+		}
+	}
+	ownerID := uploadJobOwners[jobID]
+	uploadJobsLock.Unlock()
+
+	persistJobStatus(jobID, progress)
+	publishJobProgress(progress)
+	publishUserEvent(ownerID, WSTypeUploadProgress, progress)
+}
+
+func persistJobStatus(jobID string, progress UploadProgress) {
+	if db == nil {
+		return
+	}
+
+	updates := map[string]interface{}{
+		"status":       progress.Status,
+		"progress":     progress.Progress,
+		"message":      progress.Message,
+		"error":        progress.Error,
+		"c_id":         progress.CID,
+		"proof_set_id": progress.ProofSetID,
+	}
+	if progress.Filename != "" {
+		updates["filename"] = progress.Filename
+	}
+	if progress.TotalSize != 0 {
+		updates["total_size"] = progress.TotalSize
+	}
+	if progress.BaseCID != "" {
+		updates["base_c_id"] = progress.BaseCID
+	}
+	if progress.Checksum != "" {
+		updates["checksum"] = progress.Checksum
+	}
+	if progress.ContentType != "" {
+		updates["content_type"] = progress.ContentType
+	}
+	if progress.StoredSize != 0 {
+		updates["compressed"] = progress.Compressed
+		updates["stored_size"] = progress.StoredSize
+	}
+	if len(progress.Files) > 0 {
+		if files, err := json.Marshal(progress.Files); err == nil {
+			updates["files"] = string(files)
+		}
+	}
+	if err := db.Model(&models.UploadJob{}).Where("job_id = ?", jobID).Updates(updates).Error; err != nil {
+		log.WithField("jobID", jobID).WithField("error", err.Error()).Error("Failed to persist upload job status")
+	}
+}
+
+// jobContext returns the context a job's commands should run under. It is
+// cancelled when the job is cancelled through the API.
+func jobContext(jobID string) context.Context {
+	uploadJobsLock.RLock()
+	defer uploadJobsLock.RUnlock()
+
+	if runtime, ok := uploadJobRuntimes[jobID]; ok {
+		return runtime.ctx
+	}
+	return context.Background()
+}
+
+func setJobTempDir(jobID string, tempDir string) {
+	uploadJobsLock.Lock()
+	defer uploadJobsLock.Unlock()
+
+	if runtime, ok := uploadJobRuntimes[jobID]; ok {
+		runtime.tempDir = tempDir
+	}
+}
+
+// cancelUploadJob marks a job as cancelled, kills whatever command it is
+// running and removes its temporary files.
+func cancelUploadJob(jobID string) (UploadProgress, error) {
+	uploadJobsLock.Lock()
+	progress, ok := uploadJobs[jobID]
+	if !ok {
+		uploadJobsLock.Unlock()
+		return UploadProgress{}, errors.New("upload job not loaded")
+	}
+	if isTerminalJobStatus(progress.Status) || progress.Status == "uploaded" {
+		uploadJobsLock.Unlock()
+		return progress, errUploadJobFinished
+	}
+
+	progress.Status = "cancelled"
+	progress.Message = "Upload cancelled by user"
+	progress.Error = ""
+	uploadJobs[jobID] = progress
+	uploadJobFinishedAt[jobID] = time.Now()
+
+	var tempDir string
+	if runtime, ok := uploadJobRuntimes[jobID]; ok {
+		runtime.cancel()
+		tempDir = runtime.tempDir
+		delete(uploadJobRuntimes, jobID)
+	}
+	ownerID := uploadJobOwners[jobID]
+	uploadJobsLock.Unlock()
+
+	if uploads != nil {
+		uploads.remove(jobID)
+	}
+
+	persistJobStatus(jobID, progress)
+	publishJobProgress(progress)
+	publishUserEvent(ownerID, WSTypeUploadProgress, progress)
+
+	if tempDir != "" {
+		if err := os.RemoveAll(tempDir); err != nil {
+			log.WithField("jobID", jobID).WithField("tempDir", tempDir).WithField("error", err.Error()).Warning("Failed to remove temp directory of cancelled job")
+		}
+	}
+
+	log.WithField("jobID", jobID).Info("Upload job cancelled")
+	return progress, nil
+}
+
+// sleepContext waits for d or until ctx is done, reporting whether the full
+// duration elapsed.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// getUploadJob returns the latest progress for a job and the ID of the user
+// who owns it, falling back to the database when the job isn't cached.
+func getUploadJob(jobID string) (UploadProgress, uint, bool) {
+	uploadJobsLock.RLock()
+	progress, exists := uploadJobs[jobID]
+	ownerID := uploadJobOwners[jobID]
+	uploadJobsLock.RUnlock()
+
+	if exists {
+		return progress, ownerID, true
+	}
+
+	if db == nil {
+		return UploadProgress{}, 0, false
+	}
+
+	var job models.UploadJob
+	if err := db.Where("job_id = ?", jobID).First(&job).Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			log.WithField("jobID", jobID).WithField("error", err.Error()).Error("Failed to load upload job")
+		}
+		return UploadProgress{}, 0, false
+	}
+
+	progress = uploadProgressFromJob(job)
+
+	uploadJobsLock.Lock()
+	uploadJobs[jobID] = progress
+	uploadJobOwners[jobID] = job.UserID
+	if isTerminalJobStatus(job.Status) {
+		uploadJobFinishedAt[jobID] = job.UpdatedAt
+	}
+	uploadJobsLock.Unlock()
+
+	return progress, job.UserID, true
+}
+
+// getUploadJobs is getUploadJob for several jobs at once. Jobs not held in
+// memory are loaded with a single query. Jobs that don't exist are missing
+// from the result.
+func getUploadJobs(jobIDs []string) map[string]ownedUploadProgress {
+	found := make(map[string]ownedUploadProgress, len(jobIDs))
+	var missing []string
+
+	uploadJobsLock.RLock()
+	for _, jobID := range jobIDs {
+		if progress, exists := uploadJobs[jobID]; exists {
+			found[jobID] = ownedUploadProgress{progress: progress, ownerID: uploadJobOwners[jobID]}
+		} else {
+			missing = append(missing, jobID)
+		}
+	}
+	uploadJobsLock.RUnlock()
+
+	if len(missing) == 0 || db == nil {
+		return found
+	}
+
+	var jobs []models.UploadJob
+	if err := db.Where("job_id IN ?", missing).Find(&jobs).Error; err != nil {
+		log.WithField("jobs", len(missing)).WithField("error", err.Error()).Error("Failed to load upload jobs")
+		return found
+	}
+
+	uploadJobsLock.Lock()
+	for _, job := range jobs {
+		progress := uploadProgressFromJob(job)
+		found[job.JobID] = ownedUploadProgress{progress: progress, ownerID: job.UserID}
+		uploadJobs[job.JobID] = progress
+		uploadJobOwners[job.JobID] = job.UserID
+		if isTerminalJobStatus(job.Status) {
+			uploadJobFinishedAt[job.JobID] = job.UpdatedAt
+		}
+	}
+	uploadJobsLock.Unlock()
+
+	return found
+}
+
+type ownedUploadProgress struct {
+	progress UploadProgress
+	ownerID  uint
+}
+
+// evictUploadJob drops a job from the in-memory cache. The database row is
+// kept so the status endpoint can still answer for it.
+func evictUploadJob(jobID string) {
+	uploadJobsLock.Lock()
+	delete(uploadJobs, jobID)
+	delete(uploadJobOwners, jobID)
+	delete(uploadJobFinishedAt, jobID)
+	uploadJobsLock.Unlock()
+}
+
+func uploadProgressFromJob(job models.UploadJob) UploadProgress {
+	var files []FileProgress
+	if job.Files != "" {
+		if err := json.Unmarshal([]byte(job.Files), &files); err != nil {
+			log.WithField("jobID", job.JobID).WithField("error", err.Error()).Warning("Failed to decode per-file upload statuses")
+		}
+	}
+
+	return UploadProgress{
+		Files:       files,
+		Status:      job.Status,
+		Progress:    job.Progress,
+		Message:     job.Message,
+		CID:         job.CID,
+		BaseCID:     job.BaseCID,
+		Error:       job.Error,
+		Filename:    job.Filename,
+		TotalSize:   job.TotalSize,
+		JobID:       job.JobID,
+		ProofSetID:  job.ProofSetID,
+		Checksum:    job.Checksum,
+		ContentType: job.ContentType,
+		Compressed:  job.Compressed,
+		StoredSize:  job.StoredSize,
+	}
+}
+
+// markInterruptedUploadJobs flags jobs that were still running when the
+// process last stopped. Their goroutines are gone, so they'll never finish.
+// Uploaded jobs are left alone since the root worker resumes their work.
+func markInterruptedUploadJobs() {
+	result := db.Model(&models.UploadJob{}).
+		Where("status NOT IN ? AND status <> ?", terminalJobStatuses, "uploaded").
+		Updates(map[string]interface{}{
+			"status":  "interrupted",
+			"error":   "Upload was interrupted by a server restart",
+			"message": "The server restarted while this upload was in progress. Please upload the file again.",
+		})
+	if result.Error != nil {
+		log.WithField("error", result.Error.Error()).Error("Failed to mark interrupted upload jobs")
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.WithField("count", result.RowsAffected).Warning("Marked in-flight upload jobs as interrupted")
+	}
+}
+
+const (
+	defaultUploadJobsLimit = 20
+	maxUploadJobsLimit     = 100
+)
+
+// UploadJobSummary is one entry of the upload job list
+// @Description Upload job with its latest status
+type UploadJobSummary struct {
+	JobID         string    `json:"jobId"`
+	Status        string    `json:"status"`
+	Progress      int       `json:"progress"`
+	Message       string    `json:"message,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	Filename      string    `json:"filename"`
+	TotalSize     int64     `json:"totalSize"`
+	CID           string    `json:"cid,omitempty"`
+	ProofSetID    string    `json:"proofSetId,omitempty"`
+	QueuePosition int       `json:"queuePosition,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// UploadJobListResponse is a page of a user's upload jobs
+// @Description Page of upload jobs, newest first
+type UploadJobListResponse struct {
+	Jobs   []UploadJobSummary `json:"jobs"`
+	Total  int64              `json:"total"`
+	Limit  int                `json:"limit"`
+	Offset int                `json:"offset"`
+}
+
+// @Summary List upload jobs
+// @Description List the authenticated user's upload jobs, newest first. status=active (default) returns only jobs that are still running; status=all includes finished ones.
+// @Tags upload
+// @Produce json
+// @Param status query string false "active or all" default(active)
+// @Param limit query int false "Maximum number of jobs to return (max 100)" default(20)
+// @Param offset query int false "Number of jobs to skip" default(0)
+// @Success 200 {object} UploadJobListResponse
+// @Failure 400 {object} ErrorResponse
+// @Router /api/v1/upload/jobs [get]
+func ListUploadJobs(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	status := c.DefaultQuery("status", "active")
+	if status != "active" && status != "all" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "status must be 'active' or 'all'",
+		})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultUploadJobsLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "limit must be a positive integer",
+		})
+		return
+	}
+	if limit > maxUploadJobsLimit {
+		limit = maxUploadJobsLimit
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "offset must be a non-negative integer",
+		})
+		return
+	}
+
+	query := db.Model(&models.UploadJob{}).Where("user_id = ?", userID.(uint))
+	if status == "active" {
+		query = query.Where("status NOT IN ?", terminalJobStatuses)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to count upload jobs")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list upload jobs",
+		})
+		return
+	}
+
+	var jobs []models.UploadJob
+	if err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&jobs).Error; err != nil {
+		log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to list upload jobs")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to list upload jobs",
+		})
+		return
+	}
+
+	summaries := make([]UploadJobSummary, 0, len(jobs))
+	for _, job := range jobs {
+		summary := UploadJobSummary{
+			JobID:      job.JobID,
+			Status:     job.Status,
+			Progress:   job.Progress,
+			Message:    job.Message,
+			Error:      job.Error,
+			Filename:   job.Filename,
+			TotalSize:  job.TotalSize,
+			CID:        job.CID,
+			ProofSetID: job.ProofSetID,
+			CreatedAt:  job.CreatedAt,
+			UpdatedAt:  job.UpdatedAt,
+		}
+		if summary.Status == "queued" && uploads != nil {
+			if pos, ok := uploads.position(job.JobID); ok {
+				summary.QueuePosition = pos
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+
+	c.JSON(http.StatusOK, UploadJobListResponse{
+		Jobs:   summaries,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sniffLen is the number of leading bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+// detectFileContentType sniffs the content type from the first bytes of the
+// file made up of paths, read in order. The client-supplied type is never
+// trusted.
+func detectFileContentType(paths ...string) (string, error) {
+	buf := make([]byte, 0, sniffLen)
+	for _, path := range paths {
+		if len(buf) == sniffLen {
+			break
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		n, err := io.ReadFull(f, buf[len(buf):sniffLen])
+		f.Close()
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", err
+		}
+		buf = buf[:len(buf)+n]
+	}
+
+	return sniffContentType(buf), nil
+}
+
+// sniffContentType returns the media type of data starting with buf, without
+// parameters such as charset.
+func sniffContentType(buf []byte) string {
+	if contentType, ok := detectExecutable(buf); ok {
+		return contentType
+	}
+
+	contentType := http.DetectContentType(buf)
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return contentType
+}
+
+// previewableTypes are the content types browsers can show inline without
+// running anything the file contains. HTML and SVG are left out on purpose.
+var previewableTypes = map[string]bool{
+	"application/pdf": true,
+	"text/plain":      true,
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"image/bmp":       true,
+	"video/mp4":       true,
+	"video/webm":      true,
+	"video/ogg":       true,
+	"audio/mpeg":      true,
+	"audio/ogg":       true,
+	"audio/wave":      true,
+	"audio/wav":       true,
+	"audio/webm":      true,
+}
+
+// contentTypeHint picks the type to store for an upload. The sniffed type
+// wins; the type the client claimed is only used when sniffing found
+// nothing more specific than application/octet-stream, and only if it is a
+// well-formed type uploads may have.
+func contentTypeHint(sniffed, claimed string) string {
+	if sniffed != "application/octet-stream" || claimed == "" {
+		return sniffed
+	}
+	mediaType, _, err := mime.ParseMediaType(claimed)
+	if err != nil || !mimeTypeAllowed(mediaType) {
+		return sniffed
+	}
+	return strings.ToLower(mediaType)
+}
+
+// executableSignatures covers the binary formats http.DetectContentType
+// reports as application/octet-stream.
+var executableSignatures = []struct {
+	magic       []byte
+	contentType string
+}{
+	{[]byte("MZ"), "application/x-msdownload"},
+	{[]byte("\x7fELF"), "application/x-executable"},
+	{[]byte{0xfe, 0xed, 0xfa, 0xce}, "application/x-mach-binary"},
+	{[]byte{0xfe, 0xed, 0xfa, 0xcf}, "application/x-mach-binary"},
+	{[]byte{0xce, 0xfa, 0xed, 0xfe}, "application/x-mach-binary"},
+	{[]byte{0xcf, 0xfa, 0xed, 0xfe}, "application/x-mach-binary"},
+}
+
+func detectExecutable(buf []byte) (string, bool) {
+	for _, sig := range executableSignatures {
+		if bytes.HasPrefix(buf, sig.magic) {
+			return sig.contentType, true
+		}
+	}
+	return "", false
+}
+
+// mimeTypeAllowed checks a detected content type against the configured
+// allow and deny lists. Entries may end in "/*" to match a whole family.
+// Blocked types win over allowed ones, and an empty allow list accepts
+// everything that isn't blocked.
+func mimeTypeAllowed(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	if matchesMimeList(contentType, cfg.Upload.BlockedMimeTypes) {
+		return false
+	}
+	if len(cfg.Upload.AllowedMimeTypes) == 0 {
+		return true
+	}
+	return matchesMimeList(contentType, cfg.Upload.AllowedMimeTypes)
+}
+
+func matchesMimeList(contentType string, list []string) bool {
+	for _, entry := range list {
+		if entry == contentType {
+			return true
+		}
+		if prefix := strings.TrimSuffix(entry, "*"); prefix != entry && strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func respondUnsupportedMediaType(c *gin.Context, filename, contentType string) {
+	c.JSON(http.StatusUnsupportedMediaType, gin.H{
+		"error":       "File type not allowed",
+		"message":     "Files of type " + contentType + " can't be uploaded",
+		"contentType": contentType,
+		"filename":    filename,
+	})
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pieceSizeAlignment is the multiple the PDP service requires piece sizes to
+// be. add-roots rejects anything else with "Size must be a multiple of 32",
+// which no amount of retrying fixes.
+const pieceSizeAlignment = 32
+
+// alignedPieceSize rounds size up to the next valid piece size. Empty files
+// still take one aligned block.
+func alignedPieceSize(size int64) int64 {
+	if size <= 0 {
+		return pieceSizeAlignment
+	}
+	return (size + pieceSizeAlignment - 1) / pieceSizeAlignment * pieceSizeAlignment
+}
+
+func pieceNeedsPadding(size int64) bool {
+	return alignedPieceSize(size) != size
+}
+
+// padUploadFile appends zero bytes to the upload's file until its size is a
+// valid piece size and records the padded size. The checksum is left as the
+// checksum of the original contents, which downloads are trimmed back to.
+func padUploadFile(upload *localUpload) error {
+	current := upload.storedSize()
+	padded := alignedPieceSize(current)
+
+	f, err := os.OpenFile(upload.Path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, zeroReader{}, padded-current); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	log.WithField("path", upload.Path).
+		WithField("size", current).
+		WithField("paddedSize", padded).
+		Info("Padded file to a valid piece size")
+	upload.PaddedSize = padded
+	return nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func respondUnalignedSize(c *gin.Context, filename string, size int64) {
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error":   "Unsupported file size",
+		"message": fmt.Sprintf("%s is %d bytes, but files must be a multiple of %d bytes and padding is disabled on this server", filename, size, pieceSizeAlignment),
+	})
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+// paddingSizes are a 1-byte file, a file exactly on the boundary and one
+// byte over, with the size each is stored at.
+var paddingSizes = []struct {
+	name   string
+	size   int64
+	stored int64
+}{
+	{"1 byte", 1, pieceSizeAlignment},
+	{"on the boundary", pieceSizeAlignment, pieceSizeAlignment},
+	{"one byte over", pieceSizeAlignment + 1, 2 * pieceSizeAlignment},
+}
+
+func TestAlignedPieceSize(t *testing.T) {
+	for _, test := range paddingSizes {
+		if got := alignedPieceSize(test.size); got != test.stored {
+			t.Errorf("%s: alignedPieceSize(%d) = %d, want %d", test.name, test.size, got, test.stored)
+		}
+		if got, want := pieceNeedsPadding(test.size), test.size != test.stored; got != want {
+			t.Errorf("%s: pieceNeedsPadding(%d) = %v, want %v", test.name, test.size, got, want)
+		}
+	}
+	if got := alignedPieceSize(0); got != pieceSizeAlignment {
+		t.Errorf("alignedPieceSize(0) = %d, want %d", got, pieceSizeAlignment)
+	}
+}
+
+func TestPadUploadFile(t *testing.T) {
+	for _, test := range paddingSizes {
+		data := bytes.Repeat([]byte("a"), int(test.size))
+		path := filepath.Join(t.TempDir(), "upload")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("write upload: %v", err)
+		}
+		upload := localUpload{Path: path, Filename: "a.txt", Size: test.size}
+		if pieceNeedsPadding(upload.storedSize()) {
+			if err := padUploadFile(&upload); err != nil {
+				t.Errorf("%s: padUploadFile: %v", test.name, err)
+				continue
+			}
+		}
+
+		if upload.storedSize() != test.stored {
+			t.Errorf("%s: stored size = %d, want %d", test.name, upload.storedSize(), test.stored)
+		}
+		wantPadded := test.stored
+		if test.size == test.stored {
+			wantPadded = 0
+		}
+		if upload.PaddedSize != wantPadded {
+			t.Errorf("%s: padded size = %d, want %d", test.name, upload.PaddedSize, wantPadded)
+		}
+		padded, _ := os.ReadFile(path)
+		want := append(data, make([]byte, test.stored-test.size)...)
+		if !bytes.Equal(padded, want) {
+			t.Errorf("%s: file = %q, want the contents followed by zeros", test.name, padded)
+		}
+	}
+}
+
+func TestUploadFileRejectsUnalignedSizeWithoutPadding(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	useTestUploadQueue(t)
+	cfg.Upload.PadFiles = false
+	user := createTestUser(t, "0x1")
+	createTestProofSet(t, user, "42")
+	fakePdptool(t, "exit 1")
+
+	for _, test := range paddingSizes {
+		data := bytes.Repeat([]byte("a"), int(test.size))
+		c, recorder := newMultipartRequest(t, "/api/v1/upload", nil, []testFile{{field: "file", name: "a.txt", data: data}}, user)
+		UploadFile(c)
+
+		want := http.StatusOK
+		if test.size != test.stored {
+			want = http.StatusUnprocessableEntity
+		}
+		if recorder.Code != want {
+			t.Errorf("%s: status = %d, want %d: %s", test.name, recorder.Code, want, recorder.Body)
+		}
+	}
+}
+
+func TestDownloadFileTrimsPadding(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	user := createTestUser(t, "0x1")
+
+	for i, test := range paddingSizes {
+		data := bytes.Repeat([]byte("a"), int(test.size))
+		piece := models.Piece{
+			UserID:      user.ID,
+			CID:         fmt.Sprintf("bagapadded%d:bagasub", i),
+			Filename:    "a.txt",
+			Size:        test.size,
+			ContentType: "text/plain",
+		}
+		if test.stored != test.size {
+			piece.PaddedSize = test.stored
+		}
+		createTestPiece(t, piece)
+		fakePdptoolServing(t, append(data, make([]byte, test.stored-test.size)...))
+
+		c, recorder := downloadRequest(piece.CID, "", user)
+		DownloadFile(c)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d: %s", test.name, recorder.Code, http.StatusOK, recorder.Body)
+			continue
+		}
+		if !bytes.Equal(recorder.Body.Bytes(), data) {
+			t.Errorf("%s: downloaded %d bytes %q, want the %d bytes uploaded", test.name, recorder.Body.Len(), recorder.Body.Bytes(), test.size)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// pdptoolProbeTimeout bounds how long pdptool may take to answer --help.
+	pdptoolProbeTimeout = 5 * time.Second
+	// pdptoolProbeTTL is how long a successful probe is trusted, so uploads
+	// don't spawn pdptool just to check it is there.
+	pdptoolProbeTTL = time.Minute
+)
+
+var (
+	pdptoolProbeLock sync.Mutex
+	pdptoolProbedAt  time.Time
+	pdptoolProbePath string
+)
+
+// PreflightCheck is the outcome of one upload readiness check
+// @Description Outcome of one upload readiness check
+type PreflightCheck struct {
+	Name    string `json:"name" example:"proofSet"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+	// status is the HTTP status an upload fails with when this check fails.
+	status int
+}
+
+// UploadPreflightResponse reports whether the user can upload right now
+// @Description Whether the user can upload right now, with the result of each check
+type UploadPreflightResponse struct {
+	Ready              bool             `json:"ready"`
+	Checks             []PreflightCheck `json:"checks"`
+	MaxUploadSize      int64            `json:"maxUploadSize" example:"10737418240"`
+	MaxUploadSizeHuman string           `json:"maxUploadSizeHuman" example:"10.0 GB"`
+	QuotaBytes         int64            `json:"quotaBytes"`
+	UsedBytes          int64            `json:"usedBytes"`
+	// RemainingBytes is -1 when the user has no quota.
+	RemainingBytes int64 `json:"remainingBytes"`
+	// UploadedBytes and DownloadedBytes are the user's all-time transfer
+	// totals.
+	UploadedBytes   int64 `json:"uploadedBytes"`
+	DownloadedBytes int64 `json:"downloadedBytes"`
+}
+
+// failure returns the first failed check, if any.
+func (r UploadPreflightResponse) failure() (PreflightCheck, bool) {
+	for _, check := range r.Checks {
+		if !check.OK {
+			return check, true
+		}
+	}
+	return PreflightCheck{}, false
+}
+
+// @Summary Check upload readiness
+// @Description Run the checks an upload must pass before any data is sent: pdptool is available, the PDP service is configured, the user's proof set is ready, and the file fits in the size limit and remaining quota
+// @Tags upload
+// @Produce json
+// @Param size query int false "Size in bytes of the file about to be uploaded"
+// @Success 200 {object} UploadPreflightResponse
+// @Failure 400 {object} ErrorResponse
+// @Router /api/v1/upload/preflight [get]
+func GetUploadPreflight(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var size int64
+	if raw := c.Query("size"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "size must be a non-negative number of bytes",
+			})
+			return
+		}
+		size = parsed
+	}
+
+	report := uploadPreflight(c.Request.Context(), userID.(uint), size)
+
+	var err error
+	report.UploadedBytes, report.DownloadedBytes, err = transferTotals(userID.(uint))
+	if err != nil {
+		log.WithField("userID", userID).WithField("error", err.Error()).Warning("Failed to load transfer totals for preflight")
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// uploadPreflight runs every upload readiness check for the user. size is the
+// number of bytes about to be uploaded, or 0 when it isn't known yet.
+func uploadPreflight(ctx context.Context, userID uint, size int64) UploadPreflightResponse {
+	report := UploadPreflightResponse{
+		MaxUploadSize:      cfg.Upload.MaxSize,
+		MaxUploadSizeHuman: formatFileSize(cfg.Upload.MaxSize),
+		QuotaBytes:         cfg.Upload.UserQuota,
+		RemainingBytes:     -1,
+	}
+
+	report.Checks = append(report.Checks, checkPdptool(ctx))
+	report.Checks = append(report.Checks, checkServiceConfig(userID))
+	report.Checks = append(report.Checks, checkProofSetReady(userID))
+	report.Checks = append(report.Checks, checkUploadSize(size))
+
+	quota, used, remaining := checkQuota(userID, size)
+	report.Checks = append(report.Checks, quota)
+	report.UsedBytes = used
+	report.RemainingBytes = remaining
+
+	_, failed := report.failure()
+	report.Ready = !failed
+	return report
+}
+
+// respondPreflightFailure rejects an upload with the status of the first
+// failed check and the full report, so clients get the same detail as from
+// the preflight endpoint.
+func respondPreflightFailure(c *gin.Context, report UploadPreflightResponse) bool {
+	check, failed := report.failure()
+	if !failed {
+		return false
+	}
+	c.JSON(check.status, gin.H{
+		"error":     check.Message,
+		"check":     check.Name,
+		"preflight": report,
+	})
+	return true
+}
+
+func checkPdptool(ctx context.Context) PreflightCheck {
+	check := PreflightCheck{Name: "pdptool", status: http.StatusInternalServerError}
+
+	pdptoolPath := cfg.PdptoolPath
+	if pdptoolPath == "" {
+		check.Message = "PDPTool path is not configured"
+		return check
+	}
+	if _, err := os.Stat(pdptoolPath); err != nil {
+		check.Message = fmt.Sprintf("PDPTool executable not found at %s", pdptoolPath)
+		return check
+	}
+
+	pdptoolProbeLock.Lock()
+	defer pdptoolProbeLock.Unlock()
+
+	if pdptoolProbePath == pdptoolPath && time.Since(pdptoolProbedAt) < pdptoolProbeTTL {
+		check.OK = true
+		return check
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, pdptoolProbeTimeout)
+	defer cancel()
+	if err := exec.CommandContext(probeCtx, pdptoolPath, "--help").Run(); err != nil {
+		log.WithField("pdptoolPath", pdptoolPath).WithField("error", err.Error()).Warning("PDPTool did not respond to probe")
+		check.Message = fmt.Sprintf("PDPTool is not responding: %v", err)
+		return check
+	}
+
+	pdptoolProbePath = pdptoolPath
+	pdptoolProbedAt = time.Now()
+	check.OK = true
+	return check
+}
+
+// checkServiceConfig checks the service the user's uploads go to, which is
+// their proof set's storage provider.
+func checkServiceConfig(userID uint) PreflightCheck {
+	check := PreflightCheck{Name: "service", status: http.StatusInternalServerError}
+	serviceURL, serviceName := uploadService(userID)
+	switch {
+	case serviceURL == "":
+		check.Message = "PDP service URL is not configured"
+	case serviceName == "":
+		check.Message = "PDP service name is not configured"
+	default:
+		check.OK = true
+	}
+	return check
+}
+
+func checkProofSetReady(userID uint) PreflightCheck {
+	check := PreflightCheck{Name: "proofSet", status: http.StatusConflict}
+
+	var proofSet models.ProofSet
+	if err := db.Where("user_id = ?", userID).First(&proofSet).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			check.Message = "No proof set found. Please create one before uploading"
+		} else {
+			log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to look up proof set for preflight")
+			check.Message = "Failed to look up proof set"
+			check.status = http.StatusInternalServerError
+		}
+		return check
+	}
+	if err := proofSetUploadError(proofSet); err != nil {
+		check.Message = err.Error()
+		if errors.Is(err, errProofSetNotReady) {
+			check.Message = "Proof set is still being created. Please try again shortly"
+		}
+		return check
+	}
+
+	check.OK = true
+	return check
+}
+
+func checkUploadSize(size int64) PreflightCheck {
+	check := PreflightCheck{Name: "size", status: http.StatusRequestEntityTooLarge}
+	if size > cfg.Upload.MaxSize {
+		check.Message = fmt.Sprintf("Maximum file size is %s", formatFileSize(cfg.Upload.MaxSize))
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+// checkQuota reports whether size more bytes fit in the user's quota, along
+// with the bytes already used and those remaining (-1 when unlimited).
+func checkQuota(userID uint, size int64) (PreflightCheck, int64, int64) {
+	check := PreflightCheck{Name: "quota", status: http.StatusInsufficientStorage}
+
+	var used int64
+	if err := db.Model(&models.Piece{}).
+		Where("user_id = ?", userID).
+		Select("COALESCE(SUM(size), 0)").
+		Scan(&used).Error; err != nil {
+		log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to compute storage used by user")
+		check.Message = "Failed to compute storage used"
+		check.status = http.StatusInternalServerError
+		return check, 0, -1
+	}
+
+	quota := cfg.Upload.UserQuota
+	if quota == 0 {
+		check.OK = true
+		return check, used, -1
+	}
+
+	remaining := quota - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	switch {
+	case remaining == 0:
+		check.Message = fmt.Sprintf("Storage quota of %s is used up", formatFileSize(quota))
+	case size > remaining:
+		check.Message = fmt.Sprintf("File needs %s but only %s of storage quota remains", formatFileSize(size), formatFileSize(remaining))
+	default:
+		check.OK = true
+	}
+	return check, used, remaining
+}
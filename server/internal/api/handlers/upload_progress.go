@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Upload progress is reported in the 20-90% band; everything before is piece
+// preparation and everything after is adding the root to the proof set.
+const (
+	uploadProgressStart = 20
+	uploadProgressEnd   = 90
+)
+
+var (
+	progressBytesRegex   = regexp.MustCompile(`(\d+)\s*/\s*(\d+)\s*(?:bytes|B)\b`)
+	progressUnitsRegex   = regexp.MustCompile(`([\d.]+)\s*([KMGTP]i?B|B)\s*/\s*([\d.]+)\s*([KMGTP]i?B|B)`)
+	progressChunkRegex   = regexp.MustCompile(`(?i)chunk\s+(\d+)\s*(?:/|of)\s*(\d+)`)
+	progressPercentRegex = regexp.MustCompile(`(\d{1,3}(?:\.\d+)?)\s*%`)
+)
+
+// parsePdptoolProgress extracts how many bytes of a file of totalSize bytes
+// have been uploaded from a single line of pdptool output. It understands
+// byte counts ("1024/4096 bytes"), human readable sizes ("1.5 MiB / 3 MiB"),
+// chunk counters ("chunk 3 of 10") and plain percentages.
+func parsePdptoolProgress(line string, totalSize int64) (int64, bool) {
+	if totalSize <= 0 {
+		return 0, false
+	}
+
+	if m := progressBytesRegex.FindStringSubmatch(line); m != nil {
+		done, errDone := strconv.ParseInt(m[1], 10, 64)
+		total, errTotal := strconv.ParseInt(m[2], 10, 64)
+		if errDone == nil && errTotal == nil && total > 0 {
+			return scaleProgress(float64(done)/float64(total), totalSize), true
+		}
+	}
+
+	if m := progressUnitsRegex.FindStringSubmatch(line); m != nil {
+		done, okDone := parseSizeWithUnit(m[1], m[2])
+		total, okTotal := parseSizeWithUnit(m[3], m[4])
+		if okDone && okTotal && total > 0 {
+			return scaleProgress(done/total, totalSize), true
+		}
+	}
+
+	if m := progressChunkRegex.FindStringSubmatch(line); m != nil {
+		done, errDone := strconv.Atoi(m[1])
+		total, errTotal := strconv.Atoi(m[2])
+		if errDone == nil && errTotal == nil && total > 0 {
+			return scaleProgress(float64(done)/float64(total), totalSize), true
+		}
+	}
+
+	if m := progressPercentRegex.FindStringSubmatch(line); m != nil {
+		pct, err := strconv.ParseFloat(m[1], 64)
+		if err == nil && pct <= 100 {
+			return scaleProgress(pct/100, totalSize), true
+		}
+	}
+
+	return 0, false
+}
+
+func scaleProgress(fraction float64, totalSize int64) int64 {
+	if fraction < 0 {
+		fraction = 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	return int64(fraction * float64(totalSize))
+}
+
+func parseSizeWithUnit(value, unit string) (float64, bool) {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	multipliers := map[string]float64{
+		"B":   1,
+		"KB":  1e3,
+		"MB":  1e6,
+		"GB":  1e9,
+		"TB":  1e12,
+		"PB":  1e15,
+		"KiB": 1 << 10,
+		"MiB": 1 << 20,
+		"GiB": 1 << 30,
+		"TiB": 1 << 40,
+		"PiB": 1 << 50,
+	}
+	multiplier, ok := multipliers[unit]
+	if !ok {
+		return 0, false
+	}
+	return n * multiplier, true
+}
+
+// uploadPercent maps uploaded bytes onto the upload band of the overall job
+// progress.
+func uploadPercent(uploaded, totalSize int64) int {
+	if totalSize <= 0 {
+		return uploadProgressStart
+	}
+	band := uploadProgressEnd - uploadProgressStart
+	return uploadProgressStart + int(scaleProgress(float64(uploaded)/float64(totalSize), int64(band)))
+}
+
+// progressLineWriter receives pdptool output as it is produced, splits it
+// into lines (progress bars redraw with \r, so that counts as a line break
+// too) and reports any parseable progress.
+type progressLineWriter struct {
+	mu          sync.Mutex
+	totalSize   int64
+	partial     []byte
+	uploaded    int64
+	sawProgress bool
+	onProgress  func(uploaded int64)
+}
+
+func newProgressLineWriter(totalSize int64, onProgress func(uploaded int64)) *progressLineWriter {
+	return &progressLineWriter{
+		totalSize:  totalSize,
+		onProgress: onProgress,
+	}
+}
+
+func (w *progressLineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		idx := bytes.IndexAny(w.partial, "\r\n")
+		if idx == -1 {
+			break
+		}
+		w.handleLine(string(w.partial[:idx]))
+		w.partial = w.partial[idx+1:]
+	}
+	return len(p), nil
+}
+
+func (w *progressLineWriter) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	uploaded, ok := parsePdptoolProgress(line, w.totalSize)
+	if !ok {
+		return
+	}
+
+	w.sawProgress = true
+	if uploaded <= w.uploaded {
+		return
+	}
+	w.uploaded = uploaded
+	if w.onProgress != nil {
+		w.onProgress(uploaded)
+	}
+}
+
+// SawProgress reports whether any line of output contained progress
+// information.
+func (w *progressLineWriter) SawProgress() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sawProgress
+}
+
+// processFileOffset returns how far the process with the given pid has read
+// into the file at path, using /proc/<pid>/fdinfo. It only works on Linux and
+// is used when pdptool doesn't print progress on its own.
+func processFileOffset(pid int, path string) (int64, bool) {
+	fdDir := fmt.Sprintf("/proc/%d/fd", pid)
+	entries, err := os.ReadDir(fdDir)
+	if err != nil {
+		return 0, false
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return 0, false
+	}
+
+	for _, entry := range entries {
+		target, err := os.Readlink(filepath.Join(fdDir, entry.Name()))
+		if err != nil || target != absPath {
+			continue
+		}
+
+		info, err := os.ReadFile(fmt.Sprintf("/proc/%d/fdinfo/%s", pid, entry.Name()))
+		if err != nil {
+			return 0, false
+		}
+		for _, line := range strings.Split(string(info), "\n") {
+			if strings.HasPrefix(line, "pos:") {
+				pos, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "pos:")), 10, 64)
+				if err != nil {
+					return 0, false
+				}
+				return pos, true
+			}
+		}
+	}
+	return 0, false
+}
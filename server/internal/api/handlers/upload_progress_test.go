@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePdptoolProgress(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		totalSize int64
+		want      int64
+		ok        bool
+	}{
+		{"percent", "Uploading: 50%", 1000, 500, true},
+		{"fractional percent", "upload 12.5 % complete", 1000, 125, true},
+		{"full percent", "100%", 1000, 1000, true},
+		{"percent over 100", "150%", 1000, 0, false},
+		{"byte count", "1024/4096 bytes", 4096, 1024, true},
+		{"byte count with spaces", "sent 2048 / 4096 B", 4096, 2048, true},
+		{"byte count scaled to file", "512/1024 bytes", 4096, 2048, true},
+		{"byte count past total", "5000/4096 bytes", 4096, 4096, true},
+		{"zero byte total", "0/0 bytes", 4096, 0, false},
+		{"human readable sizes", "1.5 MiB / 3 MiB", 3000, 1500, true},
+		{"decimal units", "250 KB / 1 MB", 1000, 250, true},
+		{"chunk counter", "uploading chunk 3 of 10", 1000, 300, true},
+		{"chunk counter with slash", "Chunk 1/4", 1000, 250, true},
+		{"no progress", "Piece CID: bagaone", 1000, 0, false},
+		{"empty file", "50%", 0, 0, false},
+	}
+	for _, test := range tests {
+		got, ok := parsePdptoolProgress(test.line, test.totalSize)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%s: parsePdptoolProgress(%q, %d) = %d, %v, want %d, %v", test.name, test.line, test.totalSize, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestProgressLineWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   []int64
+		saw    bool
+	}{
+		{"newline terminated", []string{"10%\n20%\n"}, []int64{100, 200}, true},
+		{"carriage return redraws", []string{"10%\r20%\r30%\r"}, []int64{100, 200, 300}, true},
+		{"line split across writes", []string{"byte 25", "0/1000 bytes\n"}, []int64{250}, true},
+		{"partial line without newline", []string{"10%\n", "40%"}, []int64{100}, true},
+		{"partial line completed later", []string{"40", "%", "\n"}, []int64{400}, true},
+		{"progress never goes back", []string{"60%\n30%\n70%\n"}, []int64{600, 700}, true},
+		{"no progress", []string{"Uploading piece\n\n"}, nil, false},
+	}
+	for _, test := range tests {
+		var reported []int64
+		w := newProgressLineWriter(1000, func(uploaded int64) {
+			reported = append(reported, uploaded)
+		})
+		for _, write := range test.writes {
+			if n, err := w.Write([]byte(write)); n != len(write) || err != nil {
+				t.Errorf("%s: Write(%q) = %d, %v", test.name, write, n, err)
+			}
+		}
+		if !reflect.DeepEqual(reported, test.want) {
+			t.Errorf("%s: reported %v, want %v", test.name, reported, test.want)
+		}
+		if w.SawProgress() != test.saw {
+			t.Errorf("%s: SawProgress = %v, want %v", test.name, w.SawProgress(), test.saw)
+		}
+	}
+}
+
+func TestUploadPercent(t *testing.T) {
+	tests := []struct {
+		uploaded, totalSize int64
+		want                int
+	}{
+		{0, 1000, uploadProgressStart},
+		{500, 1000, 55},
+		{1000, 1000, uploadProgressEnd},
+		{2000, 1000, uploadProgressEnd},
+		{0, 0, uploadProgressStart},
+	}
+	for _, test := range tests {
+		if got := uploadPercent(test.uploaded, test.totalSize); got != test.want {
+			t.Errorf("uploadPercent(%d, %d) = %d, want %d", test.uploaded, test.totalSize, got, test.want)
+		}
+	}
+}
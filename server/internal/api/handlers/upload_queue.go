@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var errUploadQueueClosed = errors.New("upload queue is shutting down")
+
+type uploadTask struct {
+	jobID  string
+	userID uint
+	run    func()
+}
+
+// uploadQueue runs upload jobs on a fixed number of workers. Pending tasks
+// are kept per user and workers take from users in round-robin order, so one
+// user queueing many files can't hold every worker.
+type uploadQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  map[uint][]*uploadTask
+	users    []uint
+	depth    int
+	warnAt   int
+	closed   bool
+	workers  sync.WaitGroup
+	finished chan struct{}
+}
+
+var uploads *uploadQueue
+
+func newUploadQueue(concurrency, warnAt int) *uploadQueue {
+	q := &uploadQueue{
+		pending:  make(map[uint][]*uploadTask),
+		warnAt:   warnAt,
+		finished: make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	for i := 0; i < concurrency; i++ {
+		q.workers.Add(1)
+		go q.worker()
+	}
+	go func() {
+		q.workers.Wait()
+		close(q.finished)
+	}()
+
+	log.WithField("concurrency", concurrency).Info("Upload worker pool started")
+	return q
+}
+
+func (q *uploadQueue) enqueue(jobID string, userID uint, run func()) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return errUploadQueueClosed
+	}
+
+	if len(q.pending[userID]) == 0 {
+		q.users = append(q.users, userID)
+	}
+	q.pending[userID] = append(q.pending[userID], &uploadTask{jobID: jobID, userID: userID, run: run})
+	q.depth++
+
+	if q.warnAt > 0 && q.depth > q.warnAt {
+		log.WithField("queueDepth", q.depth).
+			WithField("threshold", q.warnAt).
+			Warning("Upload queue depth exceeds threshold")
+	}
+
+	q.cond.Signal()
+	return nil
+}
+
+// remove drops a job that hasn't been picked up by a worker yet.
+func (q *uploadQueue) remove(jobID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, userID := range q.users {
+		tasks := q.pending[userID]
+		for j, task := range tasks {
+			if task.jobID != jobID {
+				continue
+			}
+			q.pending[userID] = append(tasks[:j], tasks[j+1:]...)
+			q.depth--
+			if len(q.pending[userID]) == 0 {
+				delete(q.pending, userID)
+				q.users = append(q.users[:i], q.users[i+1:]...)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// position returns the 1-based place of a job in dispatch order.
+func (q *uploadQueue) position(jobID string) (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	pos := 0
+	for round := 0; ; round++ {
+		more := false
+		for _, userID := range q.users {
+			tasks := q.pending[userID]
+			if round >= len(tasks) {
+				continue
+			}
+			more = true
+			pos++
+			if tasks[round].jobID == jobID {
+				return pos, true
+			}
+		}
+		if !more {
+			return 0, false
+		}
+	}
+}
+
+func (q *uploadQueue) next() (*uploadTask, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.users) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.users) == 0 {
+		return nil, false
+	}
+
+	userID := q.users[0]
+	q.users = q.users[1:]
+	task := q.pending[userID][0]
+	q.pending[userID] = q.pending[userID][1:]
+	if len(q.pending[userID]) > 0 {
+		q.users = append(q.users, userID)
+	} else {
+		delete(q.pending, userID)
+	}
+	q.depth--
+
+	return task, true
+}
+
+func (q *uploadQueue) worker() {
+	defer q.workers.Done()
+
+	for {
+		task, ok := q.next()
+		if !ok {
+			return
+		}
+
+		if progress, _, exists := getUploadJob(task.jobID); exists && isTerminalJobStatus(progress.Status) {
+			continue
+		}
+
+		task.run()
+	}
+}
+
+// shutdown stops accepting new jobs and waits for queued and running jobs to
+// finish, or for ctx to expire. Jobs still running at that point are marked
+// interrupted on the next start.
+func (q *uploadQueue) shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	remaining := q.depth
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	log.WithField("queued", remaining).Info("Draining upload worker pool")
+
+	select {
+	case <-q.finished:
+		log.Info("Upload worker pool drained")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func enqueueUpload(jobID string, userID uint, run func()) error {
+	updateJobStatus(jobID, UploadProgress{
+		Status:  "queued",
+		Message: "Waiting for an available upload worker",
+	})
+	return uploads.enqueue(jobID, userID, run)
+}
+
+// Shutdown stops the upload job janitor, the root worker, the proof set
+// poller and background upload processing, waiting for in-flight jobs until
+// ctx expires. Queued root work and pending proof set creations are kept in
+// the database and resume on the next start.
+func Shutdown(ctx context.Context) error {
+	if janitor != nil {
+		janitor.stop()
+	}
+	if removals != nil {
+		removals.stop()
+	}
+	if chunkedJanitor != nil {
+		chunkedJanitor.stop()
+	}
+	if roots != nil {
+		roots.stop()
+	}
+	if proofSetPolls != nil {
+		proofSetPolls.stop()
+	}
+	if webhooks != nil {
+		webhooks.stop()
+	}
+	if proofs != nil {
+		proofs.stop()
+	}
+	var err error
+	if uploads != nil {
+		err = uploads.shutdown(ctx)
+	}
+	if err != nil {
+		log.Warning("Upload jobs did not finish before shutdown, stopping their commands")
+		cancelUploadJobs()
+	}
+	if usage != nil {
+		usage.stop()
+	}
+	return err
+}
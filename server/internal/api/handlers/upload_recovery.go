@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"github.com/hotvault/backend/internal/models"
+)
+
+// resumeUploadedJobs picks up jobs whose file was stored by pdptool but whose
+// piece was never saved because the server stopped in between. The CIDs
+// recorded on the job are enough to save the piece and queue its root, so
+// the stored data isn't left orphaned on the service. It runs before
+// markInterruptedUploadJobs, which flags whatever is left.
+func resumeUploadedJobs() {
+	var jobs []models.UploadJob
+	if err := db.Where("status NOT IN ? AND status <> ? AND c_id <> '' AND base_c_id <> ''", terminalJobStatuses, "uploaded").
+		Find(&jobs).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to look for upload jobs to resume")
+		return
+	}
+
+	resumed := 0
+	for _, job := range jobs {
+		if resumeUploadedJob(job) {
+			resumed++
+		}
+	}
+	if resumed > 0 {
+		log.WithField("count", resumed).Info("Resumed stored uploads that were waiting to be added to a proof set")
+	}
+}
+
+func resumeUploadedJob(job models.UploadJob) bool {
+	logger := log.WithField("jobID", job.JobID).WithField("cid", job.CID)
+	// Load the job into the cache so status updates keep its filename and
+	// reach its owner.
+	getUploadJob(job.JobID)
+
+	var proofSet models.ProofSet
+	if err := db.Where("user_id = ?", job.UserID).First(&proofSet).Error; err != nil || proofSetUploadError(proofSet) != nil {
+		logger.Warning("Cannot resume stored upload, the user's proof set is not ready")
+		return false
+	}
+
+	if existing, ok := findDuplicatePiece(job.UserID, job.CID); ok {
+		var queued int64
+		db.Model(&models.RootTask{}).Where("piece_id = ?", existing.ID).Count(&queued)
+		if pieceInProofSet(existing, proofSet.ID) || queued > 0 {
+			updateJobStatus(job.JobID, UploadProgress{
+				Status:     "uploaded",
+				Progress:   100,
+				Message:    "File uploaded. Adding it to your proof set in the background.",
+				CID:        job.CID,
+				ProofSetID: proofSet.ProofSetID,
+				PieceID:    existing.ID,
+				Checksum:   stringValue(existing.Checksum),
+			})
+			return true
+		}
+	}
+
+	upload := localUpload{
+		Filename:    job.Filename,
+		Size:        job.TotalSize,
+		Checksum:    job.Checksum,
+		ContentType: job.ContentType,
+		Compressed:  job.Compressed,
+		Metadata:    job.Metadata,
+		RetainUntil: job.RetainUntil,
+	}
+	if job.Compressed {
+		upload.CompressedSize = job.StoredSize
+	} else if job.StoredSize > job.TotalSize {
+		upload.PaddedSize = job.StoredSize
+	}
+	piece, err := queueUploadedPiece(job.JobID, job.UserID, upload, &proofSet, job.CID, job.BaseCID)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("Failed to resume stored upload")
+		return false
+	}
+
+	logger.WithField("pieceId", piece.ID).Info("Resumed stored upload after restart")
+	updateJobStatus(job.JobID, UploadProgress{
+		Status:     "uploaded",
+		Progress:   100,
+		Message:    "File uploaded. Adding it to your proof set in the background.",
+		CID:        job.CID,
+		ProofSetID: proofSet.ProofSetID,
+		PieceID:    piece.ID,
+		Checksum:   job.Checksum,
+	})
+	return true
+}
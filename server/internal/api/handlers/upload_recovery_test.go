@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hotvault/backend/internal/models"
+)
+
+func seedUploadJob(t *testing.T, job models.UploadJob) models.UploadJob {
+	t.Helper()
+	job.JobID = uuid.New().String()
+	if job.Filename == "" {
+		job.Filename = "report.pdf"
+	}
+	if err := db.Create(&job).Error; err != nil {
+		t.Fatalf("seed upload job: %v", err)
+	}
+	return job
+}
+
+func storedUploadJob(t *testing.T, jobID string) models.UploadJob {
+	t.Helper()
+	var job models.UploadJob
+	if err := db.Where("job_id = ?", jobID).First(&job).Error; err != nil {
+		t.Fatalf("load upload job: %v", err)
+	}
+	return job
+}
+
+// restartUploads runs what Initialize does for upload jobs on startup.
+func restartUploads() {
+	resumeUploadedJobs()
+	markInterruptedUploadJobs()
+}
+
+func TestRestartResumesStoredUploads(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, user, "42")
+	waiting := createTestUser(t, "0x2")
+	db.Create(&models.ProofSet{UserID: waiting.ID, ServiceName: cfg.ServiceName, ServiceURL: cfg.ServiceURL, Status: proofSetPending})
+
+	stored := seedUploadJob(t, models.UploadJob{
+		UserID:      user.ID,
+		Status:      "processing",
+		Progress:    80,
+		TotalSize:   100,
+		StoredSize:  128,
+		CID:         "bagaone:bagasub",
+		BaseCID:     "bagaone",
+		Checksum:    "abc",
+		ContentType: "application/pdf",
+		Metadata:    models.Metadata{"project": "apollo"},
+	})
+	notStored := seedUploadJob(t, models.UploadJob{UserID: user.ID, Status: "uploading", Progress: 40, TotalSize: 100})
+	finished := seedUploadJob(t, models.UploadJob{UserID: user.ID, Status: "complete", CID: "bagatwo:bagasub", BaseCID: "bagatwo"})
+	noProofSet := seedUploadJob(t, models.UploadJob{UserID: waiting.ID, Status: "pending", CID: "bagathree:bagasub", BaseCID: "bagathree"})
+
+	restartUploads()
+
+	job := storedUploadJob(t, stored.JobID)
+	if job.Status != "uploaded" {
+		t.Errorf("stored upload: status = %q, want uploaded", job.Status)
+	}
+	var piece models.Piece
+	if err := db.Where("user_id = ? AND c_id = ?", user.ID, "bagaone:bagasub").First(&piece).Error; err != nil {
+		t.Fatalf("stored upload: no piece saved: %v", err)
+	}
+	if piece.Filename != "report.pdf" || piece.Size != 100 || piece.PaddedSize != 128 || stringValue(piece.Checksum) != "abc" ||
+		piece.ContentType != "application/pdf" || piece.Metadata["project"] != "apollo" {
+		t.Errorf("stored upload: piece = %+v, want it rebuilt from the job", piece)
+	}
+	if piece.ProofSetID == nil || *piece.ProofSetID != proofSet.ID || piece.RootStatus != rootStatusPending {
+		t.Errorf("stored upload: piece in proof set %v with root %q, want %d and pending", piece.ProofSetID, piece.RootStatus, proofSet.ID)
+	}
+	if task := rootTaskOf(t, piece.ID); task.Status != rootTaskAdding || task.JobID != stored.JobID || task.ProofSetID != "42" {
+		t.Errorf("stored upload: root task = %+v, want add-roots queued for the job", task)
+	}
+
+	if job := storedUploadJob(t, notStored.JobID); job.Status != "interrupted" {
+		t.Errorf("upload never stored: status = %q, want interrupted", job.Status)
+	}
+	if job := storedUploadJob(t, finished.JobID); job.Status != "complete" {
+		t.Errorf("finished upload: status = %q, want complete", job.Status)
+	}
+	if job := storedUploadJob(t, noProofSet.JobID); job.Status != "interrupted" {
+		t.Errorf("upload without a ready proof set: status = %q, want interrupted", job.Status)
+	}
+	var pieces int64
+	db.Model(&models.Piece{}).Count(&pieces)
+	if pieces != 1 {
+		t.Errorf("%d pieces saved, want 1", pieces)
+	}
+
+	// A second restart finds nothing left to resume.
+	restartUploads()
+	db.Model(&models.Piece{}).Count(&pieces)
+	var tasks int64
+	db.Model(&models.RootTask{}).Count(&tasks)
+	if pieces != 1 || tasks != 1 {
+		t.Errorf("after a second restart: %d pieces and %d root tasks, want 1 of each", pieces, tasks)
+	}
+}
+
+func TestRestartReusesPieceAlreadySaved(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+	proofSet := createTestProofSet(t, user, "42")
+	rootID := "7"
+	checksum := "abc"
+	piece := createTestPiece(t, models.Piece{
+		UserID:     user.ID,
+		CID:        "bagaone:bagasub",
+		ProofSetID: &proofSet.ID,
+		RootID:     &rootID,
+		RootStatus: rootStatusConfirmed,
+		Checksum:   &checksum,
+	})
+	// The server stopped after saving the piece but before the job was
+	// updated.
+	job := seedUploadJob(t, models.UploadJob{UserID: user.ID, Status: "processing", CID: "bagaone:bagasub", BaseCID: "bagaone"})
+
+	restartUploads()
+
+	stored := storedUploadJob(t, job.JobID)
+	if stored.Status != "uploaded" {
+		t.Errorf("status = %q, want uploaded", stored.Status)
+	}
+	var pieces, tasks int64
+	db.Model(&models.Piece{}).Count(&pieces)
+	db.Model(&models.RootTask{}).Count(&tasks)
+	if pieces != 1 || tasks != 0 {
+		t.Errorf("%d pieces and %d root tasks, want piece %d alone", pieces, tasks, piece.ID)
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"os"
+
+	"github.com/hotvault/backend/internal/services/scan"
+)
+
+// scanner checks uploads for malware before they reach pdptool. It is a
+// no-op unless scanning is enabled.
+var scanner scan.Scanner = scan.NoopScanner{}
+
+// scanUpload scans a stored upload and returns the detected signature when
+// it is infected, after deleting the file so it can never be sent on. Scanner
+// failures are only returned when the scanner is configured to fail closed.
+func scanUpload(ctx context.Context, upload localUpload) (string, error) {
+	result, err := scanner.Scan(ctx, upload.Path)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", err
+		}
+		if cfg.Scan.FailOpen {
+			log.WithField("filename", upload.Filename).
+				WithField("error", err.Error()).
+				Warning("Malware scan failed, continuing because scanning fails open")
+			return "", nil
+		}
+		log.WithField("filename", upload.Filename).WithField("error", err.Error()).Error("Malware scan failed")
+		return "", err
+	}
+
+	if !result.Infected {
+		return "", nil
+	}
+
+	log.WithField("filename", upload.Filename).
+		WithField("signature", result.Signature).
+		Warning("Rejected upload containing malware")
+	if err := os.Remove(upload.Path); err != nil && !os.IsNotExist(err) {
+		log.WithField("path", upload.Path).WithField("error", err.Error()).Error("Failed to delete infected upload")
+	}
+	return result.Signature, nil
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBatchStatusJobs caps how many jobs one batch status request may ask for.
+const maxBatchStatusJobs = 50
+
+// BatchUploadStatusRequest lists the jobs to report on
+// @Description Job IDs to get the status of
+type BatchUploadStatusRequest struct {
+	JobIDs []string `json:"jobIds" binding:"required"`
+}
+
+// BatchUploadStatusEntry is the status of one job in a batch status response
+// @Description Status of one job, or notFound when it doesn't exist or belongs to another user
+type BatchUploadStatusEntry struct {
+	*UploadProgress
+	NotFound bool `json:"notFound,omitempty"`
+}
+
+// @Summary Get the status of several uploads
+// @Description Get the status of up to 50 upload jobs in one request. Jobs that don't exist or belong to another user are marked notFound rather than failing the request.
+// @Tags upload
+// @Accept json
+// @Produce json
+// @Param request body BatchUploadStatusRequest true "Job IDs"
+// @Success 200 {object} map[string]BatchUploadStatusEntry
+// @Failure 400 {object} ErrorResponse
+// @Router /api/v1/upload/status/batch [post]
+func GetUploadStatusBatch(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var request BatchUploadStatusRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+	if len(request.JobIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "jobIds must not be empty",
+		})
+		return
+	}
+	if len(request.JobIDs) > maxBatchStatusJobs {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("At most %d jobs can be requested at once", maxBatchStatusJobs),
+		})
+		return
+	}
+
+	jobs := getUploadJobs(request.JobIDs)
+
+	response := make(map[string]BatchUploadStatusEntry, len(request.JobIDs))
+	for _, jobID := range request.JobIDs {
+		job, ok := jobs[jobID]
+		if !ok || job.ownerID != userID.(uint) {
+			response[jobID] = BatchUploadStatusEntry{NotFound: true}
+			continue
+		}
+		progress := job.progress
+		if progress.Status == "queued" && uploads != nil {
+			if pos, ok := uploads.position(jobID); ok {
+				progress.QueuePosition = pos
+			}
+		}
+		response[jobID] = BatchUploadStatusEntry{UploadProgress: &progress}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hotvault/backend/internal/models"
+)
+
+func TestGetUploadStatusHidesOtherUsersJobs(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	owner := createTestUser(t, "0x1")
+	other := createTestUser(t, "0x2")
+
+	jobID := uuid.New().String()
+	createUploadJob(jobID, owner.ID, UploadProgress{Status: "processing", Filename: "report.pdf"})
+
+	c, recorder := newTestContext(http.MethodGet, "/api/v1/upload/status/"+jobID, nil, owner)
+	c.Params = gin.Params{{Key: "jobId", Value: jobID}}
+	GetUploadStatus(c)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("owner: status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	var progress UploadProgress
+	if err := json.Unmarshal(recorder.Body.Bytes(), &progress); err != nil || progress.Filename != "report.pdf" {
+		t.Errorf("owner: progress = %+v, %v", progress, err)
+	}
+
+	c, recorder = newTestContext(http.MethodGet, "/api/v1/upload/status/"+jobID, nil, other)
+	c.Params = gin.Params{{Key: "jobId", Value: jobID}}
+	GetUploadStatus(c)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("other user: status = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+
+	// Jobs loaded back from the database are checked the same way.
+	uploadJobsLock.Lock()
+	delete(uploadJobs, jobID)
+	delete(uploadJobOwners, jobID)
+	uploadJobsLock.Unlock()
+	c, recorder = newTestContext(http.MethodGet, "/api/v1/upload/status/"+jobID, nil, other)
+	c.Params = gin.Params{{Key: "jobId", Value: jobID}}
+	GetUploadStatus(c)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("other user, stored job: status = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+}
+
+func TestCancelUploadHidesOtherUsersJobs(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	owner := createTestUser(t, "0x1")
+	other := createTestUser(t, "0x2")
+
+	jobID := uuid.New().String()
+	createUploadJob(jobID, owner.ID, UploadProgress{Status: "processing", Filename: "report.pdf"})
+	cancel := func(jobID string, user models.User) *httptest.ResponseRecorder {
+		c, recorder := newTestContext(http.MethodDelete, "/api/v1/upload/"+jobID, nil, user)
+		c.Params = gin.Params{{Key: "jobId", Value: jobID}}
+		CancelUpload(c)
+		return recorder
+	}
+
+	// Another user's job looks the same as one that doesn't exist.
+	foreign := cancel(jobID, other)
+	unknown := cancel(uuid.New().String(), other)
+	if foreign.Code != http.StatusNotFound || foreign.Body.String() != unknown.Body.String() {
+		t.Errorf("other user: status = %d, %s, want %d, %s", foreign.Code, foreign.Body, http.StatusNotFound, unknown.Body)
+	}
+	if progress, _, _ := getUploadJob(jobID); progress.Status != "processing" {
+		t.Errorf("other user cancelled the job: status = %q", progress.Status)
+	}
+
+	if recorder := cancel(jobID, owner); recorder.Code != http.StatusOK {
+		t.Errorf("owner: status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+}
+
+const testMaxUploadSize = 1000
+
+func TestUploadFileSizeLimit(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	useTestUploadQueue(t)
+	cfg.Upload.MaxSize = testMaxUploadSize
+	user := createTestUser(t, "0x1")
+	createTestProofSet(t, user, "42")
+	fakePdptool(t, "exit 1")
+
+	tests := []struct {
+		name string
+		size int
+		want int
+	}{
+		{"exactly at the limit", testMaxUploadSize, http.StatusOK},
+		{"one byte over", testMaxUploadSize + 1, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		data := bytes.Repeat([]byte("a"), test.size)
+		c, recorder := newMultipartRequest(t, "/api/v1/upload", nil, []testFile{{field: "file", name: "a.txt", data: data}}, user)
+		UploadFile(c)
+
+		if recorder.Code != test.want {
+			t.Errorf("%s: status = %d, want %d: %s", test.name, recorder.Code, test.want, recorder.Body)
+			continue
+		}
+		if test.want == http.StatusRequestEntityTooLarge {
+			var response struct {
+				MaxUploadSize int64 `json:"maxUploadSize"`
+			}
+			json.Unmarshal(recorder.Body.Bytes(), &response)
+			if response.MaxUploadSize != testMaxUploadSize {
+				t.Errorf("%s: maxUploadSize = %d, want %d", test.name, response.MaxUploadSize, testMaxUploadSize)
+			}
+		}
+	}
+}
+
+func TestInitChunkedUploadSizeLimit(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	cfg.Upload.MaxSize = testMaxUploadSize
+	user := createTestUser(t, "0x1")
+	createTestProofSet(t, user, "42")
+	fakePdptool(t, "exit 1")
+
+	tests := []struct {
+		name string
+		size int64
+		want int
+	}{
+		{"exactly at the limit", testMaxUploadSize, http.StatusOK},
+		{"one byte over", testMaxUploadSize + 1, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		c, recorder := newJSONRequest(t, http.MethodPost, "/api/v1/chunked-upload/init", gin.H{
+			"filename":    "a.bin",
+			"totalSize":   test.size,
+			"chunkSize":   test.size,
+			"totalChunks": 1,
+			"fileType":    "application/octet-stream",
+		}, user)
+		InitChunkedUpload(c)
+
+		if recorder.Code != test.want {
+			t.Errorf("%s: status = %d, want %d: %s", test.name, recorder.Code, test.want, recorder.Body)
+			continue
+		}
+		var response struct {
+			UploadID      string `json:"uploadId"`
+			MaxUploadSize int64  `json:"maxUploadSize"`
+		}
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		if test.want == http.StatusOK {
+			t.Cleanup(func() { forgetChunkedUpload(response.UploadID) })
+		} else if response.MaxUploadSize != testMaxUploadSize {
+			t.Errorf("%s: maxUploadSize = %d, want %d", test.name, response.MaxUploadSize, testMaxUploadSize)
+		}
+	}
+}
+
+func TestGetUploadLimits(t *testing.T) {
+	useTestConfig(t)
+	cfg.Upload.MaxSize = 10 << 30
+
+	c, recorder := newTestContext(http.MethodGet, "/api/v1/upload/limits", nil, models.User{})
+	GetUploadLimits(c)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	var response UploadLimitsResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.MaxUploadSize != 10<<30 || response.MaxUploadSizeHuman != "10.0 GB" {
+		t.Errorf("limits = %+v, want 10737418240 and 10.0 GB", response)
+	}
+}
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hotvault/backend/internal/models"
+)
+
+const (
+	usageDirectionUpload   = "upload"
+	usageDirectionDownload = "download"
+
+	// usageFlushInterval and usageFlushSize bound how long and how many
+	// transfers are buffered before being written in one batch.
+	usageFlushInterval = 10 * time.Second
+	usageFlushSize     = 500
+
+	defaultUsagePeriods = 30
+	maxUsagePeriods     = 366
+)
+
+var usageGranularities = map[string]bool{
+	"day":   true,
+	"week":  true,
+	"month": true,
+}
+
+// usageRecorder buffers usage records in memory and writes them in batches,
+// so recording a transfer never costs a database round trip.
+type usageRecorder struct {
+	mu       sync.Mutex
+	pending  []models.UsageRecord
+	flushNow chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+var usage *usageRecorder
+
+func startUsageRecorder() *usageRecorder {
+	u := &usageRecorder{
+		flushNow: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	go func() {
+		defer close(u.stopped)
+		ticker := time.NewTicker(usageFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-u.done:
+				u.flush()
+				return
+			case <-ticker.C:
+				u.flush()
+			case <-u.flushNow:
+				u.flush()
+			}
+		}
+	}()
+	return u
+}
+
+func (u *usageRecorder) record(entry models.UsageRecord) {
+	u.mu.Lock()
+	u.pending = append(u.pending, entry)
+	full := len(u.pending) >= usageFlushSize
+	u.mu.Unlock()
+
+	if full {
+		select {
+		case u.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (u *usageRecorder) flush() {
+	u.mu.Lock()
+	batch := u.pending
+	u.pending = nil
+	u.mu.Unlock()
+
+	if len(batch) == 0 || db == nil {
+		return
+	}
+	if err := db.CreateInBatches(batch, usageFlushSize).Error; err != nil {
+		log.WithField("records", len(batch)).WithField("error", err.Error()).Error("Failed to save usage records")
+	}
+}
+
+// stop writes whatever is still buffered and ends the flush loop.
+func (u *usageRecorder) stop() {
+	u.stopOnce.Do(func() {
+		close(u.done)
+	})
+	<-u.stopped
+}
+
+// recordUsage notes that bytes of a piece were transferred for a user.
+// pieceID may be 0 when the transfer isn't tied to a stored piece.
+func recordUsage(userID uint, pieceID uint, direction string, bytes int64) {
+	if usage == nil || bytes <= 0 {
+		return
+	}
+	entry := models.UsageRecord{
+		UserID:    userID,
+		Direction: direction,
+		Bytes:     bytes,
+		CreatedAt: time.Now(),
+	}
+	if pieceID != 0 {
+		entry.PieceID = &pieceID
+	}
+	usage.record(entry)
+}
+
+// countingResponseWriter counts the bytes copied to a response so downloads
+// record what was actually sent, even when the client disconnects early.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (cw *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// transferTotals returns the bytes a user has uploaded and downloaded in
+// total.
+func transferTotals(userID uint) (uploaded int64, downloaded int64, err error) {
+	var rows []struct {
+		Direction string
+		Total     int64
+	}
+	err = db.Model(&models.UsageRecord{}).
+		Select("direction, COALESCE(SUM(bytes), 0) AS total").
+		Where("user_id = ?", userID).
+		Group("direction").
+		Scan(&rows).Error
+	for _, row := range rows {
+		switch row.Direction {
+		case usageDirectionUpload:
+			uploaded = row.Total
+		case usageDirectionDownload:
+			downloaded = row.Total
+		}
+	}
+	return uploaded, downloaded, err
+}
+
+// UsagePeriod is the data transferred during one period
+// @Description Bytes uploaded and downloaded during one period
+type UsagePeriod struct {
+	Period          time.Time `json:"period"`
+	UploadedBytes   int64     `json:"uploadedBytes"`
+	DownloadedBytes int64     `json:"downloadedBytes"`
+}
+
+// UsageHistoryResponse is a user's transfer history
+// @Description Bytes transferred per period, oldest first, with all-time totals
+type UsageHistoryResponse struct {
+	Granularity     string        `json:"granularity" example:"day"`
+	Periods         []UsagePeriod `json:"periods"`
+	UploadedBytes   int64         `json:"uploadedBytes"`
+	DownloadedBytes int64         `json:"downloadedBytes"`
+}
+
+// @Summary Get transfer usage history
+// @Description Get how many bytes the authenticated user uploaded and downloaded per day, week or month, along with all-time totals
+// @Tags account
+// @Produce json
+// @Param granularity query string false "day, week or month" default(day)
+// @Param periods query int false "Number of most recent periods to return (max 366)" default(30)
+// @Success 200 {object} UsageHistoryResponse
+// @Failure 400 {object} ErrorResponse
+// @Router /api/v1/account/usage/history [get]
+func GetUsageHistory(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	granularity := c.DefaultQuery("granularity", "day")
+	if !usageGranularities[granularity] {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "granularity must be 'day', 'week' or 'month'",
+		})
+		return
+	}
+
+	periods, err := strconv.Atoi(c.DefaultQuery("periods", strconv.Itoa(defaultUsagePeriods)))
+	if err != nil || periods <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "periods must be a positive integer",
+		})
+		return
+	}
+	if periods > maxUsagePeriods {
+		periods = maxUsagePeriods
+	}
+
+	if usage != nil {
+		usage.flush()
+	}
+
+	var rows []struct {
+		Period    time.Time
+		Direction string
+		Total     int64
+	}
+	if err := db.Model(&models.UsageRecord{}).
+		Select("date_trunc(?, created_at) AS period, direction, SUM(bytes) AS total", granularity).
+		Where("user_id = ? AND created_at >= ?", userID.(uint), usageHistoryStart(granularity, periods)).
+		Group("period, direction").
+		Order("period ASC").
+		Scan(&rows).Error; err != nil {
+		log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to load usage history")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load usage history",
+		})
+		return
+	}
+
+	response := UsageHistoryResponse{
+		Granularity: granularity,
+		Periods:     []UsagePeriod{},
+	}
+	index := make(map[time.Time]int)
+	for _, row := range rows {
+		i, ok := index[row.Period]
+		if !ok {
+			i = len(response.Periods)
+			index[row.Period] = i
+			response.Periods = append(response.Periods, UsagePeriod{Period: row.Period})
+		}
+		switch row.Direction {
+		case usageDirectionUpload:
+			response.Periods[i].UploadedBytes = row.Total
+		case usageDirectionDownload:
+			response.Periods[i].DownloadedBytes = row.Total
+		}
+	}
+
+	response.UploadedBytes, response.DownloadedBytes, err = transferTotals(userID.(uint))
+	if err != nil {
+		log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to load usage totals")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to load usage history",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// usageHistoryStart returns the start of the oldest period to include.
+func usageHistoryStart(granularity string, periods int) time.Time {
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch granularity {
+	case "week":
+		// date_trunc weeks start on Monday.
+		monday := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		return monday.AddDate(0, 0, -7*(periods-1))
+	case "month":
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -(periods - 1), 0)
+	default:
+		return today.AddDate(0, 0, -(periods - 1))
+	}
+}
@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Events sent to webhooks.
+const (
+	webhookEventProofSetReady            = "proofset.ready"
+	webhookEventProofSetProvingMissed    = "proofset.proving_missed"
+	webhookEventProofSetProvingRecovered = "proofset.proving_recovered"
+)
+
+// Status of a WebhookDelivery.
+const (
+	webhookDeliveryPending    = "pending"
+	webhookDeliveryDelivered  = "delivered"
+	webhookDeliveryDeadLetter = "dead_letter" // ran out of attempts
+)
+
+const maxWebhooksPerUser = 10
+
+const (
+	defaultWebhookDeliveriesLimit = 50
+	maxWebhookDeliveriesLimit     = 200
+)
+
+// WebhookEvent is the body of every webhook delivery. What data holds
+// depends on the event.
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// ProofSetReadyData is the data of a proofset.ready event.
+type ProofSetReadyData struct {
+	ID              uint   `json:"id"`
+	ProofSetID      string `json:"proofSetId"`
+	TransactionHash string `json:"transactionHash"`
+}
+
+// CreateWebhookRequest registers a webhook
+// @Description URL to send events to, over http or https. Its host may not be or resolve to a loopback, private or link-local address.
+type CreateWebhookRequest struct {
+	URL string `json:"url" binding:"required" example:"https://example.com/hotvault"`
+}
+
+// WebhookResponse describes a webhook
+// @Description A registered webhook. secret is only returned when the webhook is created; use it to check the X-Hotvault-Signature header of deliveries.
+type WebhookResponse struct {
+	ID        uint      `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookDeliveryListResponse is a page of a webhook's deliveries
+// @Description Page of a webhook's deliveries, newest first
+type WebhookDeliveryListResponse struct {
+	Deliveries []models.WebhookDelivery `json:"deliveries"`
+	Total      int64                    `json:"total"`
+	Limit      int                      `json:"limit"`
+	Offset     int                      `json:"offset"`
+}
+
+// newWebhookSecret returns a random secret of 64 hex characters.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// checkWebhookHost resolves a webhook's host and returns an error when it
+// is, or resolves to, an internal address. Deliveries check the address they
+// connect to again.
+func checkWebhookHost(ctx context.Context, host string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("url host %s could not be resolved", host)
+	}
+	for _, addr := range addrs {
+		if webhookIPBlocked(addr.IP) {
+			return fmt.Errorf("url host %s is an internal address", host)
+		}
+	}
+	return nil
+}
+
+// publishWebhookEvent queues event for every webhook of the user and wakes
+// the dispatcher. Failures are logged rather than failing whatever caused
+// the event.
+func publishWebhookEvent(userID uint, event string, data interface{}) {
+	if db == nil {
+		return
+	}
+	var hooks []models.Webhook
+	if err := db.Where("user_id = ?", userID).Find(&hooks).Error; err != nil {
+		log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to load webhooks")
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	envelope := WebhookEvent{
+		ID:        uuid.New().String(),
+		Event:     event,
+		Timestamp: time.Now().UTC(),
+		Data:      data,
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		log.WithField("event", event).WithField("error", err.Error()).Error("Failed to encode webhook event")
+		return
+	}
+
+	deliveries := make([]models.WebhookDelivery, len(hooks))
+	for i, hook := range hooks {
+		deliveries[i] = models.WebhookDelivery{
+			WebhookID:     hook.ID,
+			EventID:       envelope.ID,
+			Event:         event,
+			Payload:       string(payload),
+			Status:        webhookDeliveryPending,
+			NextAttemptAt: envelope.Timestamp,
+		}
+	}
+	if err := db.Create(&deliveries).Error; err != nil {
+		log.WithField("userID", userID).WithField("event", event).WithField("error", err.Error()).Error("Failed to queue webhook deliveries")
+		return
+	}
+	if webhooks != nil {
+		webhooks.notify()
+	}
+}
+
+// @Summary Register a webhook
+// @Description Register a URL to be sent a signed POST for events on the caller's account, proofset.ready once a proof set's ID is known, proofset.proving_missed when a proof set stops proving and proofset.proving_recovered when it proves again. Each body is a JSON envelope with id, event, timestamp and data. Deliveries carry X-Hotvault-Event, X-Hotvault-Delivery (the event id) and X-Hotvault-Timestamp headers, and X-Hotvault-Signature: sha256= followed by the hex HMAC-SHA256, keyed with the secret, of the timestamp header, a dot and the body. A delivery that doesn't get a 2xx response is retried with backoff and dead-lettered once it runs out of attempts.
+// @Tags webhooks
+// @Accept json
+// @Param request body CreateWebhookRequest true "Webhook URL"
+// @Produce json
+// @Success 201 {object} WebhookResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /api/v1/webhooks [post]
+func CreateWebhook(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var request CreateWebhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request parameters: " + err.Error(),
+		})
+		return
+	}
+	target, err := url.Parse(request.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "url must be an absolute http or https URL",
+		})
+		return
+	}
+
+	if err := checkWebhookHost(c.Request.Context(), target.Hostname()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	var count int64
+	if err := db.Model(&models.Webhook{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to count webhooks")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create webhook",
+		})
+		return
+	}
+	if count >= maxWebhooksPerUser {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Webhook limit reached. Delete a webhook before registering another",
+		})
+		return
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		log.WithField("error", err.Error()).Error("Failed to generate webhook secret")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create webhook",
+		})
+		return
+	}
+	hook := models.Webhook{
+		UserID: userID.(uint),
+		URL:    target.String(),
+		Secret: secret,
+	}
+	if err := db.Create(&hook).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to save webhook")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create webhook",
+		})
+		return
+	}
+
+	log.WithField("webhookID", hook.ID).WithField("userID", hook.UserID).Info("Registered webhook")
+	c.JSON(http.StatusCreated, WebhookResponse{
+		ID:        hook.ID,
+		URL:       hook.URL,
+		Secret:    hook.Secret,
+		CreatedAt: hook.CreatedAt,
+	})
+}
+
+// @Summary List webhooks
+// @Description List the caller's webhooks. Their secrets aren't included.
+// @Tags webhooks
+// @Produce json
+// @Success 200 {array} WebhookResponse
+// @Router /api/v1/webhooks [get]
+func ListWebhooks(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	var hooks []models.Webhook
+	if err := db.Where("user_id = ?", userID).Order("id ASC").Find(&hooks).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to fetch webhooks")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch webhooks",
+		})
+		return
+	}
+
+	response := make([]WebhookResponse, len(hooks))
+	for i, hook := range hooks {
+		response[i] = WebhookResponse{
+			ID:        hook.ID,
+			URL:       hook.URL,
+			CreatedAt: hook.CreatedAt,
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// @Summary Delete a webhook
+// @Description Stop sending events to one of the caller's webhooks. Deliveries still pending are dead-lettered; past deliveries stay listed.
+// @Tags webhooks
+// @Param id path int true "Webhook ID"
+// @Success 204
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/webhooks/{id} [delete]
+func DeleteWebhook(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	result := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).Delete(&models.Webhook{})
+	if result.Error != nil {
+		log.WithField("webhookID", c.Param("id")).WithField("error", result.Error.Error()).Error("Failed to delete webhook")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to delete webhook",
+		})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Webhook not found",
+		})
+		return
+	}
+
+	log.WithField("webhookID", c.Param("id")).Info("Deleted webhook")
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary List a webhook's deliveries
+// @Description List the events sent, or still to be sent, to one of the caller's webhooks, newest first. status is pending while attempts remain, delivered once the URL answered 2xx, or dead_letter once it ran out of attempts; lastError and responseStatus describe the last failed attempt.
+// @Tags webhooks
+// @Param id path int true "Webhook ID"
+// @Param status query string false "Only deliveries with this status: pending, delivered or dead_letter"
+// @Param limit query int false "Maximum number of deliveries to return (max 200)" default(50)
+// @Param offset query int false "Number of deliveries to skip" default(0)
+// @Produce json
+// @Success 200 {object} WebhookDeliveryListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /api/v1/webhooks/{id}/deliveries [get]
+func ListWebhookDeliveries(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "User ID not found in token",
+		})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultWebhookDeliveriesLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "limit must be a positive integer",
+		})
+		return
+	}
+	if limit > maxWebhookDeliveriesLimit {
+		limit = maxWebhookDeliveriesLimit
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "offset must be a non-negative integer",
+		})
+		return
+	}
+
+	var hook models.Webhook
+	if err := db.Unscoped().Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&hook).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": "Webhook not found",
+			})
+			return
+		}
+		log.WithField("error", err.Error()).Error("Failed to fetch webhook")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch webhook",
+		})
+		return
+	}
+
+	query := db.Model(&models.WebhookDelivery{}).Where("webhook_id = ?", hook.ID)
+	switch status := c.Query("status"); status {
+	case "":
+	case webhookDeliveryPending, webhookDeliveryDelivered, webhookDeliveryDeadLetter:
+		query = query.Where("status = ?", status)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "status must be pending, delivered or dead_letter",
+		})
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.WithField("webhookID", hook.ID).WithField("error", err.Error()).Error("Failed to count webhook deliveries")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch webhook deliveries",
+		})
+		return
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&deliveries).Error; err != nil {
+		log.WithField("webhookID", hook.ID).WithField("error", err.Error()).Error("Failed to fetch webhook deliveries")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch webhook deliveries",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, WebhookDeliveryListResponse{
+		Deliveries: deliveries,
+		Total:      total,
+		Limit:      limit,
+		Offset:     offset,
+	})
+}
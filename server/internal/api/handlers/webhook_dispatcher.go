@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+// webhookDispatchInterval is how often the dispatcher looks for due
+// deliveries when nothing new has been queued.
+const webhookDispatchInterval = 5 * time.Second
+
+// webhookDispatchBatch caps how many deliveries one pass sends.
+const webhookDispatchBatch = 100
+
+// webhookClient sends deliveries. Redirects aren't followed, so a webhook
+// has to answer at the URL it was registered with, and connections to
+// internal addresses are refused.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: checkWebhookDial,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// webhookIPBlocked reports whether deliveries may not be sent to ip. Tests
+// replace it to deliver to their loopback servers.
+var webhookIPBlocked = internalIP
+
+// internalIP reports whether ip is loopback, private, link-local, multicast
+// or unspecified, none of which a webhook may point at.
+func internalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// checkWebhookDial refuses to connect to an internal address. It runs on
+// the address actually being dialed, after the host was resolved, so a
+// name that resolves differently than it did at registration can't reach
+// one either.
+func checkWebhookDial(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || webhookIPBlocked(ip) {
+		return fmt.Errorf("webhook address %s is not allowed", host)
+	}
+	return nil
+}
+
+// webhookDispatcher sends queued webhook deliveries in the background,
+// retrying failures with backoff until they run out of attempts. Like the
+// root queue, all state lives in the webhook_deliveries table.
+type webhookDispatcher struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wake     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+var webhooks *webhookDispatcher
+
+func startWebhookDispatcher() *webhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &webhookDispatcher{
+		ctx:     ctx,
+		cancel:  cancel,
+		wake:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+	}
+
+	go d.run()
+
+	log.Info("Webhook dispatcher started")
+	return d
+}
+
+// notify wakes the dispatcher so a newly queued delivery doesn't wait for
+// the next tick.
+func (d *webhookDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop cancels any request in flight and waits for the dispatcher to exit.
+// Pending deliveries are sent after the next start.
+func (d *webhookDispatcher) stop() {
+	d.stopOnce.Do(d.cancel)
+	<-d.stopped
+}
+
+func (d *webhookDispatcher) run() {
+	defer close(d.stopped)
+
+	ticker := time.NewTicker(webhookDispatchInterval)
+	defer ticker.Stop()
+
+	for {
+		d.dispatchDue()
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchDue sends every pending delivery whose next attempt is due.
+func (d *webhookDispatcher) dispatchDue() {
+	var due []models.WebhookDelivery
+	if err := db.Where("status = ? AND next_attempt_at <= ?", webhookDeliveryPending, time.Now()).
+		Order("id ASC").
+		Limit(webhookDispatchBatch).
+		Find(&due).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to load webhook deliveries")
+		return
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	hookIDs := make([]uint, 0, len(due))
+	for _, delivery := range due {
+		hookIDs = append(hookIDs, delivery.WebhookID)
+	}
+	var hooks []models.Webhook
+	if err := db.Unscoped().Where("id IN ?", hookIDs).Find(&hooks).Error; err != nil {
+		log.WithField("error", err.Error()).Error("Failed to load webhooks")
+		return
+	}
+	hooksByID := make(map[uint]models.Webhook, len(hooks))
+	for _, hook := range hooks {
+		hooksByID[hook.ID] = hook
+	}
+
+	for _, delivery := range due {
+		if d.ctx.Err() != nil {
+			return
+		}
+		d.deliver(delivery, hooksByID[delivery.WebhookID])
+	}
+}
+
+// deliver makes one attempt at delivery and records its outcome.
+func (d *webhookDispatcher) deliver(delivery models.WebhookDelivery, hook models.Webhook) {
+	logger := log.WithField("webhookID", delivery.WebhookID).WithField("deliveryID", delivery.ID)
+	updates := map[string]interface{}{}
+
+	if hook.ID == 0 || hook.DeletedAt.Valid {
+		updates["status"] = webhookDeliveryDeadLetter
+		updates["last_error"] = "the webhook was deleted"
+	} else {
+		status, err := d.send(hook, delivery)
+		if d.ctx.Err() != nil {
+			// Shutting down; the attempt is made again after the restart.
+			return
+		}
+		updates["attempts"] = delivery.Attempts + 1
+		updates["response_status"] = status
+		if err == nil {
+			now := time.Now()
+			updates["status"] = webhookDeliveryDelivered
+			updates["delivered_at"] = &now
+			updates["last_error"] = ""
+		} else {
+			updates["last_error"] = err.Error()
+			if delivery.Attempts+1 >= cfg.Retry.WebhookMaxAttempts {
+				updates["status"] = webhookDeliveryDeadLetter
+				logger.WithField("error", err.Error()).Warning("Webhook delivery ran out of attempts")
+			} else {
+				updates["next_attempt_at"] = time.Now().Add(retryDelay(cfg.Retry.WebhookBackoff, cfg.Retry.WebhookMaxBackoff, delivery.Attempts+1))
+			}
+		}
+	}
+
+	if err := db.Model(&delivery).Updates(updates).Error; err != nil {
+		logger.WithField("error", err.Error()).Error("Failed to record webhook delivery attempt")
+	}
+}
+
+// send POSTs delivery's payload to hook, signed with its secret, and
+// returns the response status. Anything but a 2xx response is an error.
+func (d *webhookDispatcher) send(hook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write([]byte(timestamp + "." + delivery.Payload))
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, hook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Hotvault-Webhook/1.0")
+	req.Header.Set("X-Hotvault-Event", delivery.Event)
+	req.Header.Set("X-Hotvault-Delivery", delivery.EventID)
+	req.Header.Set("X-Hotvault-Timestamp", timestamp)
+	req.Header.Set("X-Hotvault-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hotvault/backend/internal/models"
+)
+
+func TestInternalIP(t *testing.T) {
+	tests := []struct {
+		ip       string
+		internal bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"fd00::1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"0.0.0.0", true},
+		{"::", true},
+		{"::ffff:127.0.0.1", true},
+		{"224.0.0.1", true},
+		{"93.184.216.34", false},
+		{"2606:2800:220:1:248:1893:25c8:1946", false},
+	}
+	for _, test := range tests {
+		if got := internalIP(net.ParseIP(test.ip)); got != test.internal {
+			t.Errorf("internalIP(%s) = %v, want %v", test.ip, got, test.internal)
+		}
+	}
+}
+
+func TestCreateWebhookRejectsInternalHosts(t *testing.T) {
+	useTestDB(t)
+	useTestConfig(t)
+	user := createTestUser(t, "0x1")
+
+	for _, target := range []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://10.0.0.5/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		c, recorder := newTestContext(http.MethodPost, "/api/v1/webhooks", strings.NewReader(`{"url":"`+target+`"}`), user)
+		c.Request.Header.Set("Content-Type", "application/json")
+		CreateWebhook(c)
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", target, recorder.Code, http.StatusBadRequest)
+		}
+	}
+
+	c, recorder := newTestContext(http.MethodPost, "/api/v1/webhooks", strings.NewReader(`{"url":"https://93.184.216.34/hook"}`), user)
+	c.Request.Header.Set("Content-Type", "application/json")
+	CreateWebhook(c)
+	if recorder.Code != http.StatusCreated {
+		t.Errorf("public address: status = %d, want %d: %s", recorder.Code, http.StatusCreated, recorder.Body)
+	}
+
+	var count int64
+	db.Model(&models.Webhook{}).Count(&count)
+	if count != 1 {
+		t.Errorf("stored %d webhooks, want 1", count)
+	}
+}
+
+func TestWebhookDeliveryRefusesInternalAddress(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	d := &webhookDispatcher{ctx: context.Background()}
+	// A host that passed registration but now resolves to loopback is
+	// refused when the delivery connects.
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	for _, target := range []string{server.URL, "http://localhost:" + port} {
+		hook := models.Webhook{URL: target, Secret: "secret"}
+		_, err := d.send(hook, models.WebhookDelivery{Event: webhookEventProofSetReady, Payload: "{}"})
+		if err == nil || !strings.Contains(err.Error(), "not allowed") {
+			t.Errorf("%s: err = %v, want the address refused", target, err)
+		}
+	}
+	if hits.Load() != 0 {
+		t.Errorf("server was sent %d requests, want 0", hits.Load())
+	}
+}
+
+func TestWebhookDeliveryDoesNotFollowRedirects(t *testing.T) {
+	previous := webhookIPBlocked
+	webhookIPBlocked = func(ip net.IP) bool { return !ip.IsLoopback() && internalIP(ip) }
+	defer func() { webhookIPBlocked = previous }()
+
+	var redirected atomic.Int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected.Add(1)
+	}))
+	defer internal.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusFound)
+	}))
+	defer server.Close()
+
+	d := &webhookDispatcher{ctx: context.Background()}
+	hook := models.Webhook{URL: server.URL, Secret: "secret"}
+	status, err := d.send(hook, models.WebhookDelivery{Event: webhookEventProofSetReady, Payload: "{}"})
+	if status != http.StatusFound || err == nil {
+		t.Errorf("send = %d, %v; want %d and an error", status, err, http.StatusFound)
+	}
+	if redirected.Load() != 0 {
+		t.Errorf("redirect was followed")
+	}
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/hotvault/backend/internal/models"
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+	wsPongTimeout  = 60 * time.Second
+)
+
+const (
+	WSTypeUploadProgress = "upload.progress"
+	WSTypeChunkedUpload  = "chunked.progress"
+	WSTypeProofSetStatus = "proofset.status"
+)
+
+// WSMessage is the envelope for every message pushed over the WebSocket
+// channel. Type tells the client how to interpret Payload.
+type WSMessage struct {
+	Type    string      `json:"type" example:"upload.progress"`
+	Payload interface{} `json:"payload"`
+}
+
+type ProofSetEvent struct {
+	ProofSetID      string `json:"proofSetId"`
+	TransactionHash string `json:"transactionHash"`
+	Status          string `json:"status"`
+	Error           string `json:"error,omitempty"`
+	ProvingHealth   string `json:"provingHealth"`
+}
+
+var (
+	userSubscribers     = make(map[uint]map[chan WSMessage]struct{})
+	userSubscribersLock sync.Mutex
+)
+
+func subscribeUser(userID uint) (chan WSMessage, func()) {
+	ch := make(chan WSMessage, 64)
+
+	userSubscribersLock.Lock()
+	if userSubscribers[userID] == nil {
+		userSubscribers[userID] = make(map[chan WSMessage]struct{})
+	}
+	userSubscribers[userID][ch] = struct{}{}
+	userSubscribersLock.Unlock()
+
+	return ch, func() {
+		userSubscribersLock.Lock()
+		delete(userSubscribers[userID], ch)
+		if len(userSubscribers[userID]) == 0 {
+			delete(userSubscribers, userID)
+		}
+		userSubscribersLock.Unlock()
+	}
+}
+
+// publishUserEvent delivers a message to every WebSocket the user has open.
+// Messages to a connection that isn't keeping up are dropped; the client
+// gets the full state again when it reconnects.
+func publishUserEvent(userID uint, msgType string, payload interface{}) {
+	if userID == 0 {
+		return
+	}
+
+	msg := WSMessage{Type: msgType, Payload: payload}
+
+	userSubscribersLock.Lock()
+	defer userSubscribersLock.Unlock()
+
+	for ch := range userSubscribers[userID] {
+		select {
+		case ch <- msg:
+		default:
+			log.WithField("userID", userID).WithField("type", msgType).Warning("Dropping WebSocket message for slow client")
+		}
+	}
+}
+
+func publishChunkedUpload(info *ChunkedUploadInfo) {
+	chunkedUploadsMutex.RLock()
+	snapshot := *info
+	chunkedUploadsMutex.RUnlock()
+	snapshot.ChunksReceived = nil
+
+	publishUserEvent(snapshot.UserID, WSTypeChunkedUpload, snapshot)
+}
+
+// WebSocketHandler upgrades the request to a WebSocket that carries status
+// updates for all of the authenticated user's upload jobs, chunked uploads
+// and proof sets. Only connections from allowedOrigins are accepted.
+// @Summary Subscribe to job updates
+// @Description Opens a WebSocket that pushes typed status messages (upload.progress, chunked.progress, proofset.status) for the authenticated user. The latest state of every active job is replayed on connect.
+// @Tags upload
+// @Success 101 {object} WSMessage
+// @Failure 401 {object} ErrorResponse
+// @Router /api/v1/ws [get]
+func WebSocketHandler(allowedOrigins []string) gin.HandlerFunc {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return true
+			}
+			for _, allowed := range allowedOrigins {
+				if origin == allowed {
+					return true
+				}
+			}
+			return false
+		},
+	}
+
+	return func(c *gin.Context) {
+		userID, exists := c.Get("userID")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "User ID not found in token",
+			})
+			return
+		}
+		uid := userID.(uint)
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			log.WithField("userID", uid).WithField("error", err.Error()).Warning("WebSocket upgrade failed")
+			return
+		}
+		defer conn.Close()
+
+		ch, unsubscribe := subscribeUser(uid)
+		defer unsubscribe()
+
+		log.WithField("userID", uid).Info("WebSocket client connected")
+
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+			conn.SetPongHandler(func(string) error {
+				return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+			})
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		writeMessage := func(msg WSMessage) bool {
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(msg); err != nil {
+				log.WithField("userID", uid).WithField("error", err.Error()).Debug("WebSocket write failed")
+				return false
+			}
+			return true
+		}
+
+		for _, msg := range currentUserState(uid) {
+			if !writeMessage(msg) {
+				return
+			}
+		}
+
+		ping := time.NewTicker(wsPingInterval)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-closed:
+				log.WithField("userID", uid).Info("WebSocket client disconnected")
+				return
+			case <-ping.C:
+				conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					return
+				}
+			case msg := <-ch:
+				if !writeMessage(msg) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// currentUserState builds the messages replayed to a client when it
+// connects, so that reconnecting never loses track of an active job.
+func currentUserState(userID uint) []WSMessage {
+	messages := make([]WSMessage, 0)
+
+	if db != nil {
+		var jobs []models.UploadJob
+		if err := db.Where("user_id = ? AND status NOT IN ?", userID, terminalJobStatuses).Order("created_at ASC").Find(&jobs).Error; err != nil {
+			log.WithField("userID", userID).WithField("error", err.Error()).Error("Failed to load active upload jobs")
+		}
+		for _, job := range jobs {
+			progress, _, ok := getUploadJob(job.JobID)
+			if !ok {
+				progress = uploadProgressFromJob(job)
+			}
+			messages = append(messages, WSMessage{Type: WSTypeUploadProgress, Payload: progress})
+		}
+	}
+
+	chunkedUploadsMutex.RLock()
+	for _, info := range chunkedUploads {
+		if info.UserID != userID {
+			continue
+		}
+		snapshot := *info
+		snapshot.ChunksReceived = nil
+		messages = append(messages, WSMessage{Type: WSTypeChunkedUpload, Payload: snapshot})
+	}
+	chunkedUploadsMutex.RUnlock()
+
+	if db != nil {
+		var proofSet models.ProofSet
+		if err := db.Where("user_id = ?", userID).First(&proofSet).Error; err == nil {
+			messages = append(messages, WSMessage{Type: WSTypeProofSetStatus, Payload: proofSetEventFromModel(proofSet)})
+		}
+	}
+
+	return messages
+}
+
+func proofSetEventFromModel(proofSet models.ProofSet) ProofSetEvent {
+	return ProofSetEvent{
+		ProofSetID:      proofSet.ProofSetID,
+		TransactionHash: proofSet.TransactionHash,
+		Status:          proofSet.Status,
+		Error:           proofSet.FailureReason,
+		ProvingHealth:   proofSet.ProvingHealth,
+	}
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireAdmin lets through only requests whose token, checked by JWTAuth,
+// is for one of wallets, which must be lowercase.
+func RequireAdmin(wallets []string) gin.HandlerFunc {
+	admins := make(map[string]bool, len(wallets))
+	for _, wallet := range wallets {
+		admins[wallet] = true
+	}
+	return func(c *gin.Context) {
+		if !admins[strings.ToLower(c.GetString("walletAddress"))] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -22,23 +22,33 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, cfg *config.Config) {
 	handlers.Initialize(db, cfg)
 
 	router.MaxMultipartMemory = 1000 << 20 // 1000 MB
+	if cfg.Upload.MaxSize < router.MaxMultipartMemory {
+		router.MaxMultipartMemory = cfg.Upload.MaxSize
+	}
+
+	allowedOrigins := []string{"http://localhost:3000", "https://hotvault-demo-app.yourdomain.com"}
 
 	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000", "https://hotvault-demo-app.yourdomain.com"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowOrigins:     allowedOrigins,
+		AllowMethods:     []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Range", "If-Range", "If-None-Match", "Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "X-Share-Password"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Type", "Content-Disposition", "Last-Modified", "X-Checksum-SHA256", "Content-Range", "Accept-Ranges", "ETag", "Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Metadata", "Upload-Job-Id", "X-Download-Gateway", "Retry-After", "X-Preview-Content-Type", "X-Preview-Truncated"},
 		AllowCredentials: true,
 		MaxAge:           12 * 60 * 60,
 	}))
 
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	router.GET("/s/:slug", handlers.OpenShareLink)
 
 	authHandler := handlers.NewAuthHandler(db, cfg)
 
 	v1 := router.Group("/api/v1")
 	{
 		v1.GET("/health", handlers.HealthCheck)
+		v1.OPTIONS("/tus", handlers.TusOptions)
+		v1.GET("/dl/:token", handlers.DownloadSignedURL)
+		v1.GET("/estimate", handlers.GetUploadEstimate)
+		v1.GET("/estimate/proof-set", handlers.GetProofSetEstimate)
 
 		auth := v1.Group("/auth")
 		{
@@ -52,8 +62,21 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, cfg *config.Config) {
 		protected.Use(middleware.JWTAuth(cfg.JWT.Secret))
 		{
 			protected.POST("/upload", handlers.UploadFile)
+			protected.POST("/upload/directory", handlers.UploadDirectory)
 			protected.GET("/upload/status/:jobId", handlers.GetUploadStatus)
+			protected.POST("/upload/status/batch", handlers.GetUploadStatusBatch)
+			protected.GET("/upload/limits", handlers.GetUploadLimits)
+			protected.GET("/upload/preflight", handlers.GetUploadPreflight)
+			protected.GET("/upload/jobs", handlers.ListUploadJobs)
+			protected.GET("/upload/events/:jobId", handlers.StreamUploadEvents)
+			protected.DELETE("/upload/:jobId", handlers.CancelUpload)
+			protected.GET("/upload/:jobId/logs", handlers.GetUploadJobLogs)
+			protected.GET("/upload/chunked", handlers.ListChunkedUploads)
+			protected.DELETE("/upload/chunked/:uploadId", handlers.AbortChunkedUpload)
+			protected.GET("/upload/chunked/:uploadId/chunks", handlers.GetMissingChunks)
+			protected.GET("/ws", handlers.WebSocketHandler(allowedOrigins))
 			protected.GET("/download/:cid", handlers.DownloadFile)
+			protected.HEAD("/download/:cid", handlers.DownloadFile)
 
 			chunkedUpload := protected.Group("/chunked-upload")
 			{
@@ -63,13 +86,59 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, cfg *config.Config) {
 				chunkedUpload.GET("/status/:uploadId", handlers.GetChunkedUploadStatus)
 			}
 
+			tus := protected.Group("/tus")
+			{
+				tus.POST("", handlers.CreateTusUpload)
+				tus.HEAD("/:id", handlers.GetTusUploadOffset)
+				tus.PATCH("/:id", handlers.PatchTusUpload)
+			}
+
 			pieces := protected.Group("/pieces")
 			{
 				pieces.GET("", handlers.GetUserPieces)
+				pieces.GET("/search", handlers.SearchPieces)
+				pieces.GET("/export", handlers.ExportPieces)
+				pieces.GET("/duplicates", handlers.GetDuplicatePieces)
+				pieces.POST("/bulk", handlers.BulkUpdatePieces)
+				pieces.GET("/bulk/:jobId", handlers.GetBulkJob)
+				pieces.GET("/verify/:jobId", handlers.GetPieceVerifyJob)
 				pieces.GET("/proof-sets", handlers.GetProofSets)
+				pieces.GET("/proof-sets/:id/archive", handlers.DownloadProofSetArchive)
+				pieces.GET("/proof-sets/archives/:jobId", handlers.DownloadProofSetArchiveJob)
 				pieces.GET("/:id", handlers.GetPieceByID)
+				pieces.PATCH("/:id", handlers.UpdatePiece)
+				pieces.DELETE("/:id", handlers.DeletePiece)
+				pieces.POST("/:id/tags", handlers.AddPieceTags)
+				pieces.DELETE("/:id/tags", handlers.RemovePieceTags)
 				pieces.GET("/cid/:cid", handlers.GetPieceByCID)
 				pieces.GET("/proofs", handlers.GetPieceProofs)
+				pieces.GET("/:id/preview", handlers.PreviewPiece)
+				pieces.GET("/:id/status", handlers.GetPieceStatus)
+				pieces.GET("/:id/events", handlers.ListPieceEvents)
+				pieces.POST("/:id/promote", handlers.PromotePieceVersion)
+				pieces.PUT("/:id/public", handlers.SetPiecePublic)
+				pieces.POST("/:id/pin", handlers.PinPiece)
+				pieces.DELETE("/:id/pin", handlers.UnpinPiece)
+				pieces.POST("/:id/verify", handlers.VerifyPiece)
+				pieces.POST("/:id/download-url", handlers.CreateDownloadURL)
+				pieces.GET("/:id/download-url", handlers.ListDownloadURLs)
+				pieces.DELETE("/:id/download-url/:linkId", handlers.RevokeDownloadURL)
+				pieces.POST("/:id/share", handlers.CreateShareLink)
+				pieces.GET("/:id/share", handlers.ListShareLinks)
+				pieces.DELETE("/:id/share/:linkId", handlers.RevokeShareLink)
+				pieces.POST("/:id/grants", handlers.CreatePieceGrant)
+				pieces.GET("/:id/grants", handlers.ListPieceGrants)
+				pieces.DELETE("/:id/grants/:grantId", handlers.RevokePieceGrant)
+			}
+
+			collections := protected.Group("/collections")
+			{
+				collections.POST("", handlers.CreateCollection)
+				collections.GET("", handlers.ListCollections)
+				collections.GET("/:id", handlers.GetCollection)
+				collections.PATCH("/:id", handlers.UpdateCollection)
+				collections.DELETE("/:id", handlers.DeleteCollection)
+				collections.GET("/:id/pieces", handlers.ListCollectionPieces)
 			}
 
 			proofset := protected.Group("/proofset")
@@ -77,11 +146,55 @@ func SetupRoutes(router *gin.Engine, db *gorm.DB, cfg *config.Config) {
 				proofset.GET("/id", handlers.GetUserProofSetID)
 			}
 
+			proofSets := protected.Group("/proof-sets")
+			{
+				proofSets.GET("/:id", handlers.GetProofSet)
+				proofSets.PATCH("/:id", handlers.UpdateProofSet)
+				proofSets.GET("/:id/status", handlers.GetProofSetStatus)
+				proofSets.DELETE("/:id", handlers.DecommissionProofSet)
+				proofSets.POST("/:id/sync", handlers.SyncProofSet)
+				proofSets.GET("/:id/sync", handlers.GetProofSetSync)
+				proofSets.GET("/:id/proofs", handlers.GetProofHistory)
+				proofSets.GET("/:id/roots", handlers.GetProofSetRoots)
+				proofSets.POST("/:id/transfer", handlers.TransferProofSet)
+			}
+
+			account := protected.Group("/account")
+			{
+				account.GET("/usage/history", handlers.GetUsageHistory)
+				account.GET("/stats", handlers.GetAccountStats)
+			}
+
+			protected.GET("/transactions", handlers.ListTransactions)
+
+			hooks := protected.Group("/webhooks")
+			{
+				hooks.POST("", handlers.CreateWebhook)
+				hooks.GET("", handlers.ListWebhooks)
+				hooks.DELETE("/:id", handlers.DeleteWebhook)
+				hooks.GET("/:id/deliveries", handlers.ListWebhookDeliveries)
+			}
+
+			protected.GET("/storage-providers", handlers.ListEnabledStorageProviders)
+
+			admin := protected.Group("/admin")
+			admin.Use(middleware.RequireAdmin(cfg.AdminWallets))
+			{
+				admin.GET("/storage-providers", handlers.ListStorageProviders)
+				admin.POST("/storage-providers", handlers.CreateStorageProvider)
+				admin.PATCH("/storage-providers/:id", handlers.UpdateStorageProvider)
+				admin.DELETE("/storage-providers/:id", handlers.DeleteStorageProvider)
+			}
+
 			protected.POST("/proof-set/create", authHandler.CreateProofSet)
+			protected.POST("/proof-set/:id/retry", authHandler.RetryProofSet)
+			protected.GET("/proof-set/status", handlers.GetProofSetCreationStatus)
 
 			roots := protected.Group("/roots")
 			{
 				roots.POST("/remove", handlers.RemoveRoot)
+				roots.POST("/remove/batch", handlers.RemoveRootsBatch)
+				roots.GET("/remove/batch/:jobId", handlers.GetBulkJob)
 			}
 		}
 	}
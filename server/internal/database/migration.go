@@ -1,16 +1,191 @@
 package database
 
 import (
+	"fmt"
+
+	"github.com/hotvault/backend/config"
 	"github.com/hotvault/backend/internal/models"
+	applogger "github.com/hotvault/backend/pkg/logger"
 	"gorm.io/gorm"
 )
 
-func MigrateDB(db *gorm.DB) error {
-	return db.AutoMigrate(
+func MigrateDB(db *gorm.DB, cfg *config.Config) error {
+	if err := dedupePieces(db, cfg.Database.DedupeDryRun); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(
 		&models.User{},
 		&models.Wallet{},
 		&models.Transaction{},
 		&models.ProofSet{},
 		&models.Piece{},
-	)
+		&models.UploadJob{},
+		&models.ArchiveEntry{},
+		&models.JobCommandLog{},
+		&models.RootTask{},
+		&models.UsageRecord{},
+		&models.ChunkedUpload{},
+		&models.DownloadLink{},
+		&models.PieceEvent{},
+		&models.Tag{},
+		&models.PieceTag{},
+		&models.Collection{},
+		&models.ShareLink{},
+		&models.PieceGrant{},
+		&models.ProofSetSync{},
+		&models.Webhook{},
+		&models.WebhookDelivery{},
+		&models.ProofRecord{},
+		&models.StorageProvider{},
+	); err != nil {
+		return err
+	}
+	if err := backfillProofSetStatus(db); err != nil {
+		return err
+	}
+	if err := seedDefaultProvider(db, cfg); err != nil {
+		return err
+	}
+	if err := addPieceSearchVector(db); err != nil {
+		return err
+	}
+	return addPieceCIDParts(db)
+}
+
+// backfillProofSetStatus gives proof sets stored before their status tracked
+// creation one inferred from their fields: ready once they have an ID,
+// confirming while a creation transaction is known, and pending otherwise.
+// Those whose decommission was requested become decommissioning.
+//
+// AutoMigrate adds a missing status column with its pending default, so
+// proof sets stored before the column existed read pending by the time this
+// runs. A pending proof set never has an ID or a creation transaction, so
+// those that do are backfilled too.
+func backfillProofSetStatus(db *gorm.DB) error {
+	return db.Exec(`
+		UPDATE proof_sets SET status = CASE
+			WHEN status = 'decommission_requested' THEN 'decommissioning'
+			WHEN proof_set_id <> '' THEN 'ready'
+			WHEN transaction_hash <> '' THEN 'confirming'
+			ELSE 'pending'
+		END
+		WHERE status IS NULL OR status IN ('', 'active', 'decommission_requested')
+			OR (status = 'pending' AND (proof_set_id <> '' OR transaction_hash <> ''))`).Error
+}
+
+// seedDefaultProvider saves the service configured by SERVICE_URL,
+// SERVICE_NAME and RECORD_KEEPER as the storage provider named "default",
+// unless one with that name exists, and assigns it the proof sets created
+// on that service before providers existed.
+func seedDefaultProvider(db *gorm.DB, cfg *config.Config) error {
+	if cfg.ServiceURL == "" || cfg.ServiceName == "" {
+		return nil
+	}
+	provider := models.StorageProvider{
+		Name:         "default",
+		ServiceURL:   cfg.ServiceURL,
+		ServiceName:  cfg.ServiceName,
+		RecordKeeper: cfg.RecordKeeper,
+		Enabled:      true,
+	}
+	if err := db.Where(models.StorageProvider{Name: provider.Name}).
+		Attrs(provider).
+		FirstOrCreate(&provider).Error; err != nil {
+		return err
+	}
+	return db.Model(&models.ProofSet{}).Unscoped().
+		Where("provider_id IS NULL AND service_url = ? AND service_name = ?", provider.ServiceURL, provider.ServiceName).
+		Update("provider_id", provider.ID).Error
+}
+
+// addPieceSearchVector adds the column piece search matches against, with
+// filenames split on punctuation so each word of "q3_report-final.pdf" can
+// be found. Being a generated column, Postgres fills it in for existing rows
+// when it is added and keeps it current on every write.
+func addPieceSearchVector(db *gorm.DB) error {
+	if err := db.Exec(`
+		ALTER TABLE pieces ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('simple', regexp_replace(filename, '[^[:alnum:]]+', ' ', 'g')), 'A') ||
+			setweight(to_tsvector('simple', regexp_replace(coalesce(content_type, ''), '[^[:alnum:]]+', ' ', 'g')), 'D')
+		) STORED`).Error; err != nil {
+		return err
+	}
+	return db.Exec(`CREATE INDEX IF NOT EXISTS idx_pieces_search_vector ON pieces USING GIN (search_vector)`).Error
+}
+
+// addPieceCIDParts splits the base:subroot CID pieces are stored under into
+// generated columns, so a piece can be looked up by its base CID alone
+// without pattern matching on c_id. A piece stored without a subroot has
+// an empty subroot_c_id.
+func addPieceCIDParts(db *gorm.DB) error {
+	for _, stmt := range []string{
+		`ALTER TABLE pieces ADD COLUMN IF NOT EXISTS base_c_id text GENERATED ALWAYS AS (split_part(c_id, ':', 1)) STORED`,
+		`ALTER TABLE pieces ADD COLUMN IF NOT EXISTS subroot_c_id text GENERATED ALWAYS AS (split_part(c_id, ':', 2)) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_pieces_base_c_id ON pieces (base_c_id)`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dedupePieces soft-deletes all but the newest live piece of each user for
+// the same CID, so the unique (user_id, c_id) index can be created over data
+// stored before it existed. Each piece removed is logged with the one kept in
+// its place. With dryRun nothing is changed; the duplicates are only logged,
+// and migration stops while any remain, as the index can't be created over
+// them.
+func dedupePieces(db *gorm.DB, dryRun bool) error {
+	if !db.Migrator().HasTable(&models.Piece{}) {
+		return nil
+	}
+
+	var duplicates []struct {
+		ID       uint
+		UserID   uint
+		CID      string `gorm:"column:c_id"`
+		Filename string
+		KeptID   uint
+	}
+	if err := db.Raw(`
+		SELECT id, user_id, c_id, filename, kept_id FROM (
+			SELECT id, user_id, c_id, filename,
+				FIRST_VALUE(id) OVER (PARTITION BY user_id, c_id ORDER BY created_at DESC, id DESC) AS kept_id,
+				ROW_NUMBER() OVER (PARTITION BY user_id, c_id ORDER BY created_at DESC, id DESC) AS row_num
+			FROM pieces
+			WHERE deleted_at IS NULL
+		) ranked
+		WHERE row_num > 1
+		ORDER BY user_id, c_id, id`).Scan(&duplicates).Error; err != nil {
+		return err
+	}
+	if len(duplicates) == 0 {
+		return nil
+	}
+
+	log := applogger.NewLogger()
+	message := "Removing duplicate piece"
+	if dryRun {
+		message = "Duplicate piece would be removed"
+	}
+	ids := make([]uint, 0, len(duplicates))
+	for _, duplicate := range duplicates {
+		log.WithField("pieceId", duplicate.ID).
+			WithField("userId", duplicate.UserID).
+			WithField("cid", duplicate.CID).
+			WithField("filename", duplicate.Filename).
+			WithField("keptPieceId", duplicate.KeptID).
+			Warning(message)
+		ids = append(ids, duplicate.ID)
+	}
+	if dryRun {
+		return fmt.Errorf("%d duplicate pieces block the unique (user_id, c_id) index; unset DB_DEDUPE_PIECES_DRY_RUN to remove them", len(duplicates))
+	}
+	if err := db.Where("id IN ?", ids).Delete(&models.Piece{}).Error; err != nil {
+		return err
+	}
+	log.WithField("removed", len(ids)).Info("Removed duplicate pieces")
+	return nil
 }
@@ -0,0 +1,246 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hotvault/backend/internal/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// proofSetBeforeStatus is the proof_sets table as it was before proof sets
+// had a status.
+type proofSetBeforeStatus struct {
+	ID              uint `gorm:"primaryKey"`
+	UserID          uint
+	ProofSetID      string
+	TransactionHash string
+	ServiceName     string
+	ServiceURL      string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	DeletedAt       gorm.DeletedAt
+}
+
+func (proofSetBeforeStatus) TableName() string { return "proof_sets" }
+
+// proofSetWithActiveStatus is the proof_sets table once decommissioning
+// added a status that defaulted to active.
+type proofSetWithActiveStatus struct {
+	ID              uint `gorm:"primaryKey"`
+	UserID          uint
+	ProofSetID      string
+	TransactionHash string
+	ServiceName     string
+	ServiceURL      string
+	Status          string `gorm:"not null;default:active"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	DeletedAt       gorm.DeletedAt
+}
+
+func (proofSetWithActiveStatus) TableName() string { return "proof_sets" }
+
+func proofSetStatuses(t *testing.T, db *gorm.DB) map[uint]string {
+	t.Helper()
+	var proofSets []models.ProofSet
+	if err := db.Unscoped().Find(&proofSets).Error; err != nil {
+		t.Fatalf("load proof sets: %v", err)
+	}
+	statuses := make(map[uint]string, len(proofSets))
+	for _, proofSet := range proofSets {
+		statuses[proofSet.ID] = proofSet.Status
+	}
+	return statuses
+}
+
+func TestBackfillProofSetStatusWhenColumnIsAdded(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.AutoMigrate(&proofSetBeforeStatus{}); err != nil {
+		t.Fatalf("create old proof_sets table: %v", err)
+	}
+	old := []proofSetBeforeStatus{
+		{ID: 1, UserID: 1, ProofSetID: "42", TransactionHash: "0xabc"},
+		{ID: 2, UserID: 2, TransactionHash: "0xdef"},
+		{ID: 3, UserID: 3},
+	}
+	if err := db.Create(&old).Error; err != nil {
+		t.Fatalf("seed proof sets: %v", err)
+	}
+
+	if err := db.AutoMigrate(&models.ProofSet{}); err != nil {
+		t.Fatalf("migrate proof sets: %v", err)
+	}
+	if err := backfillProofSetStatus(db); err != nil {
+		t.Fatalf("backfill: %v", err)
+	}
+
+	want := map[uint]string{1: "ready", 2: "confirming", 3: "pending"}
+	got := proofSetStatuses(t, db)
+	for id, status := range want {
+		if got[id] != status {
+			t.Errorf("proof set %d: status = %q, want %q", id, got[id], status)
+		}
+	}
+}
+
+func TestBackfillProofSetStatusFromActiveStatus(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.AutoMigrate(&proofSetWithActiveStatus{}); err != nil {
+		t.Fatalf("create old proof_sets table: %v", err)
+	}
+	old := []proofSetWithActiveStatus{
+		{ID: 1, UserID: 1, ProofSetID: "42", TransactionHash: "0xabc", Status: "active"},
+		{ID: 2, UserID: 2, TransactionHash: "0xdef", Status: "active"},
+		{ID: 3, UserID: 3, ProofSetID: "7", TransactionHash: "0x123", Status: "decommission_requested"},
+		{ID: 4, UserID: 4, Status: "active"},
+	}
+	if err := db.Create(&old).Error; err != nil {
+		t.Fatalf("seed proof sets: %v", err)
+	}
+
+	if err := db.AutoMigrate(&models.ProofSet{}); err != nil {
+		t.Fatalf("migrate proof sets: %v", err)
+	}
+	if err := backfillProofSetStatus(db); err != nil {
+		t.Fatalf("backfill: %v", err)
+	}
+
+	want := map[uint]string{1: "ready", 2: "confirming", 3: "decommissioning", 4: "pending"}
+	got := proofSetStatuses(t, db)
+	for id, status := range want {
+		if got[id] != status {
+			t.Errorf("proof set %d: status = %q, want %q", id, got[id], status)
+		}
+	}
+}
+
+func TestBackfillProofSetStatusKeepsTrackedStatuses(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.AutoMigrate(&models.ProofSet{}); err != nil {
+		t.Fatalf("migrate proof sets: %v", err)
+	}
+	current := []models.ProofSet{
+		{ID: 1, UserID: 1, Status: "pending"},
+		{ID: 2, UserID: 2, Status: "submitting"},
+		{ID: 3, UserID: 3, TransactionHash: "0xdef", Status: "failed", FailureReason: "transaction reverted"},
+		{ID: 4, UserID: 4, ProofSetID: "42", TransactionHash: "0xabc", Status: "decommissioning"},
+		{ID: 5, UserID: 5, ProofSetID: "43", TransactionHash: "0x123", Status: "ready"},
+	}
+	if err := db.Create(&current).Error; err != nil {
+		t.Fatalf("seed proof sets: %v", err)
+	}
+
+	// The backfill runs on every start, so it must leave proof sets whose
+	// status is already tracked alone.
+	for i := 0; i < 2; i++ {
+		if err := backfillProofSetStatus(db); err != nil {
+			t.Fatalf("backfill: %v", err)
+		}
+	}
+
+	got := proofSetStatuses(t, db)
+	for _, proofSet := range current {
+		if got[proofSet.ID] != proofSet.Status {
+			t.Errorf("proof set %d: status = %q, want %q", proofSet.ID, got[proofSet.ID], proofSet.Status)
+		}
+	}
+}
+
+// pieceBeforeUserUnique is the pieces table as it was before piece CIDs
+// were unique per user.
+type pieceBeforeUserUnique struct {
+	ID        uint `gorm:"primaryKey"`
+	UserID    uint
+	CID       string
+	Filename  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt
+}
+
+func (pieceBeforeUserUnique) TableName() string { return "pieces" }
+
+func seedDuplicatePieces(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	if err := db.AutoMigrate(&pieceBeforeUserUnique{}); err != nil {
+		t.Fatalf("create old pieces table: %v", err)
+	}
+	now := time.Now()
+	pieces := []pieceBeforeUserUnique{
+		{ID: 1, UserID: 1, CID: "bagaone", Filename: "a.txt", CreatedAt: now.Add(-2 * time.Hour)},
+		{ID: 2, UserID: 1, CID: "bagaone", Filename: "a-copy.txt", CreatedAt: now.Add(-time.Hour)},
+		{ID: 3, UserID: 2, CID: "bagaone", Filename: "a.txt", CreatedAt: now.Add(-3 * time.Hour)},
+		{ID: 4, UserID: 1, CID: "bagatwo", Filename: "b.txt", CreatedAt: now.Add(-3 * time.Hour)},
+	}
+	if err := db.Create(&pieces).Error; err != nil {
+		t.Fatalf("seed pieces: %v", err)
+	}
+}
+
+func livePieceIDs(t *testing.T, db *gorm.DB) []uint {
+	t.Helper()
+	var ids []uint
+	if err := db.Model(&pieceBeforeUserUnique{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		t.Fatalf("load pieces: %v", err)
+	}
+	return ids
+}
+
+func TestDedupePiecesKeepsNewestPieceOfEachUser(t *testing.T) {
+	db := openTestDB(t)
+	seedDuplicatePieces(t, db)
+
+	if err := dedupePieces(db, false); err != nil {
+		t.Fatalf("dedupe: %v", err)
+	}
+
+	// The other user's piece of the same CID and the user's other CID are
+	// not duplicates.
+	if got, want := livePieceIDs(t, db), []uint{2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("live pieces = %v, want %v", got, want)
+	}
+	if err := db.Exec(`CREATE UNIQUE INDEX idx_pieces_user_cid ON pieces (user_id, c_id) WHERE deleted_at IS NULL`).Error; err != nil {
+		t.Errorf("unique index over deduplicated pieces: %v", err)
+	}
+}
+
+func TestDedupePiecesDryRunChangesNothing(t *testing.T) {
+	db := openTestDB(t)
+	seedDuplicatePieces(t, db)
+
+	if err := dedupePieces(db, true); err == nil {
+		t.Fatal("dry run with duplicates left did not stop migration")
+	}
+	if got, want := livePieceIDs(t, db), []uint{1, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("live pieces = %v, want %v", got, want)
+	}
+
+	// Once there is nothing to remove, a dry run lets migration go on.
+	if err := dedupePieces(db, false); err != nil {
+		t.Fatalf("dedupe: %v", err)
+	}
+	if err := dedupePieces(db, true); err != nil {
+		t.Errorf("dry run without duplicates: %v", err)
+	}
+}
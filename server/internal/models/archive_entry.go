@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// ArchiveEntry is one file inside a piece that was uploaded as a packaged
+// directory. Offset is where the file's data starts within the archive.
+type ArchiveEntry struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	PieceID   uint      `gorm:"index;not null" json:"pieceId"`
+	Path      string    `gorm:"not null" json:"path"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// ChunkedUpload is an upload being sent in chunks. It is kept in the
+// database so uploads can continue, and their chunk directories be cleaned
+// up, after a restart.
+type ChunkedUpload struct {
+	UploadID        string    `gorm:"primaryKey;size:36" json:"uploadId"`
+	UserID          uint      `gorm:"index;not null" json:"userId"`
+	Filename        string    `gorm:"not null" json:"filename"`
+	ChunkSize       int64     `json:"chunkSize"`
+	TotalSize       int64     `json:"totalSize"`
+	TotalChunks     int       `json:"totalChunks"`
+	UploadedChunks  int       `json:"uploadedChunks"`
+	ReceivedChunks  []byte    `json:"-"`                                         // bitmap of received chunk indexes
+	AssembledChunks int       `gorm:"not null;default:0" json:"assembledChunks"` // leading chunks already appended to the assembled file
+	TempDir         string    `gorm:"not null" json:"-"`
+	Status          string    `gorm:"not null" json:"status"`
+	FileType        string    `json:"fileType"`
+	ContentType     string    `json:"contentType"`
+	Compress        bool      `json:"compress"`
+	FileHash        string    `gorm:"size:64" json:"fileHash,omitempty"` // SHA-256 the client expects the assembled file to have
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `gorm:"index" json:"updatedAt"`
+}
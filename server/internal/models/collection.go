@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// Collection groups a user's pieces, like a folder. Collections nest
+// through ParentID, nil for top-level ones.
+type Collection struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"userId"`
+	ParentID  *uint     `gorm:"index" json:"parentId"`
+	Name      string    `gorm:"not null" json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// DownloadLink records a signed download URL handed out for a piece, so it
+// can be revoked and its uses counted. The URL's token carries TokenID;
+// deleting the row revokes it. MaxUses of 0 means unlimited. IssuedBy is
+// the user who created the link, which is the owner unless the piece was
+// shared with them; 0 on links made before it was recorded.
+type DownloadLink struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	TokenID   string    `gorm:"uniqueIndex;not null" json:"id"`
+	PieceID   uint      `gorm:"index;not null" json:"pieceId"`
+	UserID    uint      `gorm:"index;not null" json:"userId"`
+	IssuedBy  uint      `gorm:"index;not null;default:0" json:"issuedBy"`
+	ExpiresAt time.Time `gorm:"not null" json:"expiresAt"`
+	MaxUses   int       `gorm:"not null;default:0" json:"maxUses"`
+	Uses      int       `gorm:"not null;default:0" json:"uses"`
+	CreatedAt time.Time `json:"createdAt"`
+}
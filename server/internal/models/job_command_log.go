@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// JobCommandLog records one pdptool invocation made while processing an
+// upload job. Stdout and Stderr are truncated and have secrets redacted.
+type JobCommandLog struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	JobID      string    `gorm:"index;not null" json:"jobId"`
+	Command    string    `gorm:"type:text" json:"command"`
+	ExitCode   int       `json:"exitCode"`
+	DurationMs int64     `json:"durationMs"`
+	Stdout     string    `gorm:"type:text" json:"stdout"`
+	Stderr     string    `gorm:"type:text" json:"stderr"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
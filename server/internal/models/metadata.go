@@ -0,0 +1,45 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Metadata is a flat set of string fields a user attaches to a piece. It is
+// stored as a JSONB object so pieces can be filtered by containment.
+type Metadata map[string]string
+
+// GormDataType makes AutoMigrate create Metadata columns as jsonb.
+func (Metadata) GormDataType() string {
+	return "jsonb"
+}
+
+// Value implements driver.Valuer. A nil map is stored as NULL.
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner.
+func (m *Metadata) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Metadata", value)
+	}
+	return json.Unmarshal(b, m)
+}
@@ -8,17 +8,39 @@ import (
 
 type Piece struct {
 	ID             uint           `gorm:"primaryKey" json:"id"`
-	UserID         uint           `gorm:"index;not null" json:"userId"`
-	CID            string         `gorm:"not null" json:"cid"`
+	UserID         uint           `gorm:"index;not null;uniqueIndex:idx_pieces_user_cid,priority:1;index:idx_pieces_user_created,priority:1;index:idx_pieces_user_size,priority:1" json:"userId"`
+	CID            string         `gorm:"not null;uniqueIndex:idx_pieces_user_cid,where:deleted_at IS NULL" json:"cid"` // unique per user, so different users can store the same file
+	BaseCID        string         `gorm:"->;-:migration" json:"baseCid"`                                                // CID before the colon, generated by the database from CID
+	SubrootCID     string         `gorm:"->;-:migration" json:"subrootCid"`                                             // CID after the colon, empty when CID has none
 	Filename       string         `gorm:"not null" json:"filename"`
-	Size           int64          `json:"size"`
+	Size           int64          `gorm:"index:idx_pieces_user_size,priority:2" json:"size"`
+	PaddedSize     int64          `json:"paddedSize,omitempty"`            // size stored on the service when the file was zero-padded, 0 otherwise
+	Compressed     bool           `gorm:"default:false" json:"compressed"` // gzipped before upload; Size and Checksum describe the original bytes
+	StoredSize     int64          `json:"storedSize,omitempty"`            // bytes stored on the service after compression and padding, 0 for older pieces
 	ServiceName    string         `gorm:"not null" json:"serviceName"`
 	ServiceURL     string         `gorm:"not null" json:"serviceUrl"`
 	PendingRemoval bool           `gorm:"default:false" json:"pendingRemoval"`
 	RemovalDate    *time.Time     `json:"removalDate"`
-	ProofSetID     *uint          `json:"proofSetId"`
+	RemovalRetries int            `gorm:"not null;default:0" json:"removalRetries,omitempty"` // times the root was found still present after the grace period and its removal sent again
+	ProofSetID     *uint          `gorm:"index" json:"proofSetId"`
 	RootID         *string        `json:"rootId"`
-	CreatedAt      time.Time      `json:"createdAt"`
+	RootStatus     string         `gorm:"index;not null;default:confirmed" json:"rootStatus"` // pending until the root ID is known, then confirmed; unconfirmed when added but its ID wasn't found, or failed
+	ContentType    string         `json:"contentType"`
+	Checksum       *string        `gorm:"size:64" json:"checksum"`                      // SHA-256 of the file, nil for pieces stored before checksums were recorded
+	FileGroupID    string         `gorm:"index" json:"fileGroupId,omitempty"`           // shared by every version of a file, empty when the piece isn't versioned
+	Version        int            `gorm:"not null;default:1" json:"version"`            // 1 for the first upload of a file, N+1 for each re-upload
+	IsCurrent      bool           `gorm:"index;not null;default:true" json:"isCurrent"` // the version listed and served for the file group
+	LastVerifiedAt *time.Time     `json:"lastVerifiedAt,omitempty"`                     // when the file was last checked against Checksum
+	VerifyResult   string         `json:"lastVerifyResult,omitempty"`                   // outcome of the check at LastVerifiedAt
+	Public         bool           `gorm:"not null;default:false" json:"public"`         // other users may download it by CID when public downloads are enabled
+	CollectionID   *uint          `gorm:"index" json:"collectionId"`                    // nil for files outside any collection
+	Metadata       Metadata       `gorm:"index:,type:gin" json:"metadata,omitempty"`    // user-defined string fields, nil when none were set
+	RetainUntil    *time.Time     `gorm:"index" json:"retainUntil,omitempty"`           // removed automatically from then on, nil to keep until deleted
+	Pinned         bool           `gorm:"not null;default:false" json:"pinned"`         // protected from removal until unpinned
+	DownloadCount  int64          `gorm:"not null;default:0" json:"downloadCount"`      // times the file was sent to a client, set along with LastDownloaded
+	LastDownloaded *time.Time     `gorm:"column:last_downloaded_at" json:"lastDownloadedAt,omitempty"`
+	RootMissing    bool           `gorm:"not null;default:false" json:"missingOnService"`
+	CreatedAt      time.Time      `gorm:"index:idx_pieces_user_created,priority:2" json:"createdAt"`
 	UpdatedAt      time.Time      `json:"updatedAt"`
 	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
 	User           User           `gorm:"foreignKey:UserID" json:"user,omitempty"`
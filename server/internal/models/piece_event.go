@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// PieceEvent records something that happened to a piece, such as its upload,
+// its root being added or removed, or a user renaming or downloading it.
+// Rows are only ever added. From and To hold the value before and after,
+// when the change has one.
+type PieceEvent struct {
+	ID      uint `gorm:"primaryKey" json:"id"`
+	PieceID uint `gorm:"index;not null" json:"pieceId"`
+	UserID  uint `gorm:"index;not null" json:"userId"` // owner of the piece
+	// ActorID is the user who caused the event, nil when the server acted
+	// on its own or the piece was reached through a link.
+	ActorID *uint  `json:"actorId,omitempty"`
+	Action  string `gorm:"not null" json:"action"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+	// Detail is context for the event, such as the upload job or link
+	// involved.
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
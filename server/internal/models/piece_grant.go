@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// PieceGrant gives another wallet access to a piece. GranteeWallet is
+// stored lowercased. GranteeID is set once that wallet has signed in, and
+// access is only honoured from then on.
+type PieceGrant struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	PieceID       uint      `gorm:"uniqueIndex:idx_piece_grants_piece_wallet,priority:1;not null" json:"pieceId"`
+	OwnerID       uint      `gorm:"index;not null" json:"ownerId"`
+	GranteeWallet string    `gorm:"uniqueIndex:idx_piece_grants_piece_wallet,priority:2;index;not null" json:"granteeWallet"`
+	GranteeID     *uint     `gorm:"index" json:"granteeId"`
+	Permission    string    `gorm:"not null;default:read" json:"permission"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
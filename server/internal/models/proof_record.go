@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// ProofRecord is a proof set's proving state as its service reported it at
+// one point in time, kept to chart proving over time. A record taken when
+// the service couldn't be reached has Reachable false and no epochs.
+type ProofRecord struct {
+	ID                 uint      `gorm:"primaryKey" json:"id"`
+	ProofSetID         uint      `gorm:"index:idx_proof_records_set_checked;not null" json:"proofSetDbId"`
+	UserID             uint      `gorm:"index;not null" json:"userId"`
+	Reachable          bool      `gorm:"not null" json:"reachable"`
+	NextChallengeEpoch *int64    `json:"nextChallengeEpoch,omitempty"`
+	LastProvenEpoch    *int64    `json:"lastProvenEpoch,omitempty"`
+	LastProofTx        string    `json:"lastProofTx,omitempty"`
+	ChainEpoch         *int64    `json:"chainEpoch,omitempty"` // the chain's epoch at the check, when ETH_RPC_URL could be asked
+	RootCount          int       `json:"rootCount"`
+	Missed             bool      `gorm:"not null;default:false" json:"missed"` // a challenge came due without being proven
+	Error              string    `json:"error,omitempty"`
+	CheckedAt          time.Time `gorm:"index:idx_proof_records_set_checked" json:"checkedAt"`
+}
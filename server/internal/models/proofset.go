@@ -8,11 +8,21 @@ import (
 
 type ProofSet struct {
 	ID              uint           `gorm:"primaryKey" json:"id"`
-	UserID          uint           `gorm:"index;not null" json:"userId"`
+	UserID          uint           `gorm:"index;not null;uniqueIndex:idx_proof_sets_user_name,priority:1" json:"userId"`
 	ProofSetID      string         `gorm:"not null" json:"proofSetId"`
+	Name            string         `gorm:"not null;default:'';uniqueIndex:idx_proof_sets_user_name,priority:2,where:deleted_at IS NULL AND name <> ''" json:"name"` // unique per user when set
+	Description     string         `gorm:"not null;default:''" json:"description"`
 	TransactionHash string         `gorm:"not null" json:"transactionHash"`
 	ServiceName     string         `gorm:"not null" json:"serviceName"`
 	ServiceURL      string         `gorm:"not null" json:"serviceUrl"`
+	ProviderID      *uint          `gorm:"index" json:"providerId"` // storage provider it was created on, nil for proof sets older than providers
+	Status          string         `gorm:"not null;default:pending" json:"status"`
+	FailureReason   string         `json:"failureReason,omitempty"`
+	PollStartedAt   *time.Time     `json:"pollStartedAt,omitempty"` // when polling for the creation transaction's proof set ID began
+	PollAttempts    int            `gorm:"not null;default:0" json:"pollAttempts"`
+	LastPollStatus  string         `json:"lastPollStatus,omitempty"`                      // what get-proof-set-create-status last reported
+	ProvingHealth   string         `gorm:"not null;default:unknown" json:"provingHealth"` // healthy, missed or unknown
+	ProvingHealthAt *time.Time     `json:"provingHealthAt,omitempty"`                     // when ProvingHealth last changed
 	Pieces          []Piece        `gorm:"foreignKey:ProofSetID" json:"pieces,omitempty"`
 	CreatedAt       time.Time      `json:"createdAt"`
 	UpdatedAt       time.Time      `json:"updatedAt"`
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// ProofSetSync is the last result of reconciling a proof set's roots on the
+// service with its pieces. Report holds the details as JSON.
+type ProofSetSync struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	ProofSetID uint      `gorm:"uniqueIndex;not null" json:"proofSetDbId"`
+	UserID     uint      `gorm:"index;not null" json:"userId"`
+	Error      string    `json:"error"` // why the roots couldn't be fetched, empty when the sync ran
+	Report     string    `gorm:"type:text" json:"-"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+)
+
+// RootTask is queued work to add an uploaded piece as a root of a proof set
+// and resolve the root ID the service assigns to it. Tasks are kept in the
+// database so they resume after a restart.
+type RootTask struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	PieceID       uint      `gorm:"uniqueIndex;not null" json:"pieceId"`
+	JobID         string    `gorm:"index" json:"jobId"`
+	UserID        uint      `gorm:"index;not null" json:"userId"`
+	ProofSetID    string    `gorm:"not null" json:"proofSetId"` // service proof set ID
+	CID           string    `gorm:"not null" json:"cid"`        // compound root:subroot CID passed to add-roots
+	BaseCID       string    `gorm:"not null" json:"baseCid"`
+	ServiceURL    string    `json:"serviceUrl"` // service of the proof set, empty for tasks older than storage providers
+	ServiceName   string    `json:"serviceName"`
+	Status        string    `gorm:"index;not null" json:"status"`
+	Attempts      int       `json:"attempts"`
+	PollAttempts  int       `json:"pollAttempts"`
+	LastError     string    `json:"lastError"`
+	NextAttemptAt time.Time `gorm:"index" json:"nextAttemptAt"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
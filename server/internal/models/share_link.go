@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// ShareLink is a public link to one piece, opened at /s/{slug} without
+// signing in. Links without an expiry last until they are revoked, which
+// disables them but keeps their access counts for the owner to see.
+type ShareLink struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	Slug           string     `gorm:"uniqueIndex;size:32;not null" json:"slug"`
+	PieceID        uint       `gorm:"index;not null" json:"pieceId"`
+	UserID         uint       `gorm:"index;not null" json:"userId"`
+	PasswordHash   string     `json:"-"`
+	ExpiresAt      *time.Time `json:"expiresAt"`
+	Enabled        bool       `gorm:"not null;default:true" json:"enabled"`
+	AccessCount    int64      `gorm:"not null;default:0" json:"accessCount"`
+	LastAccessedAt *time.Time `json:"lastAccessedAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// StorageProvider is a PDP service proof sets can be created on. Proof sets
+// keep the service URL and name of the provider they were created on, so
+// changing a provider only affects proof sets created afterwards.
+type StorageProvider struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	Name         string    `gorm:"uniqueIndex;not null" json:"name"`
+	ServiceURL   string    `gorm:"not null" json:"serviceUrl"`
+	ServiceName  string    `gorm:"not null" json:"serviceName"`
+	RecordKeeper string    `gorm:"not null" json:"recordKeeper"`
+	Enabled      bool      `gorm:"not null" json:"enabled"` // new proof sets can be created on it
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// Tag is a label a user gives their pieces. Names are unique per user and
+// stored normalized.
+type Tag struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_tags_user_name,priority:1" json:"userId"`
+	Name      string    `gorm:"not null;uniqueIndex:idx_tags_user_name,priority:2" json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// PieceTag attaches a tag to a piece.
+type PieceTag struct {
+	PieceID   uint      `gorm:"primaryKey" json:"pieceId"`
+	TagID     uint      `gorm:"primaryKey;index" json:"tagId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -8,14 +8,15 @@ import (
 
 type Transaction struct {
 	ID            uint           `gorm:"primaryKey" json:"id"`
-	UserID        uint           `gorm:"not null" json:"userId"`
+	UserID        uint           `gorm:"index;not null" json:"userId"`
 	TxHash        string         `gorm:"uniqueIndex;not null" json:"txHash"`
 	Method        string         `gorm:"not null" json:"method"`
-	Status        string         `gorm:"not null" json:"status"`
+	Status        string         `gorm:"not null" json:"status"` // pending until the transaction settles, then confirmed or failed
 	Value         string         `json:"value"`
 	BlockHash     string         `json:"blockHash"`
 	BlockNumber   uint64         `json:"blockNumber"`
 	WalletAddress string         `gorm:"not null" json:"walletAddress"`
+	ProofSetID    *uint          `gorm:"index" json:"proofSetId,omitempty"` // the proof set the transaction acted on, if any
 	CreatedAt     time.Time      `json:"createdAt"`
 	UpdatedAt     time.Time      `json:"updatedAt"`
 	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+)
+
+type UploadJob struct {
+	ID         uint   `gorm:"primaryKey" json:"id"`
+	JobID      string `gorm:"uniqueIndex;not null" json:"jobId"`
+	UserID     uint   `gorm:"index;not null" json:"userId"`
+	Status     string `gorm:"index;not null" json:"status"`
+	Progress   int    `json:"progress"`
+	Message    string `json:"message"`
+	Error      string `json:"error"`
+	Filename   string `json:"filename"`
+	TotalSize  int64  `json:"totalSize"`
+	CID        string `json:"cid"`
+	BaseCID    string `json:"baseCid"`
+	ProofSetID string `json:"proofSetId"`
+	// Checksum and ContentType describe the stored file, so a piece can be
+	// recreated for it if the server stops before the piece is saved.
+	Checksum    string    `gorm:"size:64" json:"checksum"`
+	ContentType string    `json:"contentType"`
+	Compressed  bool      `json:"compressed"`
+	StoredSize  int64     `json:"storedSize"`
+	Metadata    Metadata  `json:"-"`
+	Files       string    `gorm:"type:text" json:"-"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	// RetainUntil is given to the piece along with Metadata.
+	RetainUntil *time.Time `json:"-"`
+}
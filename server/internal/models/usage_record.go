@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// UsageRecord is one transfer of file data between a user and the server.
+// Direction is "upload" or "download".
+type UsageRecord struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index:idx_usage_user_created;not null" json:"userId"`
+	PieceID   *uint     `gorm:"index" json:"pieceId"`
+	Direction string    `gorm:"not null" json:"direction"`
+	Bytes     int64     `gorm:"not null" json:"bytes"`
+	CreatedAt time.Time `gorm:"index:idx_usage_user_created" json:"createdAt"`
+}
@@ -0,0 +1,88 @@
+package pricing
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/hotvault/backend/config"
+	"github.com/hotvault/backend/pkg/logger"
+)
+
+const (
+	// gasPriceTTL is how long a gas price read from the RPC endpoint is
+	// reused, so estimates don't query it on every request.
+	gasPriceTTL     = time.Minute
+	gasPriceTimeout = 10 * time.Second
+)
+
+// ConfigSource prices storage with the configured rates and the gas price
+// suggested by the Ethereum RPC endpoint, or the configured gas price when
+// there is no endpoint or it can't be reached.
+type ConfigSource struct {
+	cfg    config.PricingConfig
+	rpcURL string
+	logger logger.Logger
+
+	mu        sync.Mutex
+	gasPrice  *big.Int
+	fetchedAt time.Time
+}
+
+func NewConfigSource(cfg config.PricingConfig, rpcURL string) *ConfigSource {
+	return &ConfigSource{
+		cfg:    cfg,
+		rpcURL: rpcURL,
+		logger: logger.NewLogger(),
+	}
+}
+
+func (s *ConfigSource) Rates(ctx context.Context) (Rates, error) {
+	rates := Rates{
+		Source:             config.PricingSourceConfig,
+		GasPrice:           s.cfg.GasPrice,
+		GasPriceFrom:       GasPriceFromConfig,
+		StoragePerTiBMonth: s.cfg.StoragePerTiBMonth,
+		CreateProofSetGas:  s.cfg.CreateProofSetGas,
+		AddRootsGas:        s.cfg.AddRootsGas,
+		ProveGas:           s.cfg.ProveGas,
+		ProvingPeriod:      s.cfg.ProvingPeriod,
+	}
+	if gasPrice := s.suggestedGasPrice(ctx); gasPrice != nil {
+		rates.GasPrice = gasPrice
+		rates.GasPriceFrom = GasPriceFromRPC
+	}
+	return rates, nil
+}
+
+// suggestedGasPrice returns the gas price the RPC endpoint suggests, or nil
+// when there is no endpoint or it couldn't be asked.
+func (s *ConfigSource) suggestedGasPrice(ctx context.Context) *big.Int {
+	if s.rpcURL == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gasPrice != nil && time.Since(s.fetchedAt) < gasPriceTTL {
+		return s.gasPrice
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, gasPriceTimeout)
+	defer cancel()
+	client, err := ethclient.DialContext(ctx, s.rpcURL)
+	if err != nil {
+		s.logger.WithField("error", err.Error()).Warning("Failed to connect to Ethereum RPC for gas price, using PRICING_GAS_PRICE")
+		return nil
+	}
+	defer client.Close()
+	gasPrice, err := client.SuggestGasPrice(ctx)
+	if err != nil {
+		s.logger.WithField("error", err.Error()).Warning("Failed to read gas price, using PRICING_GAS_PRICE")
+		return nil
+	}
+	s.gasPrice = gasPrice
+	s.fetchedAt = time.Now()
+	return gasPrice
+}
@@ -0,0 +1,58 @@
+// Package pricing supplies the rates cost estimates are computed from.
+package pricing
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/hotvault/backend/config"
+)
+
+// Where a gas price came from.
+const (
+	GasPriceFromRPC    = "rpc"
+	GasPriceFromConfig = "config"
+	GasPriceFromNone   = "none"
+)
+
+// Rates are the prices and gas costs of storing data. Amounts are in
+// attoFIL.
+type Rates struct {
+	// Source names the Source the rates came from.
+	Source             string
+	GasPrice           *big.Int
+	GasPriceFrom       string
+	StoragePerTiBMonth *big.Int
+	CreateProofSetGas  int64
+	AddRootsGas        int64
+	ProveGas           int64
+	ProvingPeriod      time.Duration
+}
+
+// Source looks up the current rates. An error means no estimate can be
+// made, so sources fall back to configured values where they can.
+type Source interface {
+	Rates(ctx context.Context) (Rates, error)
+}
+
+// FreeSource prices everything at zero. It is used on networks where
+// storage costs nothing.
+type FreeSource struct{}
+
+func (FreeSource) Rates(ctx context.Context) (Rates, error) {
+	return Rates{
+		Source:             config.PricingSourceFree,
+		GasPrice:           new(big.Int),
+		GasPriceFrom:       GasPriceFromNone,
+		StoragePerTiBMonth: new(big.Int),
+	}, nil
+}
+
+// New returns the source selected by the configuration.
+func New(cfg config.PricingConfig, rpcURL string) Source {
+	if cfg.Source == config.PricingSourceFree {
+		return FreeSource{}
+	}
+	return NewConfigSource(cfg, rpcURL)
+}
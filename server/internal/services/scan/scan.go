@@ -0,0 +1,42 @@
+// Package scan checks uploaded files for malware before they are sent to the
+// storage provider.
+package scan
+
+import (
+	"context"
+	"time"
+
+	"github.com/hotvault/backend/config"
+)
+
+// Result is the verdict for one scanned file.
+type Result struct {
+	Infected bool
+	// Signature names what was found when Infected is true.
+	Signature string
+}
+
+// Scanner scans a file on local disk. An error means the file could not be
+// scanned, not that it is infected.
+type Scanner interface {
+	Scan(ctx context.Context, path string) (Result, error)
+}
+
+// NoopScanner accepts every file. It is used when scanning is disabled.
+type NoopScanner struct{}
+
+func (NoopScanner) Scan(ctx context.Context, path string) (Result, error) {
+	return Result{}, nil
+}
+
+// New returns the scanner selected by the configuration.
+func New(cfg config.ScanConfig) Scanner {
+	if !cfg.Enabled {
+		return NoopScanner{}
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	return NewClamAVScanner(cfg.Address, timeout)
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+// Package diskspace reports free space on the filesystem holding a path.
+package diskspace
+
+import "errors"
+
+// Available is not implemented on this platform, so callers skip their disk
+// space checks.
+func Available(dir string) (int64, error) {
+	return 0, errors.New("disk space checks are not supported on this platform")
+}
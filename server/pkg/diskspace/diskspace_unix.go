@@ -0,0 +1,16 @@
+//go:build unix
+
+// Package diskspace reports free space on the filesystem holding a path.
+package diskspace
+
+import "syscall"
+
+// Available returns the bytes available to unprivileged users on the
+// filesystem holding dir.
+func Available(dir string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}